@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/craigderington/lazytunnel/internal/agent"
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/agentclient"
+)
+
+var version = "dev"
+
+func main() {
+	serverURL := flag.String("server", envOr("LAZYTUNNEL_SERVER", "http://localhost:8080/api/v1"), "Control plane API URL (env LAZYTUNNEL_SERVER)")
+	agentID := flag.String("id", os.Getenv("LAZYTUNNEL_AGENT_ID"), "Agent ID (env LAZYTUNNEL_AGENT_ID, defaults to hostname)")
+	token := flag.String("token", os.Getenv("LAZYTUNNEL_TOKEN"), "API token, instead of logging in (env LAZYTUNNEL_TOKEN)")
+	username := flag.String("user", envOr("LAZYTUNNEL_USER", "admin"), "API username (env LAZYTUNNEL_USER)")
+	password := flag.String("password", envOr("LAZYTUNNEL_PASSWORD", "lazytunnel"), "API password (env LAZYTUNNEL_PASSWORD)")
+	interval := flag.Duration("interval", 5*time.Second, "Reconciliation interval")
+	retryRate := flag.Float64("retry-rate", 10, "Reconnect and restart attempts per second across all tunnels; 0 is unlimited")
+	retryBurst := flag.Int("retry-burst", 20, "Attempts allowed at once before --retry-rate applies")
+	debug := flag.Bool("debug", false, "Debug logging")
+	flag.Parse()
+
+	if *debug {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+	}
+
+	hostname, _ := os.Hostname()
+	id := *agentID
+	if id == "" {
+		id = hostname
+	}
+	if id == "" {
+		log.Fatal().Msg("Agent ID is required when the hostname is unknown; pass --id")
+	}
+
+	client := agentclient.New(*serverURL, *token)
+	if *token == "" {
+		if _, err := client.Login(*username, *password); err != nil {
+			log.Fatal().Err(err).Msg("Failed to authenticate with control plane")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := tunnel.NewManager(ctx)
+	manager.SetNodeAgentID(id)
+	manager.SetRetryBudget(tunnel.RetryBudget{Rate: *retryRate, Burst: *retryBurst})
+	worker := &agent.Worker{
+		ID:       id,
+		Hostname: hostname,
+		Version:  version,
+		Client:   client,
+		Manager:  manager,
+		Logger:   log.Logger,
+		Interval: *interval,
+	}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		cancel()
+	}()
+
+	log.Info().Str("id", id).Str("server", *serverURL).Str("version", version).Msg("Starting lazytunnel agent")
+	if err := worker.Run(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Agent stopped with error")
+	}
+	log.Info().Msg("Agent stopped")
+}
+
+// envOr returns an environment variable, or fallback when it is unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -3,17 +3,25 @@ package main
 import (
 	"context"
 	"flag"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/craigderington/lazytunnel/internal/api"
 	"github.com/craigderington/lazytunnel/internal/config"
+	"github.com/craigderington/lazytunnel/internal/exposure"
+	"github.com/craigderington/lazytunnel/internal/logging"
+	"github.com/craigderington/lazytunnel/internal/sshserver"
 	"github.com/craigderington/lazytunnel/internal/storage"
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
 )
 
 var version = "dev"
@@ -26,30 +34,58 @@ func main() {
 	jwtSecret := flag.String("jwt-secret", "", "JWT secret (overrides config)")
 	tlsCert := flag.String("tls-cert", "", "TLS certificate file")
 	tlsKey := flag.String("tls-key", "", "TLS key file")
+	logLevel := flag.String("log-level", "", "Log level: debug, info, warn, error (overrides config)")
+	logFormat := flag.String("log-format", "", "Log format: console or json (overrides config)")
+	logFilePath := flag.String("log-file", "", "Write logs to this file instead of stderr (overrides config)")
+	logMaxSize := flag.Int("log-max-size", 0, "Rotate the log file once it exceeds this many MB (overrides config)")
+	logRotateInterval := flag.Duration("log-rotate-interval", 0, "Rotate the log file this often, e.g. 24h (overrides config)")
+	logMaxBackups := flag.Int("log-max-backups", 0, "Number of rotated log files to keep (overrides config)")
+	mock := flag.Bool("mock", false, "Simulate SSH servers and destinations instead of connecting to them, for developing the web UI")
 	flag.Parse()
 
 	overrides := map[string]interface{}{
-		"server.addr":      *addr,
-		"database.path":    *dbPath,
-		"auth.jwt_secret":  *jwtSecret,
-		"server.tls_cert":  *tlsCert,
-		"server.tls_key":   *tlsKey,
+		"server.addr":     *addr,
+		"database.path":   *dbPath,
+		"auth.jwt_secret": *jwtSecret,
+		"server.tls_cert": *tlsCert,
+		"server.tls_key":  *tlsKey,
+		"logging.level":   *logLevel,
+		"logging.format":  *logFormat,
+		"logging.file":    *logFilePath,
 	}
 	if *debug {
 		overrides["logging.level"] = "debug"
 	}
+	// Zero means "not set" for the numeric flags
+	if *logMaxSize > 0 {
+		overrides["logging.max_size_mb"] = *logMaxSize
+	}
+	if *logRotateInterval > 0 {
+		overrides["logging.rotate_interval"] = *logRotateInterval
+	}
+	if *logMaxBackups > 0 {
+		overrides["logging.max_backups"] = *logMaxBackups
+	}
+	// Simulated tunnels are kept apart from real ones, and can be broken on
+	// purpose
+	if *mock {
+		if *dbPath == "" {
+			overrides["database.path"] = "tunnels-mock.db"
+		}
+		overrides["chaos.enabled"] = true
+	}
 
 	cfg, err := config.Load(*configPath, overrides)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to load configuration")
 	}
 
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	if cfg.DebugEnabled() {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
-	} else {
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	logFile, err := setupLogging(cfg.Logging)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to configure logging")
+	}
+	if logFile != nil {
+		defer logFile.Close()
 	}
 
 	log.Info().
@@ -69,12 +105,25 @@ func main() {
 	log.Info().Str("db_path", cfg.Database.Path).Msg("Initialized SQLite storage")
 
 	var auth *api.AuthMiddleware
-	if cfg.Auth.JWTSecret != "" {
+	if cfg.Auth.SigningKey != "" {
+		key, err := api.LoadSigningKey(cfg.Auth.SigningKey)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load JWT signing key")
+		}
+		auth, err = api.NewKeyAuthMiddleware(key, cfg.Auth.TokenExpiration)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to configure JWT signing key")
+		}
+		log.Info().Str("key", cfg.Auth.SigningKey).Msg("Authentication enabled with JWT signed by private key")
+	} else if cfg.Auth.JWTSecret != "" {
 		auth = api.NewAuthMiddleware(cfg.Auth.JWTSecret, cfg.Auth.TokenExpiration)
 		log.Info().Msg("Authentication enabled with JWT")
 	} else {
 		log.Warn().Msg("No JWT secret configured - API will run without authentication")
 	}
+	if auth != nil {
+		auth.SetProjects(cfg.Auth.Projects)
+	}
 
 	var tlsConfig *api.TLSConfig
 	if cfg.Server.TLSCert != "" && cfg.Server.TLSKey != "" {
@@ -85,12 +134,160 @@ func main() {
 		log.Info().Str("cert", cfg.Server.TLSCert).Msg("TLS enabled")
 	}
 
+	var router *exposure.Router
+	if cfg.Exposure.Enabled {
+		router, err = exposure.NewRouter(exposure.Config{
+			Domain:       cfg.Exposure.Domain,
+			Addr:         cfg.Exposure.Addr,
+			TLSAddr:      cfg.Exposure.TLSAddr,
+			UpstreamHost: cfg.Exposure.UpstreamHost,
+			ACME:         cfg.Exposure.ACME,
+			ACMEEmail:    cfg.Exposure.ACMEEmail,
+			ACMECacheDir: cfg.Exposure.ACMECacheDir,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to configure public exposure")
+		}
+		if err := router.Start(); err != nil {
+			log.Fatal().Err(err).Msg("Failed to start public exposure router")
+		}
+		log.Info().
+			Str("domain", cfg.Exposure.Domain).
+			Bool("acme", cfg.Exposure.ACME).
+			Msg("Public subdomain exposure enabled")
+	}
+
+	var sshServer *sshserver.Server
+	if cfg.SSHServer.Enabled {
+		sshServer, err = sshserver.NewServer(sshserver.Config{
+			Addr:                cfg.SSHServer.Addr,
+			HostKeyPath:         cfg.SSHServer.HostKey,
+			AuthorizedKeysPath:  cfg.SSHServer.AuthorizedKeys,
+			BindAddress:         cfg.SSHServer.BindAddress,
+			PortRangeStart:      cfg.SSHServer.PortRangeStart,
+			PortRangeEnd:        cfg.SSHServer.PortRangeEnd,
+			MaxForwardsPerAgent: cfg.SSHServer.MaxForwardsPerAgent,
+			Logger:              log.Logger,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to configure SSH server")
+		}
+		if err := sshServer.Start(); err != nil {
+			log.Fatal().Err(err).Msg("Failed to start SSH server")
+		}
+	}
+
+	var rateLimiter *api.RateLimiter
+	if cfg.RateLimit.Enabled {
+		rateLimiter = api.NewRateLimiter(cfg.RateLimit.RequestsPerSecond, cfg.RateLimit.Burst)
+		log.Info().
+			Float64("requests_per_second", cfg.RateLimit.RequestsPerSecond).
+			Int("burst", cfg.RateLimit.Burst).
+			Msg("API rate limiting enabled")
+	}
+
+	var simulator *tunnel.Simulator
+	if *mock {
+		simulator, err = tunnel.NewSimulator(tunnel.SimulatorConfig{})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to start the SSH simulator")
+		}
+		log.Warn().Msg("Mock mode: tunnels connect to simulated SSH servers and destinations, not real ones")
+	}
+
+	if cfg.Chaos.Enabled {
+		log.Warn().Msg("Fault injection enabled: admins can drop and slow down tunnels through the API; don't enable it in production")
+	}
+
 	server := api.NewServer(ctx, api.Config{
-		Addr:    cfg.Server.Addr,
-		Logger:  log.Logger,
-		Storage: store,
-		Auth:    auth,
-		TLS:     tlsConfig,
+		Addr:        cfg.Server.Addr,
+		Logger:      log.Logger,
+		Storage:     store,
+		Auth:        auth,
+		TLS:         tlsConfig,
+		RateLimiter: rateLimiter,
+		Exposure:    router,
+		SSHServer:   sshServer,
+
+		HistoryInterval:  cfg.Metrics.HistoryInterval,
+		HistoryRetention: cfg.Metrics.HistoryRetention,
+
+		HistoryPersistInterval:  cfg.Metrics.PersistInterval,
+		HistoryPersistRetention: cfg.Metrics.PersistRetention,
+
+		SystemMetricsInterval: cfg.Metrics.SystemInterval,
+
+		AllowedOrigins: cfg.Server.CORS.AllowedOrigins,
+		Compression: api.CompressionConfig{
+			Enabled:      cfg.Server.Compression.Enabled,
+			Level:        cfg.Server.Compression.Level,
+			MinSize:      cfg.Server.Compression.MinSize,
+			ExcludePaths: cfg.Server.Compression.Exclude,
+		},
+		CacheRules: cacheRules(cfg.Server.CacheControl),
+		TunnelDefaults: api.TunnelDefaults{
+			AutoReconnect: cfg.Tunnel.DefaultAutoReconnect,
+			KeepAlive:     cfg.Tunnel.DefaultKeepAlive,
+			MaxRetries:    cfg.Tunnel.DefaultMaxRetries,
+			BindAddress:   cfg.Tunnel.DefaultBindAddress,
+			IdleTimeout:   cfg.Tunnel.DefaultIdleTimeout,
+			Match:         matchRules(cfg.Tunnel.Match),
+		},
+		KeyFiles:         cfg.Tunnel.KeyFiles,
+		KeyEncryptionKey: cfg.Keys.EncryptionKey,
+
+		IdempotencyTTL:   cfg.Server.IdempotencyTTL,
+		DeletedRetention: cfg.Tunnel.DeletedRetention,
+		InactiveCleanup: api.InactiveCleanup{
+			Retention: cfg.Tunnel.InactiveRetention,
+			Webhook:   cfg.Tunnel.InactiveWebhook,
+		},
+		FDHeadroom: cfg.Tunnel.FDHeadroom,
+		RequestTimeouts: api.RequestTimeouts{
+			Default: cfg.Server.RequestTimeout,
+			Long:    cfg.Server.LongRequestTimeout,
+		},
+		Quotas: tunnel.Quotas{
+			User:    quotaLimits(cfg.Quotas.User),
+			Project: quotaLimits(cfg.Quotas.Project),
+		},
+		RetryBudget: tunnel.RetryBudget{
+			Rate:  cfg.Tunnel.RetryRate,
+			Burst: cfg.Tunnel.RetryBurst,
+		},
+		DNS: tunnel.DNSConfig{
+			Servers:     cfg.Tunnel.DNS.Servers,
+			Timeout:     cfg.Tunnel.DNS.Timeout,
+			CacheTTL:    cfg.Tunnel.DNS.CacheTTL,
+			NegativeTTL: cfg.Tunnel.DNS.NegativeTTL,
+			StaleTTL:    cfg.Tunnel.DNS.StaleTTL,
+		},
+		CircuitBreaker: tunnel.CircuitBreakerConfig{
+			MaxFailures:     cfg.Tunnel.CircuitBreaker.MaxFailures,
+			Timeout:         cfg.Tunnel.CircuitBreaker.Timeout,
+			RecoveryTimeout: cfg.Tunnel.CircuitBreaker.RecoveryTimeout,
+		},
+		Cluster: api.ClusterConfig{
+			Enabled:    cfg.Cluster.Enabled,
+			InstanceID: instanceID(cfg.Cluster.InstanceID),
+			Address:    cfg.Cluster.Address,
+			LeaseTTL:   cfg.Cluster.LeaseTTL,
+		},
+		Relay: api.RelayConfig{
+			Enabled:      cfg.Relay.Enabled,
+			AllowedHosts: cfg.Relay.AllowedHosts,
+			AllowedPorts: cfg.Relay.AllowedPorts,
+			DialTimeout:  cfg.Relay.DialTimeout,
+			Token:        cfg.Relay.Token,
+		},
+		StatusPage: api.StatusPageConfig{
+			Enabled: cfg.StatusPage.Enabled,
+			Title:   cfg.StatusPage.Title,
+			Tunnels: cfg.StatusPage.Tunnels,
+			Fields:  cfg.StatusPage.Fields,
+		},
+		FaultInjection: cfg.Chaos.Enabled,
+		Simulator:      simulator,
 	})
 
 	go func() {
@@ -123,4 +320,76 @@ func main() {
 	}
 
 	log.Info().Msg("Server stopped gracefully")
-}
\ No newline at end of file
+}
+
+// setupLogging configures the global logger's level, format and destination.
+// It returns the log file when logging to one instead of stderr.
+func setupLogging(cfg config.LoggingConfig) (*logging.RotatingFile, error) {
+	// Level and format were validated by config.Load
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	level, _ := zerolog.ParseLevel(strings.ToLower(cfg.Level))
+	zerolog.SetGlobalLevel(level)
+
+	var out io.Writer = os.Stderr
+	var file *logging.RotatingFile
+	if cfg.File != "" {
+		var err error
+		file, err = logging.NewRotatingFile(cfg.File, int64(cfg.MaxSizeMB)<<20, cfg.RotateInterval, cfg.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out = file
+	}
+
+	if strings.EqualFold(cfg.Format, "console") {
+		out = zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339, NoColor: cfg.File != ""}
+	}
+	log.Logger = zerolog.New(out).With().Timestamp().Logger()
+
+	return file, nil
+}
+
+// cacheRules converts the configured Cache-Control rules for the API server
+func cacheRules(rules []config.CacheControlRule) []api.CacheRule {
+	out := make([]api.CacheRule, 0, len(rules))
+	for _, rule := range rules {
+		out = append(out, api.CacheRule{PathPrefix: rule.Path, Value: rule.Value})
+	}
+	return out
+}
+
+// matchRules converts the configured match rules to the API's
+func matchRules(rules []config.MatchRule) []api.MatchRule {
+	out := make([]api.MatchRule, 0, len(rules))
+	for _, rule := range rules {
+		out = append(out, api.MatchRule{
+			Hosts:      rule.Host,
+			User:       rule.User,
+			Port:       rule.Port,
+			AuthMethod: types.AuthMethod(rule.AuthMethod),
+			KeyID:      rule.KeyID,
+			KeepAlive:  rule.KeepAlive,
+		})
+	}
+	return out
+}
+
+// instanceID returns the configured cluster instance ID, or the hostname
+func instanceID(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return uuid.NewString()
+}
+
+// quotaLimits converts the configured limits of a user or project
+func quotaLimits(limits config.QuotaLimits) tunnel.QuotaLimits {
+	return tunnel.QuotaLimits{
+		MaxTunnels:   limits.MaxTunnels,
+		MaxActive:    limits.MaxActive,
+		MaxBandwidth: limits.MaxBandwidth,
+	}
+}
@@ -0,0 +1,16 @@
+#!/bin/sh
+# Installs the demo's public key for the demo user and runs sshd
+set -e
+
+if [ -z "$LAZYTUNNEL_DEMO_AUTHORIZED_KEY" ]; then
+    echo "LAZYTUNNEL_DEMO_AUTHORIZED_KEY is not set; run the demo with tunnelctl demo" >&2
+    exit 1
+fi
+
+mkdir -p /home/demo/.ssh
+echo "$LAZYTUNNEL_DEMO_AUTHORIZED_KEY" > /home/demo/.ssh/authorized_keys
+chown -R demo:demo /home/demo/.ssh
+chmod 700 /home/demo/.ssh
+chmod 600 /home/demo/.ssh/authorized_keys
+
+exec /usr/sbin/sshd -D -e
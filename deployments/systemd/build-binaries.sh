@@ -5,10 +5,22 @@ set -euo pipefail
 ROOT="$(cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd)"
 cd "$ROOT"
 
+# The server embeds the web UI when it has been built; set NOUI=1 for a
+# headless server
+TAGS=""
+if [[ "${NOUI:-}" == "1" ]]; then
+  TAGS="noui"
+elif command -v npm >/dev/null 2>&1; then
+  echo "Building web UI..."
+  (cd "$ROOT/web" && npm ci && npm run build)
+else
+  echo "npm not found; the server will serve web/dist from disk if present"
+fi
+
 echo "Building lazytunnel server..."
-go build -o "$ROOT/server" ./cmd/server
+go build -tags "$TAGS" -o "$ROOT/server" ./cmd/server
 
 echo "Building lazytunnel agent..."
-go build -o "$ROOT/agent" ./cmd/agent
+go build -o "$ROOT/lazytunnel-agent" ./cmd/lazytunnel-agent
 
-echo "Done: $ROOT/server $ROOT/agent"
\ No newline at end of file
+echo "Done: $ROOT/server $ROOT/lazytunnel-agent"
\ No newline at end of file
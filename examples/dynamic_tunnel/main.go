@@ -6,8 +6,7 @@ import (
 	"log"
 	"time"
 
-	"github.com/craigderington/lazytunnel/internal/tunnel"
-	"github.com/craigderington/lazytunnel/pkg/types"
+	"github.com/craigderington/lazytunnel/pkg/tunnelkit"
 )
 
 // Example demonstrating dynamic SOCKS5 port forwarding
@@ -16,11 +15,11 @@ func main() {
 	ctx := context.Background()
 
 	// Define the SSH hop (bastion host)
-	hop := &types.Hop{
+	hop := &tunnelkit.Hop{
 		Host:       "bastion.example.com",
 		Port:       22,
 		User:       "deploy",
-		AuthMethod: types.AuthMethodKey,
+		AuthMethod: tunnelkit.AuthMethodKey,
 		KeyID:      "/home/user/.ssh/id_rsa",
 	}
 
@@ -28,19 +27,19 @@ func main() {
 	// This will:
 	// 1. Bind port 1080 on local machine as a SOCKS5 proxy
 	// 2. Forward connections dynamically through SSH to any destination
-	spec := &types.TunnelSpec{
+	spec := &tunnelkit.TunnelSpec{
 		ID:            "dynamic-tunnel-example",
 		Name:          "SOCKS5 Proxy",
-		Type:          types.TunnelTypeDynamic,
+		Type:          tunnelkit.TunnelTypeDynamic,
 		LocalPort:     1080, // SOCKS5 proxy port
 		AutoReconnect: true,
 		KeepAlive:     30 * time.Second,
 		MaxRetries:    3,
-		Hops:          []types.Hop{*hop},
+		Hops:          []tunnelkit.Hop{*hop},
 	}
 
 	// Create tunnel manager
-	manager := tunnel.NewManager(ctx)
+	manager := tunnelkit.NewManager(ctx)
 	defer manager.Shutdown()
 
 	fmt.Println("Creating SOCKS5 dynamic tunnel...")
@@ -55,7 +54,7 @@ func main() {
 	fmt.Println("\nUsage examples:")
 	fmt.Println("  curl --socks5 localhost:1080 https://example.com")
 	fmt.Println("  export ALL_PROXY=socks5://localhost:1080")
-	fmt.Println("  ssh -o ProxyCommand='nc -X 5 -x localhost:1080 %h %p' user@internal-host")
+	fmt.Printf("  ssh -o ProxyCommand='nc -X 5 -x localhost:1080 %%h %%p' user@internal-host\n")
 
 	// Get tunnel status
 	t, err := manager.Get(spec.ID)
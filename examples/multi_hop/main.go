@@ -6,8 +6,7 @@ import (
 	"log"
 	"time"
 
-	"github.com/craigderington/lazytunnel/internal/tunnel"
-	"github.com/craigderington/lazytunnel/pkg/types"
+	"github.com/craigderington/lazytunnel/pkg/tunnelkit"
 )
 
 // Example demonstrating multi-hop SSH tunneling
@@ -15,40 +14,40 @@ func main() {
 	ctx := context.Background()
 
 	// Define multiple hops
-	hops := []types.Hop{
+	hops := []tunnelkit.Hop{
 		{
 			Host:       "bastion1.example.com",
 			Port:       22,
 			User:       "deploy",
-			AuthMethod: types.AuthMethodAgent, // Use SSH agent
+			AuthMethod: tunnelkit.AuthMethodAgent, // Use SSH agent
 		},
 		{
 			Host:       "bastion2.internal.example.com",
 			Port:       22,
 			User:       "admin",
-			AuthMethod: types.AuthMethodKey,
+			AuthMethod: tunnelkit.AuthMethodKey,
 			KeyID:      "/home/user/.ssh/internal_key",
 		},
 		{
 			Host:       "private-server.internal.example.com",
 			Port:       22,
 			User:       "app",
-			AuthMethod: types.AuthMethodKey,
+			AuthMethod: tunnelkit.AuthMethodKey,
 			KeyID:      "/home/user/.ssh/app_key",
 		},
 	}
 
 	// Configure the multi-hop session
-	config := tunnel.SessionConfig{
+	config := tunnelkit.SessionConfig{
 		KeepAlive:     30 * time.Second,
 		AutoReconnect: true,
 		MaxRetries:    3,
 		Timeout:       10 * time.Second,
-		BackoffConfig: tunnel.DefaultBackoffConfig(),
+		BackoffConfig: tunnelkit.DefaultBackoffConfig(),
 	}
 
 	// Create multi-hop session
-	session, err := tunnel.NewMultiHopSession(ctx, hops, config)
+	session, err := tunnelkit.NewMultiHopSession(ctx, hops, config)
 	if err != nil {
 		log.Fatalf("Failed to create multi-hop session: %v", err)
 	}
@@ -6,8 +6,7 @@ import (
 	"log"
 	"time"
 
-	"github.com/craigderington/lazytunnel/internal/tunnel"
-	"github.com/craigderington/lazytunnel/pkg/types"
+	"github.com/craigderington/lazytunnel/pkg/tunnelkit"
 )
 
 // Example demonstrating remote SSH port forwarding
@@ -16,11 +15,11 @@ func main() {
 	ctx := context.Background()
 
 	// Define the SSH hop (bastion host)
-	hop := &types.Hop{
+	hop := &tunnelkit.Hop{
 		Host:       "bastion.example.com",
 		Port:       22,
 		User:       "deploy",
-		AuthMethod: types.AuthMethodKey,
+		AuthMethod: tunnelkit.AuthMethodKey,
 		KeyID:      "/home/user/.ssh/id_rsa",
 	}
 
@@ -28,20 +27,20 @@ func main() {
 	// This will:
 	// 1. Bind port 9090 on the remote SSH server
 	// 2. Forward connections to port 8080 on this local machine
-	spec := &types.TunnelSpec{
+	spec := &tunnelkit.TunnelSpec{
 		ID:            "remote-tunnel-example",
 		Name:          "Remote Tunnel Example",
-		Type:          types.TunnelTypeRemote,
+		Type:          tunnelkit.TunnelTypeRemote,
 		LocalPort:     8080, // Local service port
 		RemotePort:    9090, // Remote listening port
 		AutoReconnect: true,
 		KeepAlive:     30 * time.Second,
 		MaxRetries:    3,
-		Hops:          []types.Hop{*hop},
+		Hops:          []tunnelkit.Hop{*hop},
 	}
 
 	// Create tunnel manager
-	manager := tunnel.NewManager(ctx)
+	manager := tunnelkit.NewManager(ctx)
 	defer manager.Shutdown()
 
 	fmt.Println("Creating remote tunnel...")
@@ -58,12 +57,12 @@ func main() {
 	fmt.Println("Traffic will be forwarded to localhost:8080")
 
 	// Get tunnel status
-	tunnel, err := manager.Get(spec.ID)
+	t, err := manager.Get(spec.ID)
 	if err != nil {
 		log.Fatalf("Failed to get tunnel: %v", err)
 	}
 
-	status := tunnel.GetStatus()
+	status := t.GetStatus()
 	fmt.Printf("\nTunnel Status:\n")
 	fmt.Printf("  State: %s\n", status.State)
 	fmt.Printf("  Connected At: %v\n", status.ConnectedAt)
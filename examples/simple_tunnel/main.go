@@ -6,8 +6,7 @@ import (
 	"log"
 	"time"
 
-	"github.com/craigderington/lazytunnel/internal/tunnel"
-	"github.com/craigderington/lazytunnel/pkg/types"
+	"github.com/craigderington/lazytunnel/pkg/tunnelkit"
 )
 
 // Example demonstrating basic SSH tunnel usage
@@ -15,26 +14,26 @@ func main() {
 	ctx := context.Background()
 
 	// Define the SSH hop (bastion host)
-	hop := &types.Hop{
+	hop := &tunnelkit.Hop{
 		Host:       "bastion.example.com",
 		Port:       22,
 		User:       "deploy",
-		AuthMethod: types.AuthMethodKey,
+		AuthMethod: tunnelkit.AuthMethodKey,
 		KeyID:      "/home/user/.ssh/id_rsa",
 	}
 
 	// Configure the session
-	config := tunnel.SessionConfig{
+	config := tunnelkit.SessionConfig{
 		Hop:           hop,
 		KeepAlive:     30 * time.Second,
 		AutoReconnect: true,
 		MaxRetries:    3,
 		Timeout:       10 * time.Second,
-		BackoffConfig: tunnel.DefaultBackoffConfig(),
+		BackoffConfig: tunnelkit.DefaultBackoffConfig(),
 	}
 
 	// Create a new SSH session
-	session, err := tunnel.NewSession(ctx, config)
+	session, err := tunnelkit.NewSession(ctx, config)
 	if err != nil {
 		log.Fatalf("Failed to create session: %v", err)
 	}
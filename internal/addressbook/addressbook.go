@@ -0,0 +1,165 @@
+package addressbook
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+var (
+	// ErrNotFound is returned for an entry name that doesn't exist
+	ErrNotFound = errors.New("address book entry not found")
+	// ErrExists is returned when creating an entry whose name is taken
+	ErrExists = errors.New("address book entry already exists")
+)
+
+// Store keeps address book entries
+type Store interface {
+	// SaveAddress creates the entry or replaces the one with its name
+	SaveAddress(ctx context.Context, entry *types.AddressBookEntry) error
+	// GetAddress returns nil, without an error, for an unknown name
+	GetAddress(ctx context.Context, name string) (*types.AddressBookEntry, error)
+	ListAddresses(ctx context.Context) ([]*types.AddressBookEntry, error)
+	DeleteAddress(ctx context.Context, name string) error
+}
+
+// Book is the server's address book: hosts, and how to log in to them,
+// that tunnel specs reference by name
+type Book struct {
+	store Store
+	mu    sync.Mutex // serializes changes to stored entries
+	now   func() time.Time
+}
+
+// New returns an address book kept in store
+func New(store Store) *Book {
+	return &Book{store: store, now: time.Now}
+}
+
+// List returns the entries, by name
+func (b *Book) List(ctx context.Context) ([]*types.AddressBookEntry, error) {
+	entries, err := b.store.ListAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// Get returns the entry with the name
+func (b *Book) Get(ctx context.Context, name string) (*types.AddressBookEntry, error) {
+	entry, err := b.store.GetAddress(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, ErrNotFound
+	}
+	return entry, nil
+}
+
+// Address returns the entry a tunnel references, so the book can resolve
+// hops and destinations when tunnels connect
+func (b *Book) Address(ctx context.Context, name string) (*types.AddressBookEntry, error) {
+	return b.Get(ctx, name)
+}
+
+// Create adds an entry under a name no other entry has
+func (b *Book) Create(ctx context.Context, entry types.AddressBookEntry) (*types.AddressBookEntry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	existing, err := b.store.GetAddress(ctx, entry.Name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrExists
+	}
+
+	now := b.now().UTC()
+	entry.CreatedAt, entry.UpdatedAt = now, now
+	if err := b.store.SaveAddress(ctx, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Update replaces an entry's host and login defaults, keeping its owner
+func (b *Book) Update(ctx context.Context, entry types.AddressBookEntry) (*types.AddressBookEntry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	existing, err := b.Get(ctx, entry.Name)
+	if err != nil {
+		return nil, err
+	}
+	entry.Owner, entry.CreatedAt = existing.Owner, existing.CreatedAt
+	entry.UpdatedAt = b.now().UTC()
+	if err := b.store.SaveAddress(ctx, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Delete removes an entry
+func (b *Book) Delete(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := b.Get(ctx, name); err != nil {
+		return err
+	}
+	return b.store.DeleteAddress(ctx, name)
+}
+
+// MemoryStore is a Store that keeps entries in memory, for servers without
+// persistent storage
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]types.AddressBookEntry
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]types.AddressBookEntry)}
+}
+
+func (m *MemoryStore) SaveAddress(ctx context.Context, entry *types.AddressBookEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[entry.Name] = *entry
+	return nil
+}
+
+func (m *MemoryStore) GetAddress(ctx context.Context, name string) (*types.AddressBookEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[name]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (m *MemoryStore) ListAddresses(ctx context.Context) ([]*types.AddressBookEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make([]*types.AddressBookEntry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		entry := entry
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+func (m *MemoryStore) DeleteAddress(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, name)
+	return nil
+}
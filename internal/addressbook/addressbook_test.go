@@ -0,0 +1,75 @@
+package addressbook
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestBookCreateAndUpdate(t *testing.T) {
+	ctx := context.Background()
+	book := New(NewMemoryStore())
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	book.now = func() time.Time { return created }
+
+	entry, err := book.Create(ctx, types.AddressBookEntry{Name: "prod-bastion", Host: "10.0.0.1", Port: 22, Owner: "alice"})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if !entry.CreatedAt.Equal(created) || !entry.UpdatedAt.Equal(created) {
+		t.Errorf("Create() = %+v, want it timestamped", entry)
+	}
+	if _, err := book.Create(ctx, types.AddressBookEntry{Name: "prod-bastion", Host: "10.0.0.2"}); !errors.Is(err, ErrExists) {
+		t.Errorf("Create() of a taken name error = %v, want ErrExists", err)
+	}
+
+	// An update replaces the host but keeps who created it and when
+	updated := created.Add(time.Hour)
+	book.now = func() time.Time { return updated }
+	entry, err = book.Update(ctx, types.AddressBookEntry{Name: "prod-bastion", Host: "10.0.0.2", Owner: "bob"})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if entry.Host != "10.0.0.2" || entry.Port != 0 || entry.Owner != "alice" ||
+		!entry.CreatedAt.Equal(created) || !entry.UpdatedAt.Equal(updated) {
+		t.Errorf("Update() = %+v", entry)
+	}
+	if got, _ := book.Address(ctx, "prod-bastion"); got.Host != "10.0.0.2" {
+		t.Errorf("Address() = %+v, want the updated entry", got)
+	}
+
+	if _, err := book.Update(ctx, types.AddressBookEntry{Name: "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update() of an unknown name error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestBookListAndDelete(t *testing.T) {
+	ctx := context.Background()
+	book := New(NewMemoryStore())
+	for _, name := range []string{"web", "bastion", "db"} {
+		if _, err := book.Create(ctx, types.AddressBookEntry{Name: name, Host: name + ".internal"}); err != nil {
+			t.Fatalf("Create(%s) error = %v", name, err)
+		}
+	}
+
+	if err := book.Delete(ctx, "db"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := book.Delete(ctx, "db"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() of a deleted entry error = %v, want ErrNotFound", err)
+	}
+	if _, err := book.Get(ctx, "db"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a deleted entry error = %v, want ErrNotFound", err)
+	}
+
+	entries, err := book.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "bastion" || entries[1].Name != "web" {
+		t.Errorf("List() = %v, want bastion and web by name", entries)
+	}
+}
@@ -34,6 +34,10 @@ func (c *Coordinator) Start(ctx context.Context, tunnelID string) error {
 		return fmt.Errorf("agent %q is offline", spec.AgentID)
 	}
 
+	if err := c.manager.CheckStartQuota(tunnelID); err != nil {
+		return err
+	}
+
 	if c.storage != nil {
 		if err := c.storage.UpdateDesiredStatus(ctx, tunnelID, types.DesiredStatusActive); err != nil {
 			return err
@@ -46,18 +50,25 @@ func (c *Coordinator) Start(ctx context.Context, tunnelID string) error {
 }
 
 func (c *Coordinator) Stop(ctx context.Context, tunnelID string) error {
+	_, err := c.StopWith(ctx, tunnelID, tunnel.StopOptions{})
+	return err
+}
+
+// StopWith stops a tunnel like Stop. Only tunnels run by this server can be
+// force-stopped; agents drain their own connections.
+func (c *Coordinator) StopWith(ctx context.Context, tunnelID string, opts tunnel.StopOptions) (int, error) {
 	t, err := c.manager.Get(tunnelID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if tunnel.IsLocalAgent(t.Spec.AgentID) {
-		return c.manager.Stop(ctx, tunnelID)
+		return c.manager.StopWith(ctx, tunnelID, opts)
 	}
 
 	if c.storage != nil {
 		if err := c.storage.UpdateDesiredStatus(ctx, tunnelID, types.DesiredStatusStopped); err != nil {
-			return err
+			return 0, err
 		}
 	}
 	t.Spec.DesiredStatus = types.DesiredStatusStopped
@@ -66,20 +77,22 @@ func (c *Coordinator) Stop(ctx context.Context, tunnelID string) error {
 		// Tunnel may only exist as delegated placeholder
 		t.UpdateStatus(types.TunnelStateStopped, "")
 	}
-	return nil
+	return 0, nil
 }
 
-// ApplyReports updates in-memory tunnel status from agent reports.
-func (c *Coordinator) ApplyReports(reports []types.AgentStatusReport) {
+// ApplyReports updates in-memory tunnel status from an agent's reports.
+// Reports on tunnels assigned to other agents are ignored.
+func (c *Coordinator) ApplyReports(agentID string, reports []types.AgentStatusReport) {
 	for _, r := range reports {
 		t, err := c.manager.Get(r.TunnelID)
-		if err != nil {
+		if err != nil || t.Spec.AgentID != agentID {
 			continue
 		}
 		state := mapReportStatus(r.Status)
-		t.UpdateStatus(state, r.LastError)
-		if c.storage != nil {
-			_ = c.storage.UpdateStatus(context.Background(), r.TunnelID, r.Status)
+		// The manager persists the transition
+		t.UpdateFailure(state, r.FailureReason, r.LastError)
+		if r.NextRetryAt != nil {
+			t.SetNextRetry(*r.NextRetryAt, r.RetryAttempt, r.MaxRetryAttempts)
 		}
 	}
 }
@@ -95,4 +108,4 @@ func mapReportStatus(s string) types.TunnelState {
 	default:
 		return types.TunnelStateStopped
 	}
-}
\ No newline at end of file
+}
@@ -47,6 +47,15 @@ func (r *Registry) Heartbeat(id string) bool {
 	return true
 }
 
+// SetTunnelCount records how many tunnels an agent last reported on
+func (r *Registry) SetTunnelCount(id string, count int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info, ok := r.agents[id]; ok {
+		info.TunnelCount = count
+	}
+}
+
 func (r *Registry) IsOnline(id string) bool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -84,4 +93,4 @@ func (r *Registry) Get(id string) (*types.AgentInfo, bool) {
 		copy.Status = "offline"
 	}
 	return &copy, true
-}
\ No newline at end of file
+}
@@ -2,6 +2,7 @@ package agent
 
 import (
 	"context"
+	"net/http"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -11,56 +12,117 @@ import (
 	"github.com/craigderington/lazytunnel/pkg/types"
 )
 
+// shutdownReportTimeout bounds the final report sent while stopping
+const shutdownReportTimeout = 5 * time.Second
+
 // Worker reconciles tunnel desired state on a data-plane agent.
 type Worker struct {
 	ID       string
+	Hostname string // reported to the control plane; defaults to ID
+	Version  string
 	Client   *agentclient.Client
 	Manager  *tunnel.Manager
 	Logger   zerolog.Logger
 	Interval time.Duration
+
+	registered bool
+	applied    map[string]time.Time // UpdatedAt of the spec each tunnel was created from
 }
 
+// Run registers with the control plane and reconciles every Interval until
+// ctx is cancelled. The control plane is only ever dialed out to, so agents
+// can run behind NAT; while it can't be reached, assigned tunnels keep
+// running and registration is retried.
 func (w *Worker) Run(ctx context.Context) error {
-	if _, err := w.Client.Register(types.AgentRegisterRequest{
-		ID:       w.ID,
-		Hostname: w.ID,
-		Version:  "dev",
-	}); err != nil {
-		return err
-	}
-	w.Logger.Info().Str("agent_id", w.ID).Msg("Registered with control plane")
+	w.Manager.SetHookReporter(w.logHookResult)
 
 	ticker := time.NewTicker(w.Interval)
 	defer ticker.Stop()
 
 	for {
-		if err := w.reconcile(ctx); err != nil {
-			w.Logger.Warn().Err(err).Msg("Reconcile failed")
+		if err := w.register(); err != nil {
+			w.Logger.Warn().Err(err).Msg("Failed to register with control plane")
+		} else {
+			if err := w.reconcile(ctx); err != nil {
+				w.Logger.Warn().Err(err).Msg("Reconcile failed")
+			}
+			w.heartbeat()
 		}
-		_ = w.Client.Heartbeat(w.ID)
 
 		select {
 		case <-ctx.Done():
-			return w.Manager.Shutdown()
+			return w.shutdown()
 		case <-ticker.C:
 		}
 	}
 }
 
+// register announces the agent unless it already has; the control plane
+// forgets agents when it restarts, which heartbeats detect
+func (w *Worker) register() error {
+	if w.registered {
+		return nil
+	}
+
+	hostname := w.Hostname
+	if hostname == "" {
+		hostname = w.ID
+	}
+	if _, err := w.Client.Register(types.AgentRegisterRequest{
+		ID:       w.ID,
+		Hostname: hostname,
+		Version:  w.Version,
+	}); err != nil {
+		return err
+	}
+	w.registered = true
+	w.Logger.Info().Str("agent_id", w.ID).Msg("Registered with control plane")
+	return nil
+}
+
+func (w *Worker) heartbeat() {
+	err := w.Client.Heartbeat(w.ID)
+	switch {
+	case agentclient.IsStatus(err, http.StatusNotFound):
+		w.Logger.Info().Msg("Control plane no longer knows this agent; registering again")
+		w.registered = false
+	case err != nil:
+		w.Logger.Warn().Err(err).Msg("Heartbeat failed")
+	}
+}
+
 func (w *Worker) reconcile(ctx context.Context) error {
 	assignments, err := w.Client.Assignments(w.ID)
 	if err != nil {
 		return err
 	}
+	// Hops relayed over WebSocket authenticate with the agent's own token,
+	// which logins renew
+	w.Manager.SetRelayToken(w.Client.Token)
+	if w.applied == nil {
+		w.applied = make(map[string]time.Time)
+	}
 
 	reports := make([]types.AgentStatusReport, 0, len(assignments))
+	assigned := make(map[string]bool, len(assignments))
 
 	for _, a := range assignments {
 		spec := a.Spec
+		assigned[spec.ID] = true
 
+		// A changed spec takes a fresh tunnel
 		t, exists := w.managerGet(spec.ID)
+		if exists && !w.applied[spec.ID].Equal(spec.UpdatedAt) {
+			w.Logger.Info().Str("tunnel_id", spec.ID).Msg("Tunnel spec changed; recreating")
+			w.remove(ctx, spec.ID)
+			exists = false
+		}
 		if !exists {
-			_ = w.Manager.Create(ctx, &spec)
+			if err := w.Manager.Create(ctx, &spec); err != nil {
+				w.Logger.Warn().Err(err).Str("tunnel_id", spec.ID).Msg("Failed to create assigned tunnel")
+			} else {
+				w.applied[spec.ID] = spec.UpdatedAt
+			}
 			t, _ = w.managerGet(spec.ID)
 		}
 
@@ -80,28 +142,92 @@ func (w *Worker) reconcile(ctx context.Context) error {
 		}
 
 		if t, err := w.Manager.Get(spec.ID); err == nil {
-			st := t.GetStatus()
-			status := "stopped"
-			errMsg := ""
-			if st != nil {
-				status = string(st.State)
-				if status == "pending" {
-					status = "connecting"
-				}
-				errMsg = st.LastError
-			}
-			reports = append(reports, types.AgentStatusReport{
-				TunnelID:  spec.ID,
-				Status:    status,
-				LastError: errMsg,
-			})
+			reports = append(reports, report(t))
+		}
+	}
+
+	// Deleted or reassigned elsewhere
+	for _, t := range w.Manager.List() {
+		if !assigned[t.Spec.ID] {
+			w.Logger.Info().Str("tunnel_id", t.Spec.ID).Msg("Tunnel no longer assigned; removing")
+			w.remove(ctx, t.Spec.ID)
 		}
 	}
 
 	return w.Client.Report(w.ID, reports)
 }
 
+// remove stops a tunnel and forgets it; the control plane keeps its spec
+func (w *Worker) remove(ctx context.Context, id string) {
+	if err := w.Manager.Purge(ctx, id); err != nil {
+		w.Logger.Warn().Err(err).Str("tunnel_id", id).Msg("Failed to remove tunnel")
+	}
+	delete(w.applied, id)
+}
+
+// shutdown stops every tunnel and tells the control plane they stopped,
+// rather than leaving them shown as active until the agent is deemed offline
+func (w *Worker) shutdown() error {
+	tunnels := w.Manager.List()
+	err := w.Manager.Shutdown()
+	if !w.registered {
+		return err
+	}
+
+	reports := make([]types.AgentStatusReport, 0, len(tunnels))
+	for _, t := range tunnels {
+		reports = append(reports, types.AgentStatusReport{TunnelID: t.Spec.ID, Status: string(types.TunnelStateStopped)})
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- w.Client.Report(w.ID, reports) }()
+	select {
+	case reportErr := <-done:
+		if reportErr != nil {
+			w.Logger.Warn().Err(reportErr).Msg("Failed to report tunnels stopped")
+		}
+	case <-time.After(shutdownReportTimeout):
+		w.Logger.Warn().Msg("Timed out reporting tunnels stopped")
+	}
+	return err
+}
+
+// report describes a tunnel's state to the control plane
+func report(t *tunnel.Tunnel) types.AgentStatusReport {
+	r := types.AgentStatusReport{TunnelID: t.Spec.ID, Status: "stopped"}
+	st := t.GetStatus()
+	if st == nil {
+		return r
+	}
+	r.Status = string(st.State)
+	if r.Status == "pending" {
+		r.Status = "connecting"
+	}
+	r.LastError = st.LastError
+	r.FailureReason = st.FailureReason
+	r.NextRetryAt = st.NextRetryAt
+	r.RetryAttempt = st.RetryAttempt
+	r.MaxRetryAttempts = st.MaxRetryAttempts
+	return r
+}
+
+// logHookResult logs how a hook of an assigned tunnel ran
+func (w *Worker) logHookResult(payload tunnel.HookPayload, hook types.Hook, err error) {
+	event := w.Logger.Debug()
+	if err != nil {
+		event = w.Logger.Warn().Err(err)
+	}
+	if hook.Command != "" {
+		event = event.Str("command", hook.Command)
+	} else {
+		event = event.Str("url", hook.URL)
+	}
+	event.Str("tunnel_id", payload.TunnelID).
+		Str("event", string(payload.Event)).
+		Msg("Ran tunnel hook")
+}
+
 func (w *Worker) managerGet(id string) (*tunnel.Tunnel, bool) {
 	t, err := w.Manager.Get(id)
 	return t, err == nil
-}
\ No newline at end of file
+}
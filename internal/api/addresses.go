@@ -0,0 +1,321 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/internal/addressbook"
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// AddressRequest sets an address book entry's host and the login defaults
+// of hops using it
+type AddressRequest struct {
+	Host        string `json:"host" validate:"required,hostname|ip_addr"`
+	Port        int    `json:"port" validate:"omitempty,min=1,max=65535"` // SSH port; 22 if hops don't set one either
+	User        string `json:"user" validate:"omitempty,max=100"`
+	AuthMethod  string `json:"auth_method" validate:"omitempty,authmethod"`
+	KeyID       string `json:"key_id,omitempty" validate:"omitempty,max=1024"`
+	Description string `json:"description,omitempty" validate:"omitempty,max=500"`
+}
+
+// CreateAddressRequest adds an address book entry
+type CreateAddressRequest struct {
+	Name string `json:"name" validate:"required,addressname"`
+	AddressRequest
+}
+
+// AddressResponse is an address book entry as the API returns it
+type AddressResponse struct {
+	Name        string   `json:"name"`
+	Ref         string   `json:"ref"` // what tunnel specs write to use it, @name
+	Host        string   `json:"host"`
+	Port        int      `json:"port,omitempty"`
+	User        string   `json:"user,omitempty"`
+	AuthMethod  string   `json:"auth_method,omitempty"`
+	KeyID       string   `json:"key_id,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Owner       string   `json:"owner"`
+	CreatedAt   string   `json:"createdAt"`
+	UpdatedAt   string   `json:"updatedAt"`
+	UsedBy      []string `json:"usedBy"` // IDs of the tunnels referencing it
+}
+
+// handleListAddresses lists the address book, by name
+func (s *Server) handleListAddresses(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.addresses.List(r.Context())
+	if err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to list address book")
+		s.InternalError(w, "Failed to list address book")
+		return
+	}
+
+	usedBy := s.addressUsers()
+	response := make([]AddressResponse, len(entries))
+	for i, entry := range entries {
+		response[i] = newAddressResponse(entry, usedBy[entry.Name])
+	}
+	s.respondJSON(w, http.StatusOK, response)
+}
+
+// handleCreateAddress adds an address book entry
+func (s *Server) handleCreateAddress(w http.ResponseWriter, r *http.Request) {
+	var req CreateAddressRequest
+	if !s.decodeAndValidate(w, r, &req) || !s.checkAddressKey(w, r, req.KeyID) {
+		return
+	}
+
+	entry := newAddressEntry(req.Name, req.AddressRequest)
+	entry.Owner = requestOwner(r)
+	created, err := s.addresses.Create(r.Context(), entry)
+	if errors.Is(err, addressbook.ErrExists) {
+		s.ConflictError(w, fmt.Sprintf("Address book entry %q already exists", req.Name))
+		return
+	}
+	if err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to create address book entry")
+		s.InternalError(w, "Failed to create address book entry")
+		return
+	}
+
+	s.requestLogger(r).Info().Str("name", created.Name).Str("host", created.Host).Msg("Address book entry created")
+	s.respondJSON(w, http.StatusCreated, newAddressResponse(created, nil))
+}
+
+// handleGetAddress returns an address book entry
+func (s *Server) handleGetAddress(w http.ResponseWriter, r *http.Request) {
+	entry, err := s.addresses.Get(r.Context(), mux.Vars(r)["name"])
+	if !s.checkAddressResult(w, r, err, "Failed to get address book entry") {
+		return
+	}
+	s.respondJSON(w, http.StatusOK, newAddressResponse(entry, s.addressUsers()[entry.Name]))
+}
+
+// handleUpdateAddress replaces an address book entry. Tunnels using it
+// connect to the new host from their next connection on.
+func (s *Server) handleUpdateAddress(w http.ResponseWriter, r *http.Request) {
+	entry, ok := s.ownedAddress(w, r)
+	if !ok {
+		return
+	}
+	var req AddressRequest
+	if !s.decodeAndValidate(w, r, &req) || !s.checkAddressKey(w, r, req.KeyID) {
+		return
+	}
+	if req.KeyID != entry.KeyID {
+		if strangers := s.keyStrangers(r, entry.Name, req.KeyID); len(strangers) > 0 {
+			s.ConflictError(w, "Address book entry is used by other users' tunnels, which may not use its new key: "+strings.Join(strangers, ", "))
+			return
+		}
+	}
+
+	updated, err := s.addresses.Update(r.Context(), newAddressEntry(entry.Name, req))
+	if !s.checkAddressResult(w, r, err, "Failed to update address book entry") {
+		return
+	}
+
+	s.requestLogger(r).Info().
+		Str("name", updated.Name).
+		Str("host", updated.Host).
+		Str("previous_host", entry.Host).
+		Msg("Address book entry updated")
+	s.respondJSON(w, http.StatusOK, newAddressResponse(updated, s.addressUsers()[updated.Name]))
+}
+
+// handleDeleteAddress deletes an address book entry no tunnel uses
+func (s *Server) handleDeleteAddress(w http.ResponseWriter, r *http.Request) {
+	entry, ok := s.ownedAddress(w, r)
+	if !ok {
+		return
+	}
+	if users := s.addressUsers()[entry.Name]; len(users) > 0 {
+		s.ConflictError(w, "Address book entry is used by tunnels: "+strings.Join(users, ", "))
+		return
+	}
+
+	if err := s.addresses.Delete(r.Context(), entry.Name); !s.checkAddressResult(w, r, err, "Failed to delete address book entry") {
+		return
+	}
+
+	s.requestLogger(r).Info().Str("name", entry.Name).Msg("Address book entry deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkAddressKey responds with a validation error if an entry's key is a
+// managed key the caller may not use, and reports whether it may
+func (s *Server) checkAddressKey(w http.ResponseWriter, r *http.Request, keyID string) bool {
+	if err := s.checkKeyID(r, keyID); err != nil {
+		s.ValidationError(w, "Validation failed", []ValidationError{{Field: "key_id", Code: ValCodeReference, Message: err.Error()}})
+		return false
+	}
+	return true
+}
+
+// ownedAddress looks up the entry a mutating request targets, responding
+// with 404 if there is none and 403 if the caller may not modify it, as
+// ownedKey does for managed keys
+func (s *Server) ownedAddress(w http.ResponseWriter, r *http.Request) (*types.AddressBookEntry, bool) {
+	entry, err := s.addresses.Get(r.Context(), mux.Vars(r)["name"])
+	if !s.checkAddressResult(w, r, err, "Failed to get address book entry") {
+		return nil, false
+	}
+	if s.auth != nil {
+		user, ok := GetUser(r.Context())
+		if !ok || (user.Username != entry.Owner && !user.HasRole("admin")) {
+			s.Forbidden(w, "Only the entry's owner or an admin can modify it")
+			return nil, false
+		}
+	}
+	return entry, true
+}
+
+// checkAddressResult responds to a failed address book call and reports
+// whether it succeeded
+func (s *Server) checkAddressResult(w http.ResponseWriter, r *http.Request, err error, message string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, addressbook.ErrNotFound):
+		s.NotFound(w, "Address book entry")
+	default:
+		s.requestLogger(r).Error().Err(err).Msg(message)
+		s.InternalError(w, message)
+	}
+	return false
+}
+
+// checkAddresses checks that the address book entries a new tunnel's hops
+// and destinations reference exist, and that the caller may use the
+// managed keys the entries lend its hops
+func (s *Server) checkAddresses(r *http.Request, spec *types.TunnelSpec) error {
+	if len(addressRefs(spec)) == 0 {
+		return nil
+	}
+	resolved, err := tunnel.ResolveSpec(r.Context(), s.manager.AddressBook(), spec)
+	if err != nil {
+		return err
+	}
+	return s.checkHopKeys(r, resolved.Hops)
+}
+
+// keyStrangers returns the IDs of the tunnels referencing the entry name
+// whose owners may not use the managed key keyID, which the entry would
+// lend them
+func (s *Server) keyStrangers(r *http.Request, name, keyID string) []string {
+	id, ok := types.ManagedKeyID(keyID)
+	if !ok || s.auth == nil || s.keyring == nil || s.tunnelDefaults.sharesKey(keyID) {
+		return nil
+	}
+	key, err := s.keyring.Get(r.Context(), id)
+	if err != nil {
+		return nil
+	}
+	var strangers []string
+	for _, t := range s.manager.List() {
+		if t.Spec.Owner != key.Owner && slices.Contains(addressRefs(t.Spec), name) {
+			strangers = append(strangers, t.Spec.ID)
+		}
+	}
+	sort.Strings(strangers)
+	return strangers
+}
+
+// agentSpec returns the spec an agent runs: agents have no address book,
+// so the entries the spec references are filled in, and a change to one of
+// them counts as a change to the spec, which makes the agent recreate it
+func (s *Server) agentSpec(r *http.Request, spec *types.TunnelSpec) types.TunnelSpec {
+	names := addressRefs(spec)
+	if len(names) == 0 || s.addresses == nil {
+		return *spec
+	}
+	resolved, err := tunnel.ResolveSpec(r.Context(), s.addresses, spec)
+	if err != nil {
+		s.requestLogger(r).Warn().Err(err).Str("tunnel_id", spec.ID).Msg("Failed to resolve address book entries for agent")
+		return *spec
+	}
+	for _, name := range names {
+		if entry, err := s.addresses.Get(r.Context(), name); err == nil && entry.UpdatedAt.After(resolved.UpdatedAt) {
+			resolved.UpdatedAt = entry.UpdatedAt
+		}
+	}
+	return *resolved
+}
+
+// addressUsers maps address book entry names to the IDs of the tunnels
+// referencing them
+func (s *Server) addressUsers() map[string][]string {
+	users := make(map[string][]string)
+	for _, t := range s.manager.List() {
+		for _, name := range addressRefs(t.Spec) {
+			users[name] = append(users[name], t.Spec.ID)
+		}
+	}
+	for _, ids := range users {
+		sort.Strings(ids)
+	}
+	return users
+}
+
+// addressRefs returns the address book entries a spec references, once each
+func addressRefs(spec *types.TunnelSpec) []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string, ok bool) {
+		if ok && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, hop := range spec.Hops {
+		add(types.AddressRef(hop.Host))
+	}
+	add(types.AddressRef(spec.RemoteHost))
+	for _, target := range spec.Targets {
+		name, _, ok := types.AddressRefPort(target)
+		add(name, ok)
+	}
+	for _, mapping := range spec.Ports {
+		add(types.AddressRef(mapping.RemoteHost))
+	}
+	return names
+}
+
+func newAddressEntry(name string, req AddressRequest) types.AddressBookEntry {
+	return types.AddressBookEntry{
+		Name:        name,
+		Host:        req.Host,
+		Port:        req.Port,
+		User:        req.User,
+		AuthMethod:  types.AuthMethod(req.AuthMethod),
+		KeyID:       req.KeyID,
+		Description: SanitizeString(req.Description),
+	}
+}
+
+func newAddressResponse(entry *types.AddressBookEntry, usedBy []string) AddressResponse {
+	if usedBy == nil {
+		usedBy = []string{}
+	}
+	return AddressResponse{
+		Name:        entry.Name,
+		Ref:         types.AddressRefPrefix + entry.Name,
+		Host:        entry.Host,
+		Port:        entry.Port,
+		User:        entry.User,
+		AuthMethod:  string(entry.AuthMethod),
+		KeyID:       entry.KeyID,
+		Description: entry.Description,
+		Owner:       entry.Owner,
+		CreatedAt:   entry.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:   entry.UpdatedAt.Format(time.RFC3339),
+		UsedBy:      usedBy,
+	}
+}
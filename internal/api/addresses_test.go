@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestAddressBook(t *testing.T) {
+	s := newTestServer(t)
+	withAddressBook(s)
+
+	send := func(handler http.HandlerFunc, method, name, body string) *httptest.ResponseRecorder {
+		return serve(handler, newRequest(method, "/api/v1/addresses/"+name, body, nil, map[string]string{"name": name}))
+	}
+
+	rec := send(s.handleCreateAddress, http.MethodPost, "",
+		`{"name":"prod-bastion","host":"10.0.0.1","port":2222,"user":"ops","auth_method":"agent"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var created AddressResponse
+	decodeJSON(t, rec, &created)
+	if created.Ref != "@prod-bastion" || created.Host != "10.0.0.1" || created.Owner != anonymousOwner {
+		t.Errorf("created entry = %+v", created)
+	}
+
+	if rec := send(s.handleCreateAddress, http.MethodPost, "", `{"name":"prod-bastion","host":"10.0.0.2"}`); rec.Code != http.StatusConflict {
+		t.Errorf("creating a taken name status = %d, want 409", rec.Code)
+	}
+	rec = send(s.handleCreateAddress, http.MethodPost, "", `{"name":"-bad name","host":"10.0.0.2"}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), ValCodeAddressName) {
+		t.Errorf("creating a bad name = %d %s, want %s", rec.Code, rec.Body.String(), ValCodeAddressName)
+	}
+
+	// A tunnel referencing the entry keeps it from being deleted
+	spec := &types.TunnelSpec{ID: "uses-entry", Name: "db", Type: types.TunnelTypeLocal,
+		RemoteHost: "db.internal", RemotePort: 5432, Hops: []types.Hop{{Host: "@prod-bastion"}}}
+	createTunnels(t, s.manager, spec)
+
+	rec = send(s.handleListAddresses, http.MethodGet, "", "")
+	var list []AddressResponse
+	decodeJSON(t, rec, &list)
+	if len(list) != 1 || len(list[0].UsedBy) != 1 || list[0].UsedBy[0] != spec.ID {
+		t.Errorf("listed entries = %+v, want prod-bastion used by %s", list, spec.ID)
+	}
+	if rec := send(s.handleDeleteAddress, http.MethodDelete, "prod-bastion", ""); rec.Code != http.StatusConflict {
+		t.Errorf("deleting an entry in use status = %d, want 409", rec.Code)
+	}
+
+	// Updating the entry moves the tunnel's hop from its next connection on
+	rec = send(s.handleUpdateAddress, http.MethodPut, "prod-bastion", `{"host":"10.0.0.9","user":"ops"}`)
+	var updated AddressResponse
+	decodeJSON(t, rec, &updated)
+	if rec.Code != http.StatusOK || updated.Host != "10.0.0.9" || updated.Port != 0 || updated.CreatedAt != created.CreatedAt {
+		t.Errorf("update = %d %+v", rec.Code, updated)
+	}
+	hops, err := tunnel.ResolveHops(context.Background(), s.manager.AddressBook(), spec.Hops)
+	if err != nil || hops[0].Host != "10.0.0.9" || hops[0].Port != 22 || hops[0].User != "ops" {
+		t.Errorf("ResolveHops() = %+v, %v, want the updated entry", hops, err)
+	}
+
+	if rec := send(s.handleGetAddress, http.MethodGet, "missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("getting an unknown entry status = %d, want 404", rec.Code)
+	}
+
+	s.manager.Delete(context.Background(), spec.ID)
+	if rec := send(s.handleDeleteAddress, http.MethodDelete, "prod-bastion", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCreateTunnelWithAddressRefs(t *testing.T) {
+	s := newTestServer(t)
+	if _, err := withAddressBook(s).Create(context.Background(), types.AddressBookEntry{Name: "bastion", Host: "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	create := func(body string) *httptest.ResponseRecorder { return s.postTunnel(body, nil) }
+
+	// A referenced hop needs neither port, user nor auth method
+	rec := create(`{"name":"a","type":"local","localPort":0,"remoteHost":"@bastion","remotePort":5432,"agentId":"edge-1",
+		"hops":[{"host":"@bastion"}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("create with references status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = create(`{"name":"b","type":"local","remoteHost":"db","remotePort":5432,"agentId":"edge-1",
+		"hops":[{"host":"@missing"}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), ValCodeReference) {
+		t.Errorf("create with an unknown entry = %d %s, want %s", rec.Code, rec.Body.String(), ValCodeReference)
+	}
+}
+
+func TestHopRequestValidation(t *testing.T) {
+	base := func(hop string) CreateTunnelRequest {
+		var req CreateTunnelRequest
+		body := `{"name":"x","type":"local","remoteHost":"db","remotePort":5432,"hops":[` + hop + `]}`
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	if errs := ValidateRequest(base(`{"host":"@bastion"}`)); len(errs) != 0 {
+		t.Errorf("referenced hop errors = %+v, want none", errs)
+	}
+	errs := ValidateRequest(base(`{"host":"bastion"}`))
+	fields := map[string]bool{}
+	for _, e := range errs {
+		if e.Code == ValCodeRequired {
+			fields[e.Field] = true
+		}
+	}
+	if !fields["Port"] || !fields["User"] || !fields["AuthMethod"] {
+		t.Errorf("hop without a reference errors = %+v, want port, user and auth method required", errs)
+	}
+	if errs := ValidateRequest(base(`{"host":"@bad name"}`)); len(errs) != 1 || errs[0].Code != ValCodeHostname {
+		t.Errorf("invalid reference errors = %+v, want %s", errs, ValCodeHostname)
+	}
+
+	req := base(`{"host":"@bastion"}`)
+	req.RemoteHost, req.RemotePort, req.Targets = "", 0, []string{"@web:8080", "web2:8080"}
+	if errs := ValidateRequest(req); len(errs) != 0 {
+		t.Errorf("referenced targets errors = %+v, want none", errs)
+	}
+}
+
+func TestAgentSpecResolvesAddresses(t *testing.T) {
+	s := newTestServer(t)
+	entry, err := withAddressBook(s).Create(context.Background(), types.AddressBookEntry{Name: "bastion", Host: "10.0.0.1", User: "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &types.TunnelSpec{ID: "t1", UpdatedAt: entry.UpdatedAt.Add(-time.Hour),
+		Hops: []types.Hop{{Host: "@bastion", AuthMethod: types.AuthMethodAgent}}}
+
+	got := s.agentSpec(httptest.NewRequest(http.MethodGet, "/", nil), spec)
+	if got.Hops[0].Host != "10.0.0.1" || got.Hops[0].Port != 22 || got.Hops[0].User != "ops" {
+		t.Errorf("agentSpec() hops = %+v, want the entry filled in", got.Hops)
+	}
+	// Agents recreate tunnels whose spec changed, so an entry update counts
+	if !got.UpdatedAt.Equal(entry.UpdatedAt) {
+		t.Errorf("agentSpec() UpdatedAt = %v, want the entry's %v", got.UpdatedAt, entry.UpdatedAt)
+	}
+	if spec.Hops[0].Host != "@bastion" {
+		t.Error("Expected agentSpec to leave the stored spec referencing the entry")
+	}
+}
+
+func TestAddressBookDoesNotLendKeys(t *testing.T) {
+	s := newAuthTestServer(t)
+	withAddressBook(s)
+	key, err := withKeyring(t, s).Generate(context.Background(), "alice's", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := &User{ID: "1", Username: "alice", Roles: []string{"user"}}
+	bob := &User{ID: "2", Username: "bob", Roles: []string{"user"}}
+
+	send := func(handler http.HandlerFunc, method, name, body string, user *User) *httptest.ResponseRecorder {
+		return serve(handler, newRequest(method, "/api/v1/addresses/"+name, body, user, map[string]string{"name": name}))
+	}
+	withKey := `{"host": "10.0.0.1", "user": "ops", "auth_method": "key", "key_id": "` + types.ManagedKeyPrefix + key.ID + `"}`
+	if rec := send(s.handleCreateAddress, http.MethodPost, "", `{"name": "keyed", `+withKey[1:], alice); rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(s.handleCreateAddress, http.MethodPost, "", `{"name": "open", "host": "10.0.0.2", "user": "ops"}`, alice); rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	tunnelBody := func(name, entry string) string {
+		return `{"name": "` + name + `", "type": "dynamic", "agentId": "` + testAgentID + `", "hops": [{"host": "@` + entry + `"}]}`
+	}
+	if rec := s.postTunnel(tunnelBody("bob-socks", "keyed"), bob); rec.Code != http.StatusBadRequest {
+		t.Errorf("bob using alice's key through her entry status = %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+	if rec := s.postTunnel(tunnelBody("alice-socks", "keyed"), alice); rec.Code != http.StatusCreated {
+		t.Errorf("alice using her own entry status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Nor can the entry take the key once bob's tunnel uses it
+	if rec := s.postTunnel(tunnelBody("bob-open", "open"), bob); rec.Code != http.StatusCreated {
+		t.Fatalf("bob using an entry without a key status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(s.handleUpdateAddress, http.MethodPut, "open", withKey, alice); rec.Code != http.StatusConflict {
+		t.Errorf("lending bob's tunnel alice's key status = %d, want 409: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -72,7 +72,7 @@ func (s *Server) handleAgentAssignments(w http.ResponseWriter, r *http.Request)
 			}
 		}
 		assignments = append(assignments, types.AgentAssignment{
-			Spec:           *spec,
+			Spec:           s.agentSpec(r, spec),
 			DesiredStatus:  spec.DesiredStatus,
 			ReportedStatus: reported,
 		})
@@ -83,9 +83,6 @@ func (s *Server) handleAgentAssignments(w http.ResponseWriter, r *http.Request)
 
 func (s *Server) handleAgentReport(w http.ResponseWriter, r *http.Request) {
 	agentID := mux.Vars(r)["id"]
-	if s.agents != nil {
-		s.agents.Heartbeat(agentID)
-	}
 
 	var reports []types.AgentStatusReport
 	if err := json.NewDecoder(r.Body).Decode(&reports); err != nil {
@@ -93,9 +90,13 @@ func (s *Server) handleAgentReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.agents != nil {
+		s.agents.Heartbeat(agentID)
+		s.agents.SetTunnelCount(agentID, len(reports))
+	}
 	if s.coordinator != nil {
-		s.coordinator.ApplyReports(reports)
+		s.coordinator.ApplyReports(agentID, reports)
 	}
 
 	s.respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
-}
\ No newline at end of file
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// resolveAttach checks the tunnel whose connection a spec's first hop
+// reuses, and records its ID and a dependency on it, like resolveVia
+func (s *Server) resolveAttach(spec *types.TunnelSpec) error {
+	if len(spec.Hops) == 0 || spec.Hops[0].Attach == "" {
+		return nil
+	}
+	hop := &spec.Hops[0]
+
+	owner := s.findTunnel(spec.ProjectName(), hop.Attach)
+	if owner == nil {
+		return fmt.Errorf("no tunnel %q to attach to", hop.Attach)
+	}
+	ownerSpec, err := tunnel.ResolveSpec(context.Background(), s.manager.AddressBook(), owner.Spec)
+	if err != nil {
+		return err
+	}
+	hops, err := tunnel.ResolveHops(context.Background(), s.manager.AddressBook(), spec.Hops[:1])
+	if err != nil {
+		return err
+	}
+	if err := tunnel.CanAttach(ownerSpec, hops[0]); err != nil {
+		return err
+	}
+
+	hop.Attach = owner.Spec.ID
+	dependOn(spec, owner.Spec.Name)
+	return nil
+}
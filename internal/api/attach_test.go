@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestCreateTunnelAttach(t *testing.T) {
+	s := newTestServer(t, &types.TunnelSpec{
+		ID: "web-1", Name: "web", Type: types.TunnelTypeLocal, LocalPort: 8080, RemoteHost: "web.internal", RemotePort: 80,
+		Hops: []types.Hop{{Host: "bastion.example.com", Port: 22, User: "ops", AuthMethod: types.AuthMethodAgent}},
+	})
+
+	create := func(name, user string) *httptest.ResponseRecorder {
+		return s.postTunnel(`{"name": "`+name+`", "type": "local", "localPort": 5432, "remoteHost": "db.internal", "remotePort": 5432,
+			"agentId": "edge-1",
+			"hops": [{"host": "bastion.example.com", "port": 22, "user": "`+user+`", "auth_method": "agent", "attach": "web"}]}`, nil)
+	}
+
+	if rec := create("db-root", "root"); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "ops@bastion.example.com") {
+		t.Errorf("Expected attaching as another user to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := create("db", "ops")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the tunnel to be created, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created *types.TunnelSpec
+	for _, tun := range s.manager.List() {
+		if tun.Spec.Name == "db" {
+			created = tun.Spec
+		}
+	}
+	if created == nil {
+		t.Fatal("Expected the tunnel to be stored")
+	}
+	if created.Hops[0].Attach != "web-1" {
+		t.Errorf("Expected the attached tunnel's name to resolve to its ID, got %q", created.Hops[0].Attach)
+	}
+	if len(created.DependsOn) != 1 || created.DependsOn[0] != "web" {
+		t.Errorf("Expected the tunnel to depend on the attached tunnel, got %v", created.DependsOn)
+	}
+}
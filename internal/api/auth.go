@@ -2,13 +2,18 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"crypto"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
 )
 
 // Context keys for storing auth data in request context
@@ -25,6 +30,7 @@ type User struct {
 	Username string   `json:"username"`
 	Email    string   `json:"email"`
 	Roles    []string `json:"roles"`
+	Project  string   `json:"project,omitempty"`
 }
 
 // JWTClaims represents the JWT token claims
@@ -33,6 +39,9 @@ type JWTClaims struct {
 	Username string   `json:"username"`
 	Email    string   `json:"email"`
 	Roles    []string `json:"roles"`
+	Project  string   `json:"project,omitempty"` // empty = the default project
+	// Share limits a share token to one tunnel; see IssueShareToken
+	Share *ShareGrant `json:"share,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -40,6 +49,19 @@ type JWTClaims struct {
 type AuthMiddleware struct {
 	secret          []byte
 	tokenExpiration time.Duration
+
+	// Set by NewKeyAuthMiddleware to sign with a private key instead of secret
+	method     jwt.SigningMethod
+	signingKey crypto.Signer
+	verifyKey  crypto.PublicKey
+	jwk        *JWK
+
+	// Optional record of issued tokens, consulted to reject revoked ones
+	sessions SessionStore
+
+	// Projects of each user or API key, by the user ID of its tokens; see
+	// SetProjects
+	projects map[string][]string
 }
 
 // NewAuthMiddleware creates a new authentication middleware
@@ -50,6 +72,7 @@ func NewAuthMiddleware(secret string, tokenExpiration time.Duration) *AuthMiddle
 	return &AuthMiddleware{
 		secret:          []byte(secret),
 		tokenExpiration: tokenExpiration,
+		method:          jwt.SigningMethodHS256,
 	}
 }
 
@@ -59,45 +82,144 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 		// Extract token from Authorization header
 		tokenString := am.extractToken(r)
 		if tokenString == "" {
-			am.respondError(w, http.StatusUnauthorized, "Missing authorization token")
+			writeAPIError(w, http.StatusUnauthorized, NewAPIError(ErrCodeMissingAuth, "Missing authorization token"))
 			return
 		}
 
 		// Parse and validate token
-		token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-			// Verify signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return am.secret, nil
-		})
+		token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, am.verificationKey)
 
 		if err != nil {
-			am.respondError(w, http.StatusUnauthorized, "Invalid or expired token")
+			writeAPIError(w, http.StatusUnauthorized, tokenError(err))
 			return
 		}
 
 		// Extract claims
 		if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
+			if apiErr := am.checkRevoked(r.Context(), claims); apiErr != nil {
+				status := http.StatusUnauthorized
+				if apiErr.Code == ErrCodeServiceUnavailable {
+					status = http.StatusServiceUnavailable
+				}
+				writeAPIError(w, status, apiErr)
+				return
+			}
+			if claims.Share != nil && !claims.Share.allows(r) {
+				writeAPIError(w, http.StatusForbidden, NewAPIError(ErrCodeForbidden, "A share token only grants access to its tunnel"))
+				return
+			}
+			// Share tokens are issued for their tunnel's project by the server
+			if claims.Share == nil && !am.MemberOf(claims.UserID, claims.Project) {
+				writeAPIError(w, http.StatusForbidden, NewAPIError(ErrCodeForbidden, fmt.Sprintf("Not a member of project %s", claims.Project)))
+				return
+			}
+
 			user := &User{
 				ID:       claims.UserID,
 				Username: claims.Username,
 				Email:    claims.Email,
 				Roles:    claims.Roles,
+				Project:  claims.Project,
 			}
 
+			recordRequestUser(r.Context(), user)
+
 			// Add user and claims to context
 			ctx := context.WithValue(r.Context(), userContextKey, user)
 			ctx = context.WithValue(ctx, claimsContextKey, claims)
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		} else {
-			am.respondError(w, http.StatusUnauthorized, "Invalid token claims")
+			writeAPIError(w, http.StatusUnauthorized, NewAPIError(ErrCodeTokenInvalid, "Invalid token claims"))
 			return
 		}
 	})
 }
 
+// SetSessionStore makes the middleware reject tokens whose session has been
+// revoked. Tokens without a recorded session are still accepted.
+func (am *AuthMiddleware) SetSessionStore(store SessionStore) {
+	am.sessions = store
+}
+
+// SetProjects sets the projects each user or API key belongs to, keyed by
+// the user ID of its tokens. Tokens are rejected unless their project claim
+// is one of their user's projects; users not listed only belong to the
+// default project.
+func (am *AuthMiddleware) SetProjects(projects map[string][]string) {
+	am.projects = projects
+}
+
+// MemberOf reports whether a user or API key belongs to a project; the empty
+// project is the default one
+func (am *AuthMiddleware) MemberOf(userID, project string) bool {
+	if project == "" {
+		project = types.DefaultProject
+	}
+	projects, ok := am.projects[userID]
+	if !ok {
+		return project == types.DefaultProject
+	}
+	return slices.Contains(projects, project)
+}
+
+// checkRevoked returns an error if the token's session has been revoked
+func (am *AuthMiddleware) checkRevoked(ctx context.Context, claims *JWTClaims) *APIError {
+	if am.sessions == nil || claims.ID == "" {
+		return nil
+	}
+	session, err := am.sessions.GetSession(ctx, claims.ID)
+	switch {
+	case err != nil:
+		return NewAPIError(ErrCodeServiceUnavailable, "Unable to verify the session")
+	case session != nil && session.RevokedAt != nil:
+		return NewAPIError(ErrCodeTokenInvalid, "Invalid authentication token").
+			WithDetails(ErrorDetail{Field: "reason", Value: "token revoked"})
+	}
+	return nil
+}
+
+// verificationKey is the jwt.Keyfunc for tokens issued by GenerateToken.
+// Only the configured algorithm is accepted, so a token can't pick a
+// weaker one, e.g. HMAC keyed with the public key.
+func (am *AuthMiddleware) verificationKey(token *jwt.Token) (interface{}, error) {
+	if am.signingKey == nil {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return am.secret, nil
+	}
+	if token.Method.Alg() != am.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return am.verifyKey, nil
+}
+
+// tokenError describes why a token was rejected. Expiry gets its own code
+// so clients know a refresh will help; any other failure won't be fixed by
+// retrying with the same credentials.
+func tokenError(err error) *APIError {
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return NewAPIError(ErrCodeTokenExpired, "Authentication token has expired")
+	}
+
+	var reason string
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		reason = "malformed token"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		reason = "invalid signature"
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		reason = "token not valid yet"
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		reason = "unexpected signing method"
+	default:
+		reason = "token rejected"
+	}
+	return NewAPIError(ErrCodeTokenInvalid, "Invalid authentication token").
+		WithDetails(ErrorDetail{Field: "reason", Value: reason})
+}
+
 // extractToken extracts the JWT token from the Authorization header or ?token= query param.
 // Query param support is required for browser WebSocket connections.
 func (am *AuthMiddleware) extractToken(r *http.Request) string {
@@ -121,29 +243,76 @@ func (am *AuthMiddleware) extractToken(r *http.Request) string {
 
 // GenerateToken generates a new JWT token for a user
 func (am *AuthMiddleware) GenerateToken(userID, username, email string, roles []string) (string, error) {
-	claims := JWTClaims{
+	token, _, err := am.IssueToken(userID, username, email, roles)
+	return token, err
+}
+
+// IssueToken generates a new JWT token for a user and returns it with its
+// claims, whose ID (jti) identifies the token's session
+func (am *AuthMiddleware) IssueToken(userID, username, email string, roles []string) (string, *JWTClaims, error) {
+	return am.IssueProjectToken(userID, username, email, roles, "")
+}
+
+// IssueProjectToken is IssueToken for a user working in a project, which
+// becomes the token's project claim
+func (am *AuthMiddleware) IssueProjectToken(userID, username, email string, roles []string, project string) (string, *JWTClaims, error) {
+	now := time.Now()
+	claims := &JWTClaims{
 		UserID:   userID,
 		Username: username,
 		Email:    email,
 		Roles:    roles,
+		Project:  project,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(am.tokenExpiration)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(am.tokenExpiration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
+	return am.sign(claims)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(am.secret)
+// sign signs claims into a token
+func (am *AuthMiddleware) sign(claims *JWTClaims) (string, *JWTClaims, error) {
+	token := jwt.NewWithClaims(am.method, claims)
+	var signed string
+	var err error
+	if am.signingKey != nil {
+		token.Header["kid"] = am.jwk.KeyID
+		signed, err = token.SignedString(am.signingKey)
+	} else {
+		signed, err = token.SignedString(am.secret)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
 }
 
-// respondError sends a JSON error response
-func (am *AuthMiddleware) respondError(w http.ResponseWriter, status int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{
-		"error": message,
-	})
+// HasRole reports whether the user has the given role
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// requireRole restricts a handler to users with the given role. Without
+// authentication configured there are no users, so it lets every request through.
+func (s *Server) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.auth != nil {
+			user, ok := GetUser(r.Context())
+			if !ok || !user.HasRole(role) {
+				s.Forbidden(w, fmt.Sprintf("This operation requires the %s role", role))
+				return
+			}
+		}
+		next(w, r)
+	}
 }
 
 // GetUser retrieves the authenticated user from the context
@@ -2,9 +2,9 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
-	"strings"
 	"testing"
 	"time"
 
@@ -279,6 +279,7 @@ func TestMiddlewareValidToken(t *testing.T) {
 		setupReq   func(*http.Request)
 		wantNext   bool
 		wantStatus int
+		wantCode   ErrorCode
 	}{
 		{
 			name: "Valid token",
@@ -296,6 +297,7 @@ func TestMiddlewareValidToken(t *testing.T) {
 			},
 			wantNext:   false,
 			wantStatus: http.StatusUnauthorized,
+			wantCode:   ErrCodeMissingAuth,
 		},
 		{
 			name: "Empty Authorization header",
@@ -304,6 +306,7 @@ func TestMiddlewareValidToken(t *testing.T) {
 			},
 			wantNext:   false,
 			wantStatus: http.StatusUnauthorized,
+			wantCode:   ErrCodeMissingAuth,
 		},
 		{
 			name: "Invalid token format",
@@ -312,6 +315,7 @@ func TestMiddlewareValidToken(t *testing.T) {
 			},
 			wantNext:   false,
 			wantStatus: http.StatusUnauthorized,
+			wantCode:   ErrCodeMissingAuth,
 		},
 		{
 			name: "Invalid token",
@@ -320,6 +324,7 @@ func TestMiddlewareValidToken(t *testing.T) {
 			},
 			wantNext:   false,
 			wantStatus: http.StatusUnauthorized,
+			wantCode:   ErrCodeTokenInvalid,
 		},
 		{
 			name: "Tampered token",
@@ -331,6 +336,7 @@ func TestMiddlewareValidToken(t *testing.T) {
 			},
 			wantNext:   false,
 			wantStatus: http.StatusUnauthorized,
+			wantCode:   ErrCodeTokenInvalid,
 		},
 	}
 
@@ -361,6 +367,13 @@ func TestMiddlewareValidToken(t *testing.T) {
 			if !tt.wantNext && contentType != "application/json" {
 				t.Errorf("Content-Type = %s, want application/json", contentType)
 			}
+
+			if !tt.wantNext {
+				var apiErr APIError
+				if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil || apiErr.Code != tt.wantCode {
+					t.Errorf("Error code = %q (%v), want %q", apiErr.Code, err, tt.wantCode)
+				}
+			}
 		})
 	}
 }
@@ -392,16 +405,28 @@ func TestMiddlewareExpiredToken(t *testing.T) {
 		t.Errorf("Status code = %d, want %d", w.Code, http.StatusUnauthorized)
 	}
 
-	body := w.Body.String()
-	if !strings.Contains(body, "Invalid or expired token") {
-		t.Errorf("Response body should contain 'Invalid or expired token', got: %s", body)
+	if apiErr := decodeAPIError(t, w); apiErr.Code != ErrCodeTokenExpired {
+		t.Errorf("Error code = %q, want %q", apiErr.Code, ErrCodeTokenExpired)
+	}
+}
+
+// assertTokenInvalid checks for a TOKEN_INVALID error with the given reason
+func assertTokenInvalid(t *testing.T, w *httptest.ResponseRecorder, reason string) {
+	t.Helper()
+	apiErr := decodeAPIError(t, w)
+	if apiErr.Code != ErrCodeTokenInvalid {
+		t.Errorf("Error code = %q, want %q", apiErr.Code, ErrCodeTokenInvalid)
+	}
+	if len(apiErr.Details) != 1 || apiErr.Details[0].Field != "reason" || apiErr.Details[0].Value != reason {
+		t.Errorf("Details = %+v, want reason %q", apiErr.Details, reason)
 	}
 }
 
 // TestMiddlewareContextValues tests that middleware sets context values correctly
 func TestMiddlewareContextValues(t *testing.T) {
 	am := NewAuthMiddleware("test-secret-key", 1*time.Hour)
-	token, _ := am.GenerateToken("user-456", "contextuser", "context@test.com", []string{"admin", "editor"})
+	am.SetProjects(map[string][]string{"user-456": {"acme"}})
+	token, _, _ := am.IssueProjectToken("user-456", "contextuser", "context@test.com", []string{"admin", "editor"}, "acme")
 
 	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -442,6 +467,10 @@ func TestMiddlewareContextValues(t *testing.T) {
 		t.Errorf("Context user Roles length = %v, want 2", len(contextUser.Roles))
 	}
 
+	if contextUser.Project != "acme" {
+		t.Errorf("Context user Project = %v, want acme", contextUser.Project)
+	}
+
 	if contextClaims == nil {
 		t.Fatal("Context claims is nil")
 	}
@@ -637,6 +666,20 @@ func TestDifferentSigningMethods(t *testing.T) {
 	if w.Code != http.StatusUnauthorized {
 		t.Errorf("Status code = %d, want %d", w.Code, http.StatusUnauthorized)
 	}
+	// The hardcoded token's signature isn't valid base64
+	assertTokenInvalid(t, w, "malformed token")
+
+	// A well-formed token using the "none" algorithm
+	noneToken, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.RegisteredClaims{Subject: "user-123"}).
+		SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+noneToken)
+	w = httptest.NewRecorder()
+	am.Middleware(next).ServeHTTP(w, req)
+	assertTokenInvalid(t, w, "unexpected signing method")
 }
 
 // TestWrongSecret tests tokens signed with a different secret
@@ -666,6 +709,7 @@ func TestWrongSecret(t *testing.T) {
 	if w.Code != http.StatusUnauthorized {
 		t.Errorf("Status code = %d, want %d", w.Code, http.StatusUnauthorized)
 	}
+	assertTokenInvalid(t, w, "invalid signature")
 }
 
 // TestTokenWithClaimsAndExpiry tests complex token scenarios
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// InstallKeyRequest installs a managed key's public key into the
+// authorized_keys of a tunnel's hop, or of the host given
+type InstallKeyRequest struct {
+	TunnelID string `json:"tunnelId,omitempty"`
+	Hop      *int   `json:"hop,omitempty" validate:"omitempty,min=0"` // of the tunnel; default: the first using the key
+
+	// Where to install it without a tunnel, or instead of its hop's
+	Host                string                    `json:"host,omitempty" validate:"required_without=TunnelID,omitempty,hostname|ip"`
+	Port                int                       `json:"port,omitempty" validate:"omitempty,min=1,max=65535"`
+	User                string                    `json:"user,omitempty" validate:"required_without=TunnelID,max=255"`
+	HostKeyVerification types.HostKeyVerification `json:"hostKeyVerification,omitempty" validate:"omitempty,oneof=strict prompt insecure"`
+
+	// Logs in with this password; without one the hop's authentication is
+	// used, or the server's SSH agent when there is no hop or the host, port
+	// or host key verification is overridden
+	Password string `json:"password,omitempty"`
+
+	AuthorizedKeyOptions
+}
+
+// AuthorizedKeyOptions add to the restrictions derived from a tunnel
+type AuthorizedKeyOptions struct {
+	PermitOpen   []string `json:"permitOpen,omitempty"`
+	PermitListen []string `json:"permitListen,omitempty"`
+	From         []string `json:"from,omitempty"`
+}
+
+// InstallKeyResponse reports an installation
+type InstallKeyResponse struct {
+	Host  string `json:"host"`
+	User  string `json:"user"`
+	Line  string `json:"line"`  // the authorized_keys line
+	Added bool   `json:"added"` // false if the key was already there
+}
+
+// handleAuthorizedKeyLine returns a managed key's authorized_keys line as
+// text, restricted to forwarding: with ?tunnel=, to what the tunnel's hop
+// (?hop=, by default the first using the key) forwards to, and to the
+// permitOpen, permitListen and from query values
+func (s *Server) handleAuthorizedKeyLine(w http.ResponseWriter, r *http.Request) {
+	keyring, ok := s.managedKeys(w)
+	if !ok {
+		return
+	}
+	key, ok := s.usableKey(w, r, keyring)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	var hop *int
+	if value := query.Get("hop"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			s.BadRequest(w, "hop must be a non-negative integer")
+			return
+		}
+		hop = &n
+	}
+	extra := AuthorizedKeyOptions{PermitOpen: query["permitOpen"], PermitListen: query["permitListen"], From: query["from"]}
+
+	line, _, ok := s.authorizedKeyLine(w, r, key, query.Get("tunnel"), hop, extra)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, line)
+}
+
+// handleInstallKey adds a managed key's authorized_keys line, restricted
+// as handleAuthorizedKeyLine's, to a host's authorized_keys over SSH
+func (s *Server) handleInstallKey(w http.ResponseWriter, r *http.Request) {
+	keyring, ok := s.managedKeys(w)
+	if !ok {
+		return
+	}
+	key, ok := s.ownedKey(w, r, keyring)
+	if !ok {
+		return
+	}
+	var req InstallKeyRequest
+	if !s.decodeAndValidate(w, r, &req) {
+		return
+	}
+	// Installing logs in as the tunnel owner's hop user
+	if req.TunnelID != "" {
+		if _, ok := s.ownedTunnel(w, r, req.TunnelID); !ok {
+			return
+		}
+	}
+
+	line, target, ok := s.authorizedKeyLine(w, r, key, req.TunnelID, req.Hop, req.AuthorizedKeyOptions)
+	if !ok {
+		return
+	}
+	hop := installHop(target, req)
+	if !s.mayInstallKey(r, req, hop) {
+		s.Forbidden(w, "Installing without a tunnel or with the server's SSH agent requires the admin role")
+		return
+	}
+
+	added, err := tunnel.InstallAuthorizedKey(r.Context(), hop, req.Password, keyring, line)
+	if err != nil {
+		s.requestLogger(r).Warn().Err(err).Str("key_id", key.ID).Str("host", hop.Host).Msg("Failed to install managed key")
+		s.ErrorResponse(w, http.StatusBadGateway, NewAPIError(ErrCodeTunnelConnection, "Failed to install the key").
+			WithDetails(
+				ErrorDetail{Field: "host", Value: hop.Host},
+				ErrorDetail{Field: "reason", Value: err.Error()},
+			))
+		return
+	}
+
+	s.requestLogger(r).Info().
+		Str("key_id", key.ID).
+		Str("host", hop.Host).
+		Str("user", hop.User).
+		Bool("added", added).
+		Msg("Managed key installed")
+	s.respondJSON(w, http.StatusOK, InstallKeyResponse{Host: hop.Host, User: hop.User, Line: line, Added: added})
+}
+
+// installHop returns where and how to log in to install a key: the
+// tunnel's hop (target, nil without a tunnel) with the request's overrides.
+// The hop's credentials only go to the hop, verified as it is, so once the
+// host, port or host key verification is overridden, the request's
+// password or the server's SSH agent is used instead.
+func installHop(target *types.Hop, req InstallKeyRequest) types.Hop {
+	redirected := req.Host != "" || req.Port != 0 || req.HostKeyVerification != ""
+	hop := types.Hop{AuthMethod: types.AuthMethodAgent, HostKeyVerification: types.HostKeyVerifyStrict}
+	if target != nil && !redirected {
+		hop = *target
+	} else if target != nil {
+		hop.Host, hop.Port, hop.User = target.Host, target.Port, target.User
+		hop.HostKeyVerification, hop.KnownHostsPath = target.HostKeyVerification, target.KnownHostsPath
+	}
+
+	if req.Host != "" {
+		hop.Host = req.Host
+	}
+	if req.Port != 0 {
+		hop.Port = req.Port
+	}
+	if hop.Port == 0 {
+		hop.Port = 22
+	}
+	if req.User != "" {
+		hop.User = req.User
+	}
+	if req.HostKeyVerification != "" {
+		hop.HostKeyVerification = req.HostKeyVerification
+	}
+	return hop
+}
+
+// authorizedKeyLine builds a managed key's authorized_keys line, restricted
+// to what a tunnel's hop forwards to if tunnelID is set, and returns the
+// hop. It responds with an error and returns false if it can't.
+func (s *Server) authorizedKeyLine(w http.ResponseWriter, r *http.Request, key *types.ManagedKey, tunnelID string,
+	hop *int, extra AuthorizedKeyOptions) (string, *types.Hop, bool) {
+	var opts tunnel.AuthorizedKeyOptions
+	var target *types.Hop
+	if tunnelID != "" {
+		t, err := s.getTunnel(r, tunnelID)
+		if err != nil {
+			s.TunnelNotFound(w, tunnelID)
+			return "", nil, false
+		}
+		index := 0
+		if hop != nil {
+			index = *hop
+		} else {
+			for i, h := range t.Spec.Hops {
+				if id, ok := types.ManagedKeyID(h.KeyID); ok && id == key.ID {
+					index = i
+					break
+				}
+			}
+		}
+		// Restrict the key to where the address book entries go now
+		spec, err := tunnel.ResolveSpec(r.Context(), s.manager.AddressBook(), t.Spec)
+		if err != nil {
+			s.BadRequest(w, err.Error())
+			return "", nil, false
+		}
+		if opts, err = tunnel.AuthorizedKeyOptionsFor(spec, index); err != nil {
+			s.BadRequest(w, err.Error())
+			return "", nil, false
+		}
+		target = &spec.Hops[index]
+	}
+	opts.PermitOpen = append(opts.PermitOpen, extra.PermitOpen...)
+	opts.PermitListen = append(opts.PermitListen, extra.PermitListen...)
+	opts.From = append(opts.From, extra.From...)
+
+	line, err := tunnel.AuthorizedKeyLine(key.PublicKey, "lazytunnel:"+key.Name, opts)
+	if err != nil {
+		s.BadRequest(w, err.Error())
+		return "", nil, false
+	}
+	return line, target, true
+}
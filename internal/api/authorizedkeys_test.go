@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestAuthorizedKeyLine(t *testing.T) {
+	s := newTestServer(t)
+	key, err := withKeyring(t, s).Generate(context.Background(), "deploy key", anonymousOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &types.TunnelSpec{ID: "db", Name: "db", Type: types.TunnelTypeLocal,
+		RemoteHost: "db.internal", RemotePort: 5432, Hops: []types.Hop{
+			{Host: "bastion", Port: 22, User: "ops", AuthMethod: types.AuthMethodAgent},
+			{Host: "10.0.0.5", Port: 22, User: "ops", AuthMethod: types.AuthMethodKey, KeyID: types.ManagedKeyPrefix + key.ID},
+		}}
+	createTunnels(t, s.manager, spec)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		return serve(s.handleAuthorizedKeyLine, newRequest(http.MethodGet, "/api/v1/keys/"+key.ID+"/authorized_keys"+query, "",
+			nil, map[string]string{"id": key.ID}))
+	}
+
+	// Without a tunnel the key is only kept from terminals and forwarding agents
+	rec := get("")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	want := "no-pty,no-agent-forwarding,no-X11-forwarding,no-user-rc " + key.PublicKey + " lazytunnel:deploy_key\n"
+	if rec.Body.String() != want {
+		t.Errorf("line = %q, want %q", rec.Body.String(), want)
+	}
+
+	// With one, it may only forward to where the hop using it does
+	rec = get("?tunnel=db&from=192.0.2.0/24")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(),
+		`no-pty,no-agent-forwarding,no-X11-forwarding,no-user-rc,permitopen="db.internal:5432",from="192.0.2.0/24" `) {
+		t.Errorf("tunnel line = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := get("?tunnel=db&hop=0"); !strings.Contains(rec.Body.String(), `permitopen="10.0.0.5:22"`) {
+		t.Errorf("first hop line = %q, want it to open the second hop", rec.Body.String())
+	}
+
+	for query, status := range map[string]int{
+		"?tunnel=missing":             http.StatusNotFound,
+		"?tunnel=db&hop=2":            http.StatusBadRequest,
+		"?hop=-1":                     http.StatusBadRequest,
+		`?permitOpen=a:1"+command="x`: http.StatusBadRequest,
+	} {
+		if rec := get(query); rec.Code != status {
+			t.Errorf("%s status = %d, want %d", query, rec.Code, status)
+		}
+	}
+}
+
+func TestInstallKey(t *testing.T) {
+	s := newTestServer(t)
+	key, err := withKeyring(t, s).Generate(context.Background(), "deploy", anonymousOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	install := func(body string) *httptest.ResponseRecorder {
+		return serve(s.handleInstallKey, newRequest(http.MethodPost, "/api/v1/keys/"+key.ID+"/install", body,
+			nil, map[string]string{"id": key.ID}))
+	}
+
+	if rec := install(`{"password":"x"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("install without a tunnel or host status = %d, want 400", rec.Code)
+	}
+
+	// Nothing listens here any more
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	rec := install(`{"host":"127.0.0.1","port":` + strconv.Itoa(port) + `,"user":"ops","password":"x","hostKeyVerification":"insecure"}`)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("install to an unreachable host status = %d, want 502: %s", rec.Code, rec.Body.String())
+	}
+	if apiErr := decodeAPIError(t, rec); apiErr.Code != ErrCodeTunnelConnection {
+		t.Errorf("error = %+v", apiErr)
+	}
+}
+
+func TestInstallKeyIntoAnotherUsersTunnel(t *testing.T) {
+	s := newAuthTestServer(t, &types.TunnelSpec{ID: "bob-db", Name: "bob-db", Owner: "bob", Type: types.TunnelTypeLocal,
+		Hops: []types.Hop{{Host: "bastion", Port: 22, User: "bob", AuthMethod: types.AuthMethodKey, KeyID: "/home/bob/.ssh/id_ed25519"}}})
+	key, err := withKeyring(t, s).Generate(context.Background(), "mallory", "mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(s.handleInstallKey, newRequest(http.MethodPost, "/api/v1/keys/"+key.ID+"/install", `{"tunnelId":"bob-db"}`,
+		&User{ID: "3", Username: "mallory", Roles: []string{"user"}}, map[string]string{"id": key.ID}))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("install into another user's hop status = %d, want 403", rec.Code)
+	}
+}
+
+func TestInstallKeyAsTheServerRequiresAdmin(t *testing.T) {
+	// Nothing listens here any more, so allowed installs fail to connect
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	hop := func(auth types.AuthMethod, keyID string) []types.Hop {
+		return []types.Hop{{Host: "127.0.0.1", Port: port, User: "alice", AuthMethod: auth, KeyID: keyID,
+			HostKeyVerification: types.HostKeyVerifyInsecure}}
+	}
+	s := newAuthTestServer(t,
+		&types.TunnelSpec{ID: "with-key", Name: "with-key", Owner: "alice", Type: types.TunnelTypeDynamic, Hops: hop(types.AuthMethodKey, "/home/alice/.ssh/id_ed25519")},
+		&types.TunnelSpec{ID: "with-agent", Name: "with-agent", Owner: "alice", Type: types.TunnelTypeDynamic, Hops: hop(types.AuthMethodAgent, "")})
+	key, err := withKeyring(t, s).Generate(context.Background(), "alice's", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := &User{ID: "1", Username: "alice", Roles: []string{"user"}}
+	admin := &User{ID: "2", Username: "root", Roles: []string{"admin"}}
+	elsewhere := `"host": "127.0.0.1", "port": ` + strconv.Itoa(port) + `, "user": "ops", "hostKeyVerification": "insecure"`
+
+	tests := []struct {
+		name string
+		user *User
+		body string
+		want int
+	}{
+		{"no tunnel", alice, `{` + elsewhere + `, "password": "x"}`, http.StatusForbidden},
+		{"no tunnel as admin", admin, `{` + elsewhere + `, "password": "x"}`, http.StatusBadGateway},
+		{"agent hop", alice, `{"tunnelId": "with-agent"}`, http.StatusForbidden},
+		{"agent hop with a password", alice, `{"tunnelId": "with-agent", "password": "x"}`, http.StatusBadGateway},
+		{"redirected to the agent", alice, `{"tunnelId": "with-key", ` + elsewhere + `}`, http.StatusForbidden},
+		{"agent hop as admin", admin, `{"tunnelId": "with-agent"}`, http.StatusBadGateway},
+		{"key hop", alice, `{"tunnelId": "with-key"}`, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(s.handleInstallKey, newRequest(http.MethodPost, "/api/v1/keys/"+key.ID+"/install", tt.body,
+				tt.user, map[string]string{"id": key.ID}))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestInstallHop(t *testing.T) {
+	target := &types.Hop{Host: "bastion", Port: 2222, User: "ops", AuthMethod: types.AuthMethodKey, KeyID: "managed:abc",
+		HostKeyVerification: types.HostKeyVerifyStrict}
+
+	tests := []struct {
+		name   string
+		target *types.Hop
+		req    InstallKeyRequest
+		want   types.Hop
+	}{
+		{"tunnel hop", target, InstallKeyRequest{}, *target},
+		{"tunnel hop as another user", target, InstallKeyRequest{User: "root"},
+			types.Hop{Host: "bastion", Port: 2222, User: "root", AuthMethod: types.AuthMethodKey, KeyID: "managed:abc",
+				HostKeyVerification: types.HostKeyVerifyStrict}},
+		{"another host", target, InstallKeyRequest{Host: "evil.example.com", HostKeyVerification: types.HostKeyVerifyInsecure},
+			types.Hop{Host: "evil.example.com", Port: 2222, User: "ops", AuthMethod: types.AuthMethodAgent,
+				HostKeyVerification: types.HostKeyVerifyInsecure}},
+		{"unverified hop", target, InstallKeyRequest{HostKeyVerification: types.HostKeyVerifyInsecure},
+			types.Hop{Host: "bastion", Port: 2222, User: "ops", AuthMethod: types.AuthMethodAgent,
+				HostKeyVerification: types.HostKeyVerifyInsecure}},
+		{"no tunnel", nil, InstallKeyRequest{Host: "app.internal", User: "deploy"},
+			types.Hop{Host: "app.internal", Port: 22, User: "deploy", AuthMethod: types.AuthMethodAgent,
+				HostKeyVerification: types.HostKeyVerifyStrict}},
+	}
+	for _, tt := range tests {
+		if got := installHop(tt.target, tt.req); got != tt.want {
+			t.Errorf("%s: installHop() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+// benchTimeout bounds a benchmark, which on a slow link mostly goes to the
+// transfers
+const benchTimeout = 2 * time.Minute
+
+// BenchRequest measures latency and throughput through a tunnel
+type BenchRequest struct {
+	Hop     *int  `json:"hop,omitempty" validate:"omitempty,min=0"`
+	Bytes   int64 `json:"bytes,omitempty" validate:"omitempty,min=1024,max=1073741824"` // each way; default 16 MiB
+	Samples int   `json:"samples,omitempty" validate:"omitempty,min=1,max=100"`         // latency probes; default 10
+}
+
+// BenchResponse is the outcome of a benchmark. Latencies are in
+// milliseconds and throughputs in bytes per second.
+type BenchResponse struct {
+	Hop     *int  `json:"hop,omitempty"`
+	Bytes   int64 `json:"bytes"`
+	Samples int   `json:"samples"`
+
+	LatencyMin float64 `json:"latencyMin"`
+	LatencyAvg float64 `json:"latencyAvg"`
+	LatencyMax float64 `json:"latencyMax"`
+
+	ConnectLatency *float64 `json:"connectLatency,omitempty"`
+	ConnectError   string   `json:"connectError,omitempty"`
+
+	Upload   float64 `json:"upload"`
+	Download float64 `json:"download"`
+}
+
+// handleBench measures the tunnel's SSH connection to a hop: request round
+// trips, then streaming data to and from the hop. It runs in the owner's
+// SSH session, so only they and admins may.
+func (s *Server) handleBench(w http.ResponseWriter, r *http.Request) {
+	tunnelID := mux.Vars(r)["id"]
+
+	t, ok := s.ownedTunnel(w, r, tunnelID)
+	if !ok {
+		return
+	}
+
+	var req BenchRequest
+	if !s.decodeAndValidate(w, r, &req) {
+		return
+	}
+	opts := tunnel.BenchOptions{Hop: -1, Bytes: req.Bytes, Samples: req.Samples}
+	if req.Hop != nil {
+		opts.Hop = *req.Hop
+	}
+
+	// Transfers may outlast the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(benchTimeout + 5*time.Second))
+	ctx, cancel := context.WithTimeout(r.Context(), benchTimeout)
+	defer cancel()
+
+	result, err := t.Bench(ctx, opts)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			s.TimeoutError(w, fmt.Sprintf("Benchmark did not finish within %s", benchTimeout))
+			return
+		}
+		s.hopSessionError(w, tunnelID, err)
+		return
+	}
+	s.requestLogger(r).Info().
+		Str("tunnel_id", tunnelID).
+		Dur("latency_avg", result.LatencyAvg).
+		Float64("upload", result.Upload).
+		Float64("download", result.Download).
+		Msg("Tunnel benchmarked")
+
+	resp := BenchResponse{
+		Hop:        req.Hop,
+		Bytes:      result.Bytes,
+		Samples:    result.Samples,
+		LatencyMin: milliseconds(result.LatencyMin),
+		LatencyAvg: milliseconds(result.LatencyAvg),
+		LatencyMax: milliseconds(result.LatencyMax),
+
+		ConnectError: result.ConnectError,
+
+		Upload:   result.Upload,
+		Download: result.Download,
+	}
+	if result.ConnectLatency > 0 {
+		ms := milliseconds(result.ConnectLatency)
+		resp.ConnectLatency = &ms
+	}
+	s.respondJSON(w, http.StatusOK, resp)
+}
+
+// milliseconds returns d in fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
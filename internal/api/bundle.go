@@ -0,0 +1,457 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// bundleVersion is the format version of exported tunnel bundles
+const bundleVersion = 1
+
+// maxBundleSize bounds the body of an import request
+const maxBundleSize = 10 << 20
+
+// Import strategies for tunnels whose name already exists on the server
+const (
+	ImportMerge     = "merge"     // keep the existing tunnel
+	ImportOverwrite = "overwrite" // replace it with the imported one
+)
+
+// TunnelBundle is a portable set of tunnel configurations. Tunnels are
+// stored as create requests, so a bundle carries no IDs, owners or runtime
+// state, and no secrets: hops name their keys by ID, and those keys must
+// exist on the server a bundle is imported into.
+type TunnelBundle struct {
+	Version    int                   `json:"version"`
+	ExportedAt time.Time             `json:"exportedAt"`
+	Tunnels    []CreateTunnelRequest `json:"tunnels"`
+}
+
+// ImportResult lists what happened to each tunnel of an imported bundle, by name
+type ImportResult struct {
+	Created  []string        `json:"created"`
+	Replaced []string        `json:"replaced"`
+	Skipped  []string        `json:"skipped"` // names already in use, with the merge strategy
+	Failed   []ImportFailure `json:"failed"`
+}
+
+// ImportFailure is a tunnel of a bundle that could not be imported
+type ImportFailure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// handleExport returns every tunnel, or one owner's with ?owner= or ?mine=,
+// as a bundle in JSON or, with ?format=yaml, YAML
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	owner, ok := s.ownerFilter(w, r)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "yaml" {
+		s.BadRequest(w, "Invalid format: expected json or yaml")
+		return
+	}
+
+	tunnels := s.listTunnels(r)
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].Spec.Name < tunnels[j].Spec.Name })
+
+	bundle := TunnelBundle{
+		Version:    bundleVersion,
+		ExportedAt: time.Now().UTC(),
+		Tunnels:    make([]CreateTunnelRequest, 0, len(tunnels)),
+	}
+	for _, t := range tunnels {
+		if owner != "" && t.Spec.Owner != owner {
+			continue
+		}
+		req := tunnelRequest(t.Spec)
+		// Imported tunnels get new IDs, so refer to other tunnels by name
+		if len(req.Hops) > 0 {
+			req.Hops[0].Via = s.tunnelName(req.Hops[0].Via)
+			req.Hops[0].Attach = s.tunnelName(req.Hops[0].Attach)
+		}
+		bundle.Tunnels = append(bundle.Tunnels, req)
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	contentType := "application/json"
+	if err == nil && format == "yaml" {
+		data, err = jsonToYAML(data)
+		contentType = "application/yaml"
+	}
+	if err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to encode export")
+		s.InternalError(w, "Failed to export tunnels")
+		return
+	}
+
+	if format == "" {
+		format = "json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="lazytunnel-export.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// handleImport creates the tunnels of a bundle, sent as JSON or YAML. With
+// ?strategy=merge (the default) tunnels whose name is in use are skipped;
+// with ?strategy=overwrite they replace the existing tunnel, keeping its ID.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	strategy := r.URL.Query().Get("strategy")
+	if strategy == "" {
+		strategy = ImportMerge
+	}
+	if strategy != ImportMerge && strategy != ImportOverwrite {
+		s.BadRequest(w, "Invalid strategy: expected merge or overwrite")
+		return
+	}
+
+	bundle, err := decodeBundle(r)
+	if err != nil {
+		s.BadRequest(w, "Invalid bundle: "+err.Error())
+		return
+	}
+	if bundle.Version != bundleVersion {
+		s.BadRequest(w, fmt.Sprintf("Unsupported bundle version %d, expected %d", bundle.Version, bundleVersion))
+		return
+	}
+
+	// Names are unique within the project, and deleted tunnels hold on to
+	// theirs
+	existing := make(map[string]*tunnel.Tunnel)
+	for _, t := range append(s.manager.List(), s.manager.ListDeleted()...) {
+		if projectOf(t) == requestProject(r) {
+			existing[t.Spec.Name] = t
+		}
+	}
+
+	// Dependencies must exist before the tunnels that depend on them
+	bundle.Tunnels = dependenciesFirst(bundle.Tunnels)
+
+	result := ImportResult{Created: []string{}, Replaced: []string{}, Skipped: []string{}, Failed: []ImportFailure{}}
+	seen := make(map[string]bool)
+	for i := range bundle.Tunnels {
+		req := &bundle.Tunnels[i]
+		name := SanitizeString(req.Name)
+		fail := func(message string) {
+			result.Failed = append(result.Failed, ImportFailure{Name: name, Error: message})
+		}
+
+		if seen[name] {
+			fail("duplicate name in bundle")
+			continue
+		}
+		seen[name] = true
+
+		s.tunnelDefaults.applyMatchRules(req)
+		if errs := ValidateRequest(req); len(errs) > 0 {
+			messages := make([]string, len(errs))
+			for j, e := range errs {
+				messages[j] = e.Message
+			}
+			fail(strings.Join(messages, "; "))
+			continue
+		}
+		if req.Expose && s.exposure == nil {
+			fail("public exposure is not enabled on this server")
+			continue
+		}
+		if !s.mayRunHookCommands(r, req.Hooks) {
+			fail("command hooks require the admin role")
+			continue
+		}
+		if setting, ok := s.mayChangeHost(r, req); !ok {
+			fail(setting + " require the admin role")
+			continue
+		}
+
+		spec := s.newTunnelSpec(req, requestOwner(r))
+		spec.Project = requestProject(r)
+		if err := s.checkAddresses(r, &spec); err != nil {
+			fail(err.Error())
+			continue
+		}
+		if err := s.checkHopKeys(r, spec.Hops); err != nil {
+			fail(err.Error())
+			continue
+		}
+		if err := s.resolveVia(&spec); err != nil {
+			fail(err.Error())
+			continue
+		}
+		if err := s.resolveAttach(&spec); err != nil {
+			fail(err.Error())
+			continue
+		}
+		if err := s.manager.CheckDependencies(&spec); err != nil {
+			fail(err.Error())
+			continue
+		}
+		current, replacing := existing[name]
+		if replacing {
+			if strategy == ImportMerge {
+				result.Skipped = append(result.Skipped, name)
+				continue
+			}
+			if !s.mayModify(r, current) {
+				fail("only the tunnel's owner or an admin can overwrite it")
+				continue
+			}
+			spec.ID = current.Spec.ID
+			spec.CreatedAt = current.Spec.CreatedAt
+			// Like deletes, stop errors don't keep the tunnel from being removed;
+			// if it wasn't, creating its replacement fails below
+			if err := s.manager.Purge(r.Context(), spec.ID); err != nil {
+				s.requestLogger(r).Warn().Err(err).Str("tunnel_id", spec.ID).Msg("Overwritten tunnel removed with warnings")
+			}
+			if s.exposure != nil {
+				s.exposure.Release(spec.ID)
+			}
+		}
+
+		if err := s.createImported(&spec, req); err != nil {
+			s.requestLogger(r).Error().Err(err).Str("name", name).Msg("Failed to import tunnel")
+			fail(err.Error())
+			continue
+		}
+		if replacing {
+			result.Replaced = append(result.Replaced, name)
+		} else {
+			result.Created = append(result.Created, name)
+		}
+	}
+
+	s.requestLogger(r).Info().
+		Str("strategy", strategy).
+		Int("created", len(result.Created)).
+		Int("replaced", len(result.Replaced)).
+		Int("skipped", len(result.Skipped)).
+		Int("failed", len(result.Failed)).
+		Msg("Tunnels imported")
+	s.respondJSON(w, http.StatusOK, result)
+}
+
+// createImported reserves the public subdomain of an imported tunnel, if it
+// has one, and creates it
+func (s *Server) createImported(spec *types.TunnelSpec, req *CreateTunnelRequest) error {
+	if req.Expose {
+		subdomain, err := s.exposure.Assign(spec.ID, req.Subdomain, spec.RemotePort)
+		if err != nil {
+			return err
+		}
+		spec.PublicSubdomain = subdomain
+	}
+
+	if err := s.manager.Create(context.Background(), spec); err != nil {
+		if s.exposure != nil {
+			s.exposure.Release(spec.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// decodeBundle reads a bundle from the request body, as YAML when the
+// Content-Type says so and JSON otherwise
+func decodeBundle(r *http.Request) (*TunnelBundle, error) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBundleSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBundleSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxBundleSize)
+	}
+
+	if isYAMLRequest(r) {
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, err
+		}
+	}
+
+	var bundle TunnelBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+// isYAMLRequest reports whether a request's Content-Type says its body is
+// YAML, e.g. application/yaml or application/x-yaml
+func isYAMLRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return strings.HasSuffix(mediaType, "yaml")
+}
+
+// jsonToYAML re-encodes a JSON document as YAML, keeping its field names
+func jsonToYAML(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// yamlToJSON re-encodes a YAML document as JSON, so it can be decoded into
+// types that only have JSON tags
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// dependenciesFirst reorders bundled tunnels so each comes after the
+// bundled tunnels it depends on, keeping the bundle's order otherwise
+func dependenciesFirst(reqs []CreateTunnelRequest) []CreateTunnelRequest {
+	index := make(map[string]int, len(reqs))
+	for i, req := range reqs {
+		if _, ok := index[req.Name]; !ok {
+			index[req.Name] = i
+		}
+	}
+
+	ordered := make([]CreateTunnelRequest, 0, len(reqs))
+	placed := make([]bool, len(reqs))
+	var place func(i int)
+	place = func(i int) {
+		if placed[i] {
+			return
+		}
+		placed[i] = true // also breaks cycles, which then fail validation
+		for _, name := range reqs[i].DependsOn {
+			if j, ok := index[name]; ok {
+				place(j)
+			}
+		}
+		ordered = append(ordered, reqs[i])
+	}
+	for i := range reqs {
+		place(i)
+	}
+	return ordered
+}
+
+// tunnelRequest converts a spec back to the request that creates it
+func tunnelRequest(spec *types.TunnelSpec) CreateTunnelRequest {
+	req := CreateTunnelRequest{
+		Name:             spec.Name,
+		Type:             string(spec.Type),
+		Protocol:         string(spec.Protocol),
+		Hops:             make([]HopReq, len(spec.Hops)),
+		LocalPort:        spec.LocalPort,
+		LocalBindAddress: spec.LocalBindAddress,
+		LocalFamily:      string(spec.LocalFamily),
+		RemoteHost:       spec.RemoteHost,
+		RemotePort:       spec.RemotePort,
+		Targets:          spec.Targets,
+		Routes:           spec.Routes,
+		SystemProxy:      spec.SystemProxy,
+		PAC:              newPACReq(spec.PAC),
+		AutoReconnect:    &spec.AutoReconnect,
+		KeepAlive:        int(spec.KeepAlive / time.Second),
+		KeepAliveMax:     spec.KeepAliveMax,
+		DrainTimeout:     int(spec.DrainTimeout / time.Second),
+		MaxRetries:       spec.MaxRetries,
+		AgentID:          spec.AgentID,
+		Expose:           spec.PublicSubdomain != "",
+		Subdomain:        spec.PublicSubdomain,
+		Interpolated:     spec.Interpolated,
+	}
+
+	for i, hop := range spec.Hops {
+		req.Hops[i] = HopReq{
+			Host:                hop.Host,
+			Port:                hop.Port,
+			User:                hop.User,
+			AuthMethod:          string(hop.AuthMethod),
+			KeyID:               hop.KeyID,
+			ForwardAgent:        hop.ForwardAgent,
+			KeyboardInteractive: hop.KeyboardInteractive,
+			Via:                 hop.Via,
+			Attach:              hop.Attach,
+			Transport:           string(hop.Transport),
+			RelayURL:            hop.RelayURL,
+		}
+	}
+
+	for _, mapping := range spec.Ports {
+		req.Ports = append(req.Ports, PortMappingReq{
+			Name:       mapping.Name,
+			LocalPort:  mapping.LocalPort,
+			RemoteHost: mapping.RemoteHost,
+			RemotePort: mapping.RemotePort,
+		})
+	}
+
+	if spec.Balance != (types.BalancePolicy{}) {
+		req.Balance = &BalanceReq{
+			Strategy:            string(spec.Balance.Strategy),
+			HealthCheckInterval: int(spec.Balance.HealthCheckInterval / time.Second),
+		}
+	}
+
+	if spec.TCP != (types.TCPOptions{}) {
+		keepAlive := int(spec.TCP.KeepAlive / time.Second)
+		if spec.TCP.KeepAlive < 0 {
+			keepAlive = -1
+		}
+		req.TCP = &TCPOptionsReq{
+			NoDelay:        spec.TCP.NoDelay,
+			KeepAlive:      keepAlive,
+			ReadBuffer:     spec.TCP.ReadBuffer,
+			WriteBuffer:    spec.TCP.WriteBuffer,
+			SSHBuffer:      spec.TCP.SSHBuffer,
+			ConnectTimeout: int(spec.TCP.ConnectTimeout / time.Second),
+			IdleTimeout:    int(spec.TCP.IdleTimeout / time.Second),
+
+			DialRetries:      spec.TCP.DialRetries,
+			DialRetryBackoff: int(spec.TCP.DialRetryBackoff / time.Millisecond),
+			HoldTimeout:      int(spec.TCP.HoldTimeout / time.Second),
+		}
+	}
+
+	if spec.Staleness != (types.StalePolicy{}) {
+		req.Staleness = &StalenessReq{
+			After:  int(spec.Staleness.After / time.Second),
+			Action: string(spec.Staleness.Action),
+		}
+	}
+
+	if spec.Backoff != (types.BackoffPolicy{}) {
+		req.Backoff = &BackoffReq{
+			Initial:    int(spec.Backoff.Initial / time.Millisecond),
+			Max:        int(spec.Backoff.Max / time.Millisecond),
+			Multiplier: spec.Backoff.Multiplier,
+			Jitter:     spec.Backoff.Jitter,
+		}
+	}
+
+	if spec.Restart != (types.RestartPolicy{}) {
+		req.Restart = &RestartReq{
+			Mode:       string(spec.Restart.Mode),
+			MaxPerHour: spec.Restart.MaxPerHour,
+			Backoff:    int(spec.Restart.Backoff / time.Second),
+		}
+	}
+
+	req.Hooks = newHooksReq(spec.Hooks)
+	req.DependsOn = spec.DependsOn
+
+	return req
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	noDelay := false
+	spec := &types.TunnelSpec{
+		ID:         "export-1",
+		Name:       "db",
+		Owner:      "alice",
+		Type:       types.TunnelTypeLocal,
+		Hops:       []types.Hop{{Host: "bastion.example.com", Port: 22, User: "ops", AuthMethod: types.AuthMethodKey, KeyID: "ops-key"}},
+		LocalPort:  5432,
+		RemoteHost: "db.internal",
+		RemotePort: 5432,
+		KeepAlive:  45 * time.Second,
+		MaxRetries: 3,
+		Backoff:    types.BackoffPolicy{Initial: 200 * time.Millisecond, Max: 5 * time.Second, Jitter: 0.2},
+		TCP:        types.TCPOptions{NoDelay: &noDelay, KeepAlive: -1, DialRetryBackoff: 250 * time.Millisecond},
+		Staleness:  types.StalePolicy{After: 10 * time.Minute, Action: types.StaleActionStop},
+		Restart:    types.RestartPolicy{Mode: types.RestartOnFailure, Backoff: 5 * time.Second},
+		Hooks:      types.Hooks{OnConnect: []types.Hook{{URL: "https://hooks.example.com/up", Timeout: 10 * time.Second}}},
+		// Resolved by the client that created the tunnel
+		Interpolated: map[string]string{"hops[0].host": "${BASTION}", "remoteHost": "db.${ENV:-internal}"},
+	}
+	source := newTestServer(t, spec)
+
+	for _, format := range []string{"json", "yaml"} {
+		t.Run(format, func(t *testing.T) {
+			rec := serve(source.handleExport, newRequest(http.MethodGet, "/api/v1/export?format="+format, "", nil, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Export failed with %d: %s", rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Header().Get("Content-Disposition"), "lazytunnel-export."+format) {
+				t.Errorf("Unexpected Content-Disposition %q", rec.Header().Get("Content-Disposition"))
+			}
+
+			target := newTestServer(t)
+			req := newRequest(http.MethodPost, "/api/v1/import", rec.Body.String(), nil, nil)
+			req.Header.Set("Content-Type", rec.Header().Get("Content-Type"))
+
+			var result ImportResult
+			if decodeJSON(t, serve(target.handleImport, req), &result); len(result.Created) != 1 || len(result.Failed) != 0 {
+				t.Fatalf("Expected the tunnel to be created, got %+v", result)
+			}
+
+			imported := target.manager.List()[0].Spec
+			if imported.ID == spec.ID || imported.Owner != anonymousOwner {
+				t.Errorf("Expected a new ID owned by the importer, got %s owned by %s", imported.ID, imported.Owner)
+			}
+			if !reflect.DeepEqual(tunnelRequest(imported), tunnelRequest(spec)) {
+				t.Errorf("Imported spec differs:\n got  %+v\n want %+v", tunnelRequest(imported), tunnelRequest(spec))
+			}
+		})
+	}
+}
+
+func TestImportStrategies(t *testing.T) {
+	s := newTestServer(t, &types.TunnelSpec{
+		ID:         "existing-1",
+		Name:       "db",
+		Type:       types.TunnelTypeLocal,
+		Hops:       []types.Hop{{Host: "old.example.com", Port: 22, User: "ops", AuthMethod: types.AuthMethodAgent}},
+		LocalPort:  5432,
+		RemoteHost: "db.internal",
+		RemotePort: 5432,
+	})
+
+	bundle := `{"version": 1, "tunnels": [
+		{"name": "db", "type": "local", "agentId": "edge-1", "localPort": 5432, "remoteHost": "db.internal", "remotePort": 5432,
+		 "hops": [{"host": "new.example.com", "port": 22, "user": "ops", "auth_method": "agent"}]},
+		{"name": "cache", "type": "local", "agentId": "edge-1", "localPort": 6379, "remoteHost": "cache.internal", "remotePort": 6379,
+		 "hops": [{"host": "new.example.com", "port": 22, "user": "ops", "auth_method": "agent"}]},
+		{"name": "broken", "type": "local", "hops": []}
+	]}`
+	importBundle := func(strategy string) ImportResult {
+		t.Helper()
+		var result ImportResult
+		decodeJSON(t, serve(s.handleImport, newRequest(http.MethodPost, "/api/v1/import?strategy="+strategy, bundle, nil, nil)), &result)
+		return result
+	}
+
+	result := importBundle(ImportMerge)
+	if !reflect.DeepEqual(result.Created, []string{"cache"}) || !reflect.DeepEqual(result.Skipped, []string{"db"}) {
+		t.Errorf("Unexpected merge result %+v", result)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Name != "broken" {
+		t.Errorf("Expected the invalid tunnel to fail, got %+v", result.Failed)
+	}
+	if kept, _ := s.manager.Get("existing-1"); kept.Spec.Hops[0].Host != "old.example.com" {
+		t.Error("Expected merge to keep the existing tunnel")
+	}
+
+	result = importBundle(ImportOverwrite)
+	if !reflect.DeepEqual(result.Replaced, []string{"db", "cache"}) {
+		t.Errorf("Unexpected overwrite result %+v", result)
+	}
+	replaced, err := s.manager.Get("existing-1")
+	if err != nil || replaced.Spec.Hops[0].Host != "new.example.com" {
+		t.Errorf("Expected overwrite to replace the tunnel and keep its ID: %v", err)
+	}
+
+	if rec := serve(s.handleImport, newRequest(http.MethodPost, "/api/v1/import?strategy=replace", bundle, nil, nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown strategy, got %d", rec.Code)
+	}
+	if rec := serve(s.handleImport, newRequest(http.MethodPost, "/api/v1/import", `{"version": 2}`, nil, nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unsupported version, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// Roles of an instance, as reported by /cluster and /health
+const (
+	roleStandalone = "standalone"
+	roleLeader     = "leader"
+	roleStandby    = "standby"
+)
+
+// ClusterResponse describes the instances sharing this server's storage
+type ClusterResponse struct {
+	Enabled    bool              `json:"enabled"`
+	InstanceID string            `json:"instanceId,omitempty"`
+	Role       string            `json:"role"`
+	LeaderID   string            `json:"leaderId,omitempty"`
+	Instances  []*types.Instance `json:"instances"`
+}
+
+// role returns whether this instance runs standalone, leads or stands by
+func (s *Server) role() string {
+	switch {
+	case s.cluster == nil:
+		return roleStandalone
+	case s.cluster.IsLeader():
+		return roleLeader
+	default:
+		return roleStandby
+	}
+}
+
+// handleGetCluster reports this instance's role, the leader and the live
+// instances
+func (s *Server) handleGetCluster(w http.ResponseWriter, r *http.Request) {
+	resp := ClusterResponse{Role: s.role(), Instances: []*types.Instance{}}
+	if s.cluster == nil {
+		s.respondJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	resp.Enabled = true
+	resp.InstanceID = s.cluster.ID()
+
+	leader, err := s.cluster.Leader(r.Context())
+	if err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to look up the leader")
+		s.InternalError(w, "Failed to look up the leader")
+		return
+	}
+	resp.LeaderID = leader
+
+	instances, err := s.cluster.Instances(r.Context())
+	if err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to list instances")
+		s.InternalError(w, "Failed to list instances")
+		return
+	}
+	resp.Instances = instances
+
+	s.respondJSON(w, http.StatusOK, resp)
+}
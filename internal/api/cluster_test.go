@@ -0,0 +1,32 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClusterStandalone(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	s.handleGetCluster(rec, httptest.NewRequest(http.MethodGet, "/api/v1/cluster", nil))
+	var resp ClusterResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Cluster failed with %d: %s", rec.Code, rec.Body.String())
+	}
+	if resp.Enabled || resp.Role != roleStandalone || resp.Instances == nil {
+		t.Errorf("Unexpected standalone cluster response %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleHealth(rec, httptest.NewRequest(http.MethodGet, "/api/v1/health", nil))
+	var health map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+		t.Fatal(err)
+	}
+	if health["role"] != roleStandalone {
+		t.Errorf("Expected health to report the role, got %v", health["role"])
+	}
+}
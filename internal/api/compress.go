@@ -0,0 +1,317 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/craigderington/lazytunnel/internal/brotli"
+)
+
+// CompressionConfig configures brotli and gzip compression of responses
+type CompressionConfig struct {
+	Enabled bool
+	Level   int // gzip level from 1 (fastest) to 9 (smallest); 0 uses the default. Brotli has one level.
+	MinSize int // responses smaller than this many bytes are sent as-is
+
+	// Path prefixes whose responses are never compressed
+	ExcludePaths []string
+}
+
+// CacheRule sets the Cache-Control header of responses under a path prefix.
+// The longest matching prefix wins.
+type CacheRule struct {
+	PathPrefix string
+	Value      string
+}
+
+// defaultAPICacheControl keeps live tunnel state out of shared caches and
+// makes browsers revalidate it, with If-None-Match where there is an ETag
+const defaultAPICacheControl = "private, no-cache"
+
+// compressibleTypes are the media types worth compressing
+var compressibleTypes = []string{
+	"application/json",
+	"application/javascript",
+	"application/x-ndjson",
+	"application/yaml",
+	"application/xml",
+	"image/svg+xml",
+}
+
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	for _, t := range compressibleTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// contentCodings are the codings responses are compressed with, in the
+// order the server prefers them: brotli comes out smaller
+var contentCodings = []string{"br", "gzip"}
+
+// acceptedEncodings returns the codings of those offered that the client
+// accepts, by the weight it gives them, highest first. Codings of the same
+// weight keep the order offered.
+func acceptedEncodings(r *http.Request, offered ...string) []string {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if value, err := strconv.ParseFloat(q, 64); err == nil {
+					weight = value
+				}
+			}
+		}
+		weights[name] = weight
+	}
+
+	// Codings not listed take the weight of *, if any
+	weight := func(coding string) float64 {
+		if w, listed := weights[coding]; listed {
+			return w
+		}
+		return weights["*"]
+	}
+	var accepted []string
+	for _, coding := range offered {
+		if weight(coding) > 0 {
+			accepted = append(accepted, coding)
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return weight(accepted[i]) > weight(accepted[j]) })
+	return accepted
+}
+
+// addVary adds a field to the Vary header unless it is already listed
+func addVary(h http.Header, field string) {
+	for _, value := range h.Values("Vary") {
+		for _, existing := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+// cacheControlMiddleware applies the configured Cache-Control rules. API
+// responses default to private, no-cache; other handlers set their own defaults.
+func (s *Server) cacheControlMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if value := s.cacheControlFor(r.URL.Path); value != "" {
+			w.Header().Set("Cache-Control", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cacheControlFor returns the Cache-Control value for a path, if any
+func (s *Server) cacheControlFor(path string) string {
+	value, matched := "", -1
+	for _, rule := range s.cacheRules {
+		if strings.HasPrefix(path, rule.PathPrefix) && len(rule.PathPrefix) > matched {
+			value, matched = rule.Value, len(rule.PathPrefix)
+		}
+	}
+	if matched < 0 && strings.HasPrefix(path, "/api/") {
+		return defaultAPICacheControl
+	}
+	return value
+}
+
+// encoder compresses a response body for one content coding
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressionMiddleware compresses compressible responses with the coding
+// the client prefers of brotli and gzip
+func (s *Server) compressionMiddleware(next http.Handler) http.Handler {
+	level := s.compression.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	pools := map[string]*sync.Pool{
+		"br": {New: func() interface{} { return brotli.NewWriter(nil) }},
+		"gzip": {New: func() interface{} {
+			gz, _ := gzip.NewWriterLevel(nil, level)
+			return gz
+		}},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// WebSocket upgrades take over the connection
+		if r.Header.Get("Upgrade") != "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, prefix := range s.compression.ExcludePaths {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		addVary(w.Header(), "Accept-Encoding")
+		accepted := acceptedEncodings(r, contentCodings...)
+		if len(accepted) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		coding := accepted[0]
+		cw := &compressWriter{ResponseWriter: w, coding: coding, pool: pools[coding], minSize: s.compression.MinSize}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// compressing it is worthwhile: the content type must be compressible and
+// the body at least minSize bytes, unless the handler flushes first.
+type compressWriter struct {
+	http.ResponseWriter
+	coding  string
+	pool    *sync.Pool
+	minSize int
+
+	status  int
+	buf     []byte
+	enc     encoder
+	decided bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = code
+
+	h := cw.Header()
+	switch {
+	case code < http.StatusOK, code == http.StatusNoContent, code == http.StatusNotModified,
+		code == http.StatusPartialContent, h.Get("Content-Encoding") != "",
+		!isCompressible(h.Get("Content-Type")):
+		cw.passThrough()
+	default:
+		if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < cw.minSize {
+			cw.passThrough()
+		}
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	switch {
+	case cw.enc != nil:
+		return cw.enc.Write(p)
+	case cw.decided:
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.startCompression(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// passThrough sends the response uncompressed
+func (cw *compressWriter) passThrough() {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// startCompression commits to a compressed response and sends what was
+// buffered
+func (cw *compressWriter) startCompression() error {
+	cw.decided = true
+
+	h := cw.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", cw.coding)
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	cw.enc = cw.pool.Get().(encoder)
+	cw.enc.Reset(cw.ResponseWriter)
+
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.enc.Write(buf)
+	return err
+}
+
+// close finishes the response once the handler returns
+func (cw *compressWriter) close() {
+	switch {
+	case cw.enc != nil:
+		cw.enc.Close()
+		cw.pool.Put(cw.enc)
+		cw.enc = nil
+	case !cw.decided && cw.status != 0:
+		// Too small to be worth it
+		cw.passThrough()
+		cw.ResponseWriter.Write(cw.buf)
+	}
+}
+
+// Flush sends buffered output right away. Streaming responses flush early,
+// so they are compressed however little has been written.
+func (cw *compressWriter) Flush() {
+	if cw.status != 0 && !cw.decided {
+		cw.startCompression()
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack delegates to the underlying ResponseWriter
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("underlying ResponseWriter does not implement http.Hijacker")
+}
+
+// Unwrap exposes the underlying ResponseWriter to http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
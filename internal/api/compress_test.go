@@ -0,0 +1,155 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCompressionMiddleware(t *testing.T) {
+	s := &Server{compression: CompressionConfig{Enabled: true, MinSize: 256, ExcludePaths: []string{"/api/v1/metrics"}}}
+
+	large := `{"tunnels":[` + strings.Repeat(`{"name":"prod-db","state":"active"},`, 50) + `{}]}`
+	handler := s.compressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			s.respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		case "/binary":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte(large))
+		case "/stream":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Write([]byte("{}\n"))
+			w.(http.Flusher).Flush()
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(large))
+		}
+	}))
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{"large JSON", "/api/v1/tunnels", "gzip, deflate, br", "br"},
+		{"client without brotli", "/api/v1/tunnels", "gzip, deflate", "gzip"},
+		{"client without gzip", "/api/v1/tunnels", "br", "br"},
+		{"gzip preferred", "/api/v1/tunnels", "br;q=0.5, gzip", "gzip"},
+		{"gzip refused", "/api/v1/tunnels", "gzip;q=0, br", "br"},
+		{"both refused", "/api/v1/tunnels", "gzip;q=0, br;q=0", ""},
+		{"any coding", "/api/v1/tunnels", "*", "br"},
+		{"any coding less preferred", "/api/v1/tunnels", "*;q=0.5, gzip", "gzip"},
+		{"identity only", "/api/v1/tunnels", "identity", ""},
+		{"small JSON", "/small", "gzip", ""},
+		{"binary", "/binary", "gzip", ""},
+		{"excluded path", "/api/v1/metrics", "gzip", ""},
+		{"flushed stream", "/stream", "gzip", "gzip"},
+		{"flushed brotli stream", "/stream", "br", "br"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if tt.wantEncoding != "" && rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", rec.Header().Get("Vary"))
+			}
+			// The brotli package checks its output decompresses
+			if tt.wantEncoding != "gzip" {
+				return
+			}
+
+			zr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("Invalid gzip body: %v", err)
+			}
+			body, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("Failed to decompress: %v", err)
+			}
+			if tt.path != "/stream" && string(body) != large {
+				t.Errorf("Decompressed body doesn't match what the handler wrote")
+			}
+		})
+	}
+}
+
+func TestCacheControlFor(t *testing.T) {
+	s := &Server{cacheRules: []CacheRule{
+		{PathPrefix: "/api/v1/openapi.yaml", Value: "public, max-age=300"},
+		{PathPrefix: "/assets/", Value: "public, max-age=600"},
+		{PathPrefix: "/", Value: "no-cache"},
+	}}
+
+	tests := map[string]string{
+		"/api/v1/openapi.yaml":  "public, max-age=300",
+		"/assets/index-3f2a.js": "public, max-age=600",
+		"/tunnels":              "no-cache",
+		"/api/v1/tunnels":       "no-cache",
+	}
+	for path, want := range tests {
+		if got := s.cacheControlFor(path); got != want {
+			t.Errorf("cacheControlFor(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	if got := (&Server{}).cacheControlFor("/api/v1/tunnels"); got != defaultAPICacheControl {
+		t.Errorf("API default = %q, want %q", got, defaultAPICacheControl)
+	}
+	if got := (&Server{}).cacheControlFor("/favicon.svg"); got != "" {
+		t.Errorf("UI default = %q, want none", got)
+	}
+}
+
+func TestUIServesPrecompressedAssets(t *testing.T) {
+	ui := fstest.MapFS{
+		"index.html":              {Data: []byte("<html>app</html>")},
+		"assets/index-3f2a.js":    {Data: []byte("console.log(1)")},
+		"assets/index-3f2a.js.br": {Data: []byte("brotli bytes")},
+		"assets/index-3f2a.js.gz": {Data: []byte("gzip bytes")},
+		"assets/app-9c1e.js":      {Data: []byte("console.log(2)")},
+	}
+	handler := (&Server{}).uiHandler(ui)
+
+	req := httptest.NewRequest(http.MethodGet, "/assets/index-3f2a.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "br" || rec.Body.String() != "brotli bytes" {
+		t.Errorf("Expected the .br variant, got %q (%q)", rec.Body.String(), rec.Header().Get("Content-Encoding"))
+	}
+	if !strings.Contains(rec.Header().Get("Content-Type"), "javascript") {
+		t.Errorf("Content-Type = %q, want the original file's type", rec.Header().Get("Content-Type"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/assets/index-3f2a.js", nil)
+	req.Header.Set("Accept-Encoding", "br;q=0.1, gzip")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Body.String() != "gzip bytes" {
+		t.Errorf("Expected the .gz variant the client prefers, got %q (%q)", rec.Body.String(), rec.Header().Get("Content-Encoding"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/assets/app-9c1e.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "console.log(2)" {
+		t.Errorf("Expected the plain file without a .gz variant, got %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// deletedPurgeInterval is how often tunnels past their retention are purged,
+// and inactive tunnels deleted
+const deletedPurgeInterval = time.Hour
+
+// inactiveWebhookTimeout bounds telling the inactive cleanup webhook
+const inactiveWebhookTimeout = 10 * time.Second
+
+// InactiveCleanup configures deleting tunnels that have been stopped or
+// failed, and untouched, for Retention. They are soft-deleted, so they can
+// be restored until the deleted tunnels' retention passes.
+type InactiveCleanup struct {
+	Retention time.Duration // 0 disables the cleanup
+	Webhook   string        // POSTed an InactiveCleanupEvent for each cleanup deleting tunnels, if set
+}
+
+// InactiveCleanupEvent is what the inactive cleanup webhook is POSTed
+type InactiveCleanupEvent struct {
+	Event     string              `json:"event"` // always "tunnels_deleted_inactive"
+	Retention float64             `json:"retentionSeconds"`
+	Tunnels   []InactiveTunnelRef `json:"tunnels"`
+	Timestamp time.Time           `json:"timestamp"`
+}
+
+// InactiveTunnelRef identifies a tunnel the inactive cleanup deleted
+type InactiveTunnelRef struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Owner   string            `json:"owner"`
+	Project string            `json:"project,omitempty"`
+	State   types.TunnelState `json:"state"`
+}
+
+// handleRestoreTunnel brings a soft-deleted tunnel back, stopped
+func (s *Server) handleRestoreTunnel(w http.ResponseWriter, r *http.Request) {
+	tunnelID := mux.Vars(r)["id"]
+
+	t, err := s.getDeletedTunnel(r, tunnelID)
+	if err != nil {
+		if _, getErr := s.getTunnel(r, tunnelID); getErr == nil {
+			s.ConflictError(w, "Tunnel is not deleted")
+			return
+		}
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+	if _, ok := s.checkOwned(w, r, tunnelID, t, nil); !ok {
+		return
+	}
+
+	// Take the public subdomain back before the tunnel reappears
+	if t.Spec.PublicSubdomain != "" && s.exposure != nil {
+		if _, err := s.exposure.Assign(tunnelID, t.Spec.PublicSubdomain, t.Spec.RemotePort); err != nil {
+			s.ConflictError(w, fmt.Sprintf("Cannot restore public subdomain: %v", err))
+			return
+		}
+	}
+
+	restored, err := s.manager.Restore(r.Context(), tunnelID)
+	if err != nil {
+		if s.exposure != nil {
+			s.exposure.Release(tunnelID)
+		}
+		if errors.Is(err, tunnel.ErrNotDeleted) {
+			s.ConflictError(w, "Tunnel is not deleted")
+			return
+		}
+		if s.quotaError(w, err) {
+			return
+		}
+		s.requestLogger(r).Error().Err(err).Str("tunnel_id", tunnelID).Msg("Failed to restore tunnel")
+		s.InternalError(w, "Failed to restore tunnel")
+		return
+	}
+
+	s.requestLogger(r).Info().Str("tunnel_id", tunnelID).Msg("Tunnel restored")
+	s.respondJSON(w, http.StatusOK, s.newTunnelResponse(restored))
+}
+
+// purgeDeleted permanently removes tunnels once they have been deleted for
+// retention, until ctx is cancelled. A zero retention keeps deleted tunnels
+// until they are purged explicitly.
+func (s *Server) purgeDeleted(ctx context.Context, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(min(retention, deletedPurgeInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.manager.PurgeDeleted(ctx, time.Now().Add(-retention))
+			if err != nil {
+				s.logger.Error().Err(err).Msg("Failed to purge deleted tunnels")
+			}
+			if purged > 0 {
+				s.logger.Info().Int("count", purged).Msg("Purged deleted tunnels")
+			}
+		}
+	}
+}
+
+// deleteInactive runs the inactive cleanup every deletedPurgeInterval until
+// ctx is cancelled, unless it is disabled
+func (s *Server) deleteInactive(ctx context.Context, cleanup InactiveCleanup) {
+	if cleanup.Retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(min(cleanup.Retention, deletedPurgeInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.cleanupInactive(ctx, cleanup)
+		}
+	}
+}
+
+// cleanupInactive soft-deletes the tunnels inactive for the cleanup's
+// retention, logs each for the audit trail and tells the webhook
+func (s *Server) cleanupInactive(ctx context.Context, cleanup InactiveCleanup) {
+	deleted, err := s.manager.DeleteInactive(ctx, time.Now().Add(-cleanup.Retention))
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to delete inactive tunnels")
+	}
+	if len(deleted) == 0 {
+		return
+	}
+
+	event := InactiveCleanupEvent{
+		Event:     "tunnels_deleted_inactive",
+		Retention: cleanup.Retention.Seconds(),
+		Tunnels:   make([]InactiveTunnelRef, len(deleted)),
+		Timestamp: time.Now().UTC(),
+	}
+	for i, t := range deleted {
+		if s.exposure != nil {
+			s.exposure.Release(t.Spec.ID)
+		}
+		ref := InactiveTunnelRef{ID: t.Spec.ID, Name: t.Spec.Name, Owner: t.Spec.Owner, Project: projectOf(t)}
+		if status := t.GetStatus(); status != nil {
+			ref.State = status.State
+		}
+		event.Tunnels[i] = ref
+		s.logger.Info().
+			Str("tunnel_id", ref.ID).
+			Str("name", ref.Name).
+			Str("owner", ref.Owner).
+			Str("project", ref.Project).
+			Dur("retention", cleanup.Retention).
+			Msg("Deleted inactive tunnel")
+	}
+	s.logger.Info().Int("count", len(deleted)).Msg("Deleted inactive tunnels")
+
+	if cleanup.Webhook != "" {
+		if err := postInactiveCleanup(ctx, cleanup.Webhook, event); err != nil {
+			s.logger.Warn().Err(err).Str("url", cleanup.Webhook).Msg("Failed to notify the inactive cleanup webhook")
+		}
+	}
+}
+
+// postInactiveCleanup POSTs event to the webhook at url
+func postInactiveCleanup(ctx context.Context, url string, event InactiveCleanupEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, inactiveWebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
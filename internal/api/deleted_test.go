@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	spec := &types.TunnelSpec{ID: "trash-1", Name: "db", Type: types.TunnelTypeLocal}
+	s := newTestServer(t, spec)
+
+	send := func(handler http.HandlerFunc, method, target string) *httptest.ResponseRecorder {
+		return serve(handler, newRequest(method, target, "", nil, map[string]string{"id": spec.ID}))
+	}
+	list := func(query string) []TunnelResponse {
+		t.Helper()
+		var tunnels []TunnelResponse
+		decodeJSON(t, send(s.handleListTunnels, http.MethodGet, "/api/v1/tunnels"+query), &tunnels)
+		return tunnels
+	}
+
+	if rec := send(s.handleDeleteTunnel, http.MethodDelete, "/api/v1/tunnels/trash-1"); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 deleting, got %d", rec.Code)
+	}
+	if tunnels := list(""); len(tunnels) != 0 {
+		t.Errorf("Expected deleted tunnels to be hidden, got %d", len(tunnels))
+	}
+	tunnels := list("?includeDeleted=true")
+	if len(tunnels) != 1 || tunnels[0].DeletedAt == nil {
+		t.Fatalf("Expected the deleted tunnel with deletedAt, got %+v", tunnels)
+	}
+	if rec := send(s.handleGetTunnel, http.MethodGet, "/api/v1/tunnels/trash-1"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 getting a deleted tunnel, got %d", rec.Code)
+	}
+	if rec := send(s.handleGetTunnel, http.MethodGet, "/api/v1/tunnels/trash-1?includeDeleted=true"); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 getting a deleted tunnel with includeDeleted, got %d", rec.Code)
+	}
+	if rec := send(s.handleListTunnels, http.MethodGet, "/api/v1/tunnels?includeDeleted=maybe"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid includeDeleted, got %d", rec.Code)
+	}
+
+	rec := send(s.handleRestoreTunnel, http.MethodPost, "/api/v1/tunnels/trash-1/restore")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 restoring, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var restored TunnelResponse
+	if decodeJSON(t, rec, &restored); restored.DeletedAt != nil || restored.Status != "disconnected" {
+		t.Errorf("Expected a restored, stopped tunnel, got %+v", restored)
+	}
+	if rec := send(s.handleRestoreTunnel, http.MethodPost, "/api/v1/tunnels/trash-1/restore"); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 restoring a live tunnel, got %d", rec.Code)
+	}
+
+	// Purging removes it for good
+	if rec := send(s.handleDeleteTunnel, http.MethodDelete, "/api/v1/tunnels/trash-1?purge=true"); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 purging, got %d", rec.Code)
+	}
+	if tunnels := list("?includeDeleted=true"); len(tunnels) != 0 {
+		t.Errorf("Expected no tunnels after purging, got %d", len(tunnels))
+	}
+	if rec := send(s.handleRestoreTunnel, http.MethodPost, "/api/v1/tunnels/trash-1/restore"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 restoring a purged tunnel, got %d", rec.Code)
+	}
+}
+
+func TestForceStopAndDelete(t *testing.T) {
+	spec := &types.TunnelSpec{ID: "dump-1", Name: "dump", Type: types.TunnelTypeLocal}
+	s := newTestServer(t, spec)
+	send := func(handler http.HandlerFunc, method, target string) *httptest.ResponseRecorder {
+		return serve(handler, newRequest(method, target, "", nil, map[string]string{"id": spec.ID}))
+	}
+
+	if rec := send(s.handleStopTunnel, http.MethodPost, "/api/v1/tunnels/dump-1/stop?force=maybe"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid force, got %d", rec.Code)
+	}
+	if rec := send(s.handleStopTunnel, http.MethodPost, "/api/v1/tunnels/dump-1/stop?force=true"); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 force-stopping, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := send(s.handleDeleteTunnel, http.MethodDelete, "/api/v1/tunnels/dump-1?force=true")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 force-deleting, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-Force-Closed"); got != "0" {
+		t.Errorf("X-Force-Closed = %q, want 0 for a tunnel without connections", got)
+	}
+}
+
+func TestCleanupInactive(t *testing.T) {
+	ctx := context.Background()
+	spec := &types.TunnelSpec{ID: "idle-1", Name: "idle", Owner: "alice", Type: types.TunnelTypeLocal}
+	s := newTestServer(t, spec)
+	manager := s.manager
+
+	events := make(chan InactiveCleanupEvent, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event InactiveCleanupEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+		events <- event
+	}))
+	defer webhook.Close()
+
+	// Nothing is old enough yet
+	s.cleanupInactive(ctx, InactiveCleanup{Retention: time.Hour, Webhook: webhook.URL})
+	if len(manager.List()) != 1 {
+		t.Fatal("Expected a tunnel created just now to be kept")
+	}
+
+	// A cutoff in the future makes every stopped tunnel inactive
+	s.cleanupInactive(ctx, InactiveCleanup{Retention: -time.Hour, Webhook: webhook.URL})
+	if len(manager.List()) != 0 || len(manager.ListDeleted()) != 1 {
+		t.Fatalf("Expected the tunnel to be soft-deleted, %d live and %d deleted", len(manager.List()), len(manager.ListDeleted()))
+	}
+	select {
+	case event := <-events:
+		if event.Event != "tunnels_deleted_inactive" || len(event.Tunnels) != 1 ||
+			event.Tunnels[0].ID != spec.ID || event.Tunnels[0].Owner != "alice" {
+			t.Errorf("Unexpected webhook event %+v", event)
+		}
+	default:
+		t.Error("Expected the webhook to be told about the deleted tunnel")
+	}
+}
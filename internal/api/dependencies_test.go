@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestTunnelDependencies(t *testing.T) {
+	s := newTestServer(t, &types.TunnelSpec{ID: "socks-1", Name: "socks", Type: types.TunnelTypeDynamic})
+
+	create := func(name, agentID string) *httptest.ResponseRecorder {
+		return s.postTunnel(`{"name": "`+name+`", "type": "local", "localPort": 5432, "remoteHost": "db.internal", "remotePort": 5432,
+			"agentId": "`+agentID+`", "dependsOn": ["socks"],
+			"hops": [{"host": "bastion.example.com", "port": 22, "user": "ops", "auth_method": "agent"}]}`, nil)
+	}
+
+	if rec := create("db-elsewhere", "edge-2"); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "another agent") {
+		t.Errorf("Expected a dependency on another agent to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := create("db", "edge-1")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the dependent tunnel to be created, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"dependencies":[{"name":"socks","id":"socks-1","status":"disconnected"}]`) ||
+		!strings.Contains(rec.Body.String(), `"dependenciesReady":false`) {
+		t.Errorf("Expected the dependency's state to be reported, got %s", rec.Body.String())
+	}
+
+	rec = serve(s.handleDeleteTunnel, newRequest(http.MethodDelete, "/api/v1/tunnels/socks-1", "", nil, map[string]string{"id": "socks-1"}))
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "db") {
+		t.Errorf("Expected deleting a dependency to conflict, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,19 @@
+package api
+
+import "net/http"
+
+// handleGetSystemDNS returns the cached lookups of hop hostnames, with the
+// last failure of each
+func (s *Server) handleGetSystemDNS(w http.ResponseWriter, r *http.Request) {
+	s.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"entries": s.manager.DNSResolver().Entries(),
+	})
+}
+
+// handleFlushSystemDNS forgets the cached lookups, so the next connection
+// to each hop looks it up again
+func (s *Server) handleFlushSystemDNS(w http.ResponseWriter, r *http.Request) {
+	s.respondJSON(w, http.StatusOK, map[string]int{
+		"flushed": s.manager.DNSResolver().Flush(),
+	})
+}
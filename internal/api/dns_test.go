@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+func TestSystemDNS(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t)
+	s.manager.SetDNS(tunnel.DNSConfig{CacheTTL: time.Minute})
+
+	if _, err := s.manager.DNSResolver().LookupHost(ctx, "localhost"); err != nil {
+		t.Skipf("localhost doesn't resolve here: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleGetSystemDNS(rec, httptest.NewRequest(http.MethodGet, "/api/v1/system/dns", nil))
+	var body struct {
+		Entries []tunnel.DNSCacheEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Entries) != 1 || body.Entries[0].Host != "localhost" || len(body.Entries[0].Addrs) == 0 {
+		t.Errorf("entries = %+v, want the localhost lookup", body.Entries)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleFlushSystemDNS(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/system/dns", nil))
+	var flushed map[string]int
+	if err := json.Unmarshal(rec.Body.Bytes(), &flushed); err != nil {
+		t.Fatal(err)
+	}
+	if flushed["flushed"] != 1 || len(s.manager.DNSResolver().Entries()) != 0 {
+		t.Errorf("flushed = %v, leaving %v", flushed, s.manager.DNSResolver().Entries())
+	}
+}
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"time"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// TunnelResponse is the API's representation of a tunnel: its spec and its
+// current runtime status, in camelCase with durations in seconds. Every
+// endpoint and WebSocket message that returns a tunnel builds it with
+// newTunnelResponse, so they can't drift apart.
+type TunnelResponse struct {
+	ID               string                 `json:"id"`
+	Name             string                 `json:"name"`
+	Owner            string                 `json:"owner"`
+	Project          string                 `json:"project"`
+	AgentID          string                 `json:"agentId"`
+	DesiredStatus    string                 `json:"desiredStatus"`
+	Type             types.TunnelType       `json:"type"`
+	Protocol         types.Protocol         `json:"protocol"`
+	Hops             []types.Hop            `json:"hops"`
+	LocalPort        int                    `json:"localPort"`
+	LocalBindAddress string                 `json:"localBindAddress"`
+	LocalFamily      types.AddressFamily    `json:"localFamily"`
+	RemoteHost       string                 `json:"remoteHost"`
+	RemotePort       int                    `json:"remotePort"`
+	Targets          []string               `json:"targets"`
+	Balance          BalancePolicyResponse  `json:"balance"`
+	Ports            []PortMappingResponse  `json:"ports"`
+	Routes           []string               `json:"routes"`
+	SystemProxy      bool                   `json:"systemProxy"`
+	PAC              *PACReq                `json:"pac,omitempty"` // same shape as in create requests
+	TCP              TCPOptionsResponse     `json:"tcp"`
+	Staleness        StalePolicyResponse    `json:"staleness"`
+	Restart          RestartPolicyResponse  `json:"restart"`
+	Hooks            *HooksReq              `json:"hooks,omitempty"` // same shape as in create requests
+	DependsOn        []string               `json:"dependsOn,omitempty"`
+	Dependencies     []DependencyResponse   `json:"dependencies,omitempty"`      // state of each of DependsOn
+	DependenciesUp   *bool                  `json:"dependenciesReady,omitempty"` // whether all of them are active
+	AutoReconnect    bool                   `json:"autoReconnect"`
+	KeepAlive        float64                `json:"keepAlive"`
+	KeepAliveMax     int                    `json:"keepAliveMax,omitempty"` // 0 uses the default
+	DrainTimeout     float64                `json:"drainTimeout,omitempty"` // 0 uses the default
+	MaxRetries       int                    `json:"maxRetries"`
+	Backoff          BackoffPolicyResponse  `json:"backoff"` // in effect, defaults included
+	Status           string                 `json:"status"`
+	CreatedAt        string                 `json:"createdAt"`
+	UpdatedAt        string                 `json:"updatedAt"`
+	DeletedAt        *string                `json:"deletedAt"` // null unless soft-deleted
+	ErrorMessage     string                 `json:"errorMessage"`
+	FailureReason    types.FailureReason    `json:"failureReason,omitempty"` // category of ErrorMessage
+	Problems         []string               `json:"problems,omitempty"`      // why a misconfigured tunnel can't start
+	BoundAddress     string                 `json:"boundAddress"`
+	BoundPort        int                    `json:"boundPort"`
+	LastActivity     *string                `json:"lastActivity"` // null while not forwarding
+	StaleSeconds     float64                `json:"staleSeconds"`
+	Stale            bool                   `json:"stale"`
+	Restarts         *RestartsResponse      `json:"restarts"`
+	NextRetryAt      *string                `json:"nextRetryAt"`                // null unless a reconnect or restart is scheduled
+	RetryAttempt     int                    `json:"retryAttempt,omitempty"`     // of that next attempt, counting from 1
+	MaxRetryAttempts int                    `json:"maxRetryAttempts,omitempty"` // attempts before giving up; for restarts, per hour
+	TargetStatus     []TargetStatusResponse `json:"targetStatus"`
+	PortStatus       []PortStatusResponse   `json:"portStatus"`
+	PublicURL        string                 `json:"publicUrl"`
+	ForceClosed      int                    `json:"forceClosed,omitempty"`  // connections closed without draining when last stopped
+	Interpolated     map[string]string      `json:"interpolated,omitempty"` // field path to the ${VAR} template it was resolved from
+}
+
+// BalancePolicyResponse is a load balancing policy
+type BalancePolicyResponse struct {
+	Strategy            types.BalanceStrategy `json:"strategy"`
+	HealthCheckInterval float64               `json:"healthCheckInterval"`
+}
+
+// PortMappingResponse is one mapping of a multi-port tunnel
+type PortMappingResponse struct {
+	Name       string `json:"name"`
+	LocalPort  int    `json:"localPort"`
+	RemoteHost string `json:"remoteHost"`
+	RemotePort int    `json:"remotePort"`
+}
+
+// TCPOptionsResponse holds socket tuning options
+type TCPOptionsResponse struct {
+	NoDelay        *bool   `json:"noDelay"`
+	KeepAlive      float64 `json:"keepAlive"`
+	ReadBuffer     int     `json:"readBuffer"`
+	WriteBuffer    int     `json:"writeBuffer"`
+	SSHBuffer      int     `json:"sshBuffer"`
+	ConnectTimeout float64 `json:"connectTimeout"`
+	IdleTimeout    float64 `json:"idleTimeout"`
+
+	DialRetries      int     `json:"dialRetries"`
+	DialRetryBackoff int64   `json:"dialRetryBackoff"` // milliseconds
+	HoldTimeout      float64 `json:"holdTimeout"`
+}
+
+// StalePolicyResponse is a stale tunnel policy
+type StalePolicyResponse struct {
+	After  float64           `json:"after"`
+	Action types.StaleAction `json:"action"`
+}
+
+// RestartPolicyResponse is a restart policy
+type RestartPolicyResponse struct {
+	Mode       types.RestartMode `json:"mode"`
+	MaxPerHour int               `json:"maxPerHour"`
+	Backoff    float64           `json:"backoff"`
+}
+
+// BackoffPolicyResponse is the reconnect backoff of a tunnel's sessions
+type BackoffPolicyResponse struct {
+	Initial    int64   `json:"initial"` // milliseconds
+	Max        int64   `json:"max"`     // milliseconds
+	Multiplier float64 `json:"multiplier"`
+	Jitter     float64 `json:"jitter"`
+}
+
+// DependencyResponse is the state of a tunnel's dependency
+type DependencyResponse struct {
+	Name   string `json:"name"`
+	ID     string `json:"id"`     // empty if no tunnel has the name
+	Status string `json:"status"` // missing if no tunnel has the name
+}
+
+// RestartsResponse counts the restarts performed by a restart policy
+type RestartsResponse struct {
+	Count         int     `json:"count"`
+	LastHour      int     `json:"lastHour"`
+	LastRestartAt *string `json:"lastRestartAt"`
+}
+
+// TargetStatusResponse is the health and load of one pooled target
+type TargetStatusResponse struct {
+	Address     string `json:"address"`
+	Healthy     bool   `json:"healthy"`
+	ActiveConns int64  `json:"activeConns"`
+	Connections int64  `json:"connections"`
+	Failures    int64  `json:"failures"`
+	LastError   string `json:"lastError"`
+
+	FailureReason types.FailureReason `json:"failureReason,omitempty"`
+}
+
+// PortStatusResponse is the bound address and traffic of one port mapping
+type PortStatusResponse struct {
+	Name          string `json:"name"`
+	LocalPort     int    `json:"localPort"`
+	RemoteHost    string `json:"remoteHost"`
+	RemotePort    int    `json:"remotePort"`
+	BoundAddress  string `json:"boundAddress"`
+	BytesSent     int64  `json:"bytesSent"`
+	BytesReceived int64  `json:"bytesReceived"`
+	Connections   int64  `json:"connections"`
+	ActiveConns   int64  `json:"activeConns"`
+	Errors        int64  `json:"errors"`
+}
+
+// newTunnelResponse converts a tunnel and its current status
+func (s *Server) newTunnelResponse(t *tunnel.Tunnel) TunnelResponse {
+	spec := t.Spec
+	resp := TunnelResponse{
+		ID:               spec.ID,
+		Name:             spec.Name,
+		Owner:            spec.Owner,
+		Project:          projectOf(t),
+		AgentID:          spec.AgentID,
+		DesiredStatus:    string(spec.DesiredStatus),
+		Type:             spec.Type,
+		Protocol:         spec.Protocol,
+		Hops:             spec.Hops,
+		LocalPort:        spec.LocalPort,
+		LocalBindAddress: spec.LocalBindAddress,
+		LocalFamily:      spec.LocalFamily,
+		RemoteHost:       spec.RemoteHost,
+		RemotePort:       spec.RemotePort,
+		Targets:          spec.Targets,
+		Balance:          newBalancePolicyResponse(spec.Balance),
+		Ports:            newPortMappingResponses(spec.Ports),
+		Routes:           spec.Routes,
+		SystemProxy:      spec.SystemProxy,
+		PAC:              newPACReq(spec.PAC),
+		TCP:              newTCPOptionsResponse(spec.TCP),
+		Staleness:        StalePolicyResponse{After: spec.Staleness.After.Seconds(), Action: spec.Staleness.Action},
+		Restart:          newRestartPolicyResponse(spec.Restart),
+		Hooks:            newHooksReq(spec.Hooks),
+		DependsOn:        spec.DependsOn,
+		AutoReconnect:    spec.AutoReconnect,
+		KeepAlive:        spec.KeepAlive.Seconds(),
+		KeepAliveMax:     spec.KeepAliveMax,
+		DrainTimeout:     spec.DrainTimeout.Seconds(),
+		MaxRetries:       spec.MaxRetries,
+		Backoff:          newBackoffPolicyResponse(spec.Backoff),
+		Status:           "disconnected",
+		CreatedAt:        t.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:        spec.UpdatedAt.Format(time.RFC3339),
+		DeletedAt:        formatTime(spec.DeletedAt),
+		PublicURL:        s.publicURL(spec),
+		Interpolated:     spec.Interpolated,
+	}
+	if deps := s.manager.Dependencies(spec); len(deps) > 0 {
+		ready := true
+		resp.Dependencies = make([]DependencyResponse, len(deps))
+		for i, dep := range deps {
+			status := "missing"
+			if dep.TunnelID != "" {
+				status = statusName(dep.State)
+			}
+			resp.Dependencies[i] = DependencyResponse{Name: dep.Name, ID: dep.TunnelID, Status: status}
+			ready = ready && dep.State == types.TunnelStateActive
+		}
+		resp.DependenciesUp = &ready
+	}
+
+	status := t.GetStatus()
+	if status == nil {
+		return resp
+	}
+
+	resp.Status = statusName(status.State)
+	resp.ErrorMessage = status.LastError
+	resp.FailureReason = status.FailureReason
+	resp.Problems = status.Problems
+	resp.BoundAddress, resp.BoundPort = status.BoundAddress, status.BoundPort
+	resp.LastActivity = formatTime(status.LastActivity)
+	if status.LastActivity != nil {
+		resp.StaleSeconds = status.StaleSeconds
+	}
+	resp.Stale = status.Stale
+	resp.ForceClosed = status.ForceClosed
+	resp.Restarts = &RestartsResponse{
+		Count:         status.RestartCount,
+		LastHour:      status.RestartsLastHour,
+		LastRestartAt: formatTime(status.LastRestartAt),
+	}
+	resp.NextRetryAt = formatTime(status.NextRetryAt)
+	resp.RetryAttempt, resp.MaxRetryAttempts = status.RetryAttempt, status.MaxRetryAttempts
+	resp.TargetStatus = newTargetStatusResponses(status.Targets)
+	resp.PortStatus = newPortStatusResponses(status.Ports)
+	return resp
+}
+
+// statusName maps a tunnel state to the status names the API reports
+func statusName(state types.TunnelState) string {
+	switch state {
+	case types.TunnelStateActive:
+		return "active"
+	case types.TunnelStatePending:
+		return "connecting"
+	case types.TunnelStateFailed:
+		return "failed"
+	case types.TunnelStateMisconfigured:
+		return "misconfigured"
+	default:
+		return "disconnected"
+	}
+}
+
+// formatTime formats an optional time as RFC3339, nil when unset
+func formatTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	formatted := t.Format(time.RFC3339)
+	return &formatted
+}
+
+func newTCPOptionsResponse(opts types.TCPOptions) TCPOptionsResponse {
+	return TCPOptionsResponse{
+		NoDelay:        opts.NoDelay,
+		KeepAlive:      opts.KeepAlive.Seconds(),
+		ReadBuffer:     opts.ReadBuffer,
+		WriteBuffer:    opts.WriteBuffer,
+		SSHBuffer:      opts.SSHBuffer,
+		ConnectTimeout: opts.ConnectTimeout.Seconds(),
+		IdleTimeout:    opts.IdleTimeout.Seconds(),
+
+		DialRetries:      opts.DialRetries,
+		DialRetryBackoff: opts.DialRetryBackoff.Milliseconds(),
+		HoldTimeout:      opts.HoldTimeout.Seconds(),
+	}
+}
+
+func newBackoffPolicyResponse(policy types.BackoffPolicy) BackoffPolicyResponse {
+	config := tunnel.BackoffConfigFor(policy)
+	return BackoffPolicyResponse{
+		Initial:    config.Initial.Milliseconds(),
+		Max:        config.Max.Milliseconds(),
+		Multiplier: config.Multiplier,
+		Jitter:     config.Jitter,
+	}
+}
+
+func newRestartPolicyResponse(policy types.RestartPolicy) RestartPolicyResponse {
+	mode := policy.Mode
+	if mode == "" {
+		mode = types.RestartNever
+	}
+	return RestartPolicyResponse{
+		Mode:       mode,
+		MaxPerHour: policy.MaxPerHour,
+		Backoff:    policy.Backoff.Seconds(),
+	}
+}
+
+func newBalancePolicyResponse(policy types.BalancePolicy) BalancePolicyResponse {
+	strategy := policy.Strategy
+	if strategy == "" {
+		strategy = types.BalanceRoundRobin
+	}
+	return BalancePolicyResponse{
+		Strategy:            strategy,
+		HealthCheckInterval: policy.HealthCheckInterval.Seconds(),
+	}
+}
+
+func newPortMappingResponses(mappings []types.PortMapping) []PortMappingResponse {
+	if len(mappings) == 0 {
+		return nil
+	}
+	result := make([]PortMappingResponse, len(mappings))
+	for i, mapping := range mappings {
+		result[i] = PortMappingResponse{
+			Name:       mapping.Name,
+			LocalPort:  mapping.LocalPort,
+			RemoteHost: mapping.RemoteHost,
+			RemotePort: mapping.RemotePort,
+		}
+	}
+	return result
+}
+
+func newTargetStatusResponses(targets []types.TargetStatus) []TargetStatusResponse {
+	if len(targets) == 0 {
+		return nil
+	}
+	result := make([]TargetStatusResponse, len(targets))
+	for i, target := range targets {
+		result[i] = TargetStatusResponse{
+			Address:     target.Address,
+			Healthy:     target.Healthy,
+			ActiveConns: target.ActiveConns,
+			Connections: target.Connections,
+			Failures:    target.Failures,
+			LastError:   target.LastError,
+
+			FailureReason: target.FailureReason,
+		}
+	}
+	return result
+}
+
+func newPortStatusResponses(ports []types.PortStatus) []PortStatusResponse {
+	if len(ports) == 0 {
+		return nil
+	}
+	result := make([]PortStatusResponse, len(ports))
+	for i, port := range ports {
+		result[i] = PortStatusResponse{
+			Name:          port.Name,
+			LocalPort:     port.LocalPort,
+			RemoteHost:    port.RemoteHost,
+			RemotePort:    port.RemotePort,
+			BoundAddress:  port.BoundAddress,
+			BytesSent:     port.BytesSent,
+			BytesReceived: port.BytesReceived,
+			Connections:   port.Connections,
+			ActiveConns:   port.ActiveConns,
+			Errors:        port.Errors,
+		}
+	}
+	return result
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// tunnelResponseKeys is the JSON contract of a tunnel; changing it breaks clients
+var tunnelResponseKeys = []string{
+	"agentId", "autoReconnect", "backoff", "balance", "boundAddress", "boundPort",
+	"createdAt", "deletedAt", "desiredStatus", "errorMessage", "hops", "id", "keepAlive",
+	"lastActivity", "localBindAddress", "localFamily", "localPort", "maxRetries", "name", "nextRetryAt",
+	"owner", "portStatus", "ports", "project", "protocol", "publicUrl", "remoteHost",
+	"remotePort", "restart", "restarts", "routes", "stale", "staleSeconds",
+	"staleness", "status", "systemProxy", "targetStatus", "targets", "tcp", "type",
+	"updatedAt",
+}
+
+func TestTunnelResponseContract(t *testing.T) {
+	spec := &types.TunnelSpec{
+		ID:         "dto-1",
+		Name:       "db",
+		Owner:      "alice",
+		Type:       types.TunnelTypeLocal,
+		LocalPort:  5432,
+		RemoteHost: "db.internal",
+		RemotePort: 5432,
+		KeepAlive:  30 * time.Second,
+		Staleness:  types.StalePolicy{After: 10 * time.Minute, Action: types.StaleActionRestart},
+		TCP:        types.TCPOptions{DialRetryBackoff: 250 * time.Millisecond},
+		Backoff:    types.BackoffPolicy{Initial: 200 * time.Millisecond},
+	}
+	s := newTestServer(t, spec)
+	tun, _ := s.manager.Get(spec.ID)
+
+	data, err := json.Marshal(s.newTunnelResponse(tun))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, tunnelResponseKeys) {
+		t.Errorf("Unexpected keys:\n got  %v\n want %v", keys, tunnelResponseKeys)
+	}
+
+	if fields["keepAlive"] != 30.0 {
+		t.Errorf("Expected keepAlive in seconds, got %v", fields["keepAlive"])
+	}
+	if after := fields["staleness"].(map[string]interface{})["after"]; after != 600.0 {
+		t.Errorf("Expected staleness.after in seconds, got %v", after)
+	}
+	if backoff := fields["tcp"].(map[string]interface{})["dialRetryBackoff"]; backoff != 250.0 {
+		t.Errorf("Expected tcp.dialRetryBackoff in milliseconds, got %v", backoff)
+	}
+	if backoff := fields["backoff"].(map[string]interface{}); backoff["initial"] != 200.0 || backoff["max"] != 60000.0 {
+		t.Errorf("Expected the backoff in effect in milliseconds, got %v", backoff)
+	}
+	if mode := fields["restart"].(map[string]interface{})["mode"]; mode != string(types.RestartNever) {
+		t.Errorf("Expected the default restart mode, got %v", mode)
+	}
+
+	tun.UpdateStatus(types.TunnelStateFailed, "agent lost")
+
+	// Every endpoint returning a tunnel serves the same representation
+	fields = nil
+	decodeJSON(t, serve(s.handleGetTunnel, newRequest(http.MethodGet, "/api/v1/tunnels/dto-1", "", nil, map[string]string{"id": spec.ID})), &fields)
+	if fields["status"] != "failed" || fields["errorMessage"] != "agent lost" {
+		t.Errorf("Expected the failed status and its error, got %v %v", fields["status"], fields["errorMessage"])
+	}
+	if fields["lastActivity"] != nil {
+		t.Errorf("Expected null lastActivity while not forwarding, got %v", fields["lastActivity"])
+	}
+
+	var list []map[string]interface{}
+	if decodeJSON(t, serve(s.handleListTunnels, newRequest(http.MethodGet, "/api/v1/tunnels", "", nil, nil)), &list); len(list) != 1 {
+		t.Fatalf("Expected one tunnel, got %v", list)
+	}
+	if len(list[0]) != len(tunnelResponseKeys) || list[0]["errorMessage"] != "agent lost" {
+		t.Errorf("Expected the list to match the tunnel representation, got %v", list[0])
+	}
+}
+
+func TestStatusName(t *testing.T) {
+	tests := map[types.TunnelState]string{
+		types.TunnelStateActive:  "active",
+		types.TunnelStatePending: "connecting",
+		types.TunnelStateFailed:  "failed",
+		types.TunnelStateStopped: "disconnected",
+	}
+	for state, want := range tests {
+		if got := statusName(state); got != want {
+			t.Errorf("statusName(%q) = %q, want %q", state, got, want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -22,6 +23,15 @@ const (
 	ErrCodeServiceUnavailable ErrorCode = "SERVICE_UNAVAILABLE"
 	ErrCodeTimeout            ErrorCode = "TIMEOUT"
 
+	// Raised when an Idempotency-Key is reused for a different request
+	ErrCodeIdempotencyMismatch ErrorCode = "IDEMPOTENCY_KEY_MISMATCH"
+
+	// Raised when creating or starting a tunnel would exceed a quota
+	ErrCodeQuotaExceeded ErrorCode = "QUOTA_EXCEEDED"
+
+	// Raised for unknown WebSocket commands and protocol versions
+	ErrCodeUnsupportedCommand ErrorCode = "UNSUPPORTED_COMMAND"
+
 	// Tunnel-specific errors
 	ErrCodeTunnelNotFound    ErrorCode = "TUNNEL_NOT_FOUND"
 	ErrCodeTunnelExists      ErrorCode = "TUNNEL_EXISTS"
@@ -40,9 +50,11 @@ const (
 
 // ErrorDetail represents additional error details
 type ErrorDetail struct {
-	Field string      `json:"field,omitempty"`
-	Value interface{} `json:"value,omitempty"`
-	Issue string      `json:"issue,omitempty"`
+	Field  string            `json:"field,omitempty"`
+	Value  interface{}       `json:"value,omitempty"`
+	Issue  string            `json:"issue,omitempty"`
+	Code   string            `json:"code,omitempty"`   // of validation failures, see the ValCode constants
+	Params map[string]string `json:"params,omitempty"` // what a validation failure's message refers to
 }
 
 // APIError represents a standardized API error response
@@ -82,13 +94,20 @@ func (e *APIError) Error() string {
 
 // ErrorResponse sends a standardized error response
 func (s *Server) ErrorResponse(w http.ResponseWriter, status int, err *APIError) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if encodeErr := json.NewEncoder(w).Encode(err); encodeErr != nil {
+	if encodeErr := writeAPIError(w, status, err); encodeErr != nil {
 		s.logger.Error().Err(encodeErr).Msg("Failed to encode error response")
 	}
 }
 
+// writeAPIError writes err as the response. Middleware that runs outside
+// the Server, such as authentication and rate limiting, uses it directly so
+// that every error shares the same envelope.
+func writeAPIError(w http.ResponseWriter, status int, err *APIError) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(err)
+}
+
 // Common error response helpers
 
 // InternalError responds with a 500 internal server error
@@ -135,9 +154,17 @@ func (s *Server) ValidationError(w http.ResponseWriter, message string, details
 	if len(details) > 0 {
 		errDetails := make([]ErrorDetail, len(details))
 		for i, d := range details {
+			issue := d.Message
+			if s.translator != nil && d.Code != "" {
+				if translated, ok := s.translator(d.Code, d.Field, d.Params); ok {
+					issue = translated
+				}
+			}
 			errDetails[i] = ErrorDetail{
-				Field: d.Field,
-				Issue: d.Message,
+				Field:  d.Field,
+				Issue:  issue,
+				Code:   d.Code,
+				Params: d.Params,
 			}
 		}
 		err.WithDetails(errDetails...)
@@ -149,7 +176,7 @@ func (s *Server) ValidationError(w http.ResponseWriter, message string, details
 // RateLimitError responds with a 429 rate limit error
 func (s *Server) RateLimitError(w http.ResponseWriter, retryAfter int) {
 	err := NewAPIError(ErrCodeRateLimit, "Rate limit exceeded. Please try again later.")
-	w.Header().Set("Retry-After", string(rune(retryAfter)))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
 	s.ErrorResponse(w, http.StatusTooManyRequests, err)
 }
 
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// respondVersionedJSON sends data with an ETag and answers 304 Not Modified
+// when the client's If-None-Match already names it, so polling clients don't
+// download identical payloads again.
+//
+// The tag combines the manager's state version, which changes on every
+// tunnel mutation, with a digest of the payload, which catches the live
+// counters (traffic, staleness) that move without one. Read version before
+// building data, so a change made meanwhile yields a new tag next time. Tags
+// are weak because compression changes the bytes on the wire.
+func (s *Server) respondVersionedJSON(w http.ResponseWriter, r *http.Request, version uint64, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to encode JSON response")
+		s.InternalError(w, "Failed to encode response")
+		return
+	}
+
+	digest := fnv.New64a()
+	digest.Write(body)
+	etag := fmt.Sprintf(`W/"%d-%x"`, version, digest.Sum64())
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-State-Version", strconv.FormatUint(version, 10))
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, '\n'))
+}
+
+// etagMatches reports whether an If-None-Match header names etag, using the
+// weak comparison RFC 9110 prescribes for it
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestTunnelListETag(t *testing.T) {
+	s := newTestServer(t)
+
+	create := func(id, name string) {
+		t.Helper()
+		createTunnels(t, s.manager, &types.TunnelSpec{ID: id, Name: name, Type: types.TunnelTypeLocal})
+	}
+	list := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := newRequest(http.MethodGet, "/api/v1/tunnels", "", nil, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return serve(s.handleListTunnels, req)
+	}
+
+	create("etag-1", "db")
+	create("etag-2", "cache")
+
+	first := list("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected 200 with an ETag, got %d %q", first.Code, etag)
+	}
+
+	if again := list(""); again.Header().Get("ETag") != etag {
+		t.Errorf("Expected an unchanged list to keep ETag %s, got %s", etag, again.Header().Get("ETag"))
+	}
+
+	notModified := list(etag)
+	if notModified.Code != http.StatusNotModified || notModified.Body.Len() != 0 {
+		t.Errorf("Expected an empty 304 for a current ETag, got %d: %s", notModified.Code, notModified.Body.String())
+	}
+	if stripped := list(etag[len("W/"):]); stripped.Code != http.StatusNotModified {
+		t.Errorf("Expected the weak comparison to ignore W/, got %d", stripped.Code)
+	}
+	if listed := list(`"other", ` + etag); listed.Code != http.StatusNotModified {
+		t.Errorf("Expected a match anywhere in the list, got %d", listed.Code)
+	}
+
+	create("etag-3", "queue")
+	changed := list(etag)
+	if changed.Code != http.StatusOK || changed.Header().Get("ETag") == etag {
+		t.Errorf("Expected a new ETag and body after a mutation, got %d %q", changed.Code, changed.Header().Get("ETag"))
+	}
+}
+
+func TestTunnelStatusETag(t *testing.T) {
+	spec := &types.TunnelSpec{ID: "status-etag", Name: "db", Type: types.TunnelTypeLocal}
+	s := newTestServer(t, spec)
+
+	status := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := newRequest(http.MethodGet, "/api/v1/tunnels/status-etag/status", "", nil, map[string]string{"id": spec.ID})
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		return serve(s.handleGetTunnelStatus, req)
+	}
+
+	etag := status("").Header().Get("ETag")
+	if rec := status(etag); rec.Code != http.StatusNotModified {
+		t.Fatalf("Expected 304, got %d", rec.Code)
+	}
+
+	tunnel, _ := s.manager.Get(spec.ID)
+	tunnel.UpdateStatus(types.TunnelStateActive, "")
+	if rec := status(etag); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 after a state change, got %d", rec.Code)
+	}
+}
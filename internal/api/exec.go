@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultExecTimeout bounds a command when the request doesn't set a timeout
+const defaultExecTimeout = 30 * time.Second
+
+// ExecRequest runs a one-off command on one of a tunnel's hops
+type ExecRequest struct {
+	Command        string `json:"command" validate:"required,max=4096"`
+	Hop            *int   `json:"hop,omitempty" validate:"omitempty,min=0"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty" validate:"omitempty,min=1,max=600"`
+}
+
+// execStream writes a command's output as newline-delimited JSON events.
+// The response header goes out with the first event, so a command that
+// fails before printing anything can still get an error status.
+type execStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	started bool
+}
+
+func (e *execStream) send(event map[string]interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.started {
+		e.w.Header().Set("Content-Type", "application/x-ndjson")
+		e.w.WriteHeader(http.StatusOK)
+		e.started = true
+	}
+	if err := json.NewEncoder(e.w).Encode(event); err != nil {
+		return err
+	}
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (e *execStream) hasStarted() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.started
+}
+
+// execOutput turns writes to one of the command's output streams into events
+type execOutput struct {
+	stream *execStream
+	name   string
+}
+
+func (o execOutput) Write(p []byte) (int, error) {
+	if err := o.stream.send(map[string]interface{}{"stream": o.name, "data": string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// handleExec runs a command on a hop over the tunnel's SSH connection and
+// streams its output. Each line of the response is a JSON event, either
+// {"stream": "stdout"|"stderr", "data": ...} or, last, the command's
+// {"exitCode": ...}.
+func (s *Server) handleExec(w http.ResponseWriter, r *http.Request) {
+	tunnelID := mux.Vars(r)["id"]
+
+	t, err := s.getTunnel(r, tunnelID)
+	if err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+
+	var req ExecRequest
+	if !s.decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	hop := -1
+	if req.Hop != nil {
+		hop = *req.Hop
+	}
+	timeout := defaultExecTimeout
+	if req.TimeoutSeconds > 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+
+	// Streaming may outlast the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	logger := s.requestLogger(r).With().
+		Str("tunnel_id", tunnelID).
+		Int("hop", hop).
+		Str("command", req.Command).
+		Logger()
+
+	stream := &execStream{w: w}
+	start := time.Now()
+	code, err := t.Exec(ctx, hop, req.Command, execOutput{stream, "stdout"}, execOutput{stream, "stderr"})
+	duration := time.Since(start)
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+
+	if err != nil {
+		logger.Warn().Err(err).Dur("duration", duration).Msg("Remote command failed")
+	} else {
+		logger.Info().Int("exit_code", code).Dur("duration", duration).Msg("Remote command executed")
+	}
+
+	if err != nil && !stream.hasStarted() {
+		if timedOut {
+			s.TimeoutError(w, fmt.Sprintf("Command did not finish within %s", timeout))
+		} else {
+			s.hopSessionError(w, tunnelID, err)
+		}
+		return
+	}
+
+	result := map[string]interface{}{
+		"exitCode":   code,
+		"durationMs": duration.Milliseconds(),
+	}
+	if timedOut {
+		result["timedOut"] = true
+		result["error"] = fmt.Sprintf("command did not finish within %s", timeout)
+	} else if err != nil {
+		result["error"] = err.Error()
+	}
+	stream.send(result)
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestExecRequests(t *testing.T) {
+	s := newAuthTestServer(t, &types.TunnelSpec{ID: "exec-1", Name: "bastion", Type: types.TunnelTypeLocal})
+
+	admin := &User{ID: "1", Username: "admin", Roles: []string{"admin"}}
+	viewer := &User{ID: "2", Username: "viewer", Roles: []string{"viewer"}}
+
+	tests := []struct {
+		name string
+		user *User
+		id   string
+		body string
+		want int
+	}{
+		{"no user", nil, "exec-1", `{"command": "ss -ltn"}`, http.StatusForbidden},
+		{"not an admin", viewer, "exec-1", `{"command": "ss -ltn"}`, http.StatusForbidden},
+		{"unknown tunnel", admin, "missing", `{"command": "ss -ltn"}`, http.StatusNotFound},
+		{"missing command", admin, "exec-1", `{}`, http.StatusBadRequest},
+		{"negative hop", admin, "exec-1", `{"command": "ss -ltn", "hop": -1}`, http.StatusBadRequest},
+		{"timeout too long", admin, "exec-1", `{"command": "ss -ltn", "timeoutSeconds": 3600}`, http.StatusBadRequest},
+		{"disconnected", admin, "exec-1", `{"command": "ss -ltn", "hop": 0, "timeoutSeconds": 5}`, http.StatusConflict},
+	}
+
+	handler := s.requireRole("admin", s.handleExec)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(handler, newRequest(http.MethodPost, "/api/v1/tunnels/"+tt.id+"/exec", tt.body,
+				tt.user, map[string]string{"id": tt.id}))
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+// FaultsRequest sets the failures injected into a tunnel, for testing how
+// applications and reconnects cope with them. Zero injects none.
+type FaultsRequest struct {
+	DialDelayMs      int `json:"dialDelayMs" validate:"min=0,max=600000"` // before each connection through the tunnel
+	SOCKSFailPercent int `json:"socksFailPercent" validate:"min=0,max=100"`
+}
+
+// FaultsResponse is the failures injected into a tunnel
+type FaultsResponse struct {
+	TunnelID         string `json:"tunnelId"`
+	DialDelayMs      int    `json:"dialDelayMs"`
+	SOCKSFailPercent int    `json:"socksFailPercent"`
+}
+
+// handleGetFaults returns the failures injected into a tunnel
+func (s *Server) handleGetFaults(w http.ResponseWriter, r *http.Request) {
+	tunnelID := mux.Vars(r)["id"]
+	if _, err := s.getTunnel(r, tunnelID); err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+
+	faults, err := s.manager.Faults(tunnelID)
+	if err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, newFaultsResponse(tunnelID, faults))
+}
+
+// handleSetFaults injects failures into a tunnel from its next connection
+// on, replacing those injected before
+func (s *Server) handleSetFaults(w http.ResponseWriter, r *http.Request) {
+	tunnelID := mux.Vars(r)["id"]
+	if _, err := s.getTunnel(r, tunnelID); err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+	var req FaultsRequest
+	if !s.decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	faults := tunnel.Faults{
+		DialDelay:        time.Duration(req.DialDelayMs) * time.Millisecond,
+		SOCKSFailPercent: req.SOCKSFailPercent,
+	}
+	if err := s.manager.SetFaults(tunnelID, faults); err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+
+	s.requestLogger(r).Warn().
+		Str("tunnel_id", tunnelID).
+		Dur("dial_delay", faults.DialDelay).
+		Int("socks_fail_percent", faults.SOCKSFailPercent).
+		Msg("Injecting faults into tunnel")
+	s.respondJSON(w, http.StatusOK, newFaultsResponse(tunnelID, faults))
+}
+
+// handleClearFaults stops injecting failures into a tunnel
+func (s *Server) handleClearFaults(w http.ResponseWriter, r *http.Request) {
+	tunnelID := mux.Vars(r)["id"]
+	if _, err := s.getTunnel(r, tunnelID); err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+	if err := s.manager.SetFaults(tunnelID, tunnel.Faults{}); err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+
+	s.requestLogger(r).Info().Str("tunnel_id", tunnelID).Msg("Stopped injecting faults into tunnel")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDropConnection tears down the SSH connection to one of a tunnel's
+// hops, ?hop= counting from 0 and defaulting to the first, as if the
+// network had failed
+func (s *Server) handleDropConnection(w http.ResponseWriter, r *http.Request) {
+	tunnelID := mux.Vars(r)["id"]
+	if _, err := s.getTunnel(r, tunnelID); err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+	hop := 0
+	if value := r.URL.Query().Get("hop"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			s.BadRequest(w, "Invalid hop: expected a hop index starting at 0")
+			return
+		}
+		hop = n
+	}
+
+	if err := s.manager.DropConnection(tunnelID, hop); err != nil {
+		if errors.Is(err, tunnel.ErrNotConnected) {
+			s.ConflictError(w, "Tunnel is not connected to that hop")
+			return
+		}
+		s.BadRequest(w, err.Error())
+		return
+	}
+
+	s.requestLogger(r).Warn().Str("tunnel_id", tunnelID).Int("hop", hop).Msg("Dropped tunnel's SSH connection on request")
+
+	t, err := s.getTunnel(r, tunnelID)
+	if err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, s.newTunnelResponse(t))
+}
+
+func newFaultsResponse(tunnelID string, faults tunnel.Faults) FaultsResponse {
+	return FaultsResponse{
+		TunnelID:         tunnelID,
+		DialDelayMs:      int(faults.DialDelay / time.Millisecond),
+		SOCKSFailPercent: faults.SOCKSFailPercent,
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestFaultInjection(t *testing.T) {
+	spec := &types.TunnelSpec{ID: "faulty", Name: "faulty", Type: types.TunnelTypeDynamic,
+		Hops: []types.Hop{{Host: "bastion", Port: 22, User: "ops"}}}
+	s := newTestServer(t, spec)
+	s.faultInjection = true
+	manager := s.manager
+
+	send := func(handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+		return serve(handler, newRequest(method, target, body, nil, map[string]string{"id": spec.ID}))
+	}
+	faultsURL := "/api/v1/tunnels/" + spec.ID + "/faults"
+
+	rec := send(s.handleSetFaults, http.MethodPut, faultsURL, `{"dialDelayMs":250,"socksFailPercent":30}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("set status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if faults, _ := manager.Faults(spec.ID); faults.DialDelay != 250*time.Millisecond || faults.SOCKSFailPercent != 30 {
+		t.Errorf("injected faults = %+v", faults)
+	}
+
+	rec = send(s.handleGetFaults, http.MethodGet, faultsURL, "")
+	var got FaultsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || got.DialDelayMs != 250 || got.SOCKSFailPercent != 30 {
+		t.Errorf("get = %s, %v", rec.Body.String(), err)
+	}
+
+	if rec := send(s.handleSetFaults, http.MethodPut, faultsURL, `{"socksFailPercent":150}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("set with an invalid percentage status = %d, want 400", rec.Code)
+	}
+
+	// The tunnel runs on an agent, so there is no connection here to drop
+	if rec := send(s.handleDropConnection, http.MethodPost, faultsURL+"/drop?hop=0", ""); rec.Code != http.StatusConflict {
+		t.Errorf("drop without a connection status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(s.handleDropConnection, http.MethodPost, faultsURL+"/drop?hop=-1", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("drop with an invalid hop status = %d, want 400", rec.Code)
+	}
+
+	if rec := send(s.handleClearFaults, http.MethodDelete, faultsURL, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("clear status = %d", rec.Code)
+	}
+	if faults, _ := manager.Faults(spec.ID); faults != (tunnel.Faults{}) {
+		t.Errorf("faults after clearing = %+v, want none", faults)
+	}
+}
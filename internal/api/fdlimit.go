@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// FDReport is the file descriptor usage of the server
+type FDReport struct {
+	tunnel.FDUsage
+
+	// Held by the tunnels while no connection goes through them
+	TunnelBaseline int `json:"tunnelBaseline"`
+}
+
+// fdReport returns the current file descriptor usage
+func (s *Server) fdReport() FDReport {
+	return FDReport{FDUsage: s.fdGuard.Usage(), TunnelBaseline: s.tunnelBaselineFDs()}
+}
+
+// tunnelBaselineFDs estimates the descriptors the tunnels hold at rest,
+// counting those this server runs; agents run the others
+func (s *Server) tunnelBaselineFDs() int {
+	var specs []*types.TunnelSpec
+	for _, t := range s.manager.List() {
+		if t.Spec.AgentID == "" {
+			specs = append(specs, t.Spec)
+		}
+	}
+	return tunnel.BaselineFDs(specs)
+}
+
+// logFDLimit logs the file descriptor limit at startup, and warns when the
+// tunnels, and the ports agents may forward through the SSH server, could
+// need more descriptors than the limit leaves before rejecting connections
+func (s *Server) logFDLimit(forwardPorts int) {
+	report := s.fdReport()
+	if report.Limit == 0 {
+		return
+	}
+	s.logger.Info().
+		Uint64("limit", report.Limit).
+		Uint64("hard_limit", report.HardLimit).
+		Int("open", report.Open).
+		Int64("threshold", report.Threshold).
+		Msg("File descriptor limit")
+
+	usable := int64(report.Limit)
+	if report.Threshold > 0 {
+		usable = report.Threshold
+	}
+	if needed := int64(report.Open + report.TunnelBaseline + forwardPorts); needed > usable {
+		s.logger.Warn().
+			Int64("needed", needed).
+			Int64("usable", usable).
+			Int("tunnels", report.TunnelBaseline).
+			Int("forward_ports", forwardPorts).
+			Msg("Tunnels may run out of file descriptors before accepting any connection; raise the limit with ulimit -n or LimitNOFILE=")
+	}
+}
+
+// handleGetSystemFDs returns the open file descriptors against the limit,
+// and how many connections were rejected for nearing it
+func (s *Server) handleGetSystemFDs(w http.ResponseWriter, r *http.Request) {
+	s.respondJSON(w, http.StatusOK, s.fdReport())
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestGetSystemFDs(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, &types.TunnelSpec{ID: "t1", Name: "db", Type: types.TunnelTypeLocal})
+	if err := s.manager.Create(ctx, &types.TunnelSpec{ID: "t2", Name: "web", Type: types.TunnelTypeLocal}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	s.fdGuard = tunnel.NewFDGuard(10)
+
+	rec := httptest.NewRecorder()
+	s.handleGetSystemFDs(rec, httptest.NewRequest(http.MethodGet, "/api/v1/system/fds", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var report FDReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	// Only the tunnel this server runs: its SSH connection and listener
+	if report.TunnelBaseline != 2 {
+		t.Errorf("tunnelBaseline = %d, want 2", report.TunnelBaseline)
+	}
+	if limit, _, ok := tunnel.FDLimit(); ok && (report.Limit != limit || report.Open <= 0) {
+		t.Errorf("report = %+v, want the limit %d and the open descriptors", report, limit)
+	}
+}
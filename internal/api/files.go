@@ -0,0 +1,137 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+// maxUploadSize caps files uploaded through a tunnel's SSH session
+const maxUploadSize = 256 << 20
+
+// fileTransferParams reads the path and hop query parameters shared by
+// uploads and downloads. The hop defaults to the last one.
+func (s *Server) fileTransferParams(w http.ResponseWriter, r *http.Request) (string, int, bool) {
+	query := r.URL.Query()
+
+	remotePath := query.Get("path")
+	if remotePath == "" || !path.IsAbs(remotePath) {
+		s.BadRequest(w, "path is required and must be absolute")
+		return "", 0, false
+	}
+
+	hop := -1
+	if value := query.Get("hop"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			s.BadRequest(w, "Invalid hop: expected a hop index starting at 0")
+			return "", 0, false
+		}
+		hop = n
+	}
+
+	return remotePath, hop, true
+}
+
+// handleDownloadFile streams a file from a hop over the tunnel's SSH
+// session. It reads as the tunnel owner's SSH login, so only they and
+// admins may.
+func (s *Server) handleDownloadFile(w http.ResponseWriter, r *http.Request) {
+	tunnelID := mux.Vars(r)["id"]
+
+	t, ok := s.ownedTunnel(w, r, tunnelID)
+	if !ok {
+		return
+	}
+	remotePath, hop, ok := s.fileTransferParams(w, r)
+	if !ok {
+		return
+	}
+
+	file, err := t.Download(hop, remotePath)
+	if err != nil {
+		s.hopSessionError(w, tunnelID, err)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name))
+	w.Header().Set("X-File-Mode", fmt.Sprintf("%04o", file.Mode))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, file); err != nil {
+		s.requestLogger(r).Warn().Err(err).Str("tunnel_id", tunnelID).Str("path", remotePath).Msg("File download interrupted")
+	}
+}
+
+// handleUploadFile writes the request body to a file on a hop over the
+// tunnel's SSH session. The size must be known up front, so a
+// Content-Length header is required.
+func (s *Server) handleUploadFile(w http.ResponseWriter, r *http.Request) {
+	tunnelID := mux.Vars(r)["id"]
+
+	t, ok := s.ownedTunnel(w, r, tunnelID)
+	if !ok {
+		return
+	}
+	remotePath, hop, ok := s.fileTransferParams(w, r)
+	if !ok {
+		return
+	}
+
+	mode := os.FileMode(0644)
+	if value := r.URL.Query().Get("mode"); value != "" {
+		n, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || n > 0777 {
+			s.BadRequest(w, "Invalid mode: expected octal permissions such as 0644")
+			return
+		}
+		mode = os.FileMode(n)
+	}
+
+	if r.ContentLength < 0 {
+		s.ErrorResponse(w, http.StatusLengthRequired, NewAPIError(ErrCodeBadRequest, "Content-Length is required"))
+		return
+	}
+	if r.ContentLength > maxUploadSize {
+		s.ErrorResponse(w, http.StatusRequestEntityTooLarge,
+			NewAPIError(ErrCodeBadRequest, fmt.Sprintf("File exceeds the %d MiB upload limit", maxUploadSize>>20)))
+		return
+	}
+
+	if err := t.Upload(hop, remotePath, mode, r.ContentLength, r.Body); err != nil {
+		s.hopSessionError(w, tunnelID, err)
+		return
+	}
+
+	s.requestLogger(r).Info().
+		Str("tunnel_id", tunnelID).
+		Str("path", remotePath).
+		Int64("size", r.ContentLength).
+		Msg("File uploaded through tunnel")
+
+	s.respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"path": remotePath,
+		"size": r.ContentLength,
+		"mode": fmt.Sprintf("%04o", mode),
+	})
+}
+
+// hopSessionError maps a failure to use a tunnel's SSH connection to a response
+func (s *Server) hopSessionError(w http.ResponseWriter, tunnelID string, err error) {
+	if errors.Is(err, tunnel.ErrNotConnected) {
+		s.ConflictError(w, "Tunnel is not connected; start it first")
+		return
+	}
+	s.TunnelConnectionError(w, tunnelID, err.Error())
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestFileTransferRequests(t *testing.T) {
+	s := newTestServer(t, &types.TunnelSpec{ID: "files-1", Name: "bastion", Type: types.TunnelTypeLocal})
+
+	tests := []struct {
+		name     string
+		method   string
+		id       string
+		query    string
+		body     string
+		noLength bool
+		want     int
+	}{
+		{"unknown tunnel", http.MethodGet, "missing", "path=/etc/hosts", "", false, http.StatusNotFound},
+		{"relative path", http.MethodGet, "files-1", "path=etc/hosts", "", false, http.StatusBadRequest},
+		{"invalid hop", http.MethodGet, "files-1", "path=/etc/hosts&hop=-2", "", false, http.StatusBadRequest},
+		{"invalid mode", http.MethodPut, "files-1", "path=/tmp/app.yaml&mode=999", "x", false, http.StatusBadRequest},
+		{"unknown length", http.MethodPut, "files-1", "path=/tmp/app.yaml", "x", true, http.StatusLengthRequired},
+		{"download while disconnected", http.MethodGet, "files-1", "path=/etc/hosts", "", false, http.StatusConflict},
+		{"upload while disconnected", http.MethodPut, "files-1", "path=/tmp/app.yaml&mode=0600", "x", false, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newRequest(tt.method, "/api/v1/tunnels/"+tt.id+"/files?"+tt.query, tt.body, nil, map[string]string{"id": tt.id})
+			if tt.noLength {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+
+			if tt.method == http.MethodPut {
+				s.handleUploadFile(rec, req)
+			} else {
+				s.handleDownloadFile(rec, req)
+			}
+
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -5,14 +5,21 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 
+	"github.com/craigderington/lazytunnel/internal/storage"
+	"github.com/craigderington/lazytunnel/internal/tunnel"
 	"github.com/craigderington/lazytunnel/pkg/types"
 )
 
@@ -23,6 +30,7 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		"status":  "healthy",
 		"time":    time.Now().UTC(),
 		"version": "dev",
+		"role":    s.role(),
 	}
 
 	// Check tunnel manager
@@ -50,66 +58,148 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	s.respondJSON(w, http.StatusOK, health)
 }
 
-// handleListTunnels returns all active tunnels
+// handleListTunnels returns all active tunnels, optionally only those of
+// one owner (?owner=alice) or of the caller (?mine=true), and deleted ones
+// too with ?includeDeleted=true
 func (s *Server) handleListTunnels(w http.ResponseWriter, r *http.Request) {
-	tunnels := s.manager.List()
+	owner, ok := s.ownerFilter(w, r)
+	if !ok {
+		return
+	}
+	includeDeleted, ok := s.queryBool(w, r, "includeDeleted")
+	if !ok {
+		return
+	}
 
-	response := make([]map[string]interface{}, len(tunnels))
-	for i, t := range tunnels {
-		status := t.GetStatus()
-		var statusStr string
-		var errorMsg string
+	version := s.manager.Version()
+	tunnels := s.listTunnels(r)
+	if includeDeleted {
+		tunnels = append(tunnels, s.listDeletedTunnels(r)...)
+	}
+	if owner != "" {
+		tunnels = slices.DeleteFunc(tunnels, func(t *tunnel.Tunnel) bool { return t.Spec.Owner != owner })
+	}
 
-		if status != nil {
-			switch status.State {
-			case types.TunnelStateActive:
-				statusStr = "active"
-			case types.TunnelStatePending:
-				statusStr = "connecting"
-			case types.TunnelStateFailed:
-				statusStr = "failed"
-			case types.TunnelStateStopped:
-				statusStr = "disconnected"
-			default:
-				statusStr = "disconnected"
-			}
-			errorMsg = status.LastError
-		} else {
-			statusStr = "disconnected"
+	// A stable order keeps the ETag stable between identical polls
+	sort.Slice(tunnels, func(i, j int) bool {
+		if !tunnels[i].CreatedAt.Equal(tunnels[j].CreatedAt) {
+			return tunnels[i].CreatedAt.Before(tunnels[j].CreatedAt)
 		}
+		return tunnels[i].Spec.ID < tunnels[j].Spec.ID
+	})
 
-		response[i] = map[string]interface{}{
-			"id":               t.Spec.ID,
-			"name":             t.Spec.Name,
-			"owner":            t.Spec.Owner,
-			"agentId":          t.Spec.AgentID,
-			"desiredStatus":    string(t.Spec.DesiredStatus),
-			"type":             t.Spec.Type,
-			"hops":             t.Spec.Hops,
-			"localPort":        t.Spec.LocalPort,
-			"localBindAddress": t.Spec.LocalBindAddress,
-			"remoteHost":       t.Spec.RemoteHost,
-			"remotePort":       t.Spec.RemotePort,
-			"autoReconnect":    t.Spec.AutoReconnect,
-			"keepAlive":        t.Spec.KeepAlive.Seconds(),
-			"maxRetries":       t.Spec.MaxRetries,
-			"status":           statusStr,
-			"createdAt":        t.CreatedAt.Format(time.RFC3339),
-			"updatedAt":        t.Spec.UpdatedAt.Format(time.RFC3339),
-			"errorMessage":     errorMsg,
-		}
+	response := make([]TunnelResponse, len(tunnels))
+	for i, t := range tunnels {
+		response[i] = s.newTunnelResponse(t)
 	}
 
-	s.respondJSON(w, http.StatusOK, response)
+	s.respondVersionedJSON(w, r, version, response)
 }
 
 // handleCreateTunnel creates a new tunnel
 func (s *Server) handleCreateTunnel(w http.ResponseWriter, r *http.Request) {
 	var req CreateTunnelRequest
-	if !s.decodeAndValidate(w, r, &req) {
+	if !s.decodeRequest(w, r, &req) {
+		return
+	}
+	// Settings the request leaves to the match rules count as given
+	s.tunnelDefaults.applyMatchRules(&req)
+	if !s.validateRequest(w, &req) {
 		return
 	}
 
+	// Validation restricts exposure to remote tunnels; the server must also allow it
+	if req.Expose && s.exposure == nil {
+		s.BadRequest(w, "Public exposure is not enabled on this server")
+		return
+	}
+	if !s.mayRunHookCommands(r, req.Hooks) {
+		s.Forbidden(w, "Command hooks require the admin role")
+		return
+	}
+	if setting, ok := s.mayChangeHost(r, &req); !ok {
+		s.Forbidden(w, "Creating "+setting+" requires the admin role")
+		return
+	}
+
+	spec := s.newTunnelSpec(&req, requestOwner(r))
+	spec.Project = requestProject(r)
+	if err := s.checkAddresses(r, &spec); err != nil {
+		s.ValidationError(w, "Validation failed", []ValidationError{{Field: "Host", Code: ValCodeReference, Message: err.Error()}})
+		return
+	}
+	if err := s.checkHopKeys(r, spec.Hops); err != nil {
+		s.ValidationError(w, "Validation failed", []ValidationError{{Field: "KeyID", Code: ValCodeReference, Message: err.Error()}})
+		return
+	}
+	if err := s.resolveVia(&spec); err != nil {
+		s.ValidationError(w, "Validation failed", []ValidationError{{Field: "Via", Code: ValCodeReference, Message: err.Error()}})
+		return
+	}
+	if err := s.resolveAttach(&spec); err != nil {
+		s.ValidationError(w, "Validation failed", []ValidationError{{Field: "Attach", Code: ValCodeReference, Message: err.Error()}})
+		return
+	}
+	if err := s.manager.CheckDependencies(&spec); err != nil {
+		s.ValidationError(w, "Validation failed", []ValidationError{{Field: "DependsOn", Code: ValCodeReference, Message: err.Error()}})
+		return
+	}
+
+	// Reserve a public subdomain before persisting so it is stored with the spec
+	if req.Expose {
+		subdomain, err := s.exposure.Assign(spec.ID, req.Subdomain, spec.RemotePort)
+		if err != nil {
+			s.ConflictError(w, err.Error())
+			return
+		}
+		spec.PublicSubdomain = subdomain
+	}
+
+	// Create tunnel with background context (not request context!)
+	// Using context.Background() so tunnel lives beyond HTTP request
+	if err := s.manager.Create(context.Background(), &spec); err != nil {
+		if s.exposure != nil {
+			s.exposure.Release(spec.ID)
+		}
+		if errors.Is(err, tunnel.ErrNameExists) || errors.Is(err, storage.ErrNameConflict) {
+			s.TunnelExists(w, spec.Name)
+			return
+		}
+		if s.quotaError(w, err) {
+			return
+		}
+		s.requestLogger(r).Error().Err(err).Str("tunnel_id", spec.ID).Msg("Failed to create tunnel")
+		s.InternalError(w, "Failed to create tunnel")
+		return
+	}
+
+	s.requestLogger(r).Info().
+		Str("tunnel_id", spec.ID).
+		Str("name", spec.Name).
+		Str("type", string(spec.Type)).
+		Msg("Tunnel created, connecting in background")
+
+	for _, hop := range spec.Hops {
+		if hop.ForwardAgent {
+			s.requestLogger(r).Warn().
+				Str("tunnel_id", spec.ID).
+				Str("hop", hop.Host).
+				Msg("SSH agent forwarding enabled; anyone with root on this hop can use the agent while the tunnel is connected")
+		}
+	}
+
+	// Status is "connecting" initially, then transitions to "active" or "failed"
+	created, err := s.manager.Get(spec.ID)
+	if err != nil {
+		s.TunnelNotFound(w, spec.ID)
+		return
+	}
+	s.respondJSON(w, http.StatusCreated, s.newTunnelResponse(created))
+}
+
+// newTunnelSpec builds the spec of a new tunnel from a validated request,
+// filling unset settings with the server's defaults
+func (s *Server) newTunnelSpec(req *CreateTunnelRequest, owner string) types.TunnelSpec {
 	// Convert validated hops to types.Hop
 	hops := make([]types.Hop, len(req.Hops))
 	for i, h := range req.Hops {
@@ -120,13 +210,76 @@ func (s *Server) handleCreateTunnel(w http.ResponseWriter, r *http.Request) {
 			AuthMethod:          types.AuthMethod(h.AuthMethod),
 			KeyID:               h.KeyID,
 			HostKeyVerification: types.HostKeyVerifyStrict, // Default to strict verification
+			ForwardAgent:        h.ForwardAgent,
+			KeyboardInteractive: h.KeyboardInteractive,
+			Via:                 h.Via,
+			Attach:              h.Attach,
+			Transport:           types.HopTransport(h.Transport),
+			RelayURL:            h.RelayURL,
 		}
 	}
 
-	// Determine owner from context if authenticated
-	owner := "api-user"
-	if user, ok := GetUser(r.Context()); ok {
-		owner = user.Username
+	// Convert socket tuning options
+	var tcpOpts types.TCPOptions
+	if req.TCP != nil {
+		tcpOpts = types.TCPOptions{
+			NoDelay:        req.TCP.NoDelay,
+			KeepAlive:      time.Duration(req.TCP.KeepAlive) * time.Second,
+			ReadBuffer:     req.TCP.ReadBuffer,
+			WriteBuffer:    req.TCP.WriteBuffer,
+			SSHBuffer:      req.TCP.SSHBuffer,
+			ConnectTimeout: time.Duration(req.TCP.ConnectTimeout) * time.Second,
+			IdleTimeout:    time.Duration(req.TCP.IdleTimeout) * time.Second,
+
+			DialRetries:      req.TCP.DialRetries,
+			DialRetryBackoff: time.Duration(req.TCP.DialRetryBackoff) * time.Millisecond,
+			HoldTimeout:      time.Duration(req.TCP.HoldTimeout) * time.Second,
+		}
+	}
+
+	var staleness types.StalePolicy
+	if req.Staleness != nil {
+		staleness = types.StalePolicy{
+			After:  time.Duration(req.Staleness.After) * time.Second,
+			Action: types.StaleAction(req.Staleness.Action),
+		}
+	}
+
+	var ports []types.PortMapping
+	for _, mapping := range req.Ports {
+		ports = append(ports, types.PortMapping{
+			Name:       SanitizeString(mapping.Name),
+			LocalPort:  mapping.LocalPort,
+			RemoteHost: mapping.RemoteHost,
+			RemotePort: mapping.RemotePort,
+		})
+	}
+
+	var balance types.BalancePolicy
+	if req.Balance != nil {
+		balance = types.BalancePolicy{
+			Strategy:            types.BalanceStrategy(req.Balance.Strategy),
+			HealthCheckInterval: time.Duration(req.Balance.HealthCheckInterval) * time.Second,
+		}
+	}
+
+	var restart types.RestartPolicy
+	if req.Restart != nil {
+		restart = types.RestartPolicy{
+			Mode:       types.RestartMode(req.Restart.Mode),
+			MaxPerHour: req.Restart.MaxPerHour,
+			Backoff:    time.Duration(req.Restart.Backoff) * time.Second,
+		}
+	}
+
+	var backoff types.BackoffPolicy
+	if req.Backoff != nil {
+		backoff = types.BackoffPolicy{
+			Initial:    time.Duration(req.Backoff.Initial) * time.Millisecond,
+			Max:        time.Duration(req.Backoff.Max) * time.Millisecond,
+			Multiplier: req.Backoff.Multiplier,
+			Jitter:     req.Backoff.Jitter,
+		}
 	}
 
 	// Build spec
@@ -135,62 +288,63 @@ func (s *Server) handleCreateTunnel(w http.ResponseWriter, r *http.Request) {
 		Name:             SanitizeString(req.Name),
 		Owner:            owner,
 		Type:             types.TunnelType(req.Type),
+		Protocol:         types.Protocol(req.Protocol),
 		Hops:             hops,
 		LocalPort:        req.LocalPort,
 		LocalBindAddress: req.LocalBindAddress,
+		LocalFamily:      types.AddressFamily(req.LocalFamily),
 		RemoteHost:       req.RemoteHost,
 		RemotePort:       req.RemotePort,
-		AutoReconnect:    req.AutoReconnect,
+		Targets:          req.Targets,
+		Balance:          balance,
+		Ports:            ports,
+		Routes:           req.Routes,
+		SystemProxy:      req.SystemProxy,
+		PAC:              newPACPolicy(req.PAC),
+		TCP:              tcpOpts,
+		Staleness:        staleness,
+		Restart:          restart,
+		Hooks:            newHooks(req.Hooks),
+		DependsOn:        req.DependsOn,
 		KeepAlive:        time.Duration(req.KeepAlive) * time.Second,
+		KeepAliveMax:     req.KeepAliveMax,
+		DrainTimeout:     time.Duration(req.DrainTimeout) * time.Second,
 		MaxRetries:       req.MaxRetries,
+		Backoff:          backoff,
 		AgentID:          req.AgentID,
+		Interpolated:     req.Interpolated,
 		CreatedAt:        time.Now(),
 		UpdatedAt:        time.Now(),
 	}
 
 	// Set defaults
+	defaults := s.tunnelDefaults.withFallbacks()
+	spec.AutoReconnect = defaults.AutoReconnect
+	if req.AutoReconnect != nil {
+		spec.AutoReconnect = *req.AutoReconnect
+	}
 	if spec.KeepAlive == 0 {
-		spec.KeepAlive = 30 * time.Second
+		spec.KeepAlive = defaults.KeepAlive
 	}
 	if spec.MaxRetries == 0 {
-		spec.MaxRetries = 5
+		spec.MaxRetries = defaults.MaxRetries
 	}
-
-	// Create tunnel with background context (not request context!)
-	// Using context.Background() so tunnel lives beyond HTTP request
-	if err := s.manager.Create(context.Background(), &spec); err != nil {
-		s.logger.Error().Err(err).Str("tunnel_id", spec.ID).Msg("Failed to create tunnel")
-		s.InternalError(w, "Failed to create tunnel")
-		return
+	if spec.LocalBindAddress == "" && spec.Type != types.TunnelTypeRemote {
+		spec.LocalBindAddress = defaults.BindAddress
+	}
+	// Brackets only delimit an IPv6 address. The default is left to the
+	// listener when it isn't of the address family asked for.
+	if bind, err := types.ParseBindAddress(spec.LocalBindAddress); err == nil {
+		spec.LocalBindAddress = bind
+		if req.LocalBindAddress == "" && !spec.LocalFamily.Allows(bind) {
+			spec.LocalBindAddress = ""
+		}
+	}
+	if spec.TCP.IdleTimeout == 0 {
+		spec.TCP.IdleTimeout = defaults.IdleTimeout
 	}
 
-	s.logger.Info().
-		Str("tunnel_id", spec.ID).
-		Str("name", spec.Name).
-		Str("type", string(spec.Type)).
-		Msg("Tunnel created, connecting in background")
-
-	// Return the created tunnel in the format the frontend expects
-	// Status will be "connecting" initially, then transition to "active" or "failed"
-	s.respondJSON(w, http.StatusCreated, map[string]interface{}{
-		"id":               spec.ID,
-		"name":             spec.Name,
-		"owner":            spec.Owner,
-		"agentId":          spec.AgentID,
-		"desiredStatus":    string(spec.DesiredStatus),
-		"type":             spec.Type,
-		"hops":             spec.Hops,
-		"localPort":        spec.LocalPort,
-		"localBindAddress": spec.LocalBindAddress,
-		"remoteHost":       spec.RemoteHost,
-		"remotePort":       spec.RemotePort,
-		"autoReconnect":    spec.AutoReconnect,
-		"keepAlive":        spec.KeepAlive.Seconds(),
-		"maxRetries":       spec.MaxRetries,
-		"status":           "connecting", // Connecting in background
-		"createdAt":        spec.CreatedAt.Format(time.RFC3339),
-		"updatedAt":        spec.UpdatedAt.Format(time.RFC3339),
-	})
+	return spec
 }
 
 // handleGetTunnel returns details for a specific tunnel
@@ -198,89 +352,198 @@ func (s *Server) handleGetTunnel(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tunnelID := vars["id"]
 
-	tunnel, err := s.manager.Get(tunnelID)
-	if err != nil {
+	owner, ok := s.ownerFilter(w, r)
+	if !ok {
+		return
+	}
+	includeDeleted, ok := s.queryBool(w, r, "includeDeleted")
+	if !ok {
+		return
+	}
+
+	version := s.manager.Version()
+	tunnel, err := s.getTunnel(r, tunnelID)
+	if err != nil && includeDeleted {
+		tunnel, err = s.getDeletedTunnel(r, tunnelID)
+	}
+	if err != nil || (owner != "" && tunnel.Spec.Owner != owner) {
 		s.TunnelNotFound(w, tunnelID)
 		return
 	}
 
-	status := tunnel.GetStatus()
-	var statusStr string
-	var errorMsg string
-
-	if status != nil {
-		switch status.State {
-		case types.TunnelStateActive:
-			statusStr = "active"
-		case types.TunnelStatePending:
-			statusStr = "connecting"
-		case types.TunnelStateFailed:
-			statusStr = "failed"
-		case types.TunnelStateStopped:
-			statusStr = "disconnected"
-		default:
-			statusStr = "disconnected"
+	s.respondVersionedJSON(w, r, version, s.newTunnelResponse(tunnel))
+}
+
+// ownerFilter returns the owner named by ?owner= or, with ?mine=true, the
+// caller; empty when neither is given
+func (s *Server) ownerFilter(w http.ResponseWriter, r *http.Request) (string, bool) {
+	owner := r.URL.Query().Get("owner")
+	mine, ok := s.queryBool(w, r, "mine")
+	if !ok {
+		return "", false
+	}
+	if mine {
+		if owner != "" && owner != requestOwner(r) {
+			s.BadRequest(w, "owner and mine=true name different owners")
+			return "", false
 		}
-		errorMsg = status.LastError
-	} else {
-		statusStr = "disconnected"
+		owner = requestOwner(r)
 	}
+	return owner, true
+}
 
-	s.respondJSON(w, http.StatusOK, map[string]interface{}{
-		"id":               tunnel.Spec.ID,
-		"name":             tunnel.Spec.Name,
-		"owner":            tunnel.Spec.Owner,
-		"type":             tunnel.Spec.Type,
-		"hops":             tunnel.Spec.Hops,
-		"localPort":        tunnel.Spec.LocalPort,
-		"localBindAddress": tunnel.Spec.LocalBindAddress,
-		"remoteHost":       tunnel.Spec.RemoteHost,
-		"remotePort":       tunnel.Spec.RemotePort,
-		"autoReconnect":    tunnel.Spec.AutoReconnect,
-		"keepAlive":        tunnel.Spec.KeepAlive.Seconds(),
-		"maxRetries":       tunnel.Spec.MaxRetries,
-		"status":           statusStr,
-		"createdAt":        tunnel.CreatedAt.Format(time.RFC3339),
-		"updatedAt":        tunnel.Spec.UpdatedAt.Format(time.RFC3339),
-		"errorMessage":     errorMsg,
-	})
+// queryBool parses an optional boolean query parameter, false when absent
+func (s *Server) queryBool(w http.ResponseWriter, r *http.Request, name string) (bool, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, true
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		s.BadRequest(w, fmt.Sprintf("Invalid %s: expected true or false", name))
+		return false, false
+	}
+	return parsed, true
 }
 
-// handleGetTunnelStatus returns status for a specific tunnel
+// maxStatusWait caps how long a status request may long-poll
+const maxStatusWait = 2 * time.Minute
+
+// handleGetTunnelStatus returns status for a specific tunnel. With ?wait=30s
+// it long-polls for clients that can't use the WebSocket: the response is
+// held until the status changes or the wait runs out. Passing an earlier
+// response's X-State-Version as ?since= returns at once if the status has
+// changed after it, so nothing is missed between polls.
 func (s *Server) handleGetTunnelStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tunnelID := vars["id"]
 
-	tunnel, err := s.manager.Get(tunnelID)
+	wait, since, err := parseStatusWait(r)
+	if err != nil {
+		s.BadRequest(w, err.Error())
+		return
+	}
+
+	if wait > 0 {
+		if _, err := s.getTunnel(r, tunnelID); err != nil {
+			s.TunnelNotFound(w, tunnelID)
+			return
+		}
+
+		changedAt, changed := s.manager.WatchStatus(tunnelID)
+		if since == 0 || changedAt <= since {
+			// Waiting may outlast the server's write timeout
+			http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 5*time.Second))
+
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-changed:
+			case <-timer.C:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+
+	version := s.manager.Version()
+	tunnel, err := s.getTunnel(r, tunnelID)
 	if err != nil {
 		s.TunnelNotFound(w, tunnelID)
 		return
 	}
 
 	status := tunnel.GetStatus()
-	s.respondJSON(w, http.StatusOK, status)
+	s.respondVersionedJSON(w, r, version, status)
+}
+
+// parseStatusWait reads the long-poll parameters of a status request. The
+// wait is a duration ("30s") or a number of seconds, capped at maxStatusWait.
+func parseStatusWait(r *http.Request) (time.Duration, uint64, error) {
+	query := r.URL.Query()
+
+	var wait time.Duration
+	if value := query.Get("wait"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			wait = time.Duration(seconds) * time.Second
+		} else if wait, err = time.ParseDuration(value); err != nil {
+			return 0, 0, fmt.Errorf("wait %q: expected a duration such as 30s", value)
+		}
+		if wait < 0 {
+			return 0, 0, fmt.Errorf("wait must not be negative")
+		}
+		wait = min(wait, maxStatusWait)
+	}
+
+	var since uint64
+	if value := query.Get("since"); value != "" {
+		var err error
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("since %q: expected a state version", value)
+		}
+	}
+
+	return wait, since, nil
 }
 
-// handleDeleteTunnel stops and deletes a tunnel (removes from manager)
+// handleDeleteTunnel stops and soft-deletes a tunnel, so it can still be
+// restored; with ?purge=true it is removed for good, deleted or not. With
+// ?force=true active connections are closed instead of drained, and how
+// many is reported in the X-Force-Closed header.
 func (s *Server) handleDeleteTunnel(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tunnelID := vars["id"]
 
-	err := s.manager.Delete(context.Background(), tunnelID)
+	purge, ok := s.queryBool(w, r, "purge")
+	if !ok {
+		return
+	}
+	force, ok := s.queryBool(w, r, "force")
+	if !ok {
+		return
+	}
+
+	t, lookupErr := s.getTunnel(r, tunnelID)
+	if lookupErr != nil && purge {
+		t, lookupErr = s.getDeletedTunnel(r, tunnelID)
+	}
+	if _, ok := s.checkOwned(w, r, tunnelID, t, lookupErr); !ok {
+		return
+	}
+
+	// Dependents would wait for the tunnel forever
+	if dependents := s.manager.Dependents(t.Spec); len(dependents) > 0 {
+		s.ConflictError(w, fmt.Sprintf("Tunnels depend on %s: %s", t.Spec.Name, strings.Join(dependents, ", ")))
+		return
+	}
+
+	var err error
+	var forceClosed int
+	if purge {
+		err = s.manager.Purge(r.Context(), tunnelID)
+	} else {
+		forceClosed, err = s.manager.DeleteWith(r.Context(), tunnelID, tunnel.StopOptions{Force: force})
+	}
+	if s.exposure != nil {
+		s.exposure.Release(tunnelID)
+	}
 	if err != nil {
 		// Check if it's a "not found" error - that's a real error
 		if err.Error() == fmt.Sprintf("tunnel %s not found", tunnelID) {
-			s.logger.Error().Err(err).Str("tunnel_id", tunnelID).Msg("Tunnel not found")
+			s.requestLogger(r).Error().Err(err).Str("tunnel_id", tunnelID).Msg("Tunnel not found")
 			s.TunnelNotFound(w, tunnelID)
 			return
 		}
 		// Otherwise, tunnel was deleted but had stop errors (e.g. already failed)
 		// Log the error but return success
-		s.logger.Warn().Err(err).Str("tunnel_id", tunnelID).Msg("Tunnel deleted with warnings")
+		s.requestLogger(r).Warn().Err(err).Str("tunnel_id", tunnelID).Msg("Tunnel deleted with warnings")
 	} else {
-		s.logger.Info().Str("tunnel_id", tunnelID).Msg("Tunnel deleted successfully")
+		s.requestLogger(r).Info().Str("tunnel_id", tunnelID).Bool("purged", purge).Int("force_closed", forceClosed).Msg("Tunnel deleted successfully")
 	}
 
+	if force {
+		w.Header().Set("X-Force-Closed", strconv.Itoa(forceClosed))
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -289,85 +552,104 @@ func (s *Server) handleStartTunnel(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tunnelID := vars["id"]
 
+	if _, ok := s.ownedTunnel(w, r, tunnelID); !ok {
+		return
+	}
+
 	startFn := s.manager.Start
 	if s.coordinator != nil {
 		startFn = s.coordinator.Start
 	}
 	if err := startFn(r.Context(), tunnelID); err != nil {
-		s.logger.Error().Err(err).Str("tunnel_id", tunnelID).Msg("Failed to start tunnel")
+		if s.quotaError(w, err) {
+			return
+		}
+		if errors.Is(err, tunnel.ErrMisconfigured) {
+			s.ConflictError(w, err.Error())
+			return
+		}
+		s.requestLogger(r).Error().Err(err).Str("tunnel_id", tunnelID).Msg("Failed to start tunnel")
 		s.TunnelConnectionError(w, tunnelID, err.Error())
 		return
 	}
 
-	s.logger.Info().Str("tunnel_id", tunnelID).Msg("Tunnel start initiated")
+	s.requestLogger(r).Info().Str("tunnel_id", tunnelID).Msg("Tunnel start initiated")
 
 	// Get updated tunnel state
-	tunnel, err := s.manager.Get(tunnelID)
+	tunnel, err := s.getTunnel(r, tunnelID)
 	if err != nil {
 		s.TunnelNotFound(w, tunnelID)
 		return
 	}
 
-	s.respondJSON(w, http.StatusOK, map[string]interface{}{
-		"id":               tunnel.Spec.ID,
-		"name":             tunnel.Spec.Name,
-		"owner":            tunnel.Spec.Owner,
-		"type":             tunnel.Spec.Type,
-		"hops":             tunnel.Spec.Hops,
-		"localPort":        tunnel.Spec.LocalPort,
-		"localBindAddress": tunnel.Spec.LocalBindAddress,
-		"remoteHost":       tunnel.Spec.RemoteHost,
-		"remotePort":       tunnel.Spec.RemotePort,
-		"autoReconnect":    tunnel.Spec.AutoReconnect,
-		"keepAlive":        tunnel.Spec.KeepAlive.Seconds(),
-		"maxRetries":       tunnel.Spec.MaxRetries,
-		"status":           "connecting",
-		"createdAt":        tunnel.CreatedAt.Format(time.RFC3339),
-		"updatedAt":        tunnel.Spec.UpdatedAt.Format(time.RFC3339),
-	})
+	s.respondJSON(w, http.StatusOK, s.newTunnelResponse(tunnel))
 }
 
-// handleStopTunnel stops a running tunnel (keeps it in the manager)
+// handleStopTunnel stops a running tunnel (keeps it in the manager). With
+// ?force=true active connections are closed instead of drained, and how
+// many is reported in the tunnel's status.
 func (s *Server) handleStopTunnel(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tunnelID := vars["id"]
 
-	stopFn := s.manager.Stop
+	if _, ok := s.ownedTunnel(w, r, tunnelID); !ok {
+		return
+	}
+	force, ok := s.queryBool(w, r, "force")
+	if !ok {
+		return
+	}
+
+	stopFn := s.manager.StopWith
 	if s.coordinator != nil {
-		stopFn = s.coordinator.Stop
+		stopFn = s.coordinator.StopWith
 	}
-	if err := stopFn(r.Context(), tunnelID); err != nil {
-		s.logger.Error().Err(err).Str("tunnel_id", tunnelID).Msg("Failed to stop tunnel")
+	forceClosed, err := stopFn(r.Context(), tunnelID, tunnel.StopOptions{Force: force})
+	if err != nil {
+		s.requestLogger(r).Error().Err(err).Str("tunnel_id", tunnelID).Msg("Failed to stop tunnel")
 		s.InternalError(w, "Failed to stop tunnel")
 		return
 	}
 
-	s.logger.Info().Str("tunnel_id", tunnelID).Msg("Tunnel stopped")
+	s.requestLogger(r).Info().Str("tunnel_id", tunnelID).Int("force_closed", forceClosed).Msg("Tunnel stopped")
 
 	// Get updated tunnel state
-	tunnel, err := s.manager.Get(tunnelID)
+	tunnel, err := s.getTunnel(r, tunnelID)
 	if err != nil {
 		s.TunnelNotFound(w, tunnelID)
 		return
 	}
 
-	s.respondJSON(w, http.StatusOK, map[string]interface{}{
-		"id":               tunnel.Spec.ID,
-		"name":             tunnel.Spec.Name,
-		"owner":            tunnel.Spec.Owner,
-		"type":             tunnel.Spec.Type,
-		"hops":             tunnel.Spec.Hops,
-		"localPort":        tunnel.Spec.LocalPort,
-		"localBindAddress": tunnel.Spec.LocalBindAddress,
-		"remoteHost":       tunnel.Spec.RemoteHost,
-		"remotePort":       tunnel.Spec.RemotePort,
-		"autoReconnect":    tunnel.Spec.AutoReconnect,
-		"keepAlive":        tunnel.Spec.KeepAlive.Seconds(),
-		"maxRetries":       tunnel.Spec.MaxRetries,
-		"status":           "stopped",
-		"createdAt":        tunnel.CreatedAt.Format(time.RFC3339),
-		"updatedAt":        tunnel.Spec.UpdatedAt.Format(time.RFC3339),
-	})
+	s.respondJSON(w, http.StatusOK, s.newTunnelResponse(tunnel))
+}
+
+// handleReconnectTunnel makes the next connection attempt of a failed or
+// reconnecting tunnel right away, resetting its circuit breaker
+func (s *Server) handleReconnectTunnel(w http.ResponseWriter, r *http.Request) {
+	tunnelID := mux.Vars(r)["id"]
+
+	if _, ok := s.ownedTunnel(w, r, tunnelID); !ok {
+		return
+	}
+
+	if err := s.manager.Reconnect(tunnelID); err != nil {
+		if errors.Is(err, tunnel.ErrNotReconnectable) {
+			s.ConflictError(w, err.Error())
+			return
+		}
+		s.requestLogger(r).Error().Err(err).Str("tunnel_id", tunnelID).Msg("Failed to reconnect tunnel")
+		s.TunnelConnectionError(w, tunnelID, err.Error())
+		return
+	}
+
+	s.requestLogger(r).Info().Str("tunnel_id", tunnelID).Msg("Tunnel reconnect requested")
+
+	t, err := s.getTunnel(r, tunnelID)
+	if err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, s.newTunnelResponse(t))
 }
 
 // handleGetTunnelMetrics returns metrics for a specific tunnel
@@ -375,7 +657,7 @@ func (s *Server) handleGetTunnelMetrics(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	tunnelID := vars["id"]
 
-	tunnel, err := s.manager.Get(tunnelID)
+	tunnel, err := s.getTunnel(r, tunnelID)
 	if err != nil {
 		s.TunnelNotFound(w, tunnelID)
 		return
@@ -392,13 +674,97 @@ func (s *Server) handleGetTunnelMetrics(w http.ResponseWriter, r *http.Request)
 		uptime = int64(time.Since(*status.ConnectedAt).Seconds())
 	}
 
+	// Each connected hop holds one SSH connection open
+	sshConnections := 0
+	for _, hop := range status.Hops {
+		if hop.Connected {
+			sshConnections++
+		}
+	}
+
+	var staleSeconds float64
+	if status.LastActivity != nil {
+		staleSeconds = status.StaleSeconds
+	}
+
+	stats := tunnel.Stats()
 	s.respondJSON(w, http.StatusOK, map[string]interface{}{
 		"tunnelId":          tunnelID,
 		"bytesIn":           status.BytesReceived,
 		"bytesOut":          status.BytesSent,
-		"connectionsActive": 1, // TODO: Track actual connections
+		"connectionsActive": stats.ActiveConns,
 		"uptime":            uptime,
 		"lastHeartbeat":     time.Now().Format(time.RFC3339),
+		"goroutines":        stats.Goroutines,
+		"openSockets":       stats.OpenSockets,
+		"bufferBytes":       stats.BufferBytes,
+		"sshConnections":    sshConnections,
+		"lastActivity":      formatTime(status.LastActivity),
+		"staleSeconds":      staleSeconds,
+		"stale":             status.Stale,
+		"statsSeq":          stats.Seq,
+	})
+}
+
+// maxHistoryPoints bounds the number of buckets a history query may request
+const maxHistoryPoints = 10000
+
+// handleGetTunnelMetricsHistory returns sampled traffic counters for a tunnel
+// over ?range= (default 1h), downsampled to ?step= (default: sampling interval).
+// Ranges past the in-memory retention are served from storage when persisting.
+func (s *Server) handleGetTunnelMetricsHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tunnelID := vars["id"]
+
+	if _, err := s.getTunnel(r, tunnelID); err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+
+	query := r.URL.Query()
+
+	rangeDur := time.Hour
+	if v := query.Get("range"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			s.BadRequest(w, "Invalid range: expected a positive duration such as 1h or 15m")
+			return
+		}
+		rangeDur = d
+	}
+	if rangeDur > s.history.Retention() {
+		rangeDur = s.history.Retention()
+	}
+
+	step := s.history.Interval()
+	if v := query.Get("step"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			s.BadRequest(w, "Invalid step: expected a positive duration such as 30s")
+			return
+		}
+		if d > step {
+			step = d
+		}
+	}
+
+	if rangeDur/step > maxHistoryPoints {
+		s.BadRequest(w, fmt.Sprintf("Range/step would return more than %d points; increase step", maxHistoryPoints))
+		return
+	}
+
+	samples, err := s.history.Query(r.Context(), tunnelID, time.Now().Add(-rangeDur), step)
+	if err != nil {
+		s.requestLogger(r).Error().Err(err).Str("tunnel_id", tunnelID).Msg("Failed to read metrics history")
+		s.InternalError(w, "Failed to read metrics history")
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"tunnelId": tunnelID,
+		"range":    rangeDur.Seconds(),
+		"step":     step.Seconds(),
+		"samples":  samples,
 	})
 }
 
@@ -429,8 +795,8 @@ func (s *Server) handleGetLogs(w http.ResponseWriter, r *http.Request) {
 	cmd := exec.Command("journalctl", args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		s.logger.Error().Err(err).Msg("Failed to fetch logs from journalctl")
-		s.respondError(w, http.StatusInternalServerError, "Failed to fetch logs: "+err.Error())
+		s.requestLogger(r).Error().Err(err).Msg("Failed to fetch logs from journalctl")
+		s.InternalError(w, "Failed to fetch logs: "+err.Error())
 		return
 	}
 
@@ -440,7 +806,7 @@ func (s *Server) handleGetLogs(w http.ResponseWriter, r *http.Request) {
 	for scanner.Scan() {
 		var entry map[string]interface{}
 		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
-			s.logger.Warn().Err(err).Msg("Failed to parse log entry")
+			s.requestLogger(r).Warn().Err(err).Msg("Failed to parse log entry")
 			continue
 		}
 
@@ -465,8 +831,8 @@ func (s *Server) handleGetLogs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := scanner.Err(); err != nil {
-		s.logger.Error().Err(err).Msg("Error reading logs")
-		s.respondError(w, http.StatusInternalServerError, "Error reading logs")
+		s.requestLogger(r).Error().Err(err).Msg("Error reading logs")
+		s.InternalError(w, "Error reading logs")
 		return
 	}
 
@@ -480,6 +846,7 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Project  string `json:"project"` // project to work in, default if empty
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -490,12 +857,22 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	// Validate required fields
 	if req.Username == "" || req.Password == "" {
 		s.ValidationError(w, "Username and password are required", []ValidationError{
-			{Field: "username", Message: "Username is required"},
-			{Field: "password", Message: "Password is required"},
+			{Field: "username", Code: ValCodeRequired, Message: "Username is required"},
+			{Field: "password", Code: ValCodeRequired, Message: "Password is required"},
 		})
 		return
 	}
 
+	if req.Project != "" && !projectNamePattern.MatchString(req.Project) {
+		s.ValidationError(w, "Invalid project name", []ValidationError{
+			{Field: "project", Code: ValCodeProjectName, Message: "Use lowercase letters, digits and dashes"},
+		})
+		return
+	}
+	if req.Project == "" {
+		req.Project = types.DefaultProject
+	}
+
 	// Check if authentication is configured
 	if s.auth == nil {
 		s.ServiceUnavailableError(w, "Authentication not configured")
@@ -508,27 +885,51 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		s.InvalidCredentialsError(w)
 		return
 	}
+	userID := "user-1"
+	if !s.auth.MemberOf(userID, req.Project) {
+		s.Forbidden(w, fmt.Sprintf("Not a member of project %s", req.Project))
+		return
+	}
 
 	// Generate JWT token
-	token, err := s.auth.GenerateToken(
-		"user-1",                 // User ID
+	token, claims, err := s.auth.IssueProjectToken(
+		userID,                   // User ID
 		req.Username,             // Username
 		"admin@lazytunnel.local", // Email
 		[]string{"admin"},        // Roles
+		req.Project,              // Project
 	)
 	if err != nil {
-		s.logger.Error().Err(err).Msg("Failed to generate token")
+		s.requestLogger(r).Error().Err(err).Msg("Failed to generate token")
+		s.InternalError(w, "Failed to generate authentication token")
+		return
+	}
+
+	// Record the session so the token can be listed and revoked
+	session := &types.Session{
+		ID:        claims.ID,
+		UserID:    claims.UserID,
+		Username:  claims.Username,
+		Device:    r.UserAgent(),
+		IP:        clientIP(r),
+		IssuedAt:  claims.IssuedAt.Time,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if err := s.sessions.CreateSession(r.Context(), session); err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to record session")
 		s.InternalError(w, "Failed to generate authentication token")
 		return
 	}
 
-	s.logger.Info().
+	s.requestLogger(r).Info().
 		Str("username", req.Username).
+		Str("project", req.Project).
 		Msg("User logged in successfully")
 
 	s.respondJSON(w, http.StatusOK, map[string]interface{}{
 		"token":     token,
 		"tokenType": "Bearer",
-		"expiresIn": 86400, // 24 hours in seconds
+		"expiresIn": int(time.Until(session.ExpiresAt).Seconds()),
+		"project":   req.Project,
 	})
 }
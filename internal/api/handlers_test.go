@@ -0,0 +1,52 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestNewTunnelSpecDefaults(t *testing.T) {
+	s := &Server{tunnelDefaults: TunnelDefaults{
+		AutoReconnect: true,
+		MaxRetries:    8,
+		BindAddress:   "127.0.0.1",
+		IdleTimeout:   time.Hour,
+	}.withFallbacks()}
+
+	spec := s.newTunnelSpec(&CreateTunnelRequest{Name: "db", Type: "local"}, "alice")
+	if !spec.AutoReconnect || spec.MaxRetries != 8 || spec.KeepAlive != DefaultTunnelKeepAlive {
+		t.Errorf("Expected the server's defaults, got auto-reconnect %v, max retries %d, keep-alive %v",
+			spec.AutoReconnect, spec.MaxRetries, spec.KeepAlive)
+	}
+	if spec.LocalBindAddress != "127.0.0.1" || spec.TCP.IdleTimeout != time.Hour {
+		t.Errorf("Expected the default bind address and idle timeout, got %q and %v", spec.LocalBindAddress, spec.TCP.IdleTimeout)
+	}
+
+	// What the request sets wins
+	off := false
+	spec = s.newTunnelSpec(&CreateTunnelRequest{
+		Name: "db", Type: "local", AutoReconnect: &off, MaxRetries: 2, LocalBindAddress: "0.0.0.0",
+		TCP: &types.TCPOptionsReq{IdleTimeout: 60},
+	}, "alice")
+	if spec.AutoReconnect || spec.MaxRetries != 2 || spec.LocalBindAddress != "0.0.0.0" || spec.TCP.IdleTimeout != time.Minute {
+		t.Errorf("Expected the request's settings, got %+v", spec)
+	}
+
+	// IPv6 addresses lose their brackets, and a default of another address
+	// family is left to the listener
+	spec = s.newTunnelSpec(&CreateTunnelRequest{Name: "db", Type: "local", LocalBindAddress: "[::1]"}, "alice")
+	if spec.LocalBindAddress != "::1" {
+		t.Errorf("LocalBindAddress = %q, want ::1", spec.LocalBindAddress)
+	}
+	spec = s.newTunnelSpec(&CreateTunnelRequest{Name: "db", Type: "local", LocalFamily: "ipv6"}, "alice")
+	if spec.LocalBindAddress != "" || spec.LocalFamily != types.AddressFamilyIPv6 {
+		t.Errorf("Expected an IPv6 tunnel without the IPv4 default, got %q (%s)", spec.LocalBindAddress, spec.LocalFamily)
+	}
+
+	// Remote tunnels don't listen locally
+	if spec = s.newTunnelSpec(&CreateTunnelRequest{Name: "web", Type: "remote"}, "alice"); spec.LocalBindAddress != "" {
+		t.Errorf("Expected no bind address for a remote tunnel, got %q", spec.LocalBindAddress)
+	}
+}
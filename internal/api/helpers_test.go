@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+
+	"github.com/craigderington/lazytunnel/internal/addressbook"
+	"github.com/craigderington/lazytunnel/internal/keys"
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// testAgentID is the agent test tunnels are delegated to: the server only
+// records them, so nothing connects
+const testAgentID = "edge-1"
+
+// newTestServer returns a server without authentication whose fresh
+// manager holds the tunnels of specs
+func newTestServer(t *testing.T, specs ...*types.TunnelSpec) *Server {
+	t.Helper()
+	manager := tunnel.NewManager(context.Background())
+	createTunnels(t, manager, specs...)
+	return &Server{manager: manager, logger: zerolog.Nop()}
+}
+
+// newAuthTestServer is newTestServer with authentication
+func newAuthTestServer(t *testing.T, specs ...*types.TunnelSpec) *Server {
+	t.Helper()
+	s := newTestServer(t, specs...)
+	s.auth = NewAuthMiddleware("secret", time.Hour)
+	return s
+}
+
+// withAddressBook gives the server and its manager an empty address book
+func withAddressBook(s *Server) *addressbook.Book {
+	s.addresses = addressbook.New(addressbook.NewMemoryStore())
+	s.manager.SetAddressBook(s.addresses)
+	return s.addresses
+}
+
+// withKeyring gives the server and its manager an empty keyring
+func withKeyring(t *testing.T, s *Server) *keys.Keyring {
+	t.Helper()
+	keyring, err := keys.New(keys.NewMemoryStore(), "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.keyring = keyring
+	s.manager.SetKeySource(keyring)
+	return keyring
+}
+
+// createTunnels creates tunnels, delegated to testAgentID unless they name
+// an agent
+func createTunnels(t *testing.T, manager *tunnel.Manager, specs ...*types.TunnelSpec) {
+	t.Helper()
+	for _, spec := range specs {
+		if spec.AgentID == "" {
+			spec.AgentID = testAgentID
+		}
+		if err := manager.Create(context.Background(), spec); err != nil {
+			t.Fatalf("Create(%s) failed: %v", spec.ID, err)
+		}
+	}
+}
+
+// newRequest returns a request with body, made by user unless it is nil,
+// and with the path variables vars
+func newRequest(method, target, body string, user *User, vars map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if user != nil {
+		req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+	}
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+	return req
+}
+
+// serve records a handler's response to req
+func serve(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// postTunnel creates a tunnel from a JSON request body as user
+func (s *Server) postTunnel(body string, user *User) *httptest.ResponseRecorder {
+	return serve(s.handleCreateTunnel, newRequest(http.MethodPost, "/api/v1/tunnels", body, user, nil))
+}
+
+// decodeJSON decodes a JSON response into v
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("Invalid response with %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// decodeAPIError decodes an error response envelope
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) APIError {
+	t.Helper()
+	var apiErr APIError
+	if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil {
+		t.Fatalf("Invalid error response %q: %v", w.Body.String(), err)
+	}
+	return apiErr
+}
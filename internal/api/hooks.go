@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// mayRunHookCommands reports whether the request may configure command
+// hooks. They run on the server or agent like /exec does, so they need the
+// admin role; webhooks don't.
+func (s *Server) mayRunHookCommands(r *http.Request, req *HooksReq) bool {
+	if req == nil || s.auth == nil || !newHooks(req).HasCommands() {
+		return true
+	}
+	user, ok := GetUser(r.Context())
+	return ok && user.HasRole("admin")
+}
+
+// newHooks converts validated hooks to a tunnel's hooks
+func newHooks(req *HooksReq) types.Hooks {
+	if req == nil {
+		return types.Hooks{}
+	}
+	convert := func(reqs []HookReq) []types.Hook {
+		var hooks []types.Hook
+		for _, h := range reqs {
+			hooks = append(hooks, types.Hook{
+				Command: h.Command,
+				URL:     h.URL,
+				Timeout: time.Duration(h.Timeout) * time.Second,
+			})
+		}
+		return hooks
+	}
+	return types.Hooks{
+		OnConnect:    convert(req.OnConnect),
+		OnDisconnect: convert(req.OnDisconnect),
+		OnFailure:    convert(req.OnFailure),
+	}
+}
+
+// newHooksReq converts a tunnel's hooks back to their request form, or nil
+// if it has none
+func newHooksReq(hooks types.Hooks) *HooksReq {
+	if hooks.Empty() {
+		return nil
+	}
+	convert := func(hooks []types.Hook) []HookReq {
+		var reqs []HookReq
+		for _, h := range hooks {
+			reqs = append(reqs, HookReq{
+				Command: h.Command,
+				URL:     h.URL,
+				Timeout: int(h.Timeout / time.Second),
+			})
+		}
+		return reqs
+	}
+	return &HooksReq{
+		OnConnect:    convert(hooks.OnConnect),
+		OnDisconnect: convert(hooks.OnDisconnect),
+		OnFailure:    convert(hooks.OnFailure),
+	}
+}
+
+// logHookResult logs how a tunnel's hook run went
+func (s *Server) logHookResult(payload tunnel.HookPayload, hook types.Hook, err error) {
+	event := s.logger.Debug()
+	if err != nil {
+		event = s.logger.Warn().Err(err)
+	}
+	if hook.Command != "" {
+		event = event.Str("command", hook.Command)
+	} else {
+		event = event.Str("url", hook.URL)
+	}
+	event.Str("tunnel_id", payload.TunnelID).
+		Str("event", string(payload.Event)).
+		Msg("Ran tunnel hook")
+}
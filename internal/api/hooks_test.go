@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCommandHooksRequireAdmin(t *testing.T) {
+	s := newAuthTestServer(t)
+
+	admin := &User{ID: "1", Username: "admin", Roles: []string{"admin"}}
+	viewer := &User{ID: "2", Username: "viewer", Roles: []string{"viewer"}}
+
+	body := func(name, hook string) string {
+		return `{"name": "` + name + `", "type": "dynamic", "localPort": 1080, "agentId": "edge-1",
+			"hops": [{"host": "bastion.example.com", "port": 22, "user": "ops", "auth_method": "agent"}],
+			"hooks": {"onConnect": [` + hook + `]}}`
+	}
+
+	tests := []struct {
+		name string
+		user *User
+		body string
+		want int
+	}{
+		{"webhook", viewer, body("webhook", `{"url": "https://hooks.example.com/up"}`), http.StatusCreated},
+		{"command as viewer", viewer, body("viewer-command", `{"command": "notify-send up"}`), http.StatusForbidden},
+		{"command as admin", admin, body("admin-command", `{"command": "notify-send up", "timeout": 10}`), http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := s.postTunnel(tt.body, tt.user); rec.Code != tt.want {
+				t.Fatalf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	for _, created := range s.manager.List() {
+		if created.Spec.Name != "admin-command" {
+			continue
+		}
+		if hooks := created.Spec.Hooks.OnConnect; len(hooks) != 1 || hooks[0].Command != "notify-send up" || hooks[0].Timeout != 10*time.Second {
+			t.Errorf("Unexpected hooks %+v", created.Spec.Hooks)
+		}
+	}
+}
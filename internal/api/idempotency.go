@@ -0,0 +1,243 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultIdempotencyTTL is how long responses are kept for replay
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	// maxIdempotencyKeyLength bounds the Idempotency-Key header
+	maxIdempotencyKeyLength = 255
+
+	// maxIdempotentBody bounds the request bodies hashed for replay checks
+	maxIdempotentBody = 1 << 20
+)
+
+// idempotencyStore remembers the responses to mutating requests sent with an
+// Idempotency-Key header, so a client retrying after a network error gets
+// the original response instead of having the request applied twice
+type idempotencyStore struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+// idempotencyEntry is one key's request and, once complete, its response
+type idempotencyEntry struct {
+	fingerprint string        // hash of the method, path and body
+	done        chan struct{} // closed once the response is recorded
+	expires     time.Time
+
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	return &idempotencyStore{
+		ttl:     ttl,
+		entries: make(map[string]*idempotencyEntry),
+	}
+}
+
+// Run drops expired entries until ctx is cancelled
+func (st *idempotencyStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(min(st.ttl, 5*time.Minute))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			st.cleanup(time.Now())
+		}
+	}
+}
+
+func (st *idempotencyStore) cleanup(now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for key, entry := range st.entries {
+		if isClosed(entry.done) && now.After(entry.expires) {
+			delete(st.entries, key)
+		}
+	}
+}
+
+// begin returns the entry already recorded for key, or registers a new one
+// for the caller to complete, reported by created
+func (st *idempotencyStore) begin(key, fingerprint string) (entry *idempotencyEntry, created bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if existing, ok := st.entries[key]; ok && (!isClosed(existing.done) || time.Now().Before(existing.expires)) {
+		return existing, false
+	}
+
+	entry = &idempotencyEntry{fingerprint: fingerprint, done: make(chan struct{})}
+	st.entries[key] = entry
+	return entry, true
+}
+
+// finish records the response to an entry from begin. Server errors are
+// forgotten instead, so retrying them runs the request again.
+func (st *idempotencyStore) finish(key string, entry *idempotencyEntry, rec *recordingWriter) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if rec.status >= http.StatusInternalServerError {
+		delete(st.entries, key)
+	} else {
+		entry.status = rec.status
+		entry.header = rec.handlerHeader()
+		entry.body = rec.body.Bytes()
+		entry.expires = time.Now().Add(st.ttl)
+	}
+	close(entry.done)
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// recordingWriter passes a response through while keeping a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	before http.Header // headers set by middleware before the handler ran
+}
+
+// handlerHeader returns the headers the handler set, leaving out per-request
+// ones such as X-Request-ID that middleware added and the encoding applied
+// by the compression middleware, which encodes the replay afresh
+func (rw *recordingWriter) handlerHeader() http.Header {
+	header := make(http.Header)
+	for name, values := range rw.Header() {
+		if name == "Content-Encoding" || name == "Content-Length" {
+			continue
+		}
+		if !slices.Equal(values, rw.before[name]) {
+			header[name] = slices.Clone(values)
+		}
+	}
+	return header
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
+
+// Unwrap exposes the underlying ResponseWriter to http.ResponseController
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// idempotent makes a mutating handler safe to retry. A request carrying an
+// Idempotency-Key runs once; repeating it within the TTL replays the
+// original response, marked with Idempotent-Replayed. Keys are scoped to
+// the authenticated user, and reusing one for a different request is an
+// error, as is repeating a request that is still in progress.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || s.idempotency == nil {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			s.BadRequest(w, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				s.ErrorResponse(w, http.StatusRequestEntityTooLarge, NewAPIError(ErrCodeBadRequest, "Request body too large"))
+				return
+			}
+			s.BadRequest(w, "Failed to read request body: "+err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		scope := "anonymous"
+		if user, ok := GetUser(r.Context()); ok {
+			scope = user.ID
+		}
+		storeKey := scope + "\x00" + key
+
+		entry, created := s.idempotency.begin(storeKey, fingerprint)
+		if !created {
+			switch {
+			case entry.fingerprint != fingerprint:
+				s.ErrorResponse(w, http.StatusUnprocessableEntity, NewAPIError(ErrCodeIdempotencyMismatch,
+					"Idempotency-Key was already used for a different request"))
+			case !isClosed(entry.done):
+				s.ConflictError(w, "A request with this Idempotency-Key is still in progress; retry once it completes")
+			default:
+				for name, values := range entry.header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(entry.status)
+				w.Write(entry.body)
+			}
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: w, before: w.Header().Clone()}
+		completed := false
+		defer func() {
+			// A panicking handler is treated as a server error: not replayed
+			if !completed {
+				rec.status = http.StatusInternalServerError
+			}
+			s.idempotency.finish(storeKey, entry, rec)
+		}()
+
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		completed = true
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestIdempotentReplaysResponses(t *testing.T) {
+	s := &Server{logger: zerolog.Nop(), idempotency: newIdempotencyStore(0)}
+
+	var calls atomic.Int32
+	handler := s.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if r.URL.Path == "/fail" {
+			s.InternalError(w, "storage unavailable")
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/tunnels/t-%d", n))
+		s.respondJSON(w, http.StatusCreated, map[string]interface{}{"id": fmt.Sprintf("t-%d", n)})
+	})
+
+	send := func(path, key, body string, user *User) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		if user != nil {
+			req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+		}
+		rec := httptest.NewRecorder()
+		// Set by middleware for each request; must not be replayed
+		rec.Header().Set(RequestIDHeader, "req-"+key+body)
+		handler(rec, req)
+		return rec
+	}
+
+	first := send("/tunnels", "key-1", `{"name":"db"}`, nil)
+	replay := send("/tunnels", "key-1", `{"name":"db"}`, nil)
+	if calls.Load() != 1 {
+		t.Fatalf("Expected the handler to run once, ran %d times", calls.Load())
+	}
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Errorf("Expected the original response, got %d: %s", replay.Code, replay.Body.String())
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" || replay.Header().Get("Location") != "/api/v1/tunnels/t-1" {
+		t.Errorf("Expected replay headers, got %v", replay.Header())
+	}
+	if replay.Header().Get(RequestIDHeader) != "req-key-1"+`{"name":"db"}` {
+		t.Errorf("Expected the replay to keep its own request ID, got %q", replay.Header().Get(RequestIDHeader))
+	}
+
+	if rec := send("/tunnels", "key-1", `{"name":"cache"}`, nil); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a reused key, got %d", rec.Code)
+	}
+
+	send("/tunnels", "", `{"name":"db"}`, nil)
+	send("/tunnels", "", `{"name":"db"}`, nil)
+	if calls.Load() != 3 {
+		t.Errorf("Expected requests without a key to always run, ran %d times", calls.Load())
+	}
+
+	// Keys are per user
+	send("/tunnels", "key-1", `{"name":"db"}`, &User{ID: "alice"})
+	if calls.Load() != 4 {
+		t.Errorf("Expected another user's key to run the request, ran %d times", calls.Load())
+	}
+
+	// Server errors aren't kept, so a retry runs again
+	send("/fail", "key-2", "", nil)
+	send("/fail", "key-2", "", nil)
+	if calls.Load() != 6 {
+		t.Errorf("Expected failed requests to run again, ran %d times", calls.Load())
+	}
+}
+
+func TestIdempotentRejectsConcurrentRetry(t *testing.T) {
+	s := &Server{logger: zerolog.Nop(), idempotency: newIdempotencyStore(0)}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := s.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/tunnels/t-1/start", nil)
+		req.Header.Set("Idempotency-Key", "start-1")
+		return req
+	}
+
+	done := make(chan struct{})
+	go func() {
+		handler(httptest.NewRecorder(), newRequest())
+		close(done)
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	handler(rec, newRequest())
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 while the first request runs, got %d", rec.Code)
+	}
+
+	close(release)
+	<-done
+
+	rec = httptest.NewRecorder()
+	handler(rec, newRequest())
+	if rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected a replay once complete, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// IdentitiesResponse lists the keys the server can authenticate to hops with
+type IdentitiesResponse struct {
+	Identities  []Identity `json:"identities"`
+	AgentSocket string     `json:"agentSocket,omitempty"`
+	AgentError  string     `json:"agentError,omitempty"` // why the agent's keys couldn't be listed
+}
+
+// Identity is a key a hop can use: AuthMethod and KeyID are the hop
+// settings that select it
+type Identity struct {
+	Source      string `json:"source"` // agent, file or managed
+	AuthMethod  string `json:"authMethod"`
+	KeyID       string `json:"keyId,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+	Encrypted   bool   `json:"encrypted,omitempty"`
+	Error       string `json:"error,omitempty"` // why a key file can't be used
+}
+
+// identitySourceManaged is the source of managed keys' identities
+const identitySourceManaged = "managed"
+
+// handleListIdentities lists the keys the SSH agent holds, the configured
+// private key files, or ssh's default ones, and the caller's managed keys,
+// so that clients can offer them instead of asking for a key path
+func (s *Server) handleListIdentities(w http.ResponseWriter, r *http.Request) {
+	resp := IdentitiesResponse{Identities: []Identity{}, AgentSocket: tunnel.AgentSocket()}
+
+	agentKeys, err := tunnel.AgentIdentities()
+	if err != nil {
+		s.requestLogger(r).Warn().Err(err).Msg("Failed to list SSH agent keys")
+		resp.AgentError = err.Error()
+	}
+	for _, id := range agentKeys {
+		resp.Identities = append(resp.Identities, newIdentity(id))
+	}
+
+	keyFiles, skipMissing := s.keyFiles, false
+	if len(keyFiles) == 0 {
+		keyFiles, skipMissing = tunnel.DefaultKeyFiles, true
+	}
+	for _, id := range tunnel.KeyFileIdentities(keyFiles, skipMissing) {
+		resp.Identities = append(resp.Identities, newIdentity(id))
+	}
+
+	if s.keyring != nil {
+		managed, err := s.keyring.List(r.Context())
+		if err != nil {
+			s.requestLogger(r).Error().Err(err).Msg("Failed to list managed keys")
+			s.InternalError(w, "Failed to list identities")
+			return
+		}
+		for _, key := range managed {
+			if !s.mayUseKey(r, key) {
+				continue
+			}
+			resp.Identities = append(resp.Identities, Identity{
+				Source:      identitySourceManaged,
+				AuthMethod:  string(types.AuthMethodKey),
+				KeyID:       types.ManagedKeyPrefix + key.ID,
+				Type:        key.Type,
+				Fingerprint: key.Fingerprint,
+				Comment:     key.Name,
+			})
+		}
+	}
+
+	s.respondJSON(w, http.StatusOK, resp)
+}
+
+// newIdentity describes a tunnel identity for clients
+func newIdentity(id tunnel.Identity) Identity {
+	identity := Identity{
+		Source:      id.Source,
+		AuthMethod:  string(types.AuthMethodAgent),
+		Type:        id.Type,
+		Fingerprint: id.Fingerprint,
+		Comment:     id.Comment,
+		Encrypted:   id.Encrypted,
+	}
+	if id.Source == tunnel.IdentitySourceFile {
+		identity.AuthMethod = string(types.AuthMethodKey)
+		identity.KeyID = id.Path
+	}
+	if id.Err != nil {
+		identity.Error = id.Err.Error()
+	}
+	return identity
+}
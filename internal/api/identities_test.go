@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/internal/keys"
+)
+
+func TestListIdentities(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "deploy_key")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing_key")
+
+	s := &Server{logger: zerolog.Nop(), keyFiles: []string{keyPath, missing}}
+	rec := httptest.NewRecorder()
+	s.handleListIdentities(rec, httptest.NewRequest(http.MethodGet, "/api/v1/identities", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp IdentitiesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if resp.AgentSocket != "" || resp.AgentError != "" {
+		t.Errorf("agent = %q (%q), want none", resp.AgentSocket, resp.AgentError)
+	}
+	if len(resp.Identities) != 2 {
+		t.Fatalf("identities = %+v, want the two configured key files", resp.Identities)
+	}
+	if id := resp.Identities[0]; id.Source != "file" || id.AuthMethod != "key" || id.KeyID != keyPath ||
+		id.Type != ssh.KeyAlgoED25519 || id.Fingerprint == "" || id.Error != "" {
+		t.Errorf("key file identity = %+v", id)
+	}
+	// Configured key files that are missing are reported, not hidden
+	if id := resp.Identities[1]; id.KeyID != missing || id.Error == "" {
+		t.Errorf("missing key file identity = %+v, want an error", id)
+	}
+}
+
+func TestListIdentitiesIncludesManagedKeys(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	keyring, err := keys.New(keys.NewMemoryStore(), "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := keyring.Generate(context.Background(), "deploy", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{logger: zerolog.Nop(), keyFiles: []string{filepath.Join(t.TempDir(), "none")}, keyring: keyring}
+	rec := httptest.NewRecorder()
+	s.handleListIdentities(rec, httptest.NewRequest(http.MethodGet, "/api/v1/identities", nil))
+
+	var resp IdentitiesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	want := Identity{Source: "managed", AuthMethod: "key", KeyID: "managed://" + key.ID,
+		Type: ssh.KeyAlgoED25519, Fingerprint: key.Fingerprint, Comment: "deploy"}
+	if len(resp.Identities) != 2 || resp.Identities[1] != want {
+		t.Errorf("identities = %+v, want the key file and %+v", resp.Identities, want)
+	}
+}
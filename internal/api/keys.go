@@ -0,0 +1,354 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/internal/keys"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// CreateKeyRequest uploads a private key to manage, or generates an
+// ed25519 one when PrivateKey is empty
+type CreateKeyRequest struct {
+	Name       string `json:"name" validate:"required,max=100"`
+	PrivateKey string `json:"privateKey,omitempty"` // PEM, e.g. an OpenSSH private key file's content
+	Passphrase string `json:"passphrase,omitempty"` // of an encrypted PrivateKey; it is stored unlocked
+}
+
+// RotateKeyRequest replaces a managed key's key pair with an uploaded one,
+// or a generated ed25519 one when PrivateKey is empty
+type RotateKeyRequest struct {
+	PrivateKey string `json:"privateKey,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// ManagedKeyResponse is a managed key as the API returns it; private keys
+// never leave the server
+type ManagedKeyResponse struct {
+	ID          string             `json:"id"`
+	KeyID       string             `json:"keyId"` // the hop key_id that selects it
+	Name        string             `json:"name"`
+	Owner       string             `json:"owner"`
+	Type        string             `json:"type"`
+	Fingerprint string             `json:"fingerprint"`
+	PublicKey   string             `json:"publicKey"`
+	CreatedAt   string             `json:"createdAt"`
+	RotatedAt   string             `json:"rotatedAt,omitempty"`
+	Previous    *ManagedKeyVersion `json:"previous,omitempty"` // while a rotation is in progress
+	UsedBy      []string           `json:"usedBy"`             // IDs of the tunnels whose hops use it
+}
+
+// ManagedKeyVersion is the key pair a rotation in progress replaced
+type ManagedKeyVersion struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"publicKey"`
+}
+
+// managedKeys returns the keyring, responding with 503 if managed keys
+// aren't enabled
+func (s *Server) managedKeys(w http.ResponseWriter) (*keys.Keyring, bool) {
+	if s.keyring == nil {
+		s.ServiceUnavailableError(w, "Managed keys are not enabled; set keys.encryption_key")
+		return nil, false
+	}
+	return s.keyring, true
+}
+
+// handleListKeys lists the caller's managed keys, or all of them for
+// admins, oldest first
+func (s *Server) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	keyring, ok := s.managedKeys(w)
+	if !ok {
+		return
+	}
+
+	list, err := keyring.List(r.Context())
+	if err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to list managed keys")
+		s.InternalError(w, "Failed to list managed keys")
+		return
+	}
+
+	usedBy := s.managedKeyUsers()
+	response := []ManagedKeyResponse{}
+	for _, key := range list {
+		if s.mayUseKey(r, key) {
+			response = append(response, newManagedKeyResponse(key, usedBy[key.ID]))
+		}
+	}
+	s.respondJSON(w, http.StatusOK, response)
+}
+
+// handleCreateKey uploads or generates a managed key
+func (s *Server) handleCreateKey(w http.ResponseWriter, r *http.Request) {
+	keyring, ok := s.managedKeys(w)
+	if !ok {
+		return
+	}
+	var req CreateKeyRequest
+	if !s.decodeAndValidate(w, r, &req) {
+		return
+	}
+
+	var key *types.ManagedKey
+	var err error
+	if req.PrivateKey == "" {
+		key, err = keyring.Generate(r.Context(), req.Name, requestOwner(r))
+	} else {
+		key, err = keyring.Import(r.Context(), req.Name, requestOwner(r), []byte(req.PrivateKey), []byte(req.Passphrase))
+	}
+	if errors.Is(err, keys.ErrInvalidKey) {
+		s.ValidationError(w, "Validation failed", []ValidationError{
+			{Field: "privateKey", Code: ValCodeInvalid, Message: err.Error()},
+		})
+		return
+	}
+	if err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to create managed key")
+		s.InternalError(w, "Failed to create managed key")
+		return
+	}
+
+	s.requestLogger(r).Info().
+		Str("key_id", key.ID).
+		Str("fingerprint", key.Fingerprint).
+		Bool("uploaded", req.PrivateKey != "").
+		Msg("Managed key created")
+	s.respondJSON(w, http.StatusCreated, newManagedKeyResponse(key, nil))
+}
+
+// handleGetKey returns a managed key
+func (s *Server) handleGetKey(w http.ResponseWriter, r *http.Request) {
+	keyring, ok := s.managedKeys(w)
+	if !ok {
+		return
+	}
+
+	key, ok := s.usableKey(w, r, keyring)
+	if !ok {
+		return
+	}
+	s.respondJSON(w, http.StatusOK, newManagedKeyResponse(key, s.managedKeyUsers()[key.ID]))
+}
+
+// handleDeleteKey deletes a managed key no tunnel uses
+func (s *Server) handleDeleteKey(w http.ResponseWriter, r *http.Request) {
+	keyring, ok := s.managedKeys(w)
+	if !ok {
+		return
+	}
+	key, ok := s.ownedKey(w, r, keyring)
+	if !ok {
+		return
+	}
+	if users := s.managedKeyUsers()[key.ID]; len(users) > 0 {
+		s.ConflictError(w, "Managed key is used by tunnels: "+strings.Join(users, ", "))
+		return
+	}
+
+	if err := keyring.Delete(r.Context(), key.ID); !s.checkKeyResult(w, r, err, "Failed to delete managed key") {
+		return
+	}
+
+	s.requestLogger(r).Info().Str("key_id", key.ID).Msg("Managed key deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRotateKey starts a rotation: the key gets a new key pair, and hops
+// using it offer the new one and then the old one until it is finished
+func (s *Server) handleRotateKey(w http.ResponseWriter, r *http.Request) {
+	keyring, ok := s.managedKeys(w)
+	if !ok {
+		return
+	}
+	key, ok := s.ownedKey(w, r, keyring)
+	if !ok {
+		return
+	}
+
+	// The body is optional: an empty one generates the new key pair
+	var req RotateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.BadRequest(w, "Invalid request body: "+err.Error())
+		return
+	}
+
+	rotated, err := keyring.Rotate(r.Context(), key.ID, []byte(req.PrivateKey), []byte(req.Passphrase))
+	if errors.Is(err, keys.ErrInvalidKey) {
+		s.ValidationError(w, "Validation failed", []ValidationError{
+			{Field: "privateKey", Code: ValCodeInvalid, Message: err.Error()},
+		})
+		return
+	}
+	if !s.checkKeyResult(w, r, err, "Failed to rotate managed key") {
+		return
+	}
+
+	s.requestLogger(r).Info().
+		Str("key_id", rotated.ID).
+		Str("fingerprint", rotated.Fingerprint).
+		Str("previous_fingerprint", rotated.Previous.Fingerprint).
+		Msg("Managed key rotation started")
+	s.respondJSON(w, http.StatusOK, newManagedKeyResponse(rotated, s.managedKeyUsers()[rotated.ID]))
+}
+
+// handleFinishKeyRotation drops the key pair a rotation replaced
+func (s *Server) handleFinishKeyRotation(w http.ResponseWriter, r *http.Request) {
+	keyring, ok := s.managedKeys(w)
+	if !ok {
+		return
+	}
+	key, ok := s.ownedKey(w, r, keyring)
+	if !ok {
+		return
+	}
+
+	finished, err := keyring.FinishRotation(r.Context(), key.ID)
+	if !s.checkKeyResult(w, r, err, "Failed to finish managed key rotation") {
+		return
+	}
+
+	s.requestLogger(r).Info().Str("key_id", finished.ID).Msg("Managed key rotation finished")
+	s.respondJSON(w, http.StatusOK, newManagedKeyResponse(finished, s.managedKeyUsers()[finished.ID]))
+}
+
+// ownedKey looks up the managed key a mutating request targets, responding
+// with 404 if there is none and 403 if the caller may not modify it
+func (s *Server) ownedKey(w http.ResponseWriter, r *http.Request, keyring *keys.Keyring) (*types.ManagedKey, bool) {
+	key, err := keyring.Get(r.Context(), mux.Vars(r)["id"])
+	if !s.checkKeyResult(w, r, err, "Failed to get managed key") {
+		return nil, false
+	}
+	if !s.mayUseKey(r, key) {
+		s.Forbidden(w, "Only the key's owner or an admin can modify it")
+		return nil, false
+	}
+	return key, true
+}
+
+// usableKey looks up the managed key a request reads, responding with 404
+// if there is none or the caller may not use it, so other users' keys
+// don't show
+func (s *Server) usableKey(w http.ResponseWriter, r *http.Request, keyring *keys.Keyring) (*types.ManagedKey, bool) {
+	key, err := keyring.Get(r.Context(), mux.Vars(r)["id"])
+	if err == nil && !s.mayUseKey(r, key) {
+		err = keys.ErrNotFound
+	}
+	if !s.checkKeyResult(w, r, err, "Failed to get managed key") {
+		return nil, false
+	}
+	return key, true
+}
+
+// mayUseKey reports whether the caller may use, see or modify a managed
+// key: its owner and admins may, and anyone may while authentication is
+// disabled
+func (s *Server) mayUseKey(r *http.Request, key *types.ManagedKey) bool {
+	if s.auth == nil {
+		return true
+	}
+	user, ok := GetUser(r.Context())
+	return ok && (user.Username == key.Owner || user.HasRole("admin"))
+}
+
+// checkKeyID returns an error if keyID is a managed key the caller may not
+// use. Keys handed out by the match rules are shared by the server's
+// configuration, so anyone may use those.
+func (s *Server) checkKeyID(r *http.Request, keyID string) error {
+	id, ok := types.ManagedKeyID(keyID)
+	if !ok || s.keyring == nil || s.tunnelDefaults.sharesKey(keyID) {
+		return nil
+	}
+	key, err := s.keyring.Get(r.Context(), id)
+	if err == nil && !s.mayUseKey(r, key) {
+		err = keys.ErrNotFound
+	}
+	if errors.Is(err, keys.ErrNotFound) {
+		return fmt.Errorf("managed key %q not found", id)
+	}
+	return err
+}
+
+// checkHopKeys checks the managed keys of hops with checkKeyID
+func (s *Server) checkHopKeys(r *http.Request, hops []types.Hop) error {
+	for _, hop := range hops {
+		if err := s.checkKeyID(r, hop.KeyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkKeyResult responds to a failed keyring call and reports whether it
+// succeeded
+func (s *Server) checkKeyResult(w http.ResponseWriter, r *http.Request, err error, message string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, keys.ErrNotFound):
+		s.NotFound(w, "Managed key")
+	case errors.Is(err, keys.ErrRotating), errors.Is(err, keys.ErrNotRotating):
+		s.ConflictError(w, err.Error())
+	default:
+		s.requestLogger(r).Error().Err(err).Msg(message)
+		s.InternalError(w, message)
+	}
+	return false
+}
+
+// managedKeyUsers maps managed key IDs to the IDs of the tunnels whose
+// hops use them
+func (s *Server) managedKeyUsers() map[string][]string {
+	users := make(map[string][]string)
+	for _, t := range s.manager.List() {
+		seen := make(map[string]bool)
+		for _, hop := range t.Spec.Hops {
+			if id, ok := types.ManagedKeyID(hop.KeyID); ok && !seen[id] {
+				seen[id] = true
+				users[id] = append(users[id], t.Spec.ID)
+			}
+		}
+	}
+	for _, ids := range users {
+		sort.Strings(ids)
+	}
+	return users
+}
+
+func newManagedKeyResponse(key *types.ManagedKey, usedBy []string) ManagedKeyResponse {
+	if usedBy == nil {
+		usedBy = []string{}
+	}
+	response := ManagedKeyResponse{
+		ID:          key.ID,
+		KeyID:       types.ManagedKeyPrefix + key.ID,
+		Name:        key.Name,
+		Owner:       key.Owner,
+		Type:        key.Type,
+		Fingerprint: key.Fingerprint,
+		PublicKey:   key.PublicKey,
+		CreatedAt:   key.CreatedAt.Format(time.RFC3339),
+		UsedBy:      usedBy,
+	}
+	if key.RotatedAt != nil {
+		response.RotatedAt = key.RotatedAt.Format(time.RFC3339)
+	}
+	if key.Previous != nil {
+		response.Previous = &ManagedKeyVersion{
+			Type:        key.Previous.Type,
+			Fingerprint: key.Previous.Fingerprint,
+			PublicKey:   key.Previous.PublicKey,
+		}
+	}
+	return response
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestManagedKeys(t *testing.T) {
+	s := newTestServer(t)
+	withKeyring(t, s)
+
+	send := func(handler http.HandlerFunc, method, target, id, body string) *httptest.ResponseRecorder {
+		return serve(handler, newRequest(method, target, body, nil, map[string]string{"id": id}))
+	}
+
+	rec := send(s.handleCreateKey, http.MethodPost, "/api/v1/keys", "", `{"name":"deploy"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var created ManagedKeyResponse
+	decodeJSON(t, rec, &created)
+	if created.KeyID != "managed://"+created.ID || created.Type != "ssh-ed25519" || created.Fingerprint == "" ||
+		created.Owner != anonymousOwner || strings.Contains(rec.Body.String(), "PRIVATE") {
+		t.Errorf("created key = %+v", created)
+	}
+
+	if rec := send(s.handleCreateKey, http.MethodPost, "/api/v1/keys", "", `{"name":"bad","privateKey":"nope"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("uploading an invalid key status = %d, want 400", rec.Code)
+	}
+
+	// A tunnel using the key keeps it from being deleted
+	spec := &types.TunnelSpec{ID: "uses-key", Name: "db", Type: types.TunnelTypeLocal,
+		Hops: []types.Hop{{Host: "bastion", Port: 22, User: "ops", AuthMethod: types.AuthMethodKey, KeyID: created.KeyID}}}
+	createTunnels(t, s.manager, spec)
+
+	rec = send(s.handleListKeys, http.MethodGet, "/api/v1/keys", "", "")
+	var list []ManagedKeyResponse
+	decodeJSON(t, rec, &list)
+	if len(list) != 1 || list[0].ID != created.ID || len(list[0].UsedBy) != 1 || list[0].UsedBy[0] != spec.ID {
+		t.Errorf("listed keys = %+v, want the created key used by %s", list, spec.ID)
+	}
+	if rec := send(s.handleDeleteKey, http.MethodDelete, "/api/v1/keys/"+created.ID, created.ID, ""); rec.Code != http.StatusConflict {
+		t.Errorf("deleting a key in use status = %d, want 409", rec.Code)
+	}
+
+	rec = send(s.handleRotateKey, http.MethodPost, "/api/v1/keys/"+created.ID+"/rotate", created.ID, "")
+	var rotated ManagedKeyResponse
+	decodeJSON(t, rec, &rotated)
+	if rec.Code != http.StatusOK || rotated.Fingerprint == created.Fingerprint || rotated.Previous == nil ||
+		rotated.Previous.Fingerprint != created.Fingerprint {
+		t.Errorf("rotate = %d %+v", rec.Code, rotated)
+	}
+	if rec := send(s.handleRotateKey, http.MethodPost, "/api/v1/keys/"+created.ID+"/rotate", created.ID, ""); rec.Code != http.StatusConflict {
+		t.Errorf("rotating during a rotation status = %d, want 409", rec.Code)
+	}
+
+	rec = send(s.handleFinishKeyRotation, http.MethodPost, "/api/v1/keys/"+created.ID+"/rotate/finish", created.ID, "")
+	var finished ManagedKeyResponse
+	decodeJSON(t, rec, &finished)
+	if rec.Code != http.StatusOK || finished.Previous != nil || finished.Fingerprint != rotated.Fingerprint {
+		t.Errorf("finish rotation = %d %+v", rec.Code, finished)
+	}
+
+	if err := s.manager.Delete(context.Background(), spec.ID); err != nil {
+		t.Fatal(err)
+	}
+	if rec := send(s.handleDeleteKey, http.MethodDelete, "/api/v1/keys/"+created.ID, created.ID, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(s.handleGetKey, http.MethodGet, "/api/v1/keys/"+created.ID, created.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete status = %d, want 404", rec.Code)
+	}
+}
+
+func TestManagedKeysDisabled(t *testing.T) {
+	s := &Server{logger: zerolog.Nop()}
+	rec := httptest.NewRecorder()
+	s.handleListKeys(rec, httptest.NewRequest(http.MethodGet, "/api/v1/keys", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503 without an encryption key", rec.Code)
+	}
+}
+
+func TestManagedKeysArePrivate(t *testing.T) {
+	s := newAuthTestServer(t)
+	withAddressBook(s)
+	keyring := withKeyring(t, s)
+	key, err := keyring.Generate(context.Background(), "alice's", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := keyring.Generate(context.Background(), "shared", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.tunnelDefaults.Match = []MatchRule{{Hosts: []string{"*.shared.internal"}, KeyID: types.ManagedKeyPrefix + shared.ID}}
+
+	alice := &User{ID: "1", Username: "alice", Roles: []string{"user"}}
+	bob := &User{ID: "2", Username: "bob", Roles: []string{"user"}}
+	admin := &User{ID: "3", Username: "root", Roles: []string{"admin"}}
+	keyID := types.ManagedKeyPrefix + key.ID
+
+	// Only its owner and admins see it
+	for _, tt := range []struct {
+		user *User
+		want int
+	}{{alice, 1}, {bob, 0}, {admin, 2}} {
+		var list []ManagedKeyResponse
+		decodeJSON(t, serve(s.handleListKeys, newRequest(http.MethodGet, "/api/v1/keys", "", tt.user, nil)), &list)
+		if len(list) != tt.want {
+			t.Errorf("%s listed %d keys, want %d", tt.user.Username, len(list), tt.want)
+		}
+		var identities IdentitiesResponse
+		decodeJSON(t, serve(s.handleListIdentities, newRequest(http.MethodGet, "/api/v1/identities", "", tt.user, nil)), &identities)
+		managed := 0
+		for _, identity := range identities.Identities {
+			if identity.Source == identitySourceManaged {
+				managed++
+			}
+		}
+		if managed != tt.want {
+			t.Errorf("%s listed %d managed identities, want %d", tt.user.Username, managed, tt.want)
+		}
+	}
+	vars := map[string]string{"id": key.ID}
+	if rec := serve(s.handleGetKey, newRequest(http.MethodGet, "/api/v1/keys/"+key.ID, "", bob, vars)); rec.Code != http.StatusNotFound {
+		t.Errorf("getting another user's key status = %d, want 404", rec.Code)
+	}
+	if rec := serve(s.handleAuthorizedKeyLine, newRequest(http.MethodGet, "/api/v1/keys/"+key.ID+"/authorized_keys", "", bob, vars)); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's authorized_keys line status = %d, want 404", rec.Code)
+	}
+
+	// Nor can anyone else connect with it
+	tunnelBody := func(name, host string) string {
+		return `{"name": "` + name + `", "type": "dynamic", "agentId": "` + testAgentID + `",
+			"hops": [{"host": "` + host + `", "port": 22, "user": "ops", "auth_method": "key", "key_id": "` + keyID + `"}]}`
+	}
+	for _, tt := range []struct {
+		user *User
+		want int
+	}{{bob, http.StatusBadRequest}, {alice, http.StatusCreated}, {admin, http.StatusCreated}} {
+		if rec := s.postTunnel(tunnelBody(tt.user.Username+"-socks", "bastion"), tt.user); rec.Code != tt.want {
+			t.Errorf("%s creating a tunnel with alice's key status = %d, want %d: %s", tt.user.Username, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+	bundle := `{"version": 1, "tunnels": [` + tunnelBody("imported", "bastion") + `]}`
+	var result ImportResult
+	decodeJSON(t, serve(s.handleImport, newRequest(http.MethodPost, "/api/v1/import", bundle, bob, nil)), &result)
+	if len(result.Created) != 0 || len(result.Failed) != 1 || !strings.Contains(result.Failed[0].Error, "not found") {
+		t.Errorf("bob importing a tunnel with alice's key = %+v", result)
+	}
+	entry := `{"name": "alices-bastion", "host": "bastion", "auth_method": "key", "key_id": "` + keyID + `"}`
+	if rec := serve(s.handleCreateAddress, newRequest(http.MethodPost, "/api/v1/addresses", entry, bob, nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("bob adding an address with alice's key status = %d, want 400", rec.Code)
+	}
+
+	// The match rules share theirs with everyone
+	if rec := s.postTunnel(`{"name": "bob-shared", "type": "dynamic", "agentId": "`+testAgentID+`",
+		"hops": [{"host": "db.shared.internal", "port": 22, "user": "ops"}]}`, bob); rec.Code != http.StatusCreated {
+		t.Errorf("bob using the match rules' key status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
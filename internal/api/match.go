@@ -0,0 +1,90 @@
+package api
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// MatchRule sets hop settings for the hosts it matches, like a Match host
+// block of ssh_config. Tunnels created without a setting take it from the
+// first rule, in order, that matches and sets it.
+type MatchRule struct {
+	Hosts      []string // patterns such as *.prod.example.com; !pattern excludes
+	User       string
+	Port       int
+	AuthMethod types.AuthMethod // key if empty and KeyID is set
+	KeyID      string
+	KeepAlive  time.Duration // of tunnels whose hops it matches
+}
+
+// Matches reports whether host matches one of the rule's patterns and none
+// of its negated ones, ignoring case as ssh does
+func (rule MatchRule) Matches(host string) bool {
+	host = strings.ToLower(host)
+	matched := false
+	for _, pattern := range rule.Hosts {
+		negated := strings.HasPrefix(pattern, "!")
+		ok, _ := path.Match(strings.ToLower(strings.TrimPrefix(pattern, "!")), host)
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// sharesKey reports whether one of the match rules hands out keyID
+func (d TunnelDefaults) sharesKey(keyID string) bool {
+	for _, rule := range d.Match {
+		if rule.KeyID == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule MatchRule) authMethod() types.AuthMethod {
+	if rule.AuthMethod == "" && rule.KeyID != "" {
+		return types.AuthMethodKey
+	}
+	return rule.AuthMethod
+}
+
+// applyMatchRules fills the hop settings and keep-alive req leaves unset
+// from the match rules, before it is validated, so hops covered by a rule
+// don't need a user, port or auth method of their own. Hops referencing an
+// address book entry take the entry's settings instead.
+func (d TunnelDefaults) applyMatchRules(req *CreateTunnelRequest) {
+	if len(d.Match) == 0 {
+		return
+	}
+	for i := range req.Hops {
+		hop := &req.Hops[i]
+		if _, ok := types.AddressRef(hop.Host); ok {
+			continue
+		}
+		for _, rule := range d.Match {
+			if !rule.Matches(hop.Host) {
+				continue
+			}
+			if hop.User == "" {
+				hop.User = rule.User
+			}
+			if hop.Port == 0 {
+				hop.Port = rule.Port
+			}
+			switch method := rule.authMethod(); {
+			case hop.AuthMethod == "" && method != "":
+				hop.AuthMethod, hop.KeyID = string(method), rule.KeyID
+			case hop.KeyID == "" && hop.AuthMethod == string(method):
+				hop.KeyID = rule.KeyID
+			}
+			if req.KeepAlive == 0 && rule.KeepAlive > 0 {
+				req.KeepAlive = int(rule.KeepAlive / time.Second)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestMatchRuleMatches(t *testing.T) {
+	rule := MatchRule{Hosts: []string{"*.prod.example.com", "!legacy.prod.example.com", "10.0.?.1"}}
+	for host, want := range map[string]bool{
+		"db.prod.example.com":     true,
+		"DB.Prod.Example.com":     true,
+		"legacy.prod.example.com": false,
+		"prod.example.com":        false,
+		"10.0.3.1":                true,
+		"10.0.30.1":               false,
+	} {
+		if got := rule.Matches(host); got != want {
+			t.Errorf("Matches(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestApplyMatchRules(t *testing.T) {
+	defaults := TunnelDefaults{Match: []MatchRule{
+		{Hosts: []string{"*.prod.example.com"}, User: "deploy", KeyID: "managed://prod", KeepAlive: 15 * time.Second},
+		{Hosts: []string{"*"}, User: "nobody", Port: 22, AuthMethod: types.AuthMethodAgent, KeepAlive: time.Minute},
+	}}
+	req := &CreateTunnelRequest{Hops: []HopReq{
+		{Host: "bastion.prod.example.com"},
+		{Host: "db.prod.example.com", User: "dba", Port: 2222, AuthMethod: "key"},
+		{Host: "other.example.com"},
+		{Host: "@bastion"},
+	}}
+
+	defaults.applyMatchRules(req)
+	want := []HopReq{
+		{Host: "bastion.prod.example.com", User: "deploy", Port: 22, AuthMethod: "key", KeyID: "managed://prod"},
+		{Host: "db.prod.example.com", User: "dba", Port: 2222, AuthMethod: "key", KeyID: "managed://prod"},
+		{Host: "other.example.com", User: "nobody", Port: 22, AuthMethod: "agent"},
+		{Host: "@bastion"},
+	}
+	for i := range want {
+		if req.Hops[i] != want[i] {
+			t.Errorf("hop %d = %+v, want %+v", i+1, req.Hops[i], want[i])
+		}
+	}
+	if req.KeepAlive != 15 {
+		t.Errorf("KeepAlive = %d, want the first matching rule's 15", req.KeepAlive)
+	}
+
+	// What the request sets wins
+	req = &CreateTunnelRequest{KeepAlive: 5, Hops: []HopReq{{Host: "db.prod.example.com", AuthMethod: "password"}}}
+	defaults.applyMatchRules(req)
+	if req.KeepAlive != 5 || req.Hops[0].AuthMethod != "password" || req.Hops[0].KeyID != "" {
+		t.Errorf("Expected the request's settings to win, got keep-alive %d and hop %+v", req.KeepAlive, req.Hops[0])
+	}
+}
+
+func TestCreateTunnelWithMatchRules(t *testing.T) {
+	s := newTestServer(t)
+	s.tunnelDefaults = TunnelDefaults{
+		Match: []MatchRule{{Hosts: []string{"*.prod.example.com"}, User: "deploy", Port: 22, AuthMethod: types.AuthMethodAgent}},
+	}.withFallbacks()
+	create := func(body string) *httptest.ResponseRecorder { return s.postTunnel(body, nil) }
+
+	rec := create(`{"name":"a","type":"local","remoteHost":"db","remotePort":5432,"agentId":"edge-1",
+		"hops":[{"host":"bastion.prod.example.com"}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create with a matching hop status = %d: %s", rec.Code, rec.Body.String())
+	}
+	hop := s.manager.List()[0].Spec.Hops[0]
+	if hop.User != "deploy" || hop.Port != 22 || hop.AuthMethod != types.AuthMethodAgent {
+		t.Errorf("created hop = %+v, want the rule's settings", hop)
+	}
+
+	rec = create(`{"name":"b","type":"local","remoteHost":"db","remotePort":5432,"agentId":"edge-1",
+		"hops":[{"host":"bastion.staging.example.com"}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), ValCodeRequired) {
+		t.Errorf("create with an unmatched hop = %d %s, want %s", rec.Code, rec.Body.String(), ValCodeRequired)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
 )
 
 // Metrics holds all Prometheus metrics for the API
@@ -124,9 +126,76 @@ func (m *Metrics) UpdateTunnelMetrics(total, active, failed int) {
 	m.TunnelsFailed.Set(float64(failed))
 }
 
-// HandleMetrics returns the Prometheus metrics endpoint handler
-func HandleMetrics() http.Handler {
-	return promhttp.Handler()
+// HandleMetrics returns the Prometheus metrics endpoint handler, serving the
+// default registry plus any extra gatherers
+func HandleMetrics(extra ...prometheus.Gatherer) http.Handler {
+	if len(extra) == 0 {
+		return promhttp.Handler()
+	}
+	gatherers := append(prometheus.Gatherers{prometheus.DefaultGatherer}, extra...)
+	return promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
+}
+
+// tunnelCollector exports per-tunnel activity gauges, read from the manager
+// at scrape time so alerts can fire on tunnels that are connected but idle
+type tunnelCollector struct {
+	manager *tunnel.Manager
+
+	lastActivity *prometheus.Desc
+	staleSeconds *prometheus.Desc
+	stale        *prometheus.Desc
+}
+
+// newTunnelCollector creates a collector for the manager's tunnels
+func newTunnelCollector(manager *tunnel.Manager) *tunnelCollector {
+	labels := []string{"tunnel_id", "name"}
+	return &tunnelCollector{
+		manager: manager,
+		lastActivity: prometheus.NewDesc(
+			"lazytunnel_tunnel_last_activity_timestamp_seconds",
+			"Unix time traffic last moved through the tunnel",
+			labels, nil,
+		),
+		staleSeconds: prometheus.NewDesc(
+			"lazytunnel_tunnel_stale_seconds",
+			"Seconds since traffic last moved through the tunnel",
+			labels, nil,
+		),
+		stale: prometheus.NewDesc(
+			"lazytunnel_tunnel_stale",
+			"Whether the tunnel has been idle past its staleness threshold (1) or not (0)",
+			labels, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *tunnelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lastActivity
+	ch <- c.staleSeconds
+	ch <- c.stale
+}
+
+// Collect implements prometheus.Collector; only forwarding tunnels are reported
+func (c *tunnelCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range c.manager.List() {
+		status := t.GetStatus()
+		if status == nil || status.LastActivity == nil {
+			continue
+		}
+
+		stale := 0.0
+		if status.Stale {
+			stale = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.lastActivity, prometheus.GaugeValue,
+			float64(status.LastActivity.UnixNano())/1e9, t.Spec.ID, t.Spec.Name)
+		ch <- prometheus.MustNewConstMetric(c.staleSeconds, prometheus.GaugeValue,
+			status.StaleSeconds, t.Spec.ID, t.Spec.Name)
+		ch <- prometheus.MustNewConstMetric(c.stale, prometheus.GaugeValue,
+			stale, t.Spec.ID, t.Spec.Name)
+	}
 }
 
 // responseRecorder wraps http.ResponseWriter to capture status code and size
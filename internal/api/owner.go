@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+// anonymousOwner owns tunnels created while authentication is disabled
+const anonymousOwner = "api-user"
+
+// requestOwner is the owner of tunnels created by the request
+func requestOwner(r *http.Request) string {
+	if user, ok := GetUser(r.Context()); ok {
+		return user.Username
+	}
+	return anonymousOwner
+}
+
+// mayModify reports whether the request may change or delete a tunnel: its
+// owner and admins may, a share token granting control may start and stop
+// it, and anyone may while authentication is disabled
+func (s *Server) mayModify(r *http.Request, t *tunnel.Tunnel) bool {
+	if s.auth == nil {
+		return true
+	}
+	if claims, ok := GetClaims(r.Context()); ok && claims.Share != nil {
+		return claims.Share.Access == ShareAccessControl && claims.Share.TunnelID == t.Spec.ID
+	}
+	user, ok := GetUser(r.Context())
+	return ok && (user.Username == t.Spec.Owner || user.HasRole("admin"))
+}
+
+// ownedTunnel looks up the tunnel a mutating request targets, responding
+// with 404 if there is none and 403 if the caller may not modify it
+func (s *Server) ownedTunnel(w http.ResponseWriter, r *http.Request, tunnelID string) (*tunnel.Tunnel, bool) {
+	t, err := s.getTunnel(r, tunnelID)
+	return s.checkOwned(w, r, tunnelID, t, err)
+}
+
+// checkOwned responds with 404 if a tunnel lookup failed and 403 if the
+// caller may not modify the tunnel found
+func (s *Server) checkOwned(w http.ResponseWriter, r *http.Request, tunnelID string, t *tunnel.Tunnel, err error) (*tunnel.Tunnel, bool) {
+	if err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return nil, false
+	}
+	if !s.mayModify(r, t) {
+		s.Forbidden(w, "Only the tunnel's owner or an admin can modify it")
+		return nil, false
+	}
+	return t, true
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestTunnelOwnership(t *testing.T) {
+	s := newAuthTestServer(t,
+		&types.TunnelSpec{ID: "alice-db", Name: "alice-db", Owner: "alice", Type: types.TunnelTypeLocal},
+		&types.TunnelSpec{ID: "bob-db", Name: "bob-db", Owner: "bob", Type: types.TunnelTypeLocal},
+		&types.TunnelSpec{ID: "bob-cache", Name: "bob-cache", Owner: "bob", Type: types.TunnelTypeLocal},
+	)
+
+	alice := &User{ID: "1", Username: "alice", Roles: []string{"user"}}
+	admin := &User{ID: "2", Username: "root", Roles: []string{"admin"}}
+
+	list := func(query string, user *User) []TunnelResponse {
+		t.Helper()
+		var tunnels []TunnelResponse
+		decodeJSON(t, serve(s.handleListTunnels, newRequest(http.MethodGet, "/api/v1/tunnels?"+query, "", user, nil)), &tunnels)
+		return tunnels
+	}
+	remove := func(id string, user *User) int {
+		return serve(s.handleDeleteTunnel, newRequest(http.MethodDelete, "/api/v1/tunnels/"+id, "", user, map[string]string{"id": id})).Code
+	}
+
+	if tunnels := list("", alice); len(tunnels) != 3 {
+		t.Errorf("Expected every tunnel without a filter, got %d", len(tunnels))
+	}
+	if tunnels := list("mine=true", alice); len(tunnels) != 1 || tunnels[0].ID != "alice-db" {
+		t.Errorf("Expected only alice's tunnel, got %+v", tunnels)
+	}
+	if tunnels := list("owner=bob", alice); len(tunnels) != 2 {
+		t.Errorf("Expected bob's 2 tunnels, got %d", len(tunnels))
+	}
+
+	rec := serve(s.handleGetTunnel, newRequest(http.MethodGet, "/api/v1/tunnels/bob-db?owner=alice", "", alice, map[string]string{"id": "bob-db"}))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 getting a tunnel of another owner, got %d", rec.Code)
+	}
+
+	if code := remove("bob-db", alice); code != http.StatusForbidden {
+		t.Errorf("Expected 403 deleting another user's tunnel, got %d", code)
+	}
+	if code := remove("alice-db", alice); code != http.StatusNoContent {
+		t.Errorf("Expected the owner to delete their tunnel, got %d", code)
+	}
+	if code := remove("bob-db", admin); code != http.StatusNoContent {
+		t.Errorf("Expected an admin to delete any tunnel, got %d", code)
+	}
+	if code := remove("missing", admin); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown tunnel, got %d", code)
+	}
+
+	// Reading files logs in as the owner, so it is theirs to do
+	rec = serve(s.handleDownloadFile, newRequest(http.MethodGet, "/api/v1/tunnels/bob-cache/files?path=/etc/hosts", "", alice, map[string]string{"id": "bob-cache"}))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 downloading through another user's tunnel, got %d", rec.Code)
+	}
+
+	// So does benchmarking, which loads the owner's SSH session
+	rec = serve(s.handleBench, newRequest(http.MethodPost, "/api/v1/tunnels/bob-cache/bench", "", alice, map[string]string{"id": "bob-cache"}))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 benchmarking another user's tunnel, got %d", rec.Code)
+	}
+}
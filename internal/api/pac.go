@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// newPACPolicy converts the PAC settings of a create request
+func newPACPolicy(req *PACReq) types.PACPolicy {
+	if req == nil {
+		return types.PACPolicy{}
+	}
+	policy := types.PACPolicy{CIDRs: req.CIDRs}
+	for _, domain := range req.Domains {
+		policy.Domains = append(policy.Domains, strings.ToLower(domain))
+	}
+	return policy
+}
+
+// newPACReq converts a PAC policy back to its create request form, nil if
+// it proxies nothing
+func newPACReq(policy types.PACPolicy) *PACReq {
+	if policy.Empty() {
+		return nil
+	}
+	return &PACReq{Domains: policy.Domains, CIDRs: policy.CIDRs}
+}
+
+// handleGetProxyPAC serves a proxy auto-config file that sends a dynamic
+// tunnel's configured domains and networks through its SOCKS proxy and
+// everything else direct. Browsers can't send an Authorization header for
+// it, so point them at the URL with ?token= and a read-only share token,
+// which can't do anything else.
+func (s *Server) handleGetProxyPAC(w http.ResponseWriter, r *http.Request) {
+	tunnelID := mux.Vars(r)["id"]
+	t, err := s.getTunnel(r, tunnelID)
+	if err != nil {
+		s.TunnelNotFound(w, tunnelID)
+		return
+	}
+	spec := t.Spec
+	if spec.Type != types.TunnelTypeDynamic {
+		s.BadRequest(w, "PAC files are only served for dynamic tunnels")
+		return
+	}
+
+	proxy, ok := pacProxyAddress(r, spec, t.GetStatus())
+	if !ok {
+		s.ConflictError(w, "Tunnel isn't listening yet: its SOCKS port is picked when it starts")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, renderPAC(spec.Name, proxy, spec.PAC))
+}
+
+// pacProxyAddress returns the host:port browsers reach a tunnel's SOCKS
+// proxy at: the address it listens on, or the host the API was reached at
+// when it listens on every address
+func pacProxyAddress(r *http.Request, spec *types.TunnelSpec, status *types.TunnelStatus) (string, bool) {
+	host, port := spec.LocalBindAddress, spec.LocalPort
+	if status != nil && status.BoundPort != 0 {
+		if bound, _, err := net.SplitHostPort(status.BoundAddress); err == nil {
+			host = bound
+		}
+		port = status.BoundPort
+	}
+	if port == 0 {
+		return "", false
+	}
+
+	host, _ = types.ParseBindAddress(host)
+	if ip, err := netip.ParseAddr(host); host == "" || (err == nil && ip.IsUnspecified()) {
+		host = r.Host
+		if requestHost, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = requestHost
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), true
+}
+
+// renderPAC writes the proxy auto-config script for a tunnel. Domains match
+// themselves and their subdomains; networks only match hosts given as IPv4
+// addresses, as resolving names in the browser would leak internal lookups
+// and fail for names only the far side knows.
+func renderPAC(name, proxy string, policy types.PACPolicy) string {
+	var networks [][2]string
+	for _, cidr := range policy.CIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil || !prefix.Addr().Is4() {
+			continue
+		}
+		mask := net.CIDRMask(prefix.Bits(), 32)
+		networks = append(networks, [2]string{prefix.Masked().Addr().String(), net.IP(mask).String()})
+	}
+
+	// JSON is valid JavaScript, quoting included
+	literal := func(v any) string {
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	domains := policy.Domains
+	if domains == nil {
+		domains = []string{}
+	}
+	if networks == nil {
+		networks = [][2]string{}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Proxy auto-config for lazytunnel tunnel %s\n", literal(name))
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	fmt.Fprintf(&b, "  var proxy = %s;\n", literal("SOCKS5 "+proxy+"; SOCKS "+proxy))
+	fmt.Fprintf(&b, "  var domains = %s;\n", literal(domains))
+	fmt.Fprintf(&b, "  var networks = %s;\n", literal(networks))
+	b.WriteString(`  host = host.toLowerCase();
+  for (var i = 0; i < domains.length; i++) {
+    if (host == domains[i] || dnsDomainIs(host, "." + domains[i])) {
+      return proxy;
+    }
+  }
+  if (/^\d+\.\d+\.\d+\.\d+$/.test(host)) {
+    for (var j = 0; j < networks.length; j++) {
+      if (isInNet(host, networks[j][0], networks[j][1])) {
+        return proxy;
+      }
+    }
+  }
+  return "DIRECT";
+}
+`)
+	return b.String()
+}
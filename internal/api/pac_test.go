@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestProxyPAC(t *testing.T) {
+	hops := []types.Hop{{Host: "bastion", Port: 22, User: "ops"}}
+	s := newTestServer(t,
+		&types.TunnelSpec{ID: "browse", Name: "browse", Type: types.TunnelTypeDynamic, Hops: hops,
+			LocalBindAddress: "0.0.0.0", LocalPort: 1080,
+			PAC: newPACPolicy(&PACReq{Domains: []string{"Corp.Example.com"}, CIDRs: []string{"10.20.0.0/16"}})},
+		&types.TunnelSpec{ID: "unbound", Name: "unbound", Type: types.TunnelTypeDynamic, Hops: hops},
+		&types.TunnelSpec{ID: "db", Name: "db", Type: types.TunnelTypeLocal, Hops: hops,
+			LocalPort: 5432, RemoteHost: "db.internal", RemotePort: 5432})
+
+	get := func(id string) *httptest.ResponseRecorder {
+		return serve(s.handleGetProxyPAC, newRequest(http.MethodGet, "http://tunnels.example.com:8080/api/v1/tunnels/"+id+"/proxy.pac", "",
+			nil, map[string]string{"id": id}))
+	}
+
+	rec := get("browse")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/x-ns-proxy-autoconfig" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	pac := rec.Body.String()
+	for _, want := range []string{
+		"function FindProxyForURL(url, host)",
+		// Listening on every address, so reached where the API was
+		`var proxy = "SOCKS5 tunnels.example.com:1080; SOCKS tunnels.example.com:1080";`,
+		`var domains = ["corp.example.com"];`,
+		`var networks = [["10.20.0.0","255.255.0.0"]];`,
+		`return "DIRECT";`,
+	} {
+		if !strings.Contains(pac, want) {
+			t.Errorf("PAC file is missing %s:\n%s", want, pac)
+		}
+	}
+
+	if rec := get("unbound"); rec.Code != http.StatusConflict {
+		t.Errorf("PAC for a tunnel without a port status = %d, want 409", rec.Code)
+	}
+	if rec := get("db"); rec.Code != http.StatusBadRequest {
+		t.Errorf("PAC for a local tunnel status = %d, want 400", rec.Code)
+	}
+	if rec := get("missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("PAC for a missing tunnel status = %d, want 404", rec.Code)
+	}
+}
+
+func TestRenderPACWithoutPolicy(t *testing.T) {
+	pac := renderPAC("socks", "127.0.0.1:1080", types.PACPolicy{})
+	for _, want := range []string{`var domains = [];`, `var networks = [];`} {
+		if !strings.Contains(pac, want) {
+			t.Errorf("PAC file is missing %s:\n%s", want, pac)
+		}
+	}
+}
+
+func TestProxyPACWithShareToken(t *testing.T) {
+	spec := &types.TunnelSpec{ID: "browse", Name: "browse", Owner: "alice", Type: types.TunnelTypeDynamic,
+		LocalBindAddress: "127.0.0.1", LocalPort: 1080, Hops: []types.Hop{{Host: "bastion", Port: 22, User: "ops"}}}
+	s := newAuthTestServer(t, spec)
+	auth := s.auth
+
+	router := mux.NewRouter()
+	protected := router.NewRoute().Subrouter()
+	protected.Use(auth.Middleware)
+	s.registerProjectRoutes(protected)
+
+	token, _, err := auth.IssueShareToken(&User{ID: "1", Username: "alice"}, types.DefaultProject,
+		ShareGrant{TunnelID: spec.ID, Access: ShareAccessRead}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As a browser would fetch it, with the token in the URL
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"?token="+token, nil))
+		return rec.Code
+	}
+	if code := get("/tunnels/browse/proxy.pac"); code != http.StatusOK {
+		t.Errorf("PAC with a read share token status = %d, want 200", code)
+	}
+	if code := get("/tunnels/browse/files"); code != http.StatusForbidden {
+		t.Errorf("files with a read share token status = %d, want 403", code)
+	}
+}
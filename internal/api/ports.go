@@ -0,0 +1,187 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// portSuggestionRange is how many ports above the requested one are tried
+// before falling back to an OS-assigned port
+const portSuggestionRange = 100
+
+// handleCheckPort reports whether a local port can be bound on the server
+// and which tunnels are configured to use it
+func (s *Server) handleCheckPort(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	port, err := strconv.Atoi(query.Get("port"))
+	if err != nil || port < 1 || port > 65535 {
+		s.BadRequest(w, "Invalid port: expected a number between 1 and 65535")
+		return
+	}
+
+	// Forwarders bind every interface unless the tunnel sets an address
+	bind := strings.TrimSuffix(strings.TrimPrefix(query.Get("bind"), "["), "]")
+	if bind == "" {
+		bind = "0.0.0.0"
+	}
+	if net.ParseIP(bind) == nil {
+		s.BadRequest(w, "Invalid bind: expected an IP address")
+		return
+	}
+
+	protocol := types.Protocol(query.Get("protocol"))
+	if protocol == "" {
+		protocol = types.ProtocolTCP
+	}
+	if protocol != types.ProtocolTCP && protocol != types.ProtocolUDP {
+		s.BadRequest(w, "Invalid protocol: expected tcp or udp")
+		return
+	}
+
+	usedBy := []map[string]interface{}{}
+	for _, t := range portClaims(s.manager, bind, port, protocol) {
+		usedBy = append(usedBy, map[string]interface{}{
+			"id":   t.Spec.ID,
+			"name": t.Spec.Name,
+		})
+	}
+
+	available := portFree(bind, port, protocol)
+	response := map[string]interface{}{
+		"port":      port,
+		"bind":      bind,
+		"protocol":  protocol,
+		"available": available,
+		"usedBy":    usedBy,
+	}
+
+	// Suggest an alternative when the port is taken, or claimed by a
+	// tunnel that isn't currently holding it
+	if query.Get("suggest") == "true" && (!available || len(usedBy) > 0) {
+		if suggestion, ok := suggestPort(s.manager, bind, port, protocol); ok {
+			response["suggestedPort"] = suggestion
+		}
+	}
+
+	s.respondJSON(w, http.StatusOK, response)
+}
+
+// portFree reports whether bind:port can be bound right now
+func portFree(bind string, port int, protocol types.Protocol) bool {
+	addr := net.JoinHostPort(bind, strconv.Itoa(port))
+
+	if protocol == types.ProtocolUDP {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// portClaims returns tunnels configured to listen on a port overlapping bind:port,
+// whatever their current state
+func portClaims(manager *tunnel.Manager, bind string, port int, protocol types.Protocol) []*tunnel.Tunnel {
+	var claims []*tunnel.Tunnel
+	for _, t := range manager.List() {
+		spec := t.Spec
+		if !listensOn(spec, port) {
+			continue
+		}
+		// Remote tunnels dial their local port rather than listening on it
+		if spec.Type != types.TunnelTypeLocal && spec.Type != types.TunnelTypeDynamic {
+			continue
+		}
+
+		specProtocol := spec.Protocol
+		if specProtocol == "" {
+			specProtocol = types.ProtocolTCP
+		}
+		if specProtocol != protocol {
+			continue
+		}
+
+		specBind := spec.LocalBindAddress
+		if specBind == "" {
+			specBind = "0.0.0.0"
+			if spec.LocalFamily == types.AddressFamilyIPv6 {
+				specBind = "::"
+			}
+		}
+		if bindsOverlap(specBind, bind) {
+			claims = append(claims, t)
+		}
+	}
+	return claims
+}
+
+// listensOn reports whether a spec binds port, directly or through one of
+// its multi-port mappings
+func listensOn(spec *types.TunnelSpec, port int) bool {
+	if len(spec.Ports) == 0 {
+		return spec.LocalPort == port
+	}
+	for _, mapping := range spec.Ports {
+		if mapping.LocalPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+// bindsOverlap reports whether listeners on the two addresses would clash.
+// localhost listens on both loopback addresses.
+func bindsOverlap(a, b string) bool {
+	if a == "localhost" {
+		return bindsOverlap("127.0.0.1", b) || bindsOverlap("::1", b)
+	}
+	if b == "localhost" {
+		return bindsOverlap(a, "127.0.0.1") || bindsOverlap(a, "::1")
+	}
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return a == b
+	}
+	return ipA.IsUnspecified() || ipB.IsUnspecified() || ipA.Equal(ipB)
+}
+
+// suggestPort finds a free port near the requested one that no tunnel claims,
+// falling back to one assigned by the OS
+func suggestPort(manager *tunnel.Manager, bind string, port int, protocol types.Protocol) (int, bool) {
+	for candidate := port + 1; candidate <= port+portSuggestionRange && candidate <= 65535; candidate++ {
+		if len(portClaims(manager, bind, candidate, protocol)) == 0 && portFree(bind, candidate, protocol) {
+			return candidate, true
+		}
+	}
+
+	addr := net.JoinHostPort(bind, "0")
+	if protocol == types.ProtocolUDP {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return 0, false
+		}
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port, true
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return 0, false
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, true
+}
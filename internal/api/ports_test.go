@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestHandleCheckPort(t *testing.T) {
+	s := newTestServer(t)
+
+	// Hold a port so it reads as taken
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	taken := listener.Addr().(*net.TCPAddr).Port
+
+	// A tunnel delegated to another agent claims the port without binding it
+	createTunnels(t, s.manager, &types.TunnelSpec{ID: "claim-1", Name: "db", Type: types.TunnelTypeLocal, LocalPort: taken})
+
+	check := func(query string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/ports/check?"+query, nil)
+		rec := httptest.NewRecorder()
+		s.handleCheckPort(rec, req)
+
+		var body map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+
+	code, body := check("port=" + strconv.Itoa(taken) + "&bind=127.0.0.1&suggest=true")
+	if code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if body["available"] != false {
+		t.Errorf("Expected port %d to be unavailable, got %v", taken, body["available"])
+	}
+	if usedBy, _ := body["usedBy"].([]interface{}); len(usedBy) != 1 {
+		t.Errorf("Expected one claiming tunnel, got %v", body["usedBy"])
+	}
+	suggested, ok := body["suggestedPort"].(float64)
+	if !ok || int(suggested) == taken {
+		t.Errorf("Expected a different suggested port, got %v", body["suggestedPort"])
+	}
+
+	// The suggestion must actually be free
+	code, body = check("port=" + strconv.Itoa(int(suggested)) + "&bind=127.0.0.1")
+	if code != http.StatusOK || body["available"] != true {
+		t.Errorf("Expected suggested port to be available, got %d %v", code, body)
+	}
+	if _, ok := body["suggestedPort"]; ok {
+		t.Error("Expected no suggestion unless requested")
+	}
+
+	for _, query := range []string{"", "port=0", "port=70000", "port=80&bind=localhost", "port=80&protocol=sctp"} {
+		if code, _ := check(query); code != http.StatusBadRequest {
+			t.Errorf("Query %q: expected 400, got %d", query, code)
+		}
+	}
+}
+
+func TestBindsOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"0.0.0.0", "127.0.0.1", true},
+		{"127.0.0.1", "0.0.0.0", true},
+		{"127.0.0.1", "127.0.0.1", true},
+		{"127.0.0.1", "10.0.0.1", false},
+		{"::", "127.0.0.1", true},
+		{"localhost", "::1", true},
+		{"127.0.0.1", "localhost", true},
+		{"localhost", "10.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := bindsOverlap(tt.a, tt.b); got != tt.want {
+			t.Errorf("bindsOverlap(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// hostWideSetting returns what in a create request changes the machine
+// running the tunnel beyond listening on its ports, or "" if nothing does.
+// Transparent tunnels install firewall rules redirecting the host's own
+// outgoing traffic, and system proxy tunnels repoint its OS-wide proxy.
+func hostWideSetting(req *CreateTunnelRequest) string {
+	switch {
+	case req.Type == string(types.TunnelTypeTransparent):
+		return "transparent tunnels"
+	case req.SystemProxy:
+		return "system proxy tunnels"
+	}
+	return ""
+}
+
+// mayChangeHost reports whether the request may create a tunnel with req's
+// host-wide settings, and what they are. Like command hooks, they need the
+// admin role.
+func (s *Server) mayChangeHost(r *http.Request, req *CreateTunnelRequest) (string, bool) {
+	setting := hostWideSetting(req)
+	if setting == "" || s.auth == nil {
+		return setting, true
+	}
+	user, ok := GetUser(r.Context())
+	return setting, ok && user.HasRole("admin")
+}
+
+// mayInstallKey reports whether the request may log in to hop to install a
+// managed key. Logging in to a host none of the caller's tunnels goes
+// through, or with the server's SSH agent, acts as the server rather than
+// as the caller, so it needs the admin role.
+func (s *Server) mayInstallKey(r *http.Request, req InstallKeyRequest, hop types.Hop) bool {
+	usesAgent := req.Password == "" && hop.AuthMethod == types.AuthMethodAgent
+	if s.auth == nil || (req.TunnelID != "" && !usesAgent) {
+		return true
+	}
+	user, ok := GetUser(r.Context())
+	return ok && user.HasRole("admin")
+}
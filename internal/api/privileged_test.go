@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHostWideSettingsRequireAdmin(t *testing.T) {
+	s := newAuthTestServer(t)
+
+	admin := &User{ID: "1", Username: "admin", Roles: []string{"admin"}}
+	user := &User{ID: "2", Username: "alice", Roles: []string{"user"}}
+
+	// Delegated to an agent, so nothing changes here
+	hops := `"agentId": "` + testAgentID + `", "hops": [{"host": "bastion.example.com", "port": 22, "user": "ops", "auth_method": "agent"}]`
+	transparent := func(name string) string {
+		return `{"name": "` + name + `", "type": "transparent", "routes": ["10.0.0.0/8"], ` + hops + `}`
+	}
+
+	tests := []struct {
+		name string
+		user *User
+		body string
+		want int
+	}{
+		{"transparent as user", user, transparent("user-transparent"), http.StatusForbidden},
+		{"transparent as admin", admin, transparent("admin-transparent"), http.StatusCreated},
+		{"dynamic as user", user, `{"name": "socks", "type": "dynamic", ` + hops + `}`, http.StatusCreated},
+		{"system proxy as user", user, `{"name": "user-browse", "type": "dynamic", "systemProxy": true, ` + hops + `}`, http.StatusForbidden},
+		{"system proxy as admin", admin, `{"name": "admin-browse", "type": "dynamic", "systemProxy": true, ` + hops + `}`, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := s.postTunnel(tt.body, tt.user); rec.Code != tt.want {
+				t.Fatalf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// Importing can't get around it
+	bundle := `{"version": 1, "tunnels": [` + transparent("imported-transparent") + `,
+		{"name": "imported-browse", "type": "dynamic", "systemProxy": true, ` + hops + `}]}`
+	rec := serve(s.handleImport, newRequest(http.MethodPost, "/api/v1/import", bundle, user, nil))
+	var result ImportResult
+	decodeJSON(t, rec, &result)
+	if len(result.Created) != 0 || len(result.Failed) != 2 {
+		t.Fatalf("Expected both tunnels to be refused, got %+v", result)
+	}
+	for _, failed := range result.Failed {
+		if !strings.Contains(failed.Error, "admin role") {
+			t.Errorf("Unexpected failure %+v", failed)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// projectContextKey holds the project a request is scoped to
+const projectContextKey contextKey = "project"
+
+// projectNamePattern matches project names: lowercase letters, digits and
+// dashes, so they are safe in URLs
+var projectNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ProjectSummary is a project visible to the caller
+type ProjectSummary struct {
+	Name    string `json:"name"`
+	Tunnels int    `json:"tunnels"`
+}
+
+// projectScope scopes a request to the {project} of its path or, on the
+// top-level routes, to the project of the caller's token. Users may only
+// reach their own project; admins may reach any.
+func (s *Server) projectScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		project, ok := mux.Vars(r)["project"]
+		if !ok {
+			project = tokenProject(r)
+		}
+		if !projectNamePattern.MatchString(project) {
+			s.BadRequest(w, "Invalid project name: use lowercase letters, digits and dashes")
+			return
+		}
+		if !s.mayAccessProject(r, project) {
+			s.Forbidden(w, fmt.Sprintf("No access to project %s", project))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), projectContextKey, project)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// mayAccessProject reports whether the caller may work in a project
+func (s *Server) mayAccessProject(r *http.Request, project string) bool {
+	if s.auth == nil {
+		return true
+	}
+	user, ok := GetUser(r.Context())
+	return ok && (user.HasRole("admin") || tokenProject(r) == project)
+}
+
+// tokenProject is the project of the caller's token
+func tokenProject(r *http.Request) string {
+	if user, ok := GetUser(r.Context()); ok && user.Project != "" {
+		return user.Project
+	}
+	return types.DefaultProject
+}
+
+// requestProject is the project a request is scoped to
+func requestProject(r *http.Request) string {
+	if project, ok := r.Context().Value(projectContextKey).(string); ok {
+		return project
+	}
+	return tokenProject(r)
+}
+
+// projectOf is the project a tunnel belongs to
+func projectOf(t *tunnel.Tunnel) string {
+	return t.Spec.ProjectName()
+}
+
+// getTunnel looks up a live tunnel of the request's project. Tunnels of
+// other projects are reported as not found, like tunnels that don't exist.
+func (s *Server) getTunnel(r *http.Request, tunnelID string) (*tunnel.Tunnel, error) {
+	t, err := s.manager.Get(tunnelID)
+	return inProject(r, t, err)
+}
+
+// getDeletedTunnel is getTunnel for soft-deleted tunnels
+func (s *Server) getDeletedTunnel(r *http.Request, tunnelID string) (*tunnel.Tunnel, error) {
+	t, err := s.manager.GetDeleted(tunnelID)
+	return inProject(r, t, err)
+}
+
+// inProject passes on a tunnel lookup if the tunnel found belongs to the
+// request's project
+func inProject(r *http.Request, t *tunnel.Tunnel, err error) (*tunnel.Tunnel, error) {
+	if err != nil {
+		return nil, err
+	}
+	if projectOf(t) != requestProject(r) {
+		return nil, fmt.Errorf("tunnel %s not found", t.Spec.ID)
+	}
+	return t, nil
+}
+
+// listTunnels returns the live tunnels of the request's project
+func (s *Server) listTunnels(r *http.Request) []*tunnel.Tunnel {
+	return projectTunnels(r, s.manager.List())
+}
+
+// listDeletedTunnels returns the soft-deleted tunnels of the request's project
+func (s *Server) listDeletedTunnels(r *http.Request) []*tunnel.Tunnel {
+	return projectTunnels(r, s.manager.ListDeleted())
+}
+
+// projectTunnels drops the tunnels of other projects
+func projectTunnels(r *http.Request, tunnels []*tunnel.Tunnel) []*tunnel.Tunnel {
+	project := requestProject(r)
+	return slices.DeleteFunc(tunnels, func(t *tunnel.Tunnel) bool { return projectOf(t) != project })
+}
+
+// handleListProjects returns the projects the caller can reach with their
+// tunnel counts: every project that has tunnels for admins, and the
+// caller's own project for everyone else
+func (s *Server) handleListProjects(w http.ResponseWriter, r *http.Request) {
+	counts := map[string]int{tokenProject(r): 0}
+	for _, t := range append(s.manager.List(), s.manager.ListDeleted()...) {
+		project := projectOf(t)
+		if s.mayAccessProject(r, project) {
+			counts[project]++
+		}
+	}
+
+	projects := make([]ProjectSummary, 0, len(counts))
+	for name, count := range counts {
+		projects = append(projects, ProjectSummary{Name: name, Tunnels: count})
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].Name < projects[j].Name })
+
+	s.respondJSON(w, http.StatusOK, projects)
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestProjectScoping(t *testing.T) {
+	s := newAuthTestServer(t,
+		&types.TunnelSpec{ID: "legacy-db", Name: "legacy-db", Owner: "alice", Type: types.TunnelTypeLocal},
+		&types.TunnelSpec{ID: "acme-db", Name: "acme-db", Owner: "alice", Project: "acme", Type: types.TunnelTypeLocal},
+		&types.TunnelSpec{ID: "globex-db", Name: "globex-db", Owner: "bob", Project: "globex", Type: types.TunnelTypeLocal},
+	)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/projects", s.handleListProjects)
+	s.registerProjectRoutes(router.PathPrefix("/projects/{project}").Subrouter())
+	s.registerProjectRoutes(router.NewRoute().Subrouter())
+
+	alice := &User{ID: "1", Username: "alice", Roles: []string{"user"}, Project: "acme"}
+	admin := &User{ID: "2", Username: "root", Roles: []string{"admin"}}
+
+	send := func(method, target string, user *User) *httptest.ResponseRecorder {
+		return serve(router.ServeHTTP, newRequest(method, target, "", user, nil))
+	}
+	list := func(target string, user *User) []TunnelResponse {
+		t.Helper()
+		var tunnels []TunnelResponse
+		decodeJSON(t, send(http.MethodGet, target, user), &tunnels)
+		return tunnels
+	}
+
+	// The top-level routes use the token's project
+	if tunnels := list("/tunnels", alice); len(tunnels) != 1 || tunnels[0].ID != "acme-db" || tunnels[0].Project != "acme" {
+		t.Errorf("Expected only acme's tunnel, got %+v", tunnels)
+	}
+	if tunnels := list("/tunnels", admin); len(tunnels) != 1 || tunnels[0].Project != types.DefaultProject {
+		t.Errorf("Expected tunnels without a project in the default one, got %+v", tunnels)
+	}
+	if tunnels := list("/projects/acme/tunnels", alice); len(tunnels) != 1 {
+		t.Errorf("Expected acme's tunnel through the scoped route, got %+v", tunnels)
+	}
+
+	// Other projects are off limits to users, and their tunnels invisible
+	if rec := send(http.MethodGet, "/projects/globex/tunnels", alice); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 listing another project, got %d", rec.Code)
+	}
+	if rec := send(http.MethodGet, "/tunnels/globex-db", alice); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 getting another project's tunnel, got %d", rec.Code)
+	}
+	if rec := send(http.MethodGet, "/projects/globex/tunnels/globex-db", admin); rec.Code != http.StatusOK {
+		t.Errorf("Expected an admin to reach any project, got %d", rec.Code)
+	}
+	if rec := send(http.MethodGet, "/projects/Not_Valid/tunnels", admin); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid project name, got %d", rec.Code)
+	}
+
+	// Names are only unique within a project, so they don't give away
+	// what other projects hold
+	post := func(target, body string) *httptest.ResponseRecorder {
+		return serve(router.ServeHTTP, newRequest(http.MethodPost, target, body, alice, nil))
+	}
+	tunnelJSON := func(name string) string {
+		return `{"name": "` + name + `", "type": "local", "agentId": "edge-1", "localPort": 5432, "remoteHost": "db.internal", "remotePort": 5432,
+			"hops": [{"host": "bastion.example.com", "port": 22, "user": "ops", "auth_method": "agent"}]}`
+	}
+	if rec := post("/tunnels", tunnelJSON("globex-db")); rec.Code != http.StatusCreated {
+		t.Errorf("Expected another project's tunnel name to be free, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := post("/tunnels", tunnelJSON("acme-db")); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 reusing a name in the project, got %d", rec.Code)
+	}
+	var imported ImportResult
+	rec := post("/import", `{"version": 1, "tunnels": [`+tunnelJSON("legacy-db")+`]}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &imported); err != nil || len(imported.Created) != 1 {
+		t.Errorf("Expected the import to create legacy-db in acme, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var projects []ProjectSummary
+	rec = send(http.MethodGet, "/projects", alice)
+	if err := json.Unmarshal(rec.Body.Bytes(), &projects); err != nil || len(projects) != 1 || projects[0] != (ProjectSummary{Name: "acme", Tunnels: 3}) {
+		t.Errorf("Expected only alice's project, got %s", rec.Body.String())
+	}
+	rec = send(http.MethodGet, "/projects", admin)
+	if err := json.Unmarshal(rec.Body.Bytes(), &projects); err != nil || len(projects) != 3 {
+		t.Errorf("Expected every project for an admin, got %s", rec.Body.String())
+	}
+}
+
+func TestProjectMembership(t *testing.T) {
+	s := newAuthTestServer(t)
+	s.sessions = newMemorySessionStore()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/auth/login", s.handleLogin).Methods("POST")
+	protected := router.PathPrefix("/").Subrouter()
+	protected.Use(s.auth.Middleware)
+	protected.HandleFunc("/auth/sessions", s.handleListSessions).Methods("GET")
+
+	login := func(project string) int {
+		return serve(router.ServeHTTP, newRequest(http.MethodPost, "/auth/login",
+			`{"username":"admin","password":"lazytunnel","project":"`+project+`"}`, nil, nil)).Code
+	}
+	get := func(userID, project string) int {
+		token, _, err := s.auth.IssueProjectToken(userID, "alice", "alice@example.com", []string{"user"}, project)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := newRequest(http.MethodGet, "/auth/sessions", "", nil, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return serve(router.ServeHTTP, req).Code
+	}
+
+	// Without memberships everyone only belongs to the default project
+	if code := login(""); code != http.StatusOK {
+		t.Errorf("Expected the default project without memberships, got %d", code)
+	}
+	if code := login("acme"); code != http.StatusForbidden {
+		t.Errorf("Expected 403 logging in to another project, got %d", code)
+	}
+	if code := get("u2", "acme"); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a token of another project, got %d", code)
+	}
+
+	s.auth.SetProjects(map[string][]string{"user-1": {"acme"}, "u2": {"default", "acme"}})
+	if code := login("acme"); code != http.StatusOK {
+		t.Errorf("Expected a member to log in to acme, got %d", code)
+	}
+	if code := login(""); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for the default project the user isn't a member of, got %d", code)
+	}
+	if code := get("u2", "acme"); code != http.StatusOK {
+		t.Errorf("Expected a member's token to work, got %d", code)
+	}
+	if code := get("u2", "globex"); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a project outside the memberships, got %d", code)
+	}
+}
+
+func TestDeletedTunnelUpdatesStayInTheirProject(t *testing.T) {
+	s := newTestServer(t, &types.TunnelSpec{ID: "acme-db", Name: "acme-db", Owner: "alice", Project: "acme", Type: types.TunnelTypeLocal})
+	s.wsManager = NewWebSocketManager()
+
+	if err := s.manager.Delete(context.Background(), "acme-db"); err != nil {
+		t.Fatal(err)
+	}
+	s.broadcastTunnelUpdate("acme-db", &types.TunnelStatus{TunnelID: "acme-db", State: types.TunnelStateStopped})
+	if msg := <-s.wsManager.broadcast; msg.project != "acme" {
+		t.Errorf("Expected the deleted tunnel's update for acme only, got project %q", msg.project)
+	}
+
+	// Once purged, its project is unknown, so the update goes nowhere
+	if err := s.manager.Purge(context.Background(), "acme-db"); err != nil {
+		t.Fatal(err)
+	}
+	s.broadcastTunnelUpdate("acme-db", &types.TunnelStatus{TunnelID: "acme-db", State: types.TunnelStateStopped})
+	select {
+	case msg := <-s.wsManager.broadcast:
+		t.Errorf("Expected no update for a purged tunnel, got %+v", msg)
+	default:
+	}
+}
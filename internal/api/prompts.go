@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+// AnswerPromptRequest answers a pending keyboard-interactive prompt
+type AnswerPromptRequest struct {
+	Answers []string `json:"answers" validate:"dive,max=1024"`
+}
+
+// promptJSON converts a prompt to its API representation
+func promptJSON(p tunnel.AuthPrompt) map[string]interface{} {
+	questions := make([]map[string]interface{}, len(p.Questions))
+	for i, q := range p.Questions {
+		questions[i] = map[string]interface{}{
+			"text": q.Text,
+			"echo": q.Echo,
+		}
+	}
+
+	return map[string]interface{}{
+		"id":          p.ID,
+		"tunnelId":    p.TunnelID,
+		"host":        p.Host,
+		"user":        p.User,
+		"instruction": p.Instruction,
+		"questions":   questions,
+		"createdAt":   p.CreatedAt.Format(time.RFC3339),
+		"expiresAt":   p.ExpiresAt.Format(time.RFC3339),
+	}
+}
+
+// handleListPrompts returns the keyboard-interactive prompts waiting for
+// an answer from the caller: those of their tunnels in the request's
+// project, or of all its tunnels for admins
+func (s *Server) handleListPrompts(w http.ResponseWriter, r *http.Request) {
+	response := []map[string]interface{}{}
+	for _, p := range s.manager.PendingPrompts() {
+		if t, err := s.getTunnel(r, p.TunnelID); err == nil && s.mayAnswerPrompt(r, t) {
+			response = append(response, promptJSON(p))
+		}
+	}
+
+	s.respondJSON(w, http.StatusOK, response)
+}
+
+// handleAnswerPrompt submits answers to a pending prompt so the tunnel's
+// connection attempt can continue
+func (s *Server) handleAnswerPrompt(w http.ResponseWriter, r *http.Request) {
+	promptID := mux.Vars(r)["id"]
+
+	var req AnswerPromptRequest
+	if !s.decodeAndValidate(w, r, &req) {
+		return
+	}
+	// Others' prompts are reported as not found, like those that don't exist
+	if !s.answerable(r, promptID) {
+		s.NotFound(w, "Prompt")
+		return
+	}
+
+	if err := s.manager.AnswerPrompt(promptID, req.Answers); err != nil {
+		if errors.Is(err, tunnel.ErrPromptNotFound) {
+			s.NotFound(w, "Prompt")
+			return
+		}
+		s.BadRequest(w, err.Error())
+		return
+	}
+
+	s.requestLogger(r).Info().Str("prompt_id", promptID).Msg("Keyboard-interactive prompt answered")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// answerable reports whether the prompt is pending for a tunnel of the
+// request's project the caller may answer for
+func (s *Server) answerable(r *http.Request, promptID string) bool {
+	for _, p := range s.manager.PendingPrompts() {
+		if p.ID == promptID {
+			t, err := s.getTunnel(r, p.TunnelID)
+			return err == nil && s.mayAnswerPrompt(r, t)
+		}
+	}
+	return false
+}
+
+// mayAnswerPrompt reports whether the caller may see and answer a tunnel's
+// prompts, which ask for its owner's credentials: only the owner and admins
+// may
+func (s *Server) mayAnswerPrompt(r *http.Request, t *tunnel.Tunnel) bool {
+	if s.auth == nil {
+		return true
+	}
+	user, ok := GetUser(r.Context())
+	return ok && (user.Username == t.Spec.Owner || user.HasRole("admin"))
+}
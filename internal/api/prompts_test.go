@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestHandlePrompts(t *testing.T) {
+	s := newTestServer(t)
+
+	// Nothing pending yet
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/prompts", nil)
+	rec := httptest.NewRecorder()
+	s.handleListPrompts(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var prompts []interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &prompts); err != nil || len(prompts) != 0 {
+		t.Errorf("Expected an empty list, got %s", rec.Body.String())
+	}
+
+	// Answering an unknown prompt is a 404
+	rec = serve(s.handleAnswerPrompt, newRequest(http.MethodPost, "/api/v1/prompts/missing", `{"answers":["123456"]}`,
+		nil, map[string]string{"id": "missing"}))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Malformed bodies are rejected before reaching the manager
+	rec = serve(s.handleAnswerPrompt, newRequest(http.MethodPost, "/api/v1/prompts/missing", `{"answers":"123456"}`,
+		nil, map[string]string{"id": "missing"}))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPromptsAreForTheTunnelOwner(t *testing.T) {
+	s := newAuthTestServer(t,
+		&types.TunnelSpec{ID: "alice-db", Name: "alice-db", Owner: "alice", Type: types.TunnelTypeLocal},
+		&types.TunnelSpec{ID: "acme-db", Name: "acme-db", Owner: "alice", Project: "acme", Type: types.TunnelTypeLocal})
+	alice := &User{Username: "alice", Roles: []string{"user"}}
+	bob := &User{Username: "bob", Roles: []string{"user"}}
+	admin := &User{Username: "root", Roles: []string{"admin"}}
+
+	aliceDB, _ := s.manager.Get("alice-db")
+	for _, tc := range []struct {
+		user *User
+		want bool
+	}{{alice, true}, {bob, false}, {admin, true}} {
+		req := newRequest(http.MethodGet, "/api/v1/prompts", "", tc.user, nil)
+		if got := s.mayAnswerPrompt(req, aliceDB); got != tc.want {
+			t.Errorf("mayAnswerPrompt(%s) = %v, want %v", tc.user.Username, got, tc.want)
+		}
+	}
+
+	// Prompts of other projects' tunnels are not found
+	req := newRequest(http.MethodGet, "/api/v1/prompts", "", admin, nil)
+	if _, err := s.getTunnel(req, "acme-db"); err == nil {
+		t.Error("Expected acme-db to be outside the default project")
+	}
+
+	// Answering someone else's prompt is a 404, like an unknown one
+	rec := serve(s.handleAnswerPrompt, newRequest(http.MethodPost, "/api/v1/prompts/missing", `{"answers":["123456"]}`,
+		bob, map[string]string{"id": "missing"}))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The broadcast reaches the owner's and admins' clients in the project
+	msg := WebSocketMessage{Type: "auth_prompt", project: types.DefaultProject, owner: "alice"}
+	for _, tc := range []struct {
+		session *wsSession
+		want    bool
+	}{
+		{newWSSession("alice", types.DefaultProject, alice), true},
+		{newWSSession("bob", types.DefaultProject, bob), false},
+		{newWSSession("root", types.DefaultProject, admin), true},
+		{newWSSession("root", "acme", admin), false},
+		{newWSSession("anonymous", types.DefaultProject, nil), true},
+	} {
+		if got := tc.session.receives(msg); got != tc.want {
+			t.Errorf("receives(%s in %s) = %v, want %v", tc.session.userID, tc.session.project, got, tc.want)
+		}
+	}
+}
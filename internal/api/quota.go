@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+// QuotaStatus is the limits and current usage of one user or project
+type QuotaStatus struct {
+	Name   string             `json:"name"`
+	Limits tunnel.QuotaLimits `json:"limits"`
+	Usage  tunnel.QuotaUsage  `json:"usage"`
+}
+
+// QuotaResponse reports the quotas that apply to the caller's tunnels
+type QuotaResponse struct {
+	User    QuotaStatus `json:"user"`
+	Project QuotaStatus `json:"project"`
+}
+
+// handleGetQuota returns the caller's and their project's quotas and usage
+func (s *Server) handleGetQuota(w http.ResponseWriter, r *http.Request) {
+	quotas := s.manager.Quotas()
+	owner := requestOwner(r)
+	project := requestProject(r)
+
+	s.respondJSON(w, http.StatusOK, QuotaResponse{
+		User:    QuotaStatus{Name: owner, Limits: quotas.User, Usage: s.manager.UserUsage(owner)},
+		Project: QuotaStatus{Name: project, Limits: quotas.Project, Usage: s.manager.ProjectUsage(project)},
+	})
+}
+
+// quotaError responds to an error that exceeded a quota and reports
+// whether it did: with 403 for the number of tunnels, which only deleting
+// some frees, and 429 for active tunnels and bandwidth, which free up as
+// tunnels stop
+func (s *Server) quotaError(w http.ResponseWriter, err error) bool {
+	var qerr *tunnel.QuotaError
+	if !errors.As(err, &qerr) {
+		return false
+	}
+
+	status := http.StatusTooManyRequests
+	if qerr.Resource == tunnel.QuotaTunnels {
+		status = http.StatusForbidden
+	}
+	apiErr := NewAPIError(ErrCodeQuotaExceeded, qerr.Error()).WithDetails(
+		ErrorDetail{Field: "scope", Value: qerr.Scope},
+		ErrorDetail{Field: "name", Value: qerr.Name},
+		ErrorDetail{Field: "resource", Value: qerr.Resource},
+		ErrorDetail{Field: "limit", Value: qerr.Limit},
+		ErrorDetail{Field: "used", Value: qerr.Used},
+	)
+	s.ErrorResponse(w, status, apiErr)
+	return true
+}
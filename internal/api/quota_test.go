@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestQuotaEnforcement(t *testing.T) {
+	s := newTestServer(t)
+	s.manager.SetQuotas(tunnel.Quotas{User: tunnel.QuotaLimits{MaxTunnels: 2, MaxActive: 1}})
+	createTunnels(t, s.manager,
+		&types.TunnelSpec{ID: "q1", Name: "q1", Owner: anonymousOwner, Type: types.TunnelTypeLocal},
+		&types.TunnelSpec{ID: "q2", Name: "q2", Owner: anonymousOwner, Type: types.TunnelTypeLocal},
+	)
+	if err := s.manager.Start(context.Background(), "q1"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	rec := s.postTunnel(`{"name": "q3", "type": "local", "agentId": "edge-1", "localPort": 5432, "remoteHost": "db.internal", "remotePort": 5432,
+		"hops": [{"host": "bastion.example.com", "port": 22, "user": "ops", "auth_method": "agent"}]}`, nil)
+	if rec.Code != http.StatusForbidden || decodeAPIError(t, rec).Code != ErrCodeQuotaExceeded {
+		t.Errorf("Expected 403 QUOTA_EXCEEDED creating past the tunnel quota, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = serve(s.handleStartTunnel, newRequest(http.MethodPost, "/api/v1/tunnels/q2/start", "", nil, map[string]string{"id": "q2"}))
+	if rec.Code != http.StatusTooManyRequests || decodeAPIError(t, rec).Code != ErrCodeQuotaExceeded {
+		t.Errorf("Expected 429 QUOTA_EXCEEDED starting past the active quota, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var quota QuotaResponse
+	decodeJSON(t, serve(s.handleGetQuota, newRequest(http.MethodGet, "/api/v1/quota", "", nil, nil)), &quota)
+	want := QuotaStatus{
+		Name:   anonymousOwner,
+		Limits: tunnel.QuotaLimits{MaxTunnels: 2, MaxActive: 1},
+		Usage:  tunnel.QuotaUsage{Tunnels: 2, Active: 1},
+	}
+	if quota.User != want {
+		t.Errorf("Unexpected user quota %+v, want %+v", quota.User, want)
+	}
+	if quota.Project.Name != types.DefaultProject || quota.Project.Usage.Tunnels != 2 {
+		t.Errorf("Unexpected project quota %+v", quota.Project)
+	}
+}
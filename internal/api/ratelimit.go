@@ -1,9 +1,10 @@
 package api
 
 import (
-	"encoding/json"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -139,12 +140,11 @@ func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 		clientID := rl.extractClientID(r)
 
 		if !rl.Allow(clientID) {
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Retry-After", "1")
-			w.WriteHeader(http.StatusTooManyRequests)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Rate limit exceeded. Please try again later.",
-			})
+			// Seconds until the bucket refills by one request
+			retryAfter := int(math.Ceil(1 / rl.requestsPerSecond))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeAPIError(w, http.StatusTooManyRequests,
+				NewAPIError(ErrCodeRateLimit, "Rate limit exceeded. Please try again later."))
 			return
 		}
 
@@ -161,12 +161,11 @@ func (rl *RateLimiter) extractClientID(r *http.Request) string {
 	}
 
 	// Fall back to IP address
-	ip := rl.getClientIP(r)
-	return ip
+	return clientIP(r)
 }
 
-// getClientIP extracts the client IP address from the request
-func (rl *RateLimiter) getClientIP(r *http.Request) string {
+// clientIP extracts the client IP address from the request
+func clientIP(r *http.Request) string {
 	// Check X-Forwarded-For header (for requests behind proxy)
 	xff := r.Header.Get("X-Forwarded-For")
 	if xff != "" {
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newPanicCounter counts the requests whose handler panicked
+func newPanicCounter() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lazytunnel_http_panics_total",
+			Help: "Total number of HTTP requests whose handler panicked",
+		},
+		[]string{"method"},
+	)
+}
+
+// handlerPanic carries a panic from the goroutine a handler ran in, with
+// that goroutine's stack, to the one serving the request
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// recoveryMiddleware turns a panicking handler into a logged, counted 500
+// instead of a dropped connection. The stack is logged with the request ID
+// but not sent to the client.
+func (s *Server) recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &trackingWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			stack := debug.Stack()
+			if hp, ok := p.(handlerPanic); ok {
+				p, stack = hp.value, hp.stack
+			}
+			// The handler gave up on the response on purpose
+			if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(p)
+			}
+
+			s.requestLogger(r).Error().
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Str("panic", fmt.Sprint(p)).
+				Bytes("stack", stack).
+				Msg("Handler panicked")
+			if s.panics != nil {
+				s.panics.WithLabelValues(r.Method).Inc()
+			}
+
+			// Part of another response is out; cutting it short is all that's left
+			if tw.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			s.InternalError(w, "Internal server error")
+		}()
+		next.ServeHTTP(tw, r)
+	})
+}
+
+// trackingWriter records whether a response has been started
+type trackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (tw *trackingWriter) WriteHeader(code int) {
+	tw.wroteHeader = true
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *trackingWriter) Write(p []byte) (int, error) {
+	tw.wroteHeader = true
+	return tw.ResponseWriter.Write(p)
+}
+
+// Hijack delegates to the underlying ResponseWriter so WebSocket upgrades work
+func (tw *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := tw.ResponseWriter.(http.Hijacker); ok {
+		tw.wroteHeader = true
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("underlying ResponseWriter does not implement http.Hijacker")
+}
+
+// Flush delegates to the underlying ResponseWriter when supported
+func (tw *trackingWriter) Flush() {
+	tw.wroteHeader = true
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying ResponseWriter to http.ResponseController
+func (tw *trackingWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	s := &Server{
+		logger:   zerolog.New(&logs),
+		panics:   newPanicCounter(),
+		timeouts: RequestTimeouts{Default: time.Second, Long: time.Second},
+	}
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tunnels map[string]*struct{ Name string }
+		_ = tunnels["missing"].Name
+	})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"direct", s.loggingMiddleware(s.recoveryMiddleware(panicking))},
+		// The timeout middleware runs handlers in their own goroutine
+		{"through timeout", s.loggingMiddleware(s.recoveryMiddleware(s.timeoutMiddleware(panicking)))},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/tunnels", nil)
+			req.Header.Set(RequestIDHeader, "trace-panic")
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+			}
+			var apiErr APIError
+			if err := json.Unmarshal(rec.Body.Bytes(), &apiErr); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v: %s", err, rec.Body.String())
+			}
+			if apiErr.Code != ErrCodeInternal || strings.Contains(apiErr.Message, "nil pointer") {
+				t.Errorf("response = %+v, want a bare %s", apiErr, ErrCodeInternal)
+			}
+
+			var entry map[string]interface{}
+			if err := json.NewDecoder(&logs).Decode(&entry); err != nil {
+				t.Fatalf("panic log is not JSON: %v: %s", err, logs.String())
+			}
+			if entry["request_id"] != "trace-panic" || !strings.Contains(entry["panic"].(string), "nil pointer") {
+				t.Errorf("panic log = %v, want request_id trace-panic and the panic", entry)
+			}
+			if stack, _ := entry["stack"].(string); !strings.Contains(stack, "TestRecoveryMiddleware") {
+				t.Errorf("panic log stack does not reach the handler:\n%s", stack)
+			}
+
+			if got := testutil.ToFloat64(s.panics.WithLabelValues(http.MethodGet)); got != float64(i+1) {
+				t.Errorf("panics counted = %v, want %d", got, i+1)
+			}
+		})
+	}
+}
+
+func TestRecoveryMiddlewareStartedResponse(t *testing.T) {
+	s := &Server{logger: zerolog.Nop()}
+	handler := s.recoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"tunnels": [`))
+		panic("encoding failed")
+	}))
+
+	// A response that has started is aborted rather than patched up
+	defer func() {
+		p := recover()
+		if err, ok := p.(error); !ok || !errors.Is(err, http.ErrAbortHandler) {
+			t.Errorf("panic = %v, want http.ErrAbortHandler", p)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/tunnels", nil))
+	t.Error("expected the handler to abort the response")
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+// DefaultRelayDialTimeout is how long the relay waits to connect to a hop
+const DefaultRelayDialTimeout = 10 * time.Second
+
+// RelayConfig enables the WebSocket relay, which carries SSH connections to
+// hops with the wss transport for clients that can only make HTTPS requests
+type RelayConfig struct {
+	Enabled bool
+	// Hosts the relay may connect to: names, patterns such as *.internal,
+	// addresses or networks such as 10.0.0.0/8
+	AllowedHosts []string
+	AllowedPorts []int         // ports the relay may connect to
+	DialTimeout  time.Duration // zero uses the default
+
+	// Token the server's tunnels present to the relays their hops use
+	Token string
+}
+
+// allowedHost returns what to dial for host, if the relay may connect to
+// it. A name matching none of the allowed names is resolved, and dialed by
+// an address inside an allowed network, so the address checked is the one
+// connected to.
+func (c RelayConfig) allowedHost(ctx context.Context, host string) (string, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	var networks []netip.Prefix
+	for _, allowed := range c.AllowedHosts {
+		if prefix, err := netip.ParsePrefix(allowed); err == nil {
+			networks = append(networks, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(allowed); err == nil {
+			networks = append(networks, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else if ok, _ := path.Match(strings.ToLower(allowed), host); ok {
+			return host, true
+		}
+	}
+	if len(networks) == 0 {
+		return "", false
+	}
+
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else if addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host); err != nil {
+		return "", false
+	}
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		for _, network := range networks {
+			if network.Contains(addr) {
+				return addr.String(), true
+			}
+		}
+	}
+	return "", false
+}
+
+var relayUpgrader = websocket.Upgrader{
+	ReadBufferSize:  32 * 1024,
+	WriteBufferSize: 32 * 1024,
+	// Clients are SSH tools rather than browsers, and authenticate with a
+	// token rather than cookies
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleRelay connects to the host and port asked for and carries the
+// connection over a WebSocket
+func (s *Server) handleRelay(w http.ResponseWriter, r *http.Request) {
+	if !s.relay.Enabled {
+		s.NotFound(w, "Relay")
+		return
+	}
+
+	host, portStr := r.URL.Query().Get("host"), r.URL.Query().Get("port")
+	port, err := strconv.Atoi(portStr)
+	if host == "" || err != nil || port < 1 || port > 65535 {
+		s.BadRequest(w, "host and port query parameters are required")
+		return
+	}
+	if !slices.Contains(s.relay.AllowedPorts, port) {
+		s.Forbidden(w, "The relay does not connect to port "+portStr)
+		return
+	}
+	timeout := s.relay.DialTimeout
+	if timeout <= 0 {
+		timeout = DefaultRelayDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	allowed, ok := s.relay.allowedHost(ctx, host)
+	if !ok {
+		s.Forbidden(w, "The relay does not connect to "+host)
+		return
+	}
+
+	address := net.JoinHostPort(allowed, portStr)
+	target, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		s.ErrorResponse(w, http.StatusBadGateway, NewAPIError(ErrCodeTunnelConnection, "Relay failed to connect").
+			WithDetails(
+				ErrorDetail{Field: "address", Value: address},
+				ErrorDetail{Field: "reason", Value: err.Error()},
+			))
+		return
+	}
+	defer target.Close()
+
+	ws, err := relayUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already responded
+		return
+	}
+	conn := tunnel.NewWebSocketConn(ws)
+	defer conn.Close()
+
+	logger := s.logger.With().Str("target", address).Str("client", r.RemoteAddr).Logger()
+	if claims, ok := GetClaims(r.Context()); ok {
+		logger = logger.With().Str("user", claims.Username).Logger()
+	}
+	logger.Info().Msg("Relay connection opened")
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(target, conn)
+		if tcp, ok := target.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, target)
+		done <- struct{}{}
+	}()
+
+	// Either side ending ends the relay; SSH doesn't half-close
+	select {
+	case <-done:
+	case <-s.ctx.Done():
+	}
+	logger.Info().Msg("Relay connection closed")
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+func TestRelay(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	echoPort := echo.Addr().(*net.TCPAddr).Port
+
+	newServer := func(relay RelayConfig) *httptest.Server {
+		s := &Server{logger: zerolog.Nop(), ctx: context.Background(), relay: relay}
+		ts := httptest.NewServer(http.HandlerFunc(s.handleRelay))
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	relayURL := func(ts *httptest.Server, port int) string {
+		return "ws" + strings.TrimPrefix(ts.URL, "http") + "/?host=127.0.0.1&port=" + strconv.Itoa(port)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		ts := newServer(RelayConfig{})
+		_, resp, err := websocket.DefaultDialer.Dial(relayURL(ts, echoPort), nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("Expected 404 from a disabled relay, got %v", err)
+		}
+	})
+
+	t.Run("port not allowed", func(t *testing.T) {
+		ts := newServer(RelayConfig{Enabled: true, AllowedHosts: []string{"127.0.0.0/8"}, AllowedPorts: []int{22}})
+		_, resp, err := websocket.DefaultDialer.Dial(relayURL(ts, echoPort), nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Expected 403 for a port not allowed, got %v", err)
+		}
+	})
+
+	t.Run("host not allowed", func(t *testing.T) {
+		ts := newServer(RelayConfig{Enabled: true, AllowedHosts: []string{"10.0.0.0/8", "bastion.example.com"}, AllowedPorts: []int{echoPort}})
+		_, resp, err := websocket.DefaultDialer.Dial(relayURL(ts, echoPort), nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Expected 403 for a host not allowed, got %v", err)
+		}
+	})
+
+	t.Run("unreachable target", func(t *testing.T) {
+		closed, _ := net.Listen("tcp", "127.0.0.1:0")
+		port := closed.Addr().(*net.TCPAddr).Port
+		closed.Close()
+
+		ts := newServer(RelayConfig{Enabled: true, AllowedHosts: []string{"127.0.0.0/8"}, AllowedPorts: []int{port}})
+		_, resp, err := websocket.DefaultDialer.Dial(relayURL(ts, port), nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("Expected 502 for an unreachable target, got %v", err)
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		ts := newServer(RelayConfig{Enabled: true, AllowedHosts: []string{"127.0.0.0/8"}, AllowedPorts: []int{echoPort}})
+		ws, _, err := websocket.DefaultDialer.Dial(relayURL(ts, echoPort), nil)
+		if err != nil {
+			t.Fatalf("Relay refused the connection: %v", err)
+		}
+		conn := tunnel.NewWebSocketConn(ws)
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte("SSH-2.0-test\r\n"))
+		buf := make([]byte, 14)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "SSH-2.0-test\r\n" {
+			t.Fatalf("Echo through the relay = %q, %v", buf, err)
+		}
+	})
+}
+
+func TestRelayAllowedHost(t *testing.T) {
+	relay := RelayConfig{AllowedHosts: []string{"*.bastion.internal", "jump.example.com", "10.0.0.0/8", "192.168.1.5", "fd00::/8"}}
+
+	tests := []struct {
+		host string
+		want string
+		ok   bool
+	}{
+		{"db.bastion.internal", "db.bastion.internal", true},
+		{"JUMP.example.com.", "jump.example.com", true},
+		{"example.com", "", false},
+		{"10.1.2.3", "10.1.2.3", true},
+		{"::ffff:10.1.2.3", "10.1.2.3", true},
+		{"11.0.0.1", "", false},
+		{"192.168.1.5", "192.168.1.5", true},
+		{"192.168.1.6", "", false},
+		{"fd12::1", "fd12::1", true},
+		{"169.254.169.254", "", false},
+		// Resolved, and refused outside the networks
+		{"localhost", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := relay.allowedHost(context.Background(), tt.host)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("allowedHost(%q) = %q, %v, want %q, %v", tt.host, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// Nothing is allowed unless listed
+	if _, ok := (RelayConfig{}).allowedHost(context.Background(), "10.1.2.3"); ok {
+		t.Error("allowedHost() allowed a host with no hosts listed")
+	}
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+// defaultReportPeriod is how far back a usage report without ?from= goes
+const defaultReportPeriod = 30 * 24 * time.Hour
+
+// UsageTotals are what tunnels did over a report's period
+type UsageTotals struct {
+	Sessions      int64   `json:"sessions"` // times a tunnel was up
+	Connections   int64   `json:"connections"`
+	BytesSent     int64   `json:"bytesSent"`
+	BytesReceived int64   `json:"bytesReceived"`
+	Errors        int64   `json:"errors"`
+	ActiveSeconds float64 `json:"activeSeconds"`
+}
+
+// TunnelUsage is one tunnel's usage
+type TunnelUsage struct {
+	TunnelID string `json:"tunnelId"`
+	Name     string `json:"name"`
+	Owner    string `json:"owner"`
+	Deleted  bool   `json:"deleted,omitempty"`
+	UsageTotals
+}
+
+// UserUsage is the usage of the tunnels a user owns
+type UserUsage struct {
+	User    string `json:"user"`
+	Tunnels int    `json:"tunnels"`
+	UsageTotals
+}
+
+// UsageReport is the usage of a project's tunnels over [from, to)
+type UsageReport struct {
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Tunnels []TunnelUsage `json:"tunnels"`
+	Users   []UserUsage   `json:"users"`
+	Total   UsageTotals   `json:"total"`
+}
+
+// newUsageTotals converts a tunnel's usage
+func newUsageTotals(usage tunnel.Usage) UsageTotals {
+	return UsageTotals{
+		Sessions:      usage.Sessions,
+		Connections:   usage.Connections,
+		BytesSent:     usage.BytesSent,
+		BytesReceived: usage.BytesReceived,
+		Errors:        usage.Errors,
+		ActiveSeconds: usage.Active.Seconds(),
+	}
+}
+
+// handleUsageReport returns the usage of the project's tunnels, deleted ones
+// included, per tunnel and per owning user, from their traffic history over
+// [?from=, ?to=) (default: the last 30 days), as JSON or, with ?format=csv,
+// a CSV of the tunnels or, with ?by=user, of the users. Usage older than the
+// history's retention isn't known.
+func (s *Server) handleUsageReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		s.BadRequest(w, "Invalid format: expected json or csv")
+		return
+	}
+	by := query.Get("by")
+	if by != "" && by != "tunnel" && by != "user" {
+		s.BadRequest(w, "Invalid by: expected tunnel or user")
+		return
+	}
+	owner, ok := s.ownerFilter(w, r)
+	if !ok {
+		return
+	}
+
+	to := time.Now().UTC()
+	if value := query.Get("to"); value != "" {
+		t, err := parseReportTime(value)
+		if err != nil {
+			s.BadRequest(w, "Invalid to: expected an RFC 3339 time or a date such as 2024-05-01")
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultReportPeriod)
+	if value := query.Get("from"); value != "" {
+		t, err := parseReportTime(value)
+		if err != nil {
+			s.BadRequest(w, "Invalid from: expected an RFC 3339 time or a date such as 2024-04-01")
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		s.BadRequest(w, "from must be before to")
+		return
+	}
+
+	report := UsageReport{From: from, To: to, Tunnels: []TunnelUsage{}, Users: []UserUsage{}}
+	users := make(map[string]*tunnel.Usage)
+	tunnelCounts := make(map[string]int)
+	var total tunnel.Usage
+
+	tunnels := s.listTunnels(r)
+	current := len(tunnels)
+	for i, t := range append(tunnels, s.listDeletedTunnels(r)...) {
+		if owner != "" && t.Spec.Owner != owner {
+			continue
+		}
+		usage, err := s.history.Usage(r.Context(), t.Spec.ID, from, to)
+		if err != nil {
+			s.requestLogger(r).Error().Err(err).Str("tunnel_id", t.Spec.ID).Msg("Failed to read metrics history")
+			s.InternalError(w, "Failed to read metrics history")
+			return
+		}
+
+		report.Tunnels = append(report.Tunnels, TunnelUsage{
+			TunnelID:    t.Spec.ID,
+			Name:        t.Spec.Name,
+			Owner:       t.Spec.Owner,
+			Deleted:     i >= current,
+			UsageTotals: newUsageTotals(usage),
+		})
+		if users[t.Spec.Owner] == nil {
+			users[t.Spec.Owner] = &tunnel.Usage{}
+		}
+		users[t.Spec.Owner].Add(usage)
+		tunnelCounts[t.Spec.Owner]++
+		total.Add(usage)
+	}
+	for user, usage := range users {
+		report.Users = append(report.Users, UserUsage{User: user, Tunnels: tunnelCounts[user], UsageTotals: newUsageTotals(*usage)})
+	}
+	sort.Slice(report.Tunnels, func(i, j int) bool {
+		if report.Tunnels[i].Name != report.Tunnels[j].Name {
+			return report.Tunnels[i].Name < report.Tunnels[j].Name
+		}
+		return report.Tunnels[i].TunnelID < report.Tunnels[j].TunnelID
+	})
+	sort.Slice(report.Users, func(i, j int) bool { return report.Users[i].User < report.Users[j].User })
+	report.Total = newUsageTotals(total)
+
+	if format != "csv" {
+		s.respondJSON(w, http.StatusOK, report)
+		return
+	}
+
+	if by == "" {
+		by = "tunnel"
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="lazytunnel-usage-%s-%s-%s.csv"`,
+		by, from.Format("20060102"), to.Format("20060102")))
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	totals := func(t UsageTotals) []string {
+		return []string{
+			strconv.FormatInt(t.Sessions, 10),
+			strconv.FormatInt(t.Connections, 10),
+			strconv.FormatInt(t.BytesSent, 10),
+			strconv.FormatInt(t.BytesReceived, 10),
+			strconv.FormatInt(t.Errors, 10),
+			strconv.FormatFloat(t.ActiveSeconds, 'f', 0, 64),
+		}
+	}
+	totalsHeader := []string{"sessions", "connections", "bytes_sent", "bytes_received", "errors", "active_seconds"}
+	if by == "user" {
+		out.Write(append([]string{"user", "tunnels"}, totalsHeader...))
+		for _, u := range report.Users {
+			out.Write(append([]string{u.User, strconv.Itoa(u.Tunnels)}, totals(u.UsageTotals)...))
+		}
+	} else {
+		out.Write(append([]string{"tunnel_id", "name", "owner", "deleted"}, totalsHeader...))
+		for _, t := range report.Tunnels {
+			out.Write(append([]string{t.TunnelID, t.Name, t.Owner, strconv.FormatBool(t.Deleted)}, totals(t.UsageTotals)...))
+		}
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		s.requestLogger(r).Warn().Err(err).Msg("Failed to write usage report")
+	}
+}
+
+// parseReportTime parses a report bound given as an RFC 3339 time or a
+// date, meaning its start in UTC
+func parseReportTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// staticSnapshots serves fixed snapshots as saved traffic history
+type staticSnapshots []*types.MetricsSnapshot
+
+func (s staticSnapshots) SaveSnapshots(ctx context.Context, snapshots []*types.MetricsSnapshot) error {
+	return nil
+}
+
+func (s staticSnapshots) ListSnapshots(ctx context.Context, tunnelID string, since, until time.Time) ([]*types.MetricsSnapshot, error) {
+	var result []*types.MetricsSnapshot
+	for _, snap := range s {
+		if snap.TunnelID == tunnelID && !snap.Timestamp.Before(since) && snap.Timestamp.Before(until) {
+			result = append(result, snap)
+		}
+	}
+	return result, nil
+}
+
+func (s staticSnapshots) PruneSnapshots(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestUsageReport(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t,
+		&types.TunnelSpec{ID: "t1", Name: "db", Owner: "alice", Type: types.TunnelTypeLocal},
+		&types.TunnelSpec{ID: "t2", Name: "web", Owner: "alice", Type: types.TunnelTypeLocal},
+		&types.TunnelSpec{ID: "t3", Name: "cache", Owner: "bob", Type: types.TunnelTypeLocal})
+	manager := s.manager
+	if err := manager.Delete(ctx, "t3"); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := func(id string, minute int, connections, sent int64) *types.MetricsSnapshot {
+		return &types.MetricsSnapshot{TunnelID: id, Timestamp: day.Add(time.Duration(minute) * time.Minute),
+			State: types.TunnelStateActive, Connections: connections, BytesSent: sent, BytesReceived: sent / 10}
+	}
+	history := tunnel.NewHistoryRecorder(manager, time.Second, time.Minute)
+	history.Persist(tunnel.SnapshotPersistence{Store: staticSnapshots{
+		snapshot("t1", 0, 0, 0), snapshot("t1", 1, 3, 1000), snapshot("t1", 2, 5, 3000),
+		snapshot("t2", 0, 0, 0), snapshot("t2", 1, 1, 100),
+		snapshot("t3", 0, 0, 0), snapshot("t3", 1, 2, 500),
+		snapshot("t1", 60*24+1, 9, 9000), // the next day
+	}})
+	s.history = history
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleUsageReport(rec, httptest.NewRequest(http.MethodGet, "/api/v1/reports/usage"+query, nil))
+		return rec
+	}
+
+	rec := get("?from=2024-05-01&to=2024-05-02")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var report UsageReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Tunnels) != 3 || report.Tunnels[0].Name != "cache" || !report.Tunnels[0].Deleted {
+		t.Fatalf("tunnels = %+v, want 3 sorted by name with the deleted one marked", report.Tunnels)
+	}
+	if db := report.Tunnels[1]; db.Sessions != 1 || db.Connections != 5 || db.BytesSent != 3000 ||
+		db.BytesReceived != 300 || db.ActiveSeconds != 120 {
+		t.Errorf("db usage = %+v", db)
+	}
+	if len(report.Users) != 2 || report.Users[0].User != "alice" || report.Users[0].Tunnels != 2 ||
+		report.Users[0].Connections != 6 || report.Users[1].User != "bob" || report.Users[1].BytesSent != 500 {
+		t.Errorf("users = %+v", report.Users)
+	}
+	if report.Total.Connections != 8 || report.Total.Sessions != 3 {
+		t.Errorf("total = %+v", report.Total)
+	}
+
+	rec = get("?from=2024-05-01&to=2024-05-02&format=csv&by=user")
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+		t.Errorf("CSV content type = %q", rec.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	want := [][]string{
+		{"user", "tunnels", "sessions", "connections", "bytes_sent", "bytes_received", "errors", "active_seconds"},
+		{"alice", "2", "2", "6", "3100", "310", "0", "180"},
+		{"bob", "1", "1", "2", "500", "50", "0", "60"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("CSV rows = %q, want %q", rows, want)
+	}
+
+	for _, query := range []string{"?from=yesterday", "?from=2024-05-02&to=2024-05-01", "?format=xml", "?by=project"} {
+		if rec := get(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want 400", query, rec.Code)
+		}
+	}
+}
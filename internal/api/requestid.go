@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// RequestIDHeader carries the request ID in requests and responses
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client-supplied request IDs
+const maxRequestIDLength = 128
+
+const requestInfoContextKey contextKey = "request_info"
+
+// requestInfo identifies a request for logging. It is shared by pointer so
+// middleware further down (e.g. auth) can fill in the user for the access log.
+type requestInfo struct {
+	id   string
+	user string
+}
+
+// RequestID returns the ID of the request handling ctx, if any
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoContextKey).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// requestID returns the client's request ID when it is usable, or a new one
+func requestID(r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		return uuid.New().String()
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return uuid.New().String()
+		}
+	}
+	return id
+}
+
+// withRequestInfo attaches request identification to the request's context
+func withRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestInfoContextKey, info))
+}
+
+// recordRequestUser notes the authenticated user for the request's access log
+func recordRequestUser(ctx context.Context, user *User) {
+	if info, ok := ctx.Value(requestInfoContextKey).(*requestInfo); ok {
+		info.user = user.Username
+	}
+}
+
+// requestLogger returns the server logger annotated with the request ID and
+// authenticated user, for logging from handlers
+func (s *Server) requestLogger(r *http.Request) *zerolog.Logger {
+	ctx := s.logger.With()
+	if id := RequestID(r.Context()); id != "" {
+		ctx = ctx.Str("request_id", id)
+	}
+	if user, ok := GetUser(r.Context()); ok {
+		ctx = ctx.Str("user", user.Username)
+	}
+	logger := ctx.Logger()
+	return &logger
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLoggingMiddlewareRequestIDAndUser(t *testing.T) {
+	var logs bytes.Buffer
+	s := &Server{logger: zerolog.New(&logs)}
+	auth := NewAuthMiddleware("test-secret", time.Hour)
+
+	var seenID string
+	handler := s.loggingMiddleware(auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenID = RequestID(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	token, err := auth.GenerateToken("u1", "alice", "alice@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A usable client ID is kept and echoed back
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/tunnels", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(RequestIDHeader, "trace-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(RequestIDHeader); got != "trace-123" {
+		t.Errorf("response %s = %q, want trace-123", RequestIDHeader, got)
+	}
+	if seenID != "trace-123" {
+		t.Errorf("handler saw request ID %q, want trace-123", seenID)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("access log is not JSON: %v: %s", err, logs.String())
+	}
+	if entry["request_id"] != "trace-123" || entry["user"] != "alice" {
+		t.Errorf("access log = %v, want request_id trace-123 and user alice", entry)
+	}
+
+	// Unusable client IDs are replaced
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/tunnels", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(RequestIDHeader, "has spaces\n")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(RequestIDHeader); got == "" || got == "has spaces\n" {
+		t.Errorf("response %s = %q, want a generated ID", RequestIDHeader, got)
+	}
+}
@@ -8,14 +8,22 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 
+	"github.com/craigderington/lazytunnel/internal/addressbook"
 	"github.com/craigderington/lazytunnel/internal/agent"
+	"github.com/craigderington/lazytunnel/internal/cluster"
+	"github.com/craigderington/lazytunnel/internal/exposure"
+	"github.com/craigderington/lazytunnel/internal/keys"
+	"github.com/craigderington/lazytunnel/internal/sshserver"
 	"github.com/craigderington/lazytunnel/internal/tunnel"
 	"github.com/craigderington/lazytunnel/pkg/types"
+	"github.com/craigderington/lazytunnel/web"
 )
 
 // Server represents the API server
@@ -32,6 +40,59 @@ type Server struct {
 	storage     tunnel.Storage
 	agents      *agent.Registry
 	coordinator *agent.Coordinator
+	exposure    *exposure.Router
+	sshServer   *sshserver.Server
+	history     *tunnel.HistoryRecorder
+	statusSub   *tunnel.Subscription
+	cluster     *cluster.Node // nil unless instances share the storage
+	keyring     *keys.Keyring // nil unless managed keys are enabled
+	addresses   *addressbook.Book
+
+	allowedOrigins []string
+	tunnelDefaults TunnelDefaults
+	keyFiles       []string
+	compression    CompressionConfig
+	cacheRules     []CacheRule
+	relay          RelayConfig
+	statusPage     StatusPageConfig
+	faultInjection bool
+	translator     ValidationTranslator
+	timeouts       RequestTimeouts
+	idempotency    *idempotencyStore
+	sessions       SessionStore
+	systemMetrics  *systemMetricsCollector
+	fdGuard        *tunnel.FDGuard
+	promRegistry   *prometheus.Registry // per-server collectors, served alongside the default registry
+	panics         *prometheus.CounterVec
+}
+
+// Built-in defaults for tunnels created without these settings
+const (
+	DefaultTunnelKeepAlive  = 30 * time.Second
+	DefaultTunnelMaxRetries = 5
+)
+
+// TunnelDefaults are applied to tunnels created without these settings
+type TunnelDefaults struct {
+	AutoReconnect bool
+	KeepAlive     time.Duration
+	MaxRetries    int
+	BindAddress   string        // of tunnels listening locally; empty is all interfaces
+	IdleTimeout   time.Duration // of forwarded connections; zero is never
+
+	// Hop settings and keep-alives by host, checked in order
+	Match []MatchRule
+}
+
+// withFallbacks fills unset fields with the built-in defaults
+func (d TunnelDefaults) withFallbacks() TunnelDefaults {
+	if d.KeepAlive <= 0 {
+		d.KeepAlive = DefaultTunnelKeepAlive
+	}
+	if d.MaxRetries <= 0 {
+		d.MaxRetries = DefaultTunnelMaxRetries
+	}
+	return d
 }
 
 // TLSConfig holds TLS configuration
@@ -49,16 +110,154 @@ type Config struct {
 	TLS         *TLSConfig        // Optional TLS configuration
 	RateLimiter *RateLimiter      // Optional rate limiter
 	WebSocket   *WebSocketManager // Optional WebSocket manager
+	Exposure    *exposure.Router  // Optional public subdomain router for remote tunnels
+	SSHServer   *sshserver.Server // Optional SSH server agents register reverse forwards with
+
+	// Traffic history sampling (zero values use the defaults)
+	HistoryInterval  time.Duration
+	HistoryRetention time.Duration
+
+	// Saving traffic history to storage that supports it (zero values use
+	// the defaults); disabled when HistoryPersistInterval is negative
+	HistoryPersistInterval  time.Duration
+	HistoryPersistRetention time.Duration
+
+	// How often system metrics are collected and broadcast (zero uses the default)
+	SystemMetricsInterval time.Duration
+
+	// Origins allowed to call the API cross-origin; empty or "*" allows any
+	AllowedOrigins []string
+
+	// Defaults for tunnels created without them (zero values use the built-in defaults)
+	TunnelDefaults TunnelDefaults
+
+	// Private key files offered as identities for key authentication; empty
+	// offers those of ssh's defaults that exist
+	KeyFiles []string
+
+	// Secret managed keys are encrypted with at rest; empty disables
+	// managed keys
+	KeyEncryptionKey string
+
+	// Response compression and per-path Cache-Control overrides
+	Compression CompressionConfig
+	CacheRules  []CacheRule
+
+	// How long API requests may take before they are answered with a 504
+	RequestTimeouts RequestTimeouts
+
+	// How long responses to requests with an Idempotency-Key are kept for
+	// replay (zero uses the default)
+	IdempotencyTTL time.Duration
+
+	// How long deleted tunnels can be restored before they are purged for
+	// good (zero keeps them until purged explicitly)
+	DeletedRetention time.Duration
+
+	// Deleting tunnels stopped or failed for long (disabled by default)
+	InactiveCleanup InactiveCleanup
+
+	// Percentage of the file descriptor limit kept free by rejecting new
+	// forwarded connections; zero never rejects
+	FDHeadroom int
+
+	// Limits on the tunnels of each user and each project
+	Quotas tunnel.Quotas
+
+	// How fast tunnels may retry connecting, all together
+	RetryBudget tunnel.RetryBudget
+
+	// How hop hostnames are looked up and cached
+	DNS tunnel.DNSConfig
+
+	// When each tunnel's circuit breaker opens and closes again (zero
+	// values use the defaults)
+	CircuitBreaker tunnel.CircuitBreakerConfig
+
+	// High availability across instances sharing the storage
+	Cluster ClusterConfig
+
+	// WebSocket relay for hops with the wss transport
+	Relay RelayConfig
+
+	// Public, read-only status page of some tunnels
+	StatusPage StatusPageConfig
+
+	// Whether admins may inject failures into tunnels through the API, for
+	// testing; never enable it in production
+	FaultInjection bool
+
+	// Simulated SSH servers and destinations tunnels connect to instead of
+	// real ones, for developing the web UI; nil connects for real
+	Simulator *tunnel.Simulator
+
+	// Optional translation of validation error messages, e.g. into the
+	// server's language; nil keeps the English messages
+	ValidationTranslator ValidationTranslator
+}
+
+// ClusterConfig lets several instances share one storage: the one holding
+// the leader lease runs the tunnels and the others take over if it fails
+type ClusterConfig struct {
+	Enabled    bool
+	InstanceID string        // unique per instance
+	Address    string        // where this instance's API can be reached, for display
+	LeaseTTL   time.Duration // how long the leader lasts without renewing (zero uses the default)
 }
 
 // NewServer creates a new API server
 func NewServer(ctx context.Context, config Config) *Server {
 	manager := tunnel.NewManager(ctx)
+	manager.SetQuotas(config.Quotas)
+	manager.SetRetryBudget(config.RetryBudget)
+	manager.SetDNS(config.DNS)
+	manager.SetCircuitBreaker(config.CircuitBreaker)
+	manager.SetSimulator(config.Simulator)
+
+	// Turn connections away before the process runs out of file descriptors
+	fdGuard := tunnel.NewFDGuard(config.FDHeadroom)
+	manager.SetForwarderOptions(tunnel.WithFDGuard(fdGuard))
+
+	// Unlock managed keys for hops before any tunnel is started. They are
+	// kept in storage when it supports it, otherwise only in memory.
+	var keyring *keys.Keyring
+	if config.KeyEncryptionKey != "" {
+		var store keys.Store = keys.NewMemoryStore()
+		if keyStore, ok := config.Storage.(keys.Store); ok {
+			store = keyStore
+		}
+		var err error
+		if keyring, err = keys.New(store, config.KeyEncryptionKey); err != nil {
+			config.Logger.Error().Err(err).Msg("Failed to enable managed keys")
+		} else {
+			manager.SetKeySource(keyring)
+		}
+	}
+
+	// Resolve the address book entries tunnels reference, kept like
+	// managed keys
+	var addressStore addressbook.Store = addressbook.NewMemoryStore()
+	if store, ok := config.Storage.(addressbook.Store); ok {
+		addressStore = store
+	}
+	addresses := addressbook.New(addressStore)
+	manager.SetAddressBook(addresses)
 
 	// Configure storage if provided
+	var node *cluster.Node
 	if config.Storage != nil {
 		manager.SetStorage(config.Storage)
 
+		// Stand by until elected, so nothing starts before then
+		if config.Cluster.Enabled {
+			if store, ok := config.Storage.(cluster.Store); ok {
+				node = cluster.New(store, manager, config.Cluster.InstanceID, config.Cluster.Address,
+					config.Cluster.LeaseTTL, config.Logger)
+			} else {
+				config.Logger.Error().Msg("Storage does not support clustering; running standalone")
+			}
+		}
+
 		// Load existing tunnels from storage
 		if err := manager.LoadFromStorage(ctx); err != nil {
 			config.Logger.Error().Err(err).Msg("Failed to load tunnels from storage")
@@ -75,9 +274,13 @@ func NewServer(ctx context.Context, config Config) *Server {
 		wsManager.Start()
 	}
 
-	// Wire up tunnel status callback to broadcast via WebSocket
-	manager.SetStatusCallback(func(tunnelID string, status *types.TunnelStatus) {
-		wsManager.BroadcastTunnelUpdate(tunnelID, status)
+	// Announce keyboard-interactive challenges so a client can answer them
+	manager.SetPromptCallback(func(prompt tunnel.AuthPrompt) {
+		t, err := manager.Get(prompt.TunnelID)
+		if err != nil {
+			return
+		}
+		wsManager.BroadcastAuthPrompt(promptJSON(prompt), projectOf(t), t.Spec.Owner)
 	})
 
 	registry := agent.NewRegistry()
@@ -98,6 +301,83 @@ func NewServer(ctx context.Context, config Config) *Server {
 		storage:     config.Storage,
 		agents:      registry,
 		coordinator: coord,
+		exposure:    config.Exposure,
+		sshServer:   config.SSHServer,
+		cluster:     node,
+		keyring:     keyring,
+		addresses:   addresses,
+		history:     tunnel.NewHistoryRecorder(manager, config.HistoryInterval, config.HistoryRetention),
+
+		allowedOrigins: config.AllowedOrigins,
+		tunnelDefaults: config.TunnelDefaults.withFallbacks(),
+		keyFiles:       config.KeyFiles,
+		compression:    config.Compression,
+		cacheRules:     config.CacheRules,
+		relay:          config.Relay,
+		statusPage:     config.StatusPage,
+		faultInjection: config.FaultInjection,
+		translator:     config.ValidationTranslator,
+		timeouts:       config.RequestTimeouts.withFallbacks(),
+		idempotency:    newIdempotencyStore(config.IdempotencyTTL),
+		systemMetrics:  newSystemMetricsCollector(manager, wsManager, config.SystemMetricsInterval),
+		fdGuard:        fdGuard,
+		promRegistry:   prometheus.NewRegistry(),
+		panics:         newPanicCounter(),
+	}
+	if store, ok := config.Storage.(tunnel.SnapshotStore); ok && config.HistoryPersistInterval >= 0 {
+		s.history.Persist(tunnel.SnapshotPersistence{
+			Store:     store,
+			Interval:  config.HistoryPersistInterval,
+			Retention: config.HistoryPersistRetention,
+			OnError: func(err error) {
+				s.logger.Warn().Err(err).Msg("Failed to persist traffic history")
+			},
+		})
+	}
+	s.promRegistry.MustRegister(newTunnelCollector(manager), s.panics)
+	manager.SetRelayToken(config.Relay.Token)
+	manager.SetHookReporter(s.logHookResult)
+
+	// Track issued tokens in storage when it supports it
+	if store, ok := config.Storage.(SessionStore); ok {
+		s.sessions = store
+	} else {
+		s.sessions = newMemorySessionStore()
+	}
+	if s.auth != nil {
+		s.auth.SetSessionStore(s.sessions)
+	}
+
+	// Broadcast tunnel status changes via WebSocket, and take commands
+	s.statusSub = manager.Subscribe(s.broadcastTunnelUpdate, 0)
+	wsManager.SetCommandHandler(s.handleWebSocketCommand)
+
+	if s.cluster != nil {
+		go s.cluster.Run(ctx)
+	}
+	go s.history.Run(ctx)
+	go s.systemMetrics.Run(ctx)
+	go s.fdGuard.Run(ctx, time.Second)
+	go s.idempotency.Run(ctx)
+	go s.purgeDeleted(ctx, config.DeletedRetention)
+	go s.deleteInactive(ctx, config.InactiveCleanup)
+
+	forwardPorts := 0
+	if s.sshServer != nil {
+		forwardPorts = s.sshServer.PortCount()
+	}
+	s.logFDLimit(forwardPorts)
+
+	// Restore public subdomain routes for persisted remote tunnels
+	if s.exposure != nil {
+		for _, t := range manager.List() {
+			if t.Spec.PublicSubdomain == "" {
+				continue
+			}
+			if _, err := s.exposure.Assign(t.Spec.ID, t.Spec.PublicSubdomain, t.Spec.RemotePort); err != nil {
+				s.logger.Warn().Err(err).Str("tunnel_id", t.Spec.ID).Msg("Failed to restore public subdomain")
+			}
+		}
 	}
 
 	s.setupRoutes()
@@ -115,14 +395,25 @@ func NewServer(ctx context.Context, config Config) *Server {
 
 // setupRoutes configures the API routes
 func (s *Server) setupRoutes() {
-	// Apply CORS to main router first
+	// Apply CORS to main router first, inside panic recovery for the UI and
+	// status page
+	s.router.Use(s.recoveryMiddleware)
 	s.router.Use(s.corsMiddleware)
+	if s.compression.Enabled {
+		s.router.Use(s.compressionMiddleware)
+	}
+	s.router.Use(s.cacheControlMiddleware)
 
 	// API v1 routes
 	api := s.router.PathPrefix("/api/v1").Subrouter()
 
 	// Middleware
 	api.Use(s.loggingMiddleware)
+	api.Use(s.recoveryMiddleware)
+	if s.rateLimiter != nil {
+		api.Use(s.rateLimiter.Middleware)
+	}
+	api.Use(s.timeoutMiddleware)
 
 	// Health check (public)
 	api.HandleFunc("/health", s.handleHealth).Methods("GET", "OPTIONS")
@@ -131,10 +422,14 @@ func (s *Server) setupRoutes() {
 	api.HandleFunc("/openapi.yaml", s.handleOpenAPI).Methods("GET", "OPTIONS")
 
 	// Metrics endpoint (public - for Prometheus scraping)
-	api.Handle("/metrics", HandleMetrics()).Methods("GET", "OPTIONS")
+	api.Handle("/metrics", HandleMetrics(s.promRegistry)).Methods("GET", "OPTIONS")
 
 	// Authentication routes (public)
 	api.HandleFunc("/auth/login", s.handleLogin).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/jwks", s.handleJWKS).Methods("GET", "OPTIONS")
+
+	// Status page data, when enabled (public)
+	api.HandleFunc("/public/status", s.handlePublicStatus).Methods("GET", "OPTIONS")
 
 	// Agent routes (protected) — data-plane registration & sync
 	protectedAgents := api.PathPrefix("/agents").Subrouter()
@@ -153,26 +448,129 @@ func (s *Server) setupRoutes() {
 		protected.Use(s.auth.Middleware)
 	}
 
-	// Tunnel operations (protected)
-	protected.HandleFunc("/tunnels", s.handleListTunnels).Methods("GET", "OPTIONS")
-	protected.HandleFunc("/tunnels", s.handleCreateTunnel).Methods("POST", "OPTIONS")
-	protected.HandleFunc("/tunnels/{id}", s.handleGetTunnel).Methods("GET", "OPTIONS")
-	protected.HandleFunc("/tunnels/{id}", s.handleDeleteTunnel).Methods("DELETE", "OPTIONS")
-	protected.HandleFunc("/tunnels/{id}/start", s.handleStartTunnel).Methods("POST", "OPTIONS")
-	protected.HandleFunc("/tunnels/{id}/stop", s.handleStopTunnel).Methods("POST", "OPTIONS")
-	protected.HandleFunc("/tunnels/{id}/status", s.handleGetTunnelStatus).Methods("GET", "OPTIONS")
-	protected.HandleFunc("/tunnels/{id}/metrics", s.handleGetTunnelMetrics).Methods("GET", "OPTIONS")
+	// Projects visible to the caller (protected)
+	protected.HandleFunc("/projects", s.handleListProjects).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/cluster", s.handleGetCluster).Methods("GET", "OPTIONS")
+
+	// Project-scoped routes, under /projects/{project} and, for the project
+	// of the caller's token, at the top level (protected)
+	s.registerProjectRoutes(protected.PathPrefix("/projects/{project}").Subrouter())
+	s.registerProjectRoutes(protected.NewRoute().Subrouter())
+
+	// Sessions of the authenticated user (protected)
+	protected.HandleFunc("/auth/sessions", s.handleListSessions).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/auth/sessions/{id}", s.handleRevokeSession).Methods("DELETE", "OPTIONS")
+
+	// Keys hops can authenticate with (protected)
+	protected.HandleFunc("/identities", s.handleListIdentities).Methods("GET", "OPTIONS")
+
+	// Managed keys, encrypted at rest and referenced by hops as managed://<id> (protected)
+	protected.HandleFunc("/keys", s.handleListKeys).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/keys", s.handleCreateKey).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/keys/{id}", s.handleGetKey).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/keys/{id}", s.handleDeleteKey).Methods("DELETE", "OPTIONS")
+	protected.HandleFunc("/keys/{id}/rotate", s.handleRotateKey).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/keys/{id}/rotate/finish", s.handleFinishKeyRotation).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/keys/{id}/authorized_keys", s.handleAuthorizedKeyLine).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/keys/{id}/install", s.handleInstallKey).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/addresses", s.handleListAddresses).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/addresses", s.handleCreateAddress).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/addresses/{name}", s.handleGetAddress).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/addresses/{name}", s.handleUpdateAddress).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/addresses/{name}", s.handleDeleteAddress).Methods("DELETE", "OPTIONS")
+
+	// Port availability (protected)
+	protected.HandleFunc("/ports/check", s.handleCheckPort).Methods("GET", "OPTIONS")
+
+	// System metrics (protected)
+	protected.HandleFunc("/system/metrics", s.handleGetSystemMetrics).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/system/fds", s.requireRole("admin", s.handleGetSystemFDs)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/system/dns", s.requireRole("admin", s.handleGetSystemDNS)).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/system/dns", s.requireRole("admin", s.handleFlushSystemDNS)).Methods("DELETE")
+
+	// Reverse forwards registered with the embedded SSH server (protected)
+	protected.HandleFunc("/ssh/forwards", s.handleListReverseForwards).Methods("GET", "OPTIONS")
+
+	// WebSocket relay to SSH hops for clients behind firewalls (protected)
+	protected.HandleFunc("/relay", s.handleRelay).Methods("GET")
 
 	// System logs (protected)
 	protected.HandleFunc("/logs", s.handleGetLogs).Methods("GET", "OPTIONS")
 
-	// WebSocket endpoint for real-time updates (protected)
-	protected.HandleFunc("/ws", s.wsManager.HandleWebSocket)
+	// Conventional JWKS location for services verifying our tokens
+	s.router.HandleFunc("/.well-known/jwks.json", s.handleJWKS).Methods("GET", "OPTIONS")
+
+	// Status page (public, rate limited like the API)
+	if s.statusPage.Enabled {
+		var statusPage http.Handler = http.HandlerFunc(s.handleStatusPage)
+		if s.rateLimiter != nil {
+			statusPage = s.rateLimiter.Middleware(statusPage)
+		}
+		s.router.Handle("/status", statusPage).Methods("GET")
+	}
+
+	// Web frontend, embedded in the binary unless built with -tags noui
+	if web.Enabled() {
+		ui := web.Dist()
+		if ui == nil {
+			s.logger.Warn().Msg("Web UI was not embedded at build time; serving web/dist from disk")
+			ui = os.DirFS("web/dist")
+		}
+		s.router.PathPrefix("/").Handler(s.uiHandler(ui))
+	}
+}
+
+// registerProjectRoutes adds the routes whose tunnels belong to the
+// request's project to a protected router
+func (s *Server) registerProjectRoutes(router *mux.Router) {
+	router.Use(s.projectScope)
+
+	// Tunnel operations
+	router.HandleFunc("/tunnels", s.handleListTunnels).Methods("GET", "OPTIONS")
+	router.HandleFunc("/tunnels", s.idempotent(s.handleCreateTunnel)).Methods("POST", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}", s.handleGetTunnel).Methods("GET", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}", s.idempotent(s.handleDeleteTunnel)).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/start", s.idempotent(s.handleStartTunnel)).Methods("POST", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/stop", s.idempotent(s.handleStopTunnel)).Methods("POST", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/reconnect", s.handleReconnectTunnel).Methods("POST", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/restore", s.idempotent(s.handleRestoreTunnel)).Methods("POST", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/share", s.idempotent(s.handleShareTunnel)).Methods("POST", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/status", s.handleGetTunnelStatus).Methods("GET", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/metrics", s.handleGetTunnelMetrics).Methods("GET", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/metrics/history", s.handleGetTunnelMetricsHistory).Methods("GET", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/files", s.handleDownloadFile).Methods("GET", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/files", s.handleUploadFile).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/exec", s.requireRole("admin", s.handleExec)).Methods("POST", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/bench", s.handleBench).Methods("POST", "OPTIONS")
+	router.HandleFunc("/tunnels/{id}/proxy.pac", s.handleGetProxyPAC).Methods("GET", "OPTIONS")
+
+	// Failure injection, for testing (admin, when enabled)
+	if s.faultInjection {
+		router.HandleFunc("/tunnels/{id}/faults", s.requireRole("admin", s.handleGetFaults)).Methods("GET", "OPTIONS")
+		router.HandleFunc("/tunnels/{id}/faults", s.requireRole("admin", s.handleSetFaults)).Methods("PUT", "OPTIONS")
+		router.HandleFunc("/tunnels/{id}/faults", s.requireRole("admin", s.handleClearFaults)).Methods("DELETE", "OPTIONS")
+		router.HandleFunc("/tunnels/{id}/faults/drop", s.requireRole("admin", s.handleDropConnection)).Methods("POST", "OPTIONS")
+	}
+
+	// Keyboard-interactive prompts of the caller's tunnels
+	router.HandleFunc("/prompts", s.handleListPrompts).Methods("GET", "OPTIONS")
+	router.HandleFunc("/prompts/{id}", s.handleAnswerPrompt).Methods("POST", "OPTIONS")
+
+	// Live byte counters of tunnels and their connections
+	router.HandleFunc("/traffic", s.handleGetTraffic).Methods("GET", "OPTIONS")
+
+	// Quotas and usage of the caller and the project
+	router.HandleFunc("/quota", s.handleGetQuota).Methods("GET", "OPTIONS")
+
+	// Usage of tunnels per tunnel and user, for access reviews
+	router.HandleFunc("/reports/usage", s.requireRole("admin", s.handleUsageReport)).Methods("GET", "OPTIONS")
+
+	// Backup and migration of tunnel configurations
+	router.HandleFunc("/export", s.handleExport).Methods("GET", "OPTIONS")
+	router.HandleFunc("/import", s.idempotent(s.handleImport)).Methods("POST", "OPTIONS")
 
-	// Static files (web frontend) - serve from web/dist
-	s.router.PathPrefix("/").Handler(
-		http.StripPrefix("/", http.FileServer(http.Dir("web/dist"))),
-	)
+	// WebSocket endpoint for real-time updates
+	router.HandleFunc("/ws", s.wsManager.HandleWebSocket)
 }
 
 // Start starts the HTTP server (with optional TLS)
@@ -205,6 +603,16 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		return fmt.Errorf("failed to shutdown HTTP server: %w", err)
 	}
 
+	// Stop broadcasting before tunnels are torn down
+	s.statusSub.Unsubscribe()
+
+	// Hand the tunnels over to another instance
+	if s.cluster != nil {
+		if err := s.cluster.Leave(ctx); err != nil {
+			s.logger.Warn().Err(err).Msg("Failed to leave the cluster")
+		}
+	}
+
 	// Shutdown tunnel manager
 	if err := s.manager.Shutdown(); err != nil {
 		return fmt.Errorf("failed to shutdown tunnel manager: %w", err)
@@ -216,6 +624,20 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		s.logger.Info().Msg("WebSocket manager stopped")
 	}
 
+	// Shutdown public exposure router
+	if s.exposure != nil {
+		if err := s.exposure.Shutdown(ctx); err != nil {
+			return fmt.Errorf("failed to shutdown exposure router: %w", err)
+		}
+	}
+
+	// Shutdown embedded SSH server
+	if s.sshServer != nil {
+		if err := s.sshServer.Shutdown(ctx); err != nil {
+			return fmt.Errorf("failed to shutdown SSH server: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -224,26 +646,43 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		// Tag the request so its log lines can be correlated, and echo the
+		// ID back so clients can quote it
+		info := &requestInfo{id: requestID(r)}
+		r = withRequestInfo(r, info)
+		w.Header().Set(RequestIDHeader, info.id)
+
 		// Create response writer to capture status code
 		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
 		next.ServeHTTP(rw, r)
 
-		s.logger.Info().
+		event := s.logger.Info().
+			Str("request_id", info.id).
 			Str("method", r.Method).
 			Str("path", r.URL.Path).
 			Int("status", rw.statusCode).
-			Dur("duration", time.Since(start)).
-			Msg("HTTP request")
+			Dur("duration", time.Since(start))
+		if info.user != "" {
+			event = event.Str("user", info.user)
+		}
+		event.Msg("HTTP request")
 	})
 }
 
 // corsMiddleware adds CORS headers
 func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		origin, ok := s.corsOrigin(r.Header.Get("Origin"))
+		if ok {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if origin != "*" {
+			w.Header().Add("Vary", "Origin")
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-None-Match, Idempotency-Key, "+RequestIDHeader)
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, X-State-Version, Idempotent-Replayed, "+RequestIDHeader)
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -254,6 +693,18 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// corsOrigin returns the Access-Control-Allow-Origin value for a request
+// from origin, or false when the origin is not allowed
+func (s *Server) corsOrigin(origin string) (string, bool) {
+	if len(s.allowedOrigins) == 0 || slices.Contains(s.allowedOrigins, "*") {
+		return "*", true
+	}
+	if origin != "" && slices.Contains(s.allowedOrigins, origin) {
+		return origin, true
+	}
+	return "", false
+}
+
 // responseWriter wraps http.ResponseWriter to capture status code
 type responseWriter struct {
 	http.ResponseWriter
@@ -274,6 +725,11 @@ func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return nil, nil, fmt.Errorf("underlying ResponseWriter does not implement http.Hijacker")
 }
 
+// Unwrap exposes the underlying ResponseWriter to http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Flush delegates to the underlying ResponseWriter when supported.
 func (rw *responseWriter) Flush() {
 	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
@@ -290,10 +746,12 @@ func (s *Server) respondJSON(w http.ResponseWriter, status int, data interface{}
 	}
 }
 
-func (s *Server) respondError(w http.ResponseWriter, status int, message string) {
-	s.respondJSON(w, status, map[string]string{
-		"error": message,
-	})
+// publicURL returns the public URL for an exposed tunnel, or "" if not exposed
+func (s *Server) publicURL(spec *types.TunnelSpec) string {
+	if s.exposure == nil || spec.PublicSubdomain == "" {
+		return ""
+	}
+	return s.exposure.PublicURL(spec.PublicSubdomain)
 }
 
 // handleOpenAPI serves the OpenAPI specification.
@@ -301,7 +759,7 @@ func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
 	data, err := os.ReadFile("api/openapi.yaml")
 	if err != nil {
 		s.logger.Error().Err(err).Msg("Failed to read OpenAPI spec")
-		s.respondError(w, http.StatusInternalServerError, "OpenAPI spec unavailable")
+		s.InternalError(w, "OpenAPI spec unavailable")
 		return
 	}
 	w.Header().Set("Content-Type", "application/yaml")
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// SessionStore records issued tokens so they can be listed and revoked.
+// Storage passed in Config.Storage that implements it is used; otherwise
+// sessions are kept in memory and forgotten on restart.
+type SessionStore interface {
+	CreateSession(ctx context.Context, session *types.Session) error
+	// GetSession returns nil, without an error, for an unknown session
+	GetSession(ctx context.Context, id string) (*types.Session, error)
+	// ListSessions returns the user's sessions that are neither revoked nor expired
+	ListSessions(ctx context.Context, userID string) ([]*types.Session, error)
+	RevokeSession(ctx context.Context, id string) error
+}
+
+// memorySessionStore is the SessionStore used without persistent storage
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*types.Session
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: make(map[string]*types.Session)}
+}
+
+func (m *memorySessionStore) CreateSession(ctx context.Context, session *types.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Drop expired sessions as new ones arrive, so the map stays bounded
+	now := time.Now()
+	for id, existing := range m.sessions {
+		if !now.Before(existing.ExpiresAt) {
+			delete(m.sessions, id)
+		}
+	}
+
+	stored := *session
+	m.sessions[session.ID] = &stored
+	return nil
+}
+
+func (m *memorySessionStore) GetSession(ctx context.Context, id string) (*types.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	found := *session
+	return &found, nil
+}
+
+func (m *memorySessionStore) ListSessions(ctx context.Context, userID string) ([]*types.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var result []*types.Session
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.Active(now) {
+			found := *session
+			result = append(result, &found)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].IssuedAt.After(result[j].IssuedAt) })
+	return result, nil
+}
+
+func (m *memorySessionStore) RevokeSession(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return fmt.Errorf("session not found: %s", id)
+	}
+	if session.RevokedAt == nil {
+		now := time.Now()
+		session.RevokedAt = &now
+	}
+	return nil
+}
+
+// SessionResponse is an active session as the API returns it
+type SessionResponse struct {
+	ID        string `json:"id"`
+	Device    string `json:"device"`
+	IP        string `json:"ip"`
+	IssuedAt  string `json:"issuedAt"`
+	ExpiresAt string `json:"expiresAt"`
+	Current   bool   `json:"current"` // the session of the requesting token
+}
+
+// handleListSessions lists the caller's active sessions, newest first
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := GetUser(r.Context())
+	if !ok {
+		s.ServiceUnavailableError(w, "Authentication not configured")
+		return
+	}
+
+	sessions, err := s.sessions.ListSessions(r.Context(), user.ID)
+	if err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to list sessions")
+		s.InternalError(w, "Failed to list sessions")
+		return
+	}
+
+	var current string
+	if claims, ok := GetClaims(r.Context()); ok {
+		current = claims.ID
+	}
+
+	response := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = SessionResponse{
+			ID:        session.ID,
+			Device:    session.Device,
+			IP:        session.IP,
+			IssuedAt:  session.IssuedAt.Format(time.RFC3339),
+			ExpiresAt: session.ExpiresAt.Format(time.RFC3339),
+			Current:   session.ID == current,
+		}
+	}
+	s.respondJSON(w, http.StatusOK, response)
+}
+
+// handleRevokeSession revokes one of the caller's sessions; admins may
+// revoke anyone's
+func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := GetUser(r.Context())
+	if !ok {
+		s.ServiceUnavailableError(w, "Authentication not configured")
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	session, err := s.sessions.GetSession(r.Context(), id)
+	if err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to look up session")
+		s.InternalError(w, "Failed to revoke session")
+		return
+	}
+	if session == nil || (session.UserID != user.ID && !user.HasRole("admin")) {
+		s.NotFound(w, "Session")
+		return
+	}
+
+	if err := s.sessions.RevokeSession(r.Context(), id); err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to revoke session")
+		s.InternalError(w, "Failed to revoke session")
+		return
+	}
+
+	s.requestLogger(r).Info().
+		Str("session_id", id).
+		Str("session_user", session.Username).
+		Msg("Session revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
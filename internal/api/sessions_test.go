@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestSessionsListAndRevoke(t *testing.T) {
+	auth := NewAuthMiddleware("test-secret", time.Hour)
+	s := &Server{auth: auth, sessions: newMemorySessionStore(), logger: zerolog.Nop()}
+	auth.SetSessionStore(s.sessions)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/auth/login", s.handleLogin).Methods("POST")
+	protected := router.PathPrefix("/").Subrouter()
+	protected.Use(auth.Middleware)
+	protected.HandleFunc("/auth/sessions", s.handleListSessions).Methods("GET")
+	protected.HandleFunc("/auth/sessions/{id}", s.handleRevokeSession).Methods("DELETE")
+
+	send := func(method, path, token string) *httptest.ResponseRecorder {
+		var body *strings.Reader
+		if path == "/auth/login" {
+			body = strings.NewReader(`{"username":"admin","password":"lazytunnel"}`)
+		} else {
+			body = strings.NewReader("")
+		}
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("User-Agent", "tunnelctl/1.0")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func() string {
+		t.Helper()
+		rec := send(http.MethodPost, "/auth/login", "")
+		var resp struct {
+			Token     string `json:"token"`
+			ExpiresIn int    `json:"expiresIn"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Token == "" {
+			t.Fatalf("Login failed with %d: %s", rec.Code, rec.Body.String())
+		}
+		if resp.ExpiresIn <= 3500 || resp.ExpiresIn > 3600 {
+			t.Errorf("Expected expiresIn to follow the token expiration, got %d", resp.ExpiresIn)
+		}
+		return resp.Token
+	}
+	list := func(token string) []SessionResponse {
+		t.Helper()
+		rec := send(http.MethodGet, "/auth/sessions", token)
+		var sessions []SessionResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &sessions); err != nil {
+			t.Fatalf("List failed with %d: %s", rec.Code, rec.Body.String())
+		}
+		return sessions
+	}
+
+	laptop, phone := login(), login()
+
+	sessions := list(laptop)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %+v", sessions)
+	}
+	var phoneSession string
+	for _, session := range sessions {
+		if !session.Current {
+			phoneSession = session.ID
+		}
+		if session.IP != "192.0.2.1" || session.Device == "" {
+			t.Errorf("Expected the login's IP and device, got %+v", session)
+		}
+	}
+	if phoneSession == "" {
+		t.Fatal("Expected one session to be marked current")
+	}
+
+	if rec := send(http.MethodDelete, "/auth/sessions/"+phoneSession, laptop); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 revoking a session, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The revoked token no longer authenticates
+	rec := send(http.MethodGet, "/auth/sessions", phone)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a revoked token, got %d", rec.Code)
+	}
+	assertTokenInvalid(t, rec, "token revoked")
+
+	if sessions := list(laptop); len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("Expected only the current session to remain, got %+v", sessions)
+	}
+
+	if rec := send(http.MethodDelete, "/auth/sessions/unknown", laptop); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown session, got %d", rec.Code)
+	}
+}
+
+func TestSessionsOfOtherUsers(t *testing.T) {
+	auth := NewAuthMiddleware("test-secret", time.Hour)
+	s := &Server{auth: auth, sessions: newMemorySessionStore(), logger: zerolog.Nop()}
+	auth.SetSessionStore(s.sessions)
+
+	router := mux.NewRouter()
+	router.Use(auth.Middleware)
+	router.HandleFunc("/auth/sessions/{id}", s.handleRevokeSession).Methods("DELETE")
+
+	issue := func(userID string, roles ...string) (string, string) {
+		t.Helper()
+		token, claims, err := auth.IssueToken(userID, userID, userID+"@example.com", roles)
+		if err != nil {
+			t.Fatalf("IssueToken() error = %v", err)
+		}
+		err = s.sessions.CreateSession(t.Context(), newTestSession(claims))
+		if err != nil {
+			t.Fatalf("CreateSession() error = %v", err)
+		}
+		return token, claims.ID
+	}
+	revoke := func(token, id string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/auth/sessions/"+id, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	alice, aliceSession := issue("alice", "user")
+	bob, bobSession := issue("bob", "user")
+	admin, _ := issue("root", "admin")
+
+	if code := revoke(alice, bobSession); code != http.StatusNotFound {
+		t.Errorf("Expected 404 revoking another user's session, got %d", code)
+	}
+	if code := revoke(admin, aliceSession); code != http.StatusNoContent {
+		t.Errorf("Expected an admin to revoke any session, got %d", code)
+	}
+	if code := revoke(bob, bobSession); code != http.StatusNoContent {
+		t.Errorf("Expected a user to revoke their own session, got %d", code)
+	}
+}
+
+// newTestSession describes the session of an issued token
+func newTestSession(claims *JWTClaims) *types.Session {
+	return &types.Session{
+		ID:        claims.ID,
+		UserID:    claims.UserID,
+		Username:  claims.Username,
+		IssuedAt:  claims.IssuedAt.Time,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// Access a share token grants to its tunnel
+const (
+	ShareAccessRead    = "read"    // status, metrics and the PAC file
+	ShareAccessControl = "control" // read, plus starting, stopping and reconnecting it
+)
+
+// Bounds of a share token's lifetime
+const (
+	defaultShareTTL = time.Hour
+	maxShareTTL     = 7 * 24 * time.Hour
+)
+
+// ShareGrant is the scope of a share token: one tunnel, read-only or with
+// start/stop control
+type ShareGrant struct {
+	TunnelID string `json:"tunnelId"`
+	Access   string `json:"access"`
+}
+
+// shareReadRoutes are the routes any share token may use, and
+// shareControlRoutes those a control token may use besides; both are keyed
+// by the end of their path template
+var (
+	shareReadRoutes = map[string]string{
+		"/tunnels/{id}":                 http.MethodGet,
+		"/tunnels/{id}/status":          http.MethodGet,
+		"/tunnels/{id}/metrics":         http.MethodGet,
+		"/tunnels/{id}/metrics/history": http.MethodGet,
+		"/tunnels/{id}/proxy.pac":       http.MethodGet,
+	}
+	shareControlRoutes = map[string]string{
+		"/tunnels/{id}/start":     http.MethodPost,
+		"/tunnels/{id}/stop":      http.MethodPost,
+		"/tunnels/{id}/reconnect": http.MethodPost,
+	}
+)
+
+// allows reports whether a request is within the grant: one of the routes
+// its access allows, on its tunnel
+func (g *ShareGrant) allows(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil || mux.Vars(r)["id"] != g.TunnelID {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+
+	i := strings.LastIndex(template, "/tunnels/{id}")
+	if i < 0 {
+		return false
+	}
+	suffix := template[i:]
+	if method, ok := shareReadRoutes[suffix]; ok {
+		return r.Method == method
+	}
+	if method, ok := shareControlRoutes[suffix]; ok {
+		return g.Access == ShareAccessControl && r.Method == method
+	}
+	return false
+}
+
+// IssueShareToken issues a token that grants access to a single tunnel of
+// a project until it expires. It acts for the user sharing the tunnel, but
+// with no roles and under its own username, so it only ever passes checks
+// through its grant.
+func (am *AuthMiddleware) IssueShareToken(issuer *User, project string, grant ShareGrant, ttl time.Duration) (string, *JWTClaims, error) {
+	now := time.Now()
+	claims := &JWTClaims{
+		UserID:   issuer.ID,
+		Username: "share:" + issuer.Username,
+		Project:  project,
+		Share:    &grant,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}
+	return am.sign(claims)
+}
+
+// CreateShareRequest is the request body of POST /tunnels/{id}/share
+type CreateShareRequest struct {
+	Access    string `json:"access"`              // read (default) or control
+	ExpiresIn string `json:"expiresIn,omitempty"` // a duration, e.g. "30m"; default 1h, at most 7 days
+}
+
+// ShareResponse is a share token and a link to the tunnel's status using it
+type ShareResponse struct {
+	ID        string    `json:"id"` // the token's session, to revoke it with
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	TunnelID  string    `json:"tunnelId"`
+	Access    string    `json:"access"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// handleShareTunnel issues a share token for a tunnel. The token is
+// recorded as a session of the user sharing it, who can list and revoke it
+// through /auth/sessions.
+func (s *Server) handleShareTunnel(w http.ResponseWriter, r *http.Request) {
+	tunnelID := mux.Vars(r)["id"]
+
+	if s.auth == nil {
+		s.ServiceUnavailableError(w, "Authentication not configured")
+		return
+	}
+	user, ok := GetUser(r.Context())
+	if !ok {
+		s.ServiceUnavailableError(w, "Authentication not configured")
+		return
+	}
+	t, ok := s.ownedTunnel(w, r, tunnelID)
+	if !ok {
+		return
+	}
+
+	// The body is optional: an empty one shares read access for an hour
+	var req CreateShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.BadRequest(w, "Invalid request body: "+err.Error())
+		return
+	}
+
+	if req.Access == "" {
+		req.Access = ShareAccessRead
+	}
+	if req.Access != ShareAccessRead && req.Access != ShareAccessControl {
+		s.ValidationError(w, "Validation failed", []ValidationError{
+			{Field: "access", Code: ValCodeOneOf, Params: map[string]string{"param": ShareAccessRead + ", " + ShareAccessControl}, Message: fmt.Sprintf("Access must be %s or %s", ShareAccessRead, ShareAccessControl)},
+		})
+		return
+	}
+	ttl := defaultShareTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > maxShareTTL {
+			s.ValidationError(w, "Validation failed", []ValidationError{
+				{Field: "expiresIn", Code: ValCodeDuration, Params: map[string]string{"param": maxShareTTL.String()}, Message: fmt.Sprintf("expiresIn must be a positive duration of at most %s", maxShareTTL)},
+			})
+			return
+		}
+		ttl = d
+	}
+
+	grant := ShareGrant{TunnelID: t.Spec.ID, Access: req.Access}
+	token, claims, err := s.auth.IssueShareToken(user, projectOf(t), grant, ttl)
+	if err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to generate share token")
+		s.InternalError(w, "Failed to generate share token")
+		return
+	}
+
+	session := &types.Session{
+		ID:        claims.ID,
+		UserID:    claims.UserID,
+		Username:  claims.Username,
+		Device:    fmt.Sprintf("share link: %s access to %s", req.Access, t.Spec.Name),
+		IP:        clientIP(r),
+		IssuedAt:  claims.IssuedAt.Time,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if err := s.sessions.CreateSession(r.Context(), session); err != nil {
+		s.requestLogger(r).Error().Err(err).Msg("Failed to record share session")
+		s.InternalError(w, "Failed to generate share token")
+		return
+	}
+
+	s.requestLogger(r).Info().
+		Str("tunnel_id", t.Spec.ID).
+		Str("access", req.Access).
+		Time("expires_at", session.ExpiresAt).
+		Msg("Tunnel shared")
+
+	s.respondJSON(w, http.StatusCreated, ShareResponse{
+		ID:        claims.ID,
+		Token:     token,
+		URL:       shareURL(r, t.Spec.ID, token),
+		TunnelID:  t.Spec.ID,
+		Access:    req.Access,
+		ExpiresAt: session.ExpiresAt,
+	})
+}
+
+// shareURL links to a tunnel's status with a share token
+func shareURL(r *http.Request, tunnelID, token string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/api/v1/tunnels/%s/status?token=%s",
+		scheme, r.Host, url.PathEscape(tunnelID), url.QueryEscape(token))
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestShareTokens(t *testing.T) {
+	s := newAuthTestServer(t,
+		&types.TunnelSpec{ID: "alice-db", Name: "alice-db", Owner: "alice", Type: types.TunnelTypeLocal},
+		&types.TunnelSpec{ID: "alice-cache", Name: "alice-cache", Owner: "alice", Type: types.TunnelTypeLocal},
+	)
+	auth := s.auth
+	s.sessions = newMemorySessionStore()
+	auth.SetSessionStore(s.sessions)
+
+	router := mux.NewRouter()
+	protected := router.NewRoute().Subrouter()
+	protected.Use(auth.Middleware)
+	protected.HandleFunc("/auth/sessions/{id}", s.handleRevokeSession).Methods("DELETE")
+	s.registerProjectRoutes(protected)
+
+	alice, _, err := auth.IssueToken("1", "alice", "", []string{"user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, _, err := auth.IssueToken("2", "bob", "", []string{"user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	share := func(token, body string) ShareResponse {
+		t.Helper()
+		rec := send(http.MethodPost, "/tunnels/alice-db/share", token, body)
+		var resp ShareResponse
+		if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &resp) != nil {
+			t.Fatalf("Share failed with %d: %s", rec.Code, rec.Body.String())
+		}
+		return resp
+	}
+
+	if rec := send(http.MethodPost, "/tunnels/alice-db/share", bob, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 sharing another user's tunnel, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, "/tunnels/alice-db/share", alice, `{"access":"admin"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown access, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, "/tunnels/alice-db/share", alice, `{"expiresIn":"720h"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an expiry beyond the maximum, got %d", rec.Code)
+	}
+
+	read := share(alice, "")
+	if read.Access != ShareAccessRead || time.Until(read.ExpiresAt) > time.Hour || !strings.Contains(read.URL, "/api/v1/tunnels/alice-db/status?token=") {
+		t.Errorf("Expected read access for an hour by default, got %+v", read)
+	}
+
+	if rec := send(http.MethodGet, "/tunnels/alice-db/status?token="+read.Token, "", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected a read token to get the status through its link, got %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodPost, "/tunnels/alice-db/stop"},
+		{http.MethodDelete, "/tunnels/alice-db"},
+		{http.MethodPost, "/tunnels/alice-db/share"},
+		{http.MethodGet, "/tunnels/alice-cache/status"},
+		{http.MethodGet, "/tunnels"},
+	} {
+		if rec := send(tc.method, tc.path, read.Token, ""); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected a read token to be refused %s %s, got %d", tc.method, tc.path, rec.Code)
+		}
+	}
+
+	control := share(alice, `{"access":"control","expiresIn":"10m"}`)
+	if time.Until(control.ExpiresAt) > 10*time.Minute {
+		t.Errorf("Expected the requested expiry, got %s", control.ExpiresAt)
+	}
+	if rec := send(http.MethodPost, "/tunnels/alice-db/stop", control.Token, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected a control token to stop its tunnel, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(http.MethodPost, "/tunnels/alice-cache/stop", control.Token, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a control token to be refused another tunnel, got %d", rec.Code)
+	}
+	if rec := send(http.MethodDelete, "/tunnels/alice-db", control.Token, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a control token to be refused deleting its tunnel, got %d", rec.Code)
+	}
+
+	// Share tokens are sessions of the user who shared, who can revoke them
+	if rec := send(http.MethodDelete, "/auth/sessions/"+control.ID, alice, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected the share to be revoked, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := send(http.MethodGet, "/tunnels/alice-db/status", control.Token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked share token to be rejected, got %d", rec.Code)
+	}
+}
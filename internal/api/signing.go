@@ -0,0 +1,171 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSAKeyBits is the smallest RSA key accepted for signing tokens
+const minRSAKeyBits = 2048
+
+// NewKeyAuthMiddleware creates an authentication middleware that signs
+// tokens with a private key, RS256 for RSA and EdDSA for Ed25519, so other
+// services can verify them with the public key published as a JWKS instead
+// of sharing an HMAC secret
+func NewKeyAuthMiddleware(key crypto.Signer, tokenExpiration time.Duration) (*AuthMiddleware, error) {
+	var method jwt.SigningMethod
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA signing key must be at least %d bits, got %d", minRSAKeyBits, k.N.BitLen())
+		}
+		method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T: use RSA or Ed25519", key)
+	}
+
+	jwk, err := newJWK(key.Public(), method.Alg())
+	if err != nil {
+		return nil, err
+	}
+
+	am := NewAuthMiddleware("", tokenExpiration)
+	am.method = method
+	am.signingKey = key
+	am.verifyKey = key.Public()
+	am.jwk = jwk
+	return am, nil
+}
+
+// LoadSigningKey reads a PEM encoded RSA or Ed25519 private key, in PKCS #8
+// or (for RSA) PKCS #1 form
+func LoadSigningKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s: no PEM data found", path)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse signing key: %w", err)
+		}
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse signing key: %w", err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s: unsupported key type %T", path, key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("signing key %s: unsupported PEM block %q", path, block.Type)
+	}
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// newJWK describes a public key, identified by its RFC 7638 thumbprint
+func newJWK(pub crypto.PublicKey, alg string) (*JWK, error) {
+	enc := base64.RawURLEncoding
+	jwk := &JWK{Use: "sig", Algorithm: alg}
+
+	// Thumbprint members are the required ones, in lexical order
+	var thumbprint []byte
+	var err error
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = enc.EncodeToString(k.N.Bytes())
+		jwk.E = enc.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		thumbprint, err = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N})
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = enc.EncodeToString(k)
+		thumbprint, err = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X})
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encode key thumbprint: %w", err)
+	}
+
+	sum := sha256.Sum256(thumbprint)
+	jwk.KeyID = enc.EncodeToString(sum[:])
+	return jwk, nil
+}
+
+// JWKS returns the public keys tokens can be verified with, empty when
+// tokens are signed with a shared secret
+func (am *AuthMiddleware) JWKS() JWKS {
+	if am.jwk == nil {
+		return JWKS{Keys: []JWK{}}
+	}
+	return JWKS{Keys: []JWK{*am.jwk}}
+}
+
+// handleJWKS publishes the public key that verifies issued tokens
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	if s.auth == nil || s.auth.jwk == nil {
+		s.ErrorResponse(w, http.StatusNotFound, NewAPIError(ErrCodeNotFound,
+			"No public key: tokens are signed with a shared secret, set auth.signing_key to publish one"))
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	if err := json.NewEncoder(w).Encode(s.auth.JWKS()); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to encode JWKS")
+	}
+}
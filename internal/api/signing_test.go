@@ -0,0 +1,183 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+)
+
+// writeKey writes key as a PKCS #8 PEM file and returns its path
+func writeKey(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwt.key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return path
+}
+
+func TestKeyAuthMiddleware(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		key  crypto.Signer
+		alg  string
+	}{
+		{name: "Ed25519", key: edKey, alg: "EdDSA"},
+		{name: "RSA", key: rsaKey, alg: "RS256"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := LoadSigningKey(writeKey(t, tt.key))
+			if err != nil {
+				t.Fatalf("LoadSigningKey() error = %v", err)
+			}
+			am, err := NewKeyAuthMiddleware(key, time.Hour)
+			if err != nil {
+				t.Fatalf("NewKeyAuthMiddleware() error = %v", err)
+			}
+
+			token, err := am.GenerateToken("user-1", "alice", "alice@example.com", []string{"admin"})
+			if err != nil {
+				t.Fatalf("GenerateToken() error = %v", err)
+			}
+
+			// The middleware accepts its own tokens
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			am.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Status code = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			// Another service can verify them with nothing but the JWKS
+			jwks := am.JWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Algorithm != tt.alg {
+				t.Fatalf("JWKS = %+v, want one %s key", jwks, tt.alg)
+			}
+			jwk := jwks.Keys[0]
+			parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+				if token.Header["kid"] != jwk.KeyID {
+					t.Errorf("kid = %v, want %s", token.Header["kid"], jwk.KeyID)
+				}
+				return publicKeyFromJWK(t, jwk), nil
+			}, jwt.WithValidMethods([]string{tt.alg}))
+			if err != nil || !parsed.Valid {
+				t.Errorf("Failed to verify token with the JWKS: %v", err)
+			}
+
+			// A token signed with HMAC keyed by the public key is rejected
+			forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "user-1"}).
+				SignedString([]byte(jwk.X + jwk.N))
+			req = httptest.NewRequest(http.MethodGet, "/protected", nil)
+			req.Header.Set("Authorization", "Bearer "+forged)
+			w = httptest.NewRecorder()
+			am.Middleware(http.NotFoundHandler()).ServeHTTP(w, req)
+			assertTokenInvalid(t, w, "unexpected signing method")
+		})
+	}
+}
+
+// publicKeyFromJWK decodes the public key of a JWK
+func publicKeyFromJWK(t *testing.T, jwk JWK) crypto.PublicKey {
+	t.Helper()
+	decode := func(s string) []byte {
+		data, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatalf("Invalid JWK member %q: %v", s, err)
+		}
+		return data
+	}
+	switch jwk.KeyType {
+	case "OKP":
+		return ed25519.PublicKey(decode(jwk.X))
+	case "RSA":
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(decode(jwk.N)),
+			E: int(new(big.Int).SetBytes(decode(jwk.E)).Int64()),
+		}
+	}
+	t.Fatalf("Unexpected key type %q", jwk.KeyType)
+	return nil
+}
+
+func TestKeyAuthMiddlewareRejectsWeakRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	if _, err := NewKeyAuthMiddleware(key, time.Hour); err == nil {
+		t.Error("Expected a 1024-bit RSA key to be rejected")
+	}
+}
+
+func TestHandleJWKS(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	keyAuth, err := NewKeyAuthMiddleware(key, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyAuthMiddleware() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		auth       *AuthMiddleware
+		wantStatus int
+	}{
+		{name: "Private key", auth: keyAuth, wantStatus: http.StatusOK},
+		{name: "Shared secret", auth: NewAuthMiddleware("secret", time.Hour), wantStatus: http.StatusNotFound},
+		{name: "No auth", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{auth: tt.auth, logger: zerolog.Nop()}
+			w := httptest.NewRecorder()
+			s.handleJWKS(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Status code = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var jwks JWKS
+			if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil || len(jwks.Keys) != 1 {
+				t.Fatalf("Invalid JWKS %s: %v", w.Body.String(), err)
+			}
+			if jwk := jwks.Keys[0]; jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || jwk.Use != "sig" || jwk.KeyID == "" {
+				t.Errorf("Unexpected JWK %+v", jwk)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// ReverseForwardsResponse describes the embedded SSH server and the reverse
+// forwards agents registered with it
+type ReverseForwardsResponse struct {
+	Enabled            bool             `json:"enabled"`
+	Addr               string           `json:"addr,omitempty"`
+	HostKeyFingerprint string           `json:"hostKeyFingerprint,omitempty"`
+	Forwards           []ReverseForward `json:"forwards"`
+}
+
+// ReverseForward is a port an agent forwards back to itself
+type ReverseForward struct {
+	Agent         string    `json:"agent"`
+	Port          int       `json:"port"`
+	RequestedAddr string    `json:"requestedAddr"`
+	RequestedPort int       `json:"requestedPort"`
+	RemoteAddr    string    `json:"remoteAddr"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+	ActiveConns   int64     `json:"activeConns"`
+}
+
+// handleListReverseForwards lists the reverse forwards registered with the
+// embedded SSH server
+func (s *Server) handleListReverseForwards(w http.ResponseWriter, r *http.Request) {
+	resp := ReverseForwardsResponse{Forwards: []ReverseForward{}}
+	if s.sshServer == nil {
+		s.respondJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	resp.Enabled = true
+	resp.Addr = s.sshServer.Addr()
+	resp.HostKeyFingerprint = s.sshServer.HostKeyFingerprint()
+	for _, f := range s.sshServer.Forwards() {
+		resp.Forwards = append(resp.Forwards, ReverseForward{
+			Agent:         f.Agent,
+			Port:          f.Port,
+			RequestedAddr: f.RequestedAddr,
+			RequestedPort: f.RequestedPort,
+			RemoteAddr:    f.RemoteAddr,
+			ConnectedAt:   f.ConnectedAt,
+			ActiveConns:   f.ActiveConns,
+		})
+	}
+	s.respondJSON(w, http.StatusOK, resp)
+}
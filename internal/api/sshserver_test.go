@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/craigderington/lazytunnel/internal/sshserver"
+)
+
+func TestListReverseForwards(t *testing.T) {
+	list := func(s *Server) ReverseForwardsResponse {
+		rec := httptest.NewRecorder()
+		s.handleListReverseForwards(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ssh/forwards", nil))
+		var resp ReverseForwardsResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Listing forwards failed with %d: %s", rec.Code, rec.Body.String())
+		}
+		return resp
+	}
+
+	if resp := list(&Server{logger: zerolog.Nop()}); resp.Enabled || resp.Forwards == nil {
+		t.Errorf("Expected a disabled SSH server with no forwards, got %+v", resp)
+	}
+
+	dir := t.TempDir()
+	keys := filepath.Join(dir, "authorized_keys")
+	if err := os.WriteFile(keys, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sshServer, err := sshserver.NewServer(sshserver.Config{
+		HostKeyPath:        filepath.Join(dir, "host_key"),
+		AuthorizedKeysPath: keys,
+	})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	resp := list(&Server{logger: zerolog.Nop(), sshServer: sshServer})
+	if !resp.Enabled || resp.Addr != ":2222" || resp.HostKeyFingerprint != sshServer.HostKeyFingerprint() || resp.Forwards == nil {
+		t.Errorf("Unexpected SSH server response %+v", resp)
+	}
+}
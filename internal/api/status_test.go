@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestTunnelStatusLongPoll(t *testing.T) {
+	spec := &types.TunnelSpec{ID: "poll-1", Name: "db", Type: types.TunnelTypeLocal}
+	s := newTestServer(t, spec)
+	tun, _ := s.manager.Get(spec.ID)
+
+	poll := func(id, query string) *httptest.ResponseRecorder {
+		return serve(s.handleGetTunnelStatus, newRequest(http.MethodGet, "/api/v1/tunnels/"+id+"/status?"+query, "", nil, map[string]string{"id": id}))
+	}
+
+	t.Run("returns on change", func(t *testing.T) {
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- poll(spec.ID, "wait=5s") }()
+
+		// Let the request start waiting
+		time.Sleep(50 * time.Millisecond)
+		tun.UpdateStatus(types.TunnelStateActive, "")
+
+		select {
+		case rec := <-done:
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), `"state":"active"`) {
+				t.Errorf("Expected the new state, got %s", rec.Body.String())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the long poll to return on the status change")
+		}
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		start := time.Now()
+		rec := poll(spec.ID, "wait=100ms")
+		if rec.Code != http.StatusOK || time.Since(start) < 100*time.Millisecond {
+			t.Errorf("Expected 200 after the wait, got %d after %s", rec.Code, time.Since(start))
+		}
+	})
+
+	t.Run("missed change returns at once", func(t *testing.T) {
+		seen, err := strconv.ParseUint(poll(spec.ID, "").Header().Get("X-State-Version"), 10, 64)
+		if err != nil {
+			t.Fatalf("Missing X-State-Version: %v", err)
+		}
+		tun.UpdateStatus(types.TunnelStateFailed, "agent lost")
+
+		start := time.Now()
+		rec := poll(spec.ID, "wait=5s&since="+strconv.FormatUint(seen, 10))
+		if rec.Code != http.StatusOK || time.Since(start) > time.Second {
+			t.Errorf("Expected an immediate 200, got %d after %s", rec.Code, time.Since(start))
+		}
+	})
+
+	t.Run("invalid wait", func(t *testing.T) {
+		if rec := poll(spec.ID, "wait=soon"); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("unknown tunnel", func(t *testing.T) {
+		if rec := poll("missing", "wait=5s"); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// Fields a public status page can show besides each tunnel's name and status
+const (
+	StatusFieldUptime  = "uptime"  // when the tunnel connected
+	StatusFieldLatency = "latency" // round trip through its hops
+	StatusFieldError   = "error"   // why it is down
+	StatusFieldTraffic = "traffic" // bytes sent and received
+)
+
+// StatusPageFields lists the fields a status page can show
+var StatusPageFields = []string{StatusFieldUptime, StatusFieldLatency, StatusFieldError, StatusFieldTraffic}
+
+// DefaultStatusPageTitle heads the status page unless configured otherwise
+const DefaultStatusPageTitle = "Tunnel status"
+
+// StatusPageConfig enables an unauthenticated, read-only page showing
+// whether some tunnels are up, for people without an account
+type StatusPageConfig struct {
+	Enabled bool
+	Title   string
+	Tunnels []string // names of the tunnels shown; path.Match patterns such as staging-*
+	Fields  []string // of StatusPageFields, shown besides name and status
+}
+
+// PublicTunnelStatus is a tunnel as the status page shows it; the fields
+// that aren't configured are left out
+type PublicTunnelStatus struct {
+	Name          string     `json:"name"`
+	Status        string     `json:"status"`
+	Up            bool       `json:"up"`
+	ConnectedAt   *time.Time `json:"connectedAt,omitempty"`
+	LatencyMs     *float64   `json:"latencyMs,omitempty"`
+	LastError     *string    `json:"lastError,omitempty"`
+	BytesSent     *int64     `json:"bytesSent,omitempty"`
+	BytesReceived *int64     `json:"bytesReceived,omitempty"`
+}
+
+// PublicStatusResponse is the status page's data
+type PublicStatusResponse struct {
+	Title     string               `json:"title"`
+	UpdatedAt time.Time            `json:"updatedAt"`
+	Tunnels   []PublicTunnelStatus `json:"tunnels"`
+}
+
+// publicStatus collects the status page's tunnels, by name
+func (s *Server) publicStatus() PublicStatusResponse {
+	title := s.statusPage.Title
+	if title == "" {
+		title = DefaultStatusPageTitle
+	}
+	resp := PublicStatusResponse{Title: title, UpdatedAt: time.Now(), Tunnels: []PublicTunnelStatus{}}
+	show := func(field string) bool { return slices.Contains(s.statusPage.Fields, field) }
+
+	for _, t := range s.manager.List() {
+		if !s.onStatusPage(t.Spec.Name) {
+			continue
+		}
+		status := t.GetStatus()
+		if status == nil {
+			status = &types.TunnelStatus{State: types.TunnelStateStopped}
+		}
+
+		entry := PublicTunnelStatus{
+			Name:   t.Spec.Name,
+			Status: statusName(status.State),
+			Up:     status.State == types.TunnelStateActive,
+		}
+		if show(StatusFieldUptime) && entry.Up {
+			entry.ConnectedAt = status.ConnectedAt
+		}
+		if show(StatusFieldLatency) && entry.Up {
+			latency := float64(status.Latency) / float64(time.Millisecond)
+			entry.LatencyMs = &latency
+		}
+		if show(StatusFieldError) && status.LastError != "" {
+			entry.LastError = &status.LastError
+		}
+		if show(StatusFieldTraffic) {
+			entry.BytesSent, entry.BytesReceived = &status.BytesSent, &status.BytesReceived
+		}
+		resp.Tunnels = append(resp.Tunnels, entry)
+	}
+
+	sort.Slice(resp.Tunnels, func(i, j int) bool { return resp.Tunnels[i].Name < resp.Tunnels[j].Name })
+	return resp
+}
+
+// onStatusPage reports whether a tunnel is on the status page's allow-list
+func (s *Server) onStatusPage(name string) bool {
+	for _, pattern := range s.statusPage.Tunnels {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// handlePublicStatus returns the status page's data
+func (s *Server) handlePublicStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.statusPage.Enabled {
+		s.NotFound(w, "Status page")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	s.respondJSON(w, http.StatusOK, s.publicStatus())
+}
+
+var statusPageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"since": func(t *time.Time) string { return time.Since(*t).Round(time.Second).String() },
+	"bytes": func(n *int64) string { return formatByteCount(*n) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; color: #1f2937; }
+table { width: 100%; border-collapse: collapse; }
+th, td { text-align: left; padding: .5rem; border-bottom: 1px solid #e5e7eb; }
+.up { color: #15803d; } .down { color: #b91c1c; }
+footer { margin-top: 1rem; color: #6b7280; font-size: .875rem; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Tunnels}}<table>
+<tr><th>Tunnel</th><th>Status</th><th></th></tr>
+{{range .Tunnels}}<tr>
+<td>{{.Name}}</td>
+<td class="{{if .Up}}up{{else}}down{{end}}">{{if .Up}}● up{{else}}● {{.Status}}{{end}}</td>
+<td>{{if .ConnectedAt}}for {{since .ConnectedAt}} {{end}}{{if .LatencyMs}}{{printf "%.0f" .LatencyMs}} ms {{end}}{{if .BytesSent}}{{bytes .BytesSent}} out, {{bytes .BytesReceived}} in {{end}}{{if .LastError}}{{.LastError}}{{end}}</td>
+</tr>
+{{end}}</table>{{else}}<p>No tunnels to show.</p>{{end}}
+<footer>Updated {{.UpdatedAt.Format "2006-01-02 15:04:05 MST"}}</footer>
+</body>
+</html>
+`))
+
+// handleStatusPage serves the status page as HTML. It is only routed
+// when the status page is enabled.
+func (s *Server) handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := statusPageTemplate.Execute(w, s.publicStatus()); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to render status page")
+	}
+}
+
+// formatByteCount formats a byte count with a binary unit, e.g. 1.5 MiB
+func formatByteCount(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestPublicStatus(t *testing.T) {
+	s := newTestServer(t,
+		&types.TunnelSpec{ID: "staging-db", Name: "staging-db", Type: types.TunnelTypeLocal},
+		&types.TunnelSpec{ID: "staging-cache", Name: "staging-cache", Type: types.TunnelTypeLocal},
+		&types.TunnelSpec{ID: "prod-db", Name: "prod-db", Type: types.TunnelTypeLocal},
+	)
+	s.statusPage = StatusPageConfig{
+		Enabled: true,
+		Tunnels: []string{"staging-*"},
+		Fields:  []string{StatusFieldError},
+	}
+	db, _ := s.manager.Get("staging-db")
+	db.UpdateStatus(types.TunnelStateActive, "")
+	cache, _ := s.manager.Get("staging-cache")
+	cache.UpdateStatus(types.TunnelStateFailed, "connection refused")
+	prod, _ := s.manager.Get("prod-db")
+	prod.UpdateStatus(types.TunnelStateFailed, "secret.internal: no such host")
+
+	rec := serve(s.handlePublicStatus, newRequest(http.MethodGet, "/api/v1/public/status", "", nil, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var resp PublicStatusResponse
+	if decodeJSON(t, rec, &resp); resp.Title != DefaultStatusPageTitle || len(resp.Tunnels) != 2 {
+		t.Fatalf("Expected the two allowed tunnels, got %+v", resp)
+	}
+	cacheStatus, dbStatus := resp.Tunnels[0], resp.Tunnels[1]
+	if cacheStatus.Name != "staging-cache" || cacheStatus.Up || cacheStatus.LastError == nil {
+		t.Errorf("Expected the cache down with its error, got %+v", cacheStatus)
+	}
+	if dbStatus.Name != "staging-db" || !dbStatus.Up || dbStatus.ConnectedAt != nil || dbStatus.BytesSent != nil {
+		t.Errorf("Expected the db up without unconfigured fields, got %+v", dbStatus)
+	}
+
+	rec = serve(s.handleStatusPage, newRequest(http.MethodGet, "/status", "", nil, nil))
+	page := rec.Body.String()
+	if !strings.Contains(page, "staging-db") || !strings.Contains(page, "connection refused") || strings.Contains(page, "prod-db") {
+		t.Errorf("Expected the page to show the allowed tunnels only, got:\n%s", page)
+	}
+
+	s.statusPage.Enabled = false
+	if rec := serve(s.handlePublicStatus, newRequest(http.MethodGet, "/api/v1/public/status", "", nil, nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 while disabled, got %d", rec.Code)
+	}
+}
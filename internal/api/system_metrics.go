@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// DefaultSystemMetricsInterval is how often system metrics are collected and broadcast
+const DefaultSystemMetricsInterval = 5 * time.Second
+
+// SystemMetrics is a snapshot of process health and tunnel-wide traffic
+type SystemMetrics struct {
+	Timestamp     time.Time `json:"timestamp"`
+	UptimeSeconds float64   `json:"uptimeSeconds"`
+
+	// Process
+	Goroutines int    `json:"goroutines"`
+	HeapAlloc  uint64 `json:"heapAlloc"` // bytes
+	MemorySys  uint64 `json:"memorySys"` // bytes obtained from the OS
+	NumGC      uint32 `json:"numGc"`
+	OpenFDs    int    `json:"openFds"` // -1 when unavailable on this platform
+	FDLimit    uint64 `json:"fdLimit"` // soft RLIMIT_NOFILE; 0 when unavailable
+	WSClients  int    `json:"wsClients"`
+	NumCPU     int    `json:"numCpu"`
+	GoVersion  string `json:"goVersion"`
+
+	// Tunnels
+	TunnelsTotal      int   `json:"tunnelsTotal"`
+	TunnelsActive     int   `json:"tunnelsActive"`
+	TunnelsConnecting int   `json:"tunnelsConnecting"`
+	TunnelsFailed     int   `json:"tunnelsFailed"`
+	ActiveConnections int64 `json:"activeConnections"`
+
+	// Transfer (cumulative across running forwarders, rates in bytes/second)
+	BytesSent     int64   `json:"bytesSent"`
+	BytesReceived int64   `json:"bytesReceived"`
+	SendRate      float64 `json:"sendRate"`
+	ReceiveRate   float64 `json:"receiveRate"`
+}
+
+// systemMetricsCollector periodically gathers SystemMetrics and broadcasts
+// them to WebSocket clients
+type systemMetricsCollector struct {
+	manager   *tunnel.Manager
+	wsManager *WebSocketManager
+	interval  time.Duration
+	startedAt time.Time
+
+	mu     sync.RWMutex
+	latest *SystemMetrics
+}
+
+// newSystemMetricsCollector creates a collector; a zero interval uses the default
+func newSystemMetricsCollector(manager *tunnel.Manager, wsManager *WebSocketManager, interval time.Duration) *systemMetricsCollector {
+	if interval <= 0 {
+		interval = DefaultSystemMetricsInterval
+	}
+
+	return &systemMetricsCollector{
+		manager:   manager,
+		wsManager: wsManager,
+		interval:  interval,
+		startedAt: time.Now(),
+	}
+}
+
+// Run collects metrics every interval until ctx is cancelled
+func (c *systemMetricsCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			metrics := c.collect(now)
+
+			// Nobody to tell; the snapshot is still kept for the REST endpoint
+			if c.wsManager != nil && c.wsManager.GetClientCount() > 0 {
+				c.wsManager.BroadcastSystemMetrics(metrics)
+			}
+		}
+	}
+}
+
+// Latest returns the most recent snapshot, collecting one if none exists yet
+func (c *systemMetricsCollector) Latest() SystemMetrics {
+	c.mu.RLock()
+	latest := c.latest
+	c.mu.RUnlock()
+
+	if latest == nil {
+		return c.collect(time.Now())
+	}
+	return *latest
+}
+
+// collect gathers a snapshot and stores it as the latest
+func (c *systemMetricsCollector) collect(now time.Time) SystemMetrics {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	metrics := SystemMetrics{
+		Timestamp:     now,
+		UptimeSeconds: now.Sub(c.startedAt).Seconds(),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapAlloc:     mem.HeapAlloc,
+		MemorySys:     mem.Sys,
+		NumGC:         mem.NumGC,
+		OpenFDs:       tunnel.OpenFDs(),
+		NumCPU:        runtime.NumCPU(),
+		GoVersion:     runtime.Version(),
+	}
+
+	metrics.FDLimit, _, _ = tunnel.FDLimit()
+	if c.wsManager != nil {
+		metrics.WSClients = c.wsManager.GetClientCount()
+	}
+
+	for _, t := range c.manager.List() {
+		metrics.TunnelsTotal++
+		if status := t.GetStatus(); status != nil {
+			switch status.State {
+			case types.TunnelStateActive:
+				metrics.TunnelsActive++
+			case types.TunnelStatePending:
+				metrics.TunnelsConnecting++
+			case types.TunnelStateFailed:
+				metrics.TunnelsFailed++
+			}
+		}
+
+		stats := t.Stats()
+		metrics.ActiveConnections += stats.ActiveConns
+		metrics.BytesSent += stats.BytesSent
+		metrics.BytesReceived += stats.BytesReceived
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Rates against the previous snapshot; totals drop when tunnels stop
+	if prev := c.latest; prev != nil {
+		if elapsed := now.Sub(prev.Timestamp).Seconds(); elapsed > 0 {
+			if metrics.BytesSent >= prev.BytesSent {
+				metrics.SendRate = float64(metrics.BytesSent-prev.BytesSent) / elapsed
+			}
+			if metrics.BytesReceived >= prev.BytesReceived {
+				metrics.ReceiveRate = float64(metrics.BytesReceived-prev.BytesReceived) / elapsed
+			}
+		}
+	}
+
+	c.latest = &metrics
+	return metrics
+}
+
+// handleGetSystemMetrics returns the latest system metrics snapshot
+func (s *Server) handleGetSystemMetrics(w http.ResponseWriter, r *http.Request) {
+	s.respondJSON(w, http.StatusOK, s.systemMetrics.Latest())
+}
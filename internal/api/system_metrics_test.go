@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+func TestSystemMetricsCollector(t *testing.T) {
+	manager := tunnel.NewManager(context.Background())
+	collector := newSystemMetricsCollector(manager, NewWebSocketManager(), 0)
+
+	if collector.interval != DefaultSystemMetricsInterval {
+		t.Errorf("Expected default interval %v, got %v", DefaultSystemMetricsInterval, collector.interval)
+	}
+
+	// Latest collects on demand before the first tick
+	metrics := collector.Latest()
+	if metrics.Goroutines <= 0 {
+		t.Errorf("Expected goroutine count, got %d", metrics.Goroutines)
+	}
+	if metrics.HeapAlloc == 0 {
+		t.Error("Expected non-zero heap allocation")
+	}
+	if metrics.TunnelsTotal != 0 {
+		t.Errorf("Expected no tunnels, got %d", metrics.TunnelsTotal)
+	}
+
+	next := collector.collect(metrics.Timestamp.Add(time.Second))
+	if next.SendRate != 0 || next.ReceiveRate != 0 {
+		t.Errorf("Expected zero rates without traffic, got %v/%v", next.SendRate, next.ReceiveRate)
+	}
+	if latest := collector.Latest(); !latest.Timestamp.Equal(next.Timestamp) {
+		t.Error("Expected Latest to return the most recent snapshot")
+	}
+}
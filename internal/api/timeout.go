@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Built-in request timeouts
+const (
+	DefaultRequestTimeout     = 10 * time.Second
+	DefaultLongRequestTimeout = 2 * time.Minute
+)
+
+// RequestTimeouts bound how long API requests may take before they are
+// answered with a 504. Zero values use the defaults; negative ones disable
+// the timeout.
+type RequestTimeouts struct {
+	Default time.Duration // most requests
+	Long    time.Duration // requests that handle many tunnels or much history
+}
+
+// withFallbacks fills unset timeouts with the built-in defaults
+func (t RequestTimeouts) withFallbacks() RequestTimeouts {
+	if t.Default == 0 {
+		t.Default = DefaultRequestTimeout
+	}
+	if t.Long == 0 {
+		t.Long = DefaultLongRequestTimeout
+	}
+	return t
+}
+
+// untimedRoutes end the path templates of the routes no timeout applies to:
+// they are upgraded to another protocol, stream, or bound themselves, e.g.
+// with the timeout of the command they run
+var untimedRoutes = []string{
+	"/ws",
+	"/relay",
+	"/tunnels/{id}/files",
+	"/tunnels/{id}/exec",
+	"/tunnels/{id}/bench",
+	"/keys/{id}/install",
+}
+
+// longRoutes end the path templates of the routes that get the long timeout
+var longRoutes = []string{
+	"/import",
+	"/export",
+	"/tunnels/{id}/metrics/history",
+	"/reports/usage",
+}
+
+// routeTimeout returns how long a request to a matched route may take, or
+// zero if it may take as long as it needs
+func (s *Server) routeTimeout(r *http.Request) time.Duration {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return s.timeouts.Default
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return s.timeouts.Default
+	}
+
+	for _, suffix := range untimedRoutes {
+		if strings.HasSuffix(template, suffix) {
+			return 0
+		}
+	}
+	// Long-polls are capped by maxStatusWait
+	if strings.HasSuffix(template, "/tunnels/{id}/status") && r.URL.Query().Get("wait") != "" {
+		return 0
+	}
+	for _, suffix := range longRoutes {
+		if strings.HasSuffix(template, suffix) {
+			return s.timeouts.Long
+		}
+	}
+	return s.timeouts.Default
+}
+
+// timeoutMiddleware cancels the context of requests that outlast their
+// route's timeout and answers them with a 504. The response is held back
+// until the handler returns, so that a late handler can't write over the
+// 504; handlers should still give up once their context is done.
+func (s *Server) timeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := s.routeTimeout(r)
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		if s.server != nil && timeout+5*time.Second > s.server.WriteTimeout {
+			// Waiting may outlast the server's write timeout
+			http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+		}
+
+		tw := &timeoutWriter{header: w.Header().Clone()}
+		done := make(chan struct{})
+		panicked := make(chan handlerPanic, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- handlerPanic{value: p, stack: debug.Stack()}
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			// Panic where the recovery middleware can see it
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			header := w.Header()
+			clear(header)
+			maps.Copy(header, tw.header)
+			w.WriteHeader(tw.status())
+			_, _ = w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			tw.timedOut = true
+			tw.mu.Unlock()
+			s.requestLogger(r).Warn().Dur("timeout", timeout).Msg("Request timed out")
+			s.TimeoutError(w, fmt.Sprintf("Request did not finish within %s", timeout))
+		}
+	})
+}
+
+// timeoutWriter holds a handler's response until it is known to have
+// finished in time
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	code     int
+	body     bytes.Buffer
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.code == 0 {
+		tw.code = code
+	}
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.body.Write(p)
+}
+
+// status returns the status code the handler sent. Must be called with
+// tw.mu held.
+func (tw *timeoutWriter) status() int {
+	if tw.code == 0 {
+		return http.StatusOK
+	}
+	return tw.code
+}
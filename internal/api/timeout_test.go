@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	s := &Server{
+		logger:   zerolog.Nop(),
+		timeouts: RequestTimeouts{Default: 50 * time.Millisecond, Long: time.Second},
+	}
+
+	// Handlers that wait for their context, or take a while regardless
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			s.InternalError(w, r.Context().Err().Error())
+		case <-time.After(200 * time.Millisecond):
+			s.respondJSON(w, http.StatusOK, map[string]string{"result": "done"})
+		}
+	}
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.Use(s.timeoutMiddleware)
+	api.HandleFunc("/tunnels", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		s.respondJSON(w, http.StatusCreated, map[string]string{"result": "created"})
+	})
+	api.HandleFunc("/tunnels/{id}", slow)
+	api.HandleFunc("/tunnels/{id}/status", slow)
+	api.HandleFunc("/projects/{project}/tunnels/{id}/exec", slow)
+	api.HandleFunc("/export", slow)
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"fast request passes through", "/api/v1/tunnels", http.StatusCreated},
+		{"slow request times out", "/api/v1/tunnels/t1", http.StatusGatewayTimeout},
+		{"long route", "/api/v1/export", http.StatusOK},
+		{"untimed route", "/api/v1/projects/acme/tunnels/t1/exec", http.StatusOK},
+		{"status without wait", "/api/v1/tunnels/t1/status", http.StatusGatewayTimeout},
+		{"status long-poll", "/api/v1/tunnels/t1/status?wait=1s", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+
+			switch tt.status {
+			case http.StatusGatewayTimeout:
+				var apiErr APIError
+				if err := json.Unmarshal(rec.Body.Bytes(), &apiErr); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if apiErr.Code != ErrCodeTimeout {
+					t.Errorf("code = %s, want %s", apiErr.Code, ErrCodeTimeout)
+				}
+			case http.StatusCreated:
+				if got := rec.Header().Get("ETag"); got != `"v1"` {
+					t.Errorf("ETag = %q, want the handler's", got)
+				}
+			}
+		})
+	}
+}
+
+func TestTimeoutMiddlewareDisabled(t *testing.T) {
+	s := &Server{timeouts: RequestTimeouts{Default: -1, Long: -1}.withFallbacks()}
+
+	handler := s.timeoutMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Error("request has a deadline with timeouts disabled")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/tunnels", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
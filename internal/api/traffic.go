@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+// TrafficResponse is a snapshot of the byte counters of every tunnel and
+// its active connections. Clients derive rates from successive snapshots.
+type TrafficResponse struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Tunnels   []TunnelTraffic `json:"tunnels"`
+}
+
+// TunnelTraffic is the traffic of one tunnel
+type TunnelTraffic struct {
+	ID            string               `json:"id"`
+	Name          string               `json:"name"`
+	Status        string               `json:"status"`
+	BytesSent     int64                `json:"bytesSent"`
+	BytesReceived int64                `json:"bytesReceived"`
+	ActiveConns   int64                `json:"activeConns"`
+	Connections   []ConnectionResponse `json:"connections"`
+}
+
+// ConnectionResponse is one active forwarded connection. Its ID stays the
+// same from one snapshot to the next.
+type ConnectionResponse struct {
+	ID            uint64    `json:"id"`
+	Source        string    `json:"source"`
+	Target        string    `json:"target"`
+	StartedAt     time.Time `json:"startedAt"`
+	BytesSent     int64     `json:"bytesSent"`
+	BytesReceived int64     `json:"bytesReceived"`
+}
+
+// handleGetTraffic returns the traffic counters of the tunnels in the
+// request's project, for live views like tunnelctl top
+func (s *Server) handleGetTraffic(w http.ResponseWriter, r *http.Request) {
+	tunnels := s.listTunnels(r)
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].Spec.Name < tunnels[j].Spec.Name })
+
+	resp := TrafficResponse{Timestamp: time.Now().UTC(), Tunnels: make([]TunnelTraffic, 0, len(tunnels))}
+	for _, t := range tunnels {
+		resp.Tunnels = append(resp.Tunnels, newTunnelTraffic(t))
+	}
+	s.respondJSON(w, http.StatusOK, resp)
+}
+
+func newTunnelTraffic(t *tunnel.Tunnel) TunnelTraffic {
+	traffic := TunnelTraffic{
+		ID:          t.Spec.ID,
+		Name:        t.Spec.Name,
+		Connections: []ConnectionResponse{},
+	}
+	if status := t.GetStatus(); status != nil {
+		traffic.Status = statusName(status.State)
+		traffic.BytesSent = status.BytesSent
+		traffic.BytesReceived = status.BytesReceived
+	}
+	traffic.ActiveConns = t.Stats().ActiveConns
+
+	for _, conn := range t.Connections() {
+		traffic.Connections = append(traffic.Connections, ConnectionResponse{
+			ID:            conn.ID,
+			Source:        conn.Source,
+			Target:        conn.Target,
+			StartedAt:     conn.StartedAt,
+			BytesSent:     conn.BytesSent,
+			BytesReceived: conn.BytesReceived,
+		})
+	}
+	return traffic
+}
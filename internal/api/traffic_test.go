@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestGetTraffic(t *testing.T) {
+	s := newTestServer(t,
+		&types.TunnelSpec{ID: "web-id", Name: "web", Owner: anonymousOwner, Type: types.TunnelTypeLocal},
+		&types.TunnelSpec{ID: "db-id", Name: "db", Owner: anonymousOwner, Type: types.TunnelTypeLocal},
+	)
+
+	rec := serve(s.handleGetTraffic, newRequest(http.MethodGet, "/api/v1/traffic", "", nil, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var traffic TrafficResponse
+	decodeJSON(t, rec, &traffic)
+	if len(traffic.Tunnels) != 2 || traffic.Tunnels[0].Name != "db" || traffic.Tunnels[1].Name != "web" {
+		t.Fatalf("Expected db and web sorted by name, got %+v", traffic.Tunnels)
+	}
+	if traffic.Tunnels[0].Connections == nil || traffic.Timestamp.IsZero() {
+		t.Errorf("Expected a timestamp and an empty connection list, got %s", rec.Body.String())
+	}
+}
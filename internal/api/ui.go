@@ -0,0 +1,105 @@
+package api
+
+import (
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// uiHandler serves the single-page web UI. Vite fingerprints everything under
+// assets/, so those files can be cached for good, while index.html and the
+// other top-level files are revalidated on every load so a new release shows
+// up right away; a configured cache rule overrides both. Paths that aren't
+// files get index.html, letting client-side routes survive a reload.
+func (s *Server) uiHandler(ui fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Unknown API paths must not turn into the UI's index page
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			s.NotFound(w, "Endpoint")
+			return
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		info, err := fs.Stat(ui, name)
+
+		switch {
+		case name == "" || name == "index.html":
+			setCacheControl(w, "no-cache")
+			serveIndex(w, r, ui)
+		case err == nil && !info.IsDir():
+			if strings.HasPrefix(name, "assets/") {
+				setCacheControl(w, "public, max-age=31536000, immutable")
+			} else {
+				setCacheControl(w, "no-cache")
+			}
+			if !servePrecompressed(w, r, ui, name) {
+				http.ServeFileFS(w, r, ui, name)
+			}
+		case path.Ext(name) == "":
+			setCacheControl(w, "no-cache")
+			serveIndex(w, r, ui)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// serveIndex writes index.html; http.ServeFileFS would redirect requests for
+// it to the directory
+func serveIndex(w http.ResponseWriter, r *http.Request, ui fs.FS) {
+	index, err := fs.ReadFile(ui, "index.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(index)
+	}
+}
+
+// setCacheControl sets Cache-Control unless a cache rule already did
+func setCacheControl(w http.ResponseWriter, value string) {
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", value)
+	}
+}
+
+// precompressedSuffixes are the file suffixes of assets compressed ahead of
+// time, by content coding
+var precompressedSuffixes = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// servePrecompressed serves name from a .br or .gz file next to it, when the
+// build produced one and the client accepts it, the coding it prefers
+// first. It reports whether it did.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, ui fs.FS, name string) bool {
+	for _, coding := range acceptedEncodings(r, contentCodings...) {
+		file, err := ui.Open(name + precompressedSuffixes[coding])
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		content, ok := file.(io.ReadSeeker)
+		if err != nil || !ok {
+			continue
+		}
+
+		addVary(w.Header(), "Accept-Encoding")
+		w.Header().Set("Content-Encoding", coding)
+		if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		http.ServeContent(w, r, name, info.ModTime(), content)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestUIHandler(t *testing.T) {
+	ui := fstest.MapFS{
+		"index.html":           {Data: []byte("<html>app</html>")},
+		"favicon.svg":          {Data: []byte("<svg/>")},
+		"assets/index-3f2a.js": {Data: []byte("console.log(1)")},
+	}
+	handler := (&Server{}).uiHandler(ui)
+
+	tests := []struct {
+		path      string
+		wantCode  int
+		wantBody  string
+		wantCache string
+	}{
+		{"/", http.StatusOK, "<html>app</html>", "no-cache"},
+		{"/index.html", http.StatusOK, "<html>app</html>", "no-cache"},
+		{"/tunnels/abc123", http.StatusOK, "<html>app</html>", "no-cache"},
+		{"/assets", http.StatusOK, "<html>app</html>", "no-cache"},
+		{"/assets/index-3f2a.js", http.StatusOK, "console.log(1)", "public, max-age=31536000, immutable"},
+		{"/favicon.svg", http.StatusOK, "<svg/>", "no-cache"},
+		{"/assets/missing.js", http.StatusNotFound, "", ""},
+		{"/api/v2/tunnels", http.StatusNotFound, "NOT_FOUND", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("Expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("Expected body to contain %q, got %q", tt.wantBody, rec.Body.String())
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.wantCache {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.wantCache)
+			}
+		})
+	}
+}
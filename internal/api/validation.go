@@ -1,12 +1,19 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/go-playground/validator/v10"
+
+	"github.com/craigderington/lazytunnel/internal/exposure"
+	"github.com/craigderington/lazytunnel/pkg/types"
 )
 
 // Validator instance for request validation
@@ -18,12 +25,22 @@ func init() {
 	// Register custom validation functions
 	validate.RegisterValidation("tunneltype", validateTunnelType)
 	validate.RegisterValidation("authmethod", validateAuthMethod)
+	validate.RegisterValidation("subdomain", validateSubdomain)
+	validate.RegisterValidation("ws_url", validateWebSocketURL)
+	validate.RegisterValidation("bindaddr", validateBindAddress)
+	validate.RegisterValidation("addressname", validateAddressName)
+	validate.RegisterValidation("addressref", validateAddressRef)
+	validate.RegisterValidation("addressref_port", validateAddressRefPort)
+
+	// Register cross-field validation
+	validate.RegisterStructValidation(validateTunnelRequestByType, CreateTunnelRequest{})
+	validate.RegisterStructValidation(validateHopRequest, HopReq{})
 }
 
 // validateTunnelType validates tunnel type values
 func validateTunnelType(fl validator.FieldLevel) bool {
 	value := fl.Field().String()
-	validTypes := []string{"local", "remote", "dynamic"}
+	validTypes := []string{"local", "remote", "dynamic", "transparent"}
 	for _, t := range validTypes {
 		if value == t {
 			return true
@@ -44,36 +61,242 @@ func validateAuthMethod(fl validator.FieldLevel) bool {
 	return false
 }
 
-// CreateTunnelRequest represents the validated request for creating a tunnel
-type CreateTunnelRequest struct {
-	Name             string   `json:"name" validate:"required,min=1,max=100"`
-	Type             string   `json:"type" validate:"required,tunneltype"`
-	Hops             []HopReq `json:"hops" validate:"required,min=1,dive"`
-	LocalPort        int      `json:"localPort" validate:"min=0,max=65535"`
-	LocalBindAddress string   `json:"localBindAddress" validate:"omitempty,ip_addr|hostname"`
-	RemoteHost       string   `json:"remoteHost" validate:"required,hostname|ip_addr"`
-	RemotePort       int      `json:"remotePort" validate:"required,min=1,max=65535"`
-	AutoReconnect    bool     `json:"autoReconnect"`
-	KeepAlive        int      `json:"keepAlive" validate:"min=0,max=300"`
-	MaxRetries       int      `json:"maxRetries" validate:"min=0,max=100"`
-	AgentID          string   `json:"agentId" validate:"omitempty,max=100"`
+// validateSubdomain validates public subdomain labels
+func validateSubdomain(fl validator.FieldLevel) bool {
+	return exposure.ValidSubdomain(fl.Field().String())
+}
+
+// validateWebSocketURL validates ws:// and wss:// URLs with a host
+func validateWebSocketURL(fl validator.FieldLevel) bool {
+	u, err := url.Parse(fl.Field().String())
+	return err == nil && (u.Scheme == "ws" || u.Scheme == "wss") && u.Host != ""
+}
+
+// validateBindAddress validates local bind addresses: IP addresses, IPv6
+// ones in brackets, or localhost
+func validateBindAddress(fl validator.FieldLevel) bool {
+	_, err := types.ParseBindAddress(fl.Field().String())
+	return err == nil
+}
+
+// validateAddressName validates address book entry names
+func validateAddressName(fl validator.FieldLevel) bool {
+	return types.ValidAddressName(fl.Field().String())
+}
+
+// validateAddressRef validates @name references to address book entries
+func validateAddressRef(fl validator.FieldLevel) bool {
+	_, ok := types.AddressRef(fl.Field().String())
+	return ok
+}
+
+// validateAddressRefPort validates @name:port destinations on address book
+// entries
+func validateAddressRefPort(fl validator.FieldLevel) bool {
+	_, port, ok := types.AddressRefPort(fl.Field().String())
+	if !ok {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 1 && n <= 65535
 }
 
-// HopReq represents a single hop in a validated tunnel request
-type HopReq struct {
-	Host       string `json:"host" validate:"required,hostname|ip_addr"`
-	Port       int    `json:"port" validate:"min=1,max=65535"`
-	User       string `json:"user" validate:"required,min=1,max=100"`
-	AuthMethod string `json:"auth_method" validate:"required,authmethod"`
-	KeyID      string `json:"key_id,omitempty"`
+// validateHopRequest requires the port, user and auth method of hops that
+// don't take them from an address book entry
+func validateHopRequest(sl validator.StructLevel) {
+	hop := sl.Current().Interface().(HopReq)
+	if strings.HasPrefix(hop.Host, types.AddressRefPrefix) {
+		return // an invalid reference is reported on its own
+	}
+	if hop.Port == 0 {
+		sl.ReportError(hop.Port, "Port", "Port", "required", "")
+	}
+	if hop.User == "" {
+		sl.ReportError(hop.User, "User", "User", "required", "")
+	}
+	if hop.AuthMethod == "" {
+		sl.ReportError(hop.AuthMethod, "AuthMethod", "AuthMethod", "required", "")
+	}
 }
 
-// ValidationError represents a validation error response
+// validateTunnelRequestByType enforces the fields each tunnel type needs or
+// can't use. Unknown types are left to the tunneltype tag.
+func validateTunnelRequestByType(sl validator.StructLevel) {
+	req := sl.Current().Interface().(CreateTunnelRequest)
+
+	required := func(field string, value interface{}, missing bool) {
+		if missing {
+			sl.ReportError(value, field, field, "required_for_type", req.Type)
+		}
+	}
+	excluded := func(field string, value interface{}, present bool) {
+		if present {
+			sl.ReportError(value, field, field, "excluded_for_type", req.Type)
+		}
+	}
+
+	// Later hops are reached through the ones before them
+	for i := 1; i < len(req.Hops); i++ {
+		if req.Hops[i].Via != "" {
+			sl.ReportError(req.Hops[i].Via, "Via", "Via", "first_hop_only", "")
+		}
+		if req.Hops[i].Attach != "" {
+			sl.ReportError(req.Hops[i].Attach, "Attach", "Attach", "first_hop_only", "")
+		}
+		if req.Hops[i].Transport != "" && req.Hops[i].Transport != "tcp" {
+			sl.ReportError(req.Hops[i].Transport, "Transport", "Transport", "first_hop_only", "")
+		}
+	}
+	if len(req.Hops) > 0 {
+		hop := req.Hops[0]
+		relayed := hop.Transport == "wss" || hop.Transport == "auto"
+		if relayed && hop.RelayURL == "" {
+			sl.ReportError(hop.RelayURL, "RelayURL", "RelayURL", "required_for_transport", hop.Transport)
+		}
+		if relayed && (hop.Via != "" || hop.Attach != "") {
+			sl.ReportError(hop.Transport, "Transport", "Transport", "excluded_with", "Via/Attach")
+		}
+	}
+
+	// The bind address has to be of the address family asked for
+	if bind, err := types.ParseBindAddress(req.LocalBindAddress); err == nil && !types.AddressFamily(req.LocalFamily).Allows(bind) {
+		sl.ReportError(req.LocalBindAddress, "LocalBindAddress", "LocalBindAddress", "bindaddr", req.LocalFamily)
+	}
+
+	switch req.Type {
+	case "local":
+		// Forwards LocalPort (0 picks a free port) to RemoteHost:RemotePort,
+		// spreads connections over a pool of Targets, or forwards several Ports
+		if len(req.Ports) > 0 {
+			excluded("LocalPort", req.LocalPort, req.LocalPort != 0)
+			excluded("RemoteHost", req.RemoteHost, req.RemoteHost != "")
+			excluded("RemotePort", req.RemotePort, req.RemotePort != 0)
+			excluded("Targets", req.Targets, len(req.Targets) > 0)
+			excluded("Protocol", req.Protocol, req.Protocol == "udp")
+
+			seen := make(map[int]bool)
+			for _, mapping := range req.Ports {
+				if mapping.LocalPort != 0 && seen[mapping.LocalPort] {
+					sl.ReportError(req.Ports, "Ports", "Ports", "duplicate_port", strconv.Itoa(mapping.LocalPort))
+				}
+				seen[mapping.LocalPort] = true
+			}
+		} else if len(req.Targets) > 0 {
+			excluded("RemoteHost", req.RemoteHost, req.RemoteHost != "")
+			excluded("RemotePort", req.RemotePort, req.RemotePort != 0)
+			excluded("Protocol", req.Protocol, req.Protocol == "udp")
+		} else {
+			required("RemoteHost", req.RemoteHost, req.RemoteHost == "")
+			required("RemotePort", req.RemotePort, req.RemotePort == 0)
+		}
+		excluded("Routes", req.Routes, len(req.Routes) > 0)
+	case "remote":
+		// Binds RemotePort on the last hop and forwards it back to LocalPort
+		required("LocalPort", req.LocalPort, req.LocalPort == 0)
+		required("RemotePort", req.RemotePort, req.RemotePort == 0)
+		excluded("Routes", req.Routes, len(req.Routes) > 0)
+	case "dynamic":
+		// The SOCKS client picks each destination
+		excluded("RemoteHost", req.RemoteHost, req.RemoteHost != "")
+		excluded("RemotePort", req.RemotePort, req.RemotePort != 0)
+		excluded("Routes", req.Routes, len(req.Routes) > 0)
+	case "transparent":
+		// Destinations come from the redirected connections
+		required("Routes", req.Routes, len(req.Routes) == 0)
+		excluded("RemoteHost", req.RemoteHost, req.RemoteHost != "")
+		excluded("RemotePort", req.RemotePort, req.RemotePort != 0)
+	default:
+		return
+	}
+
+	// UDP is relayed over an SSH channel, which only local tunnels support
+	if req.Type != "local" {
+		excluded("Protocol", req.Protocol, req.Protocol == "udp")
+		excluded("Targets", req.Targets, len(req.Targets) > 0)
+		excluded("Ports", req.Ports, len(req.Ports) > 0)
+	}
+
+	// Public exposure serves a port bound on the last hop
+	if req.Type != "remote" {
+		excluded("Expose", req.Expose, req.Expose)
+	}
+
+	// Only a SOCKS proxy can be the system's proxy or a PAC file's
+	if req.Type != "dynamic" {
+		excluded("SystemProxy", req.SystemProxy, req.SystemProxy)
+		excluded("PAC", req.PAC, req.PAC != nil)
+	}
+}
+
+// The create request model lives in pkg/types, shared with clients
+type (
+	CreateTunnelRequest = types.CreateTunnelRequest
+	StalenessReq        = types.StalenessReq
+	PACReq              = types.PACReq
+	PortMappingReq      = types.PortMappingReq
+	BalanceReq          = types.BalanceReq
+	TCPOptionsReq       = types.TCPOptionsReq
+	RestartReq          = types.RestartReq
+	BackoffReq          = types.BackoffReq
+	HooksReq            = types.HooksReq
+	HookReq             = types.HookReq
+	HopReq              = types.HopReq
+)
+
+// Validation error codes identify each kind of validation failure. They
+// are stable across releases, unlike the English messages, so clients can
+// match on them and localize the message from the code and its params.
+const (
+	ValCodeRequired             = "VAL_REQUIRED"
+	ValCodeRequiredForType      = "VAL_REQUIRED_FOR_TYPE"
+	ValCodeUnsupportedForType   = "VAL_UNSUPPORTED_FOR_TYPE"
+	ValCodeRequiredForTransport = "VAL_REQUIRED_FOR_TRANSPORT"
+	ValCodeRequiredWithout      = "VAL_REQUIRED_WITHOUT"
+	ValCodeExcludedWith         = "VAL_EXCLUDED_WITH"
+	ValCodeEmpty                = "VAL_EMPTY"
+	ValCodeMin                  = "VAL_MIN"
+	ValCodeMax                  = "VAL_MAX"
+	ValCodeMinField             = "VAL_MIN_FIELD"
+	ValCodePortRange            = "VAL_PORT_RANGE"
+	ValCodeDuplicatePort        = "VAL_DUPLICATE_PORT"
+	ValCodeHostname             = "VAL_HOSTNAME"
+	ValCodeIP                   = "VAL_IP"
+	ValCodeBindAddress          = "VAL_BIND_ADDRESS"
+	ValCodeAddressName          = "VAL_ADDRESS_NAME"
+	ValCodeHostPort             = "VAL_HOST_PORT"
+	ValCodeCIDR                 = "VAL_CIDR"
+	ValCodeWSURL                = "VAL_WS_URL"
+	ValCodeHTTPURL              = "VAL_HTTP_URL"
+	ValCodeSubdomain            = "VAL_SUBDOMAIN"
+	ValCodeTunnelType           = "VAL_TUNNEL_TYPE"
+	ValCodeAuthMethod           = "VAL_AUTH_METHOD"
+	ValCodeOneOf                = "VAL_ONE_OF"
+	ValCodeFirstHopOnly         = "VAL_FIRST_HOP_ONLY"
+	ValCodeDuration             = "VAL_DURATION"
+	ValCodeProjectName          = "VAL_PROJECT_NAME"
+	ValCodeReference            = "VAL_REFERENCE"
+	ValCodeInvalid              = "VAL_INVALID"
+)
+
+// ValidationError represents a validation error response. Params hold the
+// values the message refers to besides the field, e.g. "param" for the
+// limit or tunnel type a rule depends on and "value" for the offending
+// value where the message shows it.
 type ValidationError struct {
-	Field   string `json:"field"`
-	Message string `json:"message"`
+	Field   string            `json:"field"`
+	Code    string            `json:"code,omitempty"`
+	Params  map[string]string `json:"params,omitempty"`
+	Message string            `json:"message"`
 }
 
+// ValidationTranslator renders the message of a validation failure from its
+// code, field and params, e.g. in another language. It returns false to
+// keep the English message, such as for codes it doesn't know.
+type ValidationTranslator func(code, field string, params map[string]string) (string, bool)
+
+// portFields are the fields holding a single port number
+var portFields = map[string]bool{"LocalPort": true, "RemotePort": true, "Port": true}
+
 // ValidateRequest validates a struct and returns validation errors
 func ValidateRequest(req interface{}) []ValidationError {
 	if err := validate.Struct(req); err != nil {
@@ -82,6 +305,8 @@ func ValidateRequest(req interface{}) []ValidationError {
 			for _, e := range validationErrors {
 				errors = append(errors, ValidationError{
 					Field:   e.Field(),
+					Code:    validationCode(e),
+					Params:  validationParams(e),
 					Message: formatValidationError(e),
 				})
 			}
@@ -91,6 +316,94 @@ func ValidateRequest(req interface{}) []ValidationError {
 	return nil
 }
 
+// validationCode returns the stable code of a validation error
+func validationCode(e validator.FieldError) string {
+	switch e.Tag() {
+	case "required":
+		return ValCodeRequired
+	case "required_for_type":
+		return ValCodeRequiredForType
+	case "excluded_for_type":
+		return ValCodeUnsupportedForType
+	case "required_for_transport":
+		return ValCodeRequiredForTransport
+	case "required_without":
+		return ValCodeRequiredWithout
+	case "excluded_with":
+		return ValCodeExcludedWith
+	case "min", "max":
+		if portFields[e.Field()] {
+			return ValCodePortRange
+		}
+		if e.Tag() == "max" {
+			return ValCodeMax
+		}
+		if e.Param() == "1" {
+			return ValCodeEmpty
+		}
+		return ValCodeMin
+	case "gtefield":
+		return ValCodeMinField
+	case "duplicate_port":
+		return ValCodeDuplicatePort
+	case "hostname", "hostname|ip_addr", "ip_addr|hostname", "hostname|ip_addr|addressref", "hostname_rfc1123":
+		return ValCodeHostname
+	case "ip_addr":
+		return ValCodeIP
+	case "bindaddr":
+		return ValCodeBindAddress
+	case "addressname":
+		return ValCodeAddressName
+	case "hostname_port", "hostname_port|addressref_port":
+		return ValCodeHostPort
+	case "cidrv4":
+		return ValCodeCIDR
+	case "ws_url":
+		return ValCodeWSURL
+	case "http_url":
+		return ValCodeHTTPURL
+	case "subdomain":
+		return ValCodeSubdomain
+	case "tunneltype":
+		return ValCodeTunnelType
+	case "authmethod":
+		return ValCodeAuthMethod
+	case "oneof":
+		return ValCodeOneOf
+	case "first_hop_only":
+		return ValCodeFirstHopOnly
+	default:
+		return ValCodeInvalid
+	}
+}
+
+// validationParams returns the values the message of a validation error
+// refers to besides its field, or nil if there are none
+func validationParams(e validator.FieldError) map[string]string {
+	params := map[string]string{}
+	switch e.Tag() {
+	case "oneof":
+		params["param"] = strings.ReplaceAll(e.Param(), " ", ", ")
+	case "tunneltype":
+		params["param"] = "local, remote, dynamic, transparent"
+	case "authmethod":
+		params["param"] = "key, password, agent, cert"
+	case "excluded_for_type":
+		params["param"] = e.Param()
+		if e.Field() == "Protocol" {
+			params["value"] = fmt.Sprint(e.Value())
+		}
+	default:
+		if e.Param() != "" {
+			params["param"] = e.Param()
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
 // formatValidationError creates a human-readable error message from a validation error
 func formatValidationError(e validator.FieldError) string {
 	field := e.Field()
@@ -100,6 +413,13 @@ func formatValidationError(e validator.FieldError) string {
 	switch tag {
 	case "required":
 		return fmt.Sprintf("%s is required", field)
+	case "required_for_type":
+		return fmt.Sprintf("%s is required for %s tunnels", field, param)
+	case "excluded_for_type":
+		if field == "Protocol" {
+			return fmt.Sprintf("Protocol %v is not supported for %s tunnels", e.Value(), param)
+		}
+		return fmt.Sprintf("%s is not supported for %s tunnels", field, param)
 	case "min":
 		if param == "1" {
 			return fmt.Sprintf("%s cannot be empty", field)
@@ -107,16 +427,53 @@ func formatValidationError(e validator.FieldError) string {
 		return fmt.Sprintf("%s must be at least %s", field, param)
 	case "max":
 		return fmt.Sprintf("%s must be at most %s", field, param)
+	case "gtefield":
+		return fmt.Sprintf("%s must be at least %s", field, param)
 	case "hostname":
 		return fmt.Sprintf("%s must be a valid hostname or IP address", field)
+	case "hostname_rfc1123":
+		return fmt.Sprintf("%s must be a valid domain name, e.g. corp.example.com", field)
 	case "ip_addr":
 		return fmt.Sprintf("%s must be a valid IP address", field)
-	case "hostname|ip_addr":
+	case "bindaddr":
+		if param != "" {
+			return fmt.Sprintf("%s must be an %s address or localhost", field, param)
+		}
+		return fmt.Sprintf("%s must be an IP address, [IPv6 address] or localhost", field)
+	case "hostname|ip_addr", "ip_addr|hostname":
 		return fmt.Sprintf("%s must be a valid hostname or IP address", field)
+	case "hostname|ip_addr|addressref":
+		return fmt.Sprintf("%s must be a valid hostname, IP address or @name address book reference", field)
+	case "hostname_port|addressref_port":
+		return fmt.Sprintf("%s must be a host:port pair or @name:port", field)
+	case "addressname":
+		return fmt.Sprintf("%s must start with a letter or digit and have only letters, digits, dots, hyphens and underscores (at most 63)", field)
 	case "tunneltype":
-		return fmt.Sprintf("%s must be one of: local, remote, dynamic", field)
+		return fmt.Sprintf("%s must be one of: local, remote, dynamic, transparent", field)
+	case "required_for_transport":
+		return fmt.Sprintf("%s is required for the %s transport", field, param)
+	case "ws_url":
+		return fmt.Sprintf("%s must be a ws or wss URL", field)
+	case "first_hop_only":
+		return fmt.Sprintf("%s is only allowed on the first hop", field)
+	case "duplicate_port":
+		return fmt.Sprintf("%s maps local port %s more than once", field, param)
+	case "hostname_port":
+		return fmt.Sprintf("%s must be a host:port pair", field)
+	case "cidrv4":
+		return fmt.Sprintf("%s must be a valid IPv4 CIDR (e.g. 10.0.0.0/8)", field)
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", field, strings.ReplaceAll(param, " ", ", "))
 	case "authmethod":
 		return fmt.Sprintf("%s must be one of: key, password, agent, cert", field)
+	case "required_without":
+		return fmt.Sprintf("%s or %s is required", field, param)
+	case "excluded_with":
+		return fmt.Sprintf("%s and %s cannot both be set", field, param)
+	case "http_url":
+		return fmt.Sprintf("%s must be an http or https URL", field)
+	case "subdomain":
+		return fmt.Sprintf("%s must be a lowercase DNS label (letters, digits, hyphens)", field)
 	default:
 		return fmt.Sprintf("%s failed validation: %s", field, tag)
 	}
@@ -127,15 +484,42 @@ func (s *Server) respondValidationErrors(w http.ResponseWriter, errors []Validat
 	s.ValidationError(w, "Validation failed", errors)
 }
 
-// decodeAndValidate decodes a JSON request body and validates it
+// decodeAndValidate decodes a JSON request body, or a YAML one when the
+// Content-Type says so, and validates it
 func (s *Server) decodeAndValidate(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	return s.decodeRequest(w, r, req) && s.validateRequest(w, req)
+}
+
+// decodeRequest decodes a JSON request body, or a YAML one when the
+// Content-Type says so, responding with 400 if it can't
+func (s *Server) decodeRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	body := io.Reader(r.Body)
+	if isYAMLRequest(r) {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxBundleSize+1))
+		if err == nil && len(data) > maxBundleSize {
+			err = fmt.Errorf("larger than %d bytes", maxBundleSize)
+		}
+		if err == nil {
+			data, err = yamlToJSON(data)
+		}
+		if err != nil {
+			s.BadRequest(w, "Invalid request body: "+err.Error())
+			return false
+		}
+		body = bytes.NewReader(data)
+	}
+
 	// Decode request
-	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+	if err := json.NewDecoder(body).Decode(req); err != nil {
 		s.BadRequest(w, "Invalid request body: "+err.Error())
 		return false
 	}
+	return true
+}
 
-	// Validate request
+// validateRequest validates a decoded request, responding with the
+// failures if it is invalid
+func (s *Server) validateRequest(w http.ResponseWriter, req interface{}) bool {
 	if errors := ValidateRequest(req); len(errors) > 0 {
 		s.respondValidationErrors(w, errors)
 		return false
@@ -10,6 +10,8 @@ import (
 	"testing"
 
 	ut "github.com/go-playground/universal-translator"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
 )
 
 // TestCreateTunnelRequestValidation tests the CreateTunnelRequest struct validation
@@ -38,7 +40,7 @@ func TestCreateTunnelRequestValidation(t *testing.T) {
 				Name:       "remote-tunnel",
 				Type:       "remote",
 				Hops:       []HopReq{{Host: "1.2.3.4", Port: 22, User: "root", AuthMethod: "password"}},
-				LocalPort:  0,
+				LocalPort:  8443,
 				RemoteHost: "192.168.1.1",
 				RemotePort: 443,
 			},
@@ -46,6 +48,37 @@ func TestCreateTunnelRequestValidation(t *testing.T) {
 		},
 		{
 			name: "Valid dynamic tunnel (SOCKS5)",
+			req: CreateTunnelRequest{
+				Name:      "socks-proxy",
+				Type:      "dynamic",
+				Hops:      []HopReq{{Host: "proxy.example.com", Port: 2222, User: "proxy", AuthMethod: "agent"}},
+				LocalPort: 1080,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Valid transparent tunnel",
+			req: CreateTunnelRequest{
+				Name:   "vpn-ish",
+				Type:   "transparent",
+				Hops:   []HopReq{{Host: "gw.example.com", Port: 22, User: "ops", AuthMethod: "key"}},
+				Routes: []string{"10.0.0.0/8"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Remote tunnel without local port",
+			req: CreateTunnelRequest{
+				Name:       "remote-tunnel",
+				Type:       "remote",
+				Hops:       []HopReq{{Host: "1.2.3.4", Port: 22, User: "root", AuthMethod: "password"}},
+				RemotePort: 443,
+			},
+			wantErr: true,
+			fields:  []string{"LocalPort"},
+		},
+		{
+			name: "Dynamic tunnel with a fixed destination",
 			req: CreateTunnelRequest{
 				Name:       "socks-proxy",
 				Type:       "dynamic",
@@ -54,7 +87,57 @@ func TestCreateTunnelRequestValidation(t *testing.T) {
 				RemoteHost: "target.example.com",
 				RemotePort: 80,
 			},
-			wantErr: false,
+			wantErr: true,
+			fields:  []string{"RemoteHost", "RemotePort"},
+		},
+		{
+			name: "Transparent tunnel without routes",
+			req: CreateTunnelRequest{
+				Name: "vpn-ish",
+				Type: "transparent",
+				Hops: []HopReq{{Host: "gw.example.com", Port: 22, User: "ops", AuthMethod: "key"}},
+			},
+			wantErr: true,
+			fields:  []string{"Routes"},
+		},
+		{
+			name: "Routes on a local tunnel",
+			req: CreateTunnelRequest{
+				Name:       "test",
+				Type:       "local",
+				Hops:       []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				RemoteHost: "target.com",
+				RemotePort: 80,
+				Routes:     []string{"10.0.0.0/8"},
+			},
+			wantErr: true,
+			fields:  []string{"Routes"},
+		},
+		{
+			name: "UDP on a remote tunnel",
+			req: CreateTunnelRequest{
+				Name:       "remote-udp",
+				Type:       "remote",
+				Protocol:   "udp",
+				Hops:       []HopReq{{Host: "1.2.3.4", Port: 22, User: "root", AuthMethod: "password"}},
+				LocalPort:  5353,
+				RemotePort: 53,
+			},
+			wantErr: true,
+			fields:  []string{"Protocol"},
+		},
+		{
+			name: "Expose on a local tunnel",
+			req: CreateTunnelRequest{
+				Name:       "test",
+				Type:       "local",
+				Hops:       []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				RemoteHost: "target.com",
+				RemotePort: 80,
+				Expose:     true,
+			},
+			wantErr: true,
+			fields:  []string{"Expose"},
 		},
 		{
 			name: "Valid tunnel with IP addresses",
@@ -69,6 +152,104 @@ func TestCreateTunnelRequestValidation(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Valid IPv6 bind address in brackets",
+			req: CreateTunnelRequest{
+				Name:             "ip6-tunnel",
+				Type:             "local",
+				Hops:             []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				LocalPort:        3306,
+				LocalBindAddress: "[::1]",
+				LocalFamily:      "ipv6",
+				RemoteHost:       "db.internal",
+				RemotePort:       3306,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Bind address that isn't an IP address",
+			req: CreateTunnelRequest{
+				Name:             "test",
+				Type:             "local",
+				Hops:             []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				LocalBindAddress: "db.internal",
+				RemoteHost:       "target.com",
+				RemotePort:       80,
+			},
+			wantErr: true,
+			fields:  []string{"LocalBindAddress"},
+		},
+		{
+			name: "Bind address of another address family",
+			req: CreateTunnelRequest{
+				Name:             "test",
+				Type:             "local",
+				Hops:             []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				LocalBindAddress: "127.0.0.1",
+				LocalFamily:      "ipv6",
+				RemoteHost:       "target.com",
+				RemotePort:       80,
+			},
+			wantErr: true,
+			fields:  []string{"LocalBindAddress"},
+		},
+		{
+			name: "System proxy for a dynamic tunnel",
+			req: CreateTunnelRequest{
+				Name:        "browse",
+				Type:        "dynamic",
+				Hops:        []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				SystemProxy: true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "System proxy for a local tunnel",
+			req: CreateTunnelRequest{
+				Name:        "test",
+				Type:        "local",
+				Hops:        []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				RemoteHost:  "target.com",
+				RemotePort:  80,
+				SystemProxy: true,
+			},
+			wantErr: true,
+			fields:  []string{"SystemProxy"},
+		},
+		{
+			name: "PAC policy for a dynamic tunnel",
+			req: CreateTunnelRequest{
+				Name: "browse",
+				Type: "dynamic",
+				Hops: []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				PAC:  &PACReq{Domains: []string{"corp.example.com", "internal"}, CIDRs: []string{"10.0.0.0/8"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "PAC policy with an invalid domain and network",
+			req: CreateTunnelRequest{
+				Name: "browse",
+				Type: "dynamic",
+				Hops: []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				PAC:  &PACReq{Domains: []string{"*.corp.example.com"}, CIDRs: []string{"fd00::/8"}},
+			},
+			wantErr: true,
+			fields:  []string{"Domains[0]", "CIDRs[0]"},
+		},
+		{
+			name: "PAC policy for a local tunnel",
+			req: CreateTunnelRequest{
+				Name:       "test",
+				Type:       "local",
+				Hops:       []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				RemoteHost: "target.com",
+				RemotePort: 80,
+				PAC:        &PACReq{Domains: []string{"corp.example.com"}},
+			},
+			wantErr: true,
+			fields:  []string{"PAC"},
+		},
 		{
 			name: "Missing required name",
 			req: CreateTunnelRequest{
@@ -129,6 +310,138 @@ func TestCreateTunnelRequestValidation(t *testing.T) {
 			wantErr: true,
 			fields:  []string{"RemotePort"},
 		},
+		{
+			name: "Valid staleness policy",
+			req: CreateTunnelRequest{
+				Name:       "test",
+				Type:       "local",
+				Hops:       []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				RemoteHost: "target.com",
+				RemotePort: 80,
+				Staleness:  &StalenessReq{After: 600, Action: "restart"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid staleness action",
+			req: CreateTunnelRequest{
+				Name:       "test",
+				Type:       "local",
+				Hops:       []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				RemoteHost: "target.com",
+				RemotePort: 80,
+				Staleness:  &StalenessReq{After: 600, Action: "reboot"},
+			},
+			wantErr: true,
+			fields:  []string{"Action"},
+		},
+		{
+			name: "Invalid restart mode",
+			req: CreateTunnelRequest{
+				Name:       "test",
+				Type:       "local",
+				Hops:       []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				RemoteHost: "target.com",
+				RemotePort: 80,
+				Restart:    &RestartReq{Mode: "sometimes", MaxPerHour: -1},
+			},
+			wantErr: true,
+			fields:  []string{"Mode", "MaxPerHour"},
+		},
+		{
+			name: "Invalid backoff",
+			req: CreateTunnelRequest{
+				Name:       "test",
+				Type:       "local",
+				Hops:       []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				RemoteHost: "target.com",
+				RemotePort: 80,
+				Backoff:    &BackoffReq{Initial: 5000, Max: 1000, Multiplier: 0.5, Jitter: 2},
+			},
+			wantErr: true,
+			fields:  []string{"Max", "Multiplier", "Jitter"},
+		},
+		{
+			name: "Valid local tunnel with target pool",
+			req: CreateTunnelRequest{
+				Name:    "test",
+				Type:    "local",
+				Hops:    []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				Targets: []string{"app-1.internal:8080", "10.0.0.12:8080"},
+				Balance: &BalanceReq{Strategy: "least-connections", HealthCheckInterval: 5},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Target pool with remote host",
+			req: CreateTunnelRequest{
+				Name:       "test",
+				Type:       "local",
+				Hops:       []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				RemoteHost: "target.com",
+				Targets:    []string{"app-1.internal:8080", "not-a-target"},
+				Balance:    &BalanceReq{Strategy: "random"},
+			},
+			wantErr: true,
+			fields:  []string{"RemoteHost", "Targets[1]", "Strategy"},
+		},
+		{
+			name: "Target pool on remote tunnel",
+			req: CreateTunnelRequest{
+				Name:       "test",
+				Type:       "remote",
+				Hops:       []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				LocalPort:  3000,
+				RemotePort: 8080,
+				Targets:    []string{"app-1.internal:8080"},
+			},
+			wantErr: true,
+			fields:  []string{"Targets"},
+		},
+		{
+			name: "Valid multi-port local tunnel",
+			req: CreateTunnelRequest{
+				Name: "test",
+				Type: "local",
+				Hops: []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				Ports: []PortMappingReq{
+					{Name: "db", LocalPort: 5432, RemoteHost: "db.internal", RemotePort: 5432},
+					{Name: "cache", LocalPort: 6379, RemoteHost: "cache.internal", RemotePort: 6379},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Multi-port tunnel with duplicate port and single mapping fields",
+			req: CreateTunnelRequest{
+				Name:       "test",
+				Type:       "local",
+				Hops:       []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				RemoteHost: "target.com",
+				Ports: []PortMappingReq{
+					{LocalPort: 5432, RemoteHost: "db.internal", RemotePort: 5432},
+					{LocalPort: 5432, RemoteHost: "replica.internal"},
+				},
+			},
+			wantErr: true,
+			fields:  []string{"RemoteHost", "RemotePort", "Ports"},
+		},
+		{
+			name: "Hooks with neither or both of command and URL",
+			req: CreateTunnelRequest{
+				Name:      "hooked",
+				Type:      "dynamic",
+				Hops:      []HopReq{{Host: "host.com", Port: 22, User: "user", AuthMethod: "key"}},
+				LocalPort: 1080,
+				Hooks: &HooksReq{
+					OnConnect:    []HookReq{{Timeout: 5}},
+					OnDisconnect: []HookReq{{Command: "true", URL: "https://hooks.example.com"}},
+					OnFailure:    []HookReq{{URL: "ftp://hooks.example.com", Timeout: 7200}},
+				},
+			},
+			wantErr: true,
+			fields:  []string{"Command", "URL", "Timeout"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -513,6 +826,89 @@ func TestMultiHopValidation(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "First hop through a tunnel",
+			hops: []HopReq{
+				{Host: "bastion.internal", Port: 22, User: "admin", AuthMethod: "key", Via: "vpn-socks"},
+				{Host: "target.example.com", Port: 22, User: "admin", AuthMethod: "key"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Second hop through a tunnel",
+			hops: []HopReq{
+				{Host: "bastion.example.com", Port: 22, User: "admin", AuthMethod: "key"},
+				{Host: "target.example.com", Port: 22, User: "admin", AuthMethod: "key", Via: "vpn-socks"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "First hop attached to a tunnel",
+			hops: []HopReq{
+				{Host: "bastion.example.com", Port: 22, User: "admin", AuthMethod: "key", Attach: "web"},
+				{Host: "target.example.com", Port: 22, User: "admin", AuthMethod: "key"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Second hop attached to a tunnel",
+			hops: []HopReq{
+				{Host: "bastion.example.com", Port: 22, User: "admin", AuthMethod: "key"},
+				{Host: "target.example.com", Port: 22, User: "admin", AuthMethod: "key", Attach: "web"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Attached and through a tunnel",
+			hops: []HopReq{
+				{Host: "bastion.example.com", Port: 22, User: "admin", AuthMethod: "key", Attach: "web", Via: "vpn-socks"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "First hop over the WebSocket relay",
+			hops: []HopReq{
+				{Host: "bastion.internal", Port: 22, User: "admin", AuthMethod: "key", Transport: "wss", RelayURL: "wss://lazytunnel.example.com/api/v1/relay"},
+				{Host: "target.example.com", Port: 22, User: "admin", AuthMethod: "key"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Relayed hop without a relay URL",
+			hops: []HopReq{
+				{Host: "bastion.internal", Port: 22, User: "admin", AuthMethod: "key", Transport: "auto"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Relay URL that isn't a WebSocket URL",
+			hops: []HopReq{
+				{Host: "bastion.internal", Port: 22, User: "admin", AuthMethod: "key", Transport: "wss", RelayURL: "https://lazytunnel.example.com/api/v1/relay"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Unknown transport",
+			hops: []HopReq{
+				{Host: "bastion.internal", Port: 22, User: "admin", AuthMethod: "key", Transport: "quic"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Relayed and through a tunnel",
+			hops: []HopReq{
+				{Host: "bastion.internal", Port: 22, User: "admin", AuthMethod: "key", Transport: "wss", RelayURL: "wss://lazytunnel.example.com/api/v1/relay", Via: "vpn-socks"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Second hop over the WebSocket relay",
+			hops: []HopReq{
+				{Host: "bastion.example.com", Port: 22, User: "admin", AuthMethod: "key"},
+				{Host: "target.example.com", Port: 22, User: "admin", AuthMethod: "key", Transport: "wss", RelayURL: "wss://lazytunnel.example.com/api/v1/relay"},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -535,6 +931,42 @@ func TestMultiHopValidation(t *testing.T) {
 	}
 }
 
+// TestClientCreateRequest checks that a create request built by a client,
+// like tunnelctl's, reaches the server with every field intact
+func TestClientCreateRequest(t *testing.T) {
+	autoReconnect := true
+	sent := types.CreateTunnelRequest{
+		Name:          "prod-db",
+		Type:          "local",
+		Protocol:      "tcp",
+		LocalPort:     5432,
+		RemoteHost:    "db.internal",
+		RemotePort:    5432,
+		AutoReconnect: &autoReconnect,
+		KeepAlive:     30,
+		MaxRetries:    3,
+		Hops: []types.HopReq{
+			{Host: "bastion.example.com", Port: 22, User: "deploy", AuthMethod: "key", KeyID: "/home/deploy/.ssh/id_rsa", ForwardAgent: true},
+		},
+		Interpolated: map[string]string{"hops[*].user": "${USER}"},
+	}
+	body, err := json.Marshal(sent)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var received CreateTunnelRequest
+	if err := json.Unmarshal(body, &received); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(received, sent) {
+		t.Errorf("Received %+v, want %+v", received, sent)
+	}
+	if errors := ValidateRequest(received); len(errors) > 0 {
+		t.Errorf("Expected the client's request to validate, got %v", errors)
+	}
+}
+
 // TestFormatValidationError tests error message formatting
 func TestFormatValidationError(t *testing.T) {
 	tests := []struct {
@@ -549,7 +981,9 @@ func TestFormatValidationError(t *testing.T) {
 		{"hostname", "", "Field must be a valid hostname or IP address"},
 		{"ip_addr", "", "Field must be a valid IP address"},
 		{"hostname|ip_addr", "", "Field must be a valid hostname or IP address"},
-		{"tunneltype", "", "Field must be one of: local, remote, dynamic"},
+		{"tunneltype", "", "Field must be one of: local, remote, dynamic, transparent"},
+		{"required_for_type", "remote", "Field is required for remote tunnels"},
+		{"excluded_for_type", "dynamic", "Field is not supported for dynamic tunnels"},
 		{"authmethod", "", "Field must be one of: key, password, agent, cert"},
 		{"unknown", "", "Field failed validation: unknown"},
 	}
@@ -590,13 +1024,117 @@ func (m *mockFieldError) Type() reflect.Type                { return nil }
 func (m *mockFieldError) Translate(tr ut.Translator) string { return "" }
 func (m *mockFieldError) Error() string                     { return "" }
 
+// TestValidationErrorCodes locks the codes of validation failures, which
+// clients match on and must not change between releases
+func TestValidationErrorCodes(t *testing.T) {
+	tests := []struct {
+		field string
+		tag   string
+		param string
+		code  string
+	}{
+		{"Name", "required", "", "VAL_REQUIRED"},
+		{"RemoteHost", "required_for_type", "local", "VAL_REQUIRED_FOR_TYPE"},
+		{"Routes", "excluded_for_type", "remote", "VAL_UNSUPPORTED_FOR_TYPE"},
+		{"RelayURL", "required_for_transport", "wss", "VAL_REQUIRED_FOR_TRANSPORT"},
+		{"Key", "required_without", "Password", "VAL_REQUIRED_WITHOUT"},
+		{"Transport", "excluded_with", "Via/Attach", "VAL_EXCLUDED_WITH"},
+		{"Name", "min", "1", "VAL_EMPTY"},
+		{"KeepAlive", "min", "0", "VAL_MIN"},
+		{"KeepAlive", "max", "300", "VAL_MAX"},
+		{"Max", "gtefield", "Initial", "VAL_MIN_FIELD"},
+		{"RemotePort", "min", "1", "VAL_PORT_RANGE"},
+		{"LocalPort", "max", "65535", "VAL_PORT_RANGE"},
+		{"Port", "max", "65535", "VAL_PORT_RANGE"},
+		{"Ports", "duplicate_port", "8080", "VAL_DUPLICATE_PORT"},
+		{"Host", "hostname|ip_addr", "", "VAL_HOSTNAME"},
+		{"LocalBindAddress", "ip_addr|hostname", "", "VAL_HOSTNAME"},
+		{"Address", "ip_addr", "", "VAL_IP"},
+		{"LocalBindAddress", "bindaddr", "ipv6", "VAL_BIND_ADDRESS"},
+		{"Targets[0]", "hostname_port", "", "VAL_HOST_PORT"},
+		{"Routes[0]", "cidrv4", "", "VAL_CIDR"},
+		{"RelayURL", "ws_url", "", "VAL_WS_URL"},
+		{"URL", "http_url", "", "VAL_HTTP_URL"},
+		{"Subdomain", "subdomain", "", "VAL_SUBDOMAIN"},
+		{"Type", "tunneltype", "", "VAL_TUNNEL_TYPE"},
+		{"AuthMethod", "authmethod", "", "VAL_AUTH_METHOD"},
+		{"Protocol", "oneof", "tcp udp", "VAL_ONE_OF"},
+		{"Via", "first_hop_only", "", "VAL_FIRST_HOP_ONLY"},
+		{"Name", "unknown", "", "VAL_INVALID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field+"/"+tt.tag, func(t *testing.T) {
+			code := validationCode(&mockFieldError{field: tt.field, tag: tt.tag, param: tt.param})
+			if code != tt.code {
+				t.Errorf("validationCode() = %s, want %s", code, tt.code)
+			}
+		})
+	}
+}
+
+// TestValidateRequestCodes tests the codes and params of real validation failures
+func TestValidateRequestCodes(t *testing.T) {
+	req := CreateTunnelRequest{
+		Name:       "codes",
+		Type:       "local",
+		Protocol:   "sctp",
+		Hops:       []HopReq{{Host: "bastion.example.com", Port: 22, User: "admin", AuthMethod: "key"}},
+		RemoteHost: "db.internal",
+		RemotePort: 70000,
+	}
+
+	got := map[string]ValidationError{}
+	for _, e := range ValidateRequest(req) {
+		got[e.Field] = e
+	}
+	port := got["RemotePort"]
+	if port.Code != ValCodePortRange || port.Params["param"] != "65535" {
+		t.Errorf("RemotePort error = %+v, want %s with param 65535", port, ValCodePortRange)
+	}
+	protocol := got["Protocol"]
+	if protocol.Code != ValCodeOneOf || protocol.Params["param"] != "tcp, udp" {
+		t.Errorf("Protocol error = %+v, want %s with param \"tcp, udp\"", protocol, ValCodeOneOf)
+	}
+}
+
+// TestValidationTranslator tests that a translator replaces the messages
+// of the codes it knows and keeps the others
+func TestValidationTranslator(t *testing.T) {
+	server := &Server{translator: func(code, field string, params map[string]string) (string, bool) {
+		if code != ValCodePortRange {
+			return "", false
+		}
+		return field + " doit être inférieur ou égal à " + params["param"], true
+	}}
+
+	w := httptest.NewRecorder()
+	server.ValidationError(w, "Validation failed", []ValidationError{
+		{Field: "RemotePort", Code: ValCodePortRange, Params: map[string]string{"param": "65535"}, Message: "RemotePort must be at most 65535"},
+		{Field: "Name", Code: ValCodeRequired, Message: "Name is required"},
+	})
+
+	var response APIError
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	want := []ErrorDetail{
+		{Field: "RemotePort", Issue: "RemotePort doit être inférieur ou égal à 65535", Code: ValCodePortRange, Params: map[string]string{"param": "65535"}},
+		{Field: "Name", Issue: "Name is required", Code: ValCodeRequired},
+	}
+	if !reflect.DeepEqual(response.Details, want) {
+		t.Errorf("Details = %+v, want %+v", response.Details, want)
+	}
+}
+
 // TestDecodeAndValidate tests the decodeAndValidate function
 func TestDecodeAndValidate(t *testing.T) {
 	tests := []struct {
-		name       string
-		body       string
-		wantValid  bool
-		wantStatus int
+		name        string
+		contentType string // application/json if empty
+		body        string
+		wantValid   bool
+		wantStatus  int
 	}{
 		{
 			name:       "Valid JSON",
@@ -622,12 +1160,37 @@ func TestDecodeAndValidate(t *testing.T) {
 			wantValid:  false,
 			wantStatus: http.StatusBadRequest,
 		},
+		{
+			name:        "Valid YAML",
+			contentType: "application/yaml",
+			body:        "name: test\ntype: local\nhops:\n  - {host: host.example.com, port: 22, user: admin, auth_method: key}\nremoteHost: target.example.com\nremotePort: 80\n",
+			wantValid:   true,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "Invalid YAML",
+			contentType: "application/yaml",
+			body:        "name: [test",
+			wantValid:   false,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "Valid YAML but invalid data",
+			contentType: "application/x-yaml",
+			body:        "name: test\ntype: invalid\n",
+			wantValid:   false,
+			wantStatus:  http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest(http.MethodPost, "/tunnels", bytes.NewBufferString(tt.body))
-			req.Header.Set("Content-Type", "application/json")
+			contentType := tt.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			req.Header.Set("Content-Type", contentType)
 			w := httptest.NewRecorder()
 
 			var tunnelReq CreateTunnelRequest
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// resolveVia checks the tunnel a spec's first hop is reached through, and
+// records its ID and a dependency on it, so the hop is only dialed once the
+// tunnel is up. The dependency check then keeps it in the same project and
+// on the same node.
+func (s *Server) resolveVia(spec *types.TunnelSpec) error {
+	if len(spec.Hops) == 0 || spec.Hops[0].Via == "" {
+		return nil
+	}
+	hop := &spec.Hops[0]
+
+	via := s.findTunnel(spec.ProjectName(), hop.Via)
+	if via == nil {
+		return fmt.Errorf("no tunnel %q to reach the first hop through", hop.Via)
+	}
+
+	// Both may reference the address book; compare where they really go
+	hops, err := tunnel.ResolveHops(context.Background(), s.manager.AddressBook(), spec.Hops[:1])
+	if err != nil {
+		return err
+	}
+	viaSpec, err := tunnel.ResolveSpec(context.Background(), s.manager.AddressBook(), via.Spec)
+	if err != nil {
+		return err
+	}
+	if err := tunnel.CanCarry(viaSpec, net.JoinHostPort(hops[0].Host, strconv.Itoa(hops[0].Port))); err != nil {
+		return err
+	}
+
+	hop.Via = via.Spec.ID
+	dependOn(spec, via.Spec.Name)
+	return nil
+}
+
+// findTunnel looks a project's tunnel up by ID, then by name, which is
+// easier to write by hand and survives export and import. It returns nil if
+// there's none.
+func (s *Server) findTunnel(project, ref string) *tunnel.Tunnel {
+	if t, err := s.manager.Get(ref); err == nil && t.Spec.ProjectName() == project {
+		return t
+	}
+	for _, t := range s.manager.List() {
+		if t.Spec.ProjectName() == project && t.Spec.Name == ref {
+			return t
+		}
+	}
+	return nil
+}
+
+// tunnelName returns the name of the tunnel with the ID, or the ID itself if
+// there's no such tunnel
+func (s *Server) tunnelName(id string) string {
+	if id == "" {
+		return ""
+	}
+	if t, err := s.manager.Get(id); err == nil {
+		return t.Spec.Name
+	}
+	return id
+}
+
+// dependOn adds a dependency on the named tunnel, unless the spec has it
+func dependOn(spec *types.TunnelSpec, name string) {
+	if !slices.Contains(spec.DependsOn, name) {
+		spec.DependsOn = append(spec.DependsOn, name)
+	}
+}
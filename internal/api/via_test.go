@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestCreateTunnelVia(t *testing.T) {
+	s := newTestServer(t,
+		&types.TunnelSpec{ID: "socks-1", Name: "vpn-socks", Type: types.TunnelTypeDynamic},
+		&types.TunnelSpec{ID: "db-1", Name: "db", Type: types.TunnelTypeLocal, RemoteHost: "db.internal", RemotePort: 5432},
+		&types.TunnelSpec{ID: "rev-1", Name: "reverse", Type: types.TunnelTypeRemote, RemoteHost: "localhost", RemotePort: 8080},
+	)
+
+	create := func(name, via string) *httptest.ResponseRecorder {
+		return s.postTunnel(`{"name": "`+name+`", "type": "local", "localPort": 8443, "remoteHost": "app.internal", "remotePort": 443,
+			"agentId": "edge-1",
+			"hops": [{"host": "bastion.internal", "port": 22, "user": "ops", "auth_method": "agent", "via": "`+via+`"}]}`, nil)
+	}
+
+	for via, why := range map[string]string{
+		"nope":    "a missing tunnel",
+		"db-1":    "a local tunnel forwarding elsewhere",
+		"reverse": "a remote tunnel",
+	} {
+		if rec := create("app-"+via, via); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected going through %s to be refused, got %d: %s", why, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := create("app", "vpn-socks")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the tunnel to be created, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created *types.TunnelSpec
+	for _, tun := range s.manager.List() {
+		if tun.Spec.Name == "app" {
+			created = tun.Spec
+		}
+	}
+	if created == nil {
+		t.Fatal("Expected the tunnel to be stored")
+	}
+	if created.Hops[0].Via != "socks-1" {
+		t.Errorf("Expected the via tunnel's name to resolve to its ID, got %q", created.Hops[0].Via)
+	}
+	if len(created.DependsOn) != 1 || created.DependsOn[0] != "vpn-socks" {
+		t.Errorf("Expected the tunnel to depend on the via tunnel, got %v", created.DependsOn)
+	}
+}
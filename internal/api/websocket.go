@@ -4,23 +4,28 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
 )
 
 // WebSocketManager manages WebSocket connections and broadcasts updates
 type WebSocketManager struct {
 	clients    map[*WebSocketClient]bool
+	sessions   map[string]*wsSession // of disconnected clients that may resume, by ID
 	broadcast  chan WebSocketMessage
-	register   chan *WebSocketClient
 	unregister chan *WebSocketClient
 	mu         sync.RWMutex
 	upgrader   websocket.Upgrader
 	ctx        context.Context
 	cancel     context.CancelFunc
+	commands   CommandHandler // runs commands clients send; nil rejects them
 }
 
 // WebSocketClient represents a single WebSocket connection
@@ -29,6 +34,14 @@ type WebSocketClient struct {
 	conn    *websocket.Conn
 	send    chan WebSocketMessage
 	userID  string
+	project string
+	ctx     context.Context // the client's identity and project, for its commands
+	batcher *updateBatcher  // coalesces tunnel updates; nil unless batched
+	session *wsSession      // numbers and keeps the events sent, for resuming
+
+	// Liveness, from the client's answers to pings
+	lastPong atomic.Int64 // unix nanoseconds
+	pingRTT  atomic.Int64 // nanoseconds
 }
 
 // WebSocketMessage represents a message sent over WebSocket
@@ -36,6 +49,10 @@ type WebSocketMessage struct {
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
 	Time    time.Time   `json:"time"`
+	Seq     uint64      `json:"seq,omitempty"` // numbers the events of a client, which resumes after the last it got
+
+	project string // only clients of this project receive it, if set
+	owner   string // only this user's and admins' clients receive it, if set
 }
 
 // NewWebSocketManager creates a new WebSocket manager
@@ -44,12 +61,14 @@ func NewWebSocketManager() *WebSocketManager {
 
 	return &WebSocketManager{
 		clients:    make(map[*WebSocketClient]bool),
+		sessions:   make(map[string]*wsSession),
 		broadcast:  make(chan WebSocketMessage, 256),
-		register:   make(chan *WebSocketClient),
 		unregister: make(chan *WebSocketClient),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
+			// permessage-deflate, for clients that offer it
+			EnableCompression: true,
 			CheckOrigin: func(r *http.Request) bool {
 				// Allow all origins in development
 				// In production, this should be restricted
@@ -61,6 +80,13 @@ func NewWebSocketManager() *WebSocketManager {
 	}
 }
 
+// SetCommandHandler sets what runs the commands clients send
+func (wsm *WebSocketManager) SetCommandHandler(handler CommandHandler) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	wsm.commands = handler
+}
+
 // Start begins the WebSocket manager event loop
 func (wsm *WebSocketManager) Start() {
 	go wsm.run()
@@ -79,57 +105,80 @@ func (wsm *WebSocketManager) Stop() {
 
 // run is the main event loop for the WebSocket manager
 func (wsm *WebSocketManager) run() {
+	expiry := time.NewTicker(wsResumeWindow / 4)
+	defer expiry.Stop()
+
 	for {
 		select {
-		case client := <-wsm.register:
-			wsm.mu.Lock()
-			wsm.clients[client] = true
-			wsm.mu.Unlock()
-			log.Info().Str("user_id", client.userID).Msg("WebSocket client connected")
-
 		case client := <-wsm.unregister:
 			wsm.mu.Lock()
 			if _, ok := wsm.clients[client]; ok {
-				delete(wsm.clients, client)
-				close(client.send)
+				wsm.detachLocked(client)
 			}
 			wsm.mu.Unlock()
 			log.Info().Str("user_id", client.userID).Msg("WebSocket client disconnected")
 
 		case message := <-wsm.broadcast:
-			wsm.mu.RLock()
+			wsm.mu.Lock()
+			// Disconnected clients get the event when they resume
+			for _, session := range wsm.sessions {
+				if session.receives(message) {
+					session.record(message)
+				}
+			}
 			clients := make([]*WebSocketClient, 0, len(wsm.clients))
 			for client := range wsm.clients {
 				clients = append(clients, client)
 			}
-			wsm.mu.RUnlock()
+			wsm.mu.Unlock()
 
 			for _, client := range clients {
+				if !client.session.receives(message) {
+					continue
+				}
 				select {
-				case client.send <- message:
+				case client.send <- client.session.record(message):
 				default:
 					// Client's send channel is full, close it
 					wsm.mu.Lock()
-					delete(wsm.clients, client)
-					close(client.send)
+					if _, ok := wsm.clients[client]; ok {
+						wsm.detachLocked(client)
+					}
 					wsm.mu.Unlock()
 				}
 			}
 
+		case now := <-expiry.C:
+			wsm.expireSessions(now)
+
 		case <-wsm.ctx.Done():
 			return
 		}
 	}
 }
 
-// HandleWebSocket upgrades HTTP connection to WebSocket
+// HandleWebSocket upgrades HTTP connection to WebSocket. ?updates=batched
+// asks for tunnel updates as coalesced diffs instead of one full message
+// per change. ?resume= with a client ID from a previous hello, and ?lastSeq=
+// with the seq of the last event it got, resumes that client's events.
 func (wsm *WebSocketManager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Extract user from context if authenticated
 	userID := "anonymous"
-	if user, ok := GetUser(r.Context()); ok {
+	user, ok := GetUser(r.Context())
+	if ok {
 		userID = user.ID
 	}
 
+	updates := r.URL.Query().Get("updates")
+	switch updates {
+	case "":
+		updates = wsUpdatesFull
+	case wsUpdatesFull, wsUpdatesBatched:
+	default:
+		http.Error(w, "Invalid updates: use full or batched", http.StatusBadRequest)
+		return
+	}
+
 	conn, err := wsm.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Error().Err(err).Msg("WebSocket upgrade failed")
@@ -141,24 +190,70 @@ func (wsm *WebSocketManager) HandleWebSocket(w http.ResponseWriter, r *http.Requ
 		conn:    conn,
 		send:    make(chan WebSocketMessage, 256),
 		userID:  userID,
+		project: requestProject(r),
+		ctx:     commandContext(wsm.ctx, r),
+	}
+	if updates == wsUpdatesBatched {
+		client.batcher = newUpdateBatcher()
+	}
+
+	// Sessions change hands and clients register under the lock that
+	// broadcasts hold, so no event is missed or sent twice in between
+	wsm.mu.Lock()
+	var missed []WebSocketMessage
+	resumed := false
+	if id := r.URL.Query().Get("resume"); id != "" {
+		client.session, missed, resumed = wsm.resumeLocked(id, r.URL.Query().Get("lastSeq"), userID, client.project)
+	}
+	if !resumed {
+		client.session = newWSSession(userID, client.project, user)
+	}
+
+	// Announce the protocol before anything else is sent
+	client.send <- WebSocketMessage{
+		Type: wsMessageHello,
+		Payload: HelloPayload{
+			ProtocolVersion: WebSocketProtocolVersion,
+			Commands:        commandNames(),
+			Updates:         updates,
+			ClientID:        client.session.id,
+			Resumed:         resumed,
+			ResumeWindow:    wsResumeWindow.Seconds(),
+			PingInterval:    wsPingInterval.Seconds(),
+		},
+		Time: time.Now(),
+	}
+	for _, message := range missed {
+		client.send <- message
 	}
 
-	wsm.register <- client
+	// Registered before reading, so acks to its first commands reach it
+	wsm.clients[client] = true
+	wsm.mu.Unlock()
+	log.Info().Str("user_id", client.userID).Bool("resumed", resumed).Msg("WebSocket client connected")
 
 	// Start goroutines for reading and writing
 	go client.writePump()
 	go client.readPump()
 }
 
-// BroadcastTunnelUpdate sends tunnel status update to all clients
-func (wsm *WebSocketManager) BroadcastTunnelUpdate(tunnelID string, status interface{}) {
+// TunnelUpdate is the payload of a tunnel_update message: the raw status
+// that changed and the tunnel as the REST API returns it, which is omitted
+// once the tunnel has been deleted
+type TunnelUpdate struct {
+	TunnelID string              `json:"tunnelId"`
+	Status   *types.TunnelStatus `json:"status"`
+	Tunnel   *TunnelResponse     `json:"tunnel,omitempty"`
+}
+
+// BroadcastTunnelUpdate sends tunnel status update to the clients of the
+// tunnel's project
+func (wsm *WebSocketManager) BroadcastTunnelUpdate(update TunnelUpdate, project string) {
 	msg := WebSocketMessage{
-		Type: "tunnel_update",
-		Payload: map[string]interface{}{
-			"tunnelId": tunnelID,
-			"status":   status,
-		},
-		Time: time.Now(),
+		Type:    "tunnel_update",
+		Payload: update,
+		Time:    time.Now(),
+		project: project,
 	}
 
 	select {
@@ -168,6 +263,21 @@ func (wsm *WebSocketManager) BroadcastTunnelUpdate(tunnelID string, status inter
 	}
 }
 
+// broadcastTunnelUpdate is the manager subscription that pushes status
+// changes to WebSocket clients. Updates of deleted tunnels, which arrive as
+// they stop, still go to their project only; those of tunnels purged since
+// are dropped, as their project is no longer known.
+func (s *Server) broadcastTunnelUpdate(tunnelID string, status *types.TunnelStatus) {
+	update := TunnelUpdate{TunnelID: tunnelID, Status: status}
+	if t, err := s.manager.Get(tunnelID); err == nil {
+		resp := s.newTunnelResponse(t)
+		update.Tunnel = &resp
+		s.wsManager.BroadcastTunnelUpdate(update, projectOf(t))
+	} else if t, err := s.manager.GetDeleted(tunnelID); err == nil {
+		s.wsManager.BroadcastTunnelUpdate(update, projectOf(t))
+	}
+}
+
 // BroadcastSystemMetrics sends system metrics to all clients
 func (wsm *WebSocketManager) BroadcastSystemMetrics(metrics interface{}) {
 	msg := WebSocketMessage{
@@ -183,6 +293,24 @@ func (wsm *WebSocketManager) BroadcastSystemMetrics(metrics interface{}) {
 	}
 }
 
+// BroadcastAuthPrompt announces a keyboard-interactive prompt that needs an
+// answer to the clients of the tunnel's owner and admins in its project
+func (wsm *WebSocketManager) BroadcastAuthPrompt(prompt interface{}, project, owner string) {
+	msg := WebSocketMessage{
+		Type:    "auth_prompt",
+		Payload: prompt,
+		Time:    time.Now(),
+		project: project,
+		owner:   owner,
+	}
+
+	select {
+	case wsm.broadcast <- msg:
+	case <-time.After(100 * time.Millisecond):
+		log.Warn().Msg("WebSocket broadcast channel full, dropping message")
+	}
+}
+
 // readPump handles incoming messages from the client
 func (c *WebSocketClient) readPump() {
 	defer func() {
@@ -190,58 +318,119 @@ func (c *WebSocketClient) readPump() {
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(512) // Max message size
-	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	c.conn.SetReadLimit(maxCommandSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(data string) error {
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		// Pings carry the time they were sent
+		now := time.Now()
+		if sent, err := strconv.ParseInt(data, 10, 64); err == nil && sent <= now.UnixNano() {
+			c.pingRTT.Store(now.UnixNano() - sent)
+		}
+		c.lastPong.Store(now.UnixNano())
 		return nil
 	})
 
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Error().Err(err).Str("user_id", c.userID).Msg("WebSocket error")
 			}
 			break
 		}
-		// We don't process incoming messages for now (one-way updates)
-		// But this loop is needed to detect disconnections
+		c.reply(c.runCommand(data))
+	}
+}
+
+// runCommand executes a command a client sent, in order of arrival
+func (c *WebSocketClient) runCommand(data []byte) CommandAck {
+	var cmd WebSocketCommand
+	if err := json.Unmarshal(data, &cmd); err != nil || cmd.Type == "" {
+		return CommandAck{Status: http.StatusBadRequest, Error: NewAPIError(ErrCodeBadRequest, "Invalid command: expected JSON with id, type and version")}
+	}
+
+	c.manager.mu.RLock()
+	handler := c.manager.commands
+	c.manager.mu.RUnlock()
+	if handler == nil {
+		return CommandAck{ID: cmd.ID, Type: cmd.Type, Status: http.StatusBadRequest,
+			Error: NewAPIError(ErrCodeUnsupportedCommand, "This server does not accept commands")}
+	}
+	ack := handler(c.ctx, cmd)
+	if ack.OK && cmd.Type == wsMessageHeartbeat {
+		ack.Result = c.liveness()
+	}
+	return ack
+}
+
+// reply sends an ack to this client alone, unless it has been disconnected
+func (c *WebSocketClient) reply(ack CommandAck) {
+	msg := WebSocketMessage{Type: wsMessageAck, Payload: ack, Time: time.Now()}
+
+	// Send channels are closed under the write lock once clients leave
+	c.manager.mu.RLock()
+	defer c.manager.mu.RUnlock()
+	if !c.manager.clients[c] {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		log.Warn().Str("user_id", c.userID).Msg("WebSocket send buffer full, dropping ack")
 	}
 }
 
 // writePump handles outgoing messages to the client
 func (c *WebSocketClient) writePump() {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(wsPingInterval)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
 	}()
 
+	var flush <-chan time.Time // set while batched tunnel updates are pending
+	var batchSeq uint64        // of the latest batched update
+
 	for {
 		select {
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if !ok {
 				// Channel closed
+				c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			data, err := json.Marshal(message)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to marshal WebSocket message")
+			if update, isUpdate := message.Payload.(TunnelUpdate); isUpdate && c.batcher != nil {
+				if c.batcher.add(update) {
+					flush = time.After(wsBatchInterval)
+				}
+				batchSeq = message.Seq
 				continue
 			}
+			if err := c.write(message); err != nil {
+				return
+			}
 
-			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-				log.Error().Err(err).Str("user_id", c.userID).Msg("WebSocket write error")
+		case <-flush:
+			flush = nil
+			payload, err := c.batcher.flush()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to diff tunnel updates")
+				continue
+			}
+			if payload == nil {
+				continue
+			}
+			if err := c.write(WebSocketMessage{Type: wsMessageTunnelUpdates, Payload: payload, Time: time.Now(), Seq: batchSeq}); err != nil {
 				return
 			}
 
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			ping := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+			if err := c.conn.WriteMessage(websocket.PingMessage, ping); err != nil {
 				return
 			}
 
@@ -251,6 +440,23 @@ func (c *WebSocketClient) writePump() {
 	}
 }
 
+// write sends a message to the client. Only the write pump may call it.
+// Messages that can't be marshaled are logged and skipped.
+func (c *WebSocketClient) write(message WebSocketMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal WebSocket message")
+		return nil
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Error().Err(err).Str("user_id", c.userID).Msg("WebSocket write error")
+		return err
+	}
+	return nil
+}
+
 // GetClientCount returns the number of connected clients
 func (wsm *WebSocketManager) GetClientCount() int {
 	wsm.mu.RLock()
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Tunnel update modes a client picks with ?updates= when connecting
+const (
+	wsUpdatesFull    = "full"    // a tunnel_update message per change (default)
+	wsUpdatesBatched = "batched" // tunnel_updates messages of coalesced diffs
+)
+
+const (
+	wsMessageTunnelUpdates = "tunnel_updates"
+
+	// wsBatchInterval is how long a batched client's tunnel updates are
+	// coalesced before being sent
+	wsBatchInterval = 250 * time.Millisecond
+)
+
+// nullField marks a field that was dropped since the client's last update
+var nullField = json.RawMessage("null")
+
+// TunnelDiff is one tunnel's entry in a tunnel_updates message: the
+// top-level fields of its status and of its REST representation that
+// changed since the client last heard of it, null for those that are gone.
+// Full is set when all fields are there, i.e. the first time the client
+// hears of the tunnel; Deleted once it has been deleted.
+type TunnelDiff struct {
+	TunnelID string                     `json:"tunnelId"`
+	Full     bool                       `json:"full,omitempty"`
+	Deleted  bool                       `json:"deleted,omitempty"`
+	Status   map[string]json.RawMessage `json:"status,omitempty"`
+	Tunnel   map[string]json.RawMessage `json:"tunnel,omitempty"`
+}
+
+// TunnelUpdatesPayload is the payload of a tunnel_updates message
+type TunnelUpdatesPayload struct {
+	Updates []TunnelDiff `json:"updates"`
+}
+
+// tunnelFields is what a batched client last got of a tunnel, by field
+type tunnelFields struct {
+	status map[string]json.RawMessage
+	tunnel map[string]json.RawMessage
+}
+
+// updateBatcher coalesces a batched client's tunnel updates, keeping the
+// latest of each tunnel, and diffs them against what the client already
+// has. Only the client's write pump uses it.
+type updateBatcher struct {
+	pending map[string]TunnelUpdate
+	order   []string // tunnel IDs, in order of their first pending update
+	sent    map[string]tunnelFields
+}
+
+func newUpdateBatcher() *updateBatcher {
+	return &updateBatcher{
+		pending: make(map[string]TunnelUpdate),
+		sent:    make(map[string]tunnelFields),
+	}
+}
+
+// add queues an update, replacing the tunnel's pending one, and reports
+// whether it starts a new batch
+func (b *updateBatcher) add(update TunnelUpdate) bool {
+	first := len(b.pending) == 0
+	if _, ok := b.pending[update.TunnelID]; !ok {
+		b.order = append(b.order, update.TunnelID)
+	}
+	b.pending[update.TunnelID] = update
+	return first
+}
+
+// flush returns the diffs of the pending updates and starts a new batch.
+// It returns nil when nothing changed.
+func (b *updateBatcher) flush() (*TunnelUpdatesPayload, error) {
+	payload := &TunnelUpdatesPayload{}
+	for _, id := range b.order {
+		diff, err := b.diff(b.pending[id])
+		if err != nil {
+			return nil, err
+		}
+		if diff != nil {
+			payload.Updates = append(payload.Updates, *diff)
+		}
+	}
+	b.pending = make(map[string]TunnelUpdate)
+	b.order = b.order[:0]
+
+	if len(payload.Updates) == 0 {
+		return nil, nil
+	}
+	return payload, nil
+}
+
+// diff returns an update's changes to what the client has, or nil if none
+func (b *updateBatcher) diff(update TunnelUpdate) (*TunnelDiff, error) {
+	status, err := jsonFields(update.Status)
+	if err != nil {
+		return nil, err
+	}
+	prev, known := b.sent[update.TunnelID]
+	diff := &TunnelDiff{TunnelID: update.TunnelID, Full: !known, Status: diffFields(prev.status, status)}
+
+	if update.Tunnel == nil {
+		delete(b.sent, update.TunnelID)
+		diff.Deleted = true
+		return diff, nil
+	}
+
+	tunnel, err := jsonFields(update.Tunnel)
+	if err != nil {
+		return nil, err
+	}
+	diff.Tunnel = diffFields(prev.tunnel, tunnel)
+	b.sent[update.TunnelID] = tunnelFields{status: status, tunnel: tunnel}
+
+	if len(diff.Status) == 0 && len(diff.Tunnel) == 0 {
+		return nil, nil
+	}
+	return diff, nil
+}
+
+// jsonFields returns the top-level fields of v's JSON object, or nil for nil
+func jsonFields(v interface{}) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// diffFields returns the fields of cur that differ from prev, and null for
+// the fields of prev that cur no longer has
+func diffFields(prev, cur map[string]json.RawMessage) map[string]json.RawMessage {
+	changed := make(map[string]json.RawMessage)
+	for key, value := range cur {
+		if old, ok := prev[key]; !ok || !bytes.Equal(old, value) {
+			changed[key] = value
+		}
+	}
+	for key := range prev {
+		if _, ok := cur[key]; !ok {
+			changed[key] = nullField
+		}
+	}
+	return changed
+}
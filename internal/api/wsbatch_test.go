@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestUpdateBatcher(t *testing.T) {
+	b := newUpdateBatcher()
+	update := func(state types.TunnelState, lastError string) TunnelUpdate {
+		return TunnelUpdate{
+			TunnelID: "t1",
+			Status:   &types.TunnelStatus{TunnelID: "t1", State: state, LastError: lastError},
+			Tunnel:   &TunnelResponse{ID: "t1", Name: "db", Status: string(state)},
+		}
+	}
+
+	// Updates within a batch are coalesced; the first is sent in full
+	if !b.add(update(types.TunnelStatePending, "")) {
+		t.Error("Expected the first update to start a batch")
+	}
+	if b.add(update(types.TunnelStateFailed, "refused")) {
+		t.Error("Expected later updates to join the batch")
+	}
+	payload, err := b.flush()
+	if err != nil || payload == nil || len(payload.Updates) != 1 {
+		t.Fatalf("Expected one coalesced update, got %+v, %v", payload, err)
+	}
+	diff := payload.Updates[0]
+	if !diff.Full || string(diff.Status["state"]) != `"failed"` || string(diff.Tunnel["name"]) != `"db"` {
+		t.Errorf("Expected the latest update in full, got %+v", diff)
+	}
+
+	// Then only what changed, with dropped fields nulled
+	b.add(update(types.TunnelStateActive, ""))
+	payload, _ = b.flush()
+	diff = payload.Updates[0]
+	if diff.Full || string(diff.Status["state"]) != `"active"` || string(diff.Status["last_error"]) != "null" {
+		t.Errorf("Expected the changed and dropped status fields, got %+v", diff.Status)
+	}
+	if _, ok := diff.Tunnel["name"]; ok || string(diff.Tunnel["status"]) != `"active"` {
+		t.Errorf("Expected only the changed tunnel fields, got %+v", diff.Tunnel)
+	}
+
+	// Nothing is sent for updates that change nothing
+	b.add(update(types.TunnelStateActive, ""))
+	if payload, _ = b.flush(); payload != nil {
+		t.Errorf("Expected no message for an unchanged tunnel, got %+v", payload)
+	}
+
+	b.add(TunnelUpdate{TunnelID: "t1", Status: &types.TunnelStatus{TunnelID: "t1", State: types.TunnelStateStopped}})
+	payload, _ = b.flush()
+	if diff = payload.Updates[0]; !diff.Deleted {
+		t.Errorf("Expected the deletion to be reported, got %+v", diff)
+	}
+	if _, ok := b.sent["t1"]; ok {
+		t.Error("Expected a deleted tunnel to be forgotten")
+	}
+}
+
+func TestWebSocketBatchedUpdates(t *testing.T) {
+	wsManager := NewWebSocketManager()
+	wsManager.Start()
+	defer wsManager.Stop()
+
+	srv := httptest.NewServer(http.HandlerFunc(wsManager.HandleWebSocket))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	if _, resp, err := websocket.DefaultDialer.Dial(url+"?updates=diffs", nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an unknown update mode to be rejected, got %v", err)
+	}
+
+	dialer := websocket.Dialer{EnableCompression: true}
+	conn, resp, err := dialer.Dial(url+"?updates=batched", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); !strings.Contains(ext, "permessage-deflate") {
+		t.Errorf("Expected compression to be negotiated, got %q", ext)
+	}
+
+	read := func(v interface{}) string {
+		t.Helper()
+		var msg struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if err := json.Unmarshal(msg.Payload, v); err != nil {
+			t.Fatal(err)
+		}
+		return msg.Type
+	}
+
+	var hello HelloPayload
+	if typ := read(&hello); typ != wsMessageHello || hello.Updates != wsUpdatesBatched {
+		t.Fatalf("Expected a hello with batched updates, got %s %+v", typ, hello)
+	}
+
+	for i := 0; i < 5; i++ {
+		wsManager.BroadcastTunnelUpdate(TunnelUpdate{
+			TunnelID: "t1",
+			Status:   &types.TunnelStatus{TunnelID: "t1", State: types.TunnelStateActive, BytesSent: int64(i)},
+			Tunnel:   &TunnelResponse{ID: "t1", Name: "db"},
+		}, types.DefaultProject)
+	}
+	var updates TunnelUpdatesPayload
+	if typ := read(&updates); typ != wsMessageTunnelUpdates || len(updates.Updates) != 1 {
+		t.Fatalf("Expected the updates in one batch, got %s %+v", typ, updates)
+	}
+	if sent := string(updates.Updates[0].Status["bytes_sent"]); sent != "4" {
+		t.Errorf("Expected the latest status, got bytes_sent %s", sent)
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// WebSocketProtocolVersion is the version of the command protocol spoken
+// over /ws. Clients send it in each command; commands of other versions are
+// rejected so both sides notice an incompatible peer.
+const WebSocketProtocolVersion = 1
+
+// maxCommandSize bounds a single command read from a WebSocket client
+const maxCommandSize = 64 * 1024
+
+// Messages of the command protocol, besides the commands themselves
+const (
+	wsMessageHello     = "hello"     // sent on connect: protocol version and commands
+	wsMessageAck       = "ack"       // the result of a command
+	wsMessageHeartbeat = "heartbeat" // a client's keepalive, acknowledged like a command
+)
+
+// WebSocketCommand is a request a client sends over /ws. Commands act with
+// the caller's identity and project, exactly like the REST endpoint they
+// mirror, and are answered with an ack carrying the same ID.
+type WebSocketCommand struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Version int             `json:"version"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// CommandAck is the payload of an ack: the REST status and either the
+// response body or the error
+type CommandAck struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	OK     bool            `json:"ok"`
+	Status int             `json:"status"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *APIError       `json:"error,omitempty"`
+}
+
+// HelloPayload is the payload of the hello message
+type HelloPayload struct {
+	ProtocolVersion int      `json:"protocolVersion"`
+	Commands        []string `json:"commands"`
+	Updates         string   `json:"updates"`      // tunnel update mode: full or batched
+	ClientID        string   `json:"clientId"`     // to resume with after reconnecting
+	Resumed         bool     `json:"resumed"`      // whether the missed events follow; if not, refetch
+	ResumeWindow    float64  `json:"resumeWindow"` // seconds a disconnected client can resume within
+	PingInterval    float64  `json:"pingInterval"` // seconds between the server's pings
+}
+
+// CommandHandler executes a command from a WebSocket client. ctx carries the
+// client's identity and project.
+type CommandHandler func(ctx context.Context, cmd WebSocketCommand) CommandAck
+
+// tunnelCommand is the payload of the commands that act on one tunnel
+type tunnelCommand struct {
+	TunnelID string `json:"tunnelId"`
+}
+
+// webSocketCommands maps commands to the REST endpoint they mirror
+var webSocketCommands = map[string]struct {
+	method   string
+	path     string // %s is the tunnel ID
+	tunnelID bool
+	handler  func(s *Server) http.HandlerFunc
+}{
+	"list":   {http.MethodGet, "/tunnels", false, func(s *Server) http.HandlerFunc { return s.handleListTunnels }},
+	"create": {http.MethodPost, "/tunnels", false, func(s *Server) http.HandlerFunc { return s.handleCreateTunnel }},
+	"get":    {http.MethodGet, "/tunnels/%s", true, func(s *Server) http.HandlerFunc { return s.handleGetTunnel }},
+	"status": {http.MethodGet, "/tunnels/%s/status", true, func(s *Server) http.HandlerFunc { return s.handleGetTunnelStatus }},
+	"start":  {http.MethodPost, "/tunnels/%s/start", true, func(s *Server) http.HandlerFunc { return s.handleStartTunnel }},
+	"stop":   {http.MethodPost, "/tunnels/%s/stop", true, func(s *Server) http.HandlerFunc { return s.handleStopTunnel }},
+	"delete": {http.MethodDelete, "/tunnels/%s", true, func(s *Server) http.HandlerFunc { return s.handleDeleteTunnel }},
+
+	"reconnect": {http.MethodPost, "/tunnels/%s/reconnect", true, func(s *Server) http.HandlerFunc { return s.handleReconnectTunnel }},
+}
+
+// commandNames lists the supported commands, for the hello message
+func commandNames() []string {
+	return []string{wsMessageHeartbeat, "list", "create", "get", "status", "start", "stop", "reconnect", "delete"}
+}
+
+// handleWebSocketCommand runs a command through the handler of the REST
+// endpoint it mirrors, so validation, ownership and quota checks apply
+// unchanged
+func (s *Server) handleWebSocketCommand(ctx context.Context, cmd WebSocketCommand) CommandAck {
+	ack := CommandAck{ID: cmd.ID, Type: cmd.Type}
+	fail := func(status int, apiErr *APIError) CommandAck {
+		ack.Status = status
+		ack.Error = apiErr
+		return ack
+	}
+
+	if cmd.Version != WebSocketProtocolVersion {
+		return fail(http.StatusBadRequest, NewAPIError(ErrCodeUnsupportedCommand,
+			fmt.Sprintf("Unsupported protocol version %d; this server speaks version %d", cmd.Version, WebSocketProtocolVersion)))
+	}
+	if cmd.Type == wsMessageHeartbeat {
+		ack.OK, ack.Status = true, http.StatusOK
+		return ack
+	}
+	command, ok := webSocketCommands[cmd.Type]
+	if !ok {
+		return fail(http.StatusBadRequest, NewAPIError(ErrCodeUnsupportedCommand, fmt.Sprintf("Unknown command %q", cmd.Type)))
+	}
+
+	// Tokens expire and sessions are revoked while a socket stays open
+	if apiErr := s.checkCommandAuth(ctx); apiErr != nil {
+		return fail(http.StatusUnauthorized, apiErr)
+	}
+
+	path, vars := command.path, map[string]string{}
+	body := []byte(cmd.Payload)
+	if command.tunnelID {
+		var target tunnelCommand
+		if len(cmd.Payload) > 0 {
+			if err := json.Unmarshal(cmd.Payload, &target); err != nil {
+				return fail(http.StatusBadRequest, NewAPIError(ErrCodeBadRequest, "Invalid command payload"))
+			}
+		}
+		if target.TunnelID == "" {
+			return fail(http.StatusBadRequest, NewAPIError(ErrCodeValidation, "tunnelId is required"))
+		}
+		path = fmt.Sprintf(path, url.PathEscape(target.TunnelID))
+		vars["id"] = target.TunnelID
+		body = nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, command.method, "/api/v1"+path, bytes.NewReader(body))
+	if err != nil {
+		return fail(http.StatusInternalServerError, NewAPIError(ErrCodeInternal, "Failed to build request"))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req = mux.SetURLVars(req, vars)
+
+	rec := &commandRecorder{header: make(http.Header)}
+	command.handler(s)(rec, req)
+
+	ack.Status = rec.status
+	if ack.Status == 0 {
+		ack.Status = http.StatusOK
+	}
+	ack.OK = ack.Status < http.StatusBadRequest
+	if ack.OK {
+		if rec.body.Len() > 0 {
+			ack.Result = json.RawMessage(bytes.TrimSpace(rec.body.Bytes()))
+		}
+		return ack
+	}
+
+	var apiErr APIError
+	if err := json.Unmarshal(rec.body.Bytes(), &apiErr); err != nil || apiErr.Code == "" {
+		apiErr = *NewAPIError(ErrCodeInternal, http.StatusText(ack.Status))
+	}
+	ack.Error = &apiErr
+	return ack
+}
+
+// checkCommandAuth re-checks the token a socket was opened with
+func (s *Server) checkCommandAuth(ctx context.Context) *APIError {
+	if s.auth == nil {
+		return nil
+	}
+	claims, ok := GetClaims(ctx)
+	if !ok {
+		return NewAPIError(ErrCodeMissingAuth, "Missing authorization token")
+	}
+	if claims.ExpiresAt != nil && time.Now().After(claims.ExpiresAt.Time) {
+		return NewAPIError(ErrCodeTokenExpired, "Authentication token has expired")
+	}
+	return s.auth.checkRevoked(ctx, claims)
+}
+
+// commandContext keeps the identity and project of the request that opened
+// a socket for its commands, outliving the request itself
+func commandContext(parent context.Context, r *http.Request) context.Context {
+	ctx := parent
+	for _, key := range []contextKey{userContextKey, claimsContextKey, projectContextKey} {
+		if value := r.Context().Value(key); value != nil {
+			ctx = context.WithValue(ctx, key, value)
+		}
+	}
+	return ctx
+}
+
+// commandRecorder captures the response of a handler run for a command
+type commandRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *commandRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *commandRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+}
+
+func (rec *commandRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(p)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestWebSocketCommands(t *testing.T) {
+	s := newAuthTestServer(t, &types.TunnelSpec{ID: "bobs", Name: "bobs", Owner: "bob", Type: types.TunnelTypeLocal})
+	wsManager := NewWebSocketManager()
+	wsManager.Start()
+	defer wsManager.Stop()
+
+	s.wsManager = wsManager
+	wsManager.SetCommandHandler(s.handleWebSocketCommand)
+
+	router := mux.NewRouter()
+	protected := router.NewRoute().Subrouter()
+	protected.Use(s.auth.Middleware)
+	s.registerProjectRoutes(protected)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	token, _, err := s.auth.IssueToken("1", "alice", "", []string{"user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	read := func() (string, json.RawMessage) {
+		t.Helper()
+		var msg struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		return msg.Type, msg.Payload
+	}
+	// call sends a command and returns its ack, skipping broadcasts
+	call := func(cmd WebSocketCommand) CommandAck {
+		t.Helper()
+		if err := conn.WriteJSON(cmd); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		for {
+			typ, payload := read()
+			if typ != wsMessageAck {
+				continue
+			}
+			var ack CommandAck
+			if err := json.Unmarshal(payload, &ack); err != nil {
+				t.Fatal(err)
+			}
+			if ack.ID != cmd.ID {
+				t.Fatalf("Expected the ack of %s, got %+v", cmd.ID, ack)
+			}
+			return ack
+		}
+	}
+
+	typ, payload := read()
+	var hello HelloPayload
+	if err := json.Unmarshal(payload, &hello); typ != wsMessageHello || err != nil || hello.ProtocolVersion != WebSocketProtocolVersion {
+		t.Fatalf("Expected a hello first, got %s %s", typ, payload)
+	}
+
+	body := `{"name": "ws-db", "type": "local", "agentId": "edge-1", "localPort": 5432, "remoteHost": "db.internal", "remotePort": 5432,
+		"hops": [{"host": "bastion.example.com", "port": 22, "user": "ops", "auth_method": "agent"}]}`
+	ack := call(WebSocketCommand{ID: "1", Type: "create", Version: WebSocketProtocolVersion, Payload: json.RawMessage(body)})
+	if !ack.OK || ack.Status != http.StatusCreated {
+		t.Fatalf("Expected create to succeed, got %+v", ack)
+	}
+	var created TunnelResponse
+	if err := json.Unmarshal(ack.Result, &created); err != nil || created.Owner != "alice" {
+		t.Fatalf("Expected the created tunnel owned by the caller, got %s", ack.Result)
+	}
+
+	ack = call(WebSocketCommand{ID: "2", Type: "stop", Version: WebSocketProtocolVersion, Payload: json.RawMessage(`{"tunnelId": "` + created.ID + `"}`)})
+	if !ack.OK {
+		t.Errorf("Expected stop to succeed, got %+v", ack)
+	}
+
+	// Ownership applies as over REST
+	ack = call(WebSocketCommand{ID: "3", Type: "stop", Version: WebSocketProtocolVersion, Payload: json.RawMessage(`{"tunnelId": "bobs"}`)})
+	if ack.OK || ack.Status != http.StatusForbidden || ack.Error == nil {
+		t.Errorf("Expected stopping another user's tunnel to be forbidden, got %+v", ack)
+	}
+
+	ack = call(WebSocketCommand{ID: "4", Type: "reboot", Version: WebSocketProtocolVersion})
+	if ack.OK || ack.Error == nil || ack.Error.Code != ErrCodeUnsupportedCommand {
+		t.Errorf("Expected an unknown command to be rejected, got %+v", ack)
+	}
+	ack = call(WebSocketCommand{ID: "5", Type: "heartbeat", Version: 99})
+	if ack.OK || ack.Error == nil || ack.Error.Code != ErrCodeUnsupportedCommand {
+		t.Errorf("Expected another protocol version to be rejected, got %+v", ack)
+	}
+	if ack = call(WebSocketCommand{ID: "6", Type: "heartbeat", Version: WebSocketProtocolVersion}); !ack.OK {
+		t.Errorf("Expected heartbeats to be acknowledged, got %+v", ack)
+	}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// wsResumeWindow is how long a disconnected client can come back and
+	// get the events it missed
+	wsResumeWindow = 2 * time.Minute
+
+	// wsResumeBuffer is how many of its latest events a client can get
+	// back when resuming; a client that missed more has to refetch
+	wsResumeBuffer = 128
+
+	// wsPingInterval is how often clients are pinged, and wsPongWait how
+	// long the server waits for a pong, or any message, before dropping one
+	wsPingInterval = 30 * time.Second
+	wsPongWait     = 60 * time.Second
+)
+
+// wsSession is what outlives a client's connection: its ID and the events
+// sent to it, numbered, so that it can resume after reconnecting
+type wsSession struct {
+	id      string
+	userID  string
+	project string
+	user    *User // nil without authentication
+
+	mu           sync.Mutex
+	seq          uint64             // of the last event
+	events       []WebSocketMessage // the last wsResumeBuffer events, oldest first
+	disconnected time.Time          // zero while connected
+}
+
+func newWSSession(userID, project string, user *User) *wsSession {
+	return &wsSession{id: uuid.NewString(), userID: userID, project: project, user: user}
+}
+
+// receives reports whether the client gets an event: one of its project,
+// and for its user, if the event is only for the owner and admins
+func (s *wsSession) receives(message WebSocketMessage) bool {
+	if message.project != "" && message.project != s.project {
+		return false
+	}
+	return message.owner == "" || s.user == nil || s.user.Username == message.owner || s.user.HasRole("admin")
+}
+
+// record numbers an event for the client and keeps it for resuming
+func (s *wsSession) record(message WebSocketMessage) WebSocketMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	message.Seq = s.seq
+	if len(s.events) == wsResumeBuffer {
+		s.events = append(s.events[:0], s.events[1:]...)
+	}
+	s.events = append(s.events, message)
+	return message
+}
+
+// since returns the events after lastSeq, or false if some of them are no
+// longer kept
+func (s *wsSession) since(lastSeq uint64) ([]WebSocketMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lastSeq > s.seq {
+		return nil, false
+	}
+	missed := int(s.seq - lastSeq)
+	if missed > len(s.events) {
+		return nil, false
+	}
+	return append([]WebSocketMessage(nil), s.events[len(s.events)-missed:]...), true
+}
+
+// resumeLocked takes over the disconnected session a client asks to resume
+// and returns it with the events the client missed. It returns false if the
+// session is gone, belongs to another user or project, or can't replay all
+// the events the client missed. Must be called with wsm.mu held.
+func (wsm *WebSocketManager) resumeLocked(id, lastSeq, userID, project string) (*wsSession, []WebSocketMessage, bool) {
+	session, ok := wsm.sessions[id]
+	if !ok || session.userID != userID || session.project != project {
+		return nil, nil, false
+	}
+	seq, err := strconv.ParseUint(lastSeq, 10, 64)
+	if err != nil {
+		return nil, nil, false
+	}
+	missed, ok := session.since(seq)
+	if !ok {
+		return nil, nil, false
+	}
+
+	delete(wsm.sessions, id)
+	session.mu.Lock()
+	session.disconnected = time.Time{}
+	session.mu.Unlock()
+	return session, missed, true
+}
+
+// detachLocked unregisters a client, keeping its session for resuming.
+// Must be called with wsm.mu held.
+func (wsm *WebSocketManager) detachLocked(client *WebSocketClient) {
+	delete(wsm.clients, client)
+	close(client.send)
+
+	client.session.mu.Lock()
+	client.session.disconnected = time.Now()
+	client.session.mu.Unlock()
+	wsm.sessions[client.session.id] = client.session
+}
+
+// expireSessions forgets the sessions of clients that didn't come back
+// within the resume window
+func (wsm *WebSocketManager) expireSessions(now time.Time) {
+	wsm.mu.Lock()
+	defer wsm.mu.Unlock()
+	for id, session := range wsm.sessions {
+		session.mu.Lock()
+		expired := now.Sub(session.disconnected) > wsResumeWindow
+		session.mu.Unlock()
+		if expired {
+			delete(wsm.sessions, id)
+		}
+	}
+}
+
+// HeartbeatResult is the result of a heartbeat command: how the server
+// sees the client's connection
+type HeartbeatResult struct {
+	ClientID   string     `json:"clientId"`
+	LastPongAt *time.Time `json:"lastPongAt,omitempty"` // the client's last answer to a ping
+	PingRTTMs  float64    `json:"pingRttMs"`            // round trip of the last ping
+}
+
+// liveness returns the heartbeat result of the client
+func (c *WebSocketClient) liveness() json.RawMessage {
+	result := HeartbeatResult{ClientID: c.session.id}
+	if pong := c.lastPong.Load(); pong != 0 {
+		at := time.Unix(0, pong)
+		result.LastPongAt = &at
+		result.PingRTTMs = float64(c.pingRTT.Load()) / float64(time.Millisecond)
+	}
+	data, _ := json.Marshal(result)
+	return data
+}
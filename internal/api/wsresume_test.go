@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestWebSocketResume(t *testing.T) {
+	wsManager := NewWebSocketManager()
+	wsManager.Start()
+	defer wsManager.Stop()
+	wsManager.SetCommandHandler(func(ctx context.Context, cmd WebSocketCommand) CommandAck {
+		return CommandAck{ID: cmd.ID, Type: cmd.Type, OK: true, Status: http.StatusOK}
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(wsManager.HandleWebSocket))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	type message struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+		Seq     uint64          `json:"seq"`
+	}
+	read := func(conn *websocket.Conn) message {
+		t.Helper()
+		var msg message
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		return msg
+	}
+	hello := func(conn *websocket.Conn) HelloPayload {
+		t.Helper()
+		var hello HelloPayload
+		if msg := read(conn); msg.Type != wsMessageHello || json.Unmarshal(msg.Payload, &hello) != nil {
+			t.Fatalf("Expected a hello, got %+v", msg)
+		}
+		return hello
+	}
+	broadcast := func(state types.TunnelState) {
+		wsManager.BroadcastTunnelUpdate(TunnelUpdate{TunnelID: "t1", Status: &types.TunnelStatus{TunnelID: "t1", State: state}}, types.DefaultProject)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	first := hello(conn)
+	if first.ClientID == "" || first.Resumed {
+		t.Fatalf("Expected a new client ID, got %+v", first)
+	}
+
+	// Heartbeats report how the server sees the connection
+	if err := conn.WriteJSON(WebSocketCommand{ID: "1", Type: wsMessageHeartbeat, Version: WebSocketProtocolVersion}); err != nil {
+		t.Fatal(err)
+	}
+	var ack CommandAck
+	var liveness HeartbeatResult
+	if msg := read(conn); json.Unmarshal(msg.Payload, &ack) != nil || json.Unmarshal(ack.Result, &liveness) != nil || liveness.ClientID != first.ClientID {
+		t.Errorf("Expected the heartbeat ack to carry the client ID, got %+v", msg)
+	}
+
+	broadcast(types.TunnelStatePending)
+	broadcast(types.TunnelStateActive)
+	if msg := read(conn); msg.Seq != 1 {
+		t.Fatalf("Expected events to be numbered from 1, got %+v", msg)
+	}
+	if msg := read(conn); msg.Seq != 2 {
+		t.Fatalf("Expected event 2, got %+v", msg)
+	}
+	conn.Close()
+
+	// Events while disconnected are kept for the client
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		wsManager.mu.RLock()
+		_, detached := wsManager.sessions[first.ClientID]
+		wsManager.mu.RUnlock()
+		if detached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the client to disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	broadcast(types.TunnelStateFailed)
+	broadcast(types.TunnelStatePending)
+
+	conn, _, err = websocket.DefaultDialer.Dial(url+"?resume="+first.ClientID+"&lastSeq=2", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if resumed := hello(conn); !resumed.Resumed || resumed.ClientID != first.ClientID {
+		t.Fatalf("Expected to resume, got %+v", resumed)
+	}
+	for _, want := range []uint64{3, 4} {
+		if msg := read(conn); msg.Type != "tunnel_update" || msg.Seq != want {
+			t.Fatalf("Expected missed event %d, got %+v", want, msg)
+		}
+	}
+
+	// A session can't be resumed twice, so a second client starts over
+	other, _, err := websocket.DefaultDialer.Dial(url+"?resume="+first.ClientID+"&lastSeq=2", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer other.Close()
+	if fresh := hello(other); fresh.Resumed || fresh.ClientID == first.ClientID {
+		t.Errorf("Expected a new session, got %+v", fresh)
+	}
+}
+
+func TestWSSessionSince(t *testing.T) {
+	session := newWSSession("alice", "", nil)
+	for i := 0; i < wsResumeBuffer+10; i++ {
+		session.record(WebSocketMessage{Type: "tunnel_update"})
+	}
+
+	missed, ok := session.since(uint64(wsResumeBuffer))
+	if !ok || len(missed) != 10 || missed[0].Seq != uint64(wsResumeBuffer)+1 {
+		t.Errorf("Expected the last 10 events, got %d, %v", len(missed), ok)
+	}
+	if _, ok := session.since(5); ok {
+		t.Error("Expected events no longer kept to prevent resuming")
+	}
+	if _, ok := session.since(uint64(wsResumeBuffer) + 11); ok {
+		t.Error("Expected a seq from the future to prevent resuming")
+	}
+}
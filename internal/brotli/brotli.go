@@ -0,0 +1,479 @@
+// Package brotli implements a brotli (RFC 7932) compressor for HTTP
+// responses. It finds repeats like gzip does, lazily along hash chains
+// within a 64 KiB window, and codes each block with one set of prefix
+// codes, without the context modeling or static dictionary of the
+// reference encoder. On JSON and text that comes out a little smaller than
+// gzip, between the reference encoder's qualities 3 and 5.
+package brotli
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+const (
+	windowBits  = 16
+	maxDistance = 1<<windowBits - 16 // how far back a repeat may start
+
+	// blockSize is the most input compressed as one meta-block. It fits a
+	// length of four nibbles.
+	blockSize = 1 << 16
+
+	minMatch    = 4
+	chainLength = 64  // most earlier positions tried for a repeat
+	niceLength  = 128 // repeats this long end the search
+
+	hashBits  = 15
+	hashShift = 32 - hashBits
+	chainSize = 1 << 16 // a power of two of at least maxDistance
+	chainMask = chainSize - 1
+
+	literalAlphabet  = 256
+	commandAlphabet  = 704
+	distanceAlphabet = 64 // without direct distance codes or postfix bits
+
+	// initialDistance is the last distance decoders start from
+	initialDistance = 4
+)
+
+// Insert and copy length codes: the base length of each, and the number
+// of extra bits added to it
+var (
+	insertBase  = [24]uint32{0, 1, 2, 3, 4, 5, 6, 8, 10, 14, 18, 26, 34, 50, 66, 98, 130, 194, 322, 578, 1090, 2114, 6210, 22594}
+	insertExtra = [24]uint8{0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 12, 14, 24}
+	copyBase    = [24]uint32{2, 3, 4, 5, 6, 7, 8, 9, 10, 12, 14, 18, 22, 30, 38, 54, 70, 102, 134, 198, 326, 582, 1094, 2118}
+	copyExtra   = [24]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 24}
+)
+
+// Insert-and-copy codes come in cells of 64, by range of insert and copy
+// length codes. The first two cells reuse the last distance without coding
+// it; the others are followed by a distance code.
+var (
+	lastDistanceCells = [2]int{0, 64}
+	commandCells      = [3][3]int{{128, 192, 384}, {256, 320, 512}, {448, 576, 640}}
+)
+
+// errClosed is returned for writes to a closed Writer
+var errClosed = errors.New("brotli: write to closed writer")
+
+// Writer compresses what is written to it to an underlying writer. Output
+// is buffered until a block fills up, Flush or Close.
+type Writer struct {
+	w   io.Writer
+	out bitWriter
+	err error
+
+	// window holds recent input, which repeats can refer to, followed by
+	// the input not compressed yet from pending on
+	window  []byte
+	pending int
+
+	// Hash chains: the window position + 1 of the last input with each
+	// hash, and of the input before each position with the same hash
+	head [1 << hashBits]int32
+	prev [chainSize]int32
+
+	lastDistance int // of the last repeat, which decoders keep too
+
+	wroteHeader bool
+	closed      bool
+}
+
+// NewWriter returns a Writer compressing to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, lastDistance: initialDistance}
+}
+
+// Reset discards the Writer's state to compress a new stream to w, keeping
+// its buffers
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.out.reset()
+	z.err = nil
+	z.window = z.window[:0]
+	z.pending = 0
+	clear(z.head[:])
+	clear(z.prev[:])
+	z.lastDistance = initialDistance
+	z.wroteHeader = false
+	z.closed = false
+}
+
+// Write compresses p
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	written := len(p)
+	for len(p) > 0 {
+		n := min(len(p), blockSize-(len(z.window)-z.pending))
+		z.window = append(z.window, p[:n]...)
+		p = p[n:]
+		if len(z.window)-z.pending == blockSize {
+			z.compressPending()
+			if err := z.emit(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush compresses the input so far and writes it out, so the other side
+// can decompress all of it
+func (z *Writer) Flush() error {
+	if z.closed {
+		return errClosed
+	}
+	if z.err != nil {
+		return z.err
+	}
+	z.compressPending()
+	// An empty metadata block, padded to the next byte
+	z.out.writeBits(1, 0) // not the last block
+	z.out.writeBits(2, 3) // metadata
+	z.out.writeBits(1, 0) // reserved
+	z.out.writeBits(2, 0) // no metadata bytes
+	z.out.alignToByte()
+	return z.emit()
+}
+
+// Close compresses what is left and ends the stream. It doesn't close the
+// underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	if z.err == nil {
+		z.compressPending()
+		z.out.writeBits(1, 1) // the last block
+		z.out.writeBits(1, 1) // which is empty
+		z.out.alignToByte()
+		z.emit()
+	}
+	z.closed = true
+	return z.err
+}
+
+// emit writes the complete bytes of output to the underlying writer
+func (z *Writer) emit() error {
+	if z.err != nil {
+		return z.err
+	}
+	if len(z.out.buf) > 0 {
+		_, z.err = z.w.Write(z.out.buf)
+		z.out.buf = z.out.buf[:0]
+	}
+	return z.err
+}
+
+// compressPending writes the input not compressed yet as a meta-block
+func (z *Writer) compressPending() {
+	if !z.wroteHeader {
+		z.out.writeBits(1, 0) // a window of 16 bits
+		z.wroteHeader = true
+	}
+	if z.pending == len(z.window) {
+		return
+	}
+
+	start, end := z.pending, len(z.window)
+	mark := z.out.mark()
+	if !z.writeCompressed(start, end) {
+		z.out.rewind(mark)
+		z.writeUncompressed(start, end)
+	}
+	z.pending = end
+
+	// Keep what repeats may refer to, sliding it to the start now and then
+	// by whole chain lengths, so positions keep their place in the chains
+	if z.pending > 2*chainSize {
+		z.slide((z.pending - maxDistance) &^ chainMask)
+	}
+}
+
+// slide drops the first n bytes of the window
+func (z *Writer) slide(n int) {
+	z.window = z.window[:copy(z.window, z.window[n:])]
+	z.pending -= n
+	for i, position := range z.head {
+		z.head[i] = max(position-int32(n), 0)
+	}
+	for i, position := range z.prev {
+		z.prev[i] = max(position-int32(n), 0)
+	}
+}
+
+// hash4 hashes the four bytes of input at i
+func (z *Writer) hash4(i int) uint32 {
+	return binary.LittleEndian.Uint32(z.window[i:]) * 0x1e35a7bd >> hashShift
+}
+
+// insert adds the input at i to the hash chains
+func (z *Writer) insert(i int) {
+	h := z.hash4(i)
+	z.prev[i&chainMask] = z.head[h]
+	z.head[h] = int32(i + 1)
+}
+
+// longestMatch finds the longest repeat of the input at i, up to end,
+// starting with one at the last distance, which is cheapest to code. It
+// returns a length of 0 if none is long enough.
+func (z *Writer) longestMatch(i, end, lastDistance int) (length, distance int) {
+	limit := min(end-i, niceLength)
+	if lastDistance <= i {
+		length, distance = matchLength(z.window[i-lastDistance:], z.window[i:i+limit]), lastDistance
+	}
+
+	candidate := int(z.head[z.hash4(i)]) - 1
+	for tries := chainLength; candidate >= 0 && tries > 0 && length < limit; tries-- {
+		d := i - candidate
+		if d > maxDistance {
+			break
+		}
+		if z.window[candidate+length] == z.window[i+length] {
+			if n := matchLength(z.window[candidate:], z.window[i:i+limit]); n > length {
+				length, distance = n, d
+			}
+		}
+		candidate = int(z.prev[candidate&chainMask]) - 1
+	}
+
+	if length < minMatch {
+		return 0, 0
+	}
+	// Only now take the rest of a repeat longer than the search looked at
+	for i+length < end && z.window[i+length-distance] == z.window[i+length] {
+		length++
+	}
+	return length, distance
+}
+
+// matchLength returns how many bytes a and b start with in common
+func matchLength(a, b []byte) int {
+	n := 0
+	for n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// command inserts literals, then copies earlier output
+type command struct {
+	insert   int
+	copy     int // 0 for the end of a block that ends with literals
+	distance int
+}
+
+// findCommands finds the repeats in the window from start to end, taking
+// each unless the input after it starts a longer one. It returns the last
+// distance after them.
+func (z *Writer) findCommands(start, end int) ([]command, int) {
+	var commands []command
+	lastDistance := z.lastDistance
+	literals := start
+	for i := start; i+minMatch <= end; {
+		length, distance := z.longestMatch(i, end, lastDistance)
+		z.insert(i)
+		if length == 0 {
+			i++
+			continue
+		}
+		if length < niceLength && i+1+minMatch <= end {
+			if next, nextDistance := z.longestMatch(i+1, end, lastDistance); next > length {
+				i++
+				z.insert(i)
+				length, distance = next, nextDistance
+			}
+		}
+
+		commands = append(commands, command{insert: i - literals, copy: length, distance: distance})
+		lastDistance = distance
+		for j := i + 1; j < i+length && j+minMatch <= end; j++ {
+			z.insert(j)
+		}
+		i += length
+		literals = i
+	}
+	if literals < end {
+		commands = append(commands, command{insert: end - literals})
+	}
+	return commands, lastDistance
+}
+
+// writeCompressed writes the window from start to end as a compressed
+// meta-block. It reports false if that came out larger than the input.
+func (z *Writer) writeCompressed(start, end int) bool {
+	commands, lastDistance := z.findCommands(start, end)
+
+	// Code each command once, for the counts and then to write it
+	type coded struct {
+		command, insertCode, copyCode int
+		distanceCode                  int // -1 when the command has none
+		distanceExtra                 uint32
+		distanceBits                  uint8
+	}
+	codes := make([]coded, len(commands))
+	var literalCounts [literalAlphabet]uint32
+	var commandCounts [commandAlphabet]uint32
+	var distanceCounts [distanceAlphabet]uint32
+	previous := z.lastDistance
+	position := start
+	for i, cmd := range commands {
+		c := &codes[i]
+		c.insertCode = lengthCode(&insertBase, uint32(cmd.insert))
+		c.copyCode = lengthCode(&copyBase, uint32(max(cmd.copy, 2)))
+		c.distanceCode = -1
+		cell := commandCells[c.insertCode>>3][c.copyCode>>3]
+		switch {
+		case cmd.copy == 0:
+		case cmd.distance == previous && c.insertCode < 8 && c.copyCode < 16:
+			cell = lastDistanceCells[c.copyCode>>3]
+		case cmd.distance == previous:
+			c.distanceCode = 0 // the last distance
+		default:
+			c.distanceCode, c.distanceExtra, c.distanceBits = distanceCode(cmd.distance)
+			previous = cmd.distance
+		}
+		c.command = cell + (c.insertCode&7)<<3 | c.copyCode&7
+		commandCounts[c.command]++
+		if c.distanceCode >= 0 {
+			distanceCounts[c.distanceCode]++
+		}
+		for _, literal := range z.window[position : position+cmd.insert] {
+			literalCounts[literal]++
+		}
+		position += cmd.insert + cmd.copy
+	}
+
+	literalCode := newPrefixCode(literalCounts[:], maxCodeLength)
+	commandCode := newPrefixCode(commandCounts[:], maxCodeLength)
+	distanceCodes := newPrefixCode(distanceCounts[:], maxCodeLength)
+
+	b := &z.out
+	before := b.size()
+	writeMetaBlockLength(b, end-start)
+	b.writeBits(1, 0) // compressed
+	b.writeBits(1, 0) // one literal block type
+	b.writeBits(1, 0) // one command block type
+	b.writeBits(1, 0) // one distance block type
+	b.writeBits(2, 0) // no postfix bits
+	b.writeBits(4, 0) // no direct distance codes
+	b.writeBits(2, 0) // literal context mode, unused with one code
+	b.writeBits(1, 0) // one literal prefix code
+	b.writeBits(1, 0) // one distance prefix code
+	literalCode.write(b, literalCounts[:], 8)
+	commandCode.write(b, commandCounts[:], 10)
+	distanceCodes.write(b, distanceCounts[:], 6)
+
+	position = start
+	for i, cmd := range commands {
+		c := &codes[i]
+		commandCode.writeSymbol(b, c.command)
+		b.writeBits(uint(insertExtra[c.insertCode]), uint64(uint32(cmd.insert)-insertBase[c.insertCode]))
+		b.writeBits(uint(copyExtra[c.copyCode]), uint64(uint32(max(cmd.copy, 2))-copyBase[c.copyCode]))
+		for _, literal := range z.window[position : position+cmd.insert] {
+			literalCode.writeSymbol(b, int(literal))
+		}
+		if c.distanceCode >= 0 {
+			distanceCodes.writeSymbol(b, c.distanceCode)
+			b.writeBits(uint(c.distanceBits), uint64(c.distanceExtra))
+		}
+		position += cmd.insert + cmd.copy
+	}
+
+	if b.size()-before > 8*(end-start) {
+		return false
+	}
+	z.lastDistance = lastDistance
+	return true
+}
+
+// writeUncompressed writes the window from start to end as is
+func (z *Writer) writeUncompressed(start, end int) {
+	writeMetaBlockLength(&z.out, end-start)
+	z.out.writeBits(1, 1) // uncompressed
+	z.out.alignToByte()
+	z.out.buf = append(z.out.buf, z.window[start:end]...)
+}
+
+// writeMetaBlockLength starts a meta-block that isn't the last one, of n
+// bytes
+func writeMetaBlockLength(b *bitWriter, n int) {
+	b.writeBits(1, 0) // not the last block
+	b.writeBits(2, 0) // four nibbles
+	b.writeBits(16, uint64(n-1))
+}
+
+// lengthCode returns the insert or copy length code for a length
+func lengthCode(base *[24]uint32, length uint32) int {
+	code := len(base) - 1
+	for base[code] > length {
+		code--
+	}
+	return code
+}
+
+// distanceCode returns the distance code for a distance, with its extra
+// bits and their number. Codes from 16 on stand for ranges of distances,
+// doubling in size every other code.
+func distanceCode(distance int) (int, uint32, uint8) {
+	offset := uint32(distance) + 3
+	extraBits := uint8(31 - bits.LeadingZeros32(offset) - 1)
+	prefix := offset >> extraBits & 1
+	code := 16 + 2*(int(extraBits)-1) + int(prefix)
+	return code, offset - (2+prefix)<<extraBits, extraBits
+}
+
+// bitWriter packs values into bytes, least significant bit first
+type bitWriter struct {
+	buf   []byte
+	bits  uint64
+	nbits uint
+}
+
+func (b *bitWriter) writeBits(n uint, value uint64) {
+	b.bits |= value << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.buf = append(b.buf, byte(b.bits))
+		b.bits >>= 8
+		b.nbits -= 8
+	}
+}
+
+// alignToByte pads the output with zero bits to a whole byte
+func (b *bitWriter) alignToByte() {
+	if b.nbits > 0 {
+		b.writeBits(8-b.nbits, 0)
+	}
+}
+
+// size returns the number of bits written
+func (b *bitWriter) size() int {
+	return 8*len(b.buf) + int(b.nbits)
+}
+
+// bitMark is a position in the output to rewind to
+type bitMark struct {
+	n     int
+	bits  uint64
+	nbits uint
+}
+
+func (b *bitWriter) mark() bitMark {
+	return bitMark{len(b.buf), b.bits, b.nbits}
+}
+
+func (b *bitWriter) rewind(m bitMark) {
+	b.buf, b.bits, b.nbits = b.buf[:m.n], m.bits, m.nbits
+}
+
+func (b *bitWriter) reset() {
+	b.buf, b.bits, b.nbits = b.buf[:0], 0, 0
+}
@@ -0,0 +1,271 @@
+package brotli
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func compress(t *testing.T, input []byte, chunk int, flush bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	z := NewWriter(&buf)
+	for p := input; len(p) > 0; {
+		n := min(len(p), chunk)
+		if _, err := z.Write(p[:n]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if flush {
+			if err := z.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+		}
+		p = p[n:]
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// decompress runs the reference decoder, as bundled with Node.js. Flushed
+// output decodes without the end of the stream.
+func decompress(t *testing.T, compressed []byte, partial bool) []byte {
+	t.Helper()
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("no node to run the reference decoder with")
+	}
+	options := "{}"
+	if partial {
+		options = "{finishFlush: zlib.constants.BROTLI_OPERATION_FLUSH}"
+	}
+	cmd := exec.Command(node, "-e", `const zlib = require("zlib");
+process.stdout.write(zlib.brotliDecompressSync(require("fs").readFileSync(0), `+options+`))`)
+	cmd.Stdin = bytes.NewReader(compressed)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Reference decoder failed: %v: %s", err, stderr.String())
+	}
+	return out
+}
+
+func testInputs() map[string][]byte {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 100_000)
+	rng.Read(random)
+
+	var tunnels strings.Builder
+	tunnels.WriteString("[")
+	for i := range 3000 {
+		fmt.Fprintf(&tunnels, `{"id":"%08x","name":"tunnel-%d","localPort":%d,"status":{"state":"active","bytesSent":%d}},`, i*7919, i, 10000+i, i*31337)
+	}
+	tunnels.WriteString("{}]")
+
+	// Literals from a small alphabet, with repeats of any length and distance
+	mixed := make([]byte, 150_000)
+	for i := 0; i < len(mixed); {
+		if i > 0 && rng.Intn(8) == 0 {
+			distance := 1 + rng.Intn(min(i, maxDistance))
+			for n := rng.Intn(300); n > 0 && i < len(mixed); n-- {
+				mixed[i] = mixed[i-distance]
+				i++
+			}
+			continue
+		}
+		mixed[i] = byte('a' + rng.Intn(6))
+		i++
+	}
+
+	return map[string][]byte{
+		"empty":   nil,
+		"byte":    []byte("x"),
+		"short":   []byte("abcabcabc"),
+		"zeros":   make([]byte, 200_000),
+		"random":  random,
+		"tunnels": []byte(tunnels.String()),
+		"mixed":   mixed,
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, input := range testInputs() {
+		for _, mode := range []struct {
+			name  string
+			chunk int
+			flush bool
+		}{
+			{"whole", len(input) + 1, false},
+			{"chunks", 10_000, false},
+			{"flushed", 3_000, true},
+		} {
+			t.Run(name+"/"+mode.name, func(t *testing.T) {
+				compressed := compress(t, input, mode.chunk, mode.flush)
+				if got := decompress(t, compressed, false); !bytes.Equal(got, input) {
+					t.Fatalf("Decompressed %d bytes, want %d", len(got), len(input))
+				}
+			})
+		}
+	}
+}
+
+// Streams this encoder writes, each decoded back to its input by the
+// reference decoder when recorded, so the encoder is checked without it.
+// Short ones are spelled out: "byte" and "short" are stored in
+// uncompressed meta-blocks, and a flush adds an empty metadata block (06)
+// before the empty last meta-block (03).
+var knownStreams = []struct {
+	input, mode string
+	stream      string // hex, if short
+	size        int
+	sha256      string
+}{
+	{"empty", "whole", "06", 1, ""},
+	{"byte", "whole", "0000107803", 5, ""},
+	{"byte", "flushed", "000010780603", 6, ""},
+	{"short", "whole", "80001061626361626361626303", 13, ""},
+	{"short", "flushed", "8000106162636162636162630603", 14, ""},
+	{"json", "whole", "70070000d8da4cfd338948aabb059cde69987883593c60030ed8371a3c000f846283b133a4f46054086a0161eadb4c0f8ddf21474b71f589b11b92d06d39e43ae8e60141518f611384655f4098938633e79d03", 83, ""},
+	{"zeros", "whole", "f0ff0f0004403c1650ee3d00fcff030001108705a07b0f80ff7f002000e2b00074ef01f0d3000004401c16803e01c0", 47, ""},
+	{"zeros", "flushed", "", 870, "32e90821d3716017e980e2e57b1a1b94ae0c0fb4fbb5a43c12bf03b31a3a37ac"},
+	{"random", "whole", "", 100_007, "814e530b02af4b759bcb97f1121248ba51dae3de1c6195de13e4b88a902fd001"},
+	{"random", "chunks", "", 100_007, "814e530b02af4b759bcb97f1121248ba51dae3de1c6195de13e4b88a902fd001"},
+	{"random", "flushed", "", 100_137, "8ce1f1ec3cbec52dcf4be29697f18f1f7798ee5ca5fa10a973eb49f9eb9bb5c4"},
+	{"tunnels", "whole", "", 42_960, "9937260dacb54cc90baf0aa1f1602d52d4f18b3836a9d7ff21d1a8e7770ae339"},
+	{"tunnels", "chunks", "", 42_960, "9937260dacb54cc90baf0aa1f1602d52d4f18b3836a9d7ff21d1a8e7770ae339"},
+	{"tunnels", "flushed", "", 45_494, "637ff9fea284447fbe09755d11b58c184a11b45d5ccaf4e1da6fe7a1526484a8"},
+	{"mixed", "whole", "", 8542, "b4671777f79bc34f5b7064bec681c9fdb0d7f1c7ee5ccc63ef051a299d161ee6"},
+	{"mixed", "chunks", "", 8542, "b4671777f79bc34f5b7064bec681c9fdb0d7f1c7ee5ccc63ef051a299d161ee6"},
+	{"mixed", "flushed", "", 10_070, "9094c3419c7b53843d903343e8bef2699b258ac4a958af65b3cedabfa2a25b65"},
+}
+
+func TestKnownStreams(t *testing.T) {
+	inputs := testInputs()
+	inputs["json"] = []byte(`{"id":"db","name":"db","status":{"state":"active"},"hops":[{"host":"bastion","port":22},{"host":"bastion","port":2222}]}`)
+	chunks := map[string]struct {
+		chunk int
+		flush bool
+	}{"whole": {1 << 20, false}, "chunks": {10_000, false}, "flushed": {3_000, true}}
+
+	for _, known := range knownStreams {
+		t.Run(known.input+"/"+known.mode, func(t *testing.T) {
+			mode := chunks[known.mode]
+			compressed := compress(t, inputs[known.input], mode.chunk, mode.flush)
+			sum := sha256.Sum256(compressed)
+			if known.stream != "" && hex.EncodeToString(compressed) != known.stream {
+				t.Errorf("Stream = %x, want %s", compressed, known.stream)
+			}
+			if len(compressed) != known.size || (known.sha256 != "" && hex.EncodeToString(sum[:]) != known.sha256) {
+				t.Errorf("Stream of %d bytes with SHA-256 %x, want %d bytes with %s", len(compressed), sum, known.size, known.sha256)
+			}
+		})
+	}
+}
+
+func TestFlushedOutputDecodes(t *testing.T) {
+	var buf bytes.Buffer
+	z := NewWriter(&buf)
+	z.Write([]byte(`{"type":"tunnel_update","state":"active"}` + "\n"))
+	if err := z.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// What was written so far reaches the client before the stream ends
+	if got := decompress(t, buf.Bytes(), true); string(got) != `{"type":"tunnel_update","state":"active"}`+"\n" {
+		t.Errorf("Flushed output decoded to %q", got)
+	}
+}
+
+func TestCompresses(t *testing.T) {
+	inputs := testInputs()
+
+	if got := compress(t, nil, 1, false); !bytes.Equal(got, []byte{0x06}) {
+		t.Errorf("Empty stream = %x, want 06", got)
+	}
+	// Servers prefer brotli, so it had better come out smaller
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(inputs["tunnels"])
+	gz.Close()
+	if got, want := len(compress(t, inputs["tunnels"], 1<<20, false)), gzipped.Len(); got > want {
+		t.Errorf("JSON compressed to %d bytes, more than the %d of gzip", got, want)
+	}
+	// Incompressible blocks are stored as they are
+	if got, want := len(compress(t, inputs["random"], 1<<20, false)), len(inputs["random"])+16; got > want {
+		t.Errorf("Random input compressed to %d bytes, want at most %d", got, want)
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	z := NewWriter(&bytes.Buffer{})
+	z.Close()
+	if _, err := z.Write([]byte("late")); err == nil {
+		t.Error("Expected an error writing after Close")
+	}
+}
+
+func TestZeroRuns(t *testing.T) {
+	for run := 1; run <= 5000; run++ {
+		symbols, extra := appendZeroRun(nil, nil, run)
+
+		// Decode as RFC 7932 section 3.5 does
+		zeros, repeat := 0, 0
+		for i, symbol := range symbols {
+			switch symbol {
+			case 0:
+				zeros++
+				repeat = 0
+			case repeatZeroCodeLength:
+				previous := repeat
+				if repeat > 0 {
+					repeat = (repeat - 2) << 3
+				}
+				repeat += int(extra[i]) + 3
+				zeros += repeat - previous
+			default:
+				t.Fatalf("Run of %d gave code length symbol %d", run, symbol)
+			}
+		}
+		if zeros != run {
+			t.Fatalf("Run of %d decodes to %d zeros", run, zeros)
+		}
+	}
+}
+
+func TestHuffmanLengths(t *testing.T) {
+	for _, alphabet := range []struct{ size, limit int }{
+		{codeLengthCodes, maxCodeLengthCodeLength},
+		{literalAlphabet, maxCodeLength},
+	} {
+		// Fibonacci counts make the deepest optimal codes
+		counts := make([]uint32, alphabet.size)
+		counts[0], counts[1] = 1, 1
+		for i := 2; i < len(counts); i++ {
+			counts[i] = min(counts[i-1]+counts[i-2], 1<<30)
+		}
+
+		limit := alphabet.limit
+		lengths := make([]uint8, len(counts))
+		huffmanLengths(counts, limit, lengths)
+
+		// Complete: the codes use up all the code space
+		var space uint64
+		for _, length := range lengths {
+			if length == 0 || int(length) > limit {
+				t.Fatalf("Code length %d, want 1 to %d", length, limit)
+			}
+			space += 1 << (limit - int(length))
+		}
+		if space != 1<<limit {
+			t.Errorf("Codes of at most %d bits use %d of %d code space", limit, space, 1<<limit)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package brotli
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Prefix codes as described in RFC 7932 section 3: canonical Huffman codes
+// sent as their code lengths, themselves prefix coded
+
+const (
+	maxCodeLength           = 15 // longest code of a symbol
+	maxCodeLengthCodeLength = 5  // longest code of a code length
+	repeatZeroCodeLength    = 17 // code length symbol repeating zero lengths
+	codeLengthCodes         = 18 // code length symbols 0 to 17
+)
+
+// codeLengthCodeOrder is the order code length code lengths are sent in
+var codeLengthCodeOrder = [codeLengthCodes]int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// codeLengthCodeLengthCodes is the fixed code of the code length code
+// lengths 0 to 5, as value and bit count
+var codeLengthCodeLengthCodes = [6][2]uint8{{0, 2}, {7, 4}, {3, 3}, {2, 2}, {1, 2}, {15, 4}}
+
+// prefixCode is a prefix code over an alphabet, ready to write symbols with
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16 // bit-reversed, to be written least significant bit first
+}
+
+// newPrefixCode builds a code for symbols with the given counts, no code
+// longer than limit bits. Symbols that never occur get no code. A single
+// symbol gets a zero-length code, as decoders expect of one-symbol codes.
+func newPrefixCode(counts []uint32, limit int) *prefixCode {
+	c := &prefixCode{lengths: make([]uint8, len(counts)), codes: make([]uint16, len(counts))}
+	if used := usedSymbols(counts); len(used) > 1 {
+		huffmanLengths(counts, limit, c.lengths)
+		c.assignCodes()
+	}
+	return c
+}
+
+// usedSymbols returns the symbols with a non-zero count
+func usedSymbols(counts []uint32) []int {
+	var used []int
+	for symbol, count := range counts {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	return used
+}
+
+// huffmanLengths sets the code lengths of an optimal prefix code for the
+// counts, at most limit bits long, for at least two used symbols. When the
+// optimal code is too deep, rare symbols are counted as more common until
+// it isn't, which keeps the code complete.
+func huffmanLengths(counts []uint32, limit int, lengths []uint8) {
+	type node struct {
+		count       uint32
+		left, right int // children, -1 for leaves
+		symbol      int
+	}
+	for minCount := uint32(1); ; minCount *= 2 {
+		var leaves []node
+		for symbol, count := range counts {
+			if count > 0 {
+				leaves = append(leaves, node{count: max(count, minCount), left: -1, right: -1, symbol: symbol})
+			}
+		}
+		sort.SliceStable(leaves, func(i, j int) bool { return leaves[i].count < leaves[j].count })
+
+		// Two queues: the sorted leaves and the internal nodes, which are
+		// created in increasing count order
+		nodes := append(leaves[:len(leaves):len(leaves)], make([]node, 0, len(leaves)-1)...)
+		nextLeaf, nextInternal := 0, len(leaves)
+		smallest := func() int {
+			if nextLeaf < len(leaves) && (nextInternal >= len(nodes) || nodes[nextLeaf].count <= nodes[nextInternal].count) {
+				nextLeaf++
+				return nextLeaf - 1
+			}
+			nextInternal++
+			return nextInternal - 1
+		}
+		for range len(leaves) - 1 {
+			a, b := smallest(), smallest()
+			nodes = append(nodes, node{count: nodes[a].count + nodes[b].count, left: a, right: b})
+		}
+
+		deepest := 0
+		var walk func(i, depth int)
+		walk = func(i, depth int) {
+			if nodes[i].left < 0 {
+				lengths[nodes[i].symbol] = uint8(depth)
+				deepest = max(deepest, depth)
+				return
+			}
+			walk(nodes[i].left, depth+1)
+			walk(nodes[i].right, depth+1)
+		}
+		walk(len(nodes)-1, 0)
+		if deepest <= limit {
+			return
+		}
+	}
+}
+
+// assignCodes gives the symbols canonical codes for their lengths: shorter
+// codes first, then in symbol order
+func (c *prefixCode) assignCodes() {
+	var lengthCount [maxCodeLength + 1]uint16
+	for _, length := range c.lengths {
+		lengthCount[length]++
+	}
+	lengthCount[0] = 0
+
+	var next [maxCodeLength + 1]uint16
+	code := uint16(0)
+	for length := 1; length <= maxCodeLength; length++ {
+		code = (code + lengthCount[length-1]) << 1
+		next[length] = code
+	}
+	for symbol, length := range c.lengths {
+		if length == 0 {
+			continue
+		}
+		c.codes[symbol] = bits.Reverse16(next[length]) >> (16 - length)
+		next[length]++
+	}
+}
+
+// writeSymbol writes a symbol with the code
+func (c *prefixCode) writeSymbol(b *bitWriter, symbol int) {
+	b.writeBits(uint(c.lengths[symbol]), uint64(c.codes[symbol]))
+}
+
+// write sends the code: the symbol of a one-symbol code as a simple prefix
+// code, otherwise the code lengths as a complex prefix code
+func (c *prefixCode) write(b *bitWriter, counts []uint32, alphabetBits uint) {
+	used := usedSymbols(counts)
+	if len(used) <= 1 {
+		symbol := 0
+		if len(used) == 1 {
+			symbol = used[0]
+		}
+		b.writeBits(2, 1) // simple prefix code
+		b.writeBits(2, 0) // of one symbol
+		b.writeBits(alphabetBits, uint64(symbol))
+		return
+	}
+
+	// The code lengths up to the last used symbol, runs of zeros shortened
+	var symbols, extra []uint8
+	last := used[len(used)-1]
+	for i := 0; i <= last; {
+		if c.lengths[i] != 0 {
+			symbols = append(symbols, c.lengths[i])
+			extra = append(extra, 0)
+			i++
+			continue
+		}
+		run := 1
+		for c.lengths[i+run] == 0 {
+			run++
+		}
+		symbols, extra = appendZeroRun(symbols, extra, run)
+		i += run
+	}
+
+	var lengthCounts [codeLengthCodes]uint32
+	for _, symbol := range symbols {
+		lengthCounts[symbol]++
+	}
+	lengthCode := newPrefixCode(lengthCounts[:], maxCodeLengthCodeLength)
+	single := len(usedSymbols(lengthCounts[:])) == 1
+	if single {
+		// Decoders read a one-symbol code length code with zero-bit codes
+		lengthCode.lengths[symbols[0]] = 1
+	}
+
+	// Code lengths sent in order up to the last non-zero one, where the
+	// code is complete and decoders stop reading; a one-symbol code is
+	// never complete, so all are sent
+	sent := codeLengthCodes
+	if !single {
+		for sent > 0 && lengthCode.lengths[codeLengthCodeOrder[sent-1]] == 0 {
+			sent--
+		}
+	}
+	skip := 0
+	if lengthCode.lengths[codeLengthCodeOrder[0]] == 0 && lengthCode.lengths[codeLengthCodeOrder[1]] == 0 {
+		skip = 2
+		if lengthCode.lengths[codeLengthCodeOrder[2]] == 0 {
+			skip = 3
+		}
+	}
+	b.writeBits(2, uint64(skip))
+	for _, symbol := range codeLengthCodeOrder[skip:sent] {
+		code := codeLengthCodeLengthCodes[lengthCode.lengths[symbol]]
+		b.writeBits(uint(code[1]), uint64(code[0]))
+	}
+	if single {
+		lengthCode.lengths[symbols[0]] = 0
+	}
+
+	for i, symbol := range symbols {
+		lengthCode.writeSymbol(b, int(symbol))
+		if symbol == repeatZeroCodeLength {
+			b.writeBits(3, uint64(extra[i]))
+		}
+	}
+}
+
+// appendZeroRun appends code length symbols for a run of zero code lengths.
+// Consecutive repeat codes multiply: each one after the first takes the
+// run so far, less two, times eight plus its own three to ten.
+func appendZeroRun(symbols, extra []uint8, run int) ([]uint8, []uint8) {
+	if run == 11 {
+		symbols, extra = append(symbols, 0), append(extra, 0)
+		run--
+	}
+	if run < 3 {
+		for range run {
+			symbols, extra = append(symbols, 0), append(extra, 0)
+		}
+		return symbols, extra
+	}
+
+	start := len(symbols)
+	run -= 3
+	for {
+		symbols, extra = append(symbols, repeatZeroCodeLength), append(extra, uint8(run&7))
+		run >>= 3
+		if run == 0 {
+			break
+		}
+		run--
+	}
+	// Worked out least significant part first, sent most significant first
+	for i, j := start, len(symbols)-1; i < j; i, j = i+1, j-1 {
+		extra[i], extra[j] = extra[j], extra[i]
+	}
+	return symbols, extra
+}
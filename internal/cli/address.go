@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	addressPort        int
+	addressUser        string
+	addressAuth        string
+	addressKey         string
+	addressDescription string
+)
+
+var addressCmd = &cobra.Command{
+	Use:   "address",
+	Short: "Manage the server's address book",
+	Long: `Manage the address book: named hosts, and how to log in to them, that tunnels
+reference as @name instead of repeating them. Hops take the entry's host, and
+the port, user and credentials they leave unset; destinations take its host.
+Changing an entry moves every tunnel using it on its next connection.
+
+Examples:
+  tunnelctl address set prod-bastion 10.0.0.1 --user deploy --key ~/.ssh/id_ed25519
+  tunnelctl create --name db --type local --local-port 5432 \
+    --remote-host db.internal:5432 --hop @prod-bastion
+  tunnelctl address set prod-bastion 10.0.0.2 --user deploy --key ~/.ssh/id_ed25519
+  tunnelctl address list`,
+}
+
+var addressListCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "List address book entries",
+	Args:         cobra.NoArgs,
+	RunE:         runAddressList,
+	SilenceUsage: true,
+}
+
+var addressSetCmd = &cobra.Command{
+	Use:          "set [name] [host]",
+	Short:        "Add an address book entry, or replace it",
+	Args:         cobra.ExactArgs(2),
+	RunE:         runAddressSet,
+	SilenceUsage: true,
+}
+
+var addressRemoveCmd = &cobra.Command{
+	Use:          "rm [name]",
+	Short:        "Remove an address book entry no tunnel uses",
+	Args:         cobra.ExactArgs(1),
+	RunE:         runAddressRemove,
+	SilenceUsage: true,
+}
+
+func init() {
+	addressSetCmd.Flags().IntVar(&addressPort, "port", 0, "SSH port of hops using the entry (default 22)")
+	addressSetCmd.Flags().StringVar(&addressUser, "user", "", "SSH user of hops using the entry")
+	addressSetCmd.Flags().StringVar(&addressAuth, "auth", "", "auth method of hops using the entry (key, agent, ...); key if --key is given")
+	addressSetCmd.Flags().StringVar(&addressKey, "key", "", "private key path, or managed://<id>, of hops using the entry")
+	addressSetCmd.Flags().StringVar(&addressDescription, "description", "", "what the host is")
+
+	addressCmd.AddCommand(addressListCmd)
+	addressCmd.AddCommand(addressSetCmd)
+	addressCmd.AddCommand(addressRemoveCmd)
+}
+
+// addressEntry holds the fields of an address book entry the CLI shows
+type addressEntry struct {
+	Name       string   `json:"name"`
+	Host       string   `json:"host"`
+	Port       int      `json:"port"`
+	User       string   `json:"user"`
+	AuthMethod string   `json:"auth_method"`
+	UsedBy     []string `json:"usedBy"`
+}
+
+func runAddressList(cmd *cobra.Command, args []string) error {
+	resp, err := http.Get(viper.GetString("server") + "/api/v1/addresses")
+	if err != nil {
+		return fmt.Errorf("failed to list address book: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to list address book: %s", string(body))
+	}
+	var entries []addressEntry
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(entries) == 0 {
+		fmt.Println("No address book entries")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tHOST\tPORT\tUSER\tAUTH\tTUNNELS")
+	for _, e := range entries {
+		port := "-"
+		if e.Port != 0 {
+			port = fmt.Sprint(e.Port)
+		}
+		fmt.Fprintf(w, "@%s\t%s\t%s\t%s\t%s\t%d\n", e.Name, e.Host, port, e.User, e.AuthMethod, len(e.UsedBy))
+	}
+	return w.Flush()
+}
+
+func runAddressSet(cmd *cobra.Command, args []string) error {
+	serverURL := viper.GetString("server")
+	name, host := args[0], args[1]
+
+	auth := addressAuth
+	if auth == "" && addressKey != "" {
+		auth = "key"
+	}
+	entry := map[string]interface{}{
+		"host":        host,
+		"port":        addressPort,
+		"user":        addressUser,
+		"auth_method": auth,
+		"key_id":      addressKey,
+		"description": addressDescription,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode entry: %w", err)
+	}
+
+	// Replace the entry, or create it if there's none
+	req, err := http.NewRequest(http.MethodPut, serverURL+"/api/v1/addresses/"+url.PathEscape(name), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to save address book entry: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode == http.StatusNotFound {
+		entry["name"] = name
+		if data, err = json.Marshal(entry); err != nil {
+			return fmt.Errorf("failed to encode entry: %w", err)
+		}
+		created, err := http.Post(serverURL+"/api/v1/addresses", "application/json", bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to save address book entry: %w", err)
+		}
+		defer created.Body.Close()
+		body, _ = io.ReadAll(created.Body)
+		if created.StatusCode != http.StatusCreated {
+			return fmt.Errorf("failed to save address book entry: %s", string(body))
+		}
+		fmt.Printf("✓ Added @%s → %s\n", name, host)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to save address book entry: %s", string(body))
+	}
+
+	var updated addressEntry
+	if err := json.Unmarshal(body, &updated); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	fmt.Printf("✓ Updated @%s → %s; %d tunnel(s) use it from their next connection\n", name, host, len(updated.UsedBy))
+	return nil
+}
+
+func runAddressRemove(cmd *cobra.Command, args []string) error {
+	req, err := http.NewRequest(http.MethodDelete, viper.GetString("server")+"/api/v1/addresses/"+url.PathEscape(args[0]), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to remove address book entry: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to remove address book entry: %s", string(body))
+	}
+	fmt.Printf("✓ Removed @%s\n", args[0])
+	return nil
+}
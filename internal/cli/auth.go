@@ -0,0 +1,351 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+var (
+	loginUsername      string
+	loginPasswordStdin bool
+	passphraseDelete   bool
+)
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Manage the CLI's credentials for the lazytunnel server",
+	Long: `Log in to the lazytunnel server and keep the API token in the operating
+system's credential store (macOS Keychain, the Secret Service keyring via
+secret-tool on Linux, or Windows Credential Manager), so it never sits in
+the config file. A token set in ~/.tunnelctl.yaml still takes precedence;
+set credential_store: none there to keep the credential store out of it.`,
+}
+
+var authLoginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in and save the API token",
+	Long: `Log in to the lazytunnel server and save the API token in the
+operating system's credential store. The password is prompted for unless
+--password-stdin is given.
+
+Examples:
+  tunnelctl auth login --username admin
+  echo "$PASSWORD" | tunnelctl auth login --username admin --password-stdin`,
+	Args:         cobra.NoArgs,
+	RunE:         runAuthLogin,
+	SilenceUsage: true,
+}
+
+var authLogoutCmd = &cobra.Command{
+	Use:          "logout",
+	Short:        "Remove the saved API token",
+	Args:         cobra.NoArgs,
+	RunE:         runAuthLogout,
+	SilenceUsage: true,
+}
+
+var authStatusCmd = &cobra.Command{
+	Use:          "status",
+	Short:        "Show where the API token comes from and whether the server accepts it",
+	Args:         cobra.NoArgs,
+	RunE:         runAuthStatus,
+	SilenceUsage: true,
+}
+
+var authPassphraseCmd = &cobra.Command{
+	Use:   "passphrase <key-file>",
+	Short: "Save the passphrase of an SSH private key",
+	Long: `Save the passphrase of an encrypted SSH private key in the credential
+store, so commands that connect on their own (tunnelctl stdio --hop) can use
+the key without asking. The passphrase is checked against the key first.`,
+	Args:         cobra.ExactArgs(1),
+	RunE:         runAuthPassphrase,
+	SilenceUsage: true,
+}
+
+func init() {
+	authLoginCmd.Flags().StringVarP(&loginUsername, "username", "u", "", "username (prompted for if omitted)")
+	authLoginCmd.Flags().BoolVar(&loginPasswordStdin, "password-stdin", false, "read the password from stdin")
+
+	authPassphraseCmd.Flags().BoolVar(&passphraseDelete, "delete", false, "remove the saved passphrase instead")
+
+	authCmd.AddCommand(authLoginCmd)
+	authCmd.AddCommand(authLogoutCmd)
+	authCmd.AddCommand(authStatusCmd)
+	authCmd.AddCommand(authPassphraseCmd)
+}
+
+func runAuthLogin(cmd *cobra.Command, args []string) error {
+	serverURL := viper.GetString("server")
+	stdin := bufio.NewReader(os.Stdin)
+
+	username := loginUsername
+	if username == "" {
+		fmt.Fprint(os.Stderr, "Username: ")
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read username: %w", err)
+		}
+		username = strings.TrimSpace(line)
+	}
+
+	password, err := readSecret(stdin, "Password", loginPasswordStdin)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	resp, err := http.Post(serverURL+"/api/v1/auth/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("login failed: %s", string(respBody))
+	}
+
+	var result struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil || result.Token == "" {
+		return fmt.Errorf("failed to parse response: %s", string(respBody))
+	}
+
+	store := osCredentialStore()
+	if store == nil {
+		fmt.Printf("✓ Logged in to %s as %s\n", serverURL, username)
+		fmt.Println("No credential store is available (on Linux, install secret-tool); add the token to ~/.tunnelctl.yaml:")
+		fmt.Printf("  token: %s\n", result.Token)
+		return nil
+	}
+
+	if err := store.Set(tokenKey(serverURL), result.Token); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Logged in to %s as %s (token saved in %s)\n", serverURL, username, store.Name())
+	return nil
+}
+
+// readSecret reads a secret from stdin if fromStdin, as with
+// --password-stdin, or prompts for it without echo
+func readSecret(stdin *bufio.Reader, label string, fromStdin bool) (string, error) {
+	if fromStdin {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", strings.ToLower(label), err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("stdin is not a terminal; pass the %s with --password-stdin", strings.ToLower(label))
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", strings.ToLower(label), err)
+	}
+	return string(secret), nil
+}
+
+func runAuthLogout(cmd *cobra.Command, args []string) error {
+	serverURL := viper.GetString("server")
+
+	store := osCredentialStore()
+	if store == nil {
+		return fmt.Errorf("no credential store is supported on this system; remove the token from ~/.tunnelctl.yaml")
+	}
+
+	err := store.Delete(tokenKey(serverURL))
+	if errors.Is(err, errCredentialNotFound) {
+		fmt.Printf("Not logged in to %s\n", serverURL)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Logged out of %s\n", serverURL)
+	return nil
+}
+
+func runAuthStatus(cmd *cobra.Command, args []string) error {
+	serverURL := viper.GetString("server")
+	store := osCredentialStore()
+
+	fmt.Printf("Server: %s\n", serverURL)
+	if store != nil {
+		fmt.Printf("Credential store: %s\n", store.Name())
+	} else {
+		fmt.Println("Credential store: none")
+	}
+
+	token, source := viper.GetString("token"), "config file"
+	if token == "" && store != nil {
+		saved, err := store.Get(tokenKey(serverURL))
+		if err != nil && !errors.Is(err, errCredentialNotFound) {
+			return err
+		}
+		token, source = saved, store.Name()
+	}
+	if token == "" {
+		fmt.Println("Token: not set (run tunnelctl auth login)")
+		return nil
+	}
+	fmt.Printf("Token: from %s\n", source)
+
+	if claims, ok := decodeTokenClaims(token); ok {
+		fmt.Printf("User: %s (roles: %s)\n", claims.Username, strings.Join(claims.Roles, ", "))
+		if claims.ExpiresAt > 0 {
+			expires := time.Unix(claims.ExpiresAt, 0)
+			if time.Now().After(expires) {
+				fmt.Printf("Expires: %s (expired)\n", expires.Format(time.RFC3339))
+			} else {
+				fmt.Printf("Expires: %s (in %s)\n", expires.Format(time.RFC3339), time.Until(expires).Round(time.Minute))
+			}
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, serverURL+"/api/v1/tunnels", nil)
+	if err != nil {
+		return fmt.Errorf("invalid server address %s: %w", serverURL, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Printf("Server accepts token: unknown (%v)\n", err)
+		return nil
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		fmt.Println("Server accepts token: yes")
+	case http.StatusUnauthorized:
+		fmt.Println("Server accepts token: no (run tunnelctl auth login)")
+	default:
+		fmt.Printf("Server accepts token: unknown (HTTP %d)\n", resp.StatusCode)
+	}
+	return nil
+}
+
+// tokenClaims are the JWT claims shown by "auth status"
+type tokenClaims struct {
+	Username  string   `json:"username"`
+	Roles     []string `json:"roles"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// decodeTokenClaims reads a JWT's claims without verifying it; only the
+// server can do that
+func decodeTokenClaims(token string) (*tokenClaims, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, false
+	}
+
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, false
+	}
+	return &claims, true
+}
+
+func runAuthPassphrase(cmd *cobra.Command, args []string) error {
+	keyPath, err := tunnel.ExpandPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	store := osCredentialStore()
+	if store == nil {
+		return fmt.Errorf("no credential store is available to keep the passphrase in")
+	}
+
+	if passphraseDelete {
+		err := store.Delete(passphraseKey(keyPath))
+		if errors.Is(err, errCredentialNotFound) {
+			fmt.Printf("No passphrase saved for %s\n", keyPath)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ Removed the passphrase for %s\n", keyPath)
+		return nil
+	}
+
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", keyPath, err)
+	}
+	var missing *ssh.PassphraseMissingError
+	if _, err := ssh.ParsePrivateKey(key); !errors.As(err, &missing) {
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", keyPath, err)
+		}
+		return fmt.Errorf("%s is not passphrase protected", keyPath)
+	}
+
+	passphrase, err := readSecret(bufio.NewReader(os.Stdin), "Passphrase", false)
+	if err != nil {
+		return err
+	}
+	if _, err := ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase)); err != nil {
+		return fmt.Errorf("the passphrase doesn't unlock %s: %w", keyPath, err)
+	}
+
+	if err := store.Set(passphraseKey(keyPath), passphrase); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Saved the passphrase for %s in %s\n", keyPath, store.Name())
+	return nil
+}
+
+// storedPassphrase unlocks a private key with the passphrase saved by
+// "auth passphrase"
+func storedPassphrase(keyPath string) ([]byte, error) {
+	store := osCredentialStore()
+	if store == nil {
+		return nil, fmt.Errorf("no credential store is available")
+	}
+
+	passphrase, err := store.Get(passphraseKey(keyPath))
+	if errors.Is(err, errCredentialNotFound) {
+		return nil, fmt.Errorf("none saved; run tunnelctl auth passphrase %s", keyPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(passphrase), nil
+}
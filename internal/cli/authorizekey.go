@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	authorizeTunnel        string
+	authorizeHop           int
+	authorizePermitOpen    []string
+	authorizePermitListen  []string
+	authorizeFrom          []string
+	authorizeInstall       bool
+	authorizeHost          string
+	authorizePort          int
+	authorizeUser          string
+	authorizePassword      bool
+	authorizePasswordStdin bool
+)
+
+var authorizeKeyCmd = &cobra.Command{
+	Use:   "authorize-key [key-id]",
+	Short: "Print or install a managed key's authorized_keys line",
+	Long: `Print the authorized_keys line for a key managed by the server, restricted to
+port forwarding: no terminal, agent or X11 forwarding. With --tunnel, it may
+only forward to where the tunnel goes through the hop using the key.
+
+With --install, the server adds the line to the authorized_keys of the
+tunnel's hop, or of --host, over SSH, logging in with --password if given.
+
+Examples:
+  tunnelctl authorize-key 3f2a... --tunnel db >> ~/.ssh/authorized_keys
+  tunnelctl authorize-key 3f2a... --tunnel db --install --password`,
+	Args:         cobra.ExactArgs(1),
+	RunE:         runAuthorizeKey,
+	SilenceUsage: true,
+}
+
+func init() {
+	authorizeKeyCmd.Flags().StringVar(&authorizeTunnel, "tunnel", "", "restrict the key to this tunnel's destinations (ID or name)")
+	authorizeKeyCmd.Flags().IntVar(&authorizeHop, "hop", -1, "the tunnel's hop to restrict the key for (default: the first using it)")
+	authorizeKeyCmd.Flags().StringSliceVar(&authorizePermitOpen, "permit-open", nil, "also allow forwarding to these host:port destinations")
+	authorizeKeyCmd.Flags().StringSliceVar(&authorizePermitListen, "permit-listen", nil, "also allow remote forwards listening on these [host:]ports")
+	authorizeKeyCmd.Flags().StringSliceVar(&authorizeFrom, "from", nil, "only accept the key from these address patterns")
+	authorizeKeyCmd.Flags().BoolVar(&authorizeInstall, "install", false, "have the server install the line over SSH")
+	authorizeKeyCmd.Flags().StringVar(&authorizeHost, "host", "", "host to install on instead of the tunnel's hop")
+	authorizeKeyCmd.Flags().IntVar(&authorizePort, "port", 0, "SSH port of --host (default 22)")
+	authorizeKeyCmd.Flags().StringVar(&authorizeUser, "user", "", "user to install for instead of the hop's")
+	authorizeKeyCmd.Flags().BoolVar(&authorizePassword, "password", false, "log in to install with a password, prompted for")
+	authorizeKeyCmd.Flags().BoolVar(&authorizePasswordStdin, "password-stdin", false, "log in to install with a password read from stdin")
+}
+
+func runAuthorizeKey(cmd *cobra.Command, args []string) error {
+	serverURL := viper.GetString("server")
+	keyID := url.PathEscape(args[0])
+
+	tunnelID := ""
+	if authorizeTunnel != "" {
+		t, err := fetchTunnel(serverURL, authorizeTunnel)
+		if err != nil {
+			return err
+		}
+		tunnelID = t.ID
+	}
+
+	if !authorizeInstall {
+		query := url.Values{"permitOpen": authorizePermitOpen, "permitListen": authorizePermitListen, "from": authorizeFrom}
+		if tunnelID != "" {
+			query.Set("tunnel", tunnelID)
+		}
+		if authorizeHop >= 0 {
+			query.Set("hop", strconv.Itoa(authorizeHop))
+		}
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/keys/%s/authorized_keys?%s", serverURL, keyID, query.Encode()))
+		if err != nil {
+			return fmt.Errorf("failed to get authorized_keys line: %w", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to get authorized_keys line: %s", string(body))
+		}
+		fmt.Print(string(body))
+		return nil
+	}
+
+	request := map[string]interface{}{
+		"tunnelId":     tunnelID,
+		"host":         authorizeHost,
+		"port":         authorizePort,
+		"user":         authorizeUser,
+		"permitOpen":   authorizePermitOpen,
+		"permitListen": authorizePermitListen,
+		"from":         authorizeFrom,
+	}
+	if authorizeHop >= 0 {
+		request["hop"] = authorizeHop
+	}
+	if authorizePassword || authorizePasswordStdin {
+		password, err := readSecret(bufio.NewReader(os.Stdin), "Password", authorizePasswordStdin)
+		if err != nil {
+			return err
+		}
+		request["password"] = password
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	resp, err := http.Post(fmt.Sprintf("%s/api/v1/keys/%s/install", serverURL, keyID), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to install key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to install key: %s", string(body))
+	}
+	var result struct {
+		Host  string `json:"host"`
+		User  string `json:"user"`
+		Added bool   `json:"added"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if result.Added {
+		fmt.Printf("✓ Key installed for %s@%s\n", result.User, result.Host)
+	} else {
+		fmt.Printf("✓ Key already authorized for %s@%s\n", result.User, result.Host)
+	}
+	return nil
+}
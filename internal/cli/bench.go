@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	benchHop     int
+	benchSize    int
+	benchSamples int
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench <tunnel>",
+	Short: "Measure latency and throughput through a tunnel",
+	Long: `Measure a tunnel's SSH connection: the round-trip time of SSH requests, the
+time to open a connection to the tunnel's destination, and the throughput of
+streaming data to and from the hop. The tunnel must be connected, and the hop
+needs the cat and head commands.
+
+Compare the throughput with the same transfer over plain ssh, e.g.
+  head -c 16M /dev/zero | ssh bastion 'cat > /dev/null'
+to see what the tunnel costs, and raise the tunnel's tcp.sshBuffer on links
+with a high bandwidth-delay product.
+
+Examples:
+  tunnelctl bench prod-db
+  tunnelctl bench prod-db --hop 0 --size 64`,
+	Args:         cobra.ExactArgs(1),
+	RunE:         runBench,
+	SilenceUsage: true,
+}
+
+func init() {
+	benchCmd.Flags().IntVar(&benchHop, "hop", -1, "hop to measure, counting from 0 (default: last hop)")
+	benchCmd.Flags().IntVar(&benchSize, "size", 16, "MiB to transfer each way")
+	benchCmd.Flags().IntVar(&benchSamples, "samples", 10, "round trips to time")
+}
+
+// benchResult is the server's benchmark response
+type benchResult struct {
+	Bytes          int64    `json:"bytes"`
+	Samples        int      `json:"samples"`
+	LatencyMin     float64  `json:"latencyMin"`
+	LatencyAvg     float64  `json:"latencyAvg"`
+	LatencyMax     float64  `json:"latencyMax"`
+	ConnectLatency *float64 `json:"connectLatency"`
+	ConnectError   string   `json:"connectError"`
+	Upload         float64  `json:"upload"`
+	Download       float64  `json:"download"`
+}
+
+func runBench(cmd *cobra.Command, args []string) error {
+	serverURL := viper.GetString("server")
+	t, err := fetchTunnel(serverURL, args[0])
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"bytes":   int64(benchSize) << 20,
+		"samples": benchSamples,
+	}
+	if benchHop >= 0 {
+		payload["hop"] = benchHop
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s/api/v1/tunnels/%s/bench", serverURL, url.PathEscape(t.ID)), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	hop := "last hop"
+	if benchHop >= 0 {
+		hop = fmt.Sprintf("hop %d", benchHop)
+	}
+	fmt.Printf("Benchmarking %s (%s, %d MiB each way)...\n", t.Name, hop, benchSize)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to run benchmark: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to run benchmark: %s", string(respBody))
+	}
+	var result benchResult
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	fmt.Printf("  Latency:  min %.1f ms, avg %.1f ms, max %.1f ms (%d samples)\n",
+		result.LatencyMin, result.LatencyAvg, result.LatencyMax, result.Samples)
+	switch {
+	case result.ConnectLatency != nil:
+		fmt.Printf("  Connect:  %.1f ms to %s:%d\n", *result.ConnectLatency, t.RemoteHost, t.RemotePort)
+	case result.ConnectError != "":
+		fmt.Printf("  Connect:  failed: %s\n", result.ConnectError)
+	}
+	fmt.Printf("  Upload:   %s/s (%.0f Mbit/s)\n", formatBytes(result.Upload), result.Upload*8/1e6)
+	fmt.Printf("  Download: %s/s (%.0f Mbit/s)\n", formatBytes(result.Download), result.Download*8/1e6)
+	return nil
+}
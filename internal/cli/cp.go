@@ -0,0 +1,189 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	cpHop  int
+	cpMode string
+)
+
+var cpCmd = &cobra.Command{
+	Use:   "cp <source> <destination>",
+	Short: "Copy a file to or from a hop over a tunnel's SSH connection",
+	Long: `Copy a single file to or from a hop through a tunnel's existing SSH
+connection, without opening another one. One side is a local path and the
+other is <tunnel>:<absolute path>, where <tunnel> is a tunnel ID or name.
+
+The file is transferred with scp on the hop, which must be installed there.
+The tunnel must be connected. Files go to the last hop unless --hop is set.
+
+Examples:
+  # Drop a config file on the bastion
+  tunnelctl cp ./app.yaml prod-db:/etc/app/app.yaml --mode 0600
+
+  # Fetch a log file from the first hop of a multi-hop tunnel
+  tunnelctl cp --hop 0 prod-db:/var/log/auth.log .`,
+	Args:         cobra.ExactArgs(2),
+	RunE:         runCp,
+	SilenceUsage: true,
+}
+
+func init() {
+	cpCmd.Flags().IntVar(&cpHop, "hop", -1, "hop to copy to or from, counting from 0 (default: last hop)")
+	cpCmd.Flags().StringVar(&cpMode, "mode", "", "permissions of an uploaded file in octal (default: the local file's)")
+}
+
+// splitRemote splits "<tunnel>:<path>" into its parts. Local paths such as
+// ./a:b or C:\file are not treated as remote.
+func splitRemote(arg string) (string, string, bool) {
+	tunnelRef, remotePath, found := strings.Cut(arg, ":")
+	if !found || tunnelRef == "" || len(tunnelRef) == 1 || strings.ContainsAny(tunnelRef, `/\`) {
+		return "", "", false
+	}
+	return tunnelRef, remotePath, true
+}
+
+func runCp(cmd *cobra.Command, args []string) error {
+	srcTunnel, srcPath, srcRemote := splitRemote(args[0])
+	dstTunnel, dstPath, dstRemote := splitRemote(args[1])
+
+	switch {
+	case srcRemote && dstRemote:
+		return fmt.Errorf("copying between two remote paths is not supported")
+	case dstRemote:
+		return uploadFile(args[0], dstTunnel, dstPath)
+	case srcRemote:
+		return downloadFile(srcTunnel, srcPath, args[1])
+	default:
+		return fmt.Errorf("one of source or destination must be <tunnel>:<path>")
+	}
+}
+
+// filesURL returns the file transfer endpoint of a tunnel
+func filesURL(serverURL, tunnelID, remotePath string) string {
+	query := url.Values{"path": {remotePath}}
+	if cpHop >= 0 {
+		query.Set("hop", strconv.Itoa(cpHop))
+	}
+	return fmt.Sprintf("%s/api/v1/tunnels/%s/files?%s", serverURL, url.PathEscape(tunnelID), query.Encode())
+}
+
+func uploadFile(localPath, tunnelRef, remotePath string) error {
+	serverURL := viper.GetString("server")
+	t, err := fetchTunnel(serverURL, tunnelRef)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", localPath, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory; only single files can be copied", localPath)
+	}
+
+	// Copying into a directory keeps the local file name
+	if strings.HasSuffix(remotePath, "/") {
+		remotePath += filepath.Base(localPath)
+	}
+
+	mode := cpMode
+	if mode == "" {
+		mode = fmt.Sprintf("%04o", info.Mode().Perm())
+	}
+
+	req, err := http.NewRequest(http.MethodPut, filesURL(serverURL, t.ID, remotePath)+"&mode="+url.QueryEscape(mode), file)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to upload file: %s", string(body))
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	fmt.Printf("✓ Uploaded %s → %s:%s (%v, mode %v)\n", localPath, t.Name, remotePath, formatBytes(result["size"]), result["mode"])
+	return nil
+}
+
+func downloadFile(tunnelRef, remotePath, localPath string) error {
+	serverURL := viper.GetString("server")
+	t, err := fetchTunnel(serverURL, tunnelRef)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Get(filesURL(serverURL, t.ID, remotePath))
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to download file: %s", string(body))
+	}
+
+	// Copying into a directory keeps the remote file name
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		localPath = filepath.Join(localPath, path.Base(remotePath))
+	}
+
+	mode := os.FileMode(0644)
+	if n, err := strconv.ParseUint(resp.Header.Get("X-File-Mode"), 8, 32); err == nil {
+		mode = os.FileMode(n).Perm()
+	}
+
+	file, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", localPath, err)
+	}
+
+	n, err := io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", localPath, err)
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("download of %s was cut short (%d of %d bytes)", remotePath, n, resp.ContentLength)
+	}
+
+	fmt.Printf("✓ Downloaded %s:%s → %s (%s)\n", t.Name, remotePath, localPath, formatBytes(float64(n)))
+	return nil
+}
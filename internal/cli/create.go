@@ -6,13 +6,18 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/craigderington/lazytunnel/internal/interpolate"
 	"github.com/craigderington/lazytunnel/pkg/types"
 )
 
@@ -28,6 +33,13 @@ var (
 	autoReconnect bool
 	keepAlive     int
 	maxRetries    int
+	routes        []string
+	protocol      string
+	forwardAgent  []string
+	interactive   []string
+	systemProxy   bool
+	pacDomains    []string
+	pacCIDRs      []string
 )
 
 var createCmd = &cobra.Command{
@@ -39,6 +51,7 @@ Tunnel types:
   - local:   Local port forwarding (bind local port → forward to remote)
   - remote:  Remote port forwarding (bind remote port → forward to local)
   - dynamic: SOCKS5 proxy (dynamic destinations)
+  - transparent: Route whole CIDRs through SSH (Linux, requires root)
 
 Examples:
   # Create local tunnel through bastion
@@ -50,31 +63,91 @@ Examples:
   tunnelctl create --name socks --type dynamic \
     --local-port 1080 --hop jumphost:22 --user admin --key ~/.ssh/id_rsa
 
+  # Proxy only internal sites: point the browser at the printed PAC URL
+  tunnelctl create --name intranet --type dynamic --hop bastion.example.com:22 \
+    --pac-domain corp.example.com --pac-cidr 10.0.0.0/8
+
+  # Route your browser through the bastion: the SOCKS5 proxy becomes the
+  # system proxy while the tunnel runs (on the server's machine, admin only)
+  tunnelctl create --name browse --type dynamic --system-proxy \
+    --hop bastion.example.com:22 --user admin --key ~/.ssh/id_rsa
+
   # Create remote tunnel
   tunnelctl create --name expose-local --type remote \
     --local-port 8080 --remote-port 9090 \
-    --hop server.example.com:22 --user deploy --key ~/.ssh/id_rsa`,
+    --hop server.example.com:22 --user deploy --key ~/.ssh/id_rsa
+
+  # Forward DNS over UDP (requires tunnelctl on the last hop)
+  tunnelctl create --name dns --type local --protocol udp \
+    --local-port 5353 --remote-host 10.0.0.2:53 \
+    --hop bastion.example.com:22 --user deploy --key ~/.ssh/id_rsa
+
+  # Route a private network through a bastion
+  sudo tunnelctl create --name vpc --type transparent \
+    --route 10.0.0.0/16 --hop bastion.example.com:22 --user deploy --key ~/.ssh/id_rsa
+
+  # Go through a host from the address book (see tunnelctl address)
+  tunnelctl create --name db --type local \
+    --local-port 5432 --remote-host db.internal:5432 --hop @prod-bastion
+
+  # Let the bastion use your SSH agent to log in to the next hop
+  tunnelctl create --name app --type local \
+    --local-port 8080 --remote-host localhost:8080 \
+    --hop bastion.example.com:22 --hop app.internal:22 \
+    --forward-agent bastion.example.com:22 --user deploy
+
+  # Bastion with Duo/OTP: answer the prompt with "tunnelctl prompts"
+  tunnelctl create --name prod-db --type local \
+    --local-port 5432 --remote-host db.internal:5432 \
+    --hop bastion.example.com:22 --keyboard-interactive bastion.example.com:22 \
+    --user deploy --key ~/.ssh/id_rsa
+
+  # Create the tunnels defined in a file, in the API's create request format:
+  # one per YAML document (separated by ---), a YAML or JSON list of them, or
+  # a bundle written by 'tunnelctl export'
+  tunnelctl create -f tunnels.yaml
+  cat tunnel.json | tunnelctl create -f -`,
 	RunE: runCreate,
 }
 
 func init() {
 	createCmd.Flags().StringVar(&tunnelName, "name", "", "tunnel name (required)")
-	createCmd.Flags().StringVar(&tunnelType, "type", "local", "tunnel type: local, remote, or dynamic")
+	createCmd.Flags().StringVar(&tunnelType, "type", "local", "tunnel type: local, remote, dynamic, or transparent")
+	createCmd.Flags().StringVar(&protocol, "protocol", "tcp", "transport protocol: tcp or udp (udp for local tunnels only)")
 	createCmd.Flags().IntVar(&localPort, "local-port", 0, "local port to bind")
 	createCmd.Flags().StringVar(&remoteHost, "remote-host", "", "remote host:port (for local tunnels)")
 	createCmd.Flags().IntVar(&remotePort, "remote-port", 0, "remote port (for remote tunnels)")
 	createCmd.Flags().StringArrayVar(&hops, "hop", []string{}, "SSH hop in format host:port (can specify multiple for multi-hop)")
 	createCmd.Flags().StringVar(&sshUser, "user", os.Getenv("USER"), "SSH username")
 	createCmd.Flags().StringVar(&sshKey, "key", "", "path to SSH private key")
-	createCmd.Flags().BoolVar(&autoReconnect, "auto-reconnect", true, "automatically reconnect on failure")
-	createCmd.Flags().IntVar(&keepAlive, "keep-alive", 30, "SSH keep-alive interval in seconds")
-	createCmd.Flags().IntVar(&maxRetries, "max-retries", 3, "maximum reconnection attempts")
-
-	createCmd.MarkFlagRequired("name")
-	createCmd.MarkFlagRequired("hop")
+	createCmd.Flags().BoolVar(&autoReconnect, "auto-reconnect", false, "automatically reconnect on failure (default: the server's)")
+	createCmd.Flags().IntVar(&keepAlive, "keep-alive", 0, "SSH keep-alive interval in seconds (default: the server's)")
+	createCmd.Flags().IntVar(&maxRetries, "max-retries", 0, "maximum reconnection attempts (default: the server's)")
+	createCmd.Flags().StringArrayVar(&routes, "route", []string{}, "CIDR to route through the tunnel (for transparent tunnels, can specify multiple)")
+	createCmd.Flags().StringArrayVar(&forwardAgent, "forward-agent", []string{}, "forward your SSH agent to this hop (host:port matching a --hop, can specify multiple)")
+	createCmd.Flags().BoolVar(&systemProxy, "system-proxy", false, "register the SOCKS5 proxy in the OS proxy settings while the tunnel runs (for dynamic tunnels, admin only)")
+	createCmd.Flags().StringArrayVar(&pacDomains, "pac-domain", []string{}, "domain (and its subdomains) the tunnel's proxy.pac sends through the SOCKS5 proxy (for dynamic tunnels, can specify multiple)")
+	createCmd.Flags().StringArrayVar(&pacCIDRs, "pac-cidr", []string{}, "IPv4 CIDR the tunnel's proxy.pac sends through the SOCKS5 proxy (for dynamic tunnels, can specify multiple)")
+	createCmd.Flags().StringArrayVar(&interactive, "keyboard-interactive", []string{}, "allow 2FA/keyboard-interactive prompts from this hop, answered with 'tunnelctl prompts' (host:port matching a --hop)")
+	createCmd.Flags().StringVarP(&createFile, "filename", "f", "", "create the tunnels defined in a YAML or JSON file instead, - for stdin")
 }
 
 func runCreate(cmd *cobra.Command, args []string) error {
+	if createFile != "" {
+		if cmd.Flags().Changed("name") || cmd.Flags().Changed("hop") {
+			return fmt.Errorf("--filename can't be combined with --name or --hop; define the tunnels in the file")
+		}
+		return runCreateFromFile(cmd, createFile)
+	}
+	if tunnelName == "" || len(hops) == 0 {
+		return fmt.Errorf("--name and --hop are required, unless tunnels are read from a file with --filename")
+	}
+
+	interpolated, err := interpolateFlags()
+	if err != nil {
+		return err
+	}
+
 	// Parse tunnel type
 	var ttype types.TunnelType
 	switch strings.ToLower(tunnelType) {
@@ -93,35 +166,76 @@ func runCreate(cmd *cobra.Command, args []string) error {
 		}
 	case "dynamic":
 		ttype = types.TunnelTypeDynamic
+	case "transparent":
+		ttype = types.TunnelTypeTransparent
+		if len(routes) == 0 {
+			return fmt.Errorf("--route is required for transparent tunnels")
+		}
 	default:
-		return fmt.Errorf("invalid tunnel type: %s (must be local, remote, or dynamic)", tunnelType)
+		return fmt.Errorf("invalid tunnel type: %s (must be local, remote, dynamic, or transparent)", tunnelType)
 	}
 
-	// Parse hops
-	hopList := make([]types.Hop, len(hops))
-	for i, h := range hops {
-		parts := strings.Split(h, ":")
-		if len(parts) != 2 {
-			return fmt.Errorf("invalid hop format: %s (expected host:port)", h)
+	// Parse protocol
+	proto := types.Protocol(strings.ToLower(protocol))
+	switch proto {
+	case types.ProtocolTCP:
+	case types.ProtocolUDP:
+		if ttype != types.TunnelTypeLocal {
+			return fmt.Errorf("--protocol udp is only supported for local tunnels")
+		}
+	default:
+		return fmt.Errorf("invalid protocol: %s (must be tcp or udp)", protocol)
+	}
+
+	// Agent forwarding and interactive auth are opted into per hop
+	for _, h := range append(append([]string{}, forwardAgent...), interactive...) {
+		if !slices.Contains(hops, h) {
+			return fmt.Errorf("%s does not match any --hop", h)
 		}
+	}
 
-		var port int
-		if _, err := fmt.Sscanf(parts[1], "%d", &port); err != nil {
-			return fmt.Errorf("invalid port in hop: %s", h)
+	// Parse hops
+	hopList := make([]types.HopReq, len(hops))
+	for i, h := range hops {
+		// An address book entry fills in what isn't given on the command line
+		if _, ok := types.AddressRef(h); ok {
+			hopList[i] = types.HopReq{
+				Host:                h,
+				KeyID:               sshKey,
+				ForwardAgent:        slices.Contains(forwardAgent, h),
+				KeyboardInteractive: slices.Contains(interactive, h),
+			}
+			if cmd.Flags().Changed("user") {
+				hopList[i].User = sshUser
+			}
+			if sshKey != "" {
+				hopList[i].AuthMethod = string(types.AuthMethodKey)
+			}
+			continue
 		}
 
-		authMethod := types.AuthMethodKey
 		keyID := sshKey
 		if keyID == "" {
-			keyID = os.ExpandEnv("$HOME/.ssh/id_rsa")
+			keyID = defaultKeyPath()
 		}
 
-		hopList[i] = types.Hop{
-			Host:       parts[0],
-			Port:       port,
-			User:       sshUser,
-			AuthMethod: authMethod,
-			KeyID:      keyID,
+		hop, err := parseHop(h, sshUser, types.AuthMethodKey, keyID)
+		if err != nil {
+			return err
+		}
+		hopList[i] = types.HopReq{
+			Host:                hop.Host,
+			Port:                hop.Port,
+			User:                hop.User,
+			AuthMethod:          string(hop.AuthMethod),
+			KeyID:               hop.KeyID,
+			ForwardAgent:        slices.Contains(forwardAgent, h),
+			KeyboardInteractive: slices.Contains(interactive, h),
+		}
+	}
+	for _, h := range hopList {
+		if h.ForwardAgent {
+			fmt.Fprintf(os.Stderr, "Warning: forwarding your SSH agent to %s; anyone with root on that host can use your keys while the tunnel is connected\n", h.Host)
 		}
 	}
 
@@ -142,29 +256,41 @@ func runCreate(cmd *cobra.Command, args []string) error {
 		remPort = remotePort
 	}
 
-	// Create tunnel spec
-	spec := types.TunnelSpec{
-		Name:          tunnelName,
-		Type:          ttype,
-		LocalPort:     localPort,
-		RemoteHost:    remHost,
-		RemotePort:    remPort,
-		Hops:          hopList,
-		AutoReconnect: autoReconnect,
-		KeepAlive:     time.Duration(keepAlive) * time.Second,
-		MaxRetries:    maxRetries,
+	// Build the same create request the API validates
+	req := types.CreateTunnelRequest{
+		Name:        tunnelName,
+		Type:        string(ttype),
+		Protocol:    string(proto),
+		LocalPort:   localPort,
+		RemoteHost:  remHost,
+		RemotePort:  remPort,
+		Hops:        hopList,
+		KeepAlive:   keepAlive,
+		MaxRetries:  maxRetries,
+		Routes:      routes,
+		SystemProxy: systemProxy,
+	}
+	if len(pacDomains) > 0 || len(pacCIDRs) > 0 {
+		req.PAC = &types.PACReq{Domains: pacDomains, CIDRs: pacCIDRs}
+	}
+	// Left to the server's default unless given
+	if cmd.Flags().Changed("auto-reconnect") {
+		req.AutoReconnect = &autoReconnect
+	}
+	if len(interpolated) > 0 {
+		req.Interpolated = interpolated
 	}
 
 	// Make API request
 	serverURL := viper.GetString("server")
 	url := fmt.Sprintf("%s/api/v1/tunnels", serverURL)
 
-	jsonData, err := json.Marshal(spec)
+	jsonData, err := json.Marshal(req)
 	if err != nil {
-		return fmt.Errorf("failed to marshal tunnel spec: %w", err)
+		return fmt.Errorf("failed to marshal create request: %w", err)
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := postIdempotent(url, "application/json", jsonData)
 	if err != nil {
 		return fmt.Errorf("failed to create tunnel: %w", err)
 	}
@@ -185,15 +311,186 @@ func runCreate(cmd *cobra.Command, args []string) error {
 	fmt.Printf("✓ Tunnel created successfully\n")
 	fmt.Printf("  ID: %s\n", result["id"])
 	fmt.Printf("  Name: %s\n", tunnelName)
+	fmt.Printf("  Owner: %s\n", result["owner"])
 	fmt.Printf("  Type: %s\n", tunnelType)
 
 	if ttype == types.TunnelTypeLocal {
-		fmt.Printf("  Listening: localhost:%d → %s\n", localPort, remoteHost)
+		fmt.Printf("  Listening: localhost:%d/%s → %s\n", localPort, proto, remoteHost)
 	} else if ttype == types.TunnelTypeRemote {
 		fmt.Printf("  Listening: remote:%d → localhost:%d\n", remotePort, localPort)
 	} else if ttype == types.TunnelTypeDynamic {
 		fmt.Printf("  SOCKS5 Proxy: localhost:%d\n", localPort)
+		if systemProxy {
+			fmt.Printf("  System proxy: registered while the tunnel runs\n")
+		}
+		if req.PAC != nil {
+			id, _ := result["id"].(string)
+			if link, expires, err := pacURL(serverURL, id); err != nil {
+				fmt.Printf("  PAC file: %s/%s/proxy.pac (couldn't get a share token: %v)\n", url, id, err)
+			} else if expires.IsZero() {
+				fmt.Printf("  PAC file: %s\n", link)
+			} else {
+				fmt.Printf("  PAC file: %s\n", link)
+				fmt.Printf("    (read-only link, expires %s)\n", expires.Local().Format("2006-01-02 15:04"))
+			}
+		}
+	} else if ttype == types.TunnelTypeTransparent {
+		fmt.Printf("  Routing: %s\n", strings.Join(routes, ", "))
 	}
 
 	return nil
 }
+
+// pacShareTTL is how long the share token in a printed PAC URL lasts, the
+// longest the server allows
+const pacShareTTL = "168h"
+
+// pacURL returns the URL of a tunnel's PAC file to put in browser or OS
+// proxy settings. When the server requires logins, it carries a read-only
+// share token for the tunnel rather than the session token, and the time it
+// expires.
+func pacURL(serverURL, tunnelID string) (string, time.Time, error) {
+	link := fmt.Sprintf("%s/api/v1/tunnels/%s/proxy.pac", serverURL, url.PathEscape(tunnelID))
+
+	body, _ := json.Marshal(map[string]string{"access": "read", "expiresIn": pacShareTTL})
+	resp, err := http.Post(fmt.Sprintf("%s/api/v1/tunnels/%s/share", serverURL, url.PathEscape(tunnelID)), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+	case http.StatusServiceUnavailable:
+		// Authentication is off, so the URL needs no token
+		return link, time.Time{}, nil
+	default:
+		data, _ := io.ReadAll(resp.Body)
+		return "", time.Time{}, fmt.Errorf("%s", strings.TrimSpace(string(data)))
+	}
+
+	var share struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&share); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to parse share response: %w", err)
+	}
+	return link + "?token=" + url.QueryEscape(share.Token), share.ExpiresAt, nil
+}
+
+// interpolateFlags resolves the ${VAR} references of the create flags, e.g.
+// single-quoted past the shell, like those of definition files. It returns
+// their templates keyed by the field they fill; --user and --key fill every
+// hop's.
+func interpolateFlags() (map[string]string, error) {
+	templates := make(map[string]string)
+	expand := func(flag, path string, value *string) error {
+		expanded, err := interpolate.Expand(*value, os.LookupEnv)
+		if err != nil {
+			return fmt.Errorf("--%s: %w", flag, err)
+		}
+		if expanded != *value && path != "" {
+			templates[path] = *value
+		}
+		*value = expanded
+		return nil
+	}
+
+	if err := expand("name", "name", &tunnelName); err != nil {
+		return nil, err
+	}
+	if err := expand("remote-host", "remoteHost", &remoteHost); err != nil {
+		return nil, err
+	}
+	if err := expand("user", "hops[*].user", &sshUser); err != nil {
+		return nil, err
+	}
+	if err := expand("key", "hops[*].key_id", &sshKey); err != nil {
+		return nil, err
+	}
+	for i := range hops {
+		if err := expand("hop", fmt.Sprintf("hops[%d]", i), &hops[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range routes {
+		if err := expand("route", fmt.Sprintf("routes[%d]", i), &routes[i]); err != nil {
+			return nil, err
+		}
+	}
+	// These name hops, so they must match the expanded --hop values
+	for i := range forwardAgent {
+		if err := expand("forward-agent", "", &forwardAgent[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range interactive {
+		if err := expand("keyboard-interactive", "", &interactive[i]); err != nil {
+			return nil, err
+		}
+	}
+	return templates, nil
+}
+
+// parseHop parses a --hop value in host:port format
+func parseHop(h, user string, authMethod types.AuthMethod, keyID string) (types.Hop, error) {
+	parts := strings.Split(h, ":")
+	if len(parts) != 2 {
+		return types.Hop{}, fmt.Errorf("invalid hop format: %s (expected host:port)", h)
+	}
+
+	var port int
+	if _, err := fmt.Sscanf(parts[1], "%d", &port); err != nil {
+		return types.Hop{}, fmt.Errorf("invalid port in hop: %s", h)
+	}
+
+	return types.Hop{
+		Host:       parts[0],
+		Port:       port,
+		User:       user,
+		AuthMethod: authMethod,
+		KeyID:      keyID,
+	}, nil
+}
+
+// defaultKeyPath is the private key used when --key isn't given. $HOME is
+// usually unset on Windows, so it goes by the user's profile directory.
+func defaultKeyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "~/.ssh/id_rsa"
+	}
+	return filepath.Join(home, ".ssh", "id_rsa")
+}
+
+// createAttempts is how many times a create is sent when the connection fails
+const createAttempts = 3
+
+// postIdempotent sends a POST with an Idempotency-Key and retries it after
+// network errors; the key makes the server apply it at most once, so a retry
+// of a request that did get through returns the original response
+func postIdempotent(url, contentType string, body []byte) (*http.Response, error) {
+	key := uuid.NewString()
+
+	var lastErr error
+	for attempt := 1; attempt <= createAttempts; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Idempotency-Key", key)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			return resp, nil
+		}
+
+		lastErr = err
+		if attempt < createAttempts {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+	return nil, lastErr
+}
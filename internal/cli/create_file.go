@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/craigderington/lazytunnel/internal/interpolate"
+)
+
+// createFile is the file -f reads tunnel definitions from; - is stdin
+var createFile string
+
+// runCreateFromFile creates the tunnels defined in a file. Each is sent to
+// the API as is, so it is validated exactly like any other create request.
+func runCreateFromFile(cmd *cobra.Command, path string) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read tunnel definitions: %w", err)
+	}
+
+	definitions, err := parseTunnelDefinitions(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse tunnel definitions: %w", err)
+	}
+	if len(definitions) == 0 {
+		return fmt.Errorf("no tunnel definitions in %s", path)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/tunnels", viper.GetString("server"))
+	failed := 0
+	for i, definition := range definitions {
+		name := definitionName(definition, i)
+		id, err := createDefinition(endpoint, definition)
+		if err != nil {
+			fmt.Printf("✗ Failed %s: %s\n", name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("✓ Created %s (%s)\n", name, id)
+	}
+
+	if len(definitions) > 1 {
+		fmt.Printf("\nCreated: %d, failed: %d\n", len(definitions)-failed, failed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d tunnel(s) failed to create", failed)
+	}
+	return nil
+}
+
+// parseTunnelDefinitions reads tunnel create requests, as the API takes
+// them, from YAML or JSON: one per YAML document, a list of them, or the
+// tunnels of a bundle written by 'tunnelctl export'. ${VAR} references are
+// resolved from the environment and marked in each request.
+func parseTunnelDefinitions(data []byte) ([]map[string]interface{}, error) {
+	var definitions []map[string]interface{}
+	add := func(doc interface{}) error {
+		definition, ok := doc.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected a tunnel definition, got %T", doc)
+		}
+		if err := interpolateDefinition(definition); err != nil {
+			return fmt.Errorf("%s: %w", definitionName(definition, len(definitions)), err)
+		}
+		definitions = append(definitions, definition)
+		return nil
+	}
+
+	// JSON is YAML, so one decoder reads both
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc interface{}
+		if err := decoder.Decode(&doc); errors.Is(err, io.EOF) {
+			return definitions, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if bundle, ok := doc.(map[string]interface{}); ok {
+			if tunnels, ok := bundle["tunnels"]; ok {
+				doc = tunnels
+			}
+		}
+		switch doc := doc.(type) {
+		case nil:
+			// An empty document, e.g. after a trailing ---
+		case []interface{}:
+			for _, item := range doc {
+				if err := add(item); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			if err := add(doc); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// interpolateDefinition resolves the ${VAR} references of a tunnel
+// definition and records their templates in its interpolated field, next to
+// any it already has, e.g. from an exported bundle
+func interpolateDefinition(definition map[string]interface{}) error {
+	marked, _ := definition["interpolated"].(map[string]interface{})
+	delete(definition, "interpolated")
+
+	_, templates, err := interpolate.Document(definition, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	for path, template := range templates {
+		if marked == nil {
+			marked = make(map[string]interface{})
+		}
+		marked[path] = template
+	}
+	if marked != nil {
+		definition["interpolated"] = marked
+	}
+	return nil
+}
+
+// definitionName names a tunnel definition in messages
+func definitionName(definition map[string]interface{}, i int) string {
+	if name, ok := definition["name"].(string); ok && name != "" {
+		return name
+	}
+	return fmt.Sprintf("tunnel #%d", i+1)
+}
+
+// createDefinition creates one tunnel and returns its ID
+func createDefinition(endpoint string, definition map[string]interface{}) (string, error) {
+	spec, err := json.Marshal(definition)
+	if err != nil {
+		return "", err
+	}
+	resp, err := postIdempotent(endpoint, "application/json", spec)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		return "", errors.New(apiErrorMessage(body))
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &created); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return created.ID, nil
+}
+
+// apiErrorMessage describes an API error response, with the fields that
+// failed validation
+func apiErrorMessage(body []byte) string {
+	var apiErr struct {
+		Message string `json:"message"`
+		Details []struct {
+			Field string `json:"field"`
+			Issue string `json:"issue"`
+		} `json:"details"`
+	}
+	if err := json.Unmarshal(body, &apiErr); err != nil || apiErr.Message == "" {
+		return strings.TrimSpace(string(body))
+	}
+
+	issues := make([]string, 0, len(apiErr.Details))
+	for _, d := range apiErr.Details {
+		if d.Issue != "" {
+			issues = append(issues, fmt.Sprintf("%s: %s", d.Field, d.Issue))
+		}
+	}
+	if len(issues) == 0 {
+		return apiErr.Message
+	}
+	return fmt.Sprintf("%s (%s)", apiErr.Message, strings.Join(issues, "; "))
+}
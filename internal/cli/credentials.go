@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// errCredentialNotFound is returned by a credentialStore for unknown keys
+var errCredentialNotFound = errors.New("credential not found")
+
+// credentialStore keeps the CLI's secrets in the operating system's
+// credential store instead of the plaintext config file
+type credentialStore interface {
+	// Name describes the store for messages
+	Name() string
+	Get(key string) (string, error)
+	Set(key, value string) error
+	Delete(key string) error
+}
+
+// osCredentialStore returns the operating system's credential store, or nil
+// when there is none or credential_store is set to "none" in the config
+func osCredentialStore() credentialStore {
+	if viper.GetString("credential_store") == "none" {
+		return nil
+	}
+	return platformCredentialStore()
+}
+
+// tokenKey is the credential store key holding the API token for a server
+func tokenKey(serverURL string) string {
+	return "token:" + serverURL
+}
+
+// passphraseKey is the credential store key holding a private key's passphrase
+func passphraseKey(keyPath string) string {
+	if abs, err := filepath.Abs(keyPath); err == nil {
+		keyPath = abs
+	}
+	return "passphrase:" + keyPath
+}
+
+// resolveToken returns the API token for the configured server. A token set
+// in the config file or environment wins over one saved by "auth login".
+func resolveToken() string {
+	if token := viper.GetString("token"); token != "" {
+		return token
+	}
+
+	store := osCredentialStore()
+	if store == nil {
+		return ""
+	}
+	token, err := store.Get(tokenKey(viper.GetString("server")))
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+// authTransport adds the API token to requests sent to the lazytunnel server
+type authTransport struct {
+	base http.RoundTripper
+
+	once  sync.Once
+	token string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.once.Do(func() {
+		t.token = resolveToken()
+	})
+
+	if t.token == "" || req.Header.Get("Authorization") != "" || !isServerURL(req.URL) {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
+
+// isServerURL reports whether u points at the configured server, so the
+// token is never sent anywhere else
+func isServerURL(u *url.URL) bool {
+	server, err := url.Parse(viper.GetString("server"))
+	if err != nil {
+		return false
+	}
+	return u.Scheme == server.Scheme && u.Host == server.Host
+}
@@ -0,0 +1,68 @@
+//go:build darwin
+
+package cli
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keychainNotFound is the exit status of security(1) for a missing item
+const keychainNotFound = 44
+
+// keychainStore keeps credentials in the login keychain as generic
+// passwords of the "tunnelctl" service, using the security tool
+type keychainStore struct{}
+
+func platformCredentialStore() credentialStore {
+	return keychainStore{}
+}
+
+func (keychainStore) Name() string {
+	return "macOS Keychain"
+}
+
+func (keychainStore) Get(key string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", "tunnelctl", "-a", key, "-w").Output()
+	if err != nil {
+		return "", keychainError(err, "read")
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (keychainStore) Set(key, value string) error {
+	// Commands given on stdin keep the secret out of the process list; the
+	// hex form of -X sidesteps quoting it
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s tunnelctl -a %s -X %s\n",
+		keychainQuote(key), hex.EncodeToString([]byte(value))))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to save credential: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (keychainStore) Delete(key string) error {
+	if err := exec.Command("security", "delete-generic-password", "-s", "tunnelctl", "-a", key).Run(); err != nil {
+		return keychainError(err, "delete")
+	}
+	return nil
+}
+
+// keychainError maps a failed security(1) run to errCredentialNotFound when
+// the item doesn't exist
+func keychainError(err error, op string) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == keychainNotFound {
+		return errCredentialNotFound
+	}
+	return fmt.Errorf("failed to %s credential: %w", op, err)
+}
+
+// keychainQuote quotes an argument for security's interactive mode
+func keychainQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// secretServiceStore keeps credentials in the desktop keyring (GNOME
+// Keyring, KWallet) through the Secret Service API, using secret-tool
+type secretServiceStore struct{}
+
+// platformCredentialStore returns the Secret Service store when secret-tool
+// is installed, which it usually isn't on servers
+func platformCredentialStore() credentialStore {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil
+	}
+	return secretServiceStore{}
+}
+
+func (secretServiceStore) Name() string {
+	return "Secret Service keyring"
+}
+
+func (secretServiceStore) Get(key string) (string, error) {
+	var stderr strings.Builder
+	cmd := exec.Command("secret-tool", "lookup", "service", "tunnelctl", "account", key)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		// A missing item is a silent exit status 1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() == 0 {
+			return "", errCredentialNotFound
+		}
+		return "", fmt.Errorf("failed to read credential: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func (secretServiceStore) Set(key, value string) error {
+	// secret-tool reads the secret from stdin, keeping it out of the process list
+	cmd := exec.Command("secret-tool", "store", "--label", "tunnelctl "+key, "service", "tunnelctl", "account", key)
+	cmd.Stdin = strings.NewReader(value)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to save credential: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (s secretServiceStore) Delete(key string) error {
+	// clear succeeds whether or not anything matched
+	if _, err := s.Get(key); err != nil {
+		return err
+	}
+	if out, err := exec.Command("secret-tool", "clear", "service", "tunnelctl", "account", key).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete credential: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !windows && !darwin && !linux
+
+package cli
+
+// platformCredentialStore returns nil where no credential store is
+// supported; the token then has to be set in the config file
+func platformCredentialStore() credentialStore {
+	return nil
+}
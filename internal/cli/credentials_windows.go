@@ -0,0 +1,114 @@
+//go:build windows
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	advapi32        = windows.NewLazySystemDLL("advapi32.dll")
+	procCredReadW   = advapi32.NewProc("CredReadW")
+	procCredWriteW  = advapi32.NewProc("CredWriteW")
+	procCredDeleteW = advapi32.NewProc("CredDeleteW")
+	procCredFree    = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+)
+
+// winCredential mirrors the CREDENTIALW structure
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// winCredStore keeps credentials in the Windows Credential Manager as
+// generic credentials named "tunnelctl:<key>"
+type winCredStore struct{}
+
+func platformCredentialStore() credentialStore {
+	return winCredStore{}
+}
+
+func (winCredStore) Name() string {
+	return "Windows Credential Manager"
+}
+
+func (winCredStore) Get(key string) (string, error) {
+	target, err := windows.UTF16PtrFromString("tunnelctl:" + key)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *winCredential
+	ret, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		if errors.Is(err, windows.ERROR_NOT_FOUND) {
+			return "", errCredentialNotFound
+		}
+		return "", fmt.Errorf("failed to read credential: %w", err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func (winCredStore) Set(key, value string) error {
+	target, err := windows.UTF16PtrFromString("tunnelctl:" + key)
+	if err != nil {
+		return err
+	}
+	user, err := windows.UTF16PtrFromString("tunnelctl")
+	if err != nil {
+		return err
+	}
+
+	blob := []byte(value)
+	cred := winCredential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+
+	if ret, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); ret == 0 {
+		return fmt.Errorf("failed to save credential: %w", err)
+	}
+	return nil
+}
+
+func (winCredStore) Delete(key string) error {
+	target, err := windows.UTF16PtrFromString("tunnelctl:" + key)
+	if err != nil {
+		return err
+	}
+
+	if ret, _, err := procCredDeleteW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); ret == 0 {
+		if errors.Is(err, windows.ERROR_NOT_FOUND) {
+			return errCredentialNotFound
+		}
+		return fmt.Errorf("failed to delete credential: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/pkg/tunnelkit"
+)
+
+var (
+	demoComposeFile string
+	demoSSHAddr     string
+	demoUser        string
+	demoKey         string
+	demoTarget      string
+	demoRemotePort  int
+	demoNoDocker    bool
+	demoKeep        bool
+	demoYes         bool
+)
+
+var demoCmd = &cobra.Command{
+	Use:   "demo",
+	Short: "Walk through local, remote and dynamic tunnels against a throwaway SSH server",
+	Long: `Walk through local, remote and dynamic (SOCKS5) tunnels step by step.
+
+The demo starts a throwaway SSH server and a web service only reachable
+through it with Docker Compose (deployments/demo), generates a one-off key
+for it, and then opens each kind of tunnel in-process, showing traffic
+flowing through it. Everything is torn down at the end.
+
+Requires Docker with the compose plugin, unless --no-docker points the demo
+at an SSH server of your own.
+
+Examples:
+  # Run the demo from a checkout of the repository
+  tunnelctl demo
+
+  # Run it without pausing between steps
+  tunnelctl demo --yes
+
+  # Use your own SSH server and a service behind it
+  tunnelctl demo --no-docker --ssh bastion.example.com:22 --user deploy \
+    --key ~/.ssh/id_ed25519 --target intranet.example.com:80`,
+	RunE:         runDemo,
+	SilenceUsage: true,
+}
+
+func init() {
+	demoCmd.Flags().StringVar(&demoComposeFile, "compose-file", filepath.Join("deployments", "demo", "docker-compose.yml"), "Docker Compose file of the demo environment")
+	demoCmd.Flags().StringVar(&demoSSHAddr, "ssh", "127.0.0.1:2222", "SSH server in format host:port")
+	demoCmd.Flags().StringVar(&demoUser, "user", "demo", "SSH user")
+	demoCmd.Flags().StringVar(&demoKey, "key", "", "SSH private key (default: a key generated for the demo)")
+	demoCmd.Flags().StringVar(&demoTarget, "target", "whoami:80", "HTTP service reachable from the SSH server, in format host:port")
+	demoCmd.Flags().IntVar(&demoRemotePort, "remote-port", 8080, "port the remote tunnel binds on the SSH server")
+	demoCmd.Flags().BoolVar(&demoNoDocker, "no-docker", false, "use an SSH server that is already running instead of starting the demo environment")
+	demoCmd.Flags().BoolVar(&demoKeep, "keep", false, "leave the demo environment running afterwards")
+	demoCmd.Flags().BoolVarP(&demoYes, "yes", "y", false, "run every step without pausing")
+}
+
+// demoRun is the state of one run of the demo
+type demoRun struct {
+	ctx     context.Context
+	out     io.Writer
+	in      *bufio.Reader
+	session *tunnelkit.Session
+	step    int
+}
+
+func runDemo(cmd *cobra.Command, args []string) error {
+	host, port, err := splitDemoAddr(demoSSHAddr)
+	if err != nil {
+		return fmt.Errorf("invalid --ssh: %w", err)
+	}
+	if _, _, err := splitDemoAddr(demoTarget); err != nil {
+		return fmt.Errorf("invalid --target: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+	d := &demoRun{ctx: ctx, out: cmd.OutOrStdout(), in: bufio.NewReader(cmd.InOrStdin())}
+
+	keyPath, publicKey := expandHome(demoKey), ""
+	if demoKey == "" {
+		dir, err := os.MkdirTemp("", "lazytunnel-demo-")
+		if err != nil {
+			return fmt.Errorf("failed to create a directory for the demo key: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		if keyPath, publicKey, err = generateDemoKey(dir); err != nil {
+			return err
+		}
+	} else if !demoNoDocker {
+		if publicKey, err = readPublicKey(keyPath); err != nil {
+			return err
+		}
+	}
+
+	if !demoNoDocker {
+		d.heading("Starting the demo environment")
+		fmt.Fprintf(d.out, "An SSH server on %s and a web service (%s) only it can reach.\n", demoSSHAddr, demoTarget)
+		if err := composeUp(ctx, d.out, publicKey, port); err != nil {
+			return err
+		}
+		if !demoKeep {
+			defer composeDown(d.out)
+		}
+	}
+
+	d.heading("Connecting to the SSH server")
+	session, err := tunnelkit.NewSession(ctx, tunnelkit.SessionConfig{
+		Hop: &tunnelkit.Hop{
+			Host:       host,
+			Port:       port,
+			User:       demoUser,
+			AuthMethod: tunnelkit.AuthMethodKey,
+			KeyID:      keyPath,
+			// The demo server is created on the spot, so there is no
+			// known_hosts entry to check it against
+			HostKeyVerification: tunnelkit.HostKeyVerifyInsecure,
+		},
+		MaxRetries:    10,
+		Timeout:       5 * time.Second,
+		BackoffConfig: tunnelkit.BackoffConfig{Initial: 500 * time.Millisecond, Max: 2 * time.Second, Multiplier: 1.5},
+	})
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if err := session.ConnectWithRetry(); err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", demoSSHAddr, err)
+	}
+	d.session = session
+	fmt.Fprintf(d.out, "Connected to %s@%s. Every tunnel below shares this one SSH connection.\n", demoUser, demoSSHAddr)
+
+	for _, step := range []func() error{d.localTunnel, d.remoteTunnel, d.dynamicTunnel} {
+		if err := d.pause(); err != nil {
+			return err
+		}
+		if err := step(); err != nil {
+			return err
+		}
+	}
+
+	d.heading("Done")
+	fmt.Fprintln(d.out, "Run the same tunnels for real with tunnelctl create, or embed them in Go programs with pkg/tunnelkit.")
+	if demoKeep && !demoNoDocker {
+		fmt.Fprintf(d.out, "The demo environment is still running; stop it with: docker compose -f %s down\n", demoComposeFile)
+	}
+	return nil
+}
+
+// localTunnel shows a local (ssh -L) tunnel reaching the private service
+func (d *demoRun) localTunnel() error {
+	d.heading("Local tunnel (ssh -L)")
+	fmt.Fprintf(d.out, "A local port on this machine forwards to %s, which only the SSH server can reach.\n", demoTarget)
+
+	host, port, _ := splitDemoAddr(demoTarget)
+	spec := &tunnelkit.TunnelSpec{
+		Type:             tunnelkit.TunnelTypeLocal,
+		LocalBindAddress: "127.0.0.1",
+		RemoteHost:       host,
+		RemotePort:       port,
+	}
+	forwarder, err := tunnelkit.NewLocalForwarder(d.ctx, spec, d.session)
+	if err != nil {
+		return err
+	}
+	if err := forwarder.Start(); err != nil {
+		return err
+	}
+	defer forwarder.ForceStop()
+
+	target := "http://" + forwarder.LocalAddr() + "/"
+	fmt.Fprintf(d.out, "\n  $ tunnelctl create --name demo-local --type local --local-port %d --remote-host %s %s\n", spec.LocalPort, demoTarget, demoHopFlags())
+	fmt.Fprintf(d.out, "  $ curl %s\n\n", target)
+	return d.fetch(http.DefaultClient, target)
+}
+
+// remoteTunnel shows a remote (ssh -R) tunnel exposing a service on this
+// machine to the SSH server
+func (d *demoRun) remoteTunnel() error {
+	d.heading("Remote tunnel (ssh -R)")
+	fmt.Fprintf(d.out, "Port %d on the SSH server forwards back to a web server on this machine.\n", demoRemotePort)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to start the local web server: %w", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hostname, _ := os.Hostname()
+		fmt.Fprintf(w, "Hello from %s, served to the SSH server through a remote tunnel\n", hostname)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	spec := &tunnelkit.TunnelSpec{
+		Type:       tunnelkit.TunnelTypeRemote,
+		LocalPort:  listener.Addr().(*net.TCPAddr).Port,
+		RemotePort: demoRemotePort,
+	}
+	forwarder, err := tunnelkit.NewRemoteForwarder(d.ctx, spec, d.session)
+	if err != nil {
+		return err
+	}
+	if err := forwarder.Start(); err != nil {
+		return err
+	}
+	defer forwarder.ForceStop()
+
+	// Fetch the page the way a program on the SSH server would, through
+	// the server's own loopback
+	onServer := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return d.session.DialContext(ctx, network, addr)
+		},
+	}}
+	target := fmt.Sprintf("http://127.0.0.1:%d/", demoRemotePort)
+	fmt.Fprintf(d.out, "\n  $ tunnelctl create --name demo-remote --type remote --remote-port %d --local-port %d %s\n", spec.RemotePort, spec.LocalPort, demoHopFlags())
+	fmt.Fprintf(d.out, "  (on the SSH server) $ curl %s\n\n", target)
+	return d.fetch(onServer, target)
+}
+
+// dynamicTunnel shows a dynamic (ssh -D) tunnel: a SOCKS5 proxy reaching
+// anything the SSH server can
+func (d *demoRun) dynamicTunnel() error {
+	d.heading("Dynamic tunnel (ssh -D)")
+	fmt.Fprintln(d.out, "A local SOCKS5 proxy sends each connection wherever the client asks, through the SSH server.")
+
+	spec := &tunnelkit.TunnelSpec{
+		Type:             tunnelkit.TunnelTypeDynamic,
+		LocalBindAddress: "127.0.0.1",
+	}
+	forwarder, err := tunnelkit.NewDynamicForwarder(d.ctx, spec, d.session)
+	if err != nil {
+		return err
+	}
+	if err := forwarder.Start(); err != nil {
+		return err
+	}
+	defer forwarder.ForceStop()
+
+	proxy := &url.URL{Scheme: "socks5", Host: forwarder.LocalAddr()}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+	target := "http://" + demoTarget + "/"
+	fmt.Fprintf(d.out, "\n  $ tunnelctl create --name demo-socks --type dynamic --local-port %d %s\n", spec.LocalPort, demoHopFlags())
+	fmt.Fprintf(d.out, "  $ curl --socks5-hostname %s %s\n\n", forwarder.LocalAddr(), target)
+	return d.fetch(client, target)
+}
+
+// fetch GETs target with client and prints the start of the response
+func (d *demoRun) fetch(client *http.Client, target string) error {
+	ctx, cancel := context.WithTimeout(d.ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request through the tunnel failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	fmt.Fprintf(d.out, "  %s\n", resp.Status)
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 4096))
+	for lines := 0; scanner.Scan() && lines < 8; lines++ {
+		fmt.Fprintf(d.out, "  | %s\n", scanner.Text())
+	}
+	return nil
+}
+
+// demoHopFlags returns the tunnelctl create flags connecting through the
+// demo's SSH server
+func demoHopFlags() string {
+	flags := fmt.Sprintf("--hop %s --user %s", demoSSHAddr, demoUser)
+	if demoKey != "" {
+		flags += " --key " + demoKey
+	}
+	return flags
+}
+
+// splitDemoAddr splits a host:port flag value
+func splitDemoAddr(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in %s", addr)
+	}
+	return host, port, nil
+}
+
+// heading prints the title of the next step
+func (d *demoRun) heading(title string) {
+	d.step++
+	fmt.Fprintf(d.out, "\n== %d. %s\n", d.step, title)
+}
+
+// pause waits for Enter unless the demo runs with --yes
+func (d *demoRun) pause() error {
+	if demoYes {
+		return nil
+	}
+	fmt.Fprint(d.out, "\nPress Enter to continue...")
+	if _, err := d.in.ReadString('\n'); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// generateDemoKey writes a new ed25519 key pair for the demo to dir. It
+// returns the private key's path and the public key in authorized_keys
+// format.
+func generateDemoKey(dir string) (string, string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate the demo key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "lazytunnel-demo")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode the demo key: %w", err)
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode the demo key: %w", err)
+	}
+
+	path := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return "", "", fmt.Errorf("failed to write the demo key: %w", err)
+	}
+	return path, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublic))), nil
+}
+
+// readPublicKey returns the public key of the private key at path in
+// authorized_keys format
+func readPublicKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s (passphrase-protected keys need --no-docker): %w", path, err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
+
+// composeUp starts the demo environment, authorizing publicKey and
+// publishing SSH on sshPort
+func composeUp(ctx context.Context, out io.Writer, publicKey string, sshPort int) error {
+	if _, err := os.Stat(demoComposeFile); err != nil {
+		return fmt.Errorf("demo environment not found at %s: run from a checkout of lazytunnel, pass --compose-file, or use --no-docker", demoComposeFile)
+	}
+	cmd := exec.CommandContext(ctx, "docker", "compose", "-f", demoComposeFile, "up", "--build", "--detach", "--wait")
+	cmd.Env = append(os.Environ(),
+		"LAZYTUNNEL_DEMO_AUTHORIZED_KEY="+publicKey,
+		"LAZYTUNNEL_DEMO_SSH_PORT="+strconv.Itoa(sshPort),
+	)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to start the demo environment (is Docker running?): %w", err)
+	}
+	return nil
+}
+
+// composeDown stops the demo environment and removes its containers
+func composeDown(out io.Writer) {
+	fmt.Fprintln(out, "\nStopping the demo environment...")
+	cmd := exec.Command("docker", "compose", "-f", demoComposeFile, "down", "--volumes")
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(out, "Failed to stop the demo environment: %v\n", err)
+	}
+}
@@ -0,0 +1,374 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+var (
+	doctorKeys       []string
+	doctorHosts      []string
+	doctorPorts      []int
+	doctorKnownHosts string
+	doctorToken      string
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the local environment for common setup problems",
+	Long: `Check the local environment for common setup problems and print
+how to fix them.
+
+Checks:
+  - SSH agent is reachable and holds keys
+  - SSH private keys are readable, parseable and not accessible by others
+  - SSH hosts have known_hosts entries
+  - lazytunnel server is reachable and the API token is accepted
+  - local ports are free to bind
+
+Examples:
+  # Check the defaults (~/.ssh keys, server from config)
+  tunnelctl doctor
+
+  # Check what a tunnel will need before creating it
+  tunnelctl doctor --key ~/.ssh/deploy --hop bastion.example.com:22 --port 5432`,
+	RunE:         runDoctor,
+	SilenceUsage: true,
+}
+
+func init() {
+	doctorCmd.Flags().StringArrayVar(&doctorKeys, "key", []string{}, "SSH private key to check (default: ~/.ssh/id_ed25519, id_ecdsa, id_rsa)")
+	doctorCmd.Flags().StringArrayVar(&doctorHosts, "hop", []string{}, "SSH host in format host:port to look up in known_hosts (can specify multiple)")
+	doctorCmd.Flags().IntSliceVar(&doctorPorts, "port", []int{}, "local port that must be free to bind (can specify multiple)")
+	doctorCmd.Flags().StringVar(&doctorKnownHosts, "known-hosts", "", "known_hosts file (default: ~/.ssh/known_hosts)")
+	doctorCmd.Flags().StringVar(&doctorToken, "token", "", "API token to verify (default: token from config or tunnelctl auth login)")
+}
+
+// checkResult is the outcome of a single doctor check
+type checkResult int
+
+const (
+	checkOK checkResult = iota
+	checkWarn
+	checkFail
+)
+
+// doctorReport prints check results and counts failures
+type doctorReport struct {
+	out      io.Writer
+	failures int
+	warnings int
+}
+
+// add prints a check result with an optional fix hint
+func (r *doctorReport) add(result checkResult, message, fix string) {
+	symbol := "✓"
+	switch result {
+	case checkWarn:
+		symbol = "!"
+		r.warnings++
+	case checkFail:
+		symbol = "✗"
+		r.failures++
+	}
+
+	fmt.Fprintf(r.out, "  %s %s\n", symbol, message)
+	if fix != "" && result != checkOK {
+		fmt.Fprintf(r.out, "      fix: %s\n", fix)
+	}
+}
+
+// section prints a heading for a group of checks
+func (r *doctorReport) section(title string) {
+	fmt.Fprintf(r.out, "\n%s\n", title)
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	report := &doctorReport{out: cmd.OutOrStdout()}
+
+	home, _ := os.UserHomeDir()
+
+	report.section("SSH agent")
+	checkSSHAgent(report)
+
+	report.section("SSH keys")
+	keys := doctorKeys
+	if len(keys) == 0 {
+		keys = defaultKeyPaths(home)
+	}
+	checkKeyFiles(report, keys, len(doctorKeys) > 0)
+
+	if len(doctorHosts) > 0 {
+		report.section("Known hosts")
+		knownHostsPath := doctorKnownHosts
+		if knownHostsPath == "" {
+			knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		checkKnownHosts(report, expandHome(knownHostsPath), doctorHosts)
+	}
+
+	report.section("Server")
+	token := doctorToken
+	if token == "" {
+		token = resolveToken()
+	}
+	checkServer(report, viper.GetString("server"), token)
+
+	if len(doctorPorts) > 0 {
+		report.section("Local ports")
+		checkLocalPorts(report, doctorPorts)
+	}
+
+	fmt.Fprintln(report.out)
+	if report.failures > 0 {
+		return fmt.Errorf("%d check(s) failed, %d warning(s)", report.failures, report.warnings)
+	}
+	fmt.Fprintf(report.out, "All checks passed (%d warning(s))\n", report.warnings)
+	return nil
+}
+
+// checkSSHAgent verifies SSH_AUTH_SOCK (or the Windows OpenSSH agent's
+// named pipe) points at an agent holding keys
+func checkSSHAgent(report *doctorReport) {
+	if tunnel.AgentSocket() == "" {
+		report.add(checkWarn, "SSH_AUTH_SOCK is not set (only needed for agent authentication)",
+			`eval "$(ssh-agent -s)" && ssh-add`)
+		return
+	}
+
+	conn, err := tunnel.DialAgent()
+	if err != nil {
+		fix := `start a new agent with eval "$(ssh-agent -s)"`
+		if runtime.GOOS == "windows" {
+			fix = "start the OpenSSH agent service with Start-Service ssh-agent (as administrator)"
+		}
+		report.add(checkFail, fmt.Sprintf("cannot connect to SSH agent: %v", err), fix)
+		return
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		report.add(checkFail, fmt.Sprintf("SSH agent did not answer: %v", err), "restart the SSH agent")
+		return
+	}
+	if len(keys) == 0 {
+		report.add(checkWarn, "SSH agent is running but holds no keys", "ssh-add ~/.ssh/id_ed25519")
+		return
+	}
+	report.add(checkOK, fmt.Sprintf("SSH agent is reachable and holds %d key(s)", len(keys)), "")
+}
+
+// defaultKeyPaths returns the private keys ssh tries by default
+func defaultKeyPaths(home string) []string {
+	return []string{
+		filepath.Join(home, ".ssh", "id_ed25519"),
+		filepath.Join(home, ".ssh", "id_ecdsa"),
+		filepath.Join(home, ".ssh", "id_rsa"),
+	}
+}
+
+// checkKeyFiles verifies keys are readable, private and parseable. Missing
+// default keys are skipped; missing keys the user asked for are failures.
+func checkKeyFiles(report *doctorReport, paths []string, explicit bool) {
+	found := 0
+
+	for _, path := range paths {
+		path = expandHome(path)
+
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			if explicit {
+				report.add(checkFail, fmt.Sprintf("%s does not exist", path), "check the path passed with --key")
+			}
+			continue
+		}
+		if err != nil {
+			report.add(checkFail, fmt.Sprintf("%s: %v", path, err), "")
+			continue
+		}
+		found++
+
+		// ssh refuses keys other users can read
+		if perm := info.Mode().Perm(); perm&0077 != 0 {
+			report.add(checkFail, fmt.Sprintf("%s has permissions %04o, other users can access it", path, perm),
+				"chmod 600 "+path)
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			report.add(checkFail, fmt.Sprintf("%s is not readable: %v", path, err), "chown $USER "+path)
+			continue
+		}
+
+		_, err = ssh.ParsePrivateKey(data)
+		var passphraseErr *ssh.PassphraseMissingError
+		switch {
+		case errors.As(err, &passphraseErr):
+			report.add(checkWarn, fmt.Sprintf("%s is passphrase protected; the server can't use it unattended", path),
+				"load it into the agent with ssh-add "+path+" and use agent authentication")
+		case err != nil:
+			report.add(checkFail, fmt.Sprintf("%s is not a valid private key: %v", path, err),
+				"point --key at the private key, not the .pub file")
+		default:
+			report.add(checkOK, fmt.Sprintf("%s is a valid private key", path), "")
+		}
+	}
+
+	if found == 0 && !explicit {
+		report.add(checkWarn, "no default SSH keys found in ~/.ssh", `ssh-keygen -t ed25519`)
+	}
+}
+
+// checkKnownHosts verifies each host has an entry in the known_hosts file
+func checkKnownHosts(report *doctorReport, path string, hosts []string) {
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		report.add(checkFail, fmt.Sprintf("cannot read %s: %v", path, err), "touch "+path+" && chmod 600 "+path)
+		return
+	}
+
+	// Probe with a throwaway key: a known host reports a key mismatch,
+	// an unknown one reports no expected keys at all
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		report.add(checkFail, fmt.Sprintf("cannot generate probe key: %v", err), "")
+		return
+	}
+	probe, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		report.add(checkFail, fmt.Sprintf("cannot generate probe key: %v", err), "")
+		return
+	}
+
+	for _, hop := range hosts {
+		host, port := splitHostPort(hop, 22)
+		address := net.JoinHostPort(host, strconv.Itoa(port))
+		remote := &net.TCPAddr{IP: net.IPv4zero, Port: port}
+
+		err := callback(address, remote, probe)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) > 0 {
+			report.add(checkOK, fmt.Sprintf("%s has a known_hosts entry", address), "")
+			continue
+		}
+
+		keyscan := fmt.Sprintf("ssh-keyscan -p %d %s >> %s", port, host, path)
+		report.add(checkFail, fmt.Sprintf("%s has no known_hosts entry; strict host key verification will fail", address),
+			keyscan+" (verify the fingerprint out of band)")
+	}
+}
+
+// checkServer verifies the server answers and accepts the token
+func checkServer(report *doctorReport, serverURL, token string) {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	resp, err := client.Get(serverURL + "/api/v1/health")
+	if err != nil {
+		report.add(checkFail, fmt.Sprintf("cannot reach server at %s: %v", serverURL, err),
+			"start lazytunnel-server or pass --server with the right address")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		report.add(checkWarn, fmt.Sprintf("server at %s reports health status %d", serverURL, resp.StatusCode),
+			"check the server logs")
+	} else {
+		report.add(checkOK, fmt.Sprintf("server at %s is reachable", serverURL), "")
+	}
+
+	// A protected endpoint tells whether the token is accepted
+	req, err := http.NewRequest(http.MethodGet, serverURL+"/api/v1/tunnels", nil)
+	if err != nil {
+		report.add(checkFail, fmt.Sprintf("invalid server address %s: %v", serverURL, err), "")
+		return
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err = client.Do(req)
+	if err != nil {
+		report.add(checkFail, fmt.Sprintf("cannot query tunnels: %v", err), "")
+		return
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized && token == "":
+		report.add(checkFail, "server requires authentication and no token is configured",
+			"run tunnelctl auth login, or pass --token or set token in ~/.tunnelctl.yaml")
+	case resp.StatusCode == http.StatusUnauthorized:
+		report.add(checkFail, "server rejected the token (invalid or expired)", "run tunnelctl auth login to get a fresh token")
+	case resp.StatusCode == http.StatusOK && token == "":
+		report.add(checkOK, "server accepts requests without a token (authentication disabled)", "")
+	case resp.StatusCode == http.StatusOK:
+		report.add(checkOK, "token is valid", "")
+	default:
+		report.add(checkWarn, fmt.Sprintf("unexpected status %d listing tunnels", resp.StatusCode), "check the server logs")
+	}
+}
+
+// checkLocalPorts verifies each port can be bound on the loopback interface
+func checkLocalPorts(report *doctorReport, ports []int) {
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			report.add(checkFail, fmt.Sprintf("%d is not a valid port", port), "")
+			continue
+		}
+
+		listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			fix := fmt.Sprintf("find the process with lsof -iTCP:%d -sTCP:LISTEN or pick another --local-port", port)
+			if port < 1024 {
+				fix = "ports below 1024 need root; pick a port of 1024 or higher"
+			}
+			report.add(checkFail, fmt.Sprintf("port %d is not free: %v", port, err), fix)
+			continue
+		}
+		listener.Close()
+		report.add(checkOK, fmt.Sprintf("port %d is free", port), "")
+	}
+}
+
+// expandHome replaces a leading ~ with the home directory and, on Windows,
+// expands %VAR% references such as %USERPROFILE%
+func expandHome(path string) string {
+	expanded, err := tunnel.ExpandPath(path)
+	if err != nil {
+		return path
+	}
+	return expanded
+}
+
+// splitHostPort splits host[:port], using defaultPort when none is given
+func splitHostPort(hostport string, defaultPort int) (string, int) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport, defaultPort
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return host, defaultPort
+	}
+	return host, port
+}
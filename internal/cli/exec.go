@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	execHop     int
+	execTimeout time.Duration
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec <tunnel> -- <command> [args...]",
+	Short: "Run a command on a hop over a tunnel's SSH connection",
+	Long: `Run a one-off command on one of a tunnel's hops through its existing SSH
+connection, streaming the output. Useful for debugging from the bastion's
+point of view. The tunnel must be connected, and the server only allows this
+for admins. tunnelctl exits with the command's exit status.
+
+Examples:
+  # Check which ports are listening on the bastion
+  tunnelctl exec prod-db -- ss -ltn
+
+  # Run on the first hop of a multi-hop tunnel with a longer timeout
+  tunnelctl exec prod-db --hop 0 --timeout 2m -- journalctl -u sshd -n 50`,
+	Args:         cobra.MinimumNArgs(2),
+	RunE:         runExec,
+	SilenceUsage: true,
+}
+
+func init() {
+	execCmd.Flags().IntVar(&execHop, "hop", -1, "hop to run the command on, counting from 0 (default: last hop)")
+	execCmd.Flags().DurationVar(&execTimeout, "timeout", 30*time.Second, "kill the command after this long (max 10m)")
+}
+
+// execEvent is one line of the server's streamed response
+type execEvent struct {
+	Stream   string `json:"stream"`
+	Data     string `json:"data"`
+	ExitCode *int   `json:"exitCode"`
+	TimedOut bool   `json:"timedOut"`
+	Error    string `json:"error"`
+}
+
+func runExec(cmd *cobra.Command, args []string) error {
+	serverURL := viper.GetString("server")
+	t, err := fetchTunnel(serverURL, args[0])
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"command":        strings.Join(args[1:], " "),
+		"timeoutSeconds": int((execTimeout + time.Second - 1) / time.Second),
+	}
+	if execHop >= 0 {
+		payload["hop"] = execHop
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s/api/v1/tunnels/%s/exec", serverURL, url.PathEscape(t.ID)), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to run command: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to run command: %s", string(respBody))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var event execEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+
+		switch {
+		case event.Stream == "stdout":
+			io.WriteString(os.Stdout, event.Data)
+		case event.Stream == "stderr":
+			io.WriteString(os.Stderr, event.Data)
+		case event.Error != "":
+			return fmt.Errorf("%s", event.Error)
+		case event.ExitCode != nil:
+			if *event.ExitCode != 0 {
+				os.Exit(*event.ExitCode)
+			}
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read output: %w", err)
+	}
+	return fmt.Errorf("connection closed before the command finished")
+}
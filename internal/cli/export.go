@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	exportFormat string
+	exportOutput string
+	exportMine   bool
+	exportOwner  string
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export tunnel configurations as a bundle",
+	Long: `Export the configuration of every tunnel as a portable bundle, to back
+it up or move it to another server with 'tunnelctl import'. Bundles hold no
+secrets: SSH keys are referenced by ID and must exist on the target server.
+
+Examples:
+  tunnelctl export > tunnels.json
+  tunnelctl export -o tunnels.yaml
+  tunnelctl export --mine --format yaml`,
+	RunE: runExport,
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportFormat, "format", "", "bundle format: json or yaml (default from the --output extension, else json)")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "write the bundle to this file instead of stdout")
+	exportCmd.Flags().BoolVar(&exportMine, "mine", false, "only export tunnels you own")
+	exportCmd.Flags().StringVar(&exportOwner, "owner", "", "only export tunnels owned by this user")
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	format := exportFormat
+	if format == "" {
+		format = "json"
+		if isYAMLFile(exportOutput) {
+			format = "yaml"
+		}
+	}
+
+	query := url.Values{"format": {format}}
+	if exportMine {
+		query.Set("mine", "true")
+	}
+	if exportOwner != "" {
+		query.Set("owner", exportOwner)
+	}
+	endpoint := fmt.Sprintf("%s/api/v1/export?%s", viper.GetString("server"), query.Encode())
+
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to export tunnels: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to export tunnels: %s", string(body))
+	}
+
+	if exportOutput == "" {
+		_, err := cmd.OutOrStdout().Write(body)
+		return err
+	}
+	if err := os.WriteFile(exportOutput, body, 0o644); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	fmt.Printf("✓ Tunnels exported to %s\n", exportOutput)
+
+	return nil
+}
+
+// isYAMLFile reports whether a path has a YAML extension
+func isYAMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
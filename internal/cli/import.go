@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var importStrategy string
+
+var importCmd = &cobra.Command{
+	Use:   "import [bundle-file]",
+	Short: "Import tunnel configurations from a bundle",
+	Long: `Create the tunnels of a bundle written by 'tunnelctl export'. YAML is
+detected from the .yaml or .yml extension; use - to read JSON from stdin.
+
+Tunnels whose name is already in use are skipped with --strategy merge (the
+default), or replace the existing tunnel with --strategy overwrite.
+
+Examples:
+  tunnelctl import tunnels.json
+  tunnelctl import tunnels.yaml --strategy overwrite`,
+	Args: cobra.ExactArgs(1),
+	RunE: runImport,
+}
+
+func init() {
+	importCmd.Flags().StringVar(&importStrategy, "strategy", "merge", "what to do with tunnels whose name exists: merge (skip them) or overwrite")
+}
+
+// importResult is the server's report of an import
+type importResult struct {
+	Created  []string `json:"created"`
+	Replaced []string `json:"replaced"`
+	Skipped  []string `json:"skipped"`
+	Failed   []struct {
+		Name  string `json:"name"`
+		Error string `json:"error"`
+	} `json:"failed"`
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	path := args[0]
+
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	contentType := "application/json"
+	if isYAMLFile(path) {
+		contentType = "application/yaml"
+	}
+
+	query := url.Values{"strategy": {importStrategy}}
+	endpoint := fmt.Sprintf("%s/api/v1/import?%s", viper.GetString("server"), query.Encode())
+
+	resp, err := postIdempotent(endpoint, contentType, data)
+	if err != nil {
+		return fmt.Errorf("failed to import tunnels: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to import tunnels: %s", string(body))
+	}
+
+	var result importResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	for _, name := range result.Created {
+		fmt.Printf("✓ Created %s\n", name)
+	}
+	for _, name := range result.Replaced {
+		fmt.Printf("✓ Replaced %s\n", name)
+	}
+	for _, name := range result.Skipped {
+		fmt.Printf("- Skipped %s (name in use)\n", name)
+	}
+	for _, failure := range result.Failed {
+		fmt.Printf("✗ Failed %s: %s\n", failure.Name, failure.Error)
+	}
+
+	fmt.Printf("\nCreated: %d, replaced: %d, skipped: %d, failed: %d\n",
+		len(result.Created), len(result.Replaced), len(result.Skipped), len(result.Failed))
+
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("%d tunnel(s) failed to import", len(result.Failed))
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"text/tabwriter"
 	"time"
 
@@ -12,18 +13,54 @@ import (
 	"github.com/spf13/viper"
 )
 
+var (
+	listMine  bool
+	listOwner string
+)
+
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all active tunnels",
-	Long:  `List all currently active SSH tunnels on the server.`,
-	RunE:  runList,
+	Long: `List all currently active SSH tunnels on the server.
+
+Examples:
+  tunnelctl list
+  tunnelctl list --mine
+  tunnelctl list --owner alice`,
+	RunE: runList,
+}
+
+func init() {
+	listCmd.Flags().BoolVar(&listMine, "mine", false, "only list tunnels you own")
+	listCmd.Flags().StringVar(&listOwner, "owner", "", "only list tunnels owned by this user")
+}
+
+// tunnelSummary holds the fields of a tunnel that list prints
+type tunnelSummary struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Owner     string `json:"owner"`
+	Type      string `json:"type"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"createdAt"`
 }
 
 func runList(cmd *cobra.Command, args []string) error {
 	serverURL := viper.GetString("server")
-	url := fmt.Sprintf("%s/api/v1/tunnels", serverURL)
 
-	resp, err := http.Get(url)
+	query := url.Values{}
+	if listMine {
+		query.Set("mine", "true")
+	}
+	if listOwner != "" {
+		query.Set("owner", listOwner)
+	}
+	endpoint := fmt.Sprintf("%s/api/v1/tunnels", serverURL)
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	resp, err := http.Get(endpoint)
 	if err != nil {
 		return fmt.Errorf("failed to list tunnels: %w", err)
 	}
@@ -35,16 +72,11 @@ func runList(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to list tunnels: %s", string(body))
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
+	var tunnels []tunnelSummary
+	if err := json.Unmarshal(body, &tunnels); err != nil {
 		return fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	tunnels, ok := result["tunnels"].([]interface{})
-	if !ok {
-		return fmt.Errorf("invalid response format")
-	}
-
 	if len(tunnels) == 0 {
 		fmt.Println("No active tunnels")
 		return nil
@@ -52,27 +84,18 @@ func runList(cmd *cobra.Command, args []string) error {
 
 	// Print table
 	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 3, ' ', 0)
-	fmt.Fprintln(w, "ID\tNAME\tTYPE\tSTATE\tCREATED")
-	fmt.Fprintln(w, "──\t────\t────\t─────\t───────")
-
-	for _, t := range tunnels {
-		tunnel := t.(map[string]interface{})
-
-		id := tunnel["id"].(string)
-		name := tunnel["name"].(string)
-		ttype := tunnel["type"].(string)
-
-		status := tunnel["status"].(map[string]interface{})
-		state := status["state"].(string)
-
-		createdAt := tunnel["created_at"].(string)
-		created, _ := time.Parse(time.RFC3339, createdAt)
-
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
-			truncate(id, 8),
-			name,
-			ttype,
-			state,
+	fmt.Fprintln(w, "ID\tNAME\tOWNER\tTYPE\tSTATE\tCREATED")
+	fmt.Fprintln(w, "──\t────\t─────\t────\t─────\t───────")
+
+	for _, tunnel := range tunnels {
+		created, _ := time.Parse(time.RFC3339, tunnel.CreatedAt)
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			truncate(tunnel.ID, 8),
+			tunnel.Name,
+			tunnel.Owner,
+			tunnel.Type,
+			tunnel.Status,
 			created.Format("2006-01-02 15:04"),
 		)
 	}
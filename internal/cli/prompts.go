@@ -0,0 +1,188 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	promptsWatch bool
+	promptsList  bool
+)
+
+// pendingPrompt mirrors a prompt returned by GET /api/v1/prompts
+type pendingPrompt struct {
+	ID          string `json:"id"`
+	TunnelID    string `json:"tunnelId"`
+	Host        string `json:"host"`
+	User        string `json:"user"`
+	Instruction string `json:"instruction"`
+	Questions   []struct {
+		Text string `json:"text"`
+		Echo bool   `json:"echo"`
+	} `json:"questions"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+var promptsCmd = &cobra.Command{
+	Use:   "prompts",
+	Short: "Answer 2FA / keyboard-interactive prompts from SSH hops",
+	Long: `Answer keyboard-interactive challenges (Duo, OTP, ...) that hops created
+with --keyboard-interactive send while a tunnel connects. The connection
+waits until the prompt is answered or expires.
+
+Examples:
+  # Answer whatever is pending now
+  tunnelctl prompts
+
+  # Keep answering as prompts arrive, e.g. during reconnects
+  tunnelctl prompts --watch
+
+  # Only show pending prompts
+  tunnelctl prompts --list`,
+	SilenceUsage: true,
+	RunE:         runPrompts,
+}
+
+func init() {
+	promptsCmd.Flags().BoolVar(&promptsWatch, "watch", false, "keep waiting for new prompts")
+	promptsCmd.Flags().BoolVar(&promptsList, "list", false, "list pending prompts without answering")
+}
+
+func runPrompts(cmd *cobra.Command, args []string) error {
+	serverURL := viper.GetString("server")
+	stdin := bufio.NewReader(os.Stdin)
+	seen := make(map[string]bool)
+
+	for {
+		prompts, err := fetchPrompts(serverURL)
+		if err != nil {
+			return err
+		}
+
+		answered := 0
+		for _, p := range prompts {
+			if seen[p.ID] {
+				continue
+			}
+			seen[p.ID] = true
+
+			if promptsList {
+				printPrompt(p)
+				continue
+			}
+			if err := answerPrompt(serverURL, p, stdin); err != nil {
+				return err
+			}
+			answered++
+		}
+
+		if !promptsWatch {
+			if len(prompts) == 0 {
+				fmt.Println("No pending prompts")
+			}
+			return nil
+		}
+		if answered == 0 {
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// printPrompt describes a prompt without its questions' answers
+func printPrompt(p pendingPrompt) {
+	fmt.Printf("Tunnel %s: %s@%s (expires %s)\n", p.TunnelID, p.User, p.Host, p.ExpiresAt)
+	if p.Instruction != "" {
+		fmt.Printf("  %s\n", strings.ReplaceAll(p.Instruction, "\n", "\n  "))
+	}
+	for _, q := range p.Questions {
+		fmt.Printf("  - %s\n", strings.TrimSpace(q.Text))
+	}
+}
+
+// answerPrompt asks each question on the terminal and submits the answers
+func answerPrompt(serverURL string, p pendingPrompt, stdin *bufio.Reader) error {
+	printPrompt(p)
+
+	answers := make([]string, len(p.Questions))
+	for i, q := range p.Questions {
+		text := q.Text
+		if !q.Echo {
+			text += "(input is visible) "
+		}
+		fmt.Print(text)
+
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read answer: %w", err)
+		}
+		answers[i] = strings.TrimRight(line, "\r\n")
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"answers": answers})
+	if err != nil {
+		return fmt.Errorf("failed to encode answers: %w", err)
+	}
+
+	resp, err := doAPIRequest(http.MethodPost, serverURL+"/api/v1/prompts/"+p.ID, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to submit answers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		fmt.Println("✓ Submitted")
+	case http.StatusNotFound:
+		fmt.Println("✗ Prompt expired before it was answered")
+	default:
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to submit answers: %s", string(respBody))
+	}
+	return nil
+}
+
+// fetchPrompts lists pending prompts
+func fetchPrompts(serverURL string) ([]pendingPrompt, error) {
+	resp, err := doAPIRequest(http.MethodGet, serverURL+"/api/v1/prompts", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list prompts: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list prompts: %s", string(body))
+	}
+
+	var prompts []pendingPrompt
+	if err := json.Unmarshal(body, &prompts); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return prompts, nil
+}
+
+// doAPIRequest sends a request with the configured API token, if any
+func doAPIRequest(method, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token := viper.GetString("token"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var reconnectCmd = &cobra.Command{
+	Use:   "reconnect [tunnel-id-or-name]",
+	Short: "Retry a failed tunnel now",
+	Long: `Retry a failed or reconnecting tunnel right away instead of waiting out its
+backoff, e.g. once the bastion it goes through is back. The tunnel's circuit
+breaker is reset too.`,
+	Args:         cobra.ExactArgs(1),
+	RunE:         runReconnect,
+	SilenceUsage: true,
+}
+
+func runReconnect(cmd *cobra.Command, args []string) error {
+	serverURL := viper.GetString("server")
+	t, err := fetchTunnel(serverURL, args[0])
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(fmt.Sprintf("%s/api/v1/tunnels/%s/reconnect", serverURL, t.ID), "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("failed to reconnect tunnel: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to reconnect tunnel: %s", string(body))
+	}
+
+	fmt.Printf("✓ Reconnecting tunnel: %s\n", args[0])
+	return nil
+}
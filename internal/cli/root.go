@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -46,7 +47,22 @@ func init() {
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(reconnectCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(demoCmd)
+	rootCmd.AddCommand(promptsCmd)
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(udpRelayCmd)
+	rootCmd.AddCommand(stdioCmd)
+	rootCmd.AddCommand(cpCmd)
+	rootCmd.AddCommand(execCmd)
+	rootCmd.AddCommand(benchCmd)
+	rootCmd.AddCommand(topCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(authCmd)
+	rootCmd.AddCommand(authorizeKeyCmd)
+	rootCmd.AddCommand(addressCmd)
 }
 
 func initConfig() {
@@ -73,4 +89,7 @@ func initConfig() {
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
+
+	// Send the API token with every request to the server
+	http.DefaultClient.Transport = &authTransport{base: http.DefaultTransport}
 }
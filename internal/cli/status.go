@@ -5,17 +5,31 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var statusWait time.Duration
+
 var statusCmd = &cobra.Command{
 	Use:   "status [tunnel-id-or-name]",
 	Short: "Get tunnel status",
-	Long:  `Get detailed status information for a specific tunnel.`,
-	Args:  cobra.ExactArgs(1),
-	RunE:  runStatus,
+	Long: `Get detailed status information for a specific tunnel.
+
+With --wait, the server holds the request until the tunnel's status changes
+(or the wait runs out) before it is printed.
+
+Examples:
+  tunnelctl status prod-db
+  tunnelctl status prod-db --wait 60s`,
+	Args: cobra.ExactArgs(1),
+	RunE: runStatus,
+}
+
+func init() {
+	statusCmd.Flags().DurationVar(&statusWait, "wait", 0, "wait up to this long for the status to change (at most 2m)")
 }
 
 func runStatus(cmd *cobra.Command, args []string) error {
@@ -23,6 +37,9 @@ func runStatus(cmd *cobra.Command, args []string) error {
 
 	serverURL := viper.GetString("server")
 	url := fmt.Sprintf("%s/api/v1/tunnels/%s/status", serverURL, tunnelID)
+	if statusWait > 0 {
+		url += "?wait=" + statusWait.String()
+	}
 
 	resp, err := http.Get(url)
 	if err != nil {
@@ -53,7 +70,12 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		fmt.Printf("  Connected: %v\n", connectedAt)
 	}
 
-	if lastError, ok := status["last_error"]; ok && lastError != nil && lastError != "" {
+	if problems, ok := status["problems"].([]interface{}); ok && len(problems) > 0 {
+		fmt.Println("  Problems:")
+		for _, problem := range problems {
+			fmt.Printf("    - %v\n", problem)
+		}
+	} else if lastError, ok := status["last_error"]; ok && lastError != nil && lastError != "" {
 		fmt.Printf("  Last Error: %v\n", lastError)
 	}
 
@@ -64,6 +86,13 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		fmt.Printf("  Retry Count: %v\n", retryCount)
 	}
 
+	if next, ok := status["next_retry_at"].(string); ok {
+		if at, err := time.Parse(time.RFC3339Nano, next); err == nil {
+			fmt.Printf("  Next Retry: in %s (attempt %v of %v)\n",
+				max(time.Until(at), 0).Round(time.Second), status["retry_attempt"], status["max_retry_attempts"])
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,283 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/net/proxy"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+var (
+	stdioTunnel string
+	stdioHops   []string
+	stdioUser   string
+	stdioKey    string
+)
+
+var stdioCmd = &cobra.Command{
+	Use:   "stdio [host:port]",
+	Short: "Connect stdin/stdout to a target through a tunnel",
+	Long: `Connect stdin and stdout to a target through a tunnel, for use as an
+OpenSSH ProxyCommand or by any tool that can talk to a command's pipes.
+
+With --tunnel, the connection goes through a tunnel on the lazytunnel
+server: a local tunnel forwards to its own target, and a dynamic (SOCKS5)
+tunnel forwards to host:port. With --hop, tunnelctl opens its own SSH
+connection through the hops (like ssh -W) and the server is not used.
+
+Examples:
+  # Reach hosts behind a bastion with plain ssh
+  ssh -o ProxyCommand='tunnelctl stdio --hop bastion.example.com:22 --user deploy %h:%p' app.internal
+
+  # Reuse the server's SOCKS tunnel to the private network
+  ssh -o ProxyCommand='tunnelctl stdio --tunnel vpc-socks %h:%p' app.internal
+
+  # Pipe through an existing local tunnel
+  tunnelctl stdio --tunnel prod-db < query.bin`,
+	Args:         cobra.MaximumNArgs(1),
+	RunE:         runStdio,
+	SilenceUsage: true,
+}
+
+func init() {
+	stdioCmd.Flags().StringVar(&stdioTunnel, "tunnel", "", "ID or name of a local or dynamic tunnel on the server")
+	stdioCmd.Flags().StringArrayVar(&stdioHops, "hop", []string{}, "SSH hop in format host:port to connect through directly (can specify multiple for multi-hop)")
+	stdioCmd.Flags().StringVar(&stdioUser, "user", os.Getenv("USER"), "SSH username (with --hop)")
+	stdioCmd.Flags().StringVar(&stdioKey, "key", "", "path to SSH private key (with --hop; default: SSH agent, then ~/.ssh/id_rsa)")
+}
+
+func runStdio(cmd *cobra.Command, args []string) error {
+	var target string
+	if len(args) == 1 {
+		target = args[0]
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return fmt.Errorf("invalid target %s (expected host:port)", target)
+		}
+	}
+
+	var conn net.Conn
+	var err error
+	switch {
+	case stdioTunnel != "" && len(stdioHops) > 0:
+		return fmt.Errorf("use either --tunnel or --hop, not both")
+	case stdioTunnel != "":
+		conn, err = dialViaTunnel(stdioTunnel, target)
+	case len(stdioHops) > 0:
+		if target == "" {
+			return fmt.Errorf("host:port is required with --hop")
+		}
+		var session io.Closer
+		conn, session, err = dialViaHops(cmd.Context(), stdioHops, target)
+		if err == nil {
+			defer session.Close()
+		}
+	default:
+		return fmt.Errorf("--tunnel or --hop is required")
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return pipeStdio(conn, os.Stdin, os.Stdout)
+}
+
+// dialViaTunnel connects to the listener of a tunnel on the server
+func dialViaTunnel(idOrName, target string) (net.Conn, error) {
+	serverURL := viper.GetString("server")
+	t, err := fetchTunnel(serverURL, idOrName)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Status != "active" {
+		return nil, fmt.Errorf("tunnel %s is not active (status: %s)", t.Name, t.Status)
+	}
+	if t.BoundAddress == "" {
+		return nil, fmt.Errorf("tunnel %s has no single listener to connect to", t.Name)
+	}
+	addr, err := reachableAddr(t.BoundAddress, serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch types.TunnelType(t.Type) {
+	case types.TunnelTypeLocal:
+		if target != "" {
+			return nil, fmt.Errorf("tunnel %s always forwards to %s:%d; omit host:port", t.Name, t.RemoteHost, t.RemotePort)
+		}
+		return net.Dial("tcp", addr)
+
+	case types.TunnelTypeDynamic:
+		if target == "" {
+			return nil, fmt.Errorf("host:port is required for dynamic tunnel %s", t.Name)
+		}
+		dialer, err := proxy.SOCKS5("tcp", addr, nil, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("failed to use SOCKS proxy %s: %w", addr, err)
+		}
+		return dialer.Dial("tcp", target)
+
+	default:
+		return nil, fmt.Errorf("tunnel %s is a %s tunnel; stdio needs a local or dynamic tunnel", t.Name, t.Type)
+	}
+}
+
+// stdioTunnelInfo holds the tunnel fields stdio needs from the API
+type stdioTunnelInfo struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	Status       string `json:"status"`
+	RemoteHost   string `json:"remoteHost"`
+	RemotePort   int    `json:"remotePort"`
+	BoundAddress string `json:"boundAddress"`
+}
+
+// fetchTunnel looks a tunnel up by ID, falling back to a match on name
+func fetchTunnel(serverURL, idOrName string) (*stdioTunnelInfo, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/api/v1/tunnels/%s", serverURL, url.PathEscape(idOrName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tunnel: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var t stdioTunnelInfo
+		if err := json.Unmarshal(body, &t); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+		return &t, nil
+	case http.StatusNotFound:
+	default:
+		return nil, fmt.Errorf("failed to get tunnel: %s", string(body))
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/api/v1/tunnels", serverURL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tunnels: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list tunnels: %s", string(body))
+	}
+
+	var tunnels []stdioTunnelInfo
+	if err := json.Unmarshal(body, &tunnels); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	for i := range tunnels {
+		if tunnels[i].Name == idOrName {
+			return &tunnels[i], nil
+		}
+	}
+	return nil, fmt.Errorf("tunnel not found: %s", idOrName)
+}
+
+// reachableAddr turns a listener address on the server into one this
+// machine can dial, using the server's host for wildcard binds
+func reachableAddr(boundAddr, serverURL string) (string, error) {
+	host, port, err := net.SplitHostPort(boundAddr)
+	if err != nil {
+		return "", fmt.Errorf("invalid bound address %s: %w", boundAddr, err)
+	}
+
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid server address %s: %w", serverURL, err)
+		}
+		host = u.Hostname()
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// dialViaHops opens an SSH connection through hops and dials target over it.
+// The returned closer ends the SSH connection.
+func dialViaHops(ctx context.Context, hopSpecs []string, target string) (net.Conn, io.Closer, error) {
+	authMethod := types.AuthMethodKey
+	keyID := stdioKey
+	if keyID == "" {
+		if tunnel.AgentSocket() != "" {
+			authMethod = types.AuthMethodAgent
+		} else {
+			keyID = defaultKeyPath()
+		}
+	}
+
+	hopList := make([]types.Hop, len(hopSpecs))
+	for i, h := range hopSpecs {
+		hop, err := parseHop(h, stdioUser, authMethod, keyID)
+		if err != nil {
+			return nil, nil, err
+		}
+		hopList[i] = hop
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var session interface {
+		tunnel.SessionDialer
+		Connect() error
+		Close() error
+	}
+	if len(hopList) == 1 {
+		single, err := tunnel.NewSession(ctx, tunnel.SessionConfig{Hop: &hopList[0], Passphrase: storedPassphrase})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create session: %w", err)
+		}
+		session = single
+	} else {
+		multi, err := tunnel.NewMultiHopSession(ctx, hopList, tunnel.SessionConfig{Passphrase: storedPassphrase})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create multi-hop session: %w", err)
+		}
+		session = multi
+	}
+
+	if err := session.Connect(); err != nil {
+		session.Close()
+		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	conn, err := session.Dial("tcp", target)
+	if err != nil {
+		session.Close()
+		return nil, nil, fmt.Errorf("failed to dial %s: %w", target, err)
+	}
+	return conn, session, nil
+}
+
+// pipeStdio copies in to conn and conn to out until the remote side closes.
+// End of input is passed on as a half-close so the target can still reply.
+func pipeStdio(conn net.Conn, in io.Reader, out io.Writer) error {
+	go func() {
+		_, _ = io.Copy(conn, in)
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		}
+	}()
+
+	if _, err := io.Copy(out, conn); err != nil {
+		return fmt.Errorf("connection closed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	topInterval   time.Duration
+	topSort       string
+	topIterations int
+)
+
+var topCmd = &cobra.Command{
+	Use:   "top [tunnel]",
+	Short: "Live traffic view of tunnels and their connections",
+	Long: `Show the byte rates of each tunnel and of each of its active connections,
+refreshed every interval, to find which tunnel is saturating a link. OUT is
+traffic sent through the tunnel towards its destination, IN is traffic coming
+back. Rates are computed between refreshes, so the first one shows totals only.
+
+Give a tunnel's name or ID to only show that tunnel's connections.
+
+Examples:
+  tunnelctl top
+  tunnelctl top prod-db
+  tunnelctl top --sort total --interval 5s`,
+	Args:         cobra.MaximumNArgs(1),
+	RunE:         runTop,
+	SilenceUsage: true,
+}
+
+func init() {
+	topCmd.Flags().DurationVar(&topInterval, "interval", time.Second, "time between refreshes")
+	topCmd.Flags().StringVar(&topSort, "sort", "rate", "sort by rate, total or name")
+	topCmd.Flags().IntVarP(&topIterations, "iterations", "n", 0, "refreshes before exiting (default: run until interrupted)")
+}
+
+// trafficSnapshot is the server's traffic response
+type trafficSnapshot struct {
+	Timestamp time.Time `json:"timestamp"`
+	Tunnels   []struct {
+		ID            string `json:"id"`
+		Name          string `json:"name"`
+		Status        string `json:"status"`
+		BytesSent     int64  `json:"bytesSent"`
+		BytesReceived int64  `json:"bytesReceived"`
+		ActiveConns   int64  `json:"activeConns"`
+		Connections   []struct {
+			ID            uint64    `json:"id"`
+			Source        string    `json:"source"`
+			Target        string    `json:"target"`
+			StartedAt     time.Time `json:"startedAt"`
+			BytesSent     int64     `json:"bytesSent"`
+			BytesReceived int64     `json:"bytesReceived"`
+		} `json:"connections"`
+	} `json:"tunnels"`
+}
+
+// topRow is one tunnel or connection line of the view
+type topRow struct {
+	key     string
+	name    string
+	columns []string
+	sent    int64
+	recv    int64
+	outRate float64
+	inRate  float64
+	known   bool // seen in the previous snapshot, so its rates are meaningful
+}
+
+func runTop(cmd *cobra.Command, args []string) error {
+	switch topSort {
+	case "rate", "total", "name":
+	default:
+		return fmt.Errorf("invalid --sort %q: use rate, total or name", topSort)
+	}
+	if topInterval < 100*time.Millisecond {
+		return fmt.Errorf("--interval must be at least 100ms")
+	}
+
+	serverURL := viper.GetString("server")
+	filter := ""
+	if len(args) == 1 {
+		t, err := fetchTunnel(serverURL, args[0])
+		if err != nil {
+			return err
+		}
+		filter = t.ID
+	}
+
+	var prev *trafficSnapshot
+	for i := 0; topIterations == 0 || i < topIterations; i++ {
+		if i > 0 {
+			time.Sleep(topInterval)
+		}
+		snap, err := fetchTraffic(serverURL)
+		if err != nil {
+			return err
+		}
+		tunnels, conns := topRows(prev, snap, filter)
+		prev = snap
+
+		// Clear the screen and draw from its top
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("tunnelctl top - %s - every %s, sorted by %s\n\n", snap.Timestamp.Local().Format("15:04:05"), topInterval, topSort)
+		printTopTable(tunnels, "NAME\tSTATE\tCONNS\tOUT/s\tIN/s\tTOTAL")
+		fmt.Println()
+		printTopTable(conns, "TUNNEL\tSOURCE\tTARGET\tAGE\tOUT/s\tIN/s\tTOTAL")
+	}
+	return nil
+}
+
+func fetchTraffic(serverURL string) (*trafficSnapshot, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/api/v1/traffic", serverURL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get traffic: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get traffic: %s", string(body))
+	}
+	var snap trafficSnapshot
+	if err := json.Unmarshal(body, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &snap, nil
+}
+
+// topRows builds the tunnel and connection rows of snap, with rates
+// against prev, keeping only the tunnel filter when set
+func topRows(prev, snap *trafficSnapshot, filter string) (tunnels, conns []*topRow) {
+	previous := map[string][2]int64{}
+	var elapsed float64
+	if prev != nil {
+		elapsed = snap.Timestamp.Sub(prev.Timestamp).Seconds()
+		for _, t := range prev.Tunnels {
+			previous["t"+t.ID] = [2]int64{t.BytesSent, t.BytesReceived}
+			for _, c := range t.Connections {
+				previous[fmt.Sprintf("c%d", c.ID)] = [2]int64{c.BytesSent, c.BytesReceived}
+			}
+		}
+	}
+	rated := func(row *topRow) *topRow {
+		if last, ok := previous[row.key]; ok && elapsed > 0 {
+			row.known = true
+			row.outRate = float64(max(row.sent-last[0], 0)) / elapsed
+			row.inRate = float64(max(row.recv-last[1], 0)) / elapsed
+		}
+		return row
+	}
+
+	for _, t := range snap.Tunnels {
+		if filter != "" && t.ID != filter {
+			continue
+		}
+		tunnels = append(tunnels, rated(&topRow{
+			key:     "t" + t.ID,
+			name:    t.Name,
+			columns: []string{t.Name, t.Status, fmt.Sprint(t.ActiveConns)},
+			sent:    t.BytesSent,
+			recv:    t.BytesReceived,
+		}))
+		for _, c := range t.Connections {
+			age := snap.Timestamp.Sub(c.StartedAt).Round(time.Second)
+			conns = append(conns, rated(&topRow{
+				key:     fmt.Sprintf("c%d", c.ID),
+				name:    t.Name + " " + c.Source,
+				columns: []string{t.Name, c.Source, c.Target, age.String()},
+				sent:    c.BytesSent,
+				recv:    c.BytesReceived,
+			}))
+		}
+	}
+	sortTopRows(tunnels)
+	sortTopRows(conns)
+	return tunnels, conns
+}
+
+func sortTopRows(rows []*topRow) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		switch topSort {
+		case "rate":
+			if ra, rb := a.outRate+a.inRate, b.outRate+b.inRate; ra != rb {
+				return ra > rb
+			}
+		case "total":
+			if ta, tb := a.sent+a.recv, b.sent+b.recv; ta != tb {
+				return ta > tb
+			}
+		}
+		return a.name < b.name
+	})
+}
+
+func printTopTable(rows []*topRow, header string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	for _, row := range rows {
+		out, in := "-", "-"
+		if row.known {
+			out, in = formatBytes(row.outRate)+"/s", formatBytes(row.inRate)+"/s"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", strings.Join(row.columns, "\t"), out, in, formatBytes(float64(row.sent+row.recv)))
+	}
+	w.Flush()
+}
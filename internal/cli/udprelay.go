@@ -0,0 +1,21 @@
+package cli
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+)
+
+// udpRelayCmd is run on the SSH server by UDP tunnels; it is not meant to be
+// invoked by hand
+var udpRelayCmd = &cobra.Command{
+	Use:    "udp-relay <host:port>",
+	Short:  "Relay framed UDP datagrams from stdin to a target",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return tunnel.ServeUDPRelay(os.Stdin, os.Stdout, args[0])
+	},
+}
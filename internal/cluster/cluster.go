@@ -0,0 +1,211 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// leaderLease is the lease held by the instance that runs the tunnels
+const leaderLease = "leader"
+
+// DefaultLeaseTTL is how long the leader's lease lasts without a renewal
+const DefaultLeaseTTL = 15 * time.Second
+
+// Store is the shared storage instances coordinate through
+type Store interface {
+	Heartbeat(ctx context.Context, instance *types.Instance) error
+	ListInstances(ctx context.Context) ([]*types.Instance, error)
+	RemoveInstance(ctx context.Context, id string) error
+	// AcquireLease takes or renews a lease for ttl, unless another holder
+	// has it and it hasn't expired, and reports whether holder now has it
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+	// LeaseHolder returns the lease's unexpired holder, or "" if there is none
+	LeaseHolder(ctx context.Context, name string) (string, error)
+}
+
+// Manager is the part of the tunnel manager a Node drives
+type Manager interface {
+	SetStandby(standby bool)
+	SyncFromStorage(ctx context.Context) error
+}
+
+// Node is one of several server instances sharing a storage. The instance
+// holding the leader lease runs the tunnels; the others stand by, serving
+// the API from the shared storage, and one of them takes over once the
+// leader stops renewing its lease.
+type Node struct {
+	store   Store
+	manager Manager
+	ttl     time.Duration
+	logger  zerolog.Logger
+
+	mu        sync.RWMutex
+	instance  types.Instance
+	leader    bool
+	lastRenew time.Time // when the lease was last renewed
+	stopped   chan struct{}
+	leaveOnce sync.Once
+	tickMu    sync.Mutex // keeps a round of Run from overlapping Leave
+}
+
+// New creates a node for the instance with the given ID. The manager is put
+// on standby until the node wins the leader lease.
+func New(store Store, manager Manager, id, address string, ttl time.Duration, logger zerolog.Logger) *Node {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	manager.SetStandby(true)
+
+	return &Node{
+		store:   store,
+		manager: manager,
+		ttl:     ttl,
+		logger:  logger.With().Str("instance", id).Logger(),
+		instance: types.Instance{
+			ID:        id,
+			Address:   address,
+			StartedAt: time.Now(),
+		},
+		stopped: make(chan struct{}),
+	}
+}
+
+// ID returns the instance's ID
+func (n *Node) ID() string {
+	return n.instance.ID
+}
+
+// IsLeader reports whether this instance holds the leader lease
+func (n *Node) IsLeader() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.leader
+}
+
+// Leader returns the ID of the instance holding the leader lease, or "" if
+// none currently does
+func (n *Node) Leader(ctx context.Context) (string, error) {
+	if n.IsLeader() {
+		return n.instance.ID, nil
+	}
+	return n.store.LeaseHolder(ctx, leaderLease)
+}
+
+// Instances lists the instances that have sent a heartbeat recently
+func (n *Node) Instances(ctx context.Context) ([]*types.Instance, error) {
+	all, err := n.store.ListInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	alive := make([]*types.Instance, 0, len(all))
+	for _, instance := range all {
+		if instance.Alive(now, n.ttl) {
+			alive = append(alive, instance)
+		}
+	}
+	return alive, nil
+}
+
+// Run heartbeats, competes for the leader lease and keeps the manager in
+// step with the storage until ctx is cancelled or Leave is called
+func (n *Node) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		n.tick(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.stopped:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick runs one round of Run
+func (n *Node) tick(ctx context.Context, now time.Time) {
+	n.tickMu.Lock()
+	defer n.tickMu.Unlock()
+	select {
+	case <-n.stopped:
+		return
+	default:
+	}
+
+	n.mu.Lock()
+	n.instance.HeartbeatAt = now
+	instance := n.instance
+	n.mu.Unlock()
+
+	if err := n.store.Heartbeat(ctx, &instance); err != nil {
+		n.logger.Warn().Err(err).Msg("Failed to send heartbeat")
+	}
+
+	acquired, err := n.store.AcquireLease(ctx, leaderLease, instance.ID, n.ttl)
+	if err != nil {
+		// The lease may still be ours; only give up on it once it has
+		// certainly expired, so a storage hiccup doesn't cause a failover
+		n.logger.Warn().Err(err).Msg("Failed to renew leader lease")
+		n.mu.RLock()
+		acquired = n.leader && now.Sub(n.lastRenew) < n.ttl
+		n.mu.RUnlock()
+	}
+	n.setLeader(acquired, now, err == nil)
+
+	if err := n.manager.SyncFromStorage(ctx); err != nil {
+		n.logger.Warn().Err(err).Msg("Failed to sync tunnels from storage")
+	}
+}
+
+// setLeader promotes or demotes the instance
+func (n *Node) setLeader(leader bool, now time.Time, renewed bool) {
+	n.mu.Lock()
+	changed := n.leader != leader
+	n.leader = leader
+	if leader && renewed {
+		n.lastRenew = now
+	}
+	n.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if leader {
+		n.logger.Info().Msg("Became leader; running tunnels")
+	} else {
+		n.logger.Warn().Msg("Lost leader lease; standing by")
+	}
+	n.manager.SetStandby(!leader)
+}
+
+// Leave stops Run, hands the leader lease over by releasing it and
+// deregisters the instance, so the others fail over without waiting for
+// the lease to expire
+func (n *Node) Leave(ctx context.Context) error {
+	var err error
+	n.leaveOnce.Do(func() {
+		n.tickMu.Lock()
+		defer n.tickMu.Unlock()
+		close(n.stopped)
+
+		if n.IsLeader() {
+			n.setLeader(false, time.Now(), false)
+			if err = n.store.ReleaseLease(ctx, leaderLease, n.instance.ID); err != nil {
+				return
+			}
+		}
+		err = n.store.RemoveInstance(ctx, n.instance.ID)
+	})
+	return err
+}
@@ -0,0 +1,177 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// memoryStore is an in-memory Store shared by the nodes of a test
+type memoryStore struct {
+	mu        sync.Mutex
+	instances map[string]types.Instance
+	holder    string
+	expires   time.Time
+	failing   bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{instances: make(map[string]types.Instance)}
+}
+
+func (s *memoryStore) Heartbeat(ctx context.Context, instance *types.Instance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instances[instance.ID] = *instance
+	return nil
+}
+
+func (s *memoryStore) ListInstances(ctx context.Context) ([]*types.Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instances := make([]*types.Instance, 0, len(s.instances))
+	for _, instance := range s.instances {
+		instance := instance
+		instances = append(instances, &instance)
+	}
+	return instances, nil
+}
+
+func (s *memoryStore) RemoveInstance(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.instances, id)
+	return nil
+}
+
+func (s *memoryStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		return false, context.DeadlineExceeded
+	}
+	now := time.Now()
+	if s.holder != "" && s.holder != holder && now.Before(s.expires) {
+		return false, nil
+	}
+	s.holder, s.expires = holder, now.Add(ttl)
+	return true, nil
+}
+
+func (s *memoryStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.holder == holder {
+		s.holder = ""
+	}
+	return nil
+}
+
+func (s *memoryStore) LeaseHolder(ctx context.Context, name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().After(s.expires) {
+		return "", nil
+	}
+	return s.holder, nil
+}
+
+// fakeManager records what a node asks of the tunnel manager
+type fakeManager struct {
+	mu      sync.Mutex
+	standby bool
+	syncs   int
+}
+
+func (m *fakeManager) SetStandby(standby bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.standby = standby
+}
+
+func (m *fakeManager) SyncFromStorage(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.syncs++
+	return nil
+}
+
+func (m *fakeManager) Standby() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.standby
+}
+
+func TestLeaderElection(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	ttl := 100 * time.Millisecond
+
+	managerA, managerB := &fakeManager{}, &fakeManager{}
+	a := New(store, managerA, "a", "http://a:8080", ttl, zerolog.Nop())
+	b := New(store, managerB, "b", "", ttl, zerolog.Nop())
+	if !managerA.Standby() || !managerB.Standby() {
+		t.Fatal("Expected managers to stand by until elected")
+	}
+
+	a.tick(ctx, time.Now())
+	b.tick(ctx, time.Now())
+	if !a.IsLeader() || managerA.Standby() {
+		t.Error("Expected the first instance to lead")
+	}
+	if b.IsLeader() || !managerB.Standby() {
+		t.Error("Expected the second instance to stand by")
+	}
+	if leader, _ := b.Leader(ctx); leader != "a" {
+		t.Errorf("Expected a to be reported as leader, got %q", leader)
+	}
+	if instances, _ := b.Instances(ctx); len(instances) != 2 {
+		t.Errorf("Expected 2 live instances, got %d", len(instances))
+	}
+	if managerB.syncs != 1 {
+		t.Errorf("Expected every round to sync, got %d syncs", managerB.syncs)
+	}
+
+	// A storage hiccup doesn't cost the leader its lease right away
+	store.mu.Lock()
+	store.failing = true
+	store.mu.Unlock()
+	a.tick(ctx, time.Now())
+	if !a.IsLeader() {
+		t.Error("Expected the leader to survive a failed renewal")
+	}
+	a.tick(ctx, time.Now().Add(ttl))
+	if a.IsLeader() || !managerA.Standby() {
+		t.Error("Expected the leader to step down once its lease certainly expired")
+	}
+	store.mu.Lock()
+	store.failing = false
+	store.mu.Unlock()
+
+	// The standby takes over once the lease expires
+	time.Sleep(ttl)
+	b.tick(ctx, time.Now())
+	if !b.IsLeader() || managerB.Standby() {
+		t.Error("Expected the standby to take over the expired lease")
+	}
+
+	// Leaving hands the lease over immediately
+	if err := b.Leave(ctx); err != nil {
+		t.Fatalf("Leave failed: %v", err)
+	}
+	if b.IsLeader() {
+		t.Error("Expected a node that left not to lead")
+	}
+	a.tick(ctx, time.Now())
+	if !a.IsLeader() {
+		t.Error("Expected the remaining instance to take over")
+	}
+	if instances, _ := a.Instances(ctx); len(instances) != 1 {
+		t.Errorf("Expected the instance that left to be deregistered, got %d", len(instances))
+	}
+}
@@ -1,20 +1,39 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
+	"path"
+	"reflect"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/spf13/viper"
 )
 
 // Config holds lazytunnel server configuration.
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Database DatabaseConfig `mapstructure:"database"`
-	Auth     AuthConfig     `mapstructure:"auth"`
-	Logging  LoggingConfig  `mapstructure:"logging"`
+	Server    ServerConfig    `mapstructure:"server"`
+	Database  DatabaseConfig  `mapstructure:"database"`
+	Auth      AuthConfig      `mapstructure:"auth"`
+	Logging   LoggingConfig   `mapstructure:"logging"`
+	Exposure  ExposureConfig  `mapstructure:"exposure"`
+	Metrics   MetricsConfig   `mapstructure:"metrics"`
+	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+	Tunnel    TunnelConfig    `mapstructure:"tunnel"`
+	Keys      KeysConfig      `mapstructure:"keys"`
+	Quotas    QuotaConfig     `mapstructure:"quotas"`
+	Cluster   ClusterConfig   `mapstructure:"cluster"`
+	SSHServer SSHServerConfig `mapstructure:"ssh_server"`
+	Relay     RelayConfig     `mapstructure:"relay"`
+
+	StatusPage StatusPageConfig `mapstructure:"status_page"`
+	Chaos      ChaosConfig      `mapstructure:"chaos"`
 }
 
 type ServerConfig struct {
@@ -22,29 +41,248 @@ type ServerConfig struct {
 	TLSCert string     `mapstructure:"tls_cert"`
 	TLSKey  string     `mapstructure:"tls_key"`
 	CORS    CORSConfig `mapstructure:"cors"`
+
+	Compression  CompressionConfig  `mapstructure:"compression"`
+	CacheControl []CacheControlRule `mapstructure:"cache_control"`
+
+	// How long responses to requests with an Idempotency-Key are replayed
+	IdempotencyTTL time.Duration `mapstructure:"idempotency_ttl"`
+
+	// How long API requests may take before they are answered with a 504,
+	// for most requests and for imports, exports and metrics history;
+	// negative disables the timeout
+	RequestTimeout     time.Duration `mapstructure:"request_timeout"`
+	LongRequestTimeout time.Duration `mapstructure:"long_request_timeout"`
 }
 
 type CORSConfig struct {
 	AllowedOrigins []string `mapstructure:"allowed_origins"`
 }
 
+// CompressionConfig controls brotli and gzip compression of HTTP responses
+type CompressionConfig struct {
+	Enabled bool     `mapstructure:"enabled"`
+	Level   int      `mapstructure:"level"`
+	MinSize int      `mapstructure:"min_size"`
+	Exclude []string `mapstructure:"exclude"`
+}
+
+// CacheControlRule sets the Cache-Control header for paths under a prefix
+type CacheControlRule struct {
+	Path  string `mapstructure:"path"`
+	Value string `mapstructure:"value"`
+}
+
 type DatabaseConfig struct {
 	Path string `mapstructure:"path"`
 }
 
 type AuthConfig struct {
-	JWTSecret          string        `mapstructure:"jwt_secret"`
-	JWTSecretEnv       string        `mapstructure:"jwt_secret_env"`
-	TokenExpiration    time.Duration `mapstructure:"token_expiration"`
-	AutoStartTunnels   bool          `mapstructure:"auto_start_tunnels"`
+	JWTSecret        string        `mapstructure:"jwt_secret"`
+	JWTSecretEnv     string        `mapstructure:"jwt_secret_env"`
+	TokenExpiration  time.Duration `mapstructure:"token_expiration"`
+	AutoStartTunnels bool          `mapstructure:"auto_start_tunnels"`
+
+	// PEM RSA or Ed25519 private key; when set, tokens are signed with it
+	// (RS256 or EdDSA) instead of jwt_secret and its public key is published
+	// at /.well-known/jwks.json
+	SigningKey string `mapstructure:"signing_key"`
+
+	// Projects each user or API key may work in, by the user ID of its
+	// tokens; those not listed only belong to the default project
+	Projects map[string][]string `mapstructure:"projects"`
 }
 
 type LoggingConfig struct {
 	Level  string `mapstructure:"level"`
 	Format string `mapstructure:"format"`
+
+	// Optional log file used instead of stderr, rotated by size and/or age
+	File           string        `mapstructure:"file"`
+	MaxSizeMB      int           `mapstructure:"max_size_mb"`
+	RotateInterval time.Duration `mapstructure:"rotate_interval"`
+	MaxBackups     int           `mapstructure:"max_backups"`
+}
+
+// ExposureConfig configures public subdomain exposure of remote tunnels.
+type ExposureConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	Domain       string `mapstructure:"domain"`
+	Addr         string `mapstructure:"addr"`
+	TLSAddr      string `mapstructure:"tls_addr"`
+	UpstreamHost string `mapstructure:"upstream_host"`
+	ACME         bool   `mapstructure:"acme"`
+	ACMEEmail    string `mapstructure:"acme_email"`
+	ACMECacheDir string `mapstructure:"acme_cache_dir"`
+}
+
+// MetricsConfig configures traffic history sampling and system metrics collection.
+type MetricsConfig struct {
+	HistoryInterval  time.Duration `mapstructure:"history_interval"`
+	HistoryRetention time.Duration `mapstructure:"history_retention"`
+	PersistInterval  time.Duration `mapstructure:"persist_interval"`  // saving samples to storage; negative disables
+	PersistRetention time.Duration `mapstructure:"persist_retention"` // how long saved samples are kept
+	SystemInterval   time.Duration `mapstructure:"system_interval"`
+}
+
+// RateLimitConfig configures per-client API rate limiting.
+type RateLimitConfig struct {
+	Enabled           bool    `mapstructure:"enabled"`
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int     `mapstructure:"burst"`
+}
+
+// QuotaConfig limits the tunnels of each user and of each project.
+type QuotaConfig struct {
+	User    QuotaLimits `mapstructure:"user"`
+	Project QuotaLimits `mapstructure:"project"`
+}
+
+// QuotaLimits are the limits of one user or project; 0 is unlimited.
+type QuotaLimits struct {
+	MaxTunnels   int   `mapstructure:"max_tunnels"`
+	MaxActive    int   `mapstructure:"max_active"`
+	MaxBandwidth int64 `mapstructure:"max_bandwidth"` // bytes/second
+}
+
+// ClusterConfig lets several servers share one database for high
+// availability; the elected leader runs the tunnels.
+type ClusterConfig struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	InstanceID string        `mapstructure:"instance_id"` // empty = hostname
+	Address    string        `mapstructure:"address"`
+	LeaseTTL   time.Duration `mapstructure:"lease_ttl"`
+}
+
+// SSHServerConfig configures the embedded SSH server remote agents dial
+// out to and register reverse forwards (ssh -R) with.
+type SSHServerConfig struct {
+	Enabled             bool   `mapstructure:"enabled"`
+	Addr                string `mapstructure:"addr"`
+	HostKey             string `mapstructure:"host_key"`        // generated on first start if missing
+	AuthorizedKeys      string `mapstructure:"authorized_keys"` // key comments name the agents
+	BindAddress         string `mapstructure:"bind_address"`    // where forwarded ports listen
+	PortRangeStart      int    `mapstructure:"port_range_start"`
+	PortRangeEnd        int    `mapstructure:"port_range_end"`
+	MaxForwardsPerAgent int    `mapstructure:"max_forwards_per_agent"`
+}
+
+// RelayConfig configures the WebSocket relay carrying SSH connections for
+// hops with the wss transport, from networks that block outbound SSH.
+type RelayConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	AllowedHosts []string      `mapstructure:"allowed_hosts"` // target names, patterns, addresses or CIDRs
+	AllowedPorts []int         `mapstructure:"allowed_ports"` // target ports the relay may connect to
+	DialTimeout  time.Duration `mapstructure:"dial_timeout"`
+
+	// Token this server's own tunnels present to the relays their hops use
+	Token string `mapstructure:"token"`
+}
+
+// StatusPageConfig configures the public, read-only page showing whether
+// some tunnels are up.
+type StatusPageConfig struct {
+	Enabled bool     `mapstructure:"enabled"`
+	Title   string   `mapstructure:"title"`
+	Tunnels []string `mapstructure:"tunnels"` // names shown; patterns such as staging-* match several
+	Fields  []string `mapstructure:"fields"`  // uptime, latency, error and/or traffic
+}
+
+// ChaosConfig enables the API that injects failures into tunnels, for
+// testing how applications and reconnects cope. Development only.
+type ChaosConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// statusPageFields are the fields the status page can show
+var statusPageFields = []string{"uptime", "latency", "error", "traffic"}
+
+// TunnelConfig holds defaults applied to tunnels created without them.
+type TunnelConfig struct {
+	DefaultAutoReconnect bool          `mapstructure:"default_auto_reconnect"`
+	DefaultKeepAlive     time.Duration `mapstructure:"default_keep_alive"`
+	DefaultMaxRetries    int           `mapstructure:"default_max_retries"`
+	DefaultBindAddress   string        `mapstructure:"default_bind_address"` // empty is all interfaces
+	DefaultIdleTimeout   time.Duration `mapstructure:"default_idle_timeout"` // 0 is never
+
+	// When each tunnel's circuit breaker opens, and for how long
+	CircuitBreaker CircuitBreakerConfig `mapstructure:"circuit_breaker"`
+
+	// How long deleted tunnels can be restored before they are purged; 0
+	// keeps them until purged explicitly
+	DeletedRetention time.Duration `mapstructure:"deleted_retention"`
+
+	// How long a tunnel can stay stopped or failed, untouched, before it is
+	// deleted; 0 keeps it. InactiveWebhook, if set, is POSTed the tunnels
+	// each cleanup deletes.
+	InactiveRetention time.Duration `mapstructure:"inactive_retention"`
+	InactiveWebhook   string        `mapstructure:"inactive_webhook"`
+
+	// Reconnect and restart attempts per second across all tunnels, after a
+	// burst of RetryBurst; 0 is unlimited
+	RetryRate  float64 `mapstructure:"retry_rate"`
+	RetryBurst int     `mapstructure:"retry_burst"`
+
+	// Percentage of the file descriptor limit kept free by rejecting new
+	// forwarded connections; 0 never rejects
+	FDHeadroom int `mapstructure:"fd_headroom"`
+
+	// How the hostnames of first hops are looked up
+	DNS DNSConfig `mapstructure:"dns"`
+
+	// Private key files GET /api/v1/identities offers; empty offers those
+	// of ssh's defaults that exist
+	KeyFiles []string `mapstructure:"key_files"`
+
+	// Hop settings for the hosts each rule matches, taken by tunnels
+	// created without them, like Match host blocks of ssh_config
+	Match []MatchRule `mapstructure:"match"`
+}
+
+// MatchRule sets the user, port, credentials and keep-alive of hops whose
+// host matches one of Host's patterns (*.prod.example.com) and none of its
+// negated ones (!legacy.prod.example.com). Rules are checked in order and,
+// as in ssh_config, the first to set a value wins.
+type MatchRule struct {
+	Host       []string      `mapstructure:"host"`
+	User       string        `mapstructure:"user"`
+	Port       int           `mapstructure:"port"`
+	AuthMethod string        `mapstructure:"auth_method"` // key if empty and key_id is set
+	KeyID      string        `mapstructure:"key_id"`
+	KeepAlive  time.Duration `mapstructure:"keep_alive"`
+}
+
+// matchAuthMethods are the auth methods a match rule can set
+var matchAuthMethods = []string{"key", "password", "agent", "cert"}
+
+// KeysConfig enables managed SSH keys, which hops reference as
+// managed://<id> and which are stored encrypted with EncryptionKey
+type KeysConfig struct {
+	EncryptionKey    string `mapstructure:"encryption_key"` // empty disables managed keys
+	EncryptionKeyEnv string `mapstructure:"encryption_key_env"`
+}
+
+// DNSConfig configures the lookups of hop hostnames: which servers are
+// asked, and how long answers and failures are cached
+type DNSConfig struct {
+	Servers     []string      `mapstructure:"servers"`      // IP or IP:port; empty asks the system's
+	Timeout     time.Duration `mapstructure:"timeout"`      // per lookup
+	CacheTTL    time.Duration `mapstructure:"cache_ttl"`    // 0 looks up every connection
+	NegativeTTL time.Duration `mapstructure:"negative_ttl"` // 0 doesn't remember failures
+	StaleTTL    time.Duration `mapstructure:"stale_ttl"`    // past cache_ttl, while lookups fail
+}
+
+// CircuitBreakerConfig configures the circuit breaker that stops a tunnel
+// from connecting after repeated failures.
+type CircuitBreakerConfig struct {
+	MaxFailures     int           `mapstructure:"max_failures"`     // failures in a row before opening
+	Timeout         time.Duration `mapstructure:"timeout"`          // of the operations it protects
+	RecoveryTimeout time.Duration `mapstructure:"recovery_timeout"` // open this long before trying again
 }
 
 // Load reads configuration from file, environment, and applies flag overrides.
+// Every option can be set from the environment as LAZYTUNNEL_<SECTION>_<KEY>,
+// e.g. LAZYTUNNEL_SERVER_TLS_CERT, which takes precedence over the file.
 func Load(configPath string, overrides map[string]interface{}) (*Config, error) {
 	v := viper.New()
 
@@ -55,12 +293,86 @@ func Load(configPath string, overrides map[string]interface{}) (*Config, error)
 	v.SetDefault("auth.auto_start_tunnels", false)
 	v.SetDefault("logging.level", "info")
 	v.SetDefault("logging.format", "console")
+	v.SetDefault("logging.max_size_mb", 100)
+	v.SetDefault("logging.max_backups", 5)
 	v.SetDefault("server.cors.allowed_origins", []string{"*"})
+	v.SetDefault("server.compression.enabled", true)
+	v.SetDefault("server.compression.min_size", 1024)
+	v.SetDefault("server.idempotency_ttl", "24h")
+	v.SetDefault("server.request_timeout", "10s")
+	v.SetDefault("server.long_request_timeout", "2m")
+	v.SetDefault("exposure.enabled", false)
+	v.SetDefault("exposure.addr", ":80")
+	v.SetDefault("exposure.tls_addr", ":443")
+	v.SetDefault("exposure.upstream_host", "127.0.0.1")
+	v.SetDefault("exposure.acme_cache_dir", "acme-cache")
+	v.SetDefault("metrics.history_interval", "10s")
+	v.SetDefault("metrics.history_retention", "24h")
+	v.SetDefault("metrics.persist_interval", "1m")
+	v.SetDefault("metrics.persist_retention", "720h")
+	v.SetDefault("metrics.system_interval", "5s")
+	v.SetDefault("rate_limit.enabled", false)
+	v.SetDefault("rate_limit.requests_per_second", 10.0)
+	v.SetDefault("rate_limit.burst", 20)
+	v.SetDefault("tunnel.default_auto_reconnect", false)
+	v.SetDefault("tunnel.default_keep_alive", "30s")
+	v.SetDefault("tunnel.default_max_retries", 5)
+	v.SetDefault("tunnel.default_bind_address", "")
+	v.SetDefault("tunnel.default_idle_timeout", "0s")
+	v.SetDefault("tunnel.circuit_breaker.max_failures", 5)
+	v.SetDefault("tunnel.circuit_breaker.timeout", "30s")
+	v.SetDefault("tunnel.circuit_breaker.recovery_timeout", "60s")
+	v.SetDefault("tunnel.deleted_retention", "720h")
+	v.SetDefault("tunnel.inactive_retention", "0s")
+	v.SetDefault("tunnel.inactive_webhook", "")
+	v.SetDefault("tunnel.retry_rate", 10.0)
+	v.SetDefault("tunnel.retry_burst", 20)
+	v.SetDefault("tunnel.fd_headroom", 10)
+	v.SetDefault("tunnel.dns.servers", []string{})
+	v.SetDefault("tunnel.dns.timeout", "5s")
+	v.SetDefault("tunnel.dns.cache_ttl", "60s")
+	v.SetDefault("tunnel.dns.negative_ttl", "5s")
+	v.SetDefault("tunnel.dns.stale_ttl", "1h")
+	v.SetDefault("keys.encryption_key_env", "LAZYTUNNEL_KEY_ENCRYPTION_KEY")
+	for _, scope := range []string{"user", "project"} {
+		v.SetDefault("quotas."+scope+".max_tunnels", 0)
+		v.SetDefault("quotas."+scope+".max_active", 0)
+		v.SetDefault("quotas."+scope+".max_bandwidth", 0)
+	}
+	v.SetDefault("cluster.enabled", false)
+	v.SetDefault("cluster.instance_id", "")
+	v.SetDefault("cluster.address", "")
+	v.SetDefault("cluster.lease_ttl", "15s")
+	v.SetDefault("ssh_server.enabled", false)
+	v.SetDefault("ssh_server.addr", ":2222")
+	v.SetDefault("ssh_server.host_key", "ssh_host_ed25519_key")
+	v.SetDefault("ssh_server.authorized_keys", "authorized_agent_keys")
+	v.SetDefault("ssh_server.bind_address", "127.0.0.1")
+	v.SetDefault("ssh_server.port_range_start", 20000)
+	v.SetDefault("ssh_server.port_range_end", 20999)
+	v.SetDefault("ssh_server.max_forwards_per_agent", 10)
+
+	v.SetDefault("relay.enabled", false)
+	v.SetDefault("relay.allowed_hosts", []string{})
+	v.SetDefault("relay.allowed_ports", []int{22})
+	v.SetDefault("relay.dial_timeout", "10s")
+	v.SetDefault("status_page.enabled", false)
+	v.SetDefault("status_page.title", "Tunnel status")
+	v.SetDefault("status_page.fields", []string{"uptime"})
+	v.SetDefault("chaos.enabled", false)
 
 	v.SetEnvPrefix("LAZYTUNNEL")
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
 
+	// AutomaticEnv only covers keys viper already knows about, so bind every
+	// option explicitly; otherwise options without a default ignore the env
+	for _, key := range configKeys(reflect.TypeOf(Config{}), "") {
+		if err := v.BindEnv(key); err != nil {
+			return nil, fmt.Errorf("bind env for %s: %w", key, err)
+		}
+	}
+
 	if configPath != "" {
 		v.SetConfigFile(configPath)
 		if err := v.ReadInConfig(); err != nil {
@@ -87,15 +399,245 @@ func Load(configPath string, overrides map[string]interface{}) (*Config, error)
 		}
 	}
 
+	if cfg.Keys.EncryptionKey == "" && cfg.Keys.EncryptionKeyEnv != "" {
+		cfg.Keys.EncryptionKey = os.Getenv(cfg.Keys.EncryptionKeyEnv)
+	}
+
 	if d, err := time.ParseDuration(v.GetString("auth.token_expiration")); err == nil {
 		cfg.Auth.TokenExpiration = d
 	} else if cfg.Auth.TokenExpiration == 0 {
 		cfg.Auth.TokenExpiration = 24 * time.Hour
 	}
 
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
 
+// configKeys lists the dotted mapstructure keys of every leaf option in t
+func configKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := prefix + field.Tag.Get("mapstructure")
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+			keys = append(keys, configKeys(field.Type, key+".")...)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Validate checks the configuration for mistakes that would otherwise only
+// surface once the server is running. All problems are reported together.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("server.addr %q: %w", c.Server.Addr, err))
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		errs = append(errs, errors.New("server.tls_cert and server.tls_key must be set together"))
+	}
+	for _, file := range []struct{ key, path string }{
+		{"server.tls_cert", c.Server.TLSCert},
+		{"server.tls_key", c.Server.TLSKey},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.key, err))
+		}
+	}
+	if len(c.Server.CORS.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New(`server.cors.allowed_origins must list at least one origin (use "*" to allow any)`))
+	}
+
+	if c.Server.Compression.Level < 0 || c.Server.Compression.Level > 9 {
+		errs = append(errs, fmt.Errorf("server.compression.level %d: must be between 1 and 9, or 0 for the default", c.Server.Compression.Level))
+	}
+	if c.Server.Compression.MinSize < 0 {
+		errs = append(errs, errors.New("server.compression.min_size must not be negative"))
+	}
+	for i, rule := range c.Server.CacheControl {
+		if !strings.HasPrefix(rule.Path, "/") || rule.Value == "" {
+			errs = append(errs, fmt.Errorf("server.cache_control[%d]: path must start with / and value must be set", i))
+		}
+	}
+
+	if c.Server.IdempotencyTTL <= 0 {
+		errs = append(errs, errors.New("server.idempotency_ttl must be positive"))
+	}
+
+	if c.Database.Path == "" {
+		errs = append(errs, errors.New("database.path is required"))
+	}
+
+	if c.Auth.TokenExpiration <= 0 {
+		errs = append(errs, errors.New("auth.token_expiration must be positive"))
+	}
+
+	if _, err := zerolog.ParseLevel(strings.ToLower(c.Logging.Level)); err != nil || c.Logging.Level == "" {
+		errs = append(errs, fmt.Errorf("logging.level %q: must be one of debug, info, warn, error", c.Logging.Level))
+	}
+	switch strings.ToLower(c.Logging.Format) {
+	case "console", "json":
+	default:
+		errs = append(errs, fmt.Errorf("logging.format %q: must be console or json", c.Logging.Format))
+	}
+	if c.Logging.MaxSizeMB < 0 || c.Logging.RotateInterval < 0 || c.Logging.MaxBackups < 0 {
+		errs = append(errs, errors.New("logging.max_size_mb, logging.rotate_interval and logging.max_backups must not be negative"))
+	}
+
+	if c.Exposure.Enabled && c.Exposure.Domain == "" {
+		errs = append(errs, errors.New("exposure.domain is required when exposure is enabled"))
+	}
+
+	if c.RateLimit.Enabled && (c.RateLimit.RequestsPerSecond <= 0 || c.RateLimit.Burst <= 0) {
+		errs = append(errs, errors.New("rate_limit.requests_per_second and rate_limit.burst must be positive when rate limiting is enabled"))
+	}
+
+	if c.Tunnel.DefaultKeepAlive <= 0 {
+		errs = append(errs, errors.New("tunnel.default_keep_alive must be positive"))
+	}
+	if c.Tunnel.DefaultMaxRetries < 0 {
+		errs = append(errs, errors.New("tunnel.default_max_retries must not be negative"))
+	}
+	if bind := strings.TrimSuffix(strings.TrimPrefix(c.Tunnel.DefaultBindAddress, "["), "]"); bind != "" && net.ParseIP(bind) == nil && bind != "localhost" {
+		errs = append(errs, fmt.Errorf("tunnel.default_bind_address %q: must be an IP address, [IPv6 address] or localhost", c.Tunnel.DefaultBindAddress))
+	}
+	if c.Tunnel.DefaultIdleTimeout < 0 {
+		errs = append(errs, errors.New("tunnel.default_idle_timeout must not be negative"))
+	}
+	if cb := c.Tunnel.CircuitBreaker; cb.MaxFailures <= 0 || cb.Timeout <= 0 || cb.RecoveryTimeout <= 0 {
+		errs = append(errs, errors.New("tunnel.circuit_breaker.max_failures, timeout and recovery_timeout must be positive"))
+	}
+	if c.Tunnel.DeletedRetention < 0 {
+		errs = append(errs, errors.New("tunnel.deleted_retention must not be negative"))
+	}
+	if c.Tunnel.InactiveRetention < 0 {
+		errs = append(errs, errors.New("tunnel.inactive_retention must not be negative"))
+	}
+	if c.Tunnel.RetryRate < 0 || c.Tunnel.RetryBurst < 0 {
+		errs = append(errs, errors.New("tunnel.retry_rate and tunnel.retry_burst must not be negative"))
+	}
+	if c.Tunnel.FDHeadroom < 0 || c.Tunnel.FDHeadroom > 90 {
+		errs = append(errs, errors.New("tunnel.fd_headroom must be between 0 and 90"))
+	}
+	if dns := c.Tunnel.DNS; dns.Timeout < 0 || dns.CacheTTL < 0 || dns.NegativeTTL < 0 || dns.StaleTTL < 0 {
+		errs = append(errs, errors.New("tunnel.dns.timeout, cache_ttl, negative_ttl and stale_ttl must not be negative"))
+	}
+	for i, server := range c.Tunnel.DNS.Servers {
+		host := server
+		if h, _, err := net.SplitHostPort(server); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) == nil {
+			errs = append(errs, fmt.Errorf("tunnel.dns.servers[%d] %q: must be an IP address, with an optional port", i, server))
+		}
+	}
+
+	for i, rule := range c.Tunnel.Match {
+		key := fmt.Sprintf("tunnel.match[%d]", i)
+		if len(rule.Host) == 0 {
+			errs = append(errs, fmt.Errorf("%s: host must list at least one pattern", key))
+		}
+		for _, pattern := range rule.Host {
+			if _, err := path.Match(strings.TrimPrefix(pattern, "!"), ""); err != nil || strings.TrimPrefix(pattern, "!") == "" {
+				errs = append(errs, fmt.Errorf("%s: invalid host pattern %q", key, pattern))
+			}
+		}
+		if rule.Port < 0 || rule.Port > 65535 {
+			errs = append(errs, fmt.Errorf("%s: port %d is not a valid port", key, rule.Port))
+		}
+		if rule.AuthMethod != "" && !slices.Contains(matchAuthMethods, rule.AuthMethod) {
+			errs = append(errs, fmt.Errorf("%s: unknown auth_method %q; use %s", key, rule.AuthMethod, strings.Join(matchAuthMethods, ", ")))
+		}
+		if rule.KeyID != "" && rule.AuthMethod != "" && rule.AuthMethod != "key" && rule.AuthMethod != "cert" {
+			errs = append(errs, fmt.Errorf("%s: key_id needs auth_method key or cert", key))
+		}
+		if rule.KeepAlive < 0 || rule.KeepAlive > 300*time.Second || rule.KeepAlive%time.Second != 0 {
+			errs = append(errs, fmt.Errorf("%s: keep_alive must be whole seconds, at most 300s", key))
+		}
+	}
+
+	for scope, limits := range map[string]QuotaLimits{"user": c.Quotas.User, "project": c.Quotas.Project} {
+		if limits.MaxTunnels < 0 || limits.MaxActive < 0 || limits.MaxBandwidth < 0 {
+			errs = append(errs, fmt.Errorf("quotas.%s limits must not be negative", scope))
+		}
+	}
+
+	if c.Cluster.Enabled && c.Cluster.LeaseTTL <= 0 {
+		errs = append(errs, errors.New("cluster.lease_ttl must be positive when clustering is enabled"))
+	}
+
+	if c.SSHServer.Enabled {
+		if _, _, err := net.SplitHostPort(c.SSHServer.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("ssh_server.addr %q: %w", c.SSHServer.Addr, err))
+		}
+		if c.SSHServer.HostKey == "" || c.SSHServer.AuthorizedKeys == "" {
+			errs = append(errs, errors.New("ssh_server.host_key and ssh_server.authorized_keys are required when the SSH server is enabled"))
+		} else if _, err := os.Stat(c.SSHServer.AuthorizedKeys); err != nil {
+			errs = append(errs, fmt.Errorf("ssh_server.authorized_keys: %w", err))
+		}
+		if c.SSHServer.PortRangeStart < 1 || c.SSHServer.PortRangeEnd > 65535 || c.SSHServer.PortRangeStart > c.SSHServer.PortRangeEnd {
+			errs = append(errs, fmt.Errorf("ssh_server.port_range_start %d and port_range_end %d: must be an ascending range of ports", c.SSHServer.PortRangeStart, c.SSHServer.PortRangeEnd))
+		}
+		if c.SSHServer.MaxForwardsPerAgent <= 0 {
+			errs = append(errs, errors.New("ssh_server.max_forwards_per_agent must be positive"))
+		}
+	}
+
+	if c.Relay.Enabled {
+		if len(c.Relay.AllowedHosts) == 0 {
+			errs = append(errs, errors.New("relay.allowed_hosts must list at least one host or network when the relay is enabled"))
+		}
+		for _, host := range c.Relay.AllowedHosts {
+			_, patternErr := path.Match(host, "")
+			_, networkErr := netip.ParsePrefix(host)
+			if host == "" || patternErr != nil || (strings.Contains(host, "/") && networkErr != nil) {
+				errs = append(errs, fmt.Errorf("relay.allowed_hosts: invalid host or network %q", host))
+			}
+		}
+		if len(c.Relay.AllowedPorts) == 0 {
+			errs = append(errs, errors.New("relay.allowed_ports must list at least one port when the relay is enabled"))
+		}
+		for _, port := range c.Relay.AllowedPorts {
+			if port < 1 || port > 65535 {
+				errs = append(errs, fmt.Errorf("relay.allowed_ports: %d is not a valid port", port))
+			}
+		}
+		if c.Relay.DialTimeout <= 0 {
+			errs = append(errs, errors.New("relay.dial_timeout must be positive"))
+		}
+	}
+
+	if c.StatusPage.Enabled {
+		if len(c.StatusPage.Tunnels) == 0 {
+			errs = append(errs, errors.New("status_page.tunnels must list at least one tunnel when the status page is enabled"))
+		}
+		for _, pattern := range c.StatusPage.Tunnels {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("status_page.tunnels: invalid pattern %q", pattern))
+			}
+		}
+		for _, field := range c.StatusPage.Fields {
+			if !slices.Contains(statusPageFields, field) {
+				errs = append(errs, fmt.Errorf("status_page.fields: unknown field %q; use %s", field, strings.Join(statusPageFields, ", ")))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
 func (c *Config) DebugEnabled() bool {
 	return strings.EqualFold(c.Logging.Level, "debug")
-}
\ No newline at end of file
+}
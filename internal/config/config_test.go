@@ -3,7 +3,9 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadDefaults(t *testing.T) {
@@ -17,6 +19,25 @@ func TestLoadDefaults(t *testing.T) {
 	if cfg.Database.Path != "tunnels.db" {
 		t.Errorf("db = %q", cfg.Database.Path)
 	}
+	if !cfg.Server.Compression.Enabled || cfg.Server.Compression.MinSize != 1024 {
+		t.Errorf("compression = %+v", cfg.Server.Compression)
+	}
+	if cfg.Server.IdempotencyTTL != 24*time.Hour {
+		t.Errorf("idempotency ttl = %s", cfg.Server.IdempotencyTTL)
+	}
+	if cfg.Server.RequestTimeout != 10*time.Second || cfg.Server.LongRequestTimeout != 2*time.Minute {
+		t.Errorf("request timeouts = %s, %s", cfg.Server.RequestTimeout, cfg.Server.LongRequestTimeout)
+	}
+	if cfg.Tunnel.RetryRate != 10 || cfg.Tunnel.RetryBurst != 20 {
+		t.Errorf("retry budget = %v/s, burst %d", cfg.Tunnel.RetryRate, cfg.Tunnel.RetryBurst)
+	}
+	if cfg.Tunnel.FDHeadroom != 10 {
+		t.Errorf("fd headroom = %d", cfg.Tunnel.FDHeadroom)
+	}
+	if dns := cfg.Tunnel.DNS; len(dns.Servers) != 0 || dns.Timeout != 5*time.Second || dns.CacheTTL != time.Minute ||
+		dns.NegativeTTL != 5*time.Second || dns.StaleTTL != time.Hour {
+		t.Errorf("dns = %+v", dns)
+	}
 }
 
 func TestLoadFromFile(t *testing.T) {
@@ -44,4 +65,169 @@ auth:
 	if cfg.Auth.JWTSecret != "test-secret" {
 		t.Errorf("jwt secret not loaded")
 	}
-}
\ No newline at end of file
+}
+
+func TestLoadMatchRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+tunnel:
+  match:
+    - host: ["*.prod.example.com", "!legacy.prod.example.com"]
+      user: deploy
+      key_id: "managed://prod"
+      keep_alive: "15s"
+    - host: "*"
+      port: 22
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Tunnel.Match) != 2 {
+		t.Fatalf("match rules = %+v, want 2", cfg.Tunnel.Match)
+	}
+	if rule := cfg.Tunnel.Match[0]; len(rule.Host) != 2 || rule.User != "deploy" || rule.KeyID != "managed://prod" || rule.KeepAlive != 15*time.Second {
+		t.Errorf("first rule = %+v", rule)
+	}
+	if rule := cfg.Tunnel.Match[1]; len(rule.Host) != 1 || rule.Host[0] != "*" || rule.Port != 22 {
+		t.Errorf("second rule = %+v, want a single pattern", rule)
+	}
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	cfg, err := Load(filepath.Join("..", "..", "config.example.yaml"), nil)
+	if err != nil {
+		t.Fatalf("example config does not load: %v", err)
+	}
+	if cfg.Tunnel.DefaultKeepAlive != 30*time.Second || cfg.Tunnel.DefaultMaxRetries != 5 {
+		t.Errorf("tunnel defaults = %+v", cfg.Tunnel)
+	}
+	if cfg.RateLimit.Burst != 20 {
+		t.Errorf("rate limit = %+v", cfg.RateLimit)
+	}
+}
+
+func TestLoadEnvOverrides(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "server.crt")
+	key := filepath.Join(dir, "server.key")
+	for _, f := range []string{cert, key} {
+		if err := os.WriteFile(f, []byte("pem"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Options without a default must still be picked up from the environment
+	t.Setenv("LAZYTUNNEL_SERVER_TLS_CERT", cert)
+	t.Setenv("LAZYTUNNEL_SERVER_TLS_KEY", key)
+	t.Setenv("LAZYTUNNEL_RATE_LIMIT_ENABLED", "true")
+	t.Setenv("LAZYTUNNEL_TUNNEL_DEFAULT_KEEP_ALIVE", "45s")
+	t.Setenv("LAZYTUNNEL_QUOTAS_USER_MAX_TUNNELS", "10")
+
+	cfg, err := Load("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.TLSCert != cert || cfg.Server.TLSKey != key {
+		t.Errorf("tls = %q, %q", cfg.Server.TLSCert, cfg.Server.TLSKey)
+	}
+	if !cfg.RateLimit.Enabled {
+		t.Error("rate limiting not enabled from env")
+	}
+	if cfg.Tunnel.DefaultKeepAlive != 45*time.Second {
+		t.Errorf("default keep-alive = %v", cfg.Tunnel.DefaultKeepAlive)
+	}
+	if cfg.Quotas.User.MaxTunnels != 10 {
+		t.Errorf("user max tunnels = %d", cfg.Quotas.User.MaxTunnels)
+	}
+}
+
+func TestLoadKeyEncryptionKeyFromEnv(t *testing.T) {
+	t.Setenv("LAZYTUNNEL_KEY_ENCRYPTION_KEY", "s3cret")
+	cfg, err := Load("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Keys.EncryptionKey != "s3cret" {
+		t.Errorf("encryption key = %q, want it from the default variable", cfg.Keys.EncryptionKey)
+	}
+
+	// A key set in the configuration wins over the variable
+	t.Setenv("LAZYTUNNEL_KEYS_ENCRYPTION_KEY", "configured")
+	if cfg, err = Load("", nil); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Keys.EncryptionKey != "configured" {
+		t.Errorf("encryption key = %q, want the configured one", cfg.Keys.EncryptionKey)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := `
+server:
+  addr: "8080"
+  tls_cert: "/nonexistent/server.crt"
+  compression:
+    level: 12
+  cache_control:
+    - path: "assets/"
+      value: "public"
+logging:
+  level: "loud"
+  format: "xml"
+rate_limit:
+  enabled: true
+  burst: 0
+tunnel:
+  retry_rate: -1
+  fd_headroom: 100
+  dns:
+    servers: ["dns.internal"]
+  default_bind_address: "all"
+  circuit_breaker:
+    max_failures: 0
+  match:
+    - host: ["*.prod.example.com"]
+      auth_method: "kerberos"
+quotas:
+  project:
+    max_active: -1
+cluster:
+  enabled: true
+  lease_ttl: "0s"
+ssh_server:
+  enabled: true
+  authorized_keys: "/nonexistent/authorized_keys"
+  port_range_start: 30000
+  port_range_end: 20000
+relay:
+  enabled: true
+  allowed_hosts: ["10.0.0.0/33"]
+  allowed_ports: [22, 70000]
+status_page:
+  enabled: true
+  tunnels: ["staging-*"]
+  fields: ["uptime", "password"]
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(path, nil)
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+
+	// Every problem is reported, not just the first
+	for _, want := range []string{"server.addr", "tls_key must be set together", "server.tls_cert", "server.compression.level", "server.cache_control[0]", "logging.level", "logging.format", "rate_limit", "tunnel.retry_rate", "tunnel.fd_headroom", "tunnel.dns.servers[0]", "tunnel.default_bind_address", "tunnel.circuit_breaker", "tunnel.match[0]", "quotas.project", "cluster.lease_ttl", "ssh_server.authorized_keys", "ssh_server.port_range_start", "relay.allowed_hosts", "relay.allowed_ports", "status_page.fields"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package exposure
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// subdomainPattern matches a single lowercase DNS label
+var subdomainPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Config holds public exposure configuration
+type Config struct {
+	// Domain is the base domain under which tunnel subdomains are assigned
+	// (e.g. "tunnels.example.com" yields "myapp.tunnels.example.com").
+	// A wildcard DNS record (*.tunnels.example.com) must point at this host.
+	Domain string
+	// Addr is the HTTP listen address for the public router (default ":80")
+	Addr string
+	// TLSAddr is the HTTPS listen address used when ACME is enabled (default ":443")
+	TLSAddr string
+	// UpstreamHost is where remote-forwarded ports are reachable (default "127.0.0.1")
+	UpstreamHost string
+	// ACME enables automatic certificates from Let's Encrypt for assigned subdomains
+	ACME bool
+	// ACMEEmail is the contact address registered with the ACME provider
+	ACMEEmail string
+	// ACMECacheDir stores issued certificates between restarts
+	ACMECacheDir string
+}
+
+// Route maps a public subdomain to a remote-forwarded port
+type Route struct {
+	TunnelID  string
+	Subdomain string
+	Port      int
+}
+
+// Router assigns subdomains to remote tunnels and reverse-proxies HTTP
+// requests for those subdomains to the corresponding forwarded port
+type Router struct {
+	config  Config
+	routes  map[string]Route  // subdomain -> route
+	tunnels map[string]string // tunnel ID -> subdomain
+	mu      sync.RWMutex
+
+	certManager *autocert.Manager
+	servers     []*http.Server
+}
+
+// NewRouter creates a new exposure router
+func NewRouter(config Config) (*Router, error) {
+	config.Domain = strings.ToLower(strings.Trim(config.Domain, "."))
+	if config.Domain == "" {
+		return nil, fmt.Errorf("exposure domain is required")
+	}
+	if config.Addr == "" {
+		config.Addr = ":80"
+	}
+	if config.TLSAddr == "" {
+		config.TLSAddr = ":443"
+	}
+	if config.UpstreamHost == "" {
+		config.UpstreamHost = "127.0.0.1"
+	}
+
+	r := &Router{
+		config:  config,
+		routes:  make(map[string]Route),
+		tunnels: make(map[string]string),
+	}
+
+	if config.ACME {
+		cacheDir := config.ACMECacheDir
+		if cacheDir == "" {
+			cacheDir = "acme-cache"
+		}
+		r.certManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Email:      config.ACMEEmail,
+			Cache:      autocert.DirCache(cacheDir),
+			HostPolicy: r.hostPolicy,
+		}
+	}
+
+	return r, nil
+}
+
+// Domain returns the base domain for assigned subdomains
+func (r *Router) Domain() string {
+	return r.config.Domain
+}
+
+// Assign reserves a subdomain for a tunnel. If preferred is empty a random
+// subdomain is generated. Assigning the same tunnel twice returns the existing route.
+func (r *Router) Assign(tunnelID, preferred string, port int) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.tunnels[tunnelID]; ok {
+		route := r.routes[existing]
+		route.Port = port
+		r.routes[existing] = route
+		return existing, nil
+	}
+
+	subdomain := strings.ToLower(preferred)
+	if subdomain != "" {
+		if !ValidSubdomain(subdomain) {
+			return "", fmt.Errorf("invalid subdomain: %q", preferred)
+		}
+		if _, taken := r.routes[subdomain]; taken {
+			return "", fmt.Errorf("subdomain %q is already assigned", subdomain)
+		}
+	} else {
+		for {
+			generated, err := randomSubdomain()
+			if err != nil {
+				return "", fmt.Errorf("failed to generate subdomain: %w", err)
+			}
+			if _, taken := r.routes[generated]; !taken {
+				subdomain = generated
+				break
+			}
+		}
+	}
+
+	r.routes[subdomain] = Route{TunnelID: tunnelID, Subdomain: subdomain, Port: port}
+	r.tunnels[tunnelID] = subdomain
+	return subdomain, nil
+}
+
+// Release frees the subdomain assigned to a tunnel
+func (r *Router) Release(tunnelID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if subdomain, ok := r.tunnels[tunnelID]; ok {
+		delete(r.routes, subdomain)
+		delete(r.tunnels, tunnelID)
+	}
+}
+
+// Lookup returns the route for a subdomain
+func (r *Router) Lookup(subdomain string) (Route, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	route, ok := r.routes[strings.ToLower(subdomain)]
+	return route, ok
+}
+
+// PublicURL returns the public URL for a subdomain
+func (r *Router) PublicURL(subdomain string) string {
+	if subdomain == "" {
+		return ""
+	}
+	scheme := "http"
+	if r.certManager != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s.%s", scheme, subdomain, r.config.Domain)
+}
+
+// ServeHTTP routes requests by Host header to the assigned tunnel port
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	subdomain, ok := r.subdomainFromHost(req.Host)
+	if !ok {
+		http.Error(w, "unknown host", http.StatusNotFound)
+		return
+	}
+
+	route, ok := r.Lookup(subdomain)
+	if !ok {
+		http.Error(w, "no tunnel is exposed at this address", http.StatusNotFound)
+		return
+	}
+
+	target := &url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(r.config.UpstreamHost, fmt.Sprintf("%d", route.Port)),
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, _ *http.Request, err error) {
+		http.Error(w, "tunnel upstream unavailable", http.StatusBadGateway)
+	}
+	proxy.ServeHTTP(w, req)
+}
+
+// subdomainFromHost extracts the tunnel subdomain from a request host
+func (r *Router) subdomainFromHost(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	suffix := "." + r.config.Domain
+	if !strings.HasSuffix(host, suffix) {
+		return "", false
+	}
+
+	subdomain := strings.TrimSuffix(host, suffix)
+	if !ValidSubdomain(subdomain) {
+		return "", false
+	}
+	return subdomain, true
+}
+
+// hostPolicy only allows certificates for currently assigned subdomains
+func (r *Router) hostPolicy(_ context.Context, host string) error {
+	subdomain, ok := r.subdomainFromHost(host)
+	if !ok {
+		return fmt.Errorf("host %q is not under %s", host, r.config.Domain)
+	}
+	if _, ok := r.Lookup(subdomain); !ok {
+		return fmt.Errorf("subdomain %q is not assigned", subdomain)
+	}
+	return nil
+}
+
+// Start starts the public HTTP (and, with ACME, HTTPS) listeners
+func (r *Router) Start() error {
+	var handler http.Handler = r
+	if r.certManager != nil {
+		// Plain HTTP serves ACME challenges and redirects everything else to HTTPS
+		handler = r.certManager.HTTPHandler(nil)
+	}
+
+	httpServer := &http.Server{
+		Addr:              r.config.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 15 * time.Second,
+	}
+	r.servers = append(r.servers, httpServer)
+
+	errCh := make(chan error, 2)
+	go func() { errCh <- httpServer.ListenAndServe() }()
+
+	if r.certManager != nil {
+		tlsServer := &http.Server{
+			Addr:              r.config.TLSAddr,
+			Handler:           r,
+			ReadHeaderTimeout: 15 * time.Second,
+			TLSConfig: &tls.Config{
+				GetCertificate: r.certManager.GetCertificate,
+				MinVersion:     tls.VersionTLS12,
+			},
+		}
+		r.servers = append(r.servers, tlsServer)
+		go func() { errCh <- tlsServer.ListenAndServeTLS("", "") }()
+	}
+
+	// Surface immediate bind failures to the caller
+	select {
+	case err := <-errCh:
+		if err != nil && err != http.ErrServerClosed {
+			return fmt.Errorf("failed to start exposure router: %w", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	return nil
+}
+
+// Shutdown gracefully stops the public listeners
+func (r *Router) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, srv := range r.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("errors shutting down exposure router: %v", errs)
+	}
+	return nil
+}
+
+// ValidSubdomain reports whether s is a valid single DNS label
+func ValidSubdomain(s string) bool {
+	return subdomainPattern.MatchString(s)
+}
+
+// randomSubdomain generates a short random subdomain
+func randomSubdomain() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "t" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,112 @@
+package exposure
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestNewRouterRequiresDomain(t *testing.T) {
+	if _, err := NewRouter(Config{}); err == nil {
+		t.Error("Expected error when domain is empty")
+	}
+}
+
+func TestAssignAndRelease(t *testing.T) {
+	router, err := NewRouter(Config{Domain: "tunnels.example.com."})
+	if err != nil {
+		t.Fatalf("NewRouter() error = %v", err)
+	}
+
+	sub, err := router.Assign("tunnel-1", "myapp", 9000)
+	if err != nil {
+		t.Fatalf("Assign() error = %v", err)
+	}
+	if sub != "myapp" {
+		t.Errorf("Assign() = %q, want myapp", sub)
+	}
+
+	// Same tunnel keeps its subdomain
+	again, err := router.Assign("tunnel-1", "", 9001)
+	if err != nil || again != "myapp" {
+		t.Errorf("re-Assign() = %q, %v; want myapp, nil", again, err)
+	}
+
+	// Another tunnel cannot take the same subdomain
+	if _, err := router.Assign("tunnel-2", "myapp", 9002); err == nil {
+		t.Error("Expected conflict assigning a taken subdomain")
+	}
+
+	// Invalid labels are rejected
+	if _, err := router.Assign("tunnel-3", "Bad_Label", 9003); err == nil {
+		t.Error("Expected error for invalid subdomain")
+	}
+
+	// Random assignment
+	random, err := router.Assign("tunnel-4", "", 9004)
+	if err != nil || !ValidSubdomain(random) {
+		t.Errorf("random Assign() = %q, %v", random, err)
+	}
+
+	if got := router.PublicURL("myapp"); got != "http://myapp.tunnels.example.com" {
+		t.Errorf("PublicURL() = %q", got)
+	}
+
+	router.Release("tunnel-1")
+	if _, ok := router.Lookup("myapp"); ok {
+		t.Error("Expected route to be released")
+	}
+}
+
+func TestServeHTTPRoutesByHost(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello from tunnel")
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	router, err := NewRouter(Config{Domain: "tunnels.example.com", UpstreamHost: u.Hostname()})
+	if err != nil {
+		t.Fatalf("NewRouter() error = %v", err)
+	}
+	if _, err := router.Assign("tunnel-1", "myapp", port); err != nil {
+		t.Fatalf("Assign() error = %v", err)
+	}
+
+	tests := []struct {
+		host       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"myapp.tunnels.example.com", http.StatusOK, "hello from tunnel"},
+		{"MyApp.tunnels.example.com:80", http.StatusOK, "hello from tunnel"},
+		{"other.tunnels.example.com", http.StatusNotFound, ""},
+		{"example.org", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" {
+				body, _ := io.ReadAll(rec.Body)
+				if string(body) != tt.wantBody {
+					t.Errorf("body = %q, want %q", body, tt.wantBody)
+				}
+			}
+		})
+	}
+}
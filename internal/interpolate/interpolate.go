@@ -0,0 +1,132 @@
+// Package interpolate resolves ${VAR} references to environment variables in
+// tunnel definitions, so one definition works across environments.
+package interpolate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Lookup returns the value of a variable and whether it is set, like
+// os.LookupEnv
+type Lookup func(name string) (string, bool)
+
+// Expand replaces the ${VAR} and ${VAR:-default} references in s. A
+// default is used when the variable is unset or empty; a variable that is
+// unset without a default is an error, rather than an empty host or user.
+// $${ stands for a literal ${.
+func Expand(s string, lookup Lookup) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i]) // keeps one $ of $$
+			b.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference in %q", s)
+		}
+		ref := s[i+2 : i+end]
+		s = s[i+end+1:]
+
+		name, def, hasDefault := strings.Cut(ref, ":-")
+		if !validName(name) {
+			return "", fmt.Errorf("invalid variable name %q", name)
+		}
+		value, ok := lookup(name)
+		switch {
+		case ok && value != "":
+		case hasDefault:
+			value = def
+		case !ok:
+			return "", fmt.Errorf("variable %s is not set", name)
+		}
+		b.WriteString(value)
+	}
+}
+
+// Document expands the strings of a decoded JSON or YAML document, e.g. a
+// tunnel definition. It returns the templates of the values it changed,
+// keyed by their path, like "hops[0].host", so they can be recorded.
+func Document(doc interface{}, lookup Lookup) (interface{}, map[string]string, error) {
+	templates := make(map[string]string)
+	expanded, err := walk(doc, "", lookup, templates)
+	if err != nil {
+		return nil, nil, err
+	}
+	return expanded, templates, nil
+}
+
+func walk(node interface{}, path string, lookup Lookup, templates map[string]string) (interface{}, error) {
+	switch node := node.(type) {
+	case string:
+		value, err := Expand(node, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if value != node {
+			templates[path] = node
+		}
+		return value, nil
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(node))
+		for key := range node {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys) // report the first error deterministically
+		for _, key := range keys {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			value, err := walk(node[key], child, lookup, templates)
+			if err != nil {
+				return nil, err
+			}
+			node[key] = value
+		}
+		return node, nil
+
+	case []interface{}:
+		for i := range node {
+			value, err := walk(node[i], fmt.Sprintf("%s[%d]", path, i), lookup, templates)
+			if err != nil {
+				return nil, err
+			}
+			node[i] = value
+		}
+		return node, nil
+	}
+	return node, nil
+}
+
+// validName reports whether name is a valid environment variable name
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package interpolate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func lookupFrom(env map[string]string) Lookup {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestExpand(t *testing.T) {
+	lookup := lookupFrom(map[string]string{"HOST": "bastion.prod", "PORT": "2222", "EMPTY": ""})
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "plain.example.com", want: "plain.example.com"},
+		{in: "${HOST}", want: "bastion.prod"},
+		{in: "${HOST}:${PORT}", want: "bastion.prod:2222"},
+		{in: "db.${HOST}", want: "db.bastion.prod"},
+		{in: "${USER_NAME:-deploy}", want: "deploy"},
+		{in: "${EMPTY:-fallback}", want: "fallback"},
+		{in: "${HOST:-fallback}", want: "bastion.prod"},
+		{in: "${EMPTY}", want: ""},
+		{in: "$${HOST}", want: "${HOST}"},
+		{in: "$HOST", want: "$HOST"},
+		{in: "~/.ssh/${KEY:-id_ed25519}", want: "~/.ssh/id_ed25519"},
+		{in: "${MISSING}", wantErr: true},
+		{in: "${HOST", wantErr: true},
+		{in: "${1BAD}", wantErr: true},
+		{in: "${}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := Expand(tt.in, lookup)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Expand(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Expand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDocument(t *testing.T) {
+	lookup := lookupFrom(map[string]string{"BASTION": "bastion.prod", "DB": "db.prod"})
+	doc := map[string]interface{}{
+		"name":       "db",
+		"remoteHost": "${DB}",
+		"localPort":  5432,
+		"hops": []interface{}{
+			map[string]interface{}{"host": "${BASTION}", "user": "${SSH_USER:-deploy}", "port": 22},
+		},
+	}
+
+	expanded, templates, err := Document(doc, lookup)
+	if err != nil {
+		t.Fatalf("Document failed: %v", err)
+	}
+
+	hop := expanded.(map[string]interface{})["hops"].([]interface{})[0].(map[string]interface{})
+	if hop["host"] != "bastion.prod" || hop["user"] != "deploy" {
+		t.Errorf("Expected the hop to be expanded, got %v", hop)
+	}
+	want := map[string]string{
+		"remoteHost":   "${DB}",
+		"hops[0].host": "${BASTION}",
+		"hops[0].user": "${SSH_USER:-deploy}",
+	}
+	if !reflect.DeepEqual(templates, want) {
+		t.Errorf("Expected templates %v, got %v", want, templates)
+	}
+
+	_, _, err = Document(map[string]interface{}{"hops": []interface{}{map[string]interface{}{"host": "${NOPE}"}}}, lookup)
+	if err == nil || err.Error() != "hops[0].host: variable NOPE is not set" {
+		t.Errorf("Expected the error to name the field, got %v", err)
+	}
+}
@@ -0,0 +1,353 @@
+package keys
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+var (
+	// ErrNotFound is returned for a managed key ID that doesn't exist
+	ErrNotFound = errors.New("managed key not found")
+	// ErrRotating is returned when rotating a key whose previous rotation
+	// hasn't been finished
+	ErrRotating = errors.New("key rotation already in progress")
+	// ErrNotRotating is returned when finishing a rotation of a key that
+	// isn't being rotated
+	ErrNotRotating = errors.New("no key rotation in progress")
+	// ErrInvalidKey is returned for an uploaded private key that can't be
+	// parsed or used
+	ErrInvalidKey = errors.New("invalid private key")
+)
+
+// Store keeps managed keys, with their private keys already encrypted
+type Store interface {
+	// SaveManagedKey creates the key or replaces the one with its ID
+	SaveManagedKey(ctx context.Context, key *types.ManagedKey) error
+	// GetManagedKey returns nil, without an error, for an unknown key
+	GetManagedKey(ctx context.Context, id string) (*types.ManagedKey, error)
+	ListManagedKeys(ctx context.Context) ([]*types.ManagedKey, error)
+	DeleteManagedKey(ctx context.Context, id string) error
+}
+
+// Keyring creates, rotates and unlocks managed keys. Private keys are
+// sealed with AES-256-GCM under a key derived from the configured secret,
+// bound to their key's ID, so the store never sees them in the clear.
+type Keyring struct {
+	store Store
+	aead  cipher.AEAD
+	mu    sync.Mutex // serializes changes to stored keys
+}
+
+// New returns a keyring keeping keys in store, encrypted with secret
+func New(store Store, secret string) (*Keyring, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("an encryption key is required for managed keys")
+	}
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{store: store, aead: aead}, nil
+}
+
+// Generate creates a managed ed25519 key
+func (k *Keyring) Generate(ctx context.Context, name, owner string) (*types.ManagedKey, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return k.create(ctx, name, owner, private)
+}
+
+// Import creates a managed key from a PEM-encoded private key, unlocking it
+// with passphrase if it is encrypted
+func (k *Keyring) Import(ctx context.Context, name, owner string, privateKey, passphrase []byte) (*types.ManagedKey, error) {
+	private, err := parsePrivateKey(privateKey, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return k.create(ctx, name, owner, private)
+}
+
+func (k *Keyring) create(ctx context.Context, name, owner string, private crypto.PrivateKey) (*types.ManagedKey, error) {
+	key := &types.ManagedKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Owner:     owner,
+		CreatedAt: time.Now(),
+	}
+	version, err := k.seal(key.ID, private)
+	if err != nil {
+		return nil, err
+	}
+	setVersion(key, version)
+
+	if err := k.store.SaveManagedKey(ctx, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// List returns all managed keys, oldest first
+func (k *Keyring) List(ctx context.Context) ([]*types.ManagedKey, error) {
+	keys, err := k.store.ListManagedKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// Get returns a managed key, or ErrNotFound
+func (k *Keyring) Get(ctx context.Context, id string) (*types.ManagedKey, error) {
+	key, err := k.store.GetManagedKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return key, nil
+}
+
+// Rotate replaces a managed key's key pair with privateKey, or with a new
+// ed25519 key if privateKey is empty. The replaced key stays usable until
+// FinishRotation is called.
+func (k *Keyring) Rotate(ctx context.Context, id string, privateKey, passphrase []byte) (*types.ManagedKey, error) {
+	var private crypto.PrivateKey
+	if len(privateKey) > 0 {
+		var err error
+		if private, err = parsePrivateKey(privateKey, passphrase); err != nil {
+			return nil, err
+		}
+	} else {
+		_, generated, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		private = generated
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, err := k.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.Previous != nil {
+		return nil, ErrRotating
+	}
+	version, err := k.seal(key.ID, private)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Previous = &types.ManagedKeyVersion{
+		Type:        key.Type,
+		Fingerprint: key.Fingerprint,
+		PublicKey:   key.PublicKey,
+		PrivateKey:  key.PrivateKey,
+	}
+	setVersion(key, version)
+	now := time.Now()
+	key.RotatedAt = &now
+
+	if err := k.store.SaveManagedKey(ctx, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// FinishRotation forgets the key pair a rotation replaced, once its public
+// key has been removed from the hosts that trusted it
+func (k *Keyring) FinishRotation(ctx context.Context, id string) (*types.ManagedKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, err := k.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.Previous == nil {
+		return nil, ErrNotRotating
+	}
+	key.Previous = nil
+
+	if err := k.store.SaveManagedKey(ctx, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Delete removes a managed key
+func (k *Keyring) Delete(ctx context.Context, id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, err := k.Get(ctx, id); err != nil {
+		return err
+	}
+	return k.store.DeleteManagedKey(ctx, id)
+}
+
+// Signers unlocks a managed key for authentication: its current key pair,
+// then the one a rotation in progress replaced
+func (k *Keyring) Signers(ctx context.Context, id string) ([]ssh.Signer, error) {
+	key, err := k.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := k.open(key.ID, key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	signers := []ssh.Signer{signer}
+	if key.Previous != nil {
+		previous, err := k.open(key.ID, key.Previous.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, previous)
+	}
+	return signers, nil
+}
+
+// seal encrypts a private key for the managed key with the given ID
+func (k *Keyring) seal(id string, private crypto.PrivateKey) (*types.ManagedKeyVersion, error) {
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	public := signer.PublicKey()
+	return &types.ManagedKeyVersion{
+		Type:        public.Type(),
+		Fingerprint: ssh.FingerprintSHA256(public),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(public))),
+		PrivateKey:  k.aead.Seal(nonce, nonce, pem.EncodeToMemory(block), []byte(id)),
+	}, nil
+}
+
+// open decrypts a private key sealed for the managed key with the given ID
+func (k *Keyring) open(id string, sealed []byte) (ssh.Signer, error) {
+	size := k.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, fmt.Errorf("managed key %s is corrupt", id)
+	}
+	data, err := k.aead.Open(nil, sealed[:size], sealed[size:], []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt managed key %s; was the encryption key changed?", id)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse managed key %s: %w", id, err)
+	}
+	return signer, nil
+}
+
+// setVersion makes version the managed key's current key pair
+func setVersion(key *types.ManagedKey, version *types.ManagedKeyVersion) {
+	key.Type = version.Type
+	key.Fingerprint = version.Fingerprint
+	key.PublicKey = version.PublicKey
+	key.PrivateKey = version.PrivateKey
+}
+
+// parsePrivateKey parses a PEM-encoded private key, unlocking it with
+// passphrase if it is encrypted
+func parsePrivateKey(data, passphrase []byte) (crypto.PrivateKey, error) {
+	var private crypto.PrivateKey
+	var err error
+	if len(passphrase) > 0 {
+		private, err = ssh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+	} else {
+		private, err = ssh.ParseRawPrivateKey(data)
+	}
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("%w: it is encrypted and needs a passphrase", ErrInvalidKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return private, nil
+}
+
+// MemoryStore is a Store that keeps keys in memory, for servers without
+// persistent storage
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]types.ManagedKey
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]types.ManagedKey)}
+}
+
+func (m *MemoryStore) SaveManagedKey(ctx context.Context, key *types.ManagedKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key.ID] = *key
+	return nil
+}
+
+func (m *MemoryStore) GetManagedKey(ctx context.Context, id string) (*types.ManagedKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[id]
+	if !ok {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+func (m *MemoryStore) ListManagedKeys(ctx context.Context) ([]*types.ManagedKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]*types.ManagedKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		key := key
+		keys = append(keys, &key)
+	}
+	return keys, nil
+}
+
+func (m *MemoryStore) DeleteManagedKey(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, id)
+	return nil
+}
@@ -0,0 +1,154 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestKeyring(t *testing.T, store Store, secret string) *Keyring {
+	t.Helper()
+	keyring, err := New(store, secret)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return keyring
+}
+
+func TestKeyringGenerate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	keyring := newTestKeyring(t, store, "s3cret")
+
+	key, err := keyring.Generate(ctx, "deploy", "alice")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if key.ID == "" || key.Name != "deploy" || key.Owner != "alice" || key.Type != ssh.KeyAlgoED25519 ||
+		!strings.HasPrefix(key.Fingerprint, "SHA256:") || !strings.HasPrefix(key.PublicKey, "ssh-ed25519 ") {
+		t.Errorf("generated key = %+v", key)
+	}
+
+	// The store only ever sees the private key encrypted
+	stored, _ := store.GetManagedKey(ctx, key.ID)
+	if bytes.Contains(stored.PrivateKey, []byte("PRIVATE KEY")) {
+		t.Error("private key stored in the clear")
+	}
+
+	signers, err := keyring.Signers(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("Signers() error = %v", err)
+	}
+	if len(signers) != 1 || ssh.FingerprintSHA256(signers[0].PublicKey()) != key.Fingerprint {
+		t.Errorf("Signers() = %v, want the generated key", signers)
+	}
+
+	// Another secret can't unlock it
+	if _, err := newTestKeyring(t, store, "wrong").Signers(ctx, key.ID); err == nil {
+		t.Error("Signers() succeeded with the wrong encryption key")
+	}
+	if _, err := keyring.Signers(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Signers() of an unknown key error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestKeyringImport(t *testing.T) {
+	ctx := context.Background()
+	keyring := newTestKeyring(t, NewMemoryStore(), "s3cret")
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := pem.EncodeToMemory(block)
+	signer, _ := ssh.NewSignerFromKey(priv)
+
+	if _, err := keyring.Import(ctx, "ci", "bob", encrypted, nil); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Import() without the passphrase error = %v, want ErrInvalidKey", err)
+	}
+	if _, err := keyring.Import(ctx, "ci", "bob", []byte("not a key"), nil); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Import() of garbage error = %v, want ErrInvalidKey", err)
+	}
+
+	key, err := keyring.Import(ctx, "ci", "bob", encrypted, []byte("hunter2"))
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if key.Fingerprint != ssh.FingerprintSHA256(signer.PublicKey()) {
+		t.Errorf("imported fingerprint = %s, want the uploaded key's", key.Fingerprint)
+	}
+	signers, err := keyring.Signers(ctx, key.ID)
+	if err != nil || len(signers) != 1 {
+		t.Fatalf("Signers() = %v, %v", signers, err)
+	}
+}
+
+func TestKeyringRotate(t *testing.T) {
+	ctx := context.Background()
+	keyring := newTestKeyring(t, NewMemoryStore(), "s3cret")
+
+	key, err := keyring.Generate(ctx, "deploy", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.FinishRotation(ctx, key.ID); !errors.Is(err, ErrNotRotating) {
+		t.Errorf("FinishRotation() before rotating error = %v, want ErrNotRotating", err)
+	}
+
+	rotated, err := keyring.Rotate(ctx, key.ID, nil, nil)
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if rotated.Fingerprint == key.Fingerprint || rotated.Previous == nil ||
+		rotated.Previous.Fingerprint != key.Fingerprint || rotated.RotatedAt == nil {
+		t.Errorf("rotated key = %+v, want a new key pair with the old one kept", rotated)
+	}
+	if _, err := keyring.Rotate(ctx, key.ID, nil, nil); !errors.Is(err, ErrRotating) {
+		t.Errorf("second Rotate() error = %v, want ErrRotating", err)
+	}
+
+	// Both key pairs are offered, the new one first
+	signers, err := keyring.Signers(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("Signers() error = %v", err)
+	}
+	if len(signers) != 2 || ssh.FingerprintSHA256(signers[0].PublicKey()) != rotated.Fingerprint ||
+		ssh.FingerprintSHA256(signers[1].PublicKey()) != key.Fingerprint {
+		t.Errorf("Signers() during a rotation = %v, want the new key then the old one", signers)
+	}
+
+	finished, err := keyring.FinishRotation(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("FinishRotation() error = %v", err)
+	}
+	if finished.Previous != nil || finished.Fingerprint != rotated.Fingerprint {
+		t.Errorf("finished key = %+v", finished)
+	}
+	if signers, _ := keyring.Signers(ctx, key.ID); len(signers) != 1 {
+		t.Errorf("Signers() after the rotation = %v, want only the new key", signers)
+	}
+
+	if err := keyring.Delete(ctx, key.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := keyring.Get(ctx, key.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestNewRequiresSecret(t *testing.T) {
+	if _, err := New(NewMemoryStore(), ""); err == nil {
+		t.Error("New() succeeded without an encryption key")
+	}
+}
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+
+	f, err := NewRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Distinct timestamps keep backup names unique
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(current) != "dddddddd\n" {
+		t.Errorf("current file = %q, want only the last line", current)
+	}
+
+	// Three rotations happened; only the two newest backups are kept
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("backups = %v, want 2", backups)
+	}
+	newest, _ := os.ReadFile(backups[1])
+	if string(newest) != "cccccccc\n" {
+		t.Errorf("newest backup = %q", newest)
+	}
+}
+
+func TestRotatingFileRotatesByInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+
+	f, err := NewRotatingFile(path, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return clock }
+	f.openedAt = clock
+
+	f.Write([]byte("first\n"))
+	clock = clock.Add(30 * time.Minute)
+	f.Write([]byte("second\n"))
+
+	if backups, _ := filepath.Glob(path + ".*"); len(backups) != 0 {
+		t.Fatalf("rotated before the interval elapsed: %v", backups)
+	}
+
+	clock = clock.Add(time.Hour)
+	f.Write([]byte("third\n"))
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want 1", backups)
+	}
+	old, _ := os.ReadFile(backups[0])
+	if !strings.Contains(string(old), "first") || !strings.Contains(string(old), "second") {
+		t.Errorf("backup = %q, want the first two lines", old)
+	}
+	current, _ := os.ReadFile(path)
+	if string(current) != "third\n" {
+		t.Errorf("current file = %q", current)
+	}
+}
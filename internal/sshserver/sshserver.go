@@ -0,0 +1,619 @@
+package sshserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/ssh"
+)
+
+// Config holds the embedded SSH server configuration
+type Config struct {
+	// Addr is the SSH listen address (default ":2222")
+	Addr string
+	// HostKeyPath is the server's PEM private host key. An ed25519 key is
+	// generated there on first start if the file does not exist.
+	HostKeyPath string
+	// AuthorizedKeysPath lists the public keys agents may connect with, in
+	// authorized_keys format. Each key's comment is the agent's name. The
+	// file is re-read when it changes.
+	AuthorizedKeysPath string
+	// BindAddress is where forwarded ports listen (default "127.0.0.1")
+	BindAddress string
+	// PortRangeStart and PortRangeEnd bound the ports handed to agents
+	// (default 20000-20999)
+	PortRangeStart int
+	PortRangeEnd   int
+	// MaxForwardsPerAgent limits the ports one agent holds at once (default 10)
+	MaxForwardsPerAgent int
+	Logger              zerolog.Logger
+}
+
+// Forward is a reverse forward an agent registered
+type Forward struct {
+	Agent         string
+	Port          int    // port listening on BindAddress
+	RequestedAddr string // address the agent asked to bind
+	RequestedPort int    // port the agent asked for; 0 lets the server pick
+	RemoteAddr    string // address the agent connected from
+	ConnectedAt   time.Time
+	ActiveConns   int64
+}
+
+// forward is a registered reverse forward and its listener
+type forward struct {
+	info     Forward
+	listener net.Listener
+	conns    atomic.Int64
+}
+
+// Server accepts SSH connections from agents without inbound connectivity
+// and serves their reverse forwards (ssh -R) on local ports
+type Server struct {
+	config    Config
+	sshConfig *ssh.ServerConfig
+	hostKey   ssh.Signer
+	keys      *authorizedKeys
+	logger    zerolog.Logger
+
+	mu        sync.Mutex
+	forwards  map[int]*forward // port -> forward
+	lastPorts map[string][]int // agent -> ports it held, preferred on reconnect
+	listener  net.Listener
+	conns     map[*ssh.ServerConn]struct{}
+	wg        sync.WaitGroup
+}
+
+// channelForwardMsg is the payload of tcpip-forward and
+// cancel-tcpip-forward requests (RFC 4254 section 7.1)
+type channelForwardMsg struct {
+	Addr string
+	Port uint32
+}
+
+// forwardedTCPPayload is the payload of forwarded-tcpip channels (RFC 4254
+// section 7.2)
+type forwardedTCPPayload struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// NewServer creates an embedded SSH server, loading or generating its host
+// key and loading the authorized agent keys
+func NewServer(config Config) (*Server, error) {
+	if config.Addr == "" {
+		config.Addr = ":2222"
+	}
+	if config.BindAddress == "" {
+		config.BindAddress = "127.0.0.1"
+	}
+	if config.PortRangeStart == 0 && config.PortRangeEnd == 0 {
+		config.PortRangeStart, config.PortRangeEnd = 20000, 20999
+	}
+	if config.PortRangeStart < 1 || config.PortRangeEnd > 65535 || config.PortRangeStart > config.PortRangeEnd {
+		return nil, fmt.Errorf("invalid port range %d-%d", config.PortRangeStart, config.PortRangeEnd)
+	}
+	if config.MaxForwardsPerAgent <= 0 {
+		config.MaxForwardsPerAgent = 10
+	}
+	if config.HostKeyPath == "" {
+		return nil, fmt.Errorf("host key path is required")
+	}
+	if config.AuthorizedKeysPath == "" {
+		return nil, fmt.Errorf("authorized keys path is required")
+	}
+
+	hostKey, err := loadOrGenerateHostKey(config.HostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	keys := &authorizedKeys{path: config.AuthorizedKeysPath}
+	if _, err := keys.lookup(nil); err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		config:    config,
+		hostKey:   hostKey,
+		keys:      keys,
+		logger:    config.Logger.With().Str("component", "sshserver").Logger(),
+		forwards:  make(map[int]*forward),
+		lastPorts: make(map[string][]int),
+		conns:     make(map[*ssh.ServerConn]struct{}),
+	}
+	s.sshConfig = &ssh.ServerConfig{PublicKeyCallback: s.authenticate}
+	s.sshConfig.AddHostKey(hostKey)
+	return s, nil
+}
+
+// Addr returns the address the server listens on, once started
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener != nil {
+		return s.listener.Addr().String()
+	}
+	return s.config.Addr
+}
+
+// HostKeyFingerprint returns the SHA256 fingerprint of the server's host
+// key, for agents to verify
+func (s *Server) HostKeyFingerprint() string {
+	return ssh.FingerprintSHA256(s.hostKey.PublicKey())
+}
+
+// Start begins accepting agent connections
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.Addr, err)
+	}
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serveConn(conn)
+			}()
+		}
+	}()
+
+	s.logger.Info().
+		Str("addr", listener.Addr().String()).
+		Str("host_key", s.HostKeyFingerprint()).
+		Msg("SSH server listening for agents")
+	return nil
+}
+
+// Shutdown closes the listener, every agent connection and every forward
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Forwards returns the reverse forwards currently registered, by port
+func (s *Server) Forwards() []Forward {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Forward, 0, len(s.forwards))
+	for _, f := range s.forwards {
+		info := f.info
+		info.ActiveConns = f.conns.Load()
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Port < out[j].Port })
+	return out
+}
+
+// PortCount returns how many ports agents may forward, each held open by
+// a listener
+func (s *Server) PortCount() int {
+	return s.config.PortRangeEnd - s.config.PortRangeStart + 1
+}
+
+// authenticate accepts the keys listed in the authorized keys file,
+// recording the agent's name
+func (s *Server) authenticate(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	agent, err := s.keys.lookup(key)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to read authorized keys")
+		return nil, errors.New("authorized keys unavailable")
+	}
+	if agent == "" {
+		s.logger.Warn().
+			Str("remote_addr", meta.RemoteAddr().String()).
+			Str("key", ssh.FingerprintSHA256(key)).
+			Msg("Rejected SSH connection with an unknown key")
+		return nil, fmt.Errorf("unknown key for %s", meta.User())
+	}
+	return &ssh.Permissions{Extensions: map[string]string{"agent": agent}}, nil
+}
+
+// serveConn runs one agent connection until it closes
+func (s *Server) serveConn(nc net.Conn) {
+	conn, chans, reqs, err := ssh.NewServerConn(nc, s.sshConfig)
+	if err != nil {
+		s.logger.Debug().Err(err).Str("remote_addr", nc.RemoteAddr().String()).Msg("SSH handshake failed")
+		nc.Close()
+		return
+	}
+	agent := conn.Permissions.Extensions["agent"]
+	logger := s.logger.With().Str("agent", agent).Str("remote_addr", conn.RemoteAddr().String()).Logger()
+	logger.Info().Msg("Agent connected")
+
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+
+	// Agents only register reverse forwards; they get no shell and can't
+	// reach anything through the server
+	go func() {
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "only reverse forwards (ssh -R) are allowed")
+		}
+	}()
+
+	held := make(map[int]*forward)
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			var msg channelForwardMsg
+			if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			f, err := s.listen(agent, conn, msg)
+			if err != nil {
+				logger.Warn().Err(err).Uint32("requested_port", msg.Port).Msg("Rejected reverse forward")
+				req.Reply(false, nil)
+				continue
+			}
+			held[f.info.Port] = f
+			var reply []byte
+			if msg.Port == 0 {
+				reply = ssh.Marshal(struct{ Port uint32 }{uint32(f.info.Port)})
+			}
+			req.Reply(true, reply)
+			logger.Info().Int("port", f.info.Port).Str("bind_address", s.config.BindAddress).Msg("Reverse forward registered")
+
+		case "cancel-tcpip-forward":
+			var msg channelForwardMsg
+			if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			f := findForward(held, msg)
+			if f == nil {
+				req.Reply(false, nil)
+				continue
+			}
+			delete(held, f.info.Port)
+			s.release(f)
+			req.Reply(true, nil)
+			logger.Info().Int("port", f.info.Port).Msg("Reverse forward cancelled")
+
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+
+	for _, f := range held {
+		s.release(f)
+	}
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	logger.Info().Int("forwards", len(held)).Msg("Agent disconnected")
+}
+
+// findForward returns the forward a cancel-tcpip-forward request refers to
+func findForward(held map[int]*forward, msg channelForwardMsg) *forward {
+	for _, f := range held {
+		if f.info.RequestedAddr == msg.Addr && (f.info.Port == int(msg.Port) || f.info.RequestedPort == int(msg.Port)) {
+			return f
+		}
+	}
+	return nil
+}
+
+// listen allocates a port for the agent's forward and starts serving it
+func (s *Server) listen(agent string, conn *ssh.ServerConn, msg channelForwardMsg) (*forward, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	held := 0
+	for _, f := range s.forwards {
+		if f.info.Agent == agent {
+			held++
+		}
+	}
+	if held >= s.config.MaxForwardsPerAgent {
+		return nil, fmt.Errorf("agent already holds %d forwards", held)
+	}
+
+	requested := int(msg.Port)
+	if requested != 0 && (requested < s.config.PortRangeStart || requested > s.config.PortRangeEnd) {
+		return nil, fmt.Errorf("port %d is outside the allowed range %d-%d", requested, s.config.PortRangeStart, s.config.PortRangeEnd)
+	}
+
+	for _, port := range s.candidatePorts(agent, requested) {
+		listener, err := net.Listen("tcp", net.JoinHostPort(s.config.BindAddress, strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		f := &forward{
+			info: Forward{
+				Agent:         agent,
+				Port:          port,
+				RequestedAddr: msg.Addr,
+				RequestedPort: requested,
+				RemoteAddr:    conn.RemoteAddr().String(),
+				ConnectedAt:   time.Now(),
+			},
+			listener: listener,
+		}
+		s.forwards[port] = f
+		s.remember(agent, port)
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.acceptLoop(conn, f)
+		}()
+		return f, nil
+	}
+	if requested != 0 {
+		return nil, fmt.Errorf("port %d is not available", requested)
+	}
+	return nil, errors.New("no free port in the allowed range")
+}
+
+// candidatePorts lists the ports to try for a forward, in order: the
+// requested one; otherwise the ports the agent held before, so reconnecting
+// agents keep their ports, then ports nobody held, then the rest. It must
+// be called with s.mu held.
+func (s *Server) candidatePorts(agent string, requested int) []int {
+	if requested != 0 {
+		if _, taken := s.forwards[requested]; taken {
+			return nil
+		}
+		return []int{requested}
+	}
+
+	reserved := make(map[int]bool)
+	for other, ports := range s.lastPorts {
+		if other == agent {
+			continue
+		}
+		for _, port := range ports {
+			reserved[port] = true
+		}
+	}
+
+	var own, fresh, others []int
+	for _, port := range s.lastPorts[agent] {
+		if _, taken := s.forwards[port]; !taken {
+			own = append(own, port)
+		}
+	}
+	for port := s.config.PortRangeStart; port <= s.config.PortRangeEnd; port++ {
+		if _, taken := s.forwards[port]; taken {
+			continue
+		}
+		if reserved[port] {
+			others = append(others, port)
+		} else {
+			fresh = append(fresh, port)
+		}
+	}
+	return append(append(own, fresh...), others...)
+}
+
+// remember records that the agent held port. It must be called with s.mu
+// held.
+func (s *Server) remember(agent string, port int) {
+	for _, held := range s.lastPorts[agent] {
+		if held == port {
+			return
+		}
+	}
+	s.lastPorts[agent] = append(s.lastPorts[agent], port)
+	for other, ports := range s.lastPorts {
+		if other == agent {
+			continue
+		}
+		for i, held := range ports {
+			if held == port {
+				s.lastPorts[other] = append(ports[:i:i], ports[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// release stops serving a forward and frees its port
+func (s *Server) release(f *forward) {
+	f.listener.Close()
+	s.mu.Lock()
+	if s.forwards[f.info.Port] == f {
+		delete(s.forwards, f.info.Port)
+	}
+	s.mu.Unlock()
+}
+
+// acceptLoop hands each connection to the forward's port to the agent over
+// a forwarded-tcpip channel
+func (s *Server) acceptLoop(conn *ssh.ServerConn, f *forward) {
+	for {
+		client, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.forwardConn(conn, f, client)
+		}()
+	}
+}
+
+// forwardConn copies one connection to and from the agent
+func (s *Server) forwardConn(conn *ssh.ServerConn, f *forward, client net.Conn) {
+	defer client.Close()
+
+	originHost, originPortStr, _ := net.SplitHostPort(client.RemoteAddr().String())
+	originPort, _ := strconv.Atoi(originPortStr)
+	port := f.info.RequestedPort
+	if port == 0 {
+		port = f.info.Port
+	}
+	payload := ssh.Marshal(forwardedTCPPayload{
+		Addr:       f.info.RequestedAddr,
+		Port:       uint32(port),
+		OriginAddr: originHost,
+		OriginPort: uint32(originPort),
+	})
+	channel, reqs, err := conn.OpenChannel("forwarded-tcpip", payload)
+	if err != nil {
+		s.logger.Debug().Err(err).Str("agent", f.info.Agent).Int("port", f.info.Port).Msg("Agent refused forwarded connection")
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(reqs)
+
+	f.conns.Add(1)
+	defer f.conns.Add(-1)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(channel, client)
+		channel.CloseWrite()
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, channel)
+		if tcp, ok := client.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// authorizedKeys maps agent keys to agent names, re-reading the file when
+// it changes
+type authorizedKeys struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	agents  map[string]string // marshaled key -> agent name
+}
+
+// lookup returns the name of the agent key belongs to, or "" if the key is
+// not authorized. A nil key only (re)loads the file.
+func (k *authorizedKeys) lookup(key ssh.PublicKey) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return "", fmt.Errorf("authorized keys: %w", err)
+	}
+	if k.agents == nil || !info.ModTime().Equal(k.modTime) || info.Size() != k.size {
+		data, err := os.ReadFile(k.path)
+		if err != nil {
+			return "", fmt.Errorf("authorized keys: %w", err)
+		}
+		agents, err := parseAuthorizedKeys(data)
+		if err != nil {
+			return "", fmt.Errorf("authorized keys %s: %w", k.path, err)
+		}
+		k.agents, k.modTime, k.size = agents, info.ModTime(), info.Size()
+	}
+
+	if key == nil {
+		return "", nil
+	}
+	return k.agents[string(key.Marshal())], nil
+}
+
+// parseAuthorizedKeys parses an authorized_keys file whose comments name the
+// agents
+func parseAuthorizedKeys(data []byte) (map[string]string, error) {
+	agents := make(map[string]string)
+	for line, rest := 1, data; len(bytes.TrimSpace(rest)) > 0; line++ {
+		var current []byte
+		current, rest, _ = bytes.Cut(rest, []byte("\n"))
+		current = bytes.TrimSpace(current)
+		if len(current) == 0 || current[0] == '#' {
+			continue
+		}
+		key, comment, _, _, err := ssh.ParseAuthorizedKey(current)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if comment == "" {
+			return nil, fmt.Errorf("line %d: the key's comment must name the agent", line)
+		}
+		agents[string(key.Marshal())] = comment
+	}
+	return agents, nil
+}
+
+// loadOrGenerateHostKey loads the PEM host key at path, generating and
+// saving an ed25519 key if there is none
+func loadOrGenerateHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("host key %s: %w", path, err)
+		}
+		return signer, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("host key: %w", err)
+	}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate host key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "lazytunnel")
+	if err != nil {
+		return nil, fmt.Errorf("encode host key: %w", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, fmt.Errorf("save host key: %w", err)
+	}
+	return ssh.NewSignerFromKey(private)
+}
@@ -0,0 +1,231 @@
+package sshserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newAgentKey returns a new agent key and its authorized_keys line
+func newAgentKey(t *testing.T, name string) (ssh.Signer, string) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	signer, _ := ssh.NewSignerFromKey(private)
+	sshPublic, _ := ssh.NewPublicKey(public)
+	line := string(ssh.MarshalAuthorizedKey(sshPublic))
+	return signer, line[:len(line)-1] + " " + name + "\n"
+}
+
+// freePortRange returns a port that was free a moment ago, to start the
+// allocation range at
+func freePortRange(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startServer starts a server authorizing the given authorized_keys lines
+func startServer(t *testing.T, config Config, authorized ...string) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	keys := filepath.Join(dir, "authorized_keys")
+	var data string
+	for _, line := range authorized {
+		data += line
+	}
+	if err := os.WriteFile(keys, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Addr = "127.0.0.1:0"
+	config.HostKeyPath = filepath.Join(dir, "host_key")
+	config.AuthorizedKeysPath = keys
+	if config.PortRangeStart == 0 {
+		config.PortRangeStart = freePortRange(t)
+		config.PortRangeEnd = config.PortRangeStart + 4
+	}
+	s, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	return s
+}
+
+// dialAgent connects to the server as an agent with key
+func dialAgent(t *testing.T, s *Server, key ssh.Signer) (*ssh.Client, error) {
+	t.Helper()
+	client, err := ssh.Dial("tcp", s.Addr(), &ssh.ClientConfig{
+		User:            "agent",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(key)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         2 * time.Second,
+	})
+	if err == nil {
+		t.Cleanup(func() { client.Close() })
+	}
+	return client, err
+}
+
+// serveEcho echoes back every connection accepted on l
+func serveEcho(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+	}
+}
+
+func TestReverseForward(t *testing.T) {
+	key, line := newAgentKey(t, "edge-1")
+	s := startServer(t, Config{}, line)
+
+	client, err := dialAgent(t, s, key)
+	if err != nil {
+		t.Fatalf("Failed to connect as an agent: %v", err)
+	}
+	remote, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Reverse forward failed: %v", err)
+	}
+	go serveEcho(remote)
+
+	port := remote.Addr().(*net.TCPAddr).Port
+	if port < s.config.PortRangeStart || port > s.config.PortRangeEnd {
+		t.Fatalf("Allocated port %d outside the range %d-%d", port, s.config.PortRangeStart, s.config.PortRangeEnd)
+	}
+
+	// Connections to the allocated port reach the agent
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("Failed to connect to the forwarded port: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Echo through the forward = %q, %v", buf, err)
+	}
+
+	forwards := s.Forwards()
+	if len(forwards) != 1 || forwards[0].Agent != "edge-1" || forwards[0].Port != port {
+		t.Fatalf("Forwards() = %+v, want edge-1 on port %d", forwards, port)
+	}
+
+	// Agents get no shell or onward connections
+	if _, err := client.NewSession(); err == nil {
+		t.Error("Expected session channels to be rejected")
+	}
+	if _, err := client.Dial("tcp", "127.0.0.1:22"); err == nil {
+		t.Error("Expected direct-tcpip channels to be rejected")
+	}
+}
+
+func TestRejectsUnknownKeys(t *testing.T) {
+	_, line := newAgentKey(t, "edge-1")
+	s := startServer(t, Config{}, line)
+
+	stranger, _ := newAgentKey(t, "stranger")
+	if _, err := dialAgent(t, s, stranger); err == nil {
+		t.Fatal("Expected an unauthorized key to be rejected")
+	}
+
+	// Keys added to the file are picked up without a restart
+	newcomer, newcomerLine := newAgentKey(t, "newcomer")
+	os.WriteFile(s.config.AuthorizedKeysPath, []byte(line+newcomerLine), 0600)
+	if _, err := dialAgent(t, s, newcomer); err != nil {
+		t.Errorf("Expected a newly authorized key to connect, got %v", err)
+	}
+}
+
+func TestPortAllocation(t *testing.T) {
+	key, line := newAgentKey(t, "edge-1")
+	otherKey, otherLine := newAgentKey(t, "edge-2")
+	s := startServer(t, Config{MaxForwardsPerAgent: 2}, line, otherLine)
+
+	client, err := dialAgent(t, s, key)
+	if err != nil {
+		t.Fatalf("Failed to connect as an agent: %v", err)
+	}
+
+	// Ports outside the range are refused
+	if _, err := client.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(s.config.PortRangeEnd+1))); err == nil {
+		t.Error("Expected a port outside the range to be refused")
+	}
+
+	// A requested port inside the range is honored
+	first, err := client.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(s.config.PortRangeEnd)))
+	if err != nil {
+		t.Fatalf("Requested port refused: %v", err)
+	}
+	if _, err := client.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("Second forward refused: %v", err)
+	}
+	if _, err := client.Listen("tcp", "127.0.0.1:0"); err == nil {
+		t.Error("Expected forwards beyond the per-agent limit to be refused")
+	}
+
+	// Another agent can't take a port in use
+	other, err := dialAgent(t, s, otherKey)
+	if err != nil {
+		t.Fatalf("Failed to connect as the second agent: %v", err)
+	}
+	if _, err := other.Listen("tcp", first.Addr().String()); err == nil {
+		t.Error("Expected a port held by another agent to be refused")
+	}
+	otherForward, err := other.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Second agent's forward refused: %v", err)
+	}
+	otherPort := otherForward.Addr().(*net.TCPAddr).Port
+
+	// A reconnecting agent gets its port back
+	other.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.Forwards()) != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	other, err = dialAgent(t, s, otherKey)
+	if err != nil {
+		t.Fatalf("Failed to reconnect: %v", err)
+	}
+	again, err := other.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Forward after reconnect refused: %v", err)
+	}
+	if port := again.Addr().(*net.TCPAddr).Port; port != otherPort {
+		t.Errorf("Expected the reconnecting agent to get port %d back, got %d", otherPort, port)
+	}
+}
+
+func TestAuthorizedKeysNeedAgentNames(t *testing.T) {
+	_, line := newAgentKey(t, "")
+	if _, err := parseAuthorizedKeys([]byte(line)); err == nil {
+		t.Error("Expected a key without a comment to be rejected")
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -12,6 +14,10 @@ import (
 	"github.com/craigderington/lazytunnel/pkg/types"
 )
 
+// ErrNameConflict is returned by Save when another tunnel of the project
+// already has the name
+var ErrNameConflict = errors.New("tunnel name already in use")
+
 // SQLiteStore provides persistent storage for tunnel specifications
 type SQLiteStore struct {
 	db *sql.DB
@@ -39,10 +45,44 @@ func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
 	return store, nil
 }
 
-// initSchema creates the database schema
-func (s *SQLiteStore) initSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS tunnels (
+// columnMigrations lists columns added after the initial schema, applied in order
+var columnMigrations = []struct {
+	name       string
+	definition string
+}{
+	{"local_bind_address", `local_bind_address TEXT DEFAULT '127.0.0.1'`},
+	{"agent_id", `agent_id TEXT DEFAULT ''`},
+	{"desired_status", `desired_status TEXT DEFAULT 'stopped'`},
+	{"public_subdomain", `public_subdomain TEXT DEFAULT ''`},
+	{"routes", `routes TEXT DEFAULT '[]'`}, // JSON array of CIDRs
+	{"protocol", `protocol TEXT DEFAULT 'tcp'`},
+	{"tcp_options", `tcp_options TEXT DEFAULT '{}'`},       // JSON TCPOptions
+	{"staleness", `staleness TEXT DEFAULT '{}'`},           // JSON StalePolicy
+	{"restart_policy", `restart_policy TEXT DEFAULT '{}'`}, // JSON RestartPolicy
+	{"targets", `targets TEXT DEFAULT '[]'`},               // JSON array of host:port
+	{"balance", `balance TEXT DEFAULT '{}'`},               // JSON BalancePolicy
+	{"port_mappings", `port_mappings TEXT DEFAULT '[]'`},   // JSON array of PortMapping
+	{"deleted_at", `deleted_at TIMESTAMP`},                 // set while soft-deleted
+	{"project", `project TEXT DEFAULT 'default'`},
+	{"interpolated", `interpolated TEXT DEFAULT '{}'`}, // JSON map of field path to ${VAR} template
+	{"hooks", `hooks TEXT DEFAULT '{}'`},               // JSON Hooks
+	{"depends_on", `depends_on TEXT DEFAULT '[]'`},     // JSON array of tunnel names
+	{"keep_alive_max", `keep_alive_max INTEGER DEFAULT 0`},
+	{"drain_timeout", `drain_timeout INTEGER DEFAULT 0`}, // seconds
+	{"backoff", `backoff TEXT DEFAULT '{}'`},             // JSON BackoffPolicy
+	{"local_family", `local_family TEXT DEFAULT ''`},     // ipv4, ipv6 or empty for any
+	{"system_proxy", `system_proxy BOOLEAN DEFAULT 0`},
+	{"pac", `pac TEXT DEFAULT '{}'`}, // JSON PACPolicy
+}
+
+// tunnelColumns is the column list shared by all tunnel SELECT queries
+const tunnelColumns = `id, name, owner, project, agent_id, desired_status, type, protocol, hops, local_port, local_bind_address, local_family,
+		       remote_host, remote_port, public_subdomain, routes, system_proxy, pac, tcp_options, staleness, restart_policy, targets, balance, port_mappings, interpolated, hooks, depends_on, auto_reconnect, keep_alive, keep_alive_max, drain_timeout, max_retries, backoff, status, created_at, updated_at, deleted_at`
+
+// tunnelsTable creates the tunnels table, given its name, as first
+// released; columnMigrations adds the later columns
+const tunnelsTable = `
+	CREATE TABLE IF NOT EXISTS %s (
 		id TEXT PRIMARY KEY,
 		name TEXT NOT NULL,
 		owner TEXT NOT NULL,
@@ -57,41 +97,179 @@ func (s *SQLiteStore) initSchema() error {
 		max_retries INTEGER NOT NULL,
 		status TEXT NOT NULL, -- active, stopped, failed, etc.
 		created_at TIMESTAMP NOT NULL,
-		updated_at TIMESTAMP NOT NULL,
-		UNIQUE(name)
+		updated_at TIMESTAMP NOT NULL
 	);
+`
 
+// tunnelIndexes indexes the tunnels table once all its columns exist.
+// Names are unique within a project.
+const tunnelIndexes = `
 	CREATE INDEX IF NOT EXISTS idx_tunnels_status ON tunnels(status);
 	CREATE INDEX IF NOT EXISTS idx_tunnels_owner ON tunnels(owner);
 	CREATE INDEX IF NOT EXISTS idx_tunnels_created_at ON tunnels(created_at DESC);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_tunnels_project_name ON tunnels(project, name);
+`
+
+// initSchema creates the database schema
+func (s *SQLiteStore) initSchema() error {
+	schema := fmt.Sprintf(tunnelsTable, "tunnels") + `
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY, -- JWT ID (jti)
+		user_id TEXT NOT NULL,
+		username TEXT NOT NULL,
+		device TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		issued_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+
+	CREATE TABLE IF NOT EXISTS instances (
+		id TEXT PRIMARY KEY,
+		address TEXT NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		heartbeat_at TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS leases (
+		name TEXT PRIMARY KEY,
+		holder TEXT NOT NULL, -- instance ID
+		expires_at INTEGER NOT NULL -- unix nanoseconds, compared in SQL
+	);
+
+	CREATE TABLE IF NOT EXISTS tunnel_metrics (
+		tunnel_id TEXT NOT NULL,
+		timestamp INTEGER NOT NULL, -- unix nanoseconds, compared in SQL
+		state TEXT NOT NULL,
+		bytes_sent INTEGER NOT NULL,
+		bytes_received INTEGER NOT NULL,
+		connections INTEGER NOT NULL,
+		active_conns INTEGER NOT NULL,
+		errors INTEGER NOT NULL,
+		latency INTEGER NOT NULL -- nanoseconds
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_tunnel_metrics_tunnel ON tunnel_metrics(tunnel_id, timestamp);
+	CREATE INDEX IF NOT EXISTS idx_tunnel_metrics_timestamp ON tunnel_metrics(timestamp);
+
+	CREATE TABLE IF NOT EXISTS managed_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		owner TEXT NOT NULL,
+		type TEXT NOT NULL,
+		fingerprint TEXT NOT NULL,
+		public_key TEXT NOT NULL,
+		private_key BLOB NOT NULL, -- encrypted by the keyring
+		created_at TIMESTAMP NOT NULL,
+		rotated_at TIMESTAMP,
+		previous_type TEXT, -- the key pair a rotation in progress replaced
+		previous_fingerprint TEXT,
+		previous_public_key TEXT,
+		previous_private_key BLOB
+	);
+
+	CREATE TABLE IF NOT EXISTS address_book (
+		name TEXT PRIMARY KEY,
+		host TEXT NOT NULL,
+		port INTEGER NOT NULL DEFAULT 0,
+		user TEXT NOT NULL DEFAULT '',
+		auth_method TEXT NOT NULL DEFAULT '',
+		key_id TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		owner TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	);
 	`
 
 	if _, err := s.db.Exec(schema); err != nil {
 		return fmt.Errorf("failed to create schema: %w", err)
 	}
 
-	// Add local_bind_address column if it doesn't exist (for backward compatibility)
-	if _, err := s.db.Exec(`
-		ALTER TABLE tunnels ADD COLUMN local_bind_address TEXT DEFAULT '127.0.0.1'
-	`); err != nil {
-		if !isDuplicateColumnError(err) {
-			return fmt.Errorf("failed to add local_bind_address column: %w", err)
+	// Add columns introduced after the initial schema (for backward compatibility)
+	for _, column := range columnMigrations {
+		if _, err := s.db.Exec(`ALTER TABLE tunnels ADD COLUMN ` + column.definition); err != nil {
+			if !isDuplicateColumnError(err) {
+				return fmt.Errorf("failed to add %s column: %w", column.name, err)
+			}
 		}
 	}
 
-	if _, err := s.db.Exec(`ALTER TABLE tunnels ADD COLUMN agent_id TEXT DEFAULT ''`); err != nil {
-		if !isDuplicateColumnError(err) {
-			return fmt.Errorf("failed to add agent_id column: %w", err)
-		}
+	if err := s.migrateNameUniqueness(); err != nil {
+		return fmt.Errorf("failed to scope tunnel names to projects: %w", err)
+	}
+	if _, err := s.db.Exec(tunnelIndexes); err != nil {
+		return fmt.Errorf("failed to index tunnels: %w", err)
 	}
 
-	if _, err := s.db.Exec(`ALTER TABLE tunnels ADD COLUMN desired_status TEXT DEFAULT 'stopped'`); err != nil {
-		if !isDuplicateColumnError(err) {
-			return fmt.Errorf("failed to add desired_status column: %w", err)
+	return nil
+}
+
+// migrateNameUniqueness rebuilds a tunnels table from before names were
+// unique per project rather than across projects. SQLite can't drop the
+// old table constraint, so the rows are copied to a new table.
+func (s *SQLiteStore) migrateNameUniqueness() error {
+	var definition string
+	err := s.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'tunnels'`).Scan(&definition)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(definition, "UNIQUE(name)") {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	columns, err := tableColumns(tx, "tunnels")
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(tunnelsTable, "tunnels_rebuild")); err != nil {
+		return err
+	}
+	for _, column := range columnMigrations {
+		if _, err := tx.Exec(`ALTER TABLE tunnels_rebuild ADD COLUMN ` + column.definition); err != nil && !isDuplicateColumnError(err) {
+			return err
+		}
+	}
+	list := strings.Join(columns, ", ")
+	statements := []string{
+		`INSERT INTO tunnels_rebuild (` + list + `) SELECT ` + list + ` FROM tunnels`,
+		// Takes the old indexes with it; initSchema creates them again
+		`DROP TABLE tunnels`,
+		`ALTER TABLE tunnels_rebuild RENAME TO tunnels`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
 		}
 	}
+	return tx.Commit()
+}
 
-	return nil
+// tableColumns returns the names of a table's columns
+func tableColumns(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
 }
 
 // isDuplicateColumnError checks if error is about duplicate column
@@ -125,39 +303,164 @@ func (s *SQLiteStore) Save(ctx context.Context, spec *types.TunnelSpec) error {
 		return fmt.Errorf("failed to marshal hops: %w", err)
 	}
 
+	routesJSON, err := json.Marshal(spec.Routes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal routes: %w", err)
+	}
+
+	pacJSON, err := json.Marshal(spec.PAC)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pac policy: %w", err)
+	}
+
+	tcpJSON, err := json.Marshal(spec.TCP)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tcp options: %w", err)
+	}
+
+	stalenessJSON, err := json.Marshal(spec.Staleness)
+	if err != nil {
+		return fmt.Errorf("failed to marshal staleness policy: %w", err)
+	}
+
+	restartJSON, err := json.Marshal(spec.Restart)
+	if err != nil {
+		return fmt.Errorf("failed to marshal restart policy: %w", err)
+	}
+
+	backoffJSON, err := json.Marshal(spec.Backoff)
+	if err != nil {
+		return fmt.Errorf("failed to marshal backoff policy: %w", err)
+	}
+
+	targetsJSON, err := json.Marshal(spec.Targets)
+	if err != nil {
+		return fmt.Errorf("failed to marshal targets: %w", err)
+	}
+
+	balanceJSON, err := json.Marshal(spec.Balance)
+	if err != nil {
+		return fmt.Errorf("failed to marshal balance policy: %w", err)
+	}
+
+	portsJSON, err := json.Marshal(spec.Ports)
+	if err != nil {
+		return fmt.Errorf("failed to marshal port mappings: %w", err)
+	}
+
+	interpolatedJSON, err := json.Marshal(spec.Interpolated)
+	if err != nil {
+		return fmt.Errorf("failed to marshal interpolated fields: %w", err)
+	}
+
+	hooksJSON, err := json.Marshal(spec.Hooks)
+	if err != nil {
+		return fmt.Errorf("failed to marshal hooks: %w", err)
+	}
+
+	dependsOnJSON, err := json.Marshal(spec.DependsOn)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dependencies: %w", err)
+	}
+
 	desired := string(spec.DesiredStatus)
 	if desired == "" {
 		desired = "stopped"
 	}
 
+	protocol := string(spec.Protocol)
+	if protocol == "" {
+		protocol = string(types.ProtocolTCP)
+	}
+
+	// Upsert rather than replace so re-saving a spec keeps its runtime status
 	query := `
-		INSERT OR REPLACE INTO tunnels (
-			id, name, owner, agent_id, desired_status, type, hops, local_port, local_bind_address,
-			remote_host, remote_port, auto_reconnect, keep_alive, max_retries, status, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO tunnels (
+			id, name, owner, project, agent_id, desired_status, type, protocol, hops, local_port, local_bind_address, local_family,
+			remote_host, remote_port, public_subdomain, routes, system_proxy, pac, tcp_options, staleness, restart_policy, targets, balance, port_mappings, interpolated, hooks, depends_on, auto_reconnect, keep_alive, keep_alive_max, drain_timeout, max_retries, backoff, status, created_at, updated_at, deleted_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			name = excluded.name,
+			owner = excluded.owner,
+			project = excluded.project,
+			agent_id = excluded.agent_id,
+			desired_status = excluded.desired_status,
+			type = excluded.type,
+			protocol = excluded.protocol,
+			hops = excluded.hops,
+			local_port = excluded.local_port,
+			local_bind_address = excluded.local_bind_address,
+			local_family = excluded.local_family,
+			remote_host = excluded.remote_host,
+			remote_port = excluded.remote_port,
+			public_subdomain = excluded.public_subdomain,
+			routes = excluded.routes,
+			system_proxy = excluded.system_proxy,
+			pac = excluded.pac,
+			tcp_options = excluded.tcp_options,
+			staleness = excluded.staleness,
+			restart_policy = excluded.restart_policy,
+			targets = excluded.targets,
+			balance = excluded.balance,
+			port_mappings = excluded.port_mappings,
+			interpolated = excluded.interpolated,
+			hooks = excluded.hooks,
+			depends_on = excluded.depends_on,
+			auto_reconnect = excluded.auto_reconnect,
+			keep_alive = excluded.keep_alive,
+			keep_alive_max = excluded.keep_alive_max,
+			drain_timeout = excluded.drain_timeout,
+			max_retries = excluded.max_retries,
+			backoff = excluded.backoff,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at,
+			deleted_at = excluded.deleted_at
 	`
 
 	_, err = s.db.ExecContext(ctx, query,
 		spec.ID,
 		spec.Name,
 		spec.Owner,
+		spec.ProjectName(),
 		spec.AgentID,
 		desired,
 		spec.Type,
+		protocol,
 		string(hopsJSON),
 		spec.LocalPort,
 		spec.LocalBindAddress,
+		spec.LocalFamily,
 		spec.RemoteHost,
 		spec.RemotePort,
+		spec.PublicSubdomain,
+		string(routesJSON),
+		spec.SystemProxy,
+		string(pacJSON),
+		string(tcpJSON),
+		string(stalenessJSON),
+		string(restartJSON),
+		string(targetsJSON),
+		string(balanceJSON),
+		string(portsJSON),
+		string(interpolatedJSON),
+		string(hooksJSON),
+		string(dependsOnJSON),
 		spec.AutoReconnect,
 		int(spec.KeepAlive.Seconds()),
+		spec.KeepAliveMax,
+		int(spec.DrainTimeout.Seconds()),
 		spec.MaxRetries,
+		string(backoffJSON),
 		"stopped",
 		spec.CreatedAt,
 		spec.UpdatedAt,
+		spec.DeletedAt,
 	)
 
 	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: tunnels.project, tunnels.name") {
+			return fmt.Errorf("failed to save tunnel %q: %w", spec.Name, ErrNameConflict)
+		}
 		return fmt.Errorf("failed to save tunnel: %w", err)
 	}
 
@@ -217,67 +520,37 @@ func (s *SQLiteStore) Delete(ctx context.Context, tunnelID string) error {
 		return fmt.Errorf("tunnel not found: %s", tunnelID)
 	}
 
+	// Its metrics history goes with it
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM tunnel_metrics WHERE tunnel_id = ?`, tunnelID); err != nil {
+		return fmt.Errorf("failed to delete tunnel metrics: %w", err)
+	}
+
 	return nil
 }
 
 // Get retrieves a tunnel spec by ID
 func (s *SQLiteStore) Get(ctx context.Context, tunnelID string) (*types.TunnelSpec, error) {
 	query := `
-		SELECT id, name, owner, agent_id, desired_status, type, hops, local_port, local_bind_address,
-		       remote_host, remote_port, auto_reconnect, keep_alive, max_retries, status, created_at, updated_at
+		SELECT ` + tunnelColumns + `
 		FROM tunnels
 		WHERE id = ?
 	`
 
-	var spec types.TunnelSpec
-	var hopsJSON string
-	var keepAliveSeconds int
-	var status string
-	var desired string
-
-	err := s.db.QueryRowContext(ctx, query, tunnelID).Scan(
-		&spec.ID,
-		&spec.Name,
-		&spec.Owner,
-		&spec.AgentID,
-		&desired,
-		&spec.Type,
-		&hopsJSON,
-		&spec.LocalPort,
-		&spec.LocalBindAddress,
-		&spec.RemoteHost,
-		&spec.RemotePort,
-		&spec.AutoReconnect,
-		&keepAliveSeconds,
-		&spec.MaxRetries,
-		&status,
-		&spec.CreatedAt,
-		&spec.UpdatedAt,
-	)
-
-	if err == sql.ErrNoRows {
+	spec, err := scanTunnelRow(s.db.QueryRowContext(ctx, query, tunnelID))
+	if errors.Is(err, sql.ErrNoRows) {
 		return nil, fmt.Errorf("tunnel not found: %s", tunnelID)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tunnel: %w", err)
 	}
 
-	// Unmarshal hops
-	if err := json.Unmarshal([]byte(hopsJSON), &spec.Hops); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal hops: %w", err)
-	}
-
-	spec.KeepAlive = time.Duration(keepAliveSeconds) * time.Second
-	spec.DesiredStatus = types.DesiredStatus(desired)
-
-	return &spec, nil
+	return spec, nil
 }
 
 // List retrieves all tunnel specs
 func (s *SQLiteStore) List(ctx context.Context) ([]*types.TunnelSpec, error) {
 	query := `
-		SELECT id, name, owner, agent_id, desired_status, type, hops, local_port, local_bind_address,
-		       remote_host, remote_port, auto_reconnect, keep_alive, max_retries, status, created_at, updated_at
+		SELECT ` + tunnelColumns + `
 		FROM tunnels
 		ORDER BY created_at DESC
 	`
@@ -305,13 +578,13 @@ func (s *SQLiteStore) List(ctx context.Context) ([]*types.TunnelSpec, error) {
 	return specs, nil
 }
 
-// ListByAgent returns tunnels assigned to a specific agent.
+// ListByAgent returns tunnels assigned to a specific agent, leaving out
+// deleted ones
 func (s *SQLiteStore) ListByAgent(ctx context.Context, agentID string) ([]*types.TunnelSpec, error) {
 	query := `
-		SELECT id, name, owner, agent_id, desired_status, type, hops, local_port, local_bind_address,
-		       remote_host, remote_port, auto_reconnect, keep_alive, max_retries, status, created_at, updated_at
+		SELECT ` + tunnelColumns + `
 		FROM tunnels
-		WHERE agent_id = ?
+		WHERE agent_id = ? AND deleted_at IS NULL
 		ORDER BY created_at DESC
 	`
 	rows, err := s.db.QueryContext(ctx, query, agentID)
@@ -331,31 +604,177 @@ func (s *SQLiteStore) ListByAgent(ctx context.Context, agentID string) ([]*types
 	return specs, rows.Err()
 }
 
-func scanTunnelRow(rows *sql.Rows) (*types.TunnelSpec, error) {
+// ListStatuses returns the last persisted runtime status of every tunnel, keyed by ID
+func (s *SQLiteStore) ListStatuses(ctx context.Context) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, status FROM tunnels`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tunnel statuses: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]string)
+	for rows.Next() {
+		var id, status string
+		if err := rows.Scan(&id, &status); err != nil {
+			return nil, fmt.Errorf("failed to scan tunnel status: %w", err)
+		}
+		statuses[id] = status
+	}
+	return statuses, rows.Err()
+}
+
+// sessionColumns is the column list shared by session SELECT queries
+const sessionColumns = `id, user_id, username, device, ip, issued_at, expires_at, revoked_at`
+
+// CreateSession records an issued token
+func (s *SQLiteStore) CreateSession(ctx context.Context, session *types.Session) error {
+	query := `INSERT INTO sessions (` + sessionColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, session.ID, session.UserID, session.Username, session.Device,
+		session.IP, session.IssuedAt, session.ExpiresAt, session.RevokedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// GetSession retrieves a session by ID, nil if there is none
+func (s *SQLiteStore) GetSession(ctx context.Context, id string) (*types.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = ?`
+
+	session, err := scanSessionRow(s.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return session, nil
+}
+
+// ListSessions retrieves a user's unrevoked, unexpired sessions, newest first
+func (s *SQLiteStore) ListSessions(ctx context.Context, userID string) ([]*types.Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL
+		ORDER BY issued_at DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var sessions []*types.Session
+	for rows.Next() {
+		session, err := scanSessionRow(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		if session.Active(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession marks a session's token as revoked
+func (s *SQLiteStore) RevokeSession(ctx context.Context, id string) error {
+	query := `UPDATE sessions SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?`
+
+	result, err := s.db.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("session not found: %s", id)
+	}
+	return nil
+}
+
+func scanSessionRow(row rowScanner) (*types.Session, error) {
+	var session types.Session
+	var revokedAt sql.NullTime
+	if err := row.Scan(&session.ID, &session.UserID, &session.Username, &session.Device, &session.IP,
+		&session.IssuedAt, &session.ExpiresAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	return &session, nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTunnelRow(row rowScanner) (*types.TunnelSpec, error) {
 	var spec types.TunnelSpec
 	var hopsJSON string
+	var routesJSON sql.NullString
+	var pacJSON sql.NullString
+	var tcpJSON sql.NullString
+	var stalenessJSON sql.NullString
+	var restartJSON sql.NullString
+	var backoffJSON sql.NullString
+	var targetsJSON sql.NullString
+	var balanceJSON sql.NullString
+	var portsJSON sql.NullString
+	var interpolatedJSON sql.NullString
+	var hooksJSON sql.NullString
+	var dependsOnJSON sql.NullString
 	var keepAliveSeconds int
+	var keepAliveMax sql.NullInt64
+	var drainSeconds sql.NullInt64
 	var status string
 	var desired string
+	var protocol sql.NullString
+	var deletedAt sql.NullTime
+	var project sql.NullString
 
-	err := rows.Scan(
+	err := row.Scan(
 		&spec.ID,
 		&spec.Name,
 		&spec.Owner,
+		&project,
 		&spec.AgentID,
 		&desired,
 		&spec.Type,
+		&protocol,
 		&hopsJSON,
 		&spec.LocalPort,
 		&spec.LocalBindAddress,
+		&spec.LocalFamily,
 		&spec.RemoteHost,
 		&spec.RemotePort,
+		&spec.PublicSubdomain,
+		&routesJSON,
+		&spec.SystemProxy,
+		&pacJSON,
+		&tcpJSON,
+		&stalenessJSON,
+		&restartJSON,
+		&targetsJSON,
+		&balanceJSON,
+		&portsJSON,
+		&interpolatedJSON,
+		&hooksJSON,
+		&dependsOnJSON,
 		&spec.AutoReconnect,
 		&keepAliveSeconds,
+		&keepAliveMax,
+		&drainSeconds,
 		&spec.MaxRetries,
+		&backoffJSON,
 		&status,
 		&spec.CreatedAt,
 		&spec.UpdatedAt,
+		&deletedAt,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan tunnel: %w", err)
@@ -363,11 +782,402 @@ func scanTunnelRow(rows *sql.Rows) (*types.TunnelSpec, error) {
 	if err := json.Unmarshal([]byte(hopsJSON), &spec.Hops); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal hops: %w", err)
 	}
+	if routesJSON.Valid && routesJSON.String != "" {
+		if err := json.Unmarshal([]byte(routesJSON.String), &spec.Routes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal routes: %w", err)
+		}
+	}
+	if pacJSON.Valid && pacJSON.String != "" {
+		if err := json.Unmarshal([]byte(pacJSON.String), &spec.PAC); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal pac policy: %w", err)
+		}
+	}
+	if tcpJSON.Valid && tcpJSON.String != "" {
+		if err := json.Unmarshal([]byte(tcpJSON.String), &spec.TCP); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal tcp options: %w", err)
+		}
+	}
+	if stalenessJSON.Valid && stalenessJSON.String != "" {
+		if err := json.Unmarshal([]byte(stalenessJSON.String), &spec.Staleness); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal staleness policy: %w", err)
+		}
+	}
+	if restartJSON.Valid && restartJSON.String != "" {
+		if err := json.Unmarshal([]byte(restartJSON.String), &spec.Restart); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal restart policy: %w", err)
+		}
+	}
+	if backoffJSON.Valid && backoffJSON.String != "" {
+		if err := json.Unmarshal([]byte(backoffJSON.String), &spec.Backoff); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal backoff policy: %w", err)
+		}
+	}
+	if targetsJSON.Valid && targetsJSON.String != "" {
+		if err := json.Unmarshal([]byte(targetsJSON.String), &spec.Targets); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal targets: %w", err)
+		}
+	}
+	if balanceJSON.Valid && balanceJSON.String != "" {
+		if err := json.Unmarshal([]byte(balanceJSON.String), &spec.Balance); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal balance policy: %w", err)
+		}
+	}
+	if portsJSON.Valid && portsJSON.String != "" {
+		if err := json.Unmarshal([]byte(portsJSON.String), &spec.Ports); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal port mappings: %w", err)
+		}
+	}
+	if interpolatedJSON.Valid && interpolatedJSON.String != "" {
+		if err := json.Unmarshal([]byte(interpolatedJSON.String), &spec.Interpolated); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal interpolated fields: %w", err)
+		}
+	}
+	if hooksJSON.Valid && hooksJSON.String != "" {
+		if err := json.Unmarshal([]byte(hooksJSON.String), &spec.Hooks); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal hooks: %w", err)
+		}
+	}
+	if dependsOnJSON.Valid && dependsOnJSON.String != "" {
+		if err := json.Unmarshal([]byte(dependsOnJSON.String), &spec.DependsOn); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal dependencies: %w", err)
+		}
+	}
 	spec.KeepAlive = time.Duration(keepAliveSeconds) * time.Second
+	spec.KeepAliveMax = int(keepAliveMax.Int64)
+	spec.DrainTimeout = time.Duration(drainSeconds.Int64) * time.Second
 	spec.DesiredStatus = types.DesiredStatus(desired)
+	spec.Protocol = types.Protocol(protocol.String)
+	spec.Project = project.String
+	if deletedAt.Valid {
+		spec.DeletedAt = &deletedAt.Time
+	}
 	return &spec, nil
 }
 
+// Heartbeat records that an instance is alive, registering it if needed
+func (s *SQLiteStore) Heartbeat(ctx context.Context, instance *types.Instance) error {
+	query := `
+		INSERT INTO instances (id, address, started_at, heartbeat_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			address = excluded.address,
+			started_at = excluded.started_at,
+			heartbeat_at = excluded.heartbeat_at
+	`
+	_, err := s.db.ExecContext(ctx, query, instance.ID, instance.Address, instance.StartedAt, instance.HeartbeatAt)
+	if err != nil {
+		return fmt.Errorf("failed to record heartbeat: %w", err)
+	}
+	return nil
+}
+
+// ListInstances retrieves the registered instances, oldest first
+func (s *SQLiteStore) ListInstances(ctx context.Context) ([]*types.Instance, error) {
+	query := `SELECT id, address, started_at, heartbeat_at FROM instances ORDER BY started_at, id`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances: %w", err)
+	}
+	defer rows.Close()
+
+	var instances []*types.Instance
+	for rows.Next() {
+		var instance types.Instance
+		if err := rows.Scan(&instance.ID, &instance.Address, &instance.StartedAt, &instance.HeartbeatAt); err != nil {
+			return nil, fmt.Errorf("failed to scan instance: %w", err)
+		}
+		instances = append(instances, &instance)
+	}
+	return instances, rows.Err()
+}
+
+// RemoveInstance unregisters an instance
+func (s *SQLiteStore) RemoveInstance(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM instances WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to remove instance: %w", err)
+	}
+	return nil
+}
+
+// AcquireLease takes the named lease for holder, or renews it if holder
+// already has it, until ttl from now. It reports whether holder has the
+// lease: another holder keeps it until it expires.
+func (s *SQLiteStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	query := `
+		INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			holder = excluded.holder,
+			expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at < ?
+	`
+	result, err := s.db.ExecContext(ctx, query, name, holder, now.Add(ttl).UnixNano(), now.UnixNano())
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// ReleaseLease gives up the named lease if holder has it, so another
+// instance can take it without waiting for it to expire
+func (s *SQLiteStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM leases WHERE name = ? AND holder = ?`, name, holder); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return nil
+}
+
+// LeaseHolder returns who holds the named lease, empty if nobody does or
+// it has expired
+func (s *SQLiteStore) LeaseHolder(ctx context.Context, name string) (string, error) {
+	var holder string
+	err := s.db.QueryRowContext(ctx, `SELECT holder FROM leases WHERE name = ? AND expires_at >= ?`,
+		name, time.Now().UnixNano()).Scan(&holder)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get lease holder: %w", err)
+	}
+	return holder, nil
+}
+
+// SaveSnapshots stores tunnel metrics snapshots
+func (s *SQLiteStore) SaveSnapshots(ctx context.Context, snapshots []*types.MetricsSnapshot) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save snapshots: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO tunnel_metrics (tunnel_id, timestamp, state, bytes_sent, bytes_received,
+			connections, active_conns, errors, latency)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to save snapshots: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, snap := range snapshots {
+		_, err := stmt.ExecContext(ctx, snap.TunnelID, snap.Timestamp.UnixNano(), string(snap.State),
+			snap.BytesSent, snap.BytesReceived, snap.Connections, snap.ActiveConns, snap.Errors, int64(snap.Latency))
+		if err != nil {
+			return fmt.Errorf("failed to save snapshots: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save snapshots: %w", err)
+	}
+	return nil
+}
+
+// ListSnapshots retrieves a tunnel's metrics snapshots taken in
+// [since, until), oldest first
+func (s *SQLiteStore) ListSnapshots(ctx context.Context, tunnelID string, since, until time.Time) ([]*types.MetricsSnapshot, error) {
+	query := `
+		SELECT timestamp, state, bytes_sent, bytes_received, connections, active_conns, errors, latency
+		FROM tunnel_metrics
+		WHERE tunnel_id = ? AND timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp
+	`
+	rows, err := s.db.QueryContext(ctx, query, tunnelID, since.UnixNano(), until.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	var snapshots []*types.MetricsSnapshot
+	for rows.Next() {
+		var timestamp, latency int64
+		var state string
+		snap := types.MetricsSnapshot{TunnelID: tunnelID}
+		if err := rows.Scan(&timestamp, &state, &snap.BytesSent, &snap.BytesReceived,
+			&snap.Connections, &snap.ActiveConns, &snap.Errors, &latency); err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot: %w", err)
+		}
+		snap.Timestamp = time.Unix(0, timestamp)
+		snap.State = types.TunnelState(state)
+		snap.Latency = time.Duration(latency)
+		snapshots = append(snapshots, &snap)
+	}
+	return snapshots, rows.Err()
+}
+
+// PruneSnapshots deletes the metrics snapshots taken before a time
+func (s *SQLiteStore) PruneSnapshots(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM tunnel_metrics WHERE timestamp < ?`, before.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune snapshots: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// managedKeyColumns is the column list shared by managed key queries
+const managedKeyColumns = `id, name, owner, type, fingerprint, public_key, private_key, created_at, rotated_at,
+	previous_type, previous_fingerprint, previous_public_key, previous_private_key`
+
+// SaveManagedKey creates a managed key or replaces the one with its ID
+func (s *SQLiteStore) SaveManagedKey(ctx context.Context, key *types.ManagedKey) error {
+	var previousType, previousFingerprint, previousPublicKey sql.NullString
+	var previousPrivateKey []byte
+	if key.Previous != nil {
+		previousType = sql.NullString{String: key.Previous.Type, Valid: true}
+		previousFingerprint = sql.NullString{String: key.Previous.Fingerprint, Valid: true}
+		previousPublicKey = sql.NullString{String: key.Previous.PublicKey, Valid: true}
+		previousPrivateKey = key.Previous.PrivateKey
+	}
+
+	query := `INSERT OR REPLACE INTO managed_keys (` + managedKeyColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, key.ID, key.Name, key.Owner, key.Type, key.Fingerprint, key.PublicKey,
+		key.PrivateKey, key.CreatedAt, key.RotatedAt,
+		previousType, previousFingerprint, previousPublicKey, previousPrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to save managed key: %w", err)
+	}
+	return nil
+}
+
+// GetManagedKey retrieves a managed key by ID, nil if there is none
+func (s *SQLiteStore) GetManagedKey(ctx context.Context, id string) (*types.ManagedKey, error) {
+	query := `SELECT ` + managedKeyColumns + ` FROM managed_keys WHERE id = ?`
+
+	key, err := scanManagedKeyRow(s.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get managed key: %w", err)
+	}
+	return key, nil
+}
+
+// ListManagedKeys retrieves all managed keys, oldest first
+func (s *SQLiteStore) ListManagedKeys(ctx context.Context) ([]*types.ManagedKey, error) {
+	query := `SELECT ` + managedKeyColumns + ` FROM managed_keys ORDER BY created_at`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list managed keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*types.ManagedKey
+	for rows.Next() {
+		key, err := scanManagedKeyRow(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan managed key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteManagedKey removes a managed key
+func (s *SQLiteStore) DeleteManagedKey(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM managed_keys WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete managed key: %w", err)
+	}
+	return nil
+}
+
+func scanManagedKeyRow(row rowScanner) (*types.ManagedKey, error) {
+	var key types.ManagedKey
+	var rotatedAt sql.NullTime
+	var previousType, previousFingerprint, previousPublicKey sql.NullString
+	var previousPrivateKey []byte
+	if err := row.Scan(&key.ID, &key.Name, &key.Owner, &key.Type, &key.Fingerprint, &key.PublicKey,
+		&key.PrivateKey, &key.CreatedAt, &rotatedAt,
+		&previousType, &previousFingerprint, &previousPublicKey, &previousPrivateKey); err != nil {
+		return nil, err
+	}
+	if rotatedAt.Valid {
+		key.RotatedAt = &rotatedAt.Time
+	}
+	if previousType.Valid {
+		key.Previous = &types.ManagedKeyVersion{
+			Type:        previousType.String,
+			Fingerprint: previousFingerprint.String,
+			PublicKey:   previousPublicKey.String,
+			PrivateKey:  previousPrivateKey,
+		}
+	}
+	return &key, nil
+}
+
+// addressColumns is the column list shared by address book queries
+const addressColumns = `name, host, port, user, auth_method, key_id, description, owner, created_at, updated_at`
+
+// SaveAddress creates an address book entry or replaces the one with its name
+func (s *SQLiteStore) SaveAddress(ctx context.Context, entry *types.AddressBookEntry) error {
+	query := `INSERT OR REPLACE INTO address_book (` + addressColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, entry.Name, entry.Host, entry.Port, entry.User, string(entry.AuthMethod),
+		entry.KeyID, entry.Description, entry.Owner, entry.CreatedAt, entry.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save address book entry: %w", err)
+	}
+	return nil
+}
+
+// GetAddress retrieves an address book entry by name, nil if there is none
+func (s *SQLiteStore) GetAddress(ctx context.Context, name string) (*types.AddressBookEntry, error) {
+	query := `SELECT ` + addressColumns + ` FROM address_book WHERE name = ?`
+
+	entry, err := scanAddressRow(s.db.QueryRowContext(ctx, query, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get address book entry: %w", err)
+	}
+	return entry, nil
+}
+
+// ListAddresses retrieves all address book entries, by name
+func (s *SQLiteStore) ListAddresses(ctx context.Context) ([]*types.AddressBookEntry, error) {
+	query := `SELECT ` + addressColumns + ` FROM address_book ORDER BY name`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list address book entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*types.AddressBookEntry
+	for rows.Next() {
+		entry, err := scanAddressRow(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan address book entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// DeleteAddress removes an address book entry
+func (s *SQLiteStore) DeleteAddress(ctx context.Context, name string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM address_book WHERE name = ?`, name); err != nil {
+		return fmt.Errorf("failed to delete address book entry: %w", err)
+	}
+	return nil
+}
+
+func scanAddressRow(row rowScanner) (*types.AddressBookEntry, error) {
+	var entry types.AddressBookEntry
+	var authMethod string
+	if err := row.Scan(&entry.Name, &entry.Host, &entry.Port, &entry.User, &authMethod, &entry.KeyID,
+		&entry.Description, &entry.Owner, &entry.CreatedAt, &entry.UpdatedAt); err != nil {
+		return nil, err
+	}
+	entry.AuthMethod = types.AuthMethod(authMethod)
+	return &entry, nil
+}
+
 // Close closes the database connection
 func (s *SQLiteStore) Close() error {
 	return s.db.Close()
@@ -0,0 +1,77 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+
+	// maxAcceptFailures is how many unrecognized Accept errors in a row
+	// make a listener count as broken
+	maxAcceptFailures = 10
+)
+
+// acceptBackoff paces an accept loop whose Accept keeps failing, e.g. with
+// EMFILE while the process is out of file descriptors, rather than letting
+// it spin. The pause doubles with each failure in a row, up to a second.
+type acceptBackoff struct {
+	delay    time.Duration
+	failures int // unrecognized errors in a row
+}
+
+// failed records a failed Accept and reports whether the listener is broken
+// for good: closed, or failing with errors that aren't known to pass
+func (b *acceptBackoff) failed(err error) bool {
+	b.delay = max(minAcceptBackoff, min(2*b.delay, maxAcceptBackoff))
+
+	switch {
+	case temporaryAcceptError(err):
+		return false
+	case errors.Is(err, net.ErrClosed), errors.Is(err, io.EOF):
+		return true
+	}
+	b.failures++
+	return b.failures >= maxAcceptFailures
+}
+
+// pause waits out the backoff, returning false if stop or done closes first
+func (b *acceptBackoff) pause(stop, done <-chan struct{}) bool {
+	timer := time.NewTimer(b.delay)
+	defer timer.Stop()
+
+	select {
+	case <-stop:
+		return false
+	case <-done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// reset starts over after a successful Accept
+func (b *acceptBackoff) reset() {
+	*b = acceptBackoff{}
+}
+
+// temporaryAcceptError reports whether an Accept error passes by itself:
+// resources running out until connections close, or a client giving up
+// before its connection was accepted
+func temporaryAcceptError(err error) bool {
+	for _, errno := range []syscall.Errno{
+		syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM,
+		syscall.ECONNABORTED, syscall.ECONNRESET, syscall.EINTR, syscall.EAGAIN,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
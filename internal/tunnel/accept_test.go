@@ -0,0 +1,160 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestAcceptBackoff(t *testing.T) {
+	emfile := &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept4", syscall.EMFILE)}
+
+	var b acceptBackoff
+	for i, want := range []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond} {
+		if b.failed(emfile) {
+			t.Fatalf("EMFILE %d counted as a broken listener", i+1)
+		}
+		if b.delay != want {
+			t.Errorf("Pause after %d failures = %v, want %v", i+1, b.delay, want)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		b.failed(emfile)
+	}
+	if b.delay != maxAcceptBackoff {
+		t.Errorf("Pause = %v, want it capped at %v", b.delay, maxAcceptBackoff)
+	}
+	b.reset()
+	if b.failed(emfile); b.delay != minAcceptBackoff {
+		t.Errorf("Pause after a reset = %v, want %v", b.delay, minAcceptBackoff)
+	}
+
+	for _, err := range []error{net.ErrClosed, io.EOF} {
+		var b acceptBackoff
+		if !b.failed(err) {
+			t.Errorf("%v didn't count as a broken listener", err)
+		}
+	}
+
+	// Errors that may or may not pass are given a few tries
+	b.reset()
+	for i := 1; i < maxAcceptFailures; i++ {
+		if b.failed(errors.New("wedged")) {
+			t.Fatalf("Gave up after %d unknown errors, want %d", i, maxAcceptFailures)
+		}
+	}
+	if !b.failed(errors.New("wedged")) {
+		t.Errorf("Expected %d unknown errors in a row to count as a broken listener", maxAcceptFailures)
+	}
+
+	// A pause ends early when the loop stops
+	stop := make(chan struct{})
+	close(stop)
+	b.delay = time.Hour
+	if b.pause(stop, nil) {
+		t.Error("Expected the pause to end when stopped")
+	}
+}
+
+// failingListeners open listeners whose Accept fails with errs, in turn,
+// then blocks until they are closed
+type failingListeners struct {
+	errs []error
+}
+
+func (f *failingListeners) Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	return &failingListener{errs: f.errs, closed: make(chan struct{})}, nil
+}
+
+func (f *failingListeners) ListenPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	return nil, errors.ErrUnsupported
+}
+
+type failingListener struct {
+	mu        sync.Mutex
+	errs      []error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if len(l.errs) > 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		l.mu.Unlock()
+		return nil, err
+	}
+	l.mu.Unlock()
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *failingListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *failingListener) Addr() net.Addr { return memAddr("127.0.0.1:5432") }
+
+func TestLocalForwarderBacksOffAndReportsBrokenListener(t *testing.T) {
+	emfile := &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept4", syscall.EMFILE)}
+	listeners := &failingListeners{errs: []error{emfile, emfile, emfile, io.EOF}}
+
+	failed := make(chan error, 1)
+	spec := &types.TunnelSpec{Type: types.TunnelTypeLocal, LocalPort: 5432, RemoteHost: "db.internal", RemotePort: 5432}
+	forwarder, err := NewLocalForwarder(context.Background(), spec, &MockSessionDialer{connected: true},
+		WithListenerFactory(listeners), WithListenerFailure(func(err error) { failed <- err }))
+	if err != nil {
+		t.Fatalf("NewLocalForwarder() error = %v", err)
+	}
+	started := time.Now()
+	if err := forwarder.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer forwarder.Stop()
+
+	select {
+	case err := <-failed:
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("Reported %v, want the error that broke the listener", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The broken listener was never reported")
+	}
+	// 5ms, 10ms and 20ms pauses after the EMFILEs
+	if elapsed := time.Since(started); elapsed < 35*time.Millisecond {
+		t.Errorf("Accept was retried without pausing (%v)", elapsed)
+	}
+	if errs := forwarder.Stats().Errors; errs != 4 {
+		t.Errorf("Errors = %d, want one per failed Accept", errs)
+	}
+}
+
+func TestLocalForwarderStopIsNotAListenerFailure(t *testing.T) {
+	failed := make(chan error, 1)
+	spec := &types.TunnelSpec{Type: types.TunnelTypeLocal, LocalPort: 5432, RemoteHost: "db.internal", RemotePort: 5432}
+	forwarder, err := NewLocalForwarder(context.Background(), spec, &MockSessionDialer{connected: true},
+		WithListenerFactory(&failingListeners{}), WithListenerFailure(func(err error) { failed <- err }))
+	if err != nil {
+		t.Fatalf("NewLocalForwarder() error = %v", err)
+	}
+	if err := forwarder.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	forwarder.Stop()
+
+	select {
+	case err := <-failed:
+		t.Errorf("Stopping reported a listener failure: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
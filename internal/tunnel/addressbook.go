@@ -0,0 +1,145 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// AddressBook looks up the named hosts tunnel specs reference as @name
+type AddressBook interface {
+	Address(ctx context.Context, name string) (*types.AddressBookEntry, error)
+}
+
+// ResolveHops returns hops with the address book entries they reference
+// filled in: the entry's host, and its port, user and credentials where the
+// hop leaves them unset. Hops are copied, so the spec keeps referencing the
+// entries and picks up changes to them on its next connection.
+func ResolveHops(ctx context.Context, book AddressBook, hops []types.Hop) ([]types.Hop, error) {
+	resolved := make([]types.Hop, len(hops))
+	for i, hop := range hops {
+		resolved[i] = hop
+		name, ok := types.AddressRef(hop.Host)
+		if !ok {
+			continue
+		}
+		entry, err := lookupAddress(ctx, book, name)
+		if err != nil {
+			return nil, fmt.Errorf("hop %d: %w", i+1, err)
+		}
+
+		h := &resolved[i]
+		h.Host = entry.Host
+		if h.Port == 0 {
+			h.Port = entry.Port
+		}
+		if h.Port == 0 {
+			h.Port = 22
+		}
+		if h.User == "" {
+			h.User = entry.User
+		}
+		switch {
+		case h.AuthMethod == "":
+			h.AuthMethod, h.KeyID = entry.AuthMethod, entry.KeyID
+		case h.AuthMethod == entry.AuthMethod && h.KeyID == "":
+			h.KeyID = entry.KeyID
+		}
+	}
+	return resolved, nil
+}
+
+// ResolveTarget returns the host:port destination a target references as
+// @name:port with the entry's host, and any other target as it is
+func ResolveTarget(ctx context.Context, book AddressBook, target string) (string, error) {
+	name, port, ok := types.AddressRefPort(target)
+	if !ok {
+		return target, nil
+	}
+	entry, err := lookupAddress(ctx, book, name)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(entry.Host, port), nil
+}
+
+// ResolveSpec returns a copy of spec with the address book entries its hops
+// and destinations reference filled in, for checks that need the real hosts
+func ResolveSpec(ctx context.Context, book AddressBook, spec *types.TunnelSpec) (*types.TunnelSpec, error) {
+	hops, err := ResolveHops(ctx, book, spec.Hops)
+	if err != nil {
+		return nil, err
+	}
+	resolved := *spec
+	resolved.Hops = hops
+
+	resolveHost := func(host string) (string, error) {
+		name, ok := types.AddressRef(host)
+		if !ok {
+			return host, nil
+		}
+		entry, err := lookupAddress(ctx, book, name)
+		if err != nil {
+			return "", err
+		}
+		return entry.Host, nil
+	}
+	if resolved.RemoteHost, err = resolveHost(spec.RemoteHost); err != nil {
+		return nil, err
+	}
+	resolved.Targets = slices.Clone(spec.Targets)
+	for i, target := range spec.Targets {
+		if resolved.Targets[i], err = ResolveTarget(ctx, book, target); err != nil {
+			return nil, err
+		}
+	}
+	resolved.Ports = slices.Clone(spec.Ports)
+	for i := range resolved.Ports {
+		if resolved.Ports[i].RemoteHost, err = resolveHost(spec.Ports[i].RemoteHost); err != nil {
+			return nil, err
+		}
+	}
+	return &resolved, nil
+}
+
+func lookupAddress(ctx context.Context, book AddressBook, name string) (*types.AddressBookEntry, error) {
+	if book == nil {
+		return nil, fmt.Errorf("no address book to look up %s%s in", types.AddressRefPrefix, name)
+	}
+	entry, err := book.Address(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s%s: %w", types.AddressRefPrefix, name, err)
+	}
+	return entry, nil
+}
+
+// target returns the destination to dial for addr, looking up an address
+// book reference in it
+func (f socketFactories) target(ctx context.Context, addr string) (string, error) {
+	return ResolveTarget(ctx, f.addresses, addr)
+}
+
+// WithAddressBook makes forwarders look up the address book entries their
+// destinations reference in book
+func WithAddressBook(book AddressBook) ForwarderOption {
+	return func(f *socketFactories) {
+		f.addresses = book
+	}
+}
+
+// SetAddressBook sets where the entries tunnels reference as @name are
+// looked up, from their next connection on
+func (m *Manager) SetAddressBook(book AddressBook) {
+	m.addressBook.Store(&book)
+}
+
+// AddressBook returns the address book set with SetAddressBook, or nil
+func (m *Manager) AddressBook() AddressBook {
+	if book := m.addressBook.Load(); book != nil {
+		return *book
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// fakeAddressBook is an AddressBook of fixed entries
+type fakeAddressBook map[string]types.AddressBookEntry
+
+func (b fakeAddressBook) Address(ctx context.Context, name string) (*types.AddressBookEntry, error) {
+	entry, ok := b[name]
+	if !ok {
+		return nil, errors.New("address book entry not found")
+	}
+	return &entry, nil
+}
+
+func TestResolveHops(t *testing.T) {
+	book := fakeAddressBook{
+		"bastion": {Name: "bastion", Host: "10.0.0.1", Port: 2222, User: "ops", AuthMethod: types.AuthMethodKey, KeyID: "managed://k1"},
+		"app":     {Name: "app", Host: "app.internal"},
+	}
+	hops := []types.Hop{
+		{Host: "@bastion"},
+		{Host: "@bastion", Port: 22, User: "root", AuthMethod: types.AuthMethodAgent},
+		{Host: "@bastion", AuthMethod: types.AuthMethodKey},
+		{Host: "@app", User: "deploy", AuthMethod: types.AuthMethodAgent},
+		{Host: "db.internal", Port: 22, User: "dba", AuthMethod: types.AuthMethodAgent},
+	}
+	want := []types.Hop{
+		{Host: "10.0.0.1", Port: 2222, User: "ops", AuthMethod: types.AuthMethodKey, KeyID: "managed://k1"},
+		{Host: "10.0.0.1", Port: 22, User: "root", AuthMethod: types.AuthMethodAgent},
+		{Host: "10.0.0.1", Port: 2222, User: "ops", AuthMethod: types.AuthMethodKey, KeyID: "managed://k1"},
+		{Host: "app.internal", Port: 22, User: "deploy", AuthMethod: types.AuthMethodAgent},
+		hops[4],
+	}
+
+	got, err := ResolveHops(context.Background(), book, hops)
+	if err != nil {
+		t.Fatalf("ResolveHops() error = %v", err)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("hop %d = %+v, want %+v", i+1, got[i], want[i])
+		}
+	}
+	if hops[0].Host != "@bastion" {
+		t.Error("Expected ResolveHops to leave the spec's hops referencing the entry")
+	}
+
+	if _, err := ResolveHops(context.Background(), book, []types.Hop{{Host: "@missing"}}); err == nil {
+		t.Error("Expected an unknown entry to fail")
+	}
+	if _, err := ResolveHops(context.Background(), nil, []types.Hop{{Host: "@bastion"}}); err == nil {
+		t.Error("Expected a reference without an address book to fail")
+	}
+	if got, err := ResolveHops(context.Background(), nil, hops[4:]); err != nil || got[0] != hops[4] {
+		t.Errorf("ResolveHops() without references = %+v, %v", got, err)
+	}
+}
+
+func TestResolveSpec(t *testing.T) {
+	book := fakeAddressBook{"db": {Name: "db", Host: "10.0.1.5", Port: 22}}
+	spec := &types.TunnelSpec{
+		RemoteHost: "@db",
+		Targets:    []string{"@db:5432", "replica:5432"},
+		Ports:      []types.PortMapping{{RemoteHost: "@db", RemotePort: 6379}},
+		Hops:       []types.Hop{{Host: "bastion", Port: 22, User: "ops"}},
+	}
+
+	resolved, err := ResolveSpec(context.Background(), book, spec)
+	if err != nil {
+		t.Fatalf("ResolveSpec() error = %v", err)
+	}
+	if resolved.RemoteHost != "10.0.1.5" || resolved.Targets[0] != "10.0.1.5:5432" || resolved.Targets[1] != "replica:5432" ||
+		resolved.Ports[0].RemoteHost != "10.0.1.5" {
+		t.Errorf("ResolveSpec() = %+v", resolved)
+	}
+	if spec.RemoteHost != "@db" || spec.Targets[0] != "@db:5432" || spec.Ports[0].RemoteHost != "@db" {
+		t.Error("Expected ResolveSpec to leave the spec as it was")
+	}
+}
+
+func TestLocalForwarderResolvesTargetReference(t *testing.T) {
+	book := fakeAddressBook{"db": {Name: "db", Host: "10.0.1.5"}}
+	var dialed string
+	session := &MockSessionDialer{connected: true, dialFunc: func(network, address string) (net.Conn, error) {
+		dialed = address
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}}
+	spec := &types.TunnelSpec{Type: types.TunnelTypeLocal, LocalBindAddress: "127.0.0.1", LocalPort: 5432,
+		RemoteHost: "@db", RemotePort: 5432}
+	forwarder, err := NewLocalForwarder(context.Background(), spec, session, WithAddressBook(book))
+	if err != nil {
+		t.Fatalf("NewLocalForwarder() error = %v", err)
+	}
+
+	conn, err := forwarder.dialTarget("@db:5432")
+	if err != nil {
+		t.Fatalf("dialTarget() error = %v", err)
+	}
+	conn.Close()
+	if dialed != "10.0.1.5:5432" {
+		t.Errorf("Dialed %s, want the entry's host", dialed)
+	}
+}
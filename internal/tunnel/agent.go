@@ -0,0 +1,27 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+)
+
+// AgentSocket returns where the local SSH agent listens: SSH_AUTH_SOCK, or
+// on Windows the OpenSSH agent's named pipe when it is unset. It is empty
+// when no agent is configured.
+func AgentSocket() string {
+	return agentSocket()
+}
+
+// DialAgent connects to the local SSH agent
+func DialAgent() (io.ReadWriteCloser, error) {
+	socket := agentSocket()
+	if socket == "" {
+		return nil, fmt.Errorf("SSH_AUTH_SOCK not set")
+	}
+
+	conn, err := dialAgentSocket(socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SSH agent at %s: %w", socket, err)
+	}
+	return conn, nil
+}
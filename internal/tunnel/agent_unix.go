@@ -0,0 +1,23 @@
+//go:build !windows
+
+package tunnel
+
+import (
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+func agentSocket() string {
+	return os.Getenv("SSH_AUTH_SOCK")
+}
+
+func dialAgentSocket(socket string) (io.ReadWriteCloser, error) {
+	return net.DialTimeout("unix", socket, 5*time.Second)
+}
+
+// expandEnvPath leaves paths alone; only Windows uses %VAR% references
+func expandEnvPath(path string) string {
+	return path
+}
@@ -0,0 +1,44 @@
+//go:build windows
+
+package tunnel
+
+import (
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// windowsAgentPipe is where the Windows OpenSSH agent service listens
+const windowsAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+func agentSocket() string {
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		return socket
+	}
+	return windowsAgentPipe
+}
+
+// dialAgentSocket opens a named pipe, or a unix socket for agents that
+// provide one (Windows supports AF_UNIX since Windows 10)
+func dialAgentSocket(socket string) (io.ReadWriteCloser, error) {
+	if strings.HasPrefix(socket, `\\.\pipe\`) {
+		return os.OpenFile(socket, os.O_RDWR, 0)
+	}
+	return net.DialTimeout("unix", socket, 5*time.Second)
+}
+
+var windowsEnvRef = regexp.MustCompile(`%[^%]+%`)
+
+// expandEnvPath expands %VAR% references like cmd.exe does, leaving
+// unknown variables as they are
+func expandEnvPath(path string) string {
+	return windowsEnvRef.ReplaceAllStringFunc(path, func(ref string) string {
+		if value, ok := os.LookupEnv(ref[1 : len(ref)-1]); ok {
+			return value
+		}
+		return ref
+	})
+}
@@ -0,0 +1,71 @@
+package tunnel
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// AttachFunc returns an established SSH connection to reuse
+type AttachFunc func() (*ssh.Client, error)
+
+// attachedClient returns an AttachFunc borrowing the connection to another
+// tunnel's first hop. The tunnel is looked up on each attach, so reconnects
+// pick up its new connection.
+func (m *Manager) attachedClient(tunnelID string) AttachFunc {
+	return func() (*ssh.Client, error) {
+		owner, err := m.Get(tunnelID)
+		if err != nil {
+			return nil, fmt.Errorf("attached tunnel: %w", err)
+		}
+		if session := owner.firstHop(); session != nil && session.IsConnected() {
+			if client := session.Client(); client != nil {
+				return client, nil
+			}
+		}
+		return nil, fmt.Errorf("tunnel %s isn't connected", owner.Spec.Name)
+	}
+}
+
+// CanAttach reports whether a hop can reuse the connection of the tunnel:
+// the tunnel's first hop must be the same server, logged into as the same
+// user
+func CanAttach(spec *types.TunnelSpec, hop types.Hop) error {
+	if len(spec.Hops) == 0 {
+		return fmt.Errorf("tunnel %s has no hops", spec.Name)
+	}
+	first := spec.Hops[0]
+	if !strings.EqualFold(first.Host, hop.Host) || first.Port != hop.Port || first.User != hop.User {
+		return fmt.Errorf("tunnel %s connects to %s@%s:%d, not %s@%s:%d",
+			spec.Name, first.User, first.Host, first.Port, hop.User, hop.Host, hop.Port)
+	}
+	return nil
+}
+
+// attachClient borrows the connection to the hop instead of dialing it
+func (s *Session) attachClient() (*ssh.Client, error) {
+	client, err := s.attach()
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach to %s:%d: %w", s.hop.Host, s.hop.Port, err)
+	}
+	s.recordServerInfo(client, "")
+	return client, nil
+}
+
+// firstHop returns the session of the tunnel's first hop, or nil before the
+// tunnel has one
+func (t *Tunnel) firstHop() *Session {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.session != nil {
+		return t.session
+	}
+	if t.multiSession != nil {
+		// Hops are fixed once the session is created
+		return t.multiSession.hops[0]
+	}
+	return nil
+}
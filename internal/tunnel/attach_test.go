@@ -0,0 +1,89 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestCanAttach(t *testing.T) {
+	spec := &types.TunnelSpec{Name: "db", Hops: []types.Hop{
+		{Host: "bastion.example.com", Port: 22, User: "ops"},
+		{Host: "db-host.internal", Port: 22, User: "ops"},
+	}}
+	tests := []struct {
+		name    string
+		hop     types.Hop
+		wantErr bool
+	}{
+		{"same hop", types.Hop{Host: "bastion.example.com", Port: 22, User: "ops"}, false},
+		{"host case", types.Hop{Host: "Bastion.Example.com", Port: 22, User: "ops"}, false},
+		{"other user", types.Hop{Host: "bastion.example.com", Port: 22, User: "root"}, true},
+		{"other port", types.Hop{Host: "bastion.example.com", Port: 2222, User: "ops"}, true},
+		{"second hop", types.Hop{Host: "db-host.internal", Port: 22, User: "ops"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CanAttach(spec, tt.hop); (err != nil) != tt.wantErr {
+				t.Errorf("CanAttach() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSessionAttachesToTunnelConnection(t *testing.T) {
+	addr := startTestSSHServer(t, nil, nil)
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+	hop := types.Hop{Host: host, Port: port, User: "testuser", AuthMethod: types.AuthMethodPassword}
+
+	m := NewManager(context.Background())
+	owner := addStoredTunnels(m, &types.TunnelSpec{ID: "owner-1", Name: "owner", Hops: []types.Hop{hop}})["owner"]
+
+	attachedHop := hop
+	attachedHop.Attach = "owner-1"
+	session, err := NewSession(context.Background(), SessionConfig{Hop: &attachedHop, Attach: m.attachedClient("owner-1")})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	if err := session.Connect(); err == nil {
+		t.Fatal("Expected attaching to a disconnected tunnel to fail")
+	}
+
+	ownerSession, err := NewSession(context.Background(), SessionConfig{Hop: &hop})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer ownerSession.Close()
+	ownerSession.config = testClientConfig(hop.User)
+	if err := ownerSession.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	owner.mu.Lock()
+	owner.session = ownerSession
+	owner.mu.Unlock()
+
+	// No credentials: the connection is borrowed, not authenticated again
+	if err := session.Connect(); err != nil {
+		t.Fatalf("Connect() attached error = %v", err)
+	}
+	if session.Client() != ownerSession.Client() {
+		t.Error("Expected the attached session to reuse the tunnel's connection")
+	}
+	if status := session.HopStatus(); !status.Connected || status.ServerVersion != "SSH-2.0-TestBastion_1.0" {
+		t.Errorf("HopStatus() = %+v, want connected to the test bastion", status)
+	}
+
+	// Disconnecting gives the connection back rather than closing it
+	if err := session.Disconnect(); err != nil {
+		t.Fatalf("Disconnect() error = %v", err)
+	}
+	if _, _, err := ownerSession.Client().SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Errorf("Expected the tunnel's connection to stay open, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// authorizedKeyRestrictions take away what a tunnel's key doesn't need:
+// terminals, agent and X11 forwarding, and ~/.ssh/rc. Spelled out rather
+// than as "restrict", which OpenSSH before 7.2 rejects.
+var authorizedKeyRestrictions = []string{"no-pty", "no-agent-forwarding", "no-X11-forwarding", "no-user-rc"}
+
+// AuthorizedKeyOptions limit what a key in authorized_keys may be used for
+type AuthorizedKeyOptions struct {
+	PermitOpen   []string // host:port destinations of local forwards; empty allows any
+	PermitListen []string // [host:]port listeners of remote forwards; empty allows any
+	From         []string // client address patterns the key is accepted from; empty allows any
+}
+
+// AuthorizedKeyOptionsFor returns the destinations a tunnel forwards to
+// through one of its hops: the next hop, or, from the last hop, the
+// tunnel's targets. Dynamic and transparent tunnels' destinations aren't
+// known in advance, so they are left open.
+func AuthorizedKeyOptionsFor(spec *types.TunnelSpec, hop int) (AuthorizedKeyOptions, error) {
+	if hop < 0 || hop >= len(spec.Hops) {
+		return AuthorizedKeyOptions{}, fmt.Errorf("hop %d out of range: the tunnel has %d", hop, len(spec.Hops))
+	}
+
+	var opts AuthorizedKeyOptions
+	if hop < len(spec.Hops)-1 {
+		next := spec.Hops[hop+1]
+		opts.PermitOpen = []string{net.JoinHostPort(next.Host, strconv.Itoa(next.Port))}
+		return opts, nil
+	}
+
+	switch spec.Type {
+	case types.TunnelTypeLocal:
+		switch {
+		case len(spec.Ports) > 0:
+			for _, port := range spec.Ports {
+				opts.PermitOpen = append(opts.PermitOpen, net.JoinHostPort(port.RemoteHost, strconv.Itoa(port.RemotePort)))
+			}
+		case len(spec.Targets) > 0:
+			opts.PermitOpen = append(opts.PermitOpen, spec.Targets...)
+		default:
+			opts.PermitOpen = []string{net.JoinHostPort(spec.RemoteHost, strconv.Itoa(spec.RemotePort))}
+		}
+	case types.TunnelTypeRemote:
+		opts.PermitListen = []string{strconv.Itoa(spec.RemotePort)}
+	}
+	return opts, nil
+}
+
+// AuthorizedKeyLine returns an authorized_keys line that accepts
+// publicKey, itself in authorized_keys format, only for forwarding as opts
+// allow
+func AuthorizedKeyLine(publicKey, comment string, opts AuthorizedKeyOptions) (string, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+
+	for name, values := range map[string][]string{
+		"permitopen":   opts.PermitOpen,
+		"permitlisten": opts.PermitListen,
+		"from":         opts.From,
+	} {
+		for _, value := range values {
+			if value == "" || strings.ContainsAny(value, "\" \t\r\n,") {
+				return "", fmt.Errorf("invalid %s value %q", name, value)
+			}
+		}
+	}
+
+	options := append([]string(nil), authorizedKeyRestrictions...)
+	for _, dest := range opts.PermitOpen {
+		options = append(options, fmt.Sprintf("permitopen=%q", dest))
+	}
+	for _, listen := range opts.PermitListen {
+		options = append(options, fmt.Sprintf("permitlisten=%q", listen))
+	}
+	if len(opts.From) > 0 {
+		options = append(options, fmt.Sprintf("from=%q", strings.Join(opts.From, ",")))
+	}
+
+	line := strings.Join(options, ",") + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	if comment = strings.Join(strings.Fields(comment), "_"); comment != "" {
+		line += " " + comment
+	}
+	return line, nil
+}
+
+// installTimeout bounds connecting to the host a key is installed on; the
+// whole installation gets twice as long
+const installTimeout = 10 * time.Second
+
+// installAuthorizedKeyCommand appends the line read from its second input
+// line to ~/.ssh/authorized_keys, unless the key on its first line is
+// already there, and says which it did. Both come from stdin so that
+// nothing needs quoting for the remote shell.
+const installAuthorizedKeyCommand = `umask 077 && mkdir -p ~/.ssh && touch ~/.ssh/authorized_keys && ` +
+	`read -r key && read -r line && ` +
+	`if grep -qF "$key" ~/.ssh/authorized_keys; then echo present; ` +
+	`else printf '%s\n' "$line" >> ~/.ssh/authorized_keys && echo added; fi`
+
+// InstallAuthorizedKey connects to hop directly, not through its via or
+// transport settings, and adds line, an authorized_keys line, to the
+// user's authorized_keys unless its key is already there. It authenticates
+// with password if one is given, and as the hop does otherwise. It reports
+// whether the line was added.
+func InstallAuthorizedKey(ctx context.Context, hop types.Hop, password string, keys KeySource, line string) (bool, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return false, fmt.Errorf("invalid authorized_keys line: %w", err)
+	}
+
+	if password != "" {
+		hop.AuthMethod = types.AuthMethodPassword
+	}
+	session, err := NewSession(ctx, SessionConfig{Hop: &hop, Keys: keys})
+	if err != nil {
+		return false, err
+	}
+	defer session.Close()
+
+	config, err := session.installConfig(password)
+	if err != nil {
+		return false, err
+	}
+	dialer := net.Dialer{Timeout: installTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(hop.Host, strconv.Itoa(hop.Port)))
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to %s: %w", hop.Host, err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(2 * installTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, conn.RemoteAddr().String(), config)
+	if err != nil {
+		return false, fmt.Errorf("failed to authenticate to %s: %w", hop.Host, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	remote, err := client.NewSession()
+	if err != nil {
+		return false, fmt.Errorf("failed to open session: %w", err)
+	}
+	defer remote.Close()
+
+	keyText := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	remote.Stdin = strings.NewReader(keyText + "\n" + line + "\n")
+	var stdout, stderr bytes.Buffer
+	remote.Stdout = &stdout
+	remote.Stderr = &stderr
+	if err := remote.Run(installAuthorizedKeyCommand); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return false, fmt.Errorf("failed to update authorized_keys: %s", msg)
+		}
+		return false, fmt.Errorf("failed to update authorized_keys: %w", err)
+	}
+
+	switch strings.TrimSpace(stdout.String()) {
+	case "added":
+		return true, nil
+	case "present":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected output updating authorized_keys: %q", stdout.String())
+	}
+}
+
+// installConfig is the client config InstallAuthorizedKey connects with:
+// the hop's, or password authentication, also answering
+// keyboard-interactive password prompts, with the hop's host key checks
+func (s *Session) installConfig(password string) (*ssh.ClientConfig, error) {
+	if password == "" {
+		return s.buildSSHConfig(installTimeout)
+	}
+
+	hostKeyCallback, err := s.buildHostKeyCallback()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build host key callback: %w", err)
+	}
+	answer := func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range questions {
+			answers[i] = password
+		}
+		return answers, nil
+	}
+	return &ssh.ClientConfig{
+		User:            s.hop.User,
+		Timeout:         installTimeout,
+		HostKeyCallback: hostKeyCallback,
+		Auth:            []ssh.AuthMethod{ssh.Password(password), ssh.KeyboardInteractive(answer)},
+	}, nil
+}
@@ -0,0 +1,202 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestAuthorizedKeyOptionsFor(t *testing.T) {
+	hops := []types.Hop{{Host: "bastion", Port: 22}, {Host: "10.0.0.5", Port: 2222}}
+
+	tests := []struct {
+		name string
+		spec types.TunnelSpec
+		hop  int
+		want AuthorizedKeyOptions
+	}{
+		{
+			name: "first of two hops opens the next hop",
+			spec: types.TunnelSpec{Type: types.TunnelTypeLocal, Hops: hops, RemoteHost: "db", RemotePort: 5432},
+			hop:  0,
+			want: AuthorizedKeyOptions{PermitOpen: []string{"10.0.0.5:2222"}},
+		},
+		{
+			name: "last hop opens the target",
+			spec: types.TunnelSpec{Type: types.TunnelTypeLocal, Hops: hops, RemoteHost: "db", RemotePort: 5432},
+			hop:  1,
+			want: AuthorizedKeyOptions{PermitOpen: []string{"db:5432"}},
+		},
+		{
+			name: "multi-port tunnel opens every port",
+			spec: types.TunnelSpec{Type: types.TunnelTypeLocal, Hops: hops[:1], Ports: []types.PortMapping{
+				{RemoteHost: "db", RemotePort: 5432}, {RemoteHost: "cache", RemotePort: 6379},
+			}},
+			want: AuthorizedKeyOptions{PermitOpen: []string{"db:5432", "cache:6379"}},
+		},
+		{
+			name: "load-balanced tunnel opens every target",
+			spec: types.TunnelSpec{Type: types.TunnelTypeLocal, Hops: hops[:1], Targets: []string{"web1:80", "web2:80"}},
+			want: AuthorizedKeyOptions{PermitOpen: []string{"web1:80", "web2:80"}},
+		},
+		{
+			name: "remote tunnel listens on its port",
+			spec: types.TunnelSpec{Type: types.TunnelTypeRemote, Hops: hops[:1], RemotePort: 8080},
+			want: AuthorizedKeyOptions{PermitListen: []string{"8080"}},
+		},
+		{
+			name: "dynamic tunnel is left open",
+			spec: types.TunnelSpec{Type: types.TunnelTypeDynamic, Hops: hops[:1]},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AuthorizedKeyOptionsFor(&tt.spec, tt.hop)
+			if err != nil {
+				t.Fatalf("AuthorizedKeyOptionsFor() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AuthorizedKeyOptionsFor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := AuthorizedKeyOptionsFor(&types.TunnelSpec{Hops: hops}, 2); err == nil {
+		t.Error("AuthorizedKeyOptionsFor() accepted a hop out of range")
+	}
+}
+
+func TestAuthorizedKeyLine(t *testing.T) {
+	publicKey := testPublicKey(t)
+
+	line, err := AuthorizedKeyLine(publicKey, "lazytunnel deploy", AuthorizedKeyOptions{
+		PermitOpen: []string{"db:5432", "cache:6379"},
+		From:       []string{"10.0.0.0/8", "*.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("AuthorizedKeyLine() error = %v", err)
+	}
+	want := `no-pty,no-agent-forwarding,no-X11-forwarding,no-user-rc,permitopen="db:5432",permitopen="cache:6379",` +
+		`from="10.0.0.0/8,*.example.com" ` + publicKey + " lazytunnel_deploy"
+	if line != want {
+		t.Errorf("AuthorizedKeyLine() =\n%s\nwant\n%s", line, want)
+	}
+
+	// sshd must parse it back to the same key with the same options
+	key, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		t.Fatalf("line doesn't parse: %v", err)
+	}
+	if strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) != publicKey || comment != "lazytunnel_deploy" || len(options) != 7 {
+		t.Errorf("parsed %q, %q, %q", ssh.MarshalAuthorizedKey(key), comment, options)
+	}
+
+	if _, err := AuthorizedKeyLine(publicKey, "", AuthorizedKeyOptions{PermitOpen: []string{`db:5432" command="sh`}}); err == nil {
+		t.Error("AuthorizedKeyLine() accepted an option value that breaks out of its quotes")
+	}
+	if _, err := AuthorizedKeyLine("not a key", "", AuthorizedKeyOptions{}); err == nil {
+		t.Error("AuthorizedKeyLine() accepted an invalid public key")
+	}
+}
+
+func TestInstallAuthorizedKey(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to run the install command with")
+	}
+	home := t.TempDir()
+	addr := startTestSSHServer(t, nil, handleShellExec(home))
+	host, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+	hop := types.Hop{Host: host, Port: portNum, User: "deploy", AuthMethod: types.AuthMethodAgent,
+		HostKeyVerification: types.HostKeyVerifyInsecure}
+
+	line, err := AuthorizedKeyLine(testPublicKey(t), "deploy", AuthorizedKeyOptions{PermitOpen: []string{"db:5432"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := InstallAuthorizedKey(context.Background(), hop, "secret", nil, line)
+	if err != nil || !added {
+		t.Fatalf("InstallAuthorizedKey() = %v, %v; want added", added, err)
+	}
+	// Installing again leaves the file alone
+	if added, err := InstallAuthorizedKey(context.Background(), hop, "secret", nil, line); err != nil || added {
+		t.Fatalf("second InstallAuthorizedKey() = %v, %v; want already present", added, err)
+	}
+
+	path := filepath.Join(home, ".ssh", "authorized_keys")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != line+"\n" {
+		t.Errorf("authorized_keys = %q, want just %q", data, line)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0o077 != 0 {
+		t.Errorf("authorized_keys mode = %v, want it private", info.Mode().Perm())
+	}
+}
+
+// handleShellExec runs exec requests with sh, with HOME set to home
+func handleShellExec(home string) func(*ssh.ServerConn, <-chan ssh.NewChannel) {
+	return func(_ *ssh.ServerConn, chans <-chan ssh.NewChannel) {
+		for newCh := range chans {
+			ch, reqs, err := newCh.Accept()
+			if err != nil {
+				continue
+			}
+			go func() {
+				for req := range reqs {
+					if req.Type != "exec" {
+						req.Reply(false, nil)
+						continue
+					}
+					var payload struct{ Command string }
+					ssh.Unmarshal(req.Payload, &payload)
+					req.Reply(true, nil)
+
+					cmd := exec.Command("sh", "-c", payload.Command)
+					cmd.Env = append(os.Environ(), "HOME="+home)
+					cmd.Stdin, cmd.Stdout, cmd.Stderr = ch, ch, ch.Stderr()
+					status := uint32(0)
+					var exitErr *exec.ExitError
+					if err := cmd.Run(); errors.As(err, &exitErr) {
+						status = uint32(exitErr.ExitCode())
+					} else if err != nil {
+						status = 255
+					}
+					ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+					ch.Close()
+				}
+			}()
+		}
+	}
+}
+
+// testPublicKey returns a new ed25519 public key in authorized_keys format
+func testPublicKey(t *testing.T) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
@@ -0,0 +1,61 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestBackoffConfigFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy types.BackoffPolicy
+		want   BackoffConfig
+	}{
+		{
+			name:   "defaults",
+			policy: types.BackoffPolicy{},
+			want:   DefaultBackoffConfig(),
+		},
+		{
+			name:   "aggressive",
+			policy: types.BackoffPolicy{Initial: 100 * time.Millisecond, Max: 2 * time.Second, Multiplier: 1.5, Jitter: 0.2},
+			want:   BackoffConfig{Initial: 100 * time.Millisecond, Max: 2 * time.Second, Multiplier: 1.5, Jitter: 0.2},
+		},
+		{
+			name:   "first delay above the default cap",
+			policy: types.BackoffPolicy{Initial: 2 * time.Minute},
+			want:   BackoffConfig{Initial: 2 * time.Minute, Max: 2 * time.Minute, Multiplier: 2, Jitter: 0.2},
+		},
+		{
+			name:   "without jitter",
+			policy: types.BackoffPolicy{Jitter: -1},
+			want:   BackoffConfig{Initial: time.Second, Max: time.Minute, Multiplier: 2},
+		},
+		{
+			name:   "out of range",
+			policy: types.BackoffPolicy{Multiplier: 0.5, Jitter: 3},
+			want:   BackoffConfig{Initial: time.Second, Max: time.Minute, Multiplier: 2, Jitter: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BackoffConfigFor(tt.policy); got != tt.want {
+				t.Errorf("BackoffConfigFor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpread(t *testing.T) {
+	if got := spread(time.Second, 0); got != time.Second {
+		t.Errorf("spread() without jitter = %s, want 1s", got)
+	}
+	for i := 0; i < 100; i++ {
+		if got := spread(time.Second, 0.2); got < 800*time.Millisecond || got >= 1200*time.Millisecond {
+			t.Fatalf("spread(1s, 0.2) = %s, want within 20%%", got)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package tunnel
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// DefaultHealthCheckInterval is how often pooled targets are probed when a
+// tunnel sets no interval
+const DefaultHealthCheckInterval = 10 * time.Second
+
+// errNoTargets is returned when a pool has nothing to pick from
+var errNoTargets = errors.New("no targets configured")
+
+// poolTarget is one destination in a load-balanced pool
+type poolTarget struct {
+	addr        string
+	healthy     atomic.Bool
+	activeConns atomic.Int64
+	connections atomic.Int64
+	failures    atomic.Int64
+	lastError   atomic.Value // string
+}
+
+// record updates the target after a dial through the tunnel. A failure ejects
+// it until a health check succeeds.
+func (t *poolTarget) record(err error) {
+	if err != nil {
+		t.failures.Add(1)
+		t.lastError.Store(err.Error())
+		t.healthy.Store(false)
+		return
+	}
+	t.connections.Add(1)
+}
+
+// targetPool spreads connections over several destinations, skipping those
+// that failed their last dial or health check
+type targetPool struct {
+	targets  []*poolTarget
+	strategy types.BalanceStrategy
+	next     atomic.Uint64
+}
+
+// newTargetPool creates a pool with every target initially healthy
+func newTargetPool(addrs []string, strategy types.BalanceStrategy) *targetPool {
+	pool := &targetPool{strategy: strategy}
+	for _, addr := range addrs {
+		target := &poolTarget{addr: addr}
+		target.healthy.Store(true)
+		pool.targets = append(pool.targets, target)
+	}
+	return pool
+}
+
+// pick chooses the target for a new connection. When every target has been
+// ejected they are all tried again rather than refusing the connection.
+func (p *targetPool) pick() (*poolTarget, error) {
+	if len(p.targets) == 0 {
+		return nil, errNoTargets
+	}
+
+	candidates := make([]*poolTarget, 0, len(p.targets))
+	for _, target := range p.targets {
+		if target.healthy.Load() {
+			candidates = append(candidates, target)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.targets
+	}
+
+	if p.strategy == types.BalanceLeastConnections {
+		best := candidates[0]
+		for _, target := range candidates[1:] {
+			if target.activeConns.Load() < best.activeConns.Load() {
+				best = target
+			}
+		}
+		return best, nil
+	}
+
+	// Round robin
+	n := p.next.Add(1) - 1
+	return candidates[n%uint64(len(candidates))], nil
+}
+
+// check probes every target with probe, restoring those that answer and
+// ejecting those that don't
+func (p *targetPool) check(probe func(addr string) error) {
+	for _, target := range p.targets {
+		if err := probe(target.addr); err != nil {
+			target.lastError.Store(err.Error())
+			target.healthy.Store(false)
+			continue
+		}
+		target.healthy.Store(true)
+	}
+}
+
+// status reports the state of each target, in configured order
+func (p *targetPool) status() []types.TargetStatus {
+	statuses := make([]types.TargetStatus, len(p.targets))
+	for i, target := range p.targets {
+		lastError, _ := target.lastError.Load().(string)
+		statuses[i] = types.TargetStatus{
+			Address:     target.addr,
+			Healthy:     target.healthy.Load(),
+			ActiveConns: target.activeConns.Load(),
+			Connections: target.connections.Load(),
+			Failures:    target.failures.Load(),
+			LastError:   lastError,
+		}
+		if lastError != "" {
+			statuses[i].FailureReason = types.FailureTargetUnreachable
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,142 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestTargetPoolRoundRobin(t *testing.T) {
+	pool := newTargetPool([]string{"a:80", "b:80", "c:80"}, "")
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		target, err := pool.pick()
+		if err != nil {
+			t.Fatalf("pick failed: %v", err)
+		}
+		got = append(got, target.addr)
+	}
+
+	want := []string{"a:80", "b:80", "c:80", "a:80", "b:80", "c:80"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestTargetPoolLeastConnections(t *testing.T) {
+	pool := newTargetPool([]string{"a:80", "b:80", "c:80"}, types.BalanceLeastConnections)
+	pool.targets[0].activeConns.Store(3)
+	pool.targets[1].activeConns.Store(1)
+	pool.targets[2].activeConns.Store(2)
+
+	target, _ := pool.pick()
+	if target.addr != "b:80" {
+		t.Errorf("Expected least loaded target b:80, got %s", target.addr)
+	}
+}
+
+func TestTargetPoolEjection(t *testing.T) {
+	pool := newTargetPool([]string{"a:80", "b:80"}, "")
+
+	// A failed dial ejects the target
+	pool.targets[0].record(errors.New("connection refused"))
+	for i := 0; i < 4; i++ {
+		if target, _ := pool.pick(); target.addr != "b:80" {
+			t.Fatalf("Expected ejected target to be skipped, got %s", target.addr)
+		}
+	}
+
+	// With every target ejected, all of them are tried again
+	pool.targets[1].record(errors.New("connection refused"))
+	if _, err := pool.pick(); err != nil {
+		t.Fatalf("Expected a target when all are ejected, got %v", err)
+	}
+
+	// A passing health check restores the target
+	pool.check(func(addr string) error {
+		if addr == "a:80" {
+			return nil
+		}
+		return errors.New("connection refused")
+	})
+	status := pool.status()
+	if !status[0].Healthy || status[1].Healthy {
+		t.Errorf("Expected only a:80 healthy, got %+v", status)
+	}
+	if status[0].Failures != 1 || status[1].LastError == "" {
+		t.Errorf("Expected failures to be reported, got %+v", status)
+	}
+}
+
+func TestLocalForwarderFailsOverToHealthyTarget(t *testing.T) {
+	var mu sync.Mutex
+	dialed := make(map[string]int)
+
+	dialer := &MockSessionDialer{
+		connected: true,
+		dialFunc: func(network, address string) (net.Conn, error) {
+			mu.Lock()
+			dialed[address]++
+			mu.Unlock()
+			if address == "dead:80" {
+				return nil, fmt.Errorf("connection refused")
+			}
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		},
+	}
+
+	spec := &types.TunnelSpec{
+		ID:               "balance-1",
+		Type:             types.TunnelTypeLocal,
+		LocalBindAddress: "127.0.0.1",
+		Targets:          []string{"dead:80", "live:80"},
+		TCP:              types.TCPOptions{DialRetries: 1, DialRetryBackoff: time.Millisecond},
+	}
+
+	lf, err := NewLocalForwarder(context.Background(), spec, dialer)
+	if err != nil {
+		t.Fatalf("NewLocalForwarder failed: %v", err)
+	}
+	if err := lf.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer lf.Stop()
+
+	// Connect one at a time so each pick sees the previous dial's result
+	for i := 1; i <= 4; i++ {
+		conn, err := net.Dial("tcp", lf.LocalAddr())
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.Close()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for lf.TargetStatus()[1].Connections != int64(i) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d connections to the live target, got %+v", i, lf.TargetStatus())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if status := lf.TargetStatus()[0]; status.Healthy || status.Failures != 1 {
+		t.Errorf("Expected dead target ejected after one failure, got %+v", status)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if dialed["dead:80"] != 1 {
+		t.Errorf("Expected the dead target to be dialed once, got %d", dialed["dead:80"])
+	}
+}
@@ -0,0 +1,178 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// Defaults for benchmarks run without these settings
+const (
+	DefaultBenchBytes   = 16 << 20
+	DefaultBenchSamples = 10
+)
+
+// BenchOptions configures a tunnel benchmark
+type BenchOptions struct {
+	Hop     int   // hop measured, counting from 0; negative selects the last
+	Bytes   int64 // transferred each way; zero uses the default
+	Samples int   // round trips timed; zero uses the default
+}
+
+// BenchResult is what a tunnel benchmark measured. Throughputs are in bytes
+// per second.
+type BenchResult struct {
+	Hop     int
+	Bytes   int64
+	Samples int
+
+	LatencyMin time.Duration
+	LatencyAvg time.Duration
+	LatencyMax time.Duration
+
+	// Time to open a channel from the last hop to the tunnel's destination;
+	// zero for other hops, tunnels without a single destination, or when
+	// it couldn't be reached
+	ConnectLatency time.Duration
+	ConnectError   string
+
+	Upload   float64
+	Download float64
+}
+
+// Bench measures the tunnel's SSH connection to a hop: round-trip latency
+// of SSH requests, and the throughput of streaming Bytes to and from the
+// hop through a channel, the same way data through the tunnel flows. The
+// hop needs the cat and head commands.
+func (t *Tunnel) Bench(ctx context.Context, opts BenchOptions) (*BenchResult, error) {
+	if opts.Bytes <= 0 {
+		opts.Bytes = DefaultBenchBytes
+	}
+	if opts.Samples <= 0 {
+		opts.Samples = DefaultBenchSamples
+	}
+	client, err := t.hopClient(opts.Hop)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BenchResult{Hop: opts.Hop, Bytes: opts.Bytes, Samples: opts.Samples}
+	var total time.Duration
+	for i := 0; i < opts.Samples; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			return nil, fmt.Errorf("latency probe failed: %w", err)
+		}
+		rtt := time.Since(start)
+		total += rtt
+		if result.LatencyMin == 0 || rtt < result.LatencyMin {
+			result.LatencyMin = rtt
+		}
+		result.LatencyMax = max(result.LatencyMax, rtt)
+	}
+	result.LatencyAvg = total / time.Duration(opts.Samples)
+
+	if addr := t.benchDestination(); addr != "" && opts.Hop < 0 {
+		start := time.Now()
+		conn, err := client.Dial("tcp", addr)
+		if err != nil {
+			result.ConnectError = err.Error()
+		} else {
+			result.ConnectLatency = time.Since(start)
+			conn.Close()
+		}
+	}
+
+	if result.Upload, err = benchTransfer(ctx, client, "cat > /dev/null", opts.Bytes, true); err != nil {
+		return nil, fmt.Errorf("upload failed: %w", err)
+	}
+	download := "head -c " + strconv.FormatInt(opts.Bytes, 10) + " /dev/zero"
+	if result.Download, err = benchTransfer(ctx, client, download, opts.Bytes, false); err != nil {
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+	return result, nil
+}
+
+// benchDestination returns the address a local tunnel forwards to, if it
+// forwards to a single one
+func (t *Tunnel) benchDestination() string {
+	if t.Spec.Type != types.TunnelTypeLocal || t.Spec.RemoteHost == "" || t.Spec.RemotePort == 0 || len(t.Spec.Targets) > 0 {
+		return ""
+	}
+	return net.JoinHostPort(t.Spec.RemoteHost, strconv.Itoa(t.Spec.RemotePort))
+}
+
+// benchTransfer runs command and streams size bytes to its stdin when
+// upload is set, or reads size bytes from its stdout, returning the rate
+func benchTransfer(ctx context.Context, client *ssh.Client, command string, size int64, upload bool) (float64, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open session: %w", err)
+	}
+	defer session.Close()
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return 0, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err := session.Start(command); err != nil {
+		return 0, fmt.Errorf("failed to start %q: %w", command, err)
+	}
+
+	start := time.Now()
+	var n int64
+	if upload {
+		n, err = io.CopyN(stdin, zeroReader{}, size)
+		stdin.Close()
+		if err == nil {
+			err = session.Wait()
+		}
+	} else {
+		stdin.Close()
+		n, err = io.Copy(io.Discard, stdout)
+		if err == nil {
+			err = session.Wait()
+		}
+	}
+	elapsed := time.Since(start)
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return 0, ctxErr
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return 0, fmt.Errorf("%q exited with status %d", command, exitErr.ExitStatus())
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n != size {
+		return 0, fmt.Errorf("transferred %d of %d bytes", n, size)
+	}
+	return float64(n) / elapsed.Seconds(), nil
+}
+
+// zeroReader reads endless zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,124 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// handleBench runs the benchmark's commands, "cat > /dev/null" and
+// "head -c N /dev/zero", and accepts connections to db:5432 only
+func handleBench(_ *ssh.ServerConn, chans <-chan ssh.NewChannel) {
+	for newCh := range chans {
+		if newCh.ChannelType() == "direct-tcpip" {
+			var dest struct {
+				Host string
+				Port uint32
+			}
+			ssh.Unmarshal(newCh.ExtraData(), &dest)
+			if dest.Host != "db" || dest.Port != 5432 {
+				newCh.Reject(ssh.ConnectionFailed, "connection refused")
+				continue
+			}
+			ch, reqs, err := newCh.Accept()
+			if err == nil {
+				go ssh.DiscardRequests(reqs)
+				ch.Close()
+			}
+			continue
+		}
+
+		ch, reqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range reqs {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				ssh.Unmarshal(req.Payload, &payload)
+				req.Reply(true, nil)
+
+				status := uint32(0)
+				switch {
+				case payload.Command == "cat > /dev/null":
+					io.Copy(io.Discard, ch)
+				case strings.HasPrefix(payload.Command, "head -c "):
+					n, _ := strconv.ParseInt(strings.Fields(payload.Command)[2], 10, 64)
+					io.CopyN(ch, zeroReader{}, n)
+				default:
+					status = 127
+				}
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				ch.Close()
+			}
+		}()
+	}
+}
+
+func TestTunnelBench(t *testing.T) {
+	addr := startTestSSHServer(t, nil, handleBench)
+	host, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+
+	// Dialed directly, with tuned socket buffers
+	hop := &types.Hop{Host: host, Port: portNum, User: "deploy", AuthMethod: types.AuthMethodPassword}
+	session, err := NewSession(context.Background(), SessionConfig{Hop: hop, SocketBuffer: 1 << 20})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	t.Cleanup(func() { session.Close() })
+	session.config = testClientConfig(hop.User)
+	if err := session.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	tunnel := &Tunnel{
+		Spec:    &types.TunnelSpec{ID: "bench", Type: types.TunnelTypeLocal, RemoteHost: "db", RemotePort: 5432},
+		session: session,
+	}
+
+	result, err := tunnel.Bench(context.Background(), BenchOptions{Hop: -1, Bytes: 1 << 20, Samples: 3})
+	if err != nil {
+		t.Fatalf("Bench() error = %v", err)
+	}
+	if result.Samples != 3 || result.Bytes != 1<<20 {
+		t.Errorf("Bench() ran %d samples of %d bytes, want 3 of %d", result.Samples, result.Bytes, 1<<20)
+	}
+	if result.LatencyMin <= 0 || result.LatencyMin > result.LatencyAvg || result.LatencyAvg > result.LatencyMax {
+		t.Errorf("Inconsistent latencies: min %v, avg %v, max %v", result.LatencyMin, result.LatencyAvg, result.LatencyMax)
+	}
+	if result.ConnectLatency <= 0 || result.ConnectError != "" {
+		t.Errorf("Expected the destination to be reached, got %v, %q", result.ConnectLatency, result.ConnectError)
+	}
+	if result.Upload <= 0 || result.Download <= 0 {
+		t.Errorf("Expected throughputs, got upload %f, download %f", result.Upload, result.Download)
+	}
+
+	// An unreachable destination is reported without failing the benchmark
+	tunnel.Spec.RemoteHost = "elsewhere"
+	result, err = tunnel.Bench(context.Background(), BenchOptions{Hop: -1, Bytes: 1024, Samples: 1})
+	if err != nil {
+		t.Fatalf("Bench() error = %v", err)
+	}
+	if result.ConnectLatency != 0 || result.ConnectError == "" {
+		t.Errorf("Expected a connect error, got %v, %q", result.ConnectLatency, result.ConnectError)
+	}
+}
+
+func TestTunnelBenchRequiresConnection(t *testing.T) {
+	tunnel := &Tunnel{Spec: &types.TunnelSpec{ID: "bench"}}
+	if _, err := tunnel.Bench(context.Background(), BenchOptions{Hop: -1}); err != ErrNotConnected {
+		t.Errorf("Bench() error = %v, want ErrNotConnected", err)
+	}
+}
@@ -0,0 +1,219 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// bindAddr is one address a forwarder's local socket binds
+type bindAddr struct {
+	network string // tcp or udp, with 4 or 6 appended when the family is set
+	host    string
+}
+
+// bindAddrs returns the addresses spec's local socket binds for protocol.
+// An empty bind address means all interfaces, or loopback only when
+// loopback is set, in the spec's address family. localhost binds both
+// loopback addresses unless the family picks one.
+func bindAddrs(spec *types.TunnelSpec, protocol string, loopback bool) ([]bindAddr, error) {
+	network := protocol
+	switch spec.LocalFamily {
+	case types.AddressFamilyAny:
+	case types.AddressFamilyIPv4:
+		network += "4"
+	case types.AddressFamilyIPv6:
+		network += "6"
+	default:
+		return nil, fmt.Errorf("unknown address family %q", spec.LocalFamily)
+	}
+
+	host := spec.LocalBindAddress
+	if host == "" {
+		switch {
+		case loopback && spec.LocalFamily == types.AddressFamilyIPv6:
+			host = "::1"
+		case loopback:
+			host = "127.0.0.1"
+		case spec.LocalFamily == types.AddressFamilyIPv6:
+			host = "::"
+		default:
+			host = "0.0.0.0"
+		}
+	}
+	host, err := types.ParseBindAddress(host)
+	if err != nil {
+		return nil, err
+	}
+
+	if host == "localhost" {
+		switch spec.LocalFamily {
+		case types.AddressFamilyIPv4:
+			return []bindAddr{{network, "127.0.0.1"}}, nil
+		case types.AddressFamilyIPv6:
+			return []bindAddr{{network, "::1"}}, nil
+		}
+		return []bindAddr{{protocol + "4", "127.0.0.1"}, {protocol + "6", "::1"}}, nil
+	}
+
+	if !spec.LocalFamily.Allows(host) {
+		return nil, fmt.Errorf("bind address %s isn't an %s address", host, spec.LocalFamily)
+	}
+	return []bindAddr{{network, host}}, nil
+}
+
+// listen opens spec's local TCP listener. Bound to localhost, it accepts on
+// both loopback addresses, or on whichever the host has if it lacks one.
+// The address returned is the one bound, or the one that failed.
+func (f socketFactories) listen(ctx context.Context, spec *types.TunnelSpec, loopback bool) (net.Listener, string, error) {
+	addrs, err := bindAddrs(spec, "tcp", loopback)
+	if err != nil {
+		return nil, spec.LocalBindAddress, err
+	}
+
+	// Every address gets the port the first one was given, if ephemeral
+	port := spec.LocalPort
+	var listeners []net.Listener
+	var bound string
+	for _, a := range addrs {
+		addr := net.JoinHostPort(a.host, strconv.Itoa(port))
+		listener, err := f.listener.Listen(ctx, a.network, addr)
+		if err != nil {
+			if len(addrs) > 1 && familyUnavailable(err) {
+				continue
+			}
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, addr, err
+		}
+		if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok && port == 0 {
+			port = tcpAddr.Port
+		}
+		listeners = append(listeners, listener)
+		if bound == "" {
+			bound = addr
+		}
+	}
+
+	switch len(listeners) {
+	case 0:
+		return nil, spec.LocalBindAddress, errors.New("no loopback address is configured")
+	case 1:
+		return listeners[0], bound, nil
+	}
+	return newMultiListener(listeners), net.JoinHostPort("localhost", strconv.Itoa(port)), nil
+}
+
+// listenPacket opens spec's local UDP socket. Datagrams are answered from
+// the socket they arrived on, so localhost binds only the IPv4 loopback
+// address unless the address family is IPv6.
+func (f socketFactories) listenPacket(ctx context.Context, spec *types.TunnelSpec) (net.PacketConn, string, error) {
+	addrs, err := bindAddrs(spec, "udp", false)
+	if err != nil {
+		return nil, spec.LocalBindAddress, err
+	}
+	addr := net.JoinHostPort(addrs[0].host, strconv.Itoa(spec.LocalPort))
+	conn, err := f.listener.ListenPacket(ctx, addrs[0].network, addr)
+	return conn, addr, err
+}
+
+// localDialAddr returns the address remote tunnels deliver connections to:
+// the bind address if set, otherwise the loopback address of the spec's
+// family. localhost tries both.
+func localDialAddr(spec *types.TunnelSpec) string {
+	host, err := types.ParseBindAddress(spec.LocalBindAddress)
+	if err != nil {
+		host = "127.0.0.1"
+		if spec.LocalFamily == types.AddressFamilyIPv6 {
+			host = "::1"
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(spec.LocalPort))
+}
+
+// familyUnavailable reports whether a listen failed because the host has no
+// address of that IP version, e.g. IPv6 disabled or no ::1 configured
+func familyUnavailable(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EAFNOSUPPORT)
+}
+
+// acceptResult is a connection, or error, one of a multiListener's
+// listeners accepted
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// multiListener accepts connections from several listeners, such as one per
+// loopback address for localhost, as one listener
+type multiListener struct {
+	listeners []net.Listener
+	accepted  chan acceptResult
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	ml := &multiListener{
+		listeners: listeners,
+		accepted:  make(chan acceptResult),
+		closed:    make(chan struct{}),
+	}
+	for _, l := range listeners {
+		go ml.acceptFrom(l)
+	}
+	return ml
+}
+
+// acceptFrom passes on what l accepts until l or ml is closed. The handoff
+// is unbuffered, so an accept loop backing off also paces l.
+func (ml *multiListener) acceptFrom(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		select {
+		case ml.accepted <- acceptResult{conn, err}:
+		case <-ml.closed:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// Accept returns the next connection any of the listeners accepts
+func (ml *multiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-ml.accepted:
+		return r.conn, r.err
+	case <-ml.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes every listener
+func (ml *multiListener) Close() error {
+	var errs []error
+	ml.closeOnce.Do(func() {
+		close(ml.closed)
+		for _, l := range ml.listeners {
+			errs = append(errs, l.Close())
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Addr returns the first listener's address
+func (ml *multiListener) Addr() net.Addr {
+	return ml.listeners[0].Addr()
+}
@@ -0,0 +1,150 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestBindAddrs(t *testing.T) {
+	tests := []struct {
+		bind     string
+		family   types.AddressFamily
+		loopback bool
+		want     []bindAddr
+	}{
+		{"", "", false, []bindAddr{{"tcp", "0.0.0.0"}}},
+		{"", "", true, []bindAddr{{"tcp", "127.0.0.1"}}},
+		{"", types.AddressFamilyIPv6, false, []bindAddr{{"tcp6", "::"}}},
+		{"", types.AddressFamilyIPv6, true, []bindAddr{{"tcp6", "::1"}}},
+		{"::1", "", false, []bindAddr{{"tcp", "::1"}}},
+		{"[::1]", "", false, []bindAddr{{"tcp", "::1"}}},
+		{"fe80::1%eth0", types.AddressFamilyIPv6, false, []bindAddr{{"tcp6", "fe80::1%eth0"}}},
+		{"10.0.0.1", types.AddressFamilyIPv4, false, []bindAddr{{"tcp4", "10.0.0.1"}}},
+		{"localhost", "", false, []bindAddr{{"tcp4", "127.0.0.1"}, {"tcp6", "::1"}}},
+		{"localhost", types.AddressFamilyIPv6, false, []bindAddr{{"tcp6", "::1"}}},
+	}
+	for _, tt := range tests {
+		spec := &types.TunnelSpec{LocalBindAddress: tt.bind, LocalFamily: tt.family}
+		got, err := bindAddrs(spec, "tcp", tt.loopback)
+		if err != nil {
+			t.Errorf("bindAddrs(%q, %q) error = %v", tt.bind, tt.family, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("bindAddrs(%q, %q) = %v, want %v", tt.bind, tt.family, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("bindAddrs(%q, %q) = %v, want %v", tt.bind, tt.family, got, tt.want)
+			}
+		}
+	}
+
+	for _, spec := range []*types.TunnelSpec{
+		{LocalBindAddress: "127.0.0.1", LocalFamily: types.AddressFamilyIPv6},
+		{LocalBindAddress: "::", LocalFamily: types.AddressFamilyIPv4},
+		{LocalBindAddress: "db.internal"},
+		{LocalBindAddress: "[10.0.0.1]"},
+		{LocalFamily: "ipx"},
+	} {
+		if got, err := bindAddrs(spec, "tcp", false); err == nil {
+			t.Errorf("bindAddrs(%q, %q) = %v, want an error", spec.LocalBindAddress, spec.LocalFamily, got)
+		}
+	}
+}
+
+func TestLocalDialAddr(t *testing.T) {
+	tests := []struct {
+		spec types.TunnelSpec
+		want string
+	}{
+		{types.TunnelSpec{LocalPort: 3000}, "127.0.0.1:3000"},
+		{types.TunnelSpec{LocalPort: 3000, LocalFamily: types.AddressFamilyIPv6}, "[::1]:3000"},
+		{types.TunnelSpec{LocalPort: 3000, LocalBindAddress: "[::1]"}, "[::1]:3000"},
+		{types.TunnelSpec{LocalPort: 3000, LocalBindAddress: "localhost"}, "localhost:3000"},
+	}
+	for _, tt := range tests {
+		if got := localDialAddr(&tt.spec); got != tt.want {
+			t.Errorf("localDialAddr(%q, %q) = %s, want %s", tt.spec.LocalBindAddress, tt.spec.LocalFamily, got, tt.want)
+		}
+	}
+}
+
+// noIPv6 listens like the net package, except on IPv6 addresses
+type noIPv6 struct {
+	net.ListenConfig
+}
+
+func (n *noIPv6) Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	if network == "tcp6" {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: os.NewSyscallError("bind", syscall.EADDRNOTAVAIL)}
+	}
+	return n.ListenConfig.Listen(ctx, network, address)
+}
+
+func TestListenLocalhost(t *testing.T) {
+	spec := &types.TunnelSpec{LocalBindAddress: "localhost"}
+	listener, addr, err := newSocketFactories(nil).listen(context.Background(), spec, false)
+	if errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EAFNOSUPPORT) {
+		t.Skip("No loopback address to listen on")
+	}
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(addr)
+
+	// Both loopback addresses reach the listener, on the same port
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		if _, ok := listener.(*multiListener); !ok && host == "::1" {
+			t.Log("No IPv6 loopback address; listening on IPv4 only")
+			break
+		}
+		client, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), time.Second)
+		if err != nil {
+			t.Errorf("Dial %s error = %v", host, err)
+			continue
+		}
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("Accept() error = %v", err)
+		}
+		if got := conn.RemoteAddr().(*net.TCPAddr).IP; got.String() != host {
+			t.Errorf("Accepted a connection from %s, want %s", got, host)
+		}
+		conn.Close()
+		client.Close()
+	}
+
+	listener.Close()
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept() after Close = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestListenLocalhostWithoutIPv6(t *testing.T) {
+	sockets := newSocketFactories([]ForwarderOption{WithListenerFactory(&noIPv6{})})
+
+	listener, addr, err := sockets.listen(context.Background(), &types.TunnelSpec{LocalBindAddress: "localhost"}, false)
+	if err != nil {
+		t.Fatalf("listen() error = %v", err)
+	}
+	defer listener.Close()
+	if host, _, _ := net.SplitHostPort(addr); host != "127.0.0.1" {
+		t.Errorf("Bound %s, want the IPv4 loopback address alone", addr)
+	}
+
+	// Asking for IPv6 explicitly doesn't fall back
+	spec := &types.TunnelSpec{LocalBindAddress: "localhost", LocalFamily: types.AddressFamilyIPv6}
+	if _, _, err := sockets.listen(context.Background(), spec, false); !errors.Is(err, syscall.EADDRNOTAVAIL) {
+		t.Errorf("listen() error = %v, want EADDRNOTAVAIL", err)
+	}
+}
@@ -151,6 +151,14 @@ func (cb *CircuitBreaker) RecordFailure() {
 	}
 }
 
+// Reset closes the circuit and forgets past failures, e.g. once an operator
+// has fixed their cause
+func (cb *CircuitBreaker) Reset() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.transitionTo(StateClosed)
+}
+
 // transitionTo changes the circuit breaker state
 func (cb *CircuitBreaker) transitionTo(newState CircuitBreakerState) {
 	cb.state = newState
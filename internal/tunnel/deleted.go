@@ -0,0 +1,146 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// GetDeleted retrieves a soft-deleted tunnel by ID
+func (m *Manager) GetDeleted(tunnelID string) (*Tunnel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tunnel, exists := m.deleted[tunnelID]
+	if !exists {
+		return nil, fmt.Errorf("deleted tunnel %s not found", tunnelID)
+	}
+
+	return tunnel, nil
+}
+
+// ListDeleted returns the soft-deleted tunnels
+func (m *Manager) ListDeleted() []*Tunnel {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tunnels := make([]*Tunnel, 0, len(m.deleted))
+	for _, tunnel := range m.deleted {
+		tunnels = append(tunnels, tunnel)
+	}
+
+	return tunnels
+}
+
+// Restore brings a soft-deleted tunnel back, stopped
+func (m *Manager) Restore(ctx context.Context, tunnelID string) (*Tunnel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tunnel, exists := m.deleted[tunnelID]
+	if !exists {
+		if _, live := m.tunnels[tunnelID]; live {
+			return nil, fmt.Errorf("%w: %s", ErrNotDeleted, tunnelID)
+		}
+		return nil, fmt.Errorf("tunnel %s not found", tunnelID)
+	}
+
+	// Restored tunnels are stopped, so only the number of tunnels counts
+	if err := m.checkQuotaLocked(tunnel.Spec, true, false); err != nil {
+		return nil, err
+	}
+
+	spec := *tunnel.Spec
+	spec.DeletedAt = nil
+	spec.UpdatedAt = time.Now()
+	if m.storage != nil {
+		if err := m.storage.Save(ctx, &spec); err != nil {
+			return nil, fmt.Errorf("failed to restore tunnel in storage: %w", err)
+		}
+	}
+
+	restored := &Tunnel{
+		Spec:           &spec,
+		CreatedAt:      tunnel.CreatedAt,
+		ctx:            m.ctx,
+		statusCallback: m.handleStatusChange,
+		transitionHook: m.runHooks,
+		Status: &types.TunnelStatus{
+			TunnelID: spec.ID,
+			State:    types.TunnelStateStopped,
+		},
+	}
+	delete(m.deleted, tunnelID)
+	m.tunnels[tunnelID] = restored
+	m.version.Add(1)
+
+	return restored, nil
+}
+
+// Purge permanently removes a tunnel, whether deleted or not
+func (m *Manager) Purge(ctx context.Context, tunnelID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.purgeLocked(ctx, tunnelID)
+}
+
+// PurgeDeleted permanently removes tunnels deleted before cutoff and
+// returns how many were removed
+func (m *Manager) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for id, tunnel := range m.deleted {
+		if !tunnel.Spec.DeletedAt.Before(cutoff) {
+			continue
+		}
+		if err := m.purgeLocked(ctx, id); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// purgeLocked removes a tunnel from storage and memory; m.mu must be held
+func (m *Manager) purgeLocked(ctx context.Context, tunnelID string) error {
+	tunnel, live := m.tunnels[tunnelID]
+	if !live {
+		if _, deleted := m.deleted[tunnelID]; !deleted {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+	}
+
+	// Try to stop the tunnel (may fail if already failed/stopped)
+	var stopErr error
+	if live {
+		stopErr = tunnel.Stop()
+	}
+
+	if m.storage != nil {
+		if err := m.storage.Delete(ctx, tunnelID); err != nil {
+			return fmt.Errorf("failed to delete tunnel from storage: %w", err)
+		}
+	}
+
+	delete(m.deleted, tunnelID)
+	version := m.version.Add(1)
+	if live {
+		delete(m.tunnels, tunnelID)
+		m.notifyStatusWatch(tunnelID, version, true)
+		if m.circuitBreaker != nil {
+			m.circuitBreaker.RemoveBreaker(tunnelID)
+		}
+	}
+
+	if stopErr != nil {
+		return fmt.Errorf("tunnel removed, but stop had errors: %w", stopErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestManagerSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStorage()
+	manager := NewManager(ctx)
+	manager.SetStorage(store)
+
+	spec := &types.TunnelSpec{ID: "soft-1", Name: "db", Type: types.TunnelTypeLocal, DesiredStatus: types.DesiredStatusActive}
+	createTunnels(t, manager, spec)
+
+	if err := manager.Delete(ctx, spec.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := manager.Get(spec.ID); err == nil {
+		t.Error("Expected a deleted tunnel to leave the active tunnels")
+	}
+	stored, err := store.Get(ctx, spec.ID)
+	if err != nil || stored.DeletedAt == nil {
+		t.Fatalf("Expected the spec to be kept in storage, marked deleted: %+v %v", stored, err)
+	}
+	if deleted := manager.ListDeleted(); len(deleted) != 1 || deleted[0].Spec.ID != spec.ID {
+		t.Fatalf("Expected the tunnel among the deleted ones, got %d", len(deleted))
+	}
+
+	// The name stays taken until the tunnel is purged
+	clash := &types.TunnelSpec{ID: "soft-2", Name: "db", Type: types.TunnelTypeLocal, AgentID: testAgentID}
+	if err := manager.Create(ctx, clash); !errors.Is(err, ErrNameExists) {
+		t.Errorf("Expected ErrNameExists for a deleted tunnel's name, got: %v", err)
+	}
+
+	// A restart keeps it deleted
+	reloaded := NewManager(ctx)
+	reloaded.SetStorage(store)
+	if err := reloaded.LoadFromStorage(ctx); err != nil {
+		t.Fatalf("LoadFromStorage failed: %v", err)
+	}
+	if len(reloaded.List()) != 0 || len(reloaded.ListDeleted()) != 1 {
+		t.Errorf("Expected the reloaded tunnel to stay deleted")
+	}
+
+	restored, err := manager.Restore(ctx, spec.ID)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if restored.Spec.DeletedAt != nil || restored.Spec.DesiredStatus != types.DesiredStatusStopped {
+		t.Errorf("Expected an undeleted, stopped spec, got %+v", restored.Spec)
+	}
+	if status := restored.GetStatus(); status.State != types.TunnelStateStopped {
+		t.Errorf("Expected the restored tunnel to be stopped, got %s", status.State)
+	}
+	if _, err := manager.Get(spec.ID); err != nil {
+		t.Errorf("Expected the restored tunnel to be active again: %v", err)
+	}
+	if stored, _ := store.Get(ctx, spec.ID); stored.DeletedAt != nil {
+		t.Error("Expected the restore to be persisted")
+	}
+
+	if _, err := manager.Restore(ctx, spec.ID); !errors.Is(err, ErrNotDeleted) {
+		t.Errorf("Expected ErrNotDeleted restoring a live tunnel, got: %v", err)
+	}
+}
+
+func TestManagerPurge(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStorage()
+	manager := NewManager(ctx)
+	manager.SetStorage(store)
+
+	for _, id := range []string{"purge-old", "purge-new", "purge-live"} {
+		createTunnels(t, manager, &types.TunnelSpec{ID: id, Name: id, Type: types.TunnelTypeLocal})
+	}
+	for _, id := range []string{"purge-old", "purge-new"} {
+		if err := manager.Delete(ctx, id); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+	old, _ := manager.GetDeleted("purge-old")
+	deletedAt := time.Now().Add(-48 * time.Hour)
+	old.Spec.DeletedAt = &deletedAt
+
+	purged, err := manager.PurgeDeleted(ctx, time.Now().Add(-24*time.Hour))
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeleted = %d, %v; want 1 purged", purged, err)
+	}
+	if _, err := store.Get(ctx, "purge-old"); err == nil {
+		t.Error("Expected the old tunnel to be removed from storage")
+	}
+	if _, err := manager.GetDeleted("purge-new"); err != nil {
+		t.Error("Expected the recently deleted tunnel to be kept")
+	}
+
+	// Purging works on live tunnels too
+	if err := manager.Purge(ctx, "purge-live"); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if _, err := manager.Get("purge-live"); err == nil {
+		t.Error("Expected the purged tunnel to be gone")
+	}
+	if _, err := manager.Restore(ctx, "purge-live"); err == nil || errors.Is(err, ErrNotDeleted) {
+		t.Errorf("Expected a purged tunnel not to be found, got: %v", err)
+	}
+}
@@ -0,0 +1,322 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// dependencyRecheck bounds how long a tunnel waiting for its dependencies
+// goes without looking at them again, e.g. to notice it was stopped
+const dependencyRecheck = time.Second
+
+// waitingForDependencies prefixes the status message of a tunnel waiting
+// for its dependencies
+const waitingForDependencies = "waiting for dependencies: "
+
+// ErrInvalidDependency is returned for dependencies that don't exist, run on
+// another node, or would make tunnels wait for each other
+var ErrInvalidDependency = errors.New("invalid dependency")
+
+// DependencyStatus is the state of one of a tunnel's dependencies
+type DependencyStatus struct {
+	Name     string
+	TunnelID string            // empty if no tunnel has the name
+	State    types.TunnelState // empty if no tunnel has the name
+}
+
+// CheckDependencies reports whether a tunnel's dependencies are usable: each
+// must be another existing tunnel of its project, run on the same node, and
+// not depend on the tunnel, directly or not
+func (m *Manager) CheckDependencies(spec *types.TunnelSpec) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	byName := m.tunnelsByNameLocked(spec.ProjectName())
+	for _, name := range spec.DependsOn {
+		if name == spec.Name {
+			return fmt.Errorf("%w: a tunnel can't depend on itself", ErrInvalidDependency)
+		}
+		dependency, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("%w: no tunnel of project %q is named %q", ErrInvalidDependency, spec.ProjectName(), name)
+		}
+		if !sameNode(dependency.Spec.AgentID, spec.AgentID) {
+			return fmt.Errorf("%w: %q runs on another agent", ErrInvalidDependency, name)
+		}
+	}
+
+	// Walk the dependencies for one that leads back to the tunnel
+	visited := make(map[string]bool)
+	var reaches func(names []string) bool
+	reaches = func(names []string) bool {
+		for _, name := range names {
+			if name == spec.Name {
+				return true
+			}
+			if visited[name] {
+				continue
+			}
+			visited[name] = true
+			if dependency, ok := byName[name]; ok && reaches(dependency.Spec.DependsOn) {
+				return true
+			}
+		}
+		return false
+	}
+	if reaches(spec.DependsOn) {
+		return fmt.Errorf("%w: the dependencies of %q depend on it", ErrInvalidDependency, spec.Name)
+	}
+	return nil
+}
+
+// Dependencies returns the state of each of a tunnel's dependencies, in the
+// order they are listed
+func (m *Manager) Dependencies(spec *types.TunnelSpec) []DependencyStatus {
+	if len(spec.DependsOn) == 0 {
+		return nil
+	}
+
+	m.mu.RLock()
+	byName := m.tunnelsByNameLocked(spec.ProjectName())
+	m.mu.RUnlock()
+
+	statuses := make([]DependencyStatus, len(spec.DependsOn))
+	for i, name := range spec.DependsOn {
+		statuses[i].Name = name
+		if dependency, ok := byName[name]; ok {
+			statuses[i].TunnelID = dependency.Spec.ID
+			statuses[i].State = dependency.state()
+		}
+	}
+	return statuses
+}
+
+// Dependents returns the names of the tunnels that depend on the given one
+func (m *Manager) Dependents(spec *types.TunnelSpec) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var names []string
+	for _, t := range m.tunnels {
+		if t.Spec.ProjectName() != spec.ProjectName() {
+			continue
+		}
+		for _, dependency := range t.Spec.DependsOn {
+			if dependency == spec.Name {
+				names = append(names, t.Spec.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dependenciesLocked returns the dependencies a tunnel needs started, in the
+// order to start them: dependencies of dependencies first. Active and
+// connecting tunnels are left alone. Must be called with m.mu held.
+func (m *Manager) dependenciesLocked(spec *types.TunnelSpec) []*Tunnel {
+	byName := m.tunnelsByNameLocked(spec.ProjectName())
+	visited := map[string]bool{spec.Name: true}
+
+	var ordered []*Tunnel
+	var visit func(names []string)
+	visit = func(names []string) {
+		for _, name := range names {
+			dependency, ok := byName[name]
+			if !ok || visited[name] {
+				continue
+			}
+			visited[name] = true
+			visit(dependency.Spec.DependsOn)
+			if state := dependency.state(); state != types.TunnelStateActive && state != types.TunnelStatePending {
+				ordered = append(ordered, dependency)
+			}
+		}
+	}
+	visit(spec.DependsOn)
+	return ordered
+}
+
+// dependentsLocked returns the running tunnels that depend on the given
+// one, directly or not, in the order to stop them: dependents of dependents
+// first. Must be called with m.mu held.
+func (m *Manager) dependentsLocked(spec *types.TunnelSpec) []*Tunnel {
+	dependents := make(map[string][]*Tunnel)
+	for _, t := range m.tunnels {
+		if t.Spec.ProjectName() != spec.ProjectName() {
+			continue
+		}
+		for _, dependency := range t.Spec.DependsOn {
+			dependents[dependency] = append(dependents[dependency], t)
+		}
+	}
+
+	visited := map[string]bool{spec.Name: true}
+	var ordered []*Tunnel
+	var visit func(name string)
+	visit = func(name string) {
+		for _, dependent := range dependents[name] {
+			if visited[dependent.Spec.Name] {
+				continue
+			}
+			visited[dependent.Spec.Name] = true
+			visit(dependent.Spec.Name)
+			if dependent.state() != types.TunnelStateStopped {
+				ordered = append(ordered, dependent)
+			}
+		}
+	}
+	visit(spec.Name)
+	return ordered
+}
+
+// awaitDependencies holds a connecting tunnel until all its dependencies are
+// active, reporting which it waits for. It returns false if the tunnel was
+// stopped or the manager shut down meanwhile.
+func (m *Manager) awaitDependencies(tunnel *Tunnel) bool {
+	for {
+		var waiting []string
+		var changed <-chan struct{}
+		for _, dep := range m.Dependencies(tunnel.Spec) {
+			if dep.State == types.TunnelStateActive {
+				continue
+			}
+			waiting = append(waiting, dep.Name)
+			if changed == nil && dep.TunnelID != "" {
+				_, changed = m.WatchStatus(dep.TunnelID)
+			}
+		}
+
+		if tunnel.state() == types.TunnelStateStopped {
+			return false
+		}
+		if len(waiting) == 0 {
+			return true
+		}
+		if message := waitingForDependencies + strings.Join(waiting, ", "); tunnel.lastError() != message {
+			tunnel.updateStatus(types.TunnelStatePending, message)
+		}
+
+		// Dependencies may change between the check and the watch, and
+		// stopping a tunnel isn't watched, so look again now and then
+		timer := time.NewTimer(dependencyRecheck)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-m.ctx.Done():
+			timer.Stop()
+			return false
+		}
+		timer.Stop()
+	}
+}
+
+// dependencyOrder orders tunnels so each comes after the tunnels it depends
+// on; tunnels in a dependency cycle, which validation prevents, come last
+func dependencyOrder(tunnels map[string]*Tunnel) []*Tunnel {
+	byName := make(map[tunnelKey]*Tunnel, len(tunnels))
+	all := make([]*Tunnel, 0, len(tunnels))
+	for _, t := range tunnels {
+		byName[keyOf(t.Spec)] = t
+		all = append(all, t)
+	}
+	// Deterministic order among independent tunnels
+	sort.Slice(all, func(i, j int) bool { return all[i].Spec.Name < all[j].Spec.Name })
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	marks := make(map[*Tunnel]int, len(all))
+	ordered := make([]*Tunnel, 0, len(all))
+	var cyclic []*Tunnel
+	var visit func(t *Tunnel) bool
+	visit = func(t *Tunnel) bool {
+		switch marks[t] {
+		case done:
+			return true
+		case visiting:
+			return false
+		}
+		marks[t] = visiting
+		for _, name := range t.Spec.DependsOn {
+			if dependency, ok := byName[tunnelKey{t.Spec.ProjectName(), name}]; ok && !visit(dependency) {
+				marks[t] = done
+				cyclic = append(cyclic, t)
+				return false
+			}
+		}
+		marks[t] = done
+		ordered = append(ordered, t)
+		return true
+	}
+	for _, t := range all {
+		visit(t)
+	}
+	return append(ordered, cyclic...)
+}
+
+// tunnelsByNameLocked indexes a project's tunnels by name. Must be called
+// with m.mu held.
+func (m *Manager) tunnelsByNameLocked(project string) map[string]*Tunnel {
+	byName := make(map[string]*Tunnel)
+	for _, t := range m.tunnels {
+		if t.Spec.ProjectName() == project {
+			byName[t.Spec.Name] = t
+		}
+	}
+	return byName
+}
+
+// tunnelKey identifies a tunnel by its project and name, which are unique
+// together
+type tunnelKey struct {
+	project, name string
+}
+
+// keyOf returns a tunnel's key
+func keyOf(spec *types.TunnelSpec) tunnelKey {
+	return tunnelKey{spec.ProjectName(), spec.Name}
+}
+
+// sameName reports whether two tunnels would have the same name in the
+// same project
+func sameName(a, b *types.TunnelSpec) bool {
+	return keyOf(a) == keyOf(b)
+}
+
+// sameNode reports whether tunnels with the given agent IDs run on the same
+// node
+func sameNode(a, b string) bool {
+	if IsLocalAgent(a) {
+		return IsLocalAgent(b)
+	}
+	return a == b
+}
+
+// state returns the tunnel's current state
+func (t *Tunnel) state() types.TunnelState {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.Status == nil {
+		return ""
+	}
+	return t.Status.State
+}
+
+// lastError returns the tunnel's current status message
+func (t *Tunnel) lastError() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.Status == nil {
+		return ""
+	}
+	return t.Status.LastError
+}
@@ -0,0 +1,200 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// addStoredTunnels adds stopped tunnels to the manager without connecting them
+func addStoredTunnels(m *Manager, specs ...*types.TunnelSpec) map[string]*Tunnel {
+	added := make(map[string]*Tunnel)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, spec := range specs {
+		if spec.ID == "" {
+			spec.ID = spec.Name
+		}
+		t := m.storedTunnel(spec)
+		m.tunnels[spec.ID] = t
+		added[spec.Name] = t
+	}
+	return added
+}
+
+func TestCheckDependencies(t *testing.T) {
+	m := NewManager(context.Background())
+	addStoredTunnels(m,
+		&types.TunnelSpec{Name: "socks"},
+		&types.TunnelSpec{Name: "edge-socks", AgentID: testAgentID},
+		&types.TunnelSpec{Name: "other", Project: "payments"},
+		// Depends on a tunnel that doesn't exist yet
+		&types.TunnelSpec{Name: "db", DependsOn: []string{"cache"}},
+	)
+
+	tests := []struct {
+		name    string
+		spec    types.TunnelSpec
+		wantErr bool
+	}{
+		{"no dependencies", types.TunnelSpec{Name: "web"}, false},
+		{"existing", types.TunnelSpec{Name: "web", DependsOn: []string{"socks"}}, false},
+		{"same agent", types.TunnelSpec{Name: "web", AgentID: testAgentID, DependsOn: []string{"edge-socks"}}, false},
+		{"itself", types.TunnelSpec{Name: "web", DependsOn: []string{"web"}}, true},
+		{"missing", types.TunnelSpec{Name: "web", DependsOn: []string{"nope"}}, true},
+		{"other agent", types.TunnelSpec{Name: "web", DependsOn: []string{"edge-socks"}}, true},
+		{"other project", types.TunnelSpec{Name: "web", DependsOn: []string{"other"}}, true},
+		{"cycle", types.TunnelSpec{Name: "cache", DependsOn: []string{"db"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.CheckDependencies(&tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckDependencies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidDependency) {
+				t.Errorf("Expected ErrInvalidDependency, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDependencyOrder(t *testing.T) {
+	m := NewManager(context.Background())
+	addStoredTunnels(m,
+		&types.TunnelSpec{Name: "app", DependsOn: []string{"db", "cache"}},
+		&types.TunnelSpec{Name: "db", DependsOn: []string{"socks"}},
+		&types.TunnelSpec{Name: "cache", DependsOn: []string{"socks"}},
+		&types.TunnelSpec{Name: "socks"},
+		&types.TunnelSpec{Name: "metrics"},
+	)
+
+	var names []string
+	for _, t := range dependencyOrder(m.tunnels) {
+		names = append(names, t.Spec.Name)
+	}
+	want := []string{"socks", "db", "cache", "app", "metrics"}
+	if len(names) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, names)
+		}
+	}
+}
+
+func TestStartAndStopInDependencyOrder(t *testing.T) {
+	m := NewManager(context.Background())
+
+	tunnels := addStoredTunnels(m,
+		&types.TunnelSpec{Name: "socks", AgentID: testAgentID},
+		&types.TunnelSpec{Name: "db", AgentID: testAgentID, DependsOn: []string{"socks"}},
+		&types.TunnelSpec{Name: "app", AgentID: testAgentID, DependsOn: []string{"db"}},
+		&types.TunnelSpec{Name: "unrelated", AgentID: testAgentID},
+	)
+
+	if err := m.Start(context.Background(), "app"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	for _, name := range []string{"socks", "db", "app"} {
+		if state := tunnels[name].state(); state != types.TunnelStatePending {
+			t.Errorf("Expected %s to be starting, got %s", name, state)
+		}
+	}
+	if state := tunnels["unrelated"].state(); state != types.TunnelStateStopped {
+		t.Errorf("Expected the unrelated tunnel to stay stopped, got %s", state)
+	}
+
+	if err := m.Stop(context.Background(), "socks"); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	for _, name := range []string{"socks", "db", "app"} {
+		if state := tunnels[name].state(); state != types.TunnelStateStopped {
+			t.Errorf("Expected %s to be stopped with its dependency, got %s", name, state)
+		}
+	}
+
+	if got := m.Dependents(tunnels["socks"].Spec); len(got) != 1 || got[0] != "db" {
+		t.Errorf("Expected db to depend on socks, got %v", got)
+	}
+}
+
+func TestDependenciesStayInProject(t *testing.T) {
+	m := NewManager(context.Background())
+	addStoredTunnels(m,
+		&types.TunnelSpec{Name: "socks", AgentID: testAgentID},
+		&types.TunnelSpec{ID: "payments-socks", Name: "socks", Project: "payments", AgentID: testAgentID},
+		&types.TunnelSpec{ID: "payments-db", Name: "db", Project: "payments", AgentID: testAgentID, DependsOn: []string{"socks"}},
+	)
+	m.mu.RLock()
+	socks, paymentsSocks := m.tunnels["socks"], m.tunnels["payments-socks"]
+	m.mu.RUnlock()
+
+	// The dependency is the payments tunnel, not its namesake
+	if err := m.Start(context.Background(), "payments-db"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if state := paymentsSocks.state(); state != types.TunnelStatePending {
+		t.Errorf("Expected the payments socks tunnel to be starting, got %s", state)
+	}
+	if state := socks.state(); state != types.TunnelStateStopped {
+		t.Errorf("Expected the default project's socks tunnel to stay stopped, got %s", state)
+	}
+
+	if got := m.Dependents(socks.Spec); len(got) != 0 {
+		t.Errorf("Expected nothing to depend on the default project's socks tunnel, got %v", got)
+	}
+	if got := m.Dependents(paymentsSocks.Spec); len(got) != 1 || got[0] != "db" {
+		t.Errorf("Expected db to depend on the payments socks tunnel, got %v", got)
+	}
+}
+
+func TestAwaitDependencies(t *testing.T) {
+	m := NewManager(context.Background())
+	tunnels := addStoredTunnels(m,
+		&types.TunnelSpec{Name: "socks"},
+		&types.TunnelSpec{Name: "db", DependsOn: []string{"socks"}},
+	)
+	socks, db := tunnels["socks"], tunnels["db"]
+	db.updateStatus(types.TunnelStatePending, "")
+
+	ready := make(chan bool, 1)
+	go func() { ready <- m.awaitDependencies(db) }()
+
+	waitForStatus(t, db, "the dependency wait", func(s *types.TunnelStatus) bool {
+		return s.State == types.TunnelStatePending && s.LastError == waitingForDependencies+"socks"
+	})
+	if deps := m.Dependencies(db.Spec); len(deps) != 1 || deps[0].State != types.TunnelStateStopped {
+		t.Errorf("Expected the stopped dependency to be reported, got %+v", deps)
+	}
+
+	socks.updateStatus(types.TunnelStateActive, "")
+	select {
+	case ok := <-ready:
+		if !ok {
+			t.Error("Expected the tunnel to connect once its dependency is active")
+		}
+	case <-time.After(time.Second / 2):
+		t.Fatal("Timed out waiting for the dependency to be noticed")
+	}
+
+	// Stopped while waiting
+	socks.updateStatus(types.TunnelStateFailed, "connection lost")
+	go func() { ready <- m.awaitDependencies(db) }()
+	waitForStatus(t, db, "the dependency wait", func(s *types.TunnelStatus) bool {
+		return s.LastError == waitingForDependencies+"socks"
+	})
+	_ = db.Stop()
+	select {
+	case ok := <-ready:
+		if ok {
+			t.Error("Expected a stopped tunnel to stop waiting")
+		}
+	case <-time.After(3 * dependencyRecheck):
+		t.Fatal("Timed out waiting for the stopped tunnel to stop waiting")
+	}
+}
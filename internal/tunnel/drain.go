@@ -0,0 +1,221 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// DefaultDrainTimeout is how long stopping a forwarder waits for its active
+// connections to finish, unless the tunnel sets its own
+const DefaultDrainTimeout = 10 * time.Second
+
+// StopOptions control how a tunnel stops
+type StopOptions struct {
+	// Force closes active connections at once instead of waiting for them
+	// to finish
+	Force bool
+}
+
+// ForceStopper is a forwarder that can close its active connections rather
+// than wait for them
+type ForceStopper interface {
+	// ForceStop stops the forwarder, closing its active connections at once,
+	// and returns how many it closed
+	ForceStop() (int, error)
+}
+
+// drainTimeout returns how long stopping the tunnel waits for its active
+// connections
+func drainTimeout(spec *types.TunnelSpec) time.Duration {
+	if spec.DrainTimeout > 0 {
+		return spec.DrainTimeout
+	}
+	return DefaultDrainTimeout
+}
+
+// stopForwarder stops a forwarder, closing its connections at once when
+// forced and the forwarder supports it. It returns how many connections
+// were closed.
+func stopForwarder(forwarder Forwarder, force bool) (int, error) {
+	if stopper, ok := forwarder.(ForceStopper); ok && force {
+		return stopper.ForceStop()
+	}
+	return 0, forwarder.Stop()
+}
+
+// connTracker tracks a forwarder's active connections, so stopping can wait
+// for them to finish or close them
+type connTracker struct {
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+}
+
+// ConnectionInfo describes one active forwarded connection. Bytes count
+// like the forwarder's: sent across the tunnel, received back from it.
+type ConnectionInfo struct {
+	ID            uint64
+	Source        string // the client's address
+	Target        string // where it is forwarded to; empty until connected
+	StartedAt     time.Time
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// ConnectionLister is a forwarder that can list its active connections
+type ConnectionLister interface {
+	Connections() []ConnectionInfo
+}
+
+// connIDs numbers connections across all forwarders, so an ID identifies a
+// connection from one listing to the next
+var connIDs atomic.Uint64
+
+// trackedConn is one forwarded connection and the sockets it holds open
+type trackedConn struct {
+	mu      sync.Mutex
+	sockets []io.Closer
+	closed  bool
+	target  string
+
+	id       uint64
+	source   string
+	started  time.Time
+	sent     int64
+	received int64
+}
+
+// add tracks a new connection holding socket. It must be called before the
+// connection's goroutine starts, and matched by done when it finishes.
+func (ct *connTracker) add(socket io.Closer) *trackedConn {
+	tc := &trackedConn{sockets: []io.Closer{socket}, id: connIDs.Add(1), started: time.Now()}
+	if conn, ok := socket.(net.Conn); ok && conn.RemoteAddr() != nil {
+		tc.source = conn.RemoteAddr().String()
+	}
+	ct.wg.Add(1)
+	ct.mu.Lock()
+	if ct.conns == nil {
+		ct.conns = make(map[*trackedConn]struct{})
+	}
+	ct.conns[tc] = struct{}{}
+	ct.mu.Unlock()
+	return tc
+}
+
+// done stops tracking a finished connection
+func (ct *connTracker) done(tc *trackedConn) {
+	ct.mu.Lock()
+	delete(ct.conns, tc)
+	ct.mu.Unlock()
+	ct.wg.Done()
+}
+
+// hold adds a socket the connection opened. A connection already closed
+// closes the socket at once.
+func (tc *trackedConn) hold(socket io.Closer) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.closed {
+		socket.Close()
+		return
+	}
+	tc.sockets = append(tc.sockets, socket)
+}
+
+// setTarget records where the connection is forwarded to
+func (tc *trackedConn) setTarget(target string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.target = target
+}
+
+// info describes the connection
+func (tc *trackedConn) info() ConnectionInfo {
+	tc.mu.Lock()
+	target := tc.target
+	tc.mu.Unlock()
+	return ConnectionInfo{
+		ID:            tc.id,
+		Source:        tc.source,
+		Target:        target,
+		StartedAt:     tc.started,
+		BytesSent:     atomic.LoadInt64(&tc.sent),
+		BytesReceived: atomic.LoadInt64(&tc.received),
+	}
+}
+
+// connections lists the active connections, oldest first
+func (ct *connTracker) connections() []ConnectionInfo {
+	ct.mu.Lock()
+	conns := make([]ConnectionInfo, 0, len(ct.conns))
+	for tc := range ct.conns {
+		conns = append(conns, tc.info())
+	}
+	ct.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	return conns
+}
+
+// close closes every socket of the connection
+func (tc *trackedConn) close() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.closed = true
+	for _, socket := range tc.sockets {
+		socket.Close()
+	}
+}
+
+// closeAll closes every active connection and returns how many there were
+func (ct *connTracker) closeAll() int {
+	ct.mu.Lock()
+	conns := make([]*trackedConn, 0, len(ct.conns))
+	for tc := range ct.conns {
+		conns = append(conns, tc)
+	}
+	ct.mu.Unlock()
+
+	for _, tc := range conns {
+		tc.close()
+	}
+	return len(conns)
+}
+
+// drain waits up to timeout for the active connections to finish. Forced,
+// it closes them first and returns how many it closed.
+func (ct *connTracker) drain(timeout time.Duration, force bool) (int, error) {
+	var closed int
+	if force {
+		closed = ct.closeAll()
+	}
+	if !waitTimeout(&ct.wg, timeout) {
+		return closed, fmt.Errorf("timeout waiting for connections to close after %s", timeout)
+	}
+	return closed, nil
+}
+
+// waitTimeout waits up to timeout for wg, reporting whether it finished
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
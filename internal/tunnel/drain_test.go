@@ -0,0 +1,157 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// startHeldForwarder starts a local forwarder whose connections reach a far
+// end that never answers, and opens one connection through it. It returns
+// the forwarder, the client side and the far side of that connection.
+func startHeldForwarder(t *testing.T, drain time.Duration) (*LocalForwarder, net.Conn, net.Conn) {
+	t.Helper()
+
+	farEnds := make(chan net.Conn, 1)
+	session := &MockSessionDialer{
+		connected: true,
+		dialFunc: func(network, address string) (net.Conn, error) {
+			near, far := net.Pipe()
+			farEnds <- far
+			return near, nil
+		},
+	}
+	spec := &types.TunnelSpec{
+		Type:         types.TunnelTypeLocal,
+		RemoteHost:   "db.internal",
+		RemotePort:   5432,
+		DrainTimeout: drain,
+	}
+	forwarder, err := NewLocalForwarder(context.Background(), spec, session)
+	if err != nil {
+		t.Fatalf("NewLocalForwarder() error = %v", err)
+	}
+	if err := forwarder.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { forwarder.ForceStop() })
+
+	client, err := net.Dial("tcp", forwarder.LocalAddr())
+	if err != nil {
+		t.Fatalf("Failed to connect to the forwarder: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	select {
+	case far := <-farEnds:
+		t.Cleanup(func() { far.Close() })
+		return forwarder, client, far
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the forwarder to dial")
+	}
+	return nil, nil, nil
+}
+
+func TestForwarderStopWaitsForDrainTimeout(t *testing.T) {
+	forwarder, _, _ := startHeldForwarder(t, 100*time.Millisecond)
+
+	started := time.Now()
+	err := forwarder.Stop()
+	if err == nil || !strings.Contains(err.Error(), "timeout waiting for connections") {
+		t.Fatalf("Expected Stop() to time out draining, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected Stop() to wait the 100ms drain timeout, waited %s", elapsed)
+	}
+}
+
+func TestForwarderForceStopClosesConnections(t *testing.T) {
+	forwarder, client, far := startHeldForwarder(t, time.Hour)
+
+	closed, err := forwarder.ForceStop()
+	if err != nil {
+		t.Fatalf("ForceStop() error = %v", err)
+	}
+	if closed != 1 {
+		t.Errorf("ForceStop() closed %d connections, want 1", closed)
+	}
+
+	// Both sides of the connection are cut
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the client to see the connection closed, got %v", err)
+	}
+	far.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := far.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the far end to see the connection closed, got %v", err)
+	}
+	if stats := forwarder.Stats(); stats.ActiveConns != 0 {
+		t.Errorf("Expected no active connections after ForceStop, got %d", stats.ActiveConns)
+	}
+}
+
+func TestManagerForceStopReportsClosedConnections(t *testing.T) {
+	forwarder, _, _ := startHeldForwarder(t, time.Hour)
+
+	m := NewManager(context.Background())
+	tun := addStoredTunnels(m, &types.TunnelSpec{ID: "dump-1", Name: "dump", Type: types.TunnelTypeLocal})["dump"]
+	tun.mu.Lock()
+	tun.Status.State = types.TunnelStateActive
+	tun.forwarder = forwarder
+	tun.mu.Unlock()
+
+	closed, err := m.StopWith(context.Background(), "dump-1", StopOptions{Force: true})
+	if err != nil {
+		t.Fatalf("StopWith() error = %v", err)
+	}
+	if closed != 1 {
+		t.Errorf("StopWith() closed %d connections, want 1", closed)
+	}
+	if status := tun.GetStatus(); status.State != types.TunnelStateStopped || status.ForceClosed != 1 {
+		t.Errorf("Expected a stopped tunnel reporting 1 force-closed connection, got %s with %d", status.State, status.ForceClosed)
+	}
+}
+
+func TestForwarderListsConnections(t *testing.T) {
+	forwarder, client, far := startHeldForwarder(t, time.Second)
+
+	client.Write([]byte("hello"))
+	io.ReadFull(far, make([]byte, 5))
+	far.Write([]byte("hi"))
+	io.ReadFull(client, make([]byte, 2))
+
+	var conns []ConnectionInfo
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		conns = forwarder.Connections()
+		if len(conns) == 1 && conns[0].BytesSent == 5 && conns[0].BytesReceived == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(conns) != 1 {
+		t.Fatalf("Connections() = %+v, want one connection", conns)
+	}
+	conn := conns[0]
+	if conn.Source != client.LocalAddr().String() || conn.Target != "db.internal:5432" {
+		t.Errorf("Connection from %s to %s, want from %s to db.internal:5432", conn.Source, conn.Target, client.LocalAddr())
+	}
+	if conn.BytesSent != 5 || conn.BytesReceived != 2 || conn.StartedAt.IsZero() || conn.ID == 0 {
+		t.Errorf("Unexpected connection %+v", conn)
+	}
+
+	client.Close()
+	far.Close()
+	deadline = time.Now().Add(2 * time.Second)
+	for len(forwarder.Connections()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if conns := forwarder.Connections(); len(conns) != 0 {
+		t.Errorf("Expected a closed connection to be dropped, got %+v", conns)
+	}
+}
@@ -0,0 +1,214 @@
+package tunnel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// StatusCallback is called when tunnel status changes
+type StatusCallback func(tunnelID string, status *types.TunnelStatus)
+
+// StatusEvent is a tunnel status change delivered to subscribers
+type StatusEvent struct {
+	TunnelID string
+	Status   types.TunnelStatus
+}
+
+const (
+	// DefaultSubscriberBuffer is how many events a subscriber can fall behind
+	// before further events are dropped for it
+	DefaultSubscriberBuffer = 64
+
+	// statusPersistTimeout bounds how long a status transition waits on storage
+	statusPersistTimeout = 5 * time.Second
+)
+
+// Subscription is a registered status change consumer. Each subscription
+// receives events in order on its own goroutine, so a slow consumer never
+// blocks tunnels or other subscribers; events it can't keep up with are
+// dropped and counted instead.
+type Subscription struct {
+	id      uint64
+	manager *Manager
+	events  chan StatusEvent
+	done    chan struct{}
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// Unsubscribe stops delivery and waits for the callback to return from any
+// in-flight event. Calling it more than once is safe, but not from the
+// subscription's own callback.
+func (s *Subscription) Unsubscribe() {
+	s.stop()
+	<-s.done
+}
+
+// stop removes the subscription without waiting for its goroutine
+func (s *Subscription) stop() {
+	s.once.Do(func() {
+		s.manager.hooksMu.Lock()
+		delete(s.manager.subscribers, s.id)
+		close(s.events)
+		s.manager.hooksMu.Unlock()
+	})
+}
+
+// Dropped returns how many events were discarded because the subscriber
+// was too slow
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Subscribe registers fn to receive every tunnel status change. A buffer of
+// zero or less uses DefaultSubscriberBuffer.
+func (m *Manager) Subscribe(fn StatusCallback, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultSubscriberBuffer
+	}
+
+	m.hooksMu.Lock()
+	defer m.hooksMu.Unlock()
+
+	if m.subscribers == nil {
+		m.subscribers = make(map[uint64]*Subscription)
+	}
+	m.nextSubID++
+
+	sub := &Subscription{
+		id:      m.nextSubID,
+		manager: m,
+		events:  make(chan StatusEvent, buffer),
+		done:    make(chan struct{}),
+	}
+	m.subscribers[sub.id] = sub
+
+	go func() {
+		defer close(sub.done)
+		for event := range sub.events {
+			fn(event.TunnelID, &event.Status)
+		}
+	}()
+
+	return sub
+}
+
+// SetStatusCallback sets a callback function that is invoked when tunnel status changes,
+// replacing any callback set previously. Prefer Subscribe for additional consumers.
+func (m *Manager) SetStatusCallback(cb StatusCallback) {
+	m.hooksMu.Lock()
+	previous := m.callbackSub
+	m.callbackSub = nil
+	m.hooksMu.Unlock()
+
+	if previous != nil {
+		previous.Unsubscribe()
+	}
+	if cb == nil {
+		return
+	}
+
+	sub := m.Subscribe(cb, 0)
+
+	m.hooksMu.Lock()
+	m.callbackSub = sub
+	m.hooksMu.Unlock()
+}
+
+// handleStatusChange persists a tunnel's state transition and fans it out to
+// subscribers. Every tunnel owned by the manager reports through here.
+func (m *Manager) handleStatusChange(tunnelID string, status *types.TunnelStatus) {
+	version := m.version.Add(1)
+
+	m.hooksMu.RLock()
+	storage := m.statusStorage
+	m.hooksMu.RUnlock()
+
+	// Persisted synchronously: unlike subscribers, storage must not miss
+	// a transition or see them out of order. Standing by, the leader
+	// instance records the states.
+	if storage != nil && !m.standby.Load() {
+		ctx, cancel := context.WithTimeout(context.Background(), statusPersistTimeout)
+		// Best effort: the tunnel may have been deleted while connecting
+		_ = storage.UpdateStatus(ctx, tunnelID, string(status.State))
+		cancel()
+	}
+
+	m.notifyStatusWatch(tunnelID, version, false)
+
+	event := StatusEvent{TunnelID: tunnelID, Status: *status}
+
+	// Held while sending so Unsubscribe can't close a channel mid-send
+	m.hooksMu.RLock()
+	defer m.hooksMu.RUnlock()
+
+	for _, sub := range m.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// statusWatch records a tunnel's latest status change for long-polling
+type statusWatch struct {
+	version uint64        // manager version at the change
+	changed chan struct{} // closed on the next change
+}
+
+// WatchStatus returns the manager version at which the tunnel's status last
+// changed, zero if it hasn't since the manager started, and a channel that
+// is closed when it next changes or the tunnel is deleted. Comparing the
+// version with one seen earlier tells whether a change was missed.
+func (m *Manager) WatchStatus(tunnelID string) (uint64, <-chan struct{}) {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+
+	if m.watches == nil {
+		m.watches = make(map[string]*statusWatch)
+	}
+	w, ok := m.watches[tunnelID]
+	if !ok {
+		w = &statusWatch{changed: make(chan struct{})}
+		m.watches[tunnelID] = w
+	}
+	return w.version, w.changed
+}
+
+// notifyStatusWatch wakes everyone waiting on a tunnel's status
+func (m *Manager) notifyStatusWatch(tunnelID string, version uint64, deleted bool) {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+
+	if w, ok := m.watches[tunnelID]; ok {
+		close(w.changed)
+	}
+	if deleted {
+		delete(m.watches, tunnelID)
+		return
+	}
+	if m.watches == nil {
+		m.watches = make(map[string]*statusWatch)
+	}
+	m.watches[tunnelID] = &statusWatch{version: version, changed: make(chan struct{})}
+}
+
+// closeSubscriptions unsubscribes everyone, e.g. on shutdown. It doesn't
+// wait for in-flight callbacks, which may be blocked on the manager.
+func (m *Manager) closeSubscriptions() {
+	m.hooksMu.RLock()
+	subs := make([]*Subscription, 0, len(m.subscribers))
+	for _, sub := range m.subscribers {
+		subs = append(subs, sub)
+	}
+	m.hooksMu.RUnlock()
+
+	for _, sub := range subs {
+		sub.stop()
+	}
+}
@@ -0,0 +1,160 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// newEventTunnel creates a tunnel wired to the manager's status hooks without connecting
+func newEventTunnel(m *Manager, id string) *Tunnel {
+	return &Tunnel{
+		Spec:           &types.TunnelSpec{ID: id, Type: types.TunnelTypeLocal},
+		ctx:            context.Background(),
+		statusCallback: m.handleStatusChange,
+	}
+}
+
+func TestSubscribeMultipleSubscribersInOrder(t *testing.T) {
+	manager := NewManager(context.Background())
+	tunnel := newEventTunnel(manager, "events-1")
+
+	first := make(chan types.TunnelState, 8)
+	second := make(chan types.TunnelState, 8)
+	subA := manager.Subscribe(func(_ string, status *types.TunnelStatus) { first <- status.State }, 0)
+	subB := manager.Subscribe(func(_ string, status *types.TunnelStatus) { second <- status.State }, 0)
+
+	states := []types.TunnelState{types.TunnelStatePending, types.TunnelStateActive, types.TunnelStateFailed}
+	for _, state := range states {
+		tunnel.updateStatus(state, "")
+	}
+
+	subA.Unsubscribe()
+	subB.Unsubscribe()
+
+	for name, ch := range map[string]chan types.TunnelState{"first": first, "second": second} {
+		if len(ch) != len(states) {
+			t.Fatalf("%s subscriber: expected %d events, got %d", name, len(states), len(ch))
+		}
+		for _, want := range states {
+			if got := <-ch; got != want {
+				t.Errorf("%s subscriber: expected %s, got %s", name, want, got)
+			}
+		}
+	}
+}
+
+func TestUnsubscribeStopsDelivery(t *testing.T) {
+	manager := NewManager(context.Background())
+	tunnel := newEventTunnel(manager, "events-2")
+
+	received := make(chan types.TunnelState, 8)
+	sub := manager.Subscribe(func(_ string, status *types.TunnelStatus) { received <- status.State }, 0)
+
+	tunnel.updateStatus(types.TunnelStateActive, "")
+	sub.Unsubscribe()
+	sub.Unsubscribe() // idempotent
+	tunnel.updateStatus(types.TunnelStateFailed, "")
+
+	if len(received) != 1 {
+		t.Errorf("Expected 1 event before unsubscribe, got %d", len(received))
+	}
+}
+
+func TestSlowSubscriberDoesNotBlock(t *testing.T) {
+	manager := NewManager(context.Background())
+	tunnel := newEventTunnel(manager, "events-3")
+
+	release := make(chan struct{})
+	slow := manager.Subscribe(func(string, *types.TunnelStatus) { <-release }, 1)
+
+	fast := make(chan types.TunnelState, 16)
+	fastSub := manager.Subscribe(func(_ string, status *types.TunnelStatus) { fast <- status.State }, 16)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			tunnel.updateStatus(types.TunnelStateActive, "")
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Status updates blocked on a slow subscriber")
+	}
+
+	close(release)
+	slow.Unsubscribe()
+	fastSub.Unsubscribe()
+
+	// One event in flight plus one buffered; the rest are dropped
+	if slow.Dropped() < 8 {
+		t.Errorf("Expected at least 8 dropped events, got %d", slow.Dropped())
+	}
+	if fastSub.Dropped() != 0 || len(fast) != 10 {
+		t.Errorf("Expected fast subscriber to receive all 10 events, got %d (dropped %d)", len(fast), fastSub.Dropped())
+	}
+}
+
+func TestSetStatusCallbackReplacesPrevious(t *testing.T) {
+	manager := NewManager(context.Background())
+	tunnel := newEventTunnel(manager, "events-4")
+
+	old := make(chan struct{}, 8)
+	current := make(chan struct{}, 8)
+	manager.SetStatusCallback(func(string, *types.TunnelStatus) { old <- struct{}{} })
+	manager.SetStatusCallback(func(string, *types.TunnelStatus) { current <- struct{}{} })
+
+	tunnel.updateStatus(types.TunnelStateActive, "")
+	manager.SetStatusCallback(nil)
+
+	if len(old) != 0 {
+		t.Errorf("Expected replaced callback to receive nothing, got %d events", len(old))
+	}
+	if len(current) != 1 {
+		t.Errorf("Expected current callback to receive 1 event, got %d", len(current))
+	}
+}
+
+func TestWatchStatus(t *testing.T) {
+	manager := NewManager(context.Background())
+	tunnel := newEventTunnel(manager, "events-5")
+
+	version, changed := manager.WatchStatus("events-5")
+	if version != 0 {
+		t.Errorf("Expected no change recorded yet, got version %d", version)
+	}
+	select {
+	case <-changed:
+		t.Fatal("Expected the channel to stay open until a change")
+	default:
+	}
+
+	tunnel.updateStatus(types.TunnelStateActive, "")
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a status change to close the channel")
+	}
+
+	changedAt, next := manager.WatchStatus("events-5")
+	if changedAt == 0 || changedAt != manager.Version() {
+		t.Errorf("Expected the change at version %d, got %d", manager.Version(), changedAt)
+	}
+
+	manager.mu.Lock()
+	manager.tunnels["events-5"] = tunnel
+	manager.mu.Unlock()
+	if err := manager.Delete(context.Background(), "events-5"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	select {
+	case <-next:
+	case <-time.After(time.Second):
+		t.Fatal("Expected deleting the tunnel to close the channel")
+	}
+}
@@ -0,0 +1,60 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Exec runs a command on a hop over the tunnel's existing SSH connection,
+// streaming its output to stdout and stderr, and returns its exit status.
+// A negative hop selects the last hop. The command is killed when ctx is
+// done. stdout and stderr are written from separate goroutines.
+func (t *Tunnel) Exec(ctx context.Context, hop int, command string, stdout, stderr io.Writer) (int, error) {
+	client, err := t.hopClient(hop)
+	if err != nil {
+		return -1, err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return -1, fmt.Errorf("failed to open session: %w", err)
+	}
+	defer session.Close()
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	if err := session.Start(command); err != nil {
+		return -1, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Not every server honours signals, so closing the channel is what
+		// actually ends the command
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		<-done
+		return -1, ctx.Err()
+	}
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		return exitErr.ExitStatus(), nil
+	default:
+		return -1, fmt.Errorf("command failed: %w", err)
+	}
+}
@@ -0,0 +1,116 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// handleExec runs a few canned commands: "ss -ltn" prints to stdout, "false"
+// writes to stderr and exits 3, and "sleep" blocks until the session is closed
+func handleExec(_ *ssh.ServerConn, chans <-chan ssh.NewChannel) {
+	for newCh := range chans {
+		ch, reqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range reqs {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				ssh.Unmarshal(req.Payload, &payload)
+				req.Reply(true, nil)
+
+				status := uint32(0)
+				switch payload.Command {
+				case "ss -ltn":
+					io.WriteString(ch, "LISTEN 0 128 0.0.0.0:5432\n")
+				case "false":
+					io.WriteString(ch.Stderr(), "permission denied\n")
+					status = 3
+				case "sleep":
+					continue
+				}
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				ch.Close()
+			}
+		}()
+	}
+}
+
+func newExecTunnel(t *testing.T) *Tunnel {
+	t.Helper()
+
+	addr := startTestSSHServer(t, nil, handleExec)
+
+	hop := &types.Hop{Host: "bastion", Port: 22, User: "deploy", AuthMethod: types.AuthMethodPassword}
+	session, err := NewSession(context.Background(), SessionConfig{Hop: hop})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	t.Cleanup(func() { session.Close() })
+	session.config = testClientConfig(hop.User)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	if err := session.connectOverConn(conn); err != nil {
+		t.Fatalf("connectOverConn() error = %v", err)
+	}
+
+	return &Tunnel{Spec: &types.TunnelSpec{ID: "exec"}, session: session}
+}
+
+func TestTunnelExec(t *testing.T) {
+	tunnel := newExecTunnel(t)
+
+	tests := []struct {
+		command    string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"ss -ltn", 0, "LISTEN 0 128 0.0.0.0:5432\n", ""},
+		{"false", 3, "", "permission denied\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code, err := tunnel.Exec(context.Background(), -1, tt.command, &stdout, &stderr)
+			if err != nil {
+				t.Fatalf("Exec failed: %v", err)
+			}
+			if code != tt.wantCode || stdout.String() != tt.wantStdout || stderr.String() != tt.wantStderr {
+				t.Errorf("Exec() = %d, stdout %q, stderr %q", code, stdout.String(), stderr.String())
+			}
+		})
+	}
+}
+
+func TestTunnelExecTimeout(t *testing.T) {
+	tunnel := newExecTunnel(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := tunnel.Exec(ctx, -1, "sleep", io.Discard, io.Discard)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Exec took %v to give up", elapsed)
+	}
+}
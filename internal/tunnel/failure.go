@@ -0,0 +1,110 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// FailureError attaches a failure reason to an error where the error alone
+// would be classified wrongly, e.g. a hop that the previous hop can't
+// reach fails like a destination would
+type FailureError struct {
+	Reason types.FailureReason
+	Err    error
+}
+
+func (e *FailureError) Error() string { return e.Err.Error() }
+
+func (e *FailureError) Unwrap() error { return e.Err }
+
+// withReason wraps err with reason, keeping nil errors nil
+func withReason(reason types.FailureReason, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &FailureError{Reason: reason, Err: err}
+}
+
+// ClassifyFailure returns the reason of a tunnel or hop failure, FailureUnknown
+// if it has none of the known causes, or "" for a nil error
+func ClassifyFailure(err error) types.FailureReason {
+	if err == nil {
+		return ""
+	}
+
+	var failure *FailureError
+	if errors.As(err, &failure) {
+		return failure.Reason
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return types.FailureCircuitOpen
+	}
+
+	// An unknown host key fails the same way as a changed one, and is fixed
+	// the same way: by updating known_hosts
+	var keyErr *knownhosts.KeyError
+	if errors.As(err, &keyErr) {
+		return types.FailureHostKeyMismatch
+	}
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return types.FailurePortInUse
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsFailureReason(dnsErr)
+	}
+
+	var channelErr *ssh.OpenChannelError
+	if errors.As(err, &channelErr) {
+		if reason := hopDNSFailure(channelErr); reason != "" {
+			return reason
+		}
+		return types.FailureTargetUnreachable
+	}
+	if errors.Is(err, ErrDialTimeout) {
+		return types.FailureTargetUnreachable
+	}
+
+	// x/crypto/ssh reports these without error types
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "ssh: unable to authenticate"):
+		return types.FailureAuthFailed
+	case strings.Contains(msg, "tcpip-forward request denied by peer"),
+		strings.Contains(msg, "streamlocal-forward@openssh.com request denied by peer"):
+		// Usually the port is taken on the hop; sshd doesn't say
+		return types.FailurePortInUse
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return types.FailureHostUnreachable
+	}
+	return types.FailureUnknown
+}
+
+// hopDNSFailure returns the reason of a channel a hop refused because it
+// couldn't resolve the destination, or "" if it refused for another reason.
+// OpenSSH passes on getaddrinfo's message, which differs by platform.
+func hopDNSFailure(err *ssh.OpenChannelError) types.FailureReason {
+	msg := strings.ToLower(err.Message)
+	switch {
+	case strings.Contains(msg, "temporary failure in name resolution"),
+		strings.Contains(msg, "name server"):
+		return types.FailureDNSUnavailable
+	case strings.Contains(msg, "name or service not known"),
+		strings.Contains(msg, "nodename nor servname"),
+		strings.Contains(msg, "no address associated with hostname"),
+		strings.Contains(msg, "unknown host"):
+		return types.FailureDNSNotFound
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package tunnel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	serverConfig := newTestServerConfig(t)
+	serverConfig.PasswordCallback = func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+		return nil, errors.New("wrong password")
+	}
+	addr := startTestSSHServer(t, serverConfig, nil)
+
+	// A password the server rejects
+	_, authErr := ssh.Dial("tcp", addr, testClientConfig("deploy"))
+
+	// A known_hosts entry with another key
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ssh.NewPublicKey(other)
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	os.WriteFile(knownHostsPath, []byte(knownhosts.Line([]string{addr}, otherKey)+"\n"), 0600)
+	config := testClientConfig("deploy")
+	config.HostKeyCallback, _ = knownhosts.New(knownHostsPath)
+	_, keyErr := ssh.Dial("tcp", addr, config)
+
+	// A port already listened on, and one nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	_, bindErr := net.Listen("tcp", listener.Addr().String())
+	listener.Close()
+	_, dialErr := net.Dial("tcp", listener.Addr().String())
+
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{MaxFailures: 1, RecoveryTimeout: time.Hour})
+	breaker.RecordFailure()
+	circuitErr := breaker.Allow()
+
+	tests := []struct {
+		name string
+		err  error
+		want types.FailureReason
+	}{
+		{"nil", nil, ""},
+		{"auth", authErr, types.FailureAuthFailed},
+		{"host key", keyErr, types.FailureHostKeyMismatch},
+		{"port in use", fmt.Errorf("failed to bind: %w", bindErr), types.FailurePortInUse},
+		{"remote port in use", errors.New("failed to bind remote port 0.0.0.0:80: ssh: tcpip-forward request denied by peer"), types.FailurePortInUse},
+		{"host unreachable", dialErr, types.FailureHostUnreachable},
+		{"target unreachable", &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "connect failed"}, types.FailureTargetUnreachable},
+		{"target timeout", fmt.Errorf("dial db:5432 after 1s: %w", ErrDialTimeout), types.FailureTargetUnreachable},
+		{"circuit open", circuitErr, types.FailureCircuitOpen},
+		{"no such host", &net.OpError{Op: "dial", Err: &net.DNSError{Name: "bastion", IsNotFound: true}}, types.FailureDNSNotFound},
+		{"dns timeout", &ResolveError{Host: "bastion", Err: &net.DNSError{Name: "bastion", IsTimeout: true}}, types.FailureDNSUnavailable},
+		{"target not found by the hop", &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "Name or service not known"}, types.FailureDNSNotFound},
+		{"hop dns down", &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "Temporary failure in name resolution"}, types.FailureDNSUnavailable},
+		{"unreachable hop", withReason(types.FailureHostUnreachable, &ssh.OpenChannelError{Reason: ssh.ConnectionFailed}), types.FailureHostUnreachable},
+		{"unknown", errors.New("something else"), types.FailureUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyFailure(tt.err); got != tt.want {
+				t.Errorf("ClassifyFailure(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
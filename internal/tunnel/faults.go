@@ -0,0 +1,109 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ErrInjectedFault is the error of failures injected with SetFaults and
+// DropConnection
+var ErrInjectedFault = errors.New("injected fault")
+
+// Faults are failures injected into a tunnel on purpose, to test how the
+// applications using it, and its own reconnects, cope with them. The zero
+// value injects none.
+type Faults struct {
+	DialDelay        time.Duration // before each connection through the tunnel is opened
+	SOCKSFailPercent int           // of dynamic tunnels' SOCKS handshakes refused
+}
+
+// FaultSource returns the faults currently injected into a tunnel
+type FaultSource func() Faults
+
+// WithFaults makes forwarders inject the faults faults returns
+func WithFaults(faults FaultSource) ForwarderOption {
+	return func(f *socketFactories) {
+		f.faults = faults
+	}
+}
+
+// failSOCKS reports whether to refuse a SOCKS handshake, as often as the
+// injected faults ask for
+func (f socketFactories) failSOCKS() bool {
+	if f.faults == nil {
+		return false
+	}
+	percent := f.faults().SOCKSFailPercent
+	return percent > 0 && rand.Intn(100) < percent
+}
+
+// delayDial waits out the injected dial delay, giving up when ctx is done
+func delayDial(ctx context.Context, faults FaultSource) error {
+	if faults == nil {
+		return nil
+	}
+	delay := faults().DialDelay
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetFaults injects faults into a tunnel from its next connection on,
+// replacing those injected before; the zero Faults stops injecting. Faults
+// are kept in memory, across restarts of the tunnel but not of the server.
+func (m *Manager) SetFaults(tunnelID string, faults Faults) error {
+	t, err := m.Get(tunnelID)
+	if err != nil {
+		return err
+	}
+	t.faults.Store(&faults)
+	return nil
+}
+
+// Faults returns the faults injected into a tunnel
+func (m *Manager) Faults(tunnelID string) (Faults, error) {
+	t, err := m.Get(tunnelID)
+	if err != nil {
+		return Faults{}, err
+	}
+	return t.currentFaults(), nil
+}
+
+// DropConnection tears down the SSH connection to one of a tunnel's hops,
+// counting from 0, as if it had been lost: the tunnel fails or reconnects
+// just as it would after a network outage
+func (m *Manager) DropConnection(tunnelID string, hop int) error {
+	t, err := m.Get(tunnelID)
+	if err != nil {
+		return err
+	}
+	session, err := t.hopSession(hop)
+	if err != nil {
+		return err
+	}
+	client := session.Client()
+	if client == nil {
+		return ErrNotConnected
+	}
+	session.connectionLost(client, fmt.Errorf("connection dropped: %w", ErrInjectedFault))
+	return nil
+}
+
+// currentFaults returns the faults injected with SetFaults
+func (t *Tunnel) currentFaults() Faults {
+	if faults := t.faults.Load(); faults != nil {
+		return *faults
+	}
+	return Faults{}
+}
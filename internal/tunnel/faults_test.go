@@ -0,0 +1,99 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestDynamicForwarderInjectedSOCKSFailure(t *testing.T) {
+	faults := Faults{SOCKSFailPercent: 100}
+	dialed := false
+	session := &MockSessionDialer{connected: true, dialFunc: func(network, address string) (net.Conn, error) {
+		dialed = true
+		return nil, errors.New("unexpected dial")
+	}}
+	spec := &types.TunnelSpec{ID: "test-socks-faults", Type: types.TunnelTypeDynamic, LocalBindAddress: "127.0.0.1"}
+	forwarder, err := NewDynamicForwarder(context.Background(), spec, session, WithFaults(func() Faults { return faults }))
+	if err != nil {
+		t.Fatalf("NewDynamicForwarder() error = %v", err)
+	}
+	if err := forwarder.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer forwarder.Stop()
+
+	conn, err := net.Dial("tcp", forwarder.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Greeting without auth, then CONNECT 10.0.0.1:80
+	reply := make([]byte, 10)
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, reply[:2]); err != nil {
+		t.Fatalf("Failed to read method selection: %v", err)
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 10, 0, 0, 1, 0, 80}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Failed to read CONNECT reply: %v", err)
+	}
+	if reply[1] != 0x01 {
+		t.Errorf("CONNECT reply = %#x, want general failure", reply[1])
+	}
+	if dialed {
+		t.Error("Expected a refused handshake not to dial the destination")
+	}
+}
+
+func TestDelayDial(t *testing.T) {
+	if err := delayDial(context.Background(), nil); err != nil {
+		t.Errorf("delayDial() without faults = %v", err)
+	}
+
+	source := func() Faults { return Faults{DialDelay: 50 * time.Millisecond} }
+	start := time.Now()
+	if err := delayDial(context.Background(), source); err != nil || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("delayDial() = %v after %v, want nil after the delay", err, time.Since(start))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	source = func() Faults { return Faults{DialDelay: time.Hour} }
+	if err := delayDial(ctx, source); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("delayDial() past the deadline = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestManagerFaults(t *testing.T) {
+	manager := NewManager(context.Background())
+	spec := &types.TunnelSpec{ID: "faulty", Name: "faulty", Type: types.TunnelTypeLocal,
+		RemoteHost: "db", RemotePort: 5432, Hops: []types.Hop{{Host: "bastion", Port: 22, User: "ops"}}}
+	createTunnels(t, manager, spec)
+
+	want := Faults{DialDelay: time.Second, SOCKSFailPercent: 20}
+	if err := manager.SetFaults(spec.ID, want); err != nil {
+		t.Fatalf("SetFaults() error = %v", err)
+	}
+	if got, err := manager.Faults(spec.ID); err != nil || got != want {
+		t.Errorf("Faults() = %+v, %v, want %+v", got, err, want)
+	}
+	if err := manager.SetFaults("missing", want); err == nil {
+		t.Error("Expected faults for an unknown tunnel to fail")
+	}
+
+	if err := manager.DropConnection(spec.ID, 0); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("DropConnection() of a tunnel without a session = %v, want %v", err, ErrNotConnected)
+	}
+}
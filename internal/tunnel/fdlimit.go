@@ -0,0 +1,136 @@
+package tunnel
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// fdRecountInterval is how often a guard past its threshold counts the open
+// descriptors again, as connections may have closed since its last count
+const fdRecountInterval = 100 * time.Millisecond
+
+// FDUsage is how many file descriptors the process holds against its limit
+type FDUsage struct {
+	Open      int    `json:"open"`      // -1 when unavailable on this platform
+	Limit     uint64 `json:"limit"`     // soft RLIMIT_NOFILE; 0 when unavailable
+	HardLimit uint64 `json:"hardLimit"` // what the soft limit can be raised to
+	Threshold int64  `json:"threshold"` // open descriptors past which new connections are rejected; 0 never
+	Rejected  int64  `json:"rejected"`  // connections rejected past the threshold
+}
+
+// FDLimit returns the soft and hard RLIMIT_NOFILE of the process. The Go
+// runtime raises the soft limit to the hard one at startup. ok is false on
+// platforms without the limit.
+func FDLimit() (soft, hard uint64, ok bool) {
+	return fdLimit()
+}
+
+// OpenFDs returns the number of open file descriptors, or -1 on platforms
+// that can't list them
+func OpenFDs() int {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		if entries, err := os.ReadDir(dir); err == nil {
+			return len(entries)
+		}
+	}
+	return -1
+}
+
+// FDGuard rejects new forwarded connections while the process nears its
+// file descriptor limit, so running out doesn't fail established
+// connections, new SSH connections and the API along with them
+type FDGuard struct {
+	threshold int64 // 0 never rejects
+
+	open      atomic.Int64 // as of the last count, -1 if unknown
+	countedAt atomic.Int64 // unix nanoseconds
+	rejected  atomic.Int64
+}
+
+// NewFDGuard returns a guard keeping headroom percent of the descriptor
+// limit free for what is already running. A headroom of 0, or a platform
+// without the limit, never rejects.
+func NewFDGuard(headroom int) *FDGuard {
+	g := &FDGuard{}
+	if limit, _, _ := FDLimit(); headroom > 0 && limit > 0 && g.count() >= 0 {
+		g.threshold = int64(limit) * int64(100-headroom) / 100
+	}
+	return g
+}
+
+// Run counts the open descriptors every interval until ctx is done
+func (g *FDGuard) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.count()
+		}
+	}
+}
+
+// count counts the open descriptors and returns how many there are
+func (g *FDGuard) count() int64 {
+	open := int64(OpenFDs())
+	g.open.Store(open)
+	g.countedAt.Store(time.Now().UnixNano())
+	return open
+}
+
+// Admit reports whether a new connection may be served, counting it as
+// rejected if not. A nil guard admits everything.
+func (g *FDGuard) Admit() bool {
+	if g == nil || g.threshold == 0 || g.open.Load() < g.threshold {
+		return true
+	}
+	if time.Since(time.Unix(0, g.countedAt.Load())) >= fdRecountInterval && g.count() < g.threshold {
+		return true
+	}
+	g.rejected.Add(1)
+	return false
+}
+
+// Usage returns the descriptors in use against the limit, and what the
+// guard rejected unless it is nil
+func (g *FDGuard) Usage() FDUsage {
+	usage := FDUsage{Open: OpenFDs()}
+	usage.Limit, usage.HardLimit, _ = FDLimit()
+	if g != nil {
+		usage.Threshold, usage.Rejected = g.threshold, g.rejected.Load()
+	}
+	return usage
+}
+
+// WithFDGuard makes forwarders close the connections guard doesn't admit
+// as soon as they are accepted
+func WithFDGuard(guard *FDGuard) ForwarderOption {
+	return func(f *socketFactories) {
+		f.fdGuard = guard
+	}
+}
+
+// BaselineFDs estimates the file descriptors the tunnels hold open while no
+// connection goes through them: one for the first hop's SSH connection,
+// later hops running over it, and one per local listener
+func BaselineFDs(specs []*types.TunnelSpec) int {
+	var fds int
+	for _, spec := range specs {
+		fds++
+		switch {
+		case spec.Type == types.TunnelTypeRemote:
+		case len(spec.Ports) > 0:
+			fds += len(spec.Ports)
+		default:
+			fds++
+		}
+	}
+	return fds
+}
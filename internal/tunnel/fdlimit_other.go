@@ -0,0 +1,8 @@
+//go:build !unix
+
+package tunnel
+
+// fdLimit reports no limit; Windows has no RLIMIT_NOFILE
+func fdLimit() (soft, hard uint64, ok bool) {
+	return 0, 0, false
+}
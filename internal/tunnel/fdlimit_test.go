@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestFDGuardAdmit(t *testing.T) {
+	var none *FDGuard
+	if !none.Admit() {
+		t.Error("Expected a nil guard to admit")
+	}
+
+	g := &FDGuard{threshold: 100}
+	g.open.Store(50)
+	if !g.Admit() {
+		t.Error("Expected a connection below the threshold to be admitted")
+	}
+
+	// Past the threshold as of a fresh count
+	g.open.Store(100)
+	g.countedAt.Store(time.Now().UnixNano())
+	if g.Admit() {
+		t.Error("Expected a connection past the threshold to be rejected")
+	}
+	if usage := g.Usage(); usage.Rejected != 1 || usage.Threshold != 100 {
+		t.Errorf("Usage() = %+v, want 1 rejected", usage)
+	}
+
+	// A stale count is checked again before rejecting
+	if OpenFDs() < 0 {
+		t.Skip("Open file descriptors can't be counted on this platform")
+	}
+	g.threshold = int64(OpenFDs()) + 1000
+	g.open.Store(g.threshold)
+	g.countedAt.Store(time.Now().Add(-time.Second).UnixNano())
+	if !g.Admit() {
+		t.Error("Expected a recount below the threshold to admit")
+	}
+	if g.open.Load() >= g.threshold {
+		t.Errorf("Expected the recount to be kept, got %d", g.open.Load())
+	}
+}
+
+func TestNewFDGuard(t *testing.T) {
+	if g := NewFDGuard(0); g.threshold != 0 {
+		t.Errorf("Expected no headroom to never reject, threshold %d", g.threshold)
+	}
+	limit, _, ok := FDLimit()
+	if !ok || OpenFDs() < 0 {
+		t.Skip("No file descriptor limit on this platform")
+	}
+	if g := NewFDGuard(10); g.threshold != int64(limit)*90/100 {
+		t.Errorf("threshold = %d, want 90%% of %d", g.threshold, limit)
+	}
+}
+
+func TestLocalForwarderRejectsPastFDThreshold(t *testing.T) {
+	mem := newMemNetwork()
+	guard := &FDGuard{threshold: 1}
+	guard.open.Store(1)
+	guard.countedAt.Store(time.Now().Add(time.Hour).UnixNano()) // no recount
+
+	spec := &types.TunnelSpec{Type: types.TunnelTypeLocal, LocalBindAddress: "127.0.0.1", LocalPort: 5432,
+		RemoteHost: "db.internal", RemotePort: 5432}
+	forwarder, err := NewLocalForwarder(context.Background(), spec, &MockSessionDialer{connected: true},
+		WithListenerFactory(mem), WithFDGuard(guard))
+	if err != nil {
+		t.Fatalf("NewLocalForwarder() error = %v", err)
+	}
+	if err := forwarder.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer forwarder.ForceStop()
+
+	client, err := mem.DialContext(context.Background(), "tcp", "127.0.0.1:5432")
+	if err != nil {
+		t.Fatalf("Failed to connect to the forwarder: %v", err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read() error = %v, want the connection closed", err)
+	}
+	if stats := forwarder.Stats(); stats.Connections != 0 || stats.Errors != 1 {
+		t.Errorf("Stats() = %+v, want the rejection counted as an error only", stats)
+	}
+	if guard.Usage().Rejected != 1 {
+		t.Errorf("Expected the guard to count the rejection")
+	}
+}
+
+func TestBaselineFDs(t *testing.T) {
+	specs := []*types.TunnelSpec{
+		{Type: types.TunnelTypeLocal},
+		{Type: types.TunnelTypeRemote},
+		{Type: types.TunnelTypeLocal, Ports: []types.PortMapping{{}, {}, {}}},
+		{Type: types.TunnelTypeDynamic},
+	}
+	// SSH connections: 4, listeners: 1 + 0 + 3 + 1
+	if got := BaselineFDs(specs); got != 9 {
+		t.Errorf("BaselineFDs() = %d, want 9", got)
+	}
+}
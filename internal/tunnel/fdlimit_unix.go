@@ -0,0 +1,13 @@
+//go:build unix
+
+package tunnel
+
+import "syscall"
+
+func fdLimit() (soft, hard uint64, ok bool) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, 0, false
+	}
+	return uint64(limit.Cur), uint64(limit.Max), true
+}
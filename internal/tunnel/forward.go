@@ -5,8 +5,8 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -28,8 +28,19 @@ type ForwarderStats struct {
 	Connections   int64
 	ActiveConns   int64
 	Errors        int64
+	Timeouts      int64 // dial timeouts (also counted in Errors) and idle closes
+	DialRetries   int64 // dials retried after a failure
 	StartedAt     time.Time
 	LastActivity  time.Time
+
+	// Resource footprint, for capacity planning on shared jump servers
+	Goroutines  int64 // goroutines currently serving the forwarder
+	OpenSockets int64 // listeners, client connections and tunnel channels held open
+	BufferBytes int64 // proxy buffer memory currently in use
+
+	// Seq counts the updates to the statistics so far: a snapshot with the
+	// same Seq as an earlier one has the same counters
+	Seq uint64
 }
 
 // LocalForwarder implements local port forwarding
@@ -37,13 +48,16 @@ type ForwarderStats struct {
 type LocalForwarder struct {
 	spec     *types.TunnelSpec
 	session  SessionDialer
+	sockets  socketFactories
 	listener net.Listener
+	pool     *targetPool // nil unless the spec lists Targets
 
 	// Stats
-	stats ForwarderStats
+	stats    forwarderCounters
+	activity activityClock
 
 	// Connection tracking
-	activeConns sync.WaitGroup
+	activeConns connTracker
 	mu          sync.RWMutex
 
 	// Lifecycle
@@ -56,6 +70,8 @@ type LocalForwarder struct {
 // SessionDialer interface allows for both single and multi-hop sessions
 type SessionDialer interface {
 	Dial(network, address string) (net.Conn, error)
+	// DialContext dials like Dial, giving up when ctx is done
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
 	IsConnected() bool
 }
 
@@ -63,7 +79,7 @@ type SessionDialer interface {
 var _ SessionDialer = (*Session)(nil)
 
 // NewLocalForwarder creates a new local port forwarder
-func NewLocalForwarder(ctx context.Context, spec *types.TunnelSpec, session SessionDialer) (*LocalForwarder, error) {
+func NewLocalForwarder(ctx context.Context, spec *types.TunnelSpec, session SessionDialer, opts ...ForwarderOption) (*LocalForwarder, error) {
 	if spec.Type != types.TunnelTypeLocal {
 		return nil, fmt.Errorf("invalid tunnel type: expected local, got %s", spec.Type)
 	}
@@ -73,22 +89,31 @@ func NewLocalForwarder(ctx context.Context, spec *types.TunnelSpec, session Sess
 		return nil, fmt.Errorf("invalid local port: %d", spec.LocalPort)
 	}
 
-	if spec.RemoteHost == "" || spec.RemotePort == 0 {
+	if len(spec.Targets) == 0 && (spec.RemoteHost == "" || spec.RemotePort == 0) {
 		return nil, fmt.Errorf("remote host and port are required for local forwarding")
 	}
+	for _, target := range spec.Targets {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return nil, fmt.Errorf("invalid target %q: %w", target, err)
+		}
+	}
 
 	fwdCtx, cancel := context.WithCancel(ctx)
 
 	lf := &LocalForwarder{
 		spec:    spec,
 		session: session,
+		sockets: newSocketFactories(opts),
 		ctx:     fwdCtx,
 		cancel:  cancel,
 		stopCh:  make(chan struct{}),
 	}
+	if len(spec.Targets) > 0 {
+		lf.pool = newTargetPool(spec.Targets, spec.Balance.Strategy)
+	}
 
-	lf.stats.StartedAt = time.Now()
-	lf.stats.LastActivity = time.Now()
+	lf.stats.startedAt = time.Now()
+	lf.activity.Touch()
 
 	return lf, nil
 }
@@ -101,21 +126,17 @@ func (lf *LocalForwarder) Start() error {
 		return fmt.Errorf("forwarder already started")
 	}
 
-	// Determine bind address (default to 0.0.0.0 to allow external access)
-	bindAddr := lf.spec.LocalBindAddress
-	if bindAddr == "" {
-		bindAddr = "0.0.0.0"
-	}
-
-	// Bind to local port (port 0 means OS chooses an ephemeral port)
-	addr := fmt.Sprintf("%s:%d", bindAddr, lf.spec.LocalPort)
-	listener, err := net.Listen("tcp", addr)
+	// Bind to all interfaces unless the spec names an address, allowing
+	// external access
+	// Port 0 means OS chooses an ephemeral port
+	listener, addr, err := lf.sockets.listen(lf.ctx, lf.spec, false)
 	if err != nil {
 		lf.mu.Unlock()
 		return fmt.Errorf("failed to bind to %s: %w", addr, err)
 	}
 
 	lf.listener = listener
+	lf.stats.add(1, &lf.stats.openSockets)
 
 	// Update spec with actual bound port if ephemeral was used
 	if lf.spec.LocalPort == 0 {
@@ -129,11 +150,19 @@ func (lf *LocalForwarder) Start() error {
 	// Accept connections in a goroutine
 	go lf.acceptLoop()
 
+	if lf.pool != nil {
+		go lf.healthCheckLoop()
+	}
+
 	return nil
 }
 
 // acceptLoop accepts incoming connections and spawns goroutines to handle them
 func (lf *LocalForwarder) acceptLoop() {
+	lf.stats.add(1, &lf.stats.goroutines)
+	defer lf.stats.add(-1, &lf.stats.goroutines)
+
+	var backoff acceptBackoff
 	for {
 		// Check if we should stop before accepting
 		select {
@@ -162,79 +191,166 @@ func (lf *LocalForwarder) acceptLoop() {
 			case <-lf.ctx.Done():
 				return
 			default:
-				// Error during accept
-				atomic.AddInt64(&lf.stats.Errors, 1)
-				continue
 			}
+
+			// Pause on errors rather than spin, and give up on a broken listener
+			lf.stats.add(1, &lf.stats.errors)
+			if backoff.failed(err) {
+				lf.sockets.reportListenerFailure(err)
+				return
+			}
+			if !backoff.pause(lf.stopCh, lf.ctx.Done()) {
+				return
+			}
+			continue
+		}
+		backoff.reset()
+		if !lf.sockets.admit(conn) {
+			lf.stats.add(1, &lf.stats.errors)
+			continue
 		}
 
 		// Handle connection in a new goroutine
-		lf.activeConns.Add(1)
-		go lf.handleConnection(conn)
+		tracked := lf.activeConns.add(conn)
+		go lf.handleConnection(conn, tracked)
 	}
 }
 
 // handleConnection handles a single forwarded connection
-func (lf *LocalForwarder) handleConnection(localConn net.Conn) {
-	defer lf.activeConns.Done()
+func (lf *LocalForwarder) handleConnection(localConn net.Conn, tracked *trackedConn) {
+	defer lf.activeConns.done(tracked)
 	defer localConn.Close()
 
-	atomic.AddInt64(&lf.stats.Connections, 1)
-	atomic.AddInt64(&lf.stats.ActiveConns, 1)
-	defer atomic.AddInt64(&lf.stats.ActiveConns, -1)
+	lf.stats.add(1, &lf.stats.connections, &lf.stats.activeConns)
+	defer lf.stats.add(-1, &lf.stats.activeConns)
+	lf.stats.add(1, &lf.stats.goroutines)
+	defer lf.stats.add(-1, &lf.stats.goroutines)
+	lf.stats.add(1, &lf.stats.openSockets)
+	defer lf.stats.add(-1, &lf.stats.openSockets)
 
 	// Check if session is connected
 	if !lf.session.IsConnected() {
-		atomic.AddInt64(&lf.stats.Errors, 1)
+		lf.stats.add(1, &lf.stats.errors)
 		return
 	}
 
-	// Dial remote destination through SSH tunnel
-	remoteAddr := fmt.Sprintf("%s:%d", lf.spec.RemoteHost, lf.spec.RemotePort)
-	remoteConn, err := lf.session.Dial("tcp", remoteAddr)
+	// Socket tuning is best effort; a failure shouldn't drop the connection
+	applyTCPOptions(localConn, lf.spec.TCP)
+
+	// Dial remote destination through SSH tunnel. Pooled tunnels pick a
+	// target per attempt, so a retry fails over to another target.
+	remoteAddr := net.JoinHostPort(lf.spec.RemoteHost, strconv.Itoa(lf.spec.RemotePort))
+	var target *poolTarget
+	remoteConn, err := dialWithRetry(lf.spec.TCP, lf.stopCh, &lf.stats, func() (net.Conn, error) {
+		if lf.pool == nil {
+			return lf.dialTarget(remoteAddr)
+		}
+
+		picked, err := lf.pool.pick()
+		if err != nil {
+			return nil, err
+		}
+		conn, err := lf.dialTarget(picked.addr)
+		picked.record(err)
+		if err == nil {
+			target = picked
+		}
+		return conn, err
+	})
 	if err != nil {
-		atomic.AddInt64(&lf.stats.Errors, 1)
+		lf.stats.failed(err)
 		return
 	}
 	defer remoteConn.Close()
+	tracked.hold(remoteConn)
+	lf.stats.add(1, &lf.stats.openSockets)
+	defer lf.stats.add(-1, &lf.stats.openSockets)
+	if target != nil {
+		target.activeConns.Add(1)
+		defer target.activeConns.Add(-1)
+	}
+
+	if target != nil {
+		tracked.setTarget(target.addr)
+	} else {
+		tracked.setTarget(remoteAddr)
+	}
 
 	// Bidirectional copy
-	lf.proxy(localConn, remoteConn)
+	proxyConns(localConn, remoteConn, &lf.stats, &lf.activity, lf.spec.TCP.IdleTimeout, tracked)
+}
+
+// dialTarget connects to a destination through the tunnel, looking up the
+// address book entry it references, if any, on every dial
+func (lf *LocalForwarder) dialTarget(addr string) (net.Conn, error) {
+	addr, err := lf.sockets.target(lf.ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return dialContext(lf.ctx, lf.session, "tcp", addr, dialTimeout(lf.spec.TCP))
 }
 
-// proxy copies data bidirectionally between two connections
-func (lf *LocalForwarder) proxy(local, remote net.Conn) {
-	var wg sync.WaitGroup
-	wg.Add(2)
+// healthCheckLoop periodically dials every pooled target through the
+// tunnel, ejecting targets that don't answer and restoring those that do
+func (lf *LocalForwarder) healthCheckLoop() {
+	lf.stats.add(1, &lf.stats.goroutines)
+	defer lf.stats.add(-1, &lf.stats.goroutines)
 
-	// Local -> Remote
-	go func() {
-		defer wg.Done()
-		n, _ := io.Copy(remote, local)
-		atomic.AddInt64(&lf.stats.BytesSent, n)
-		lf.updateActivity()
-	}()
+	interval := lf.spec.Balance.HealthCheckInterval
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	// Remote -> Local
-	go func() {
-		defer wg.Done()
-		n, _ := io.Copy(local, remote)
-		atomic.AddInt64(&lf.stats.BytesReceived, n)
-		lf.updateActivity()
-	}()
+	for {
+		select {
+		case <-lf.stopCh:
+			return
+		case <-lf.ctx.Done():
+			return
+		case <-ticker.C:
+		}
 
-	wg.Wait()
+		// A dead session says nothing about the targets behind it
+		if !lf.session.IsConnected() {
+			continue
+		}
+		lf.pool.check(func(addr string) error {
+			conn, err := lf.dialTarget(addr)
+			if err != nil {
+				return err
+			}
+			conn.Close()
+			return nil
+		})
+	}
 }
 
-// updateActivity updates the last activity timestamp
-func (lf *LocalForwarder) updateActivity() {
-	lf.mu.Lock()
-	lf.stats.LastActivity = time.Now()
-	lf.mu.Unlock()
+// TargetStatus reports the health and load of each pooled target, or nil
+// for a tunnel with a single destination
+func (lf *LocalForwarder) TargetStatus() []types.TargetStatus {
+	if lf.pool == nil {
+		return nil
+	}
+	return lf.pool.status()
 }
 
 // Stop stops the forwarder and waits for active connections to close
 func (lf *LocalForwarder) Stop() error {
+	_, err := lf.stop(false)
+	return err
+}
+
+// ForceStop stops the forwarder, closing active connections at once
+func (lf *LocalForwarder) ForceStop() (int, error) {
+	return lf.stop(true)
+}
+
+// stop closes the listener and drains the active connections, returning
+// how many were closed
+func (lf *LocalForwarder) stop(force bool) (int, error) {
+	var closed int
 	var err error
 	lf.stopOnce.Do(func() {
 		close(lf.stopCh)
@@ -244,42 +360,29 @@ func (lf *LocalForwarder) Stop() error {
 		if lf.listener != nil {
 			err = lf.listener.Close()
 			lf.listener = nil
+			lf.stats.add(-1, &lf.stats.openSockets)
 		}
 		lf.mu.Unlock()
 
 		// Wait for active connections to finish (with timeout)
-		done := make(chan struct{})
-		go func() {
-			lf.activeConns.Wait()
-			close(done)
-		}()
-
-		select {
-		case <-done:
-			// All connections closed gracefully
-		case <-time.After(10 * time.Second):
-			// Timeout waiting for connections
-			err = fmt.Errorf("timeout waiting for connections to close")
+		var drainErr error
+		closed, drainErr = lf.activeConns.drain(drainTimeout(lf.spec), force)
+		if drainErr != nil {
+			err = drainErr
 		}
 	})
 
-	return err
+	return closed, err
 }
 
 // Stats returns the current forwarder statistics
 func (lf *LocalForwarder) Stats() ForwarderStats {
-	lf.mu.RLock()
-	defer lf.mu.RUnlock()
+	return lf.stats.snapshot(lf.activity.Time())
+}
 
-	return ForwarderStats{
-		BytesSent:     atomic.LoadInt64(&lf.stats.BytesSent),
-		BytesReceived: atomic.LoadInt64(&lf.stats.BytesReceived),
-		Connections:   atomic.LoadInt64(&lf.stats.Connections),
-		ActiveConns:   atomic.LoadInt64(&lf.stats.ActiveConns),
-		Errors:        atomic.LoadInt64(&lf.stats.Errors),
-		StartedAt:     lf.stats.StartedAt,
-		LastActivity:  lf.stats.LastActivity,
-	}
+// Connections lists the active connections
+func (lf *LocalForwarder) Connections() []ConnectionInfo {
+	return lf.activeConns.connections()
 }
 
 // LocalAddr returns the local listening address
@@ -298,13 +401,15 @@ func (lf *LocalForwarder) LocalAddr() string {
 type RemoteForwarder struct {
 	spec     *types.TunnelSpec
 	session  SessionDialer
+	sockets  socketFactories
 	listener net.Listener
 
 	// Stats
-	stats ForwarderStats
+	stats    forwarderCounters
+	activity activityClock
 
 	// Connection tracking
-	activeConns sync.WaitGroup
+	activeConns connTracker
 	mu          sync.RWMutex
 
 	// Lifecycle
@@ -315,7 +420,7 @@ type RemoteForwarder struct {
 }
 
 // NewRemoteForwarder creates a new remote port forwarder
-func NewRemoteForwarder(ctx context.Context, spec *types.TunnelSpec, session SessionDialer) (*RemoteForwarder, error) {
+func NewRemoteForwarder(ctx context.Context, spec *types.TunnelSpec, session SessionDialer, opts ...ForwarderOption) (*RemoteForwarder, error) {
 	if spec.Type != types.TunnelTypeRemote {
 		return nil, fmt.Errorf("invalid tunnel type: expected remote, got %s", spec.Type)
 	}
@@ -333,13 +438,14 @@ func NewRemoteForwarder(ctx context.Context, spec *types.TunnelSpec, session Ses
 	rf := &RemoteForwarder{
 		spec:    spec,
 		session: session,
+		sockets: newSocketFactories(opts),
 		ctx:     fwdCtx,
 		cancel:  cancel,
 		stopCh:  make(chan struct{}),
 	}
 
-	rf.stats.StartedAt = time.Now()
-	rf.stats.LastActivity = time.Now()
+	rf.stats.startedAt = time.Now()
+	rf.activity.Touch()
 
 	return rf, nil
 }
@@ -382,6 +488,7 @@ func (rf *RemoteForwarder) Start() error {
 	}
 
 	rf.listener = listener
+	rf.stats.add(1, &rf.stats.openSockets)
 	rf.mu.Unlock()
 
 	// Accept connections in a goroutine
@@ -406,6 +513,10 @@ func (rf *RemoteForwarder) getSSHClient() interface{} {
 
 // acceptLoop accepts incoming connections from the remote side
 func (rf *RemoteForwarder) acceptLoop() {
+	rf.stats.add(1, &rf.stats.goroutines)
+	defer rf.stats.add(-1, &rf.stats.goroutines)
+
+	var backoff acceptBackoff
 	for {
 		// Check if we should stop before accepting
 		select {
@@ -434,73 +545,79 @@ func (rf *RemoteForwarder) acceptLoop() {
 			case <-rf.ctx.Done():
 				return
 			default:
-				// Error during accept
-				atomic.AddInt64(&rf.stats.Errors, 1)
-				continue
 			}
+
+			// Pause on errors rather than spin, and give up on a broken listener
+			rf.stats.add(1, &rf.stats.errors)
+			if backoff.failed(err) {
+				rf.sockets.reportListenerFailure(err)
+				return
+			}
+			if !backoff.pause(rf.stopCh, rf.ctx.Done()) {
+				return
+			}
+			continue
+		}
+		backoff.reset()
+		if !rf.sockets.admit(conn) {
+			rf.stats.add(1, &rf.stats.errors)
+			continue
 		}
 
 		// Handle connection in a new goroutine
-		rf.activeConns.Add(1)
-		go rf.handleConnection(conn)
+		tracked := rf.activeConns.add(conn)
+		go rf.handleConnection(conn, tracked)
 	}
 }
 
 // handleConnection handles a single forwarded connection
-func (rf *RemoteForwarder) handleConnection(remoteConn net.Conn) {
-	defer rf.activeConns.Done()
+func (rf *RemoteForwarder) handleConnection(remoteConn net.Conn, tracked *trackedConn) {
+	defer rf.activeConns.done(tracked)
 	defer remoteConn.Close()
 
-	atomic.AddInt64(&rf.stats.Connections, 1)
-	atomic.AddInt64(&rf.stats.ActiveConns, 1)
-	defer atomic.AddInt64(&rf.stats.ActiveConns, -1)
+	rf.stats.add(1, &rf.stats.connections, &rf.stats.activeConns)
+	defer rf.stats.add(-1, &rf.stats.activeConns)
+	rf.stats.add(1, &rf.stats.goroutines)
+	defer rf.stats.add(-1, &rf.stats.goroutines)
+	rf.stats.add(1, &rf.stats.openSockets)
+	defer rf.stats.add(-1, &rf.stats.openSockets)
 
 	// Dial local destination
-	localAddr := fmt.Sprintf("127.0.0.1:%d", rf.spec.LocalPort)
-	localConn, err := net.Dial("tcp", localAddr)
+	localAddr := localDialAddr(rf.spec)
+	localConn, err := dialWithRetry(rf.spec.TCP, rf.stopCh, &rf.stats, func() (net.Conn, error) {
+		return dialContext(rf.ctx, rf.sockets.dialer, "tcp", localAddr, dialTimeout(rf.spec.TCP))
+	})
 	if err != nil {
-		atomic.AddInt64(&rf.stats.Errors, 1)
+		rf.stats.failed(err)
 		return
 	}
 	defer localConn.Close()
+	tracked.hold(localConn)
+	rf.stats.add(1, &rf.stats.openSockets)
+	defer rf.stats.add(-1, &rf.stats.openSockets)
+
+	applyTCPOptions(localConn, rf.spec.TCP)
+	tracked.setTarget(localAddr)
 
 	// Bidirectional copy
-	rf.proxy(remoteConn, localConn)
+	proxyConns(remoteConn, localConn, &rf.stats, &rf.activity, rf.spec.TCP.IdleTimeout, tracked)
 }
 
-// proxy copies data bidirectionally between two connections
-func (rf *RemoteForwarder) proxy(remote, local net.Conn) {
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	// Remote -> Local
-	go func() {
-		defer wg.Done()
-		n, _ := io.Copy(local, remote)
-		atomic.AddInt64(&rf.stats.BytesReceived, n)
-		rf.updateActivity()
-	}()
-
-	// Local -> Remote
-	go func() {
-		defer wg.Done()
-		n, _ := io.Copy(remote, local)
-		atomic.AddInt64(&rf.stats.BytesSent, n)
-		rf.updateActivity()
-	}()
-
-	wg.Wait()
+// Stop stops the forwarder and waits for active connections to close
+func (rf *RemoteForwarder) Stop() error {
+	_, err := rf.stop(false)
+	return err
 }
 
-// updateActivity updates the last activity timestamp
-func (rf *RemoteForwarder) updateActivity() {
-	rf.mu.Lock()
-	rf.stats.LastActivity = time.Now()
-	rf.mu.Unlock()
+// ForceStop stops the forwarder, closing active connections at once
+func (rf *RemoteForwarder) ForceStop() (int, error) {
+	return rf.stop(true)
 }
 
-// Stop stops the forwarder and waits for active connections to close
-func (rf *RemoteForwarder) Stop() error {
+// stop closes the listener and drains the active connections, returning
+// how many were closed
+func (rf *RemoteForwarder) stop(force bool) (int, error) {
+	var closed int
 	var err error
 	rf.stopOnce.Do(func() {
 		close(rf.stopCh)
@@ -510,42 +627,29 @@ func (rf *RemoteForwarder) Stop() error {
 		if rf.listener != nil {
 			err = rf.listener.Close()
 			rf.listener = nil
+			rf.stats.add(-1, &rf.stats.openSockets)
 		}
 		rf.mu.Unlock()
 
 		// Wait for active connections to finish (with timeout)
-		done := make(chan struct{})
-		go func() {
-			rf.activeConns.Wait()
-			close(done)
-		}()
-
-		select {
-		case <-done:
-			// All connections closed gracefully
-		case <-time.After(10 * time.Second):
-			// Timeout waiting for connections
-			err = fmt.Errorf("timeout waiting for connections to close")
+		var drainErr error
+		closed, drainErr = rf.activeConns.drain(drainTimeout(rf.spec), force)
+		if drainErr != nil {
+			err = drainErr
 		}
 	})
 
-	return err
+	return closed, err
 }
 
 // Stats returns the current forwarder statistics
 func (rf *RemoteForwarder) Stats() ForwarderStats {
-	rf.mu.RLock()
-	defer rf.mu.RUnlock()
+	return rf.stats.snapshot(rf.activity.Time())
+}
 
-	return ForwarderStats{
-		BytesSent:     atomic.LoadInt64(&rf.stats.BytesSent),
-		BytesReceived: atomic.LoadInt64(&rf.stats.BytesReceived),
-		Connections:   atomic.LoadInt64(&rf.stats.Connections),
-		ActiveConns:   atomic.LoadInt64(&rf.stats.ActiveConns),
-		Errors:        atomic.LoadInt64(&rf.stats.Errors),
-		StartedAt:     rf.stats.StartedAt,
-		LastActivity:  rf.stats.LastActivity,
-	}
+// Connections lists the active connections
+func (rf *RemoteForwarder) Connections() []ConnectionInfo {
+	return rf.activeConns.connections()
 }
 
 // RemoteAddr returns the remote listening address
@@ -564,13 +668,15 @@ func (rf *RemoteForwarder) RemoteAddr() string {
 type DynamicForwarder struct {
 	spec     *types.TunnelSpec
 	session  SessionDialer
+	sockets  socketFactories
 	listener net.Listener
 
 	// Stats
-	stats ForwarderStats
+	stats    forwarderCounters
+	activity activityClock
 
 	// Connection tracking
-	activeConns sync.WaitGroup
+	activeConns connTracker
 	mu          sync.RWMutex
 
 	// Lifecycle
@@ -581,7 +687,7 @@ type DynamicForwarder struct {
 }
 
 // NewDynamicForwarder creates a new SOCKS5 dynamic forwarder
-func NewDynamicForwarder(ctx context.Context, spec *types.TunnelSpec, session SessionDialer) (*DynamicForwarder, error) {
+func NewDynamicForwarder(ctx context.Context, spec *types.TunnelSpec, session SessionDialer, opts ...ForwarderOption) (*DynamicForwarder, error) {
 	if spec.Type != types.TunnelTypeDynamic {
 		return nil, fmt.Errorf("invalid tunnel type: expected dynamic, got %s", spec.Type)
 	}
@@ -595,13 +701,14 @@ func NewDynamicForwarder(ctx context.Context, spec *types.TunnelSpec, session Se
 	df := &DynamicForwarder{
 		spec:    spec,
 		session: session,
+		sockets: newSocketFactories(opts),
 		ctx:     fwdCtx,
 		cancel:  cancel,
 		stopCh:  make(chan struct{}),
 	}
 
-	df.stats.StartedAt = time.Now()
-	df.stats.LastActivity = time.Now()
+	df.stats.startedAt = time.Now()
+	df.activity.Touch()
 
 	return df, nil
 }
@@ -614,21 +721,16 @@ func (df *DynamicForwarder) Start() error {
 		return fmt.Errorf("forwarder already started")
 	}
 
-	// Determine bind address (default to 0.0.0.0 to allow external access)
-	bindAddr := df.spec.LocalBindAddress
-	if bindAddr == "" {
-		bindAddr = "0.0.0.0"
-	}
-
-	// Bind to local port
-	addr := fmt.Sprintf("%s:%d", bindAddr, df.spec.LocalPort)
-	listener, err := net.Listen("tcp", addr)
+	// Bind to all interfaces unless the spec names an address, allowing
+	// external access
+	listener, addr, err := df.sockets.listen(df.ctx, df.spec, false)
 	if err != nil {
 		df.mu.Unlock()
 		return fmt.Errorf("failed to bind to %s: %w", addr, err)
 	}
 
 	df.listener = listener
+	df.stats.add(1, &df.stats.openSockets)
 
 	// Update spec with actual bound port if ephemeral was used
 	if df.spec.LocalPort == 0 {
@@ -647,6 +749,10 @@ func (df *DynamicForwarder) Start() error {
 
 // acceptLoop accepts incoming SOCKS5 connections
 func (df *DynamicForwarder) acceptLoop() {
+	df.stats.add(1, &df.stats.goroutines)
+	defer df.stats.add(-1, &df.stats.goroutines)
+
+	var backoff acceptBackoff
 	for {
 		// Check if we should stop before accepting
 		select {
@@ -675,58 +781,92 @@ func (df *DynamicForwarder) acceptLoop() {
 			case <-df.ctx.Done():
 				return
 			default:
-				// Error during accept
-				atomic.AddInt64(&df.stats.Errors, 1)
-				continue
 			}
+
+			// Pause on errors rather than spin, and give up on a broken listener
+			df.stats.add(1, &df.stats.errors)
+			if backoff.failed(err) {
+				df.sockets.reportListenerFailure(err)
+				return
+			}
+			if !backoff.pause(df.stopCh, df.ctx.Done()) {
+				return
+			}
+			continue
+		}
+		backoff.reset()
+		if !df.sockets.admit(conn) {
+			df.stats.add(1, &df.stats.errors)
+			continue
 		}
 
 		// Handle SOCKS5 connection in a new goroutine
-		df.activeConns.Add(1)
-		go df.handleSOCKS5(conn)
+		tracked := df.activeConns.add(conn)
+		go df.handleSOCKS5(conn, tracked)
 	}
 }
 
 // handleSOCKS5 handles a single SOCKS5 connection
-func (df *DynamicForwarder) handleSOCKS5(clientConn net.Conn) {
-	defer df.activeConns.Done()
+func (df *DynamicForwarder) handleSOCKS5(clientConn net.Conn, tracked *trackedConn) {
+	defer df.activeConns.done(tracked)
 	defer clientConn.Close()
 
-	atomic.AddInt64(&df.stats.Connections, 1)
-	atomic.AddInt64(&df.stats.ActiveConns, 1)
-	defer atomic.AddInt64(&df.stats.ActiveConns, -1)
+	df.stats.add(1, &df.stats.connections, &df.stats.activeConns)
+	defer df.stats.add(-1, &df.stats.activeConns)
+	df.stats.add(1, &df.stats.goroutines)
+	defer df.stats.add(-1, &df.stats.goroutines)
+	df.stats.add(1, &df.stats.openSockets)
+	defer df.stats.add(-1, &df.stats.openSockets)
 
 	// Check if session is connected
 	if !df.session.IsConnected() {
-		atomic.AddInt64(&df.stats.Errors, 1)
+		df.stats.add(1, &df.stats.errors)
 		return
 	}
 
-	// Perform SOCKS5 handshake
+	// Perform SOCKS5 handshake; a client that stalls mid-handshake is dropped
+	clientConn.SetDeadline(time.Now().Add(dialTimeout(df.spec.TCP)))
 	destAddr, err := df.socks5Handshake(clientConn)
 	if err != nil {
-		atomic.AddInt64(&df.stats.Errors, 1)
+		df.stats.failed(err)
+		return
+	}
+	if df.sockets.failSOCKS() {
+		df.stats.failed(fmt.Errorf("SOCKS handshake refused: %w", ErrInjectedFault))
+		df.socks5Error(clientConn, 0x01) // General failure
 		return
 	}
 
+	// The handshake deadline must not cut the client off while dials are retried
+	clientConn.SetDeadline(time.Time{})
+
+	applyTCPOptions(clientConn, df.spec.TCP)
+
 	// Dial destination through SSH tunnel
-	remoteConn, err := df.session.Dial("tcp", destAddr)
+	remoteConn, err := dialWithRetry(df.spec.TCP, df.stopCh, &df.stats, func() (net.Conn, error) {
+		return dialContext(df.ctx, df.session, "tcp", destAddr, dialTimeout(df.spec.TCP))
+	})
 	if err != nil {
-		atomic.AddInt64(&df.stats.Errors, 1)
+		df.stats.failed(err)
 		// Send SOCKS5 error response
 		df.socks5Error(clientConn, 0x04) // Host unreachable
 		return
 	}
 	defer remoteConn.Close()
+	tracked.hold(remoteConn)
+	df.stats.add(1, &df.stats.openSockets)
+	defer df.stats.add(-1, &df.stats.openSockets)
 
 	// Send SOCKS5 success response
 	if err := df.socks5Success(clientConn); err != nil {
-		atomic.AddInt64(&df.stats.Errors, 1)
+		df.stats.add(1, &df.stats.errors)
 		return
 	}
 
+	tracked.setTarget(destAddr)
+
 	// Bidirectional copy
-	df.proxy(clientConn, remoteConn)
+	proxyConns(clientConn, remoteConn, &df.stats, &df.activity, df.spec.TCP.IdleTimeout, tracked)
 }
 
 // socks5Handshake performs the SOCKS5 handshake and returns the destination address
@@ -801,7 +941,7 @@ func (df *DynamicForwarder) socks5Handshake(conn net.Conn) (string, error) {
 		}
 		ip := net.IP(buf[4:8])
 		port := int(buf[8])<<8 | int(buf[9])
-		destAddr = fmt.Sprintf("%s:%d", ip.String(), port)
+		destAddr = net.JoinHostPort(ip.String(), strconv.Itoa(port))
 
 	case 0x03: // Domain name
 		if n < 5 {
@@ -819,7 +959,7 @@ func (df *DynamicForwarder) socks5Handshake(conn net.Conn) (string, error) {
 		}
 		domain := string(buf[5 : 5+domainLen])
 		port := int(buf[5+domainLen])<<8 | int(buf[5+domainLen+1])
-		destAddr = fmt.Sprintf("%s:%d", domain, port)
+		destAddr = net.JoinHostPort(domain, strconv.Itoa(port))
 
 	case 0x04: // IPv6
 		if n < 22 {
@@ -830,7 +970,7 @@ func (df *DynamicForwarder) socks5Handshake(conn net.Conn) (string, error) {
 		}
 		ip := net.IP(buf[4:20])
 		port := int(buf[20])<<8 | int(buf[21])
-		destAddr = fmt.Sprintf("[%s]:%d", ip.String(), port)
+		destAddr = net.JoinHostPort(ip.String(), strconv.Itoa(port))
 
 	default:
 		df.socks5Error(conn, 0x08) // Address type not supported
@@ -873,39 +1013,21 @@ func (df *DynamicForwarder) socks5Error(conn net.Conn, rep byte) error {
 	return err
 }
 
-// proxy copies data bidirectionally between two connections
-func (df *DynamicForwarder) proxy(client, remote net.Conn) {
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	// Client -> Remote
-	go func() {
-		defer wg.Done()
-		n, _ := io.Copy(remote, client)
-		atomic.AddInt64(&df.stats.BytesSent, n)
-		df.updateActivity()
-	}()
-
-	// Remote -> Client
-	go func() {
-		defer wg.Done()
-		n, _ := io.Copy(client, remote)
-		atomic.AddInt64(&df.stats.BytesReceived, n)
-		df.updateActivity()
-	}()
-
-	wg.Wait()
+// Stop stops the forwarder and waits for active connections to close
+func (df *DynamicForwarder) Stop() error {
+	_, err := df.stop(false)
+	return err
 }
 
-// updateActivity updates the last activity timestamp
-func (df *DynamicForwarder) updateActivity() {
-	df.mu.Lock()
-	df.stats.LastActivity = time.Now()
-	df.mu.Unlock()
+// ForceStop stops the forwarder, closing active connections at once
+func (df *DynamicForwarder) ForceStop() (int, error) {
+	return df.stop(true)
 }
 
-// Stop stops the forwarder and waits for active connections to close
-func (df *DynamicForwarder) Stop() error {
+// stop closes the listener and drains the active connections, returning
+// how many were closed
+func (df *DynamicForwarder) stop(force bool) (int, error) {
+	var closed int
 	var err error
 	df.stopOnce.Do(func() {
 		close(df.stopCh)
@@ -915,42 +1037,29 @@ func (df *DynamicForwarder) Stop() error {
 		if df.listener != nil {
 			err = df.listener.Close()
 			df.listener = nil
+			df.stats.add(-1, &df.stats.openSockets)
 		}
 		df.mu.Unlock()
 
 		// Wait for active connections to finish (with timeout)
-		done := make(chan struct{})
-		go func() {
-			df.activeConns.Wait()
-			close(done)
-		}()
-
-		select {
-		case <-done:
-			// All connections closed gracefully
-		case <-time.After(10 * time.Second):
-			// Timeout waiting for connections
-			err = fmt.Errorf("timeout waiting for connections to close")
+		var drainErr error
+		closed, drainErr = df.activeConns.drain(drainTimeout(df.spec), force)
+		if drainErr != nil {
+			err = drainErr
 		}
 	})
 
-	return err
+	return closed, err
 }
 
 // Stats returns the current forwarder statistics
 func (df *DynamicForwarder) Stats() ForwarderStats {
-	df.mu.RLock()
-	defer df.mu.RUnlock()
+	return df.stats.snapshot(df.activity.Time())
+}
 
-	return ForwarderStats{
-		BytesSent:     atomic.LoadInt64(&df.stats.BytesSent),
-		BytesReceived: atomic.LoadInt64(&df.stats.BytesReceived),
-		Connections:   atomic.LoadInt64(&df.stats.Connections),
-		ActiveConns:   atomic.LoadInt64(&df.stats.ActiveConns),
-		Errors:        atomic.LoadInt64(&df.stats.Errors),
-		StartedAt:     df.stats.StartedAt,
-		LastActivity:  df.stats.LastActivity,
-	}
+// Connections lists the active connections
+func (df *DynamicForwarder) Connections() []ConnectionInfo {
+	return df.activeConns.connections()
 }
 
 // LocalAddr returns the local listening address
@@ -24,6 +24,32 @@ func (m *MockSessionDialer) Dial(network, address string) (net.Conn, error) {
 	return nil, fmt.Errorf("no dial function configured")
 }
 
+// DialContext races Dial against ctx, closing a connection that completes
+// too late, like ssh.Client.DialContext
+func (m *MockSessionDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	resultCh := make(chan dialResult)
+	go func() {
+		conn, err := m.Dial(network, address)
+		select {
+		case resultCh <- dialResult{conn, err}:
+		case <-ctx.Done():
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}()
+	select {
+	case result := <-resultCh:
+		return result.conn, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 func (m *MockSessionDialer) IsConnected() bool {
 	return m.connected
 }
@@ -458,3 +484,77 @@ func TestDynamicForwarderStartStop(t *testing.T) {
 		t.Error("Expected local address to be empty after stop")
 	}
 }
+
+func TestLocalForwarderResourceFootprint(t *testing.T) {
+	ctx := context.Background()
+
+	// Pipes are not TCP, so connections use pooled copy buffers
+	farConns := make(chan net.Conn, 1)
+	mockSession := &MockSessionDialer{
+		connected: true,
+		dialFunc: func(network, address string) (net.Conn, error) {
+			near, far := net.Pipe()
+			farConns <- far
+			return near, nil
+		},
+	}
+
+	spec := &types.TunnelSpec{
+		ID:         "test-footprint",
+		Type:       types.TunnelTypeLocal,
+		LocalPort:  0,
+		RemoteHost: "example.com",
+		RemotePort: 80,
+	}
+
+	forwarder, err := NewLocalForwarder(ctx, spec, mockSession)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %v", err)
+	}
+	if err := forwarder.Start(); err != nil {
+		t.Fatalf("Failed to start forwarder: %v", err)
+	}
+	defer forwarder.Stop()
+
+	waitForStats := func(desc string, ok func(ForwarderStats) bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !ok(forwarder.Stats()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s, stats: %+v", desc, forwarder.Stats())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Idle: the accept loop and the listener
+	waitForStats("idle footprint", func(s ForwarderStats) bool {
+		return s.Goroutines == 1 && s.OpenSockets == 1 && s.BufferBytes == 0
+	})
+
+	conn, err := net.Dial("tcp", forwarder.LocalAddr())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarder: %v", err)
+	}
+	far := <-farConns
+
+	// One connection adds a handler, two copy goroutines, both ends and a
+	// buffer per direction
+	waitForStats("connection footprint", func(s ForwarderStats) bool {
+		return s.Goroutines == 4 && s.OpenSockets == 3 && s.BufferBytes == 2*copyBufferSize
+	})
+
+	conn.Close()
+	far.Close()
+
+	waitForStats("footprint released", func(s ForwarderStats) bool {
+		return s.Goroutines == 1 && s.OpenSockets == 1 && s.BufferBytes == 0
+	})
+
+	if err := forwarder.Stop(); err != nil {
+		t.Fatalf("Failed to stop forwarder: %v", err)
+	}
+	waitForStats("stopped footprint", func(s ForwarderStats) bool {
+		return s.Goroutines == 0 && s.OpenSockets == 0 && s.BufferBytes == 0
+	})
+}
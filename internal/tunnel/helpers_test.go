@@ -0,0 +1,26 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// testAgentID is the agent test tunnels are delegated to: the manager only
+// records them, so nothing connects
+const testAgentID = "edge-1"
+
+// createTunnels creates tunnels, delegated to testAgentID unless they name
+// an agent
+func createTunnels(t *testing.T, m *Manager, specs ...*types.TunnelSpec) {
+	t.Helper()
+	for _, spec := range specs {
+		if spec.AgentID == "" {
+			spec.AgentID = testAgentID
+		}
+		if err := m.Create(context.Background(), spec); err != nil {
+			t.Fatalf("Create(%s) failed: %v", spec.ID, err)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+const (
+	// DefaultHistoryInterval is how often tunnel counters are sampled
+	DefaultHistoryInterval = 10 * time.Second
+
+	// DefaultHistoryRetention is how long samples are kept
+	DefaultHistoryRetention = 24 * time.Hour
+
+	// DefaultSnapshotInterval is how often samples are saved to storage
+	DefaultSnapshotInterval = time.Minute
+
+	// DefaultSnapshotRetention is how long saved samples are kept
+	DefaultSnapshotRetention = 30 * 24 * time.Hour
+
+	// snapshotPruneInterval is how often saved samples past their retention
+	// are deleted
+	snapshotPruneInterval = time.Hour
+)
+
+// SnapshotStore is optionally implemented by storage that can keep tunnel
+// metrics snapshots, so history outlives the process and its memory
+// retention
+type SnapshotStore interface {
+	SaveSnapshots(ctx context.Context, snapshots []*types.MetricsSnapshot) error
+	// ListSnapshots returns a tunnel's snapshots taken in [since, until),
+	// oldest first
+	ListSnapshots(ctx context.Context, tunnelID string, since, until time.Time) ([]*types.MetricsSnapshot, error)
+	// PruneSnapshots deletes the snapshots taken before a time and returns
+	// how many it deleted
+	PruneSnapshots(ctx context.Context, before time.Time) (int64, error)
+}
+
+// SnapshotPersistence configures saving a recorder's samples to storage
+type SnapshotPersistence struct {
+	Store     SnapshotStore
+	Interval  time.Duration   // between saved samples; 0 = DefaultSnapshotInterval
+	Retention time.Duration   // 0 = DefaultSnapshotRetention
+	OnError   func(err error) // told about failed saves and prunes, if set
+}
+
+// MetricsSample is a point-in-time snapshot of a tunnel's traffic counters.
+// Counters are cumulative since the forwarder started; rates are computed
+// against the previous sample returned by a query.
+type MetricsSample struct {
+	Timestamp         time.Time         `json:"timestamp"`
+	BytesSent         int64             `json:"bytesSent"`
+	BytesReceived     int64             `json:"bytesReceived"`
+	Connections       int64             `json:"connections"`
+	ActiveConnections int64             `json:"activeConnections"`
+	Errors            int64             `json:"errors"`
+	State             types.TunnelState `json:"state,omitempty"`
+	LatencyMs         float64           `json:"latencyMs"`
+	SentRate          float64           `json:"sentRate"`     // bytes/second
+	ReceivedRate      float64           `json:"receivedRate"` // bytes/second
+}
+
+// sampleRing is a fixed-capacity ring buffer of samples, oldest first
+type sampleRing struct {
+	samples []MetricsSample
+	start   int
+	count   int
+}
+
+// add appends a sample, overwriting the oldest when full
+func (r *sampleRing) add(sample MetricsSample) {
+	capacity := len(r.samples)
+	if r.count < capacity {
+		r.samples[(r.start+r.count)%capacity] = sample
+		r.count++
+		return
+	}
+	r.samples[r.start] = sample
+	r.start = (r.start + 1) % capacity
+}
+
+// at returns the i-th oldest sample
+func (r *sampleRing) at(i int) MetricsSample {
+	return r.samples[(r.start+i)%len(r.samples)]
+}
+
+// HistoryRecorder periodically samples every tunnel's forwarder stats into
+// retention-limited in-memory time series, and optionally saves some of the
+// samples to storage for queries reaching further back
+type HistoryRecorder struct {
+	manager   *Manager
+	interval  time.Duration
+	retention time.Duration
+	persist   SnapshotPersistence // Store is nil when not persisting
+
+	lastSaved  time.Time
+	lastPruned time.Time
+
+	mu     sync.RWMutex
+	series map[string]*sampleRing
+}
+
+// NewHistoryRecorder creates a recorder for the manager's tunnels.
+// Zero interval or retention use the defaults.
+func NewHistoryRecorder(manager *Manager, interval, retention time.Duration) *HistoryRecorder {
+	if interval <= 0 {
+		interval = DefaultHistoryInterval
+	}
+	if retention <= 0 {
+		retention = DefaultHistoryRetention
+	}
+	if retention < interval {
+		retention = interval
+	}
+
+	return &HistoryRecorder{
+		manager:   manager,
+		interval:  interval,
+		retention: retention,
+		series:    make(map[string]*sampleRing),
+	}
+}
+
+// Interval returns the sampling interval
+func (h *HistoryRecorder) Interval() time.Duration {
+	return h.interval
+}
+
+// Retention returns how far back samples can be queried: the memory
+// retention, or the storage retention when longer
+func (h *HistoryRecorder) Retention() time.Duration {
+	if h.persist.Store != nil && h.persist.Retention > h.retention {
+		return h.persist.Retention
+	}
+	return h.retention
+}
+
+// Persist makes the recorder save a sample of every tunnel each
+// persistence interval, and delete saved samples past their retention.
+// Must be called before Run.
+func (h *HistoryRecorder) Persist(persistence SnapshotPersistence) {
+	if persistence.Interval <= 0 {
+		persistence.Interval = DefaultSnapshotInterval
+	}
+	if persistence.Interval < h.interval {
+		persistence.Interval = h.interval
+	}
+	if persistence.Retention <= 0 {
+		persistence.Retention = DefaultSnapshotRetention
+	}
+	h.persist = persistence
+}
+
+// Run samples tunnels every interval until ctx is cancelled
+func (h *HistoryRecorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			samples := h.sample(now)
+			if h.persist.Store != nil {
+				h.save(ctx, now, samples)
+				h.prune(ctx, now)
+			}
+		}
+	}
+}
+
+// sample records the current counters of every tunnel, returning them by
+// tunnel ID, and drops series of tunnels that no longer exist
+func (h *HistoryRecorder) sample(now time.Time) map[string]MetricsSample {
+	tunnels := h.manager.List()
+
+	samples := make(map[string]MetricsSample, len(tunnels))
+	for _, t := range tunnels {
+		stats := t.Stats()
+		sample := MetricsSample{
+			Timestamp:         now,
+			BytesSent:         stats.BytesSent,
+			BytesReceived:     stats.BytesReceived,
+			Connections:       stats.Connections,
+			ActiveConnections: stats.ActiveConns,
+			Errors:            stats.Errors,
+		}
+		if status := t.GetStatus(); status != nil {
+			sample.State = status.State
+			sample.LatencyMs = float64(status.Latency) / float64(time.Millisecond)
+		}
+		samples[t.Spec.ID] = sample
+		h.record(t.Spec.ID, sample)
+	}
+
+	h.mu.Lock()
+	for id := range h.series {
+		if _, ok := samples[id]; !ok {
+			delete(h.series, id)
+		}
+	}
+	h.mu.Unlock()
+	return samples
+}
+
+// save writes samples to storage once the persistence interval has passed
+// since the last save
+func (h *HistoryRecorder) save(ctx context.Context, now time.Time, samples map[string]MetricsSample) {
+	if now.Sub(h.lastSaved) < h.persist.Interval || len(samples) == 0 {
+		return
+	}
+	h.lastSaved = now
+
+	snapshots := make([]*types.MetricsSnapshot, 0, len(samples))
+	for id, sample := range samples {
+		snapshots = append(snapshots, sampleSnapshot(id, sample))
+	}
+	if err := h.persist.Store.SaveSnapshots(ctx, snapshots); err != nil {
+		h.reportError(err)
+	}
+}
+
+// prune deletes saved samples past their retention, at most once per
+// snapshotPruneInterval
+func (h *HistoryRecorder) prune(ctx context.Context, now time.Time) {
+	if now.Sub(h.lastPruned) < snapshotPruneInterval {
+		return
+	}
+	h.lastPruned = now
+	if _, err := h.persist.Store.PruneSnapshots(ctx, now.Add(-h.persist.Retention)); err != nil {
+		h.reportError(err)
+	}
+}
+
+// reportError passes on a storage error, except for shutting down
+func (h *HistoryRecorder) reportError(err error) {
+	if h.persist.OnError != nil && !errors.Is(err, context.Canceled) {
+		h.persist.OnError(err)
+	}
+}
+
+// sampleSnapshot converts a tunnel's sample to the form it is saved in
+func sampleSnapshot(tunnelID string, sample MetricsSample) *types.MetricsSnapshot {
+	return &types.MetricsSnapshot{
+		TunnelID:      tunnelID,
+		Timestamp:     sample.Timestamp,
+		State:         sample.State,
+		BytesSent:     sample.BytesSent,
+		BytesReceived: sample.BytesReceived,
+		Connections:   sample.Connections,
+		ActiveConns:   sample.ActiveConnections,
+		Errors:        sample.Errors,
+		Latency:       time.Duration(sample.LatencyMs * float64(time.Millisecond)),
+	}
+}
+
+// snapshotSample converts a saved snapshot back to a sample
+func snapshotSample(snapshot *types.MetricsSnapshot) MetricsSample {
+	return MetricsSample{
+		Timestamp:         snapshot.Timestamp,
+		BytesSent:         snapshot.BytesSent,
+		BytesReceived:     snapshot.BytesReceived,
+		Connections:       snapshot.Connections,
+		ActiveConnections: snapshot.ActiveConns,
+		Errors:            snapshot.Errors,
+		State:             snapshot.State,
+		LatencyMs:         float64(snapshot.Latency) / float64(time.Millisecond),
+	}
+}
+
+// record appends a sample to a tunnel's series
+func (h *HistoryRecorder) record(tunnelID string, sample MetricsSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ring, ok := h.series[tunnelID]
+	if !ok {
+		ring = &sampleRing{samples: make([]MetricsSample, int(h.retention/h.interval)+1)}
+		h.series[tunnelID] = ring
+	}
+	ring.add(sample)
+}
+
+// Query returns a tunnel's samples newer than since, downsampled to one
+// sample (the latest) per step-sized bucket. Samples older than those still
+// in memory are read from storage when persisting.
+func (h *HistoryRecorder) Query(ctx context.Context, tunnelID string, since time.Time, step time.Duration) ([]MetricsSample, error) {
+	if step < h.interval {
+		step = h.interval
+	}
+
+	samples, err := h.Range(ctx, tunnelID, since, time.Now().Add(h.interval))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []MetricsSample{}
+	var bucket int64 = -1
+
+	for _, sample := range samples {
+		// Counters are cumulative, so the last sample in a bucket represents it
+		b := sample.Timestamp.Sub(since).Nanoseconds() / step.Nanoseconds()
+		if b == bucket && len(result) > 0 {
+			result[len(result)-1] = sample
+			continue
+		}
+		bucket = b
+		result = append(result, sample)
+	}
+
+	// Rates between consecutive points; counters reset when a tunnel restarts
+	for i := 1; i < len(result); i++ {
+		prev, cur := result[i-1], result[i]
+		elapsed := cur.Timestamp.Sub(prev.Timestamp).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		if cur.BytesSent >= prev.BytesSent {
+			result[i].SentRate = float64(cur.BytesSent-prev.BytesSent) / elapsed
+		}
+		if cur.BytesReceived >= prev.BytesReceived {
+			result[i].ReceivedRate = float64(cur.BytesReceived-prev.BytesReceived) / elapsed
+		}
+	}
+
+	return result, nil
+}
+
+// Range returns a tunnel's samples taken in [since, until), oldest first,
+// without downsampling. Samples older than those still in memory are read
+// from storage when persisting.
+func (h *HistoryRecorder) Range(ctx context.Context, tunnelID string, since, until time.Time) ([]MetricsSample, error) {
+	recent := h.recent(tunnelID, since, until)
+
+	var samples []MetricsSample
+	if h.persist.Store != nil {
+		// Storage covers what memory doesn't, e.g. from before a restart
+		stored := until
+		if len(recent) > 0 && recent[0].Timestamp.Before(stored) {
+			stored = recent[0].Timestamp
+		}
+		if since.Before(stored) {
+			snapshots, err := h.persist.Store.ListSnapshots(ctx, tunnelID, since, stored)
+			if err != nil {
+				return nil, err
+			}
+			for _, snapshot := range snapshots {
+				samples = append(samples, snapshotSample(snapshot))
+			}
+		}
+	}
+	return append(samples, recent...), nil
+}
+
+// recent returns a tunnel's in-memory samples taken in [since, until),
+// oldest first
+func (h *HistoryRecorder) recent(tunnelID string, since, until time.Time) []MetricsSample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ring, ok := h.series[tunnelID]
+	if !ok {
+		return nil
+	}
+	var samples []MetricsSample
+	for i := 0; i < ring.count; i++ {
+		if sample := ring.at(i); !sample.Timestamp.Before(since) && sample.Timestamp.Before(until) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
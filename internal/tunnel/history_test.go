@@ -0,0 +1,187 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func mustQuery(t *testing.T, h *HistoryRecorder, tunnelID string, since time.Time, step time.Duration) []MetricsSample {
+	t.Helper()
+	samples, err := h.Query(context.Background(), tunnelID, since, step)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	return samples
+}
+
+func TestHistoryRecorderRetention(t *testing.T) {
+	h := NewHistoryRecorder(NewManager(context.Background()), time.Second, 5*time.Second)
+
+	base := time.Now().Add(-time.Minute)
+	for i := 0; i < 20; i++ {
+		h.record("t1", MetricsSample{
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+			BytesSent: int64(i * 100),
+		})
+	}
+
+	samples := mustQuery(t, h, "t1", base, time.Second)
+	if len(samples) != 6 {
+		t.Fatalf("Expected ring to keep 6 samples, got %d", len(samples))
+	}
+	if samples[0].BytesSent != 1400 || samples[5].BytesSent != 1900 {
+		t.Errorf("Expected oldest-first samples 1400..1900, got %d..%d",
+			samples[0].BytesSent, samples[5].BytesSent)
+	}
+	if samples[1].SentRate != 100 {
+		t.Errorf("Expected sent rate 100 B/s, got %v", samples[1].SentRate)
+	}
+
+	if got := mustQuery(t, h, "unknown", base, time.Second); len(got) != 0 {
+		t.Errorf("Expected no samples for unknown tunnel, got %d", len(got))
+	}
+}
+
+func TestHistoryRecorderDownsample(t *testing.T) {
+	h := NewHistoryRecorder(NewManager(context.Background()), time.Second, time.Hour)
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	for i := 0; i < 60; i++ {
+		h.record("t1", MetricsSample{
+			Timestamp:     base.Add(time.Duration(i) * time.Second),
+			BytesReceived: int64(i),
+		})
+	}
+
+	samples := mustQuery(t, h, "t1", base, 10*time.Second)
+	if len(samples) != 6 {
+		t.Fatalf("Expected 6 buckets, got %d", len(samples))
+	}
+
+	// Each bucket is represented by its latest sample
+	for i, sample := range samples {
+		if want := int64(i*10 + 9); sample.BytesReceived != want {
+			t.Errorf("Bucket %d: expected BytesReceived %d, got %d", i, want, sample.BytesReceived)
+		}
+	}
+
+	// Samples before the range are excluded
+	if got := mustQuery(t, h, "t1", base.Add(30*time.Second), 10*time.Second); len(got) != 3 {
+		t.Errorf("Expected 3 buckets in the last 30s, got %d", len(got))
+	}
+}
+
+func TestHistoryRecorderDropsDeletedTunnels(t *testing.T) {
+	h := NewHistoryRecorder(NewManager(context.Background()), time.Second, time.Minute)
+	h.record("gone", MetricsSample{Timestamp: time.Now()})
+
+	h.sample(time.Now())
+
+	if got := mustQuery(t, h, "gone", time.Time{}, time.Second); len(got) != 0 {
+		t.Errorf("Expected series for deleted tunnel to be dropped, got %d samples", len(got))
+	}
+}
+
+// memorySnapshotStore is a SnapshotStore keeping snapshots in a slice
+type memorySnapshotStore struct {
+	mu        sync.Mutex
+	snapshots []*types.MetricsSnapshot
+	err       error
+}
+
+func (m *memorySnapshotStore) SaveSnapshots(ctx context.Context, snapshots []*types.MetricsSnapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots = append(m.snapshots, snapshots...)
+	return nil
+}
+
+func (m *memorySnapshotStore) ListSnapshots(ctx context.Context, tunnelID string, since, until time.Time) ([]*types.MetricsSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	var result []*types.MetricsSnapshot
+	for _, snap := range m.snapshots {
+		if snap.TunnelID == tunnelID && !snap.Timestamp.Before(since) && snap.Timestamp.Before(until) {
+			result = append(result, snap)
+		}
+	}
+	return result, nil
+}
+
+func (m *memorySnapshotStore) PruneSnapshots(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.snapshots[:0]
+	for _, snap := range m.snapshots {
+		if !snap.Timestamp.Before(before) {
+			kept = append(kept, snap)
+		}
+	}
+	pruned := int64(len(m.snapshots) - len(kept))
+	m.snapshots = kept
+	return pruned, nil
+}
+
+func TestHistoryRecorderPersists(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(ctx)
+	createTunnels(t, m, &types.TunnelSpec{ID: "t1", Name: "db", Type: types.TunnelTypeLocal})
+	store := &memorySnapshotStore{}
+	h := NewHistoryRecorder(m, time.Second, time.Minute)
+	h.Persist(SnapshotPersistence{Store: store, Interval: 10 * time.Second, Retention: 2 * time.Hour})
+
+	if h.Retention() != 2*time.Hour {
+		t.Errorf("Expected queries to reach back as far as storage, got %v", h.Retention())
+	}
+
+	// Samples are saved once per persistence interval
+	base := time.Now().Add(-time.Minute)
+	for i := 0; i < 30; i++ {
+		now := base.Add(time.Duration(i) * time.Second)
+		h.save(ctx, now, h.sample(now))
+	}
+	if len(store.snapshots) != 3 {
+		t.Fatalf("Expected 3 saved snapshots, got %d", len(store.snapshots))
+	}
+	if snap := store.snapshots[0]; snap.TunnelID != "t1" || snap.State == "" {
+		t.Errorf("Unexpected snapshot %+v", snap)
+	}
+
+	// Samples older than those in memory come from storage, e.g. after a restart
+	store.snapshots = append([]*types.MetricsSnapshot{
+		{TunnelID: "t1", Timestamp: base.Add(-time.Hour), BytesSent: 100},
+		{TunnelID: "t1", Timestamp: base.Add(-30 * time.Minute), BytesSent: 1900},
+	}, store.snapshots...)
+	samples := mustQuery(t, h, "t1", base.Add(-90*time.Minute), time.Second)
+	if len(samples) != 32 {
+		t.Fatalf("Expected 2 stored and 30 in-memory samples, got %d", len(samples))
+	}
+	if samples[0].BytesSent != 100 || samples[1].SentRate != 1 {
+		t.Errorf("Expected stored samples first with rates between them, got %+v", samples[:2])
+	}
+
+	// Restarted: only storage has the tunnel's history
+	restarted := NewHistoryRecorder(m, time.Second, time.Minute)
+	restarted.Persist(SnapshotPersistence{Store: store})
+	if got := mustQuery(t, restarted, "t1", base.Add(-90*time.Minute), time.Second); len(got) != 5 {
+		t.Errorf("Expected 5 stored samples after a restart, got %d", len(got))
+	}
+
+	h.prune(ctx, base.Add(2*time.Hour))
+	if len(store.snapshots) != 3 {
+		t.Errorf("Expected snapshots past retention to be pruned, %d left", len(store.snapshots))
+	}
+
+	store.err = errors.New("disk I/O error")
+	if _, err := h.Query(ctx, "t1", base.Add(-90*time.Minute), time.Second); err == nil {
+		t.Error("Expected a storage error to be returned")
+	}
+}
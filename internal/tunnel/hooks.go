@@ -0,0 +1,234 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+const (
+	// DefaultHookTimeout bounds a hook without a timeout of its own
+	DefaultHookTimeout = 30 * time.Second
+
+	// maxHookOutput is how much of a failed command's output is reported
+	maxHookOutput = 1024
+)
+
+// HookPayload is the context a hook gets: POSTed as JSON to webhooks, and
+// in LAZYTUNNEL_* environment variables to commands
+type HookPayload struct {
+	Event        types.HookEvent     `json:"event"`
+	TunnelID     string              `json:"tunnelId"`
+	Name         string              `json:"name"`
+	State        types.TunnelState   `json:"state"`
+	LocalPort    int                 `json:"localPort"` // the bound port, if the tunnel is forwarding
+	BoundAddress string              `json:"boundAddress,omitempty"`
+	Error        string              `json:"error,omitempty"`
+	Reason       types.FailureReason `json:"reason,omitempty"` // category of Error
+	Timestamp    time.Time           `json:"timestamp"`
+}
+
+// HookReporter is told how each hook run went; err is nil on success
+type HookReporter func(payload HookPayload, hook types.Hook, err error)
+
+// hookClient calls webhooks; each call is bounded by its hook's timeout
+var hookClient = &http.Client{}
+
+// hookQueue runs a tunnel's hooks one at a time, in the order their events
+// happened, so e.g. a disconnect script never overtakes the connect script
+type hookQueue struct {
+	pending []func()
+	running bool
+}
+
+// SetHookReporter sets the function told about each hook run, replacing
+// any set previously
+func (m *Manager) SetHookReporter(reporter HookReporter) {
+	m.hookMu.Lock()
+	defer m.hookMu.Unlock()
+	m.hookReporter = reporter
+}
+
+// hookEvents returns the events a state transition fires
+func hookEvents(from, to types.TunnelState) []types.HookEvent {
+	var events []types.HookEvent
+	if to == types.TunnelStateActive && from != types.TunnelStateActive {
+		events = append(events, types.HookConnect)
+	}
+	if from == types.TunnelStateActive && to != types.TunnelStateActive {
+		events = append(events, types.HookDisconnect)
+	}
+	if to == types.TunnelStateFailed && from != types.TunnelStateFailed {
+		events = append(events, types.HookFailure)
+	}
+	return events
+}
+
+// runHooks queues the hooks a tunnel's state transition fires. It is called
+// from updateStatus, possibly with mu held, so the hooks run elsewhere.
+func (m *Manager) runHooks(spec *types.TunnelSpec, from types.TunnelState, status types.TunnelStatus) {
+	// Standing by, states are those of the leader, which runs the hooks
+	if spec.Hooks.Empty() || m.standby.Load() {
+		return
+	}
+
+	for _, event := range hookEvents(from, status.State) {
+		hooks := spec.Hooks.For(event)
+		if len(hooks) == 0 {
+			continue
+		}
+		payload := HookPayload{
+			Event:        event,
+			TunnelID:     spec.ID,
+			Name:         spec.Name,
+			State:        status.State,
+			LocalPort:    status.BoundPort,
+			BoundAddress: status.BoundAddress,
+			Error:        status.LastError,
+			Reason:       status.FailureReason,
+			Timestamp:    time.Now(),
+		}
+		if payload.LocalPort == 0 {
+			payload.LocalPort = spec.LocalPort
+		}
+		agentID := spec.AgentID
+
+		m.queueHooks(spec.ID, func() {
+			// Agents run the hooks of the tunnels delegated to them
+			if !m.runOnThisNode(agentID) {
+				return
+			}
+			for _, hook := range hooks {
+				err := runHook(m.ctx, hook, payload)
+				m.hookMu.Lock()
+				reporter := m.hookReporter
+				m.hookMu.Unlock()
+				if reporter != nil {
+					reporter(payload, hook, err)
+				}
+			}
+		})
+	}
+}
+
+// queueHooks runs fn after the hooks queued earlier for the tunnel
+func (m *Manager) queueHooks(tunnelID string, fn func()) {
+	m.hookMu.Lock()
+	defer m.hookMu.Unlock()
+
+	if m.hookQueues == nil {
+		m.hookQueues = make(map[string]*hookQueue)
+	}
+	queue, ok := m.hookQueues[tunnelID]
+	if !ok {
+		queue = &hookQueue{}
+		m.hookQueues[tunnelID] = queue
+	}
+	queue.pending = append(queue.pending, fn)
+	if queue.running {
+		return
+	}
+	queue.running = true
+
+	go func() {
+		for {
+			m.hookMu.Lock()
+			if len(queue.pending) == 0 {
+				queue.running = false
+				delete(m.hookQueues, tunnelID)
+				m.hookMu.Unlock()
+				return
+			}
+			next := queue.pending[0]
+			queue.pending = queue.pending[1:]
+			m.hookMu.Unlock()
+
+			next()
+		}
+	}()
+}
+
+// runHook runs a command or calls a webhook with the event's context
+func runHook(ctx context.Context, hook types.Hook, payload HookPayload) error {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if hook.Command != "" {
+		return runHookCommand(ctx, hook.Command, payload)
+	}
+	return callWebhook(ctx, hook.URL, payload)
+}
+
+// runHookCommand runs a command through the shell
+func runHookCommand(ctx context.Context, command string, payload HookPayload) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"LAZYTUNNEL_EVENT="+string(payload.Event),
+		"LAZYTUNNEL_TUNNEL_ID="+payload.TunnelID,
+		"LAZYTUNNEL_TUNNEL_NAME="+payload.Name,
+		"LAZYTUNNEL_STATE="+string(payload.State),
+		"LAZYTUNNEL_LOCAL_PORT="+strconv.Itoa(payload.LocalPort),
+		"LAZYTUNNEL_BOUND_ADDRESS="+payload.BoundAddress,
+		"LAZYTUNNEL_ERROR="+payload.Error,
+		"LAZYTUNNEL_REASON="+string(payload.Reason),
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command timed out")
+	}
+	out := strings.TrimSpace(string(output))
+	if len(out) > maxHookOutput {
+		out = out[len(out)-maxHookOutput:]
+	}
+	if out == "" {
+		return fmt.Errorf("command failed: %w", err)
+	}
+	return fmt.Errorf("command failed: %w: %s", err, out)
+}
+
+// callWebhook POSTs the event to a webhook, which must answer with 2xx
+func callWebhook(ctx context.Context, url string, payload HookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "lazytunnel-hooks")
+
+	resp, err := hookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
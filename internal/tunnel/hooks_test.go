@@ -0,0 +1,139 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestHookEvents(t *testing.T) {
+	tests := []struct {
+		from, to types.TunnelState
+		want     []types.HookEvent
+	}{
+		{types.TunnelStatePending, types.TunnelStateActive, []types.HookEvent{types.HookConnect}},
+		{types.TunnelStateActive, types.TunnelStateStopped, []types.HookEvent{types.HookDisconnect}},
+		{types.TunnelStateActive, types.TunnelStatePending, []types.HookEvent{types.HookDisconnect}},
+		{types.TunnelStateActive, types.TunnelStateFailed, []types.HookEvent{types.HookDisconnect, types.HookFailure}},
+		{types.TunnelStatePending, types.TunnelStateFailed, []types.HookEvent{types.HookFailure}},
+		{types.TunnelStateStopped, types.TunnelStatePending, nil},
+	}
+	for _, tt := range tests {
+		if got := hookEvents(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("hookEvents(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestHooksRunOnTransitions(t *testing.T) {
+	payloads := make(chan HookPayload, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload HookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Invalid webhook payload: %v", err)
+		}
+		payloads <- payload
+		if payload.Event == types.HookFailure {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer webhook.Close()
+
+	m := NewManager(context.Background())
+	reports := make(chan error, 10)
+	m.SetHookReporter(func(payload HookPayload, hook types.Hook, err error) {
+		reports <- err
+	})
+
+	spec := &types.TunnelSpec{
+		ID:        "hooked",
+		Name:      "hooked",
+		LocalPort: 5432,
+		Hooks: types.Hooks{
+			OnConnect: []types.Hook{{URL: webhook.URL}},
+			OnFailure: []types.Hook{{URL: webhook.URL}},
+		},
+	}
+	output := filepath.Join(t.TempDir(), "disconnect.txt")
+	if runtime.GOOS != "windows" {
+		spec.Hooks.OnDisconnect = []types.Hook{{Command: `echo "$LAZYTUNNEL_EVENT $LAZYTUNNEL_TUNNEL_ID $LAZYTUNNEL_LOCAL_PORT" > ` + output}}
+	}
+	tunnel := m.storedTunnel(spec)
+
+	wait := func(desc string) error {
+		t.Helper()
+		select {
+		case err := <-reports:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the %s hook", desc)
+			return nil
+		}
+	}
+
+	tunnel.updateStatus(types.TunnelStatePending, "")
+	tunnel.updateStatus(types.TunnelStateActive, "")
+	if err := wait("connect"); err != nil {
+		t.Errorf("Expected the connect webhook to succeed, got %v", err)
+	}
+	if payload := <-payloads; payload.Event != types.HookConnect || payload.TunnelID != "hooked" || payload.LocalPort != 5432 || payload.State != types.TunnelStateActive {
+		t.Errorf("Unexpected connect payload %+v", payload)
+	}
+
+	// Same state again: no hooks
+	tunnel.updateStatus(types.TunnelStateActive, "")
+
+	tunnel.updateStatus(types.TunnelStateFailed, "connection lost")
+	if runtime.GOOS != "windows" {
+		if err := wait("disconnect"); err != nil {
+			t.Errorf("Expected the disconnect command to succeed, got %v", err)
+		}
+		data, err := os.ReadFile(output)
+		if err != nil || strings.TrimSpace(string(data)) != "disconnect hooked 5432" {
+			t.Errorf("Expected the command to get the tunnel's context, got %q (%v)", data, err)
+		}
+	}
+	if err := wait("failure"); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Expected the failing webhook to be reported, got %v", err)
+	}
+	if payload := <-payloads; payload.Event != types.HookFailure || payload.Error != "connection lost" {
+		t.Errorf("Unexpected failure payload %+v", payload)
+	}
+
+	select {
+	case payload := <-payloads:
+		t.Errorf("Unexpected hook run %+v", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestHooksSkippedOnStandby(t *testing.T) {
+	m := NewManager(context.Background())
+	m.SetStandby(true)
+	reports := make(chan error, 1)
+	m.SetHookReporter(func(payload HookPayload, hook types.Hook, err error) {
+		reports <- err
+	})
+
+	tunnel := m.storedTunnel(&types.TunnelSpec{
+		ID:    "standby",
+		Hooks: types.Hooks{OnConnect: []types.Hook{{URL: "http://127.0.0.1:1"}}},
+	})
+	tunnel.updateStatus(types.TunnelStateActive, standbyMessage)
+
+	select {
+	case <-reports:
+		t.Error("Expected the leader, not a standby instance, to run hooks")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,123 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Sources of identities
+const (
+	IdentitySourceAgent = "agent"
+	IdentitySourceFile  = "file"
+)
+
+// DefaultKeyFiles are the private keys ssh tries by default
+var DefaultKeyFiles = []string{"~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa"}
+
+// Identity is a key hops can be authenticated with: one the local SSH agent
+// holds, for agent authentication, or a private key file, for key
+// authentication with the file's path as the hop's key ID
+type Identity struct {
+	Source      string
+	Path        string // of key files, as given
+	Type        string // e.g. ssh-ed25519; empty if the file can't be read
+	Fingerprint string // SHA256, as ssh-keygen -l shows it
+	Comment     string
+	Encrypted   bool  // the key file needs a passphrase
+	Err         error // why the key file can't be used
+}
+
+// AgentIdentities lists the keys the local SSH agent holds. It returns
+// none, and no error, when no agent is configured.
+func AgentIdentities() ([]Identity, error) {
+	if AgentSocket() == "" {
+		return nil, nil
+	}
+	conn, err := DialAgent()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list SSH agent keys: %w", err)
+	}
+	identities := make([]Identity, 0, len(keys))
+	for _, key := range keys {
+		identities = append(identities, Identity{
+			Source:      IdentitySourceAgent,
+			Type:        key.Format,
+			Fingerprint: ssh.FingerprintSHA256(key),
+			Comment:     key.Comment,
+		})
+	}
+	return identities, nil
+}
+
+// KeyFileIdentities describes the private key files among paths. Files
+// that don't exist are left out with skipMissing, for paths that are only
+// tried, and reported otherwise.
+func KeyFileIdentities(paths []string, skipMissing bool) []Identity {
+	identities := make([]Identity, 0, len(paths))
+	for _, path := range paths {
+		identity, err := keyFileIdentity(path)
+		if errors.Is(err, os.ErrNotExist) && skipMissing {
+			continue
+		}
+		identity.Err = err
+		identities = append(identities, identity)
+	}
+	return identities
+}
+
+// keyFileIdentity reads the public half of a private key file, and the
+// comment of its .pub file if there is one
+func keyFileIdentity(path string) (Identity, error) {
+	identity := Identity{Source: IdentitySourceFile, Path: path}
+	expanded, err := ExpandPath(path)
+	if err != nil {
+		return identity, err
+	}
+	data, err := os.ReadFile(expanded)
+	if err != nil {
+		return identity, unwrapPathError(err)
+	}
+
+	var public ssh.PublicKey
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case err == nil:
+		public = signer.PublicKey()
+	case errors.As(err, &missing):
+		identity.Encrypted = true
+		public = missing.PublicKey
+	default:
+		return identity, fmt.Errorf("not a private key: %w", err)
+	}
+
+	// The .pub file holds the comment; older encrypted keys only have
+	// their public key there
+	if pub, err := os.ReadFile(expanded + ".pub"); err == nil {
+		if key, comment, _, _, err := ssh.ParseAuthorizedKey(pub); err == nil {
+			if public == nil {
+				public = key
+			}
+			if bytes.Equal(key.Marshal(), public.Marshal()) {
+				identity.Comment = strings.TrimSpace(comment)
+			}
+		}
+	}
+	if public != nil {
+		identity.Type = public.Type()
+		identity.Fingerprint = ssh.FingerprintSHA256(public)
+	}
+	return identity, nil
+}
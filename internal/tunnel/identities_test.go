@@ -0,0 +1,136 @@
+package tunnel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestKeyFileIdentities(t *testing.T) {
+	dir := t.TempDir()
+	writeKey := func(name string, passphrase string, comment string) string {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		var block *pem.Block
+		if passphrase == "" {
+			block, err = ssh.MarshalPrivateKey(priv, "")
+		} else {
+			block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+		}
+		if err != nil {
+			t.Fatalf("Failed to marshal key: %v", err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if comment != "" {
+			signer, err := ssh.NewSignerFromKey(priv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pub := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " " + comment + "\n"
+			if err := os.WriteFile(path+".pub", []byte(pub), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return path
+	}
+	plain := writeKey("id_plain", "", "deploy@ci")
+	encrypted := writeKey("id_encrypted", "hunter2", "")
+	garbage := filepath.Join(dir, "id_garbage")
+	if err := os.WriteFile(garbage, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "id_missing")
+
+	identities := KeyFileIdentities([]string{plain, encrypted, garbage, missing}, false)
+	if len(identities) != 4 {
+		t.Fatalf("got %d identities, want 4: %+v", len(identities), identities)
+	}
+
+	if id := identities[0]; id.Source != IdentitySourceFile || id.Path != plain || id.Type != ssh.KeyAlgoED25519 ||
+		!strings.HasPrefix(id.Fingerprint, "SHA256:") || id.Comment != "deploy@ci" || id.Encrypted || id.Err != nil {
+		t.Errorf("plain key = %+v", id)
+	}
+	if id := identities[1]; id.Type != ssh.KeyAlgoED25519 || id.Fingerprint == "" || !id.Encrypted || id.Err != nil {
+		t.Errorf("encrypted key = %+v, want its fingerprint without a passphrase", id)
+	}
+	if id := identities[2]; id.Err == nil || id.Fingerprint != "" {
+		t.Errorf("garbage key = %+v, want an error", id)
+	}
+	if id := identities[3]; !os.IsNotExist(id.Err) {
+		t.Errorf("missing key = %+v, want a not-exist error", id)
+	}
+
+	// Only tried paths, like ssh's defaults, leave missing files out
+	if identities := KeyFileIdentities([]string{missing, plain}, true); len(identities) != 1 || identities[0].Path != plain {
+		t.Errorf("skipping missing files got %+v, want only %s", identities, plain)
+	}
+}
+
+func TestAgentIdentities(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if identities, err := AgentIdentities(); err != nil || len(identities) != 0 {
+		t.Errorf("without an agent got %v, %v; want nothing", identities, err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key, Comment: "alice@laptop"}); err != nil {
+		t.Fatalf("Failed to add key to agent: %v", err)
+	}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on agent socket: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	identities, err := AgentIdentities()
+	if err != nil {
+		t.Fatalf("AgentIdentities() error = %v", err)
+	}
+	signer, _ := ssh.NewSignerFromKey(key)
+	want := Identity{
+		Source:      IdentitySourceAgent,
+		Type:        ssh.KeyAlgoED25519,
+		Fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+		Comment:     "alice@laptop",
+	}
+	if len(identities) != 1 || identities[0] != want {
+		t.Errorf("AgentIdentities() = %+v, want [%+v]", identities, want)
+	}
+
+	// An agent that can't be reached is an error
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "gone.sock"))
+	if _, err := AgentIdentities(); err == nil {
+		t.Error("AgentIdentities() succeeded with a dead agent socket")
+	}
+}
@@ -0,0 +1,66 @@
+package tunnel
+
+import (
+	"context"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// lastTouched returns when the tunnel was last created, changed, seen
+// forwarding traffic or changed state
+func (t *Tunnel) lastTouched() time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	touched := t.CreatedAt
+	for _, at := range []time.Time{t.Spec.UpdatedAt, t.changedAt} {
+		if at.After(touched) {
+			touched = at
+		}
+	}
+	if t.Status != nil && t.Status.LastActivity != nil && t.Status.LastActivity.After(touched) {
+		touched = *t.Status.LastActivity
+	}
+	return touched
+}
+
+// inactive reports whether a tunnel is down for good: stopped, failed or
+// misconfigured, rather than running or connecting
+func (t *Tunnel) inactive() bool {
+	status := t.GetStatus()
+	if status == nil {
+		return false
+	}
+	switch status.State {
+	case types.TunnelStateStopped, types.TunnelStateFailed, types.TunnelStateMisconfigured:
+		return true
+	}
+	return false
+}
+
+// DeleteInactive soft-deletes the tunnels that have been stopped, failed or
+// misconfigured, and untouched, since before cutoff, except those other
+// tunnels depend on, and returns them. Standing by, it leaves them to the
+// leader.
+func (m *Manager) DeleteInactive(ctx context.Context, cutoff time.Time) ([]*Tunnel, error) {
+	if m.standby.Load() {
+		return nil, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted []*Tunnel
+	for _, tunnel := range m.tunnels {
+		if !tunnel.inactive() || !tunnel.lastTouched().Before(cutoff) || len(m.dependentsLocked(tunnel.Spec)) > 0 {
+			continue
+		}
+		// Stop errors are expected of failed tunnels and don't keep them
+		if _, err := m.deleteLocked(ctx, tunnel, StopOptions{}); err != nil && m.tunnels[tunnel.Spec.ID] != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, tunnel)
+	}
+	return deleted, nil
+}
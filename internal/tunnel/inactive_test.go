@@ -0,0 +1,81 @@
+package tunnel
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestManagerDeleteInactive(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStorage()
+	manager := NewManager(ctx)
+	manager.SetStorage(store)
+
+	longAgo := time.Now().Add(-90 * 24 * time.Hour)
+	recently := time.Now().Add(-time.Hour)
+	for _, tt := range []struct {
+		name      string
+		state     types.TunnelState
+		touched   time.Time
+		activity  time.Time
+		dependsOn []string
+	}{
+		{name: "stopped", state: types.TunnelStateStopped, touched: longAgo},
+		{name: "failed", state: types.TunnelStateFailed, touched: longAgo},
+		{name: "active", state: types.TunnelStateActive, touched: longAgo},
+		{name: "stopped-recently", state: types.TunnelStateStopped, touched: recently},
+		{name: "used-recently", state: types.TunnelStateStopped, touched: longAgo, activity: recently},
+		{name: "dependency", state: types.TunnelStateStopped, touched: longAgo},
+		{name: "dependent", state: types.TunnelStateActive, touched: longAgo, dependsOn: []string{"dependency"}},
+	} {
+		createTunnels(t, manager, &types.TunnelSpec{ID: tt.name, Name: tt.name, Type: types.TunnelTypeLocal, DependsOn: tt.dependsOn})
+		tunnel, _ := manager.Get(tt.name)
+		tunnel.CreatedAt, tunnel.Spec.UpdatedAt, tunnel.changedAt = tt.touched, tt.touched, tt.touched
+		tunnel.Status.State = tt.state
+		if !tt.activity.IsZero() {
+			tunnel.Status.LastActivity = &tt.activity
+		}
+	}
+
+	deleted, err := manager.DeleteInactive(ctx, time.Now().Add(-30*24*time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteInactive failed: %v", err)
+	}
+	var names []string
+	for _, tunnel := range deleted {
+		names = append(names, tunnel.Spec.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "failed" || names[1] != "stopped" {
+		t.Fatalf("Expected the long stopped and failed tunnels to be deleted, got %v", names)
+	}
+	if len(manager.ListDeleted()) != 2 || len(manager.List()) != 5 {
+		t.Errorf("Expected 2 deleted and 5 live tunnels, got %d and %d", len(manager.ListDeleted()), len(manager.List()))
+	}
+	if stored, _ := store.Get(ctx, "stopped"); stored == nil || stored.DeletedAt == nil {
+		t.Error("Expected the deletion to be persisted, so the tunnel can be restored")
+	}
+
+	// Stopping a tunnel counts as touching it
+	if err := manager.Start(ctx, "stopped-recently"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := manager.Stop(ctx, "stopped-recently"); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	tunnel, _ := manager.Get("stopped-recently")
+	tunnel.CreatedAt, tunnel.Spec.UpdatedAt = longAgo, longAgo
+	if deleted, _ := manager.DeleteInactive(ctx, time.Now().Add(-30*24*time.Hour)); len(deleted) != 0 {
+		t.Errorf("Expected a tunnel stopped just now to be kept, deleted %d", len(deleted))
+	}
+
+	// Standing by, the leader cleans up
+	manager.SetStandby(true)
+	if deleted, _ := manager.DeleteInactive(ctx, time.Now()); len(deleted) != 0 {
+		t.Errorf("Expected a standby to delete nothing, deleted %d", len(deleted))
+	}
+}
@@ -2,8 +2,13 @@ package tunnel
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/craigderington/lazytunnel/pkg/types"
@@ -21,18 +26,65 @@ type Storage interface {
 	Close() error
 }
 
-// StatusCallback is called when tunnel status changes
-type StatusCallback func(tunnelID string, status *types.TunnelStatus)
+// ErrNameExists is returned when creating a tunnel whose name is already
+// taken in its project
+var ErrNameExists = errors.New("tunnel name already exists")
+
+// ErrNotDeleted is returned when restoring a tunnel that isn't deleted
+var ErrNotDeleted = errors.New("tunnel is not deleted")
+
+// StatusLister is optionally implemented by storage that can report the
+// last persisted runtime status of each tunnel
+type StatusLister interface {
+	ListStatuses(ctx context.Context) (map[string]string, error)
+}
 
 // Manager handles the lifecycle of SSH tunnels
 type Manager struct {
 	tunnels        map[string]*Tunnel
+	deleted        map[string]*Tunnel // soft-deleted, kept until restored or purged
 	mu             sync.RWMutex
 	ctx            context.Context
 	nodeAgentID    string                // non-empty on data-plane agents
 	storage        Storage               // Optional persistent storage
-	statusCallback StatusCallback        // Optional callback for status changes
 	circuitBreaker *TunnelCircuitBreaker // Circuit breaker for tunnel connections
+
+	// hooksMu guards the status hooks separately from mu, since status
+	// changes are reported while mu is held (e.g. from Start)
+	hooksMu       sync.RWMutex
+	statusStorage Storage
+	subscribers   map[uint64]*Subscription
+	nextSubID     uint64
+	callbackSub   *Subscription // registered through SetStatusCallback
+
+	prompts *promptBroker // keyboard-interactive challenges awaiting answers
+
+	// version counts changes to the tunnel set and their states
+	version atomic.Uint64
+
+	watchMu sync.Mutex
+	watches map[string]*statusWatch // per-tunnel change notification for long-polling
+
+	quotaMu   sync.RWMutex
+	quotas    Quotas
+	metering  bool             // whether meterBandwidth is running
+	bandwidth map[string]int64 // bytes/second per tunnel, as last measured
+
+	clustered atomic.Bool // whether other instances share the storage
+	standby   atomic.Bool // whether another instance runs the tunnels
+
+	hookMu       sync.Mutex
+	hookReporter HookReporter
+	hookQueues   map[string]*hookQueue // per tunnel, while its hooks run
+
+	forwarderOptions atomic.Pointer[[]ForwarderOption] // set with SetForwarderOptions
+	relayToken       atomic.Pointer[string]            // set with SetRelayToken
+	keySource        atomic.Pointer[KeySource]         // set with SetKeySource
+	retryLimiter     atomic.Pointer[retryLimiter]      // set with SetRetryBudget; nil = unlimited
+	resolver         atomic.Pointer[Resolver]          // set with SetDNS; nil asks the system every time
+	addressBook      atomic.Pointer[AddressBook]       // set with SetAddressBook
+	simulator        atomic.Pointer[Simulator]         // set with SetSimulator; nil connects for real
+	proxyOwner       systemProxyOwner                  // dynamic tunnel registered as the system proxy
 }
 
 // NewManager creates a new tunnel manager with optional circuit breaker configuration
@@ -43,11 +95,16 @@ func NewManager(ctx context.Context, cbConfig ...CircuitBreakerConfig) *Manager
 		config = cbConfig[0]
 	}
 
-	return &Manager{
+	m := &Manager{
 		tunnels:        make(map[string]*Tunnel),
+		deleted:        make(map[string]*Tunnel),
 		ctx:            ctx,
 		circuitBreaker: NewTunnelCircuitBreaker(config),
+		prompts:        newPromptBroker(0),
 	}
+	go m.monitorStaleness()
+
+	return m
 }
 
 // SetCircuitBreaker sets the circuit breaker configuration for the manager
@@ -60,8 +117,25 @@ func (m *Manager) SetCircuitBreaker(config CircuitBreakerConfig) {
 // SetStorage configures persistent storage for the manager
 func (m *Manager) SetStorage(storage Storage) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	m.storage = storage
+	m.mu.Unlock()
+
+	m.hooksMu.Lock()
+	m.statusStorage = storage
+	m.hooksMu.Unlock()
+}
+
+// SetKeySource sets where hops' managed keys are unlocked from
+func (m *Manager) SetKeySource(keys KeySource) {
+	m.keySource.Store(&keys)
+}
+
+// keys returns the key source set with SetKeySource, or nil
+func (m *Manager) keys() KeySource {
+	if keys := m.keySource.Load(); keys != nil {
+		return *keys
+	}
+	return nil
 }
 
 // SetNodeAgentID configures this manager as a data-plane agent (runs SSH for matching agent_id).
@@ -71,13 +145,6 @@ func (m *Manager) SetNodeAgentID(id string) {
 	m.nodeAgentID = id
 }
 
-// SetStatusCallback sets a callback function that is invoked when tunnel status changes
-func (m *Manager) SetStatusCallback(cb StatusCallback) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.statusCallback = cb
-}
-
 // LoadFromStorage restores tunnels from persistent storage
 // Stopped tunnels remain stopped, active tunnels are not auto-started
 func (m *Manager) LoadFromStorage(ctx context.Context) error {
@@ -90,31 +157,88 @@ func (m *Manager) LoadFromStorage(ctx context.Context) error {
 		return fmt.Errorf("failed to list tunnels from storage: %w", err)
 	}
 
+	// Last persisted states, if the storage can report them
+	var stored map[string]string
+	if lister, ok := m.storage.(StatusLister); ok {
+		stored, err = lister.ListStatuses(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list tunnel statuses from storage: %w", err)
+		}
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	for _, spec := range specs {
-		// Create tunnel in memory with stopped status
-		tunnel := &Tunnel{
-			Spec:      spec,
-			CreatedAt: spec.CreatedAt,
-			ctx:       ctx,
-			Status: &types.TunnelStatus{
-				TunnelID:  spec.ID,
-				State:     types.TunnelStateStopped,
-				LastError: "",
-			},
+		status := &types.TunnelStatus{
+			TunnelID: spec.ID,
+			State:    types.TunnelStateStopped,
+		}
+
+		if spec.DeletedAt != nil {
+			m.deleted[spec.ID] = &Tunnel{
+				Spec:           spec,
+				CreatedAt:      spec.CreatedAt,
+				ctx:            ctx,
+				statusCallback: m.handleStatusChange,
+				transitionHook: m.runHooks,
+				Status:         status,
+			}
+			continue
+		}
+
+		// Reconcile the stored state with memory: nothing runs yet in this
+		// process, so a tunnel this node owned can't still be active or
+		// connecting. Failures are kept so they stay visible after a restart.
+		// Tunnels owned by other agents, or by the leader instance while this
+		// one stands by, keep their state until they report.
+		previous, known := stored[spec.ID]
+		runsHere := RunOnThisNode(m.nodeAgentID, spec.AgentID) && !m.standby.Load()
+		if known {
+			switch {
+			case !runsHere:
+				status.State = types.TunnelState(previous)
+			case previous == string(types.TunnelStateFailed):
+				status.State = types.TunnelStateFailed
+				status.LastError = "tunnel had failed before restart"
+			}
 		}
 
-		m.tunnels[spec.ID] = tunnel
+		// Specs that can't work are reported now rather than when started
+		if runsHere {
+			if problems := CheckSpec(spec); len(problems) > 0 {
+				markMisconfigured(status, problems)
+			}
+		}
+
+		if known && string(status.State) != previous {
+			if err := m.storage.UpdateStatus(ctx, spec.ID, string(status.State)); err != nil {
+				return fmt.Errorf("failed to reconcile status of tunnel %s: %w", spec.ID, err)
+			}
+		}
+
+		m.tunnels[spec.ID] = &Tunnel{
+			Spec:           spec,
+			CreatedAt:      spec.CreatedAt,
+			ctx:            ctx,
+			statusCallback: m.handleStatusChange,
+			transitionHook: m.runHooks,
+			Status:         status,
+		}
 	}
+	m.version.Add(1)
 
 	return nil
 }
 
 // RestoreDesired reconnects tunnels that should run on this node with desired_status=active.
 func (m *Manager) RestoreDesired(ctx context.Context) {
-	tunnels := m.List()
+	if m.standby.Load() {
+		return
+	}
+	m.mu.RLock()
+	tunnels := dependencyOrder(m.tunnels)
+	m.mu.RUnlock()
 	for _, t := range tunnels {
 		if !m.runOnThisNode(t.Spec.AgentID) {
 			continue
@@ -139,6 +263,29 @@ func (m *Manager) Create(ctx context.Context, spec *types.TunnelSpec) error {
 		return fmt.Errorf("tunnel %s already exists", spec.ID)
 	}
 
+	// Names are unique per project in storage; catch clashes before the
+	// write. Other projects can use the same names.
+	for _, existing := range m.tunnels {
+		if sameName(existing.Spec, spec) {
+			return fmt.Errorf("%w: %q is used by tunnel %s", ErrNameExists, spec.Name, existing.Spec.ID)
+		}
+	}
+	for _, existing := range m.deleted {
+		if sameName(existing.Spec, spec) {
+			return fmt.Errorf("%w: %q is used by deleted tunnel %s; restore or purge it",
+				ErrNameExists, spec.Name, existing.Spec.ID)
+		}
+	}
+
+	if err := m.checkQuotaLocked(spec, true, RunOnThisNode(m.nodeAgentID, spec.AgentID)); err != nil {
+		return err
+	}
+
+	// Whichever instance leads runs it
+	if m.clustered.Load() && RunOnThisNode(m.nodeAgentID, spec.AgentID) {
+		spec.DesiredStatus = types.DesiredStatusActive
+	}
+
 	// Save to persistent storage first
 	if m.storage != nil {
 		if err := m.storage.Save(ctx, spec); err != nil {
@@ -151,7 +298,8 @@ func (m *Manager) Create(ctx context.Context, spec *types.TunnelSpec) error {
 		Spec:           spec,
 		CreatedAt:      time.Now(),
 		ctx:            ctx,
-		statusCallback: m.statusCallback,
+		statusCallback: m.handleStatusChange,
+		transitionHook: m.runHooks,
 		Status: &types.TunnelStatus{
 			TunnelID:  spec.ID,
 			State:     types.TunnelStatePending,
@@ -161,6 +309,7 @@ func (m *Manager) Create(ctx context.Context, spec *types.TunnelSpec) error {
 
 	// Store the tunnel immediately
 	m.tunnels[spec.ID] = tunnel
+	m.version.Add(1)
 
 	// Remote agents reconcile desired state; only connect on this node when appropriate.
 	if RunOnThisNode(m.nodeAgentID, spec.AgentID) {
@@ -198,13 +347,22 @@ func (m *Manager) connectTunnel(tunnel *Tunnel) {
 		tunnel.updateStatus(types.TunnelStatePending, "delegated to agent "+tunnel.Spec.AgentID)
 		return
 	}
+	if m.standby.Load() {
+		tunnel.updateStatus(types.TunnelStatePending, standbyMessage)
+		return
+	}
+
+	if len(tunnel.Spec.DependsOn) > 0 && !m.awaitDependencies(tunnel) {
+		return
+	}
 
 	// Get or create circuit breaker for this tunnel
 	breaker := m.circuitBreaker.GetBreaker(tunnel.Spec.ID)
 
 	// Check if circuit breaker allows connection
 	if err := breaker.Allow(); err != nil {
-		tunnel.updateStatus(types.TunnelStateFailed, fmt.Sprintf("Circuit breaker blocked: %v", err))
+		tunnel.updateFailure(types.TunnelStateFailed, types.FailureCircuitOpen, fmt.Sprintf("Circuit breaker blocked: %v", err))
+		m.scheduleRestart(tunnel, types.TunnelStateFailed, err)
 		return
 	}
 
@@ -213,7 +371,8 @@ func (m *Manager) connectTunnel(tunnel *Tunnel) {
 	if err != nil {
 		// Record failure in circuit breaker
 		breaker.RecordFailure()
-		tunnel.updateStatus(types.TunnelStateFailed, fmt.Sprintf("Failed to connect: %v", err))
+		tunnel.updateFailure(types.TunnelStateFailed, ClassifyFailure(err), fmt.Sprintf("Failed to connect: %v", err))
+		m.scheduleRestart(tunnel, types.TunnelStateFailed, err)
 		return
 	}
 
@@ -228,6 +387,12 @@ func (m *Manager) connectTunnel(tunnel *Tunnel) {
 func (m *Manager) initializeTunnel(ctx context.Context, tunnel *Tunnel) error {
 	spec := tunnel.Spec
 
+	// Hops referencing the address book connect to its current entries
+	hops, err := ResolveHops(ctx, m.AddressBook(), spec.Hops)
+	if err != nil {
+		return err
+	}
+
 	// Create disconnect callback to update tunnel status
 	onDisconnect := func(err error) {
 		errMsg := ""
@@ -236,7 +401,7 @@ func (m *Manager) initializeTunnel(ctx context.Context, tunnel *Tunnel) error {
 		} else {
 			errMsg = "Connection lost"
 		}
-		tunnel.updateStatus(types.TunnelStateFailed, errMsg)
+		tunnel.updateFailure(types.TunnelStateFailed, ClassifyFailure(err), errMsg)
 	}
 
 	// Create reconnect callback to restore tunnel status
@@ -244,39 +409,73 @@ func (m *Manager) initializeTunnel(ctx context.Context, tunnel *Tunnel) error {
 		tunnel.updateStatus(types.TunnelStateActive, "")
 	}
 
+	// Restart the tunnel from scratch if its policy asks for it, unless the
+	// session was closed on purpose
+	onGiveUp := func(err error) {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		m.scheduleRestart(tunnel, types.TunnelStateFailed, err)
+	}
+
 	// Create session configuration
 	sessionConfig := SessionConfig{
 		KeepAlive:     spec.KeepAlive,
+		KeepAliveMax:  spec.KeepAliveMax,
 		AutoReconnect: spec.AutoReconnect,
 		MaxRetries:    spec.MaxRetries,
 		Timeout:       10 * time.Second,
-		BackoffConfig: DefaultBackoffConfig(),
+		BackoffConfig: BackoffConfigFor(spec.Backoff),
 		OnDisconnect:  onDisconnect,
 		OnReconnect:   onReconnect,
+		OnGiveUp:      onGiveUp,
+		OnRetry:       tunnel.setNextRetry,
+		RetryWait:     m.waitRetry,
+		Prompt:        m.promptFunc(tunnel.Spec.ID),
+		Keys:          m.keys(),
+		Resolver:      m.DNSResolver(),
+		SocketBuffer:  spec.TCP.SSHBuffer,
+		Faults:        tunnel.currentFaults,
+		Simulator:     m.Simulator(),
+	}
+	if len(hops) > 0 && hops[0].Via != "" {
+		sessionConfig.Dial = m.viaDialer(hops[0].Via, sessionConfig.Timeout)
+	}
+	if len(hops) > 0 {
+		if dial := transportDialer(&hops[0], m.relayTokenValue(), sessionConfig.Resolver, sessionConfig.Timeout); dial != nil {
+			sessionConfig.Dial = dial
+		}
+	}
+	if len(hops) > 0 && hops[0].Attach != "" {
+		sessionConfig.Attach = m.attachedClient(hops[0].Attach)
 	}
 
 	// Create SSH session (single or multi-hop)
 	var session SessionDialer
 
-	if len(spec.Hops) == 0 {
+	if len(hops) == 0 {
 		return fmt.Errorf("at least one hop is required")
-	} else if len(spec.Hops) == 1 {
+	} else if len(hops) == 1 {
 		// Single hop
-		sessionConfig.Hop = &spec.Hops[0]
+		sessionConfig.Hop = &hops[0]
 		singleSession, err := NewSession(ctx, sessionConfig)
 		if err != nil {
 			return fmt.Errorf("failed to create session: %w", err)
 		}
 		session = singleSession
+		tunnel.mu.Lock()
 		tunnel.session = singleSession
+		tunnel.mu.Unlock()
 	} else {
 		// Multi-hop
-		multiSession, err := NewMultiHopSession(ctx, spec.Hops, sessionConfig)
+		multiSession, err := NewMultiHopSession(ctx, hops, sessionConfig)
 		if err != nil {
 			return fmt.Errorf("failed to create multi-hop session: %w", err)
 		}
 		session = multiSession
+		tunnel.mu.Lock()
 		tunnel.multiSession = multiSession
+		tunnel.mu.Unlock()
 	}
 
 	// Connect the session
@@ -284,10 +483,52 @@ func (m *Manager) initializeTunnel(ctx context.Context, tunnel *Tunnel) error {
 		return fmt.Errorf("failed to connect session: %w", err)
 	}
 
+	// A forwarder that stops accepting on a broken listener fails the tunnel
+	onListenerFailure := func(err error) {
+		err = withReason(types.FailureListenerBroken, err)
+		tunnel.updateFailure(types.TunnelStateFailed, types.FailureListenerBroken, fmt.Sprintf("Listener failed: %v", err))
+		m.scheduleRestart(tunnel, types.TunnelStateFailed, err)
+	}
+	forwarderOpts := slices.Concat(m.forwarderOpts(), []ForwarderOption{
+		WithListenerFailure(onListenerFailure), WithResolver(sessionConfig.Resolver), WithAddressBook(m.AddressBook()),
+		WithFaults(tunnel.currentFaults)})
+	if sessionConfig.Simulator != nil {
+		// Remote forwards deliver to simulated local destinations too
+		forwarderOpts = append(forwarderOpts, WithDialer(sessionConfig.Simulator))
+	}
+
 	// Create and start forwarder based on tunnel type
 	switch spec.Type {
 	case types.TunnelTypeLocal:
-		forwarder, err := NewLocalForwarder(ctx, spec, session)
+		if spec.Protocol == types.ProtocolUDP {
+			forwarder, err := NewUDPForwarder(ctx, spec, session, forwarderOpts...)
+			if err != nil {
+				tunnel.cleanup()
+				return fmt.Errorf("failed to create UDP forwarder: %w", err)
+			}
+			if err := forwarder.Start(); err != nil {
+				tunnel.cleanup()
+				return fmt.Errorf("failed to start forwarder: %w", err)
+			}
+			tunnel.setForwarder(forwarder)
+			break
+		}
+
+		if len(spec.Ports) > 0 {
+			forwarder, err := NewMultiPortForwarder(ctx, spec, session, forwarderOpts...)
+			if err != nil {
+				tunnel.cleanup()
+				return fmt.Errorf("failed to create multi-port forwarder: %w", err)
+			}
+			if err := forwarder.Start(); err != nil {
+				tunnel.cleanup()
+				return fmt.Errorf("failed to start forwarder: %w", err)
+			}
+			tunnel.setForwarder(forwarder)
+			break
+		}
+
+		forwarder, err := NewLocalForwarder(ctx, spec, session, forwarderOpts...)
 		if err != nil {
 			tunnel.cleanup()
 			return fmt.Errorf("failed to create local forwarder: %w", err)
@@ -296,10 +537,10 @@ func (m *Manager) initializeTunnel(ctx context.Context, tunnel *Tunnel) error {
 			tunnel.cleanup()
 			return fmt.Errorf("failed to start forwarder: %w", err)
 		}
-		tunnel.forwarder = forwarder
+		tunnel.setForwarder(forwarder)
 
 	case types.TunnelTypeRemote:
-		forwarder, err := NewRemoteForwarder(ctx, spec, session)
+		forwarder, err := NewRemoteForwarder(ctx, spec, session, forwarderOpts...)
 		if err != nil {
 			tunnel.cleanup()
 			return fmt.Errorf("failed to create remote forwarder: %w", err)
@@ -308,10 +549,10 @@ func (m *Manager) initializeTunnel(ctx context.Context, tunnel *Tunnel) error {
 			tunnel.cleanup()
 			return fmt.Errorf("failed to start forwarder: %w", err)
 		}
-		tunnel.forwarder = forwarder
+		tunnel.setForwarder(forwarder)
 
 	case types.TunnelTypeDynamic:
-		forwarder, err := NewDynamicForwarder(ctx, spec, session)
+		forwarder, err := NewDynamicForwarder(ctx, spec, session, forwarderOpts...)
 		if err != nil {
 			tunnel.cleanup()
 			return fmt.Errorf("failed to create dynamic forwarder: %w", err)
@@ -320,65 +561,143 @@ func (m *Manager) initializeTunnel(ctx context.Context, tunnel *Tunnel) error {
 			tunnel.cleanup()
 			return fmt.Errorf("failed to start forwarder: %w", err)
 		}
-		tunnel.forwarder = forwarder
+		if spec.SystemProxy {
+			release, err := m.registerSystemProxy(spec, forwarder)
+			if err != nil {
+				forwarder.Stop()
+				tunnel.cleanup()
+				return err
+			}
+			tunnel.mu.Lock()
+			tunnel.releaseSystemProxy = release
+			tunnel.mu.Unlock()
+		}
+		tunnel.setForwarder(forwarder)
+
+	case types.TunnelTypeTransparent:
+		forwarder, err := NewTransparentForwarder(ctx, spec, session, forwarderOpts...)
+		if err != nil {
+			tunnel.cleanup()
+			return fmt.Errorf("failed to create transparent forwarder: %w", err)
+		}
+		if err := forwarder.Start(); err != nil {
+			tunnel.cleanup()
+			return fmt.Errorf("failed to start forwarder: %w", err)
+		}
+		tunnel.setForwarder(forwarder)
 
 	default:
 		tunnel.cleanup()
 		return fmt.Errorf("unsupported tunnel type: %s", spec.Type)
 	}
 
+	if sessionConfig.Simulator != nil {
+		go sessionConfig.Simulator.driveTraffic(tunnel, tunnel.currentForwarder())
+	}
 	return nil
 }
 
 // Stop stops a running tunnel but keeps it in the manager
 func (m *Manager) Stop(ctx context.Context, tunnelID string) error {
+	_, err := m.StopWith(ctx, tunnelID, StopOptions{})
+	return err
+}
+
+// StopWith stops a tunnel like Stop, returning how many connections a
+// forced stop closed across the tunnel and its dependents
+func (m *Manager) StopWith(ctx context.Context, tunnelID string, opts StopOptions) (int, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	tunnel, exists := m.tunnels[tunnelID]
 	if !exists {
-		return fmt.Errorf("tunnel %s not found", tunnelID)
+		return 0, fmt.Errorf("tunnel %s not found", tunnelID)
+	}
+
+	// Tunnels relying on this one go down first
+	var closed int
+	for _, dependent := range m.dependentsLocked(tunnel.Spec) {
+		n, err := m.stopLocked(ctx, dependent, opts)
+		closed += n
+		if err != nil {
+			return closed, fmt.Errorf("failed to stop dependent tunnel %s: %w", dependent.Spec.Name, err)
+		}
+	}
+	n, err := m.stopLocked(ctx, tunnel, opts)
+	return closed + n, err
+}
+
+// stopLocked stops a tunnel and records it stopped, returning how many
+// connections were force-closed. Must be called with m.mu held.
+func (m *Manager) stopLocked(ctx context.Context, tunnel *Tunnel, opts StopOptions) (int, error) {
+	tunnelID := tunnel.Spec.ID
+	if err := m.persistDesired(ctx, tunnel, types.DesiredStatusStopped); err != nil {
+		return 0, err
 	}
 
 	// Stop the tunnel (closes SSH session and frees ports)
-	if err := tunnel.Stop(); err != nil {
-		return fmt.Errorf("failed to stop tunnel: %w", err)
+	closed, err := tunnel.StopWith(opts)
+	if err != nil {
+		return closed, fmt.Errorf("failed to stop tunnel: %w", err)
 	}
 
-	// Update status in persistent storage
-	if m.storage != nil {
+	// Update status in persistent storage, unless the leader instance owns it
+	if m.storage != nil && !m.standby.Load() {
 		if err := m.storage.UpdateStatus(ctx, tunnelID, "stopped"); err != nil {
-			return fmt.Errorf("failed to update tunnel status in storage: %w", err)
+			return closed, fmt.Errorf("failed to update tunnel status in storage: %w", err)
 		}
 	}
 
 	// Tunnel remains in map with "stopped" status
-	return nil
+	return closed, nil
 }
 
-// Delete stops and removes a tunnel from the manager
+// Delete stops a tunnel and moves it to the deleted tunnels, keeping its
+// spec until it is restored or purged
 func (m *Manager) Delete(ctx context.Context, tunnelID string) error {
+	_, err := m.DeleteWith(ctx, tunnelID, StopOptions{})
+	return err
+}
+
+// DeleteWith deletes a tunnel like Delete, returning how many connections a
+// forced stop closed
+func (m *Manager) DeleteWith(ctx context.Context, tunnelID string, opts StopOptions) (int, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	tunnel, exists := m.tunnels[tunnelID]
 	if !exists {
-		return fmt.Errorf("tunnel %s not found", tunnelID)
+		return 0, fmt.Errorf("tunnel %s not found", tunnelID)
 	}
+	return m.deleteLocked(ctx, tunnel, opts)
+}
+
+// deleteLocked stops a tunnel and moves it to the deleted tunnels. Must be
+// called with m.mu held.
+func (m *Manager) deleteLocked(ctx context.Context, tunnel *Tunnel, opts StopOptions) (int, error) {
+	tunnelID := tunnel.Spec.ID
 
 	// Try to stop the tunnel (may fail if already failed/stopped)
-	stopErr := tunnel.Stop()
+	closed, stopErr := tunnel.StopWith(opts)
 
-	// Remove from persistent storage
+	// Archive in persistent storage; a restored tunnel comes back stopped
+	now := time.Now()
+	spec := *tunnel.Spec
+	spec.DeletedAt = &now
+	spec.DesiredStatus = types.DesiredStatusStopped
+	spec.UpdatedAt = now
 	if m.storage != nil {
-		if err := m.storage.Delete(ctx, tunnelID); err != nil {
-			return fmt.Errorf("failed to delete tunnel from storage: %w", err)
+		if err := m.storage.Save(ctx, &spec); err != nil {
+			return closed, fmt.Errorf("failed to archive tunnel in storage: %w", err)
 		}
 	}
+	tunnel.Spec = &spec
 
 	// Always remove from active tunnels, even if Stop() failed
 	// (failed tunnels need to be deletable)
 	delete(m.tunnels, tunnelID)
+	m.deleted[tunnelID] = tunnel
+	m.notifyStatusWatch(tunnelID, m.version.Add(1), true)
 
 	// Remove circuit breaker for this tunnel
 	if m.circuitBreaker != nil {
@@ -388,10 +707,10 @@ func (m *Manager) Delete(ctx context.Context, tunnelID string) error {
 	// Return stop error only if it was something serious
 	// (but tunnel is already deleted from map)
 	if stopErr != nil {
-		return fmt.Errorf("tunnel removed, but stop had errors: %w", stopErr)
+		return closed, fmt.Errorf("tunnel removed, but stop had errors: %w", stopErr)
 	}
 
-	return nil
+	return closed, nil
 }
 
 // Start starts a stopped tunnel
@@ -410,6 +729,29 @@ func (m *Manager) Start(ctx context.Context, tunnelID string) error {
 		return fmt.Errorf("tunnel is already active")
 	}
 
+	// Dependencies come up first; the tunnel waits for them to be active
+	for _, dependency := range m.dependenciesLocked(tunnel.Spec) {
+		if err := m.startLocked(ctx, dependency); err != nil {
+			return fmt.Errorf("failed to start dependency %s: %w", dependency.Spec.Name, err)
+		}
+	}
+	return m.startLocked(ctx, tunnel)
+}
+
+// startLocked starts connecting a tunnel. Must be called with m.mu held.
+func (m *Manager) startLocked(ctx context.Context, tunnel *Tunnel) error {
+	if err := m.recheckLocked(tunnel); err != nil {
+		return err
+	}
+	if err := m.checkQuotaLocked(tunnel.Spec, false, true); err != nil {
+		return err
+	}
+	if RunOnThisNode(m.nodeAgentID, tunnel.Spec.AgentID) {
+		if err := m.persistDesired(ctx, tunnel, types.DesiredStatusActive); err != nil {
+			return err
+		}
+	}
+
 	// Update to connecting state
 	tunnel.updateStatus(types.TunnelStatePending, "")
 
@@ -445,20 +787,32 @@ func (m *Manager) List() []*Tunnel {
 	return tunnels
 }
 
+// Version returns the manager's state version, which increases whenever a
+// tunnel is created, deleted or changes state. Clients compare versions to
+// tell whether anything changed since they last looked.
+func (m *Manager) Version() uint64 {
+	return m.version.Load()
+}
+
 // Shutdown stops all tunnels and cleans up resources
 func (m *Manager) Shutdown() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	// Dependents stop before the tunnels they rely on
 	var errors []error
-	for id, tunnel := range m.tunnels {
-		if err := tunnel.Stop(); err != nil {
-			errors = append(errors, fmt.Errorf("failed to stop tunnel %s: %w", id, err))
+	ordered := dependencyOrder(m.tunnels)
+	for i := len(ordered) - 1; i >= 0; i-- {
+		if err := ordered[i].Stop(); err != nil {
+			errors = append(errors, fmt.Errorf("failed to stop tunnel %s: %w", ordered[i].Spec.ID, err))
 		}
 	}
 
 	m.tunnels = make(map[string]*Tunnel)
 
+	// Release subscriber goroutines
+	m.closeSubscriptions()
+
 	if len(errors) > 0 {
 		return fmt.Errorf("errors during shutdown: %v", errors)
 	}
@@ -485,6 +839,23 @@ type Tunnel struct {
 
 	// Status callback
 	statusCallback StatusCallback
+
+	// Called on state transitions, to run the tunnel's hooks
+	transitionHook func(spec *types.TunnelSpec, from types.TunnelState, status types.TunnelStatus)
+
+	// Restart policy bookkeeping, guarded by mu
+	restarts       []time.Time // restarts within the last restartWindow
+	restartPending bool
+	restartNow     chan struct{} // cuts the pending restart's backoff short
+
+	// When the state last changed in this process, guarded by mu
+	changedAt time.Time
+
+	faults atomic.Pointer[Faults] // injected with SetFaults
+
+	// Turns the system proxy off again, while the tunnel is registered as
+	// it; guarded by mu
+	releaseSystemProxy func()
 }
 
 // connect establishes the SSH session
@@ -500,21 +871,66 @@ func (t *Tunnel) connect() error {
 
 // Stop stops the tunnel (idempotent - can be called multiple times)
 func (t *Tunnel) Stop() error {
+	_, err := t.StopWith(StopOptions{})
+	return err
+}
+
+// StopWith stops the tunnel like Stop. Forced, active connections are closed
+// instead of drained; how many is returned and kept in the status.
+func (t *Tunnel) StopWith(opts StopOptions) (int, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	// Check if already stopped
 	if t.Status != nil && t.Status.State == types.TunnelStateStopped {
-		return nil // Already stopped, no-op
+		return 0, nil // Already stopped, no-op
 	}
 
+	closed, err := t.teardown(opts.Force)
+
+	// Update status
+	if t.Status == nil {
+		t.Status = &types.TunnelStatus{
+			TunnelID: t.Spec.ID,
+		}
+	}
+	t.Status.State = types.TunnelStateStopped
+	t.Status.LastError = ""
+	t.Status.FailureReason = ""
+	t.Status.Stale = false
+	t.Status.BoundAddress = ""
+	t.Status.BoundPort = 0
+	t.Status.ForceClosed = closed
+	t.Status.NextRetryAt, t.Status.RetryAttempt, t.Status.MaxRetryAttempts = nil, 0, 0
+
+	return closed, err
+}
+
+// teardown stops the forwarder and closes the SSH sessions so the tunnel can
+// be connected again, returning how many connections a forced stop closed.
+// Must be called with t.mu held.
+func (t *Tunnel) teardown(force bool) (int, error) {
+	var closed int
 	var err error
 
+	// Applications stop using the proxy before it goes away
+	if t.releaseSystemProxy != nil {
+		t.releaseSystemProxy()
+		t.releaseSystemProxy = nil
+	}
+
 	// Stop forwarder
 	if t.forwarder != nil {
-		if stopErr := t.forwarder.Stop(); stopErr != nil {
+		var stopErr error
+		if closed, stopErr = stopForwarder(t.forwarder, force); stopErr != nil {
 			err = stopErr
 		}
+		// Keep the final counts for the stopped tunnel's status
+		if t.Status != nil {
+			stats := t.forwarder.Stats()
+			t.Status.BytesSent = stats.BytesSent
+			t.Status.BytesReceived = stats.BytesReceived
+		}
 		t.forwarder = nil // Clear forwarder reference
 	}
 
@@ -527,16 +943,21 @@ func (t *Tunnel) Stop() error {
 	t.session = nil
 	t.multiSession = nil
 
-	// Update status
-	if t.Status == nil {
-		t.Status = &types.TunnelStatus{
-			TunnelID: t.Spec.ID,
-		}
-	}
-	t.Status.State = types.TunnelStateStopped
-	t.Status.LastError = ""
+	return closed, err
+}
 
-	return err
+// setForwarder records the forwarder a starting tunnel forwards with
+func (t *Tunnel) setForwarder(forwarder Forwarder) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.forwarder = forwarder
+}
+
+// currentForwarder returns the tunnel's forwarder, nil while it isn't running
+func (t *Tunnel) currentForwarder() Forwarder {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.forwarder
 }
 
 // cleanup closes SSH sessions
@@ -555,10 +976,20 @@ func (t *Tunnel) UpdateStatus(state types.TunnelState, errorMsg string) {
 	t.updateStatus(state, errorMsg)
 }
 
+// UpdateFailure updates tunnel state with the reason of its error, as
+// reported by agents
+func (t *Tunnel) UpdateFailure(state types.TunnelState, reason types.FailureReason, errorMsg string) {
+	t.updateFailure(state, reason, errorMsg)
+}
+
 // updateStatus updates the tunnel status
 func (t *Tunnel) updateStatus(state types.TunnelState, errorMsg string) {
+	t.updateFailure(state, "", errorMsg)
+}
+
+// updateFailure updates the tunnel status, categorizing errorMsg with reason
+func (t *Tunnel) updateFailure(state types.TunnelState, reason types.FailureReason, errorMsg string) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
 
 	now := time.Now()
 
@@ -568,8 +999,19 @@ func (t *Tunnel) updateStatus(state types.TunnelState, errorMsg string) {
 		}
 	}
 
+	previous := t.Status.State
+	if state != previous {
+		t.changedAt = now
+	}
 	t.Status.State = state
 	t.Status.LastError = errorMsg
+	t.Status.FailureReason = reason
+	t.Status.Problems = nil
+	t.Status.ForceClosed = 0
+	t.Status.NextRetryAt, t.Status.RetryAttempt, t.Status.MaxRetryAttempts = nil, 0, 0
+	if state != types.TunnelStateActive {
+		t.Status.Stale = false
+	}
 
 	if state == types.TunnelStateActive && t.Status.ConnectedAt == nil {
 		t.Status.ConnectedAt = &now
@@ -581,32 +1023,147 @@ func (t *Tunnel) updateStatus(state types.TunnelState, errorMsg string) {
 		t.Status.BytesSent = stats.BytesSent
 		t.Status.BytesReceived = stats.BytesReceived
 	}
+	t.Status.BoundAddress, t.Status.BoundPort = boundEndpoint(t.forwarder)
+
+	// Report a copy outside the lock; the callback may persist it or
+	// broadcast it while the tunnel keeps changing
+	snapshot := *t.Status
+	t.fillActivity(&snapshot, now)
+	snapshot.RestartsLastHour = t.recentRestarts(now)
+	cb := t.statusCallback
+	transitionHook := t.transitionHook
+	t.mu.Unlock()
+
+	if cb != nil {
+		cb(t.Spec.ID, &snapshot)
+	}
+	if transitionHook != nil && previous != state {
+		transitionHook(t.Spec, previous, snapshot)
+	}
+}
+
+// SetNextRetry records when the next attempt to connect a tunnel that isn't
+// active is due, as reported by agents
+func (t *Tunnel) SetNextRetry(at time.Time, attempt, maxAttempts int) {
+	t.setNextRetry(at, attempt, maxAttempts)
+}
+
+// setNextRetry records the next reconnect or restart attempt and reports
+// the status, so clients can count down to it. Status changes clear it.
+func (t *Tunnel) setNextRetry(at time.Time, attempt, maxAttempts int) {
+	t.mu.Lock()
+	if t.Status == nil || t.Status.State == types.TunnelStateActive || t.Status.State == types.TunnelStateStopped {
+		t.mu.Unlock()
+		return
+	}
+	t.Status.NextRetryAt = &at
+	t.Status.RetryAttempt = attempt
+	t.Status.MaxRetryAttempts = maxAttempts
 
-	// Call status callback if configured
-	if t.statusCallback != nil {
-		t.statusCallback(t.Spec.ID, t.Status)
+	now := time.Now()
+	snapshot := *t.Status
+	t.fillActivity(&snapshot, now)
+	snapshot.RestartsLastHour = t.recentRestarts(now)
+	cb := t.statusCallback
+	t.mu.Unlock()
+
+	if cb != nil {
+		cb(t.Spec.ID, &snapshot)
 	}
 }
 
+// Stats returns the forwarder statistics, or zero values if the tunnel isn't forwarding
+func (t *Tunnel) Stats() ForwarderStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.forwarder == nil {
+		return ForwarderStats{}
+	}
+	return t.forwarder.Stats()
+}
+
+// Connections lists the active connections of the tunnel's forwarder, or
+// nil if it can't list them
+func (t *Tunnel) Connections() []ConnectionInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if lister, ok := t.forwarder.(ConnectionLister); ok {
+		return lister.Connections()
+	}
+	return nil
+}
+
 // GetStatus returns the current tunnel status
 func (t *Tunnel) GetStatus() *types.TunnelStatus {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
-	// Update stats from forwarder
+	// Return a copy to avoid race conditions; the read lock doesn't allow
+	// changing the status itself
+	if t.Status == nil {
+		return nil
+	}
+
+	statusCopy := *t.Status
 	if t.forwarder != nil {
 		stats := t.forwarder.Stats()
-		if t.Status != nil {
-			t.Status.BytesSent = stats.BytesSent
-			t.Status.BytesReceived = stats.BytesReceived
+		statusCopy.BytesSent = stats.BytesSent
+		statusCopy.BytesReceived = stats.BytesReceived
+	}
+	statusCopy.BoundAddress, statusCopy.BoundPort = boundEndpoint(t.forwarder)
+	statusCopy.Hops = t.hopStatuses()
+	switch f := t.forwarder.(type) {
+	case *LocalForwarder:
+		statusCopy.Targets = f.TargetStatus()
+	case *MultiPortForwarder:
+		statusCopy.Ports = f.PortStatus()
+	}
+	t.fillActivity(&statusCopy, time.Now())
+	statusCopy.RestartsLastHour = t.recentRestarts(time.Now())
+
+	// End-to-end latency is roughly the sum of each hop's round trip
+	if len(statusCopy.Hops) > 0 {
+		statusCopy.Latency = 0
+		for _, hop := range statusCopy.Hops {
+			statusCopy.Latency += hop.Latency
 		}
 	}
+	return &statusCopy
+}
 
-	// Return a copy to avoid race conditions
-	if t.Status == nil {
-		return nil
+// hopStatuses returns per-hop connection details, or nil before the tunnel
+// has started. Must be called with t.mu held.
+func (t *Tunnel) hopStatuses() []types.HopStatus {
+	switch {
+	case t.multiSession != nil:
+		return t.multiSession.HopStatus()
+	case t.session != nil:
+		return []types.HopStatus{t.session.HopStatus()}
 	}
+	return nil
+}
 
-	statusCopy := *t.Status
-	return &statusCopy
+// boundEndpoint returns the address a forwarder accepts connections on: the
+// local listener, or for remote tunnels the listener on the last hop.
+// It is empty until the forwarder is listening.
+func boundEndpoint(forwarder Forwarder) (string, int) {
+	var addr string
+	switch f := forwarder.(type) {
+	case *RemoteForwarder:
+		addr = f.RemoteAddr()
+	case interface{ LocalAddr() string }:
+		addr = f.LocalAddr()
+	}
+	if addr == "" {
+		return "", 0
+	}
+
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return addr, port
 }
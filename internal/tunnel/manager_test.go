@@ -2,6 +2,9 @@ package tunnel
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -141,6 +144,68 @@ func TestManagerDuplicateID(t *testing.T) {
 	}
 }
 
+func TestManagerDuplicateName(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(ctx)
+	store := newMemoryStorage()
+	manager.SetStorage(store)
+
+	createTunnels(t, manager, &types.TunnelSpec{ID: "name-1", Name: "db", Type: types.TunnelTypeLocal})
+
+	second := &types.TunnelSpec{ID: "name-2", Name: "db", Type: types.TunnelTypeLocal, AgentID: testAgentID}
+	err := manager.Create(ctx, second)
+	if !errors.Is(err, ErrNameExists) {
+		t.Fatalf("Expected ErrNameExists, got: %v", err)
+	}
+
+	// Neither memory nor storage may hold the rejected tunnel
+	if _, err := manager.Get("name-2"); err == nil {
+		t.Error("Expected rejected tunnel not to be kept in memory")
+	}
+	if _, err := store.Get(ctx, "name-2"); err == nil {
+		t.Error("Expected rejected tunnel not to be saved")
+	}
+
+	// Names are only unique within a project
+	other := &types.TunnelSpec{ID: "name-3", Name: "db", Project: "payments", Type: types.TunnelTypeLocal, AgentID: testAgentID}
+	if err := manager.Create(ctx, other); err != nil {
+		t.Fatalf("Expected another project to reuse the name, got: %v", err)
+	}
+}
+
+func TestManagerVersion(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(ctx)
+	defer manager.Shutdown()
+
+	v0 := manager.Version()
+
+	spec := &types.TunnelSpec{ID: "version-1", Name: "versioned", Type: types.TunnelTypeLocal}
+	createTunnels(t, manager, spec)
+	v1 := manager.Version()
+	if v1 <= v0 {
+		t.Errorf("Expected create to advance the version past %d, got %d", v0, v1)
+	}
+
+	if v := manager.Version(); v != v1 {
+		t.Errorf("Expected reads to leave the version at %d, got %d", v1, v)
+	}
+
+	tunnel, _ := manager.Get(spec.ID)
+	tunnel.UpdateStatus(types.TunnelStateFailed, "agent lost")
+	v2 := manager.Version()
+	if v2 <= v1 {
+		t.Errorf("Expected a state change to advance the version past %d, got %d", v1, v2)
+	}
+
+	if err := manager.Delete(ctx, spec.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if v := manager.Version(); v <= v2 {
+		t.Errorf("Expected delete to advance the version past %d, got %d", v2, v)
+	}
+}
+
 func TestManagerGetNonexistent(t *testing.T) {
 	ctx := context.Background()
 	manager := NewManager(ctx)
@@ -248,3 +313,230 @@ func TestRunOnThisNode(t *testing.T) {
 		}
 	}
 }
+
+// memoryStorage is an in-memory Storage that also reports persisted statuses
+type memoryStorage struct {
+	mu       sync.Mutex
+	specs    map[string]*types.TunnelSpec
+	statuses map[string]string
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		specs:    make(map[string]*types.TunnelSpec),
+		statuses: make(map[string]string),
+	}
+}
+
+func (s *memoryStorage) Save(ctx context.Context, spec *types.TunnelSpec) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.specs[spec.ID] = spec
+	if _, ok := s.statuses[spec.ID]; !ok {
+		s.statuses[spec.ID] = string(types.TunnelStateStopped)
+	}
+	return nil
+}
+
+func (s *memoryStorage) UpdateStatus(ctx context.Context, tunnelID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.specs[tunnelID]; !ok {
+		return fmt.Errorf("tunnel not found: %s", tunnelID)
+	}
+	s.statuses[tunnelID] = status
+	return nil
+}
+
+func (s *memoryStorage) UpdateDesiredStatus(ctx context.Context, tunnelID string, status types.DesiredStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if spec, ok := s.specs[tunnelID]; ok {
+		spec.DesiredStatus = status
+	}
+	return nil
+}
+
+func (s *memoryStorage) Delete(ctx context.Context, tunnelID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.specs, tunnelID)
+	delete(s.statuses, tunnelID)
+	return nil
+}
+
+func (s *memoryStorage) Get(ctx context.Context, tunnelID string) (*types.TunnelSpec, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	spec, ok := s.specs[tunnelID]
+	if !ok {
+		return nil, fmt.Errorf("tunnel not found: %s", tunnelID)
+	}
+	return spec, nil
+}
+
+func (s *memoryStorage) List(ctx context.Context) ([]*types.TunnelSpec, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	specs := make([]*types.TunnelSpec, 0, len(s.specs))
+	for _, spec := range s.specs {
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+func (s *memoryStorage) ListByAgent(ctx context.Context, agentID string) ([]*types.TunnelSpec, error) {
+	return nil, nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
+
+func (s *memoryStorage) ListStatuses(ctx context.Context) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make(map[string]string, len(s.statuses))
+	for id, status := range s.statuses {
+		statuses[id] = status
+	}
+	return statuses, nil
+}
+
+func (s *memoryStorage) status(tunnelID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statuses[tunnelID]
+}
+
+func TestManagerPersistsStatusTransitions(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStorage()
+
+	manager := NewManager(ctx)
+	manager.SetStorage(store)
+
+	reported := make(chan types.TunnelState, 16)
+	sub := manager.Subscribe(func(tunnelID string, status *types.TunnelStatus) {
+		reported <- status.State
+	}, 0)
+
+	spec := &types.TunnelSpec{ID: "persist-1", Type: types.TunnelTypeLocal}
+	createTunnels(t, manager, spec)
+
+	tunnel, err := manager.Get(spec.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	for _, state := range []types.TunnelState{types.TunnelStatePending, types.TunnelStateActive, types.TunnelStateFailed} {
+		tunnel.UpdateStatus(state, "")
+		if got := store.status(spec.ID); got != string(state) {
+			t.Errorf("Expected stored status %s, got %s", state, got)
+		}
+	}
+
+	// stopped (from Create), pending, active, failed
+	sub.Unsubscribe()
+	if len(reported) != 4 {
+		t.Errorf("Expected 4 status events, got %d", len(reported))
+	}
+}
+
+func TestManagerLoadFromStorageReconcilesStatus(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStorage()
+
+	hops := []types.Hop{{Host: "bastion.example.com", Port: 22, User: "ops", AuthMethod: types.AuthMethodAgent}}
+	specs := []*types.TunnelSpec{
+		{ID: "was-active", Type: types.TunnelTypeLocal, Hops: hops},
+		{ID: "was-connecting", Type: types.TunnelTypeLocal, Hops: hops},
+		{ID: "was-failed", Type: types.TunnelTypeLocal, Hops: hops},
+		{ID: "was-stopped", Type: types.TunnelTypeLocal, Hops: hops},
+		{ID: "remote-active", Type: types.TunnelTypeLocal, AgentID: testAgentID},
+	}
+	for _, spec := range specs {
+		_ = store.Save(ctx, spec)
+	}
+	store.statuses["was-active"] = "active"
+	store.statuses["was-connecting"] = "pending"
+	store.statuses["was-failed"] = "failed"
+	store.statuses["remote-active"] = "active"
+
+	manager := NewManager(ctx)
+	manager.SetStorage(store)
+	if err := manager.LoadFromStorage(ctx); err != nil {
+		t.Fatalf("LoadFromStorage failed: %v", err)
+	}
+
+	expected := map[string]types.TunnelState{
+		"was-active":     types.TunnelStateStopped,
+		"was-connecting": types.TunnelStateStopped,
+		"was-failed":     types.TunnelStateFailed,
+		"was-stopped":    types.TunnelStateStopped,
+		"remote-active":  types.TunnelStateActive,
+	}
+
+	for id, want := range expected {
+		tunnel, err := manager.Get(id)
+		if err != nil {
+			t.Fatalf("Get(%s) failed: %v", id, err)
+		}
+		if got := tunnel.GetStatus().State; got != want {
+			t.Errorf("%s: expected in-memory state %s, got %s", id, want, got)
+		}
+		if got := store.status(id); got != string(want) {
+			t.Errorf("%s: expected stored status %s, got %s", id, want, got)
+		}
+	}
+
+	// Loaded tunnels report transitions too
+	tunnel, _ := manager.Get("was-stopped")
+	tunnel.UpdateStatus(types.TunnelStateActive, "")
+	if got := store.status("was-stopped"); got != "active" {
+		t.Errorf("Expected loaded tunnel transition to be persisted, got %s", got)
+	}
+}
+
+func TestTunnelStatusBoundAddress(t *testing.T) {
+	ctx := context.Background()
+	spec := &types.TunnelSpec{
+		ID:               "bound-1",
+		Type:             types.TunnelTypeLocal,
+		LocalBindAddress: "127.0.0.1",
+		LocalPort:        0, // Ephemeral
+		RemoteHost:       "example.com",
+		RemotePort:       80,
+	}
+
+	forwarder, err := NewLocalForwarder(ctx, spec, &MockSessionDialer{connected: true})
+	if err != nil {
+		t.Fatalf("NewLocalForwarder failed: %v", err)
+	}
+
+	tunnel := &Tunnel{Spec: spec, ctx: ctx, forwarder: forwarder}
+
+	// Nothing is bound before the forwarder listens
+	tunnel.updateStatus(types.TunnelStatePending, "")
+	if status := tunnel.GetStatus(); status.BoundAddress != "" || status.BoundPort != 0 {
+		t.Errorf("Expected no bound address before start, got %s (%d)", status.BoundAddress, status.BoundPort)
+	}
+
+	if err := forwarder.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	tunnel.updateStatus(types.TunnelStateActive, "")
+
+	status := tunnel.GetStatus()
+	if status.BoundPort == 0 || status.BoundAddress != forwarder.LocalAddr() {
+		t.Errorf("Expected bound address %s, got %s (%d)", forwarder.LocalAddr(), status.BoundAddress, status.BoundPort)
+	}
+
+	// Stopping clears it
+	if err := tunnel.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if status := tunnel.GetStatus(); status.BoundAddress != "" || status.BoundPort != 0 {
+		t.Errorf("Expected bound address cleared after stop, got %s (%d)", status.BoundAddress, status.BoundPort)
+	}
+}
@@ -0,0 +1,158 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// MultiPortForwarder forwards several local ports through one SSH session,
+// running a LocalForwarder per PortMapping
+type MultiPortForwarder struct {
+	mappings   []types.PortMapping
+	forwarders []*LocalForwarder
+}
+
+// NewMultiPortForwarder creates a forwarder for each of the spec's Ports.
+// Every mapping shares the spec's bind address and socket options.
+func NewMultiPortForwarder(ctx context.Context, spec *types.TunnelSpec, session SessionDialer, opts ...ForwarderOption) (*MultiPortForwarder, error) {
+	if spec.Type != types.TunnelTypeLocal {
+		return nil, fmt.Errorf("invalid tunnel type: expected local, got %s", spec.Type)
+	}
+	if len(spec.Ports) == 0 {
+		return nil, fmt.Errorf("at least one port mapping is required")
+	}
+
+	mf := &MultiPortForwarder{mappings: spec.Ports}
+	for _, mapping := range spec.Ports {
+		mappingSpec := *spec
+		mappingSpec.LocalPort = mapping.LocalPort
+		mappingSpec.RemoteHost = mapping.RemoteHost
+		mappingSpec.RemotePort = mapping.RemotePort
+		mappingSpec.Targets = nil
+		mappingSpec.Ports = nil
+
+		forwarder, err := NewLocalForwarder(ctx, &mappingSpec, session, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port mapping %s: %w", mappingLabel(mapping), err)
+		}
+		mf.forwarders = append(mf.forwarders, forwarder)
+	}
+
+	return mf, nil
+}
+
+// mappingLabel names a mapping in errors, e.g. "db (5432)"
+func mappingLabel(mapping types.PortMapping) string {
+	if mapping.Name != "" {
+		return fmt.Sprintf("%s (%d)", mapping.Name, mapping.LocalPort)
+	}
+	return strconv.Itoa(mapping.LocalPort)
+}
+
+// Start binds every mapping's local port. If any port can't be bound, the
+// ones already listening are released so the tunnel comes up whole or not at all.
+func (mf *MultiPortForwarder) Start() error {
+	for i, forwarder := range mf.forwarders {
+		if err := forwarder.Start(); err != nil {
+			for _, started := range mf.forwarders[:i] {
+				_ = started.Stop()
+			}
+			return fmt.Errorf("port mapping %s: %w", mappingLabel(mf.mappings[i]), err)
+		}
+	}
+	return nil
+}
+
+// Stop stops every mapping, returning the first error
+func (mf *MultiPortForwarder) Stop() error {
+	var firstErr error
+	for _, forwarder := range mf.forwarders {
+		if err := forwarder.Stop(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ForceStop stops every mapping, closing active connections at once, and
+// returns how many were closed across them
+func (mf *MultiPortForwarder) ForceStop() (int, error) {
+	var closed int
+	var firstErr error
+	for _, forwarder := range mf.forwarders {
+		n, err := forwarder.ForceStop()
+		closed += n
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return closed, firstErr
+}
+
+// Stats returns the statistics of all mappings combined
+func (mf *MultiPortForwarder) Stats() ForwarderStats {
+	var total ForwarderStats
+	for _, forwarder := range mf.forwarders {
+		stats := forwarder.Stats()
+		total.BytesSent += stats.BytesSent
+		total.BytesReceived += stats.BytesReceived
+		total.Connections += stats.Connections
+		total.ActiveConns += stats.ActiveConns
+		total.Errors += stats.Errors
+		total.Timeouts += stats.Timeouts
+		total.DialRetries += stats.DialRetries
+		total.Goroutines += stats.Goroutines
+		total.OpenSockets += stats.OpenSockets
+		total.BufferBytes += stats.BufferBytes
+		total.Seq += stats.Seq
+
+		if total.StartedAt.IsZero() || stats.StartedAt.Before(total.StartedAt) {
+			total.StartedAt = stats.StartedAt
+		}
+		if stats.LastActivity.After(total.LastActivity) {
+			total.LastActivity = stats.LastActivity
+		}
+	}
+	return total
+}
+
+// Connections lists the active connections of every mapping
+func (mf *MultiPortForwarder) Connections() []ConnectionInfo {
+	var conns []ConnectionInfo
+	for _, forwarder := range mf.forwarders {
+		conns = append(conns, forwarder.Connections()...)
+	}
+	return conns
+}
+
+// PortStatus reports the bound address and statistics of each mapping, in
+// configured order
+func (mf *MultiPortForwarder) PortStatus() []types.PortStatus {
+	statuses := make([]types.PortStatus, len(mf.forwarders))
+	for i, forwarder := range mf.forwarders {
+		mapping := mf.mappings[i]
+		stats := forwarder.Stats()
+		status := types.PortStatus{
+			Name:          mapping.Name,
+			LocalPort:     mapping.LocalPort,
+			RemoteHost:    mapping.RemoteHost,
+			RemotePort:    mapping.RemotePort,
+			BytesSent:     stats.BytesSent,
+			BytesReceived: stats.BytesReceived,
+			Connections:   stats.Connections,
+			ActiveConns:   stats.ActiveConns,
+			Errors:        stats.Errors,
+		}
+		// Report the port the OS picked for a mapping with local port 0
+		var boundPort int
+		status.BoundAddress, boundPort = boundEndpoint(forwarder)
+		if boundPort != 0 {
+			status.LocalPort = boundPort
+		}
+		statuses[i] = status
+	}
+	return statuses
+}
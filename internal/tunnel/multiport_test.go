@@ -0,0 +1,132 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// newMultiPortSpec returns a local tunnel with a db and a cache mapping on
+// OS-assigned ports
+func newMultiPortSpec(id string) *types.TunnelSpec {
+	return &types.TunnelSpec{
+		ID:               id,
+		Type:             types.TunnelTypeLocal,
+		LocalBindAddress: "127.0.0.1",
+		Ports: []types.PortMapping{
+			{Name: "db", RemoteHost: "db", RemotePort: 5432},
+			{Name: "cache", RemoteHost: "cache", RemotePort: 6379},
+		},
+	}
+}
+
+func TestMultiPortForwarderRoutesEachMapping(t *testing.T) {
+	// Each remote destination answers with its own name
+	var mu sync.Mutex
+	dialed := make(map[string]int)
+	session := &MockSessionDialer{
+		connected: true,
+		dialFunc: func(network, address string) (net.Conn, error) {
+			mu.Lock()
+			dialed[address]++
+			mu.Unlock()
+
+			client, server := net.Pipe()
+			go func() {
+				host, _, _ := net.SplitHostPort(address)
+				server.Write([]byte(host))
+				server.Close()
+			}()
+			return client, nil
+		},
+	}
+
+	mf, err := NewMultiPortForwarder(context.Background(), newMultiPortSpec("multi-1"), session)
+	if err != nil {
+		t.Fatalf("NewMultiPortForwarder failed: %v", err)
+	}
+	if err := mf.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer mf.Stop()
+
+	ports := mf.PortStatus()
+	if len(ports) != 2 || ports[0].LocalPort == 0 || ports[0].LocalPort == ports[1].LocalPort {
+		t.Fatalf("Expected two distinct bound ports, got %+v", ports)
+	}
+
+	read := func(addr string) string {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial %s failed: %v", addr, err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 16)
+		n, _ := io.ReadAtLeast(conn, buf, 1)
+		return string(buf[:n])
+	}
+
+	if got := read(ports[0].BoundAddress); got != "db" {
+		t.Errorf("Expected db mapping to reach db, got %q", got)
+	}
+	if got := read(ports[0].BoundAddress); got != "db" {
+		t.Errorf("Expected db mapping to reach db, got %q", got)
+	}
+	if got := read(ports[1].BoundAddress); got != "cache" {
+		t.Errorf("Expected cache mapping to reach cache, got %q", got)
+	}
+
+	// Stats are kept per mapping and combined for the tunnel
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ports = mf.PortStatus()
+		if ports[0].Connections == 2 && ports[1].Connections == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 2 db and 1 cache connections, got %+v", ports)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := mf.Stats(); stats.Connections != 3 || stats.BytesReceived == 0 {
+		t.Errorf("Expected combined stats for 3 connections, got %+v", stats)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if dialed["db:5432"] != 2 || dialed["cache:6379"] != 1 {
+		t.Errorf("Unexpected dials: %v", dialed)
+	}
+}
+
+func TestMultiPortForwarderStartIsAllOrNothing(t *testing.T) {
+	// Occupy the port of the second mapping
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer taken.Close()
+
+	spec := newMultiPortSpec("multi-2")
+	spec.Ports[1].LocalPort = taken.Addr().(*net.TCPAddr).Port
+
+	mf, err := NewMultiPortForwarder(context.Background(), spec, &MockSessionDialer{connected: true})
+	if err != nil {
+		t.Fatalf("NewMultiPortForwarder failed: %v", err)
+	}
+	if err := mf.Start(); err == nil {
+		mf.Stop()
+		t.Fatal("Expected Start to fail on a taken port")
+	}
+
+	// The first mapping's port was released again
+	if addr := mf.forwarders[0].LocalAddr(); addr != "" {
+		t.Errorf("Expected first mapping to be stopped, still listening on %s", addr)
+	}
+}
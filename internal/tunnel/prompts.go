@@ -0,0 +1,178 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// DefaultPromptTimeout is how long a keyboard-interactive challenge waits for
+// someone to answer it before the connection attempt fails
+const DefaultPromptTimeout = 2 * time.Minute
+
+// ErrPromptNotFound is returned when answering a prompt that was already
+// answered, expired, or never existed
+var ErrPromptNotFound = errors.New("prompt not found")
+
+// PromptFunc asks the user to answer a keyboard-interactive challenge from a
+// hop, blocking until they do or ctx is cancelled
+type PromptFunc func(ctx context.Context, hop *types.Hop, instruction string, questions []AuthPromptQuestion) ([]string, error)
+
+// AuthPromptQuestion is one question in a keyboard-interactive challenge
+type AuthPromptQuestion struct {
+	Text string
+	Echo bool // false for secrets such as OTP codes
+}
+
+// AuthPrompt is a keyboard-interactive challenge waiting for an answer
+type AuthPrompt struct {
+	ID          string
+	TunnelID    string
+	Host        string
+	User        string
+	Instruction string
+	Questions   []AuthPromptQuestion
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// PromptCallback is called when a new prompt needs answering
+type PromptCallback func(prompt AuthPrompt)
+
+// pendingPrompt pairs a prompt with the channel its answers arrive on
+type pendingPrompt struct {
+	prompt  AuthPrompt
+	answers chan []string
+}
+
+// promptBroker relays keyboard-interactive challenges from sessions to
+// whoever answers them through the API
+type promptBroker struct {
+	mu       sync.Mutex
+	pending  map[string]*pendingPrompt
+	timeout  time.Duration
+	callback PromptCallback
+}
+
+// newPromptBroker creates a broker; a zero timeout uses DefaultPromptTimeout
+func newPromptBroker(timeout time.Duration) *promptBroker {
+	if timeout <= 0 {
+		timeout = DefaultPromptTimeout
+	}
+	return &promptBroker{
+		pending: make(map[string]*pendingPrompt),
+		timeout: timeout,
+	}
+}
+
+// ask publishes a challenge for tunnelID and waits for its answers
+func (b *promptBroker) ask(ctx context.Context, tunnelID string, hop *types.Hop, instruction string, questions []AuthPromptQuestion) ([]string, error) {
+	now := time.Now()
+	p := &pendingPrompt{
+		prompt: AuthPrompt{
+			ID:          uuid.New().String(),
+			TunnelID:    tunnelID,
+			Host:        hop.Host,
+			User:        hop.User,
+			Instruction: instruction,
+			Questions:   questions,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(b.timeout),
+		},
+		answers: make(chan []string, 1),
+	}
+
+	b.mu.Lock()
+	b.pending[p.prompt.ID] = p
+	callback := b.callback
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.pending, p.prompt.ID)
+		b.mu.Unlock()
+	}()
+
+	if callback != nil {
+		callback(p.prompt)
+	}
+
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+
+	select {
+	case answers := <-p.answers:
+		return answers, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("no answer to keyboard-interactive prompt from %s within %s", hop.Host, b.timeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// answer delivers answers to a pending prompt
+func (b *promptBroker) answer(id string, answers []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p, ok := b.pending[id]
+	if !ok {
+		return ErrPromptNotFound
+	}
+	if len(answers) != len(p.prompt.Questions) {
+		return fmt.Errorf("expected %d answers, got %d", len(p.prompt.Questions), len(answers))
+	}
+
+	// Removed here so a second answer can't race the first
+	delete(b.pending, id)
+	p.answers <- answers
+	return nil
+}
+
+// list returns pending prompts, oldest first
+func (b *promptBroker) list() []AuthPrompt {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	prompts := make([]AuthPrompt, 0, len(b.pending))
+	for _, p := range b.pending {
+		prompts = append(prompts, p.prompt)
+	}
+	sort.Slice(prompts, func(i, j int) bool {
+		return prompts[i].CreatedAt.Before(prompts[j].CreatedAt)
+	})
+	return prompts
+}
+
+// PendingPrompts returns keyboard-interactive challenges waiting for an answer
+func (m *Manager) PendingPrompts() []AuthPrompt {
+	return m.prompts.list()
+}
+
+// AnswerPrompt answers a pending challenge so its connection can proceed.
+// There must be one answer per question.
+func (m *Manager) AnswerPrompt(id string, answers []string) error {
+	return m.prompts.answer(id, answers)
+}
+
+// SetPromptCallback sets a callback invoked whenever a new challenge needs
+// answering, replacing any callback set previously
+func (m *Manager) SetPromptCallback(cb PromptCallback) {
+	m.prompts.mu.Lock()
+	defer m.prompts.mu.Unlock()
+	m.prompts.callback = cb
+}
+
+// promptFunc returns the PromptFunc sessions of tunnelID use to reach the broker
+func (m *Manager) promptFunc(tunnelID string) PromptFunc {
+	return func(ctx context.Context, hop *types.Hop, instruction string, questions []AuthPromptQuestion) ([]string, error) {
+		return m.prompts.ask(ctx, tunnelID, hop, instruction, questions)
+	}
+}
@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestPromptBrokerAnswer(t *testing.T) {
+	broker := newPromptBroker(time.Second)
+	hop := &types.Hop{Host: "bastion", User: "deploy"}
+
+	announced := make(chan AuthPrompt, 1)
+	broker.callback = func(p AuthPrompt) { announced <- p }
+
+	type result struct {
+		answers []string
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		answers, err := broker.ask(context.Background(), "t1", hop, "Duo", []AuthPromptQuestion{{Text: "Passcode: "}})
+		done <- result{answers, err}
+	}()
+
+	prompt := <-announced
+	if got := broker.list(); len(got) != 1 || got[0].ID != prompt.ID {
+		t.Fatalf("list() = %+v, want the announced prompt", got)
+	}
+
+	if err := broker.answer(prompt.ID, []string{"1", "2"}); err == nil {
+		t.Error("answer() with the wrong number of answers succeeded")
+	}
+	if err := broker.answer("missing", []string{"1"}); !errors.Is(err, ErrPromptNotFound) {
+		t.Errorf("answer() for unknown prompt = %v, want ErrPromptNotFound", err)
+	}
+	if err := broker.answer(prompt.ID, []string{"123456"}); err != nil {
+		t.Fatalf("answer() error = %v", err)
+	}
+	if err := broker.answer(prompt.ID, []string{"123456"}); !errors.Is(err, ErrPromptNotFound) {
+		t.Errorf("second answer() = %v, want ErrPromptNotFound", err)
+	}
+
+	res := <-done
+	if res.err != nil || len(res.answers) != 1 || res.answers[0] != "123456" {
+		t.Errorf("ask() = %v, %v; want [123456]", res.answers, res.err)
+	}
+	if got := broker.list(); len(got) != 0 {
+		t.Errorf("list() after answer = %+v, want empty", got)
+	}
+}
+
+func TestPromptBrokerTimeout(t *testing.T) {
+	broker := newPromptBroker(50 * time.Millisecond)
+	hop := &types.Hop{Host: "bastion"}
+
+	_, err := broker.ask(context.Background(), "t1", hop, "", []AuthPromptQuestion{{Text: "Passcode: "}})
+	if err == nil {
+		t.Fatal("ask() succeeded without an answer, want timeout error")
+	}
+	if got := broker.list(); len(got) != 0 {
+		t.Errorf("list() after timeout = %+v, want empty", got)
+	}
+}
+
+func TestPromptBrokerCancel(t *testing.T) {
+	broker := newPromptBroker(time.Minute)
+	hop := &types.Hop{Host: "bastion"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := broker.ask(ctx, "t1", hop, "", []AuthPromptQuestion{{Text: "Passcode: "}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ask() with cancelled context = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,188 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// copyBufferSize is the size of pooled proxy buffers. SSH channels deliver
+// at most one 32KB packet per read, so 64KB keeps writes to TCP large.
+const copyBufferSize = 64 * 1024
+
+// copyBufPool recycles proxy buffers across connections
+var copyBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+// activityClock records the last activity time without taking a lock
+type activityClock struct {
+	nanos atomic.Int64
+}
+
+// Touch marks now as the last activity time
+func (a *activityClock) Touch() {
+	a.nanos.Store(time.Now().UnixNano())
+}
+
+// Time returns the last activity time
+func (a *activityClock) Time() time.Time {
+	nanos := a.nanos.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// proxyConns copies data bidirectionally between near (the client side) and
+// far (the side across the tunnel) until both directions finish.
+// Bytes written to far count as sent, bytes written to near as received,
+// in stats and in tracked's own counters unless it is nil.
+// If idleTimeout is positive, both connections are closed once no data has
+// moved in either direction for that long.
+func proxyConns(near, far net.Conn, stats *forwarderCounters, activity *activityClock, idleTimeout time.Duration, tracked *trackedConn) {
+	var connActivity activityClock
+	connActivity.Touch()
+
+	// Splicing only reports activity once the stream ends, which would trip
+	// the idle watchdog, so reserve it for connections without one
+	allowSplice := idleTimeout <= 0
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	stats.add(2, &stats.goroutines)
+
+	sent := func(n int64) {
+		stats.add(n, &stats.bytesSent)
+		if tracked != nil {
+			atomic.AddInt64(&tracked.sent, n)
+		}
+	}
+	received := func(n int64) {
+		stats.add(n, &stats.bytesReceived)
+		if tracked != nil {
+			atomic.AddInt64(&tracked.received, n)
+		}
+	}
+	buffers := func(n int64) { stats.add(n, &stats.bufferBytes) }
+
+	// Near -> Far
+	go func() {
+		defer wg.Done()
+		defer stats.add(-1, &stats.goroutines)
+		copyStream(far, near, sent, buffers, allowSplice, activity, &connActivity)
+	}()
+
+	// Far -> Near
+	go func() {
+		defer wg.Done()
+		defer stats.add(-1, &stats.goroutines)
+		copyStream(near, far, received, buffers, allowSplice, activity, &connActivity)
+	}()
+
+	if idleTimeout <= 0 {
+		wg.Wait()
+		return
+	}
+
+	done := make(chan struct{})
+	stats.add(1, &stats.goroutines)
+	go func() {
+		defer stats.add(-1, &stats.goroutines)
+		wg.Wait()
+		close(done)
+	}()
+
+	// Idle watchdog: a per-direction read deadline would cut off one-way
+	// streams, so track activity across both directions instead
+	ticker := time.NewTicker(idleCheckInterval(idleTimeout))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if time.Since(connActivity.Time()) >= idleTimeout {
+				stats.add(1, &stats.timeouts)
+				near.Close()
+				far.Close()
+				<-done
+				return
+			}
+		}
+	}
+}
+
+// idleCheckInterval returns how often to check a connection for idleness
+func idleCheckInterval(idleTimeout time.Duration) time.Duration {
+	interval := idleTimeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	if interval > 5*time.Second {
+		interval = 5 * time.Second
+	}
+	return interval
+}
+
+// copyStream copies src to dst, counting bytes as they are written so stats
+// and activity stay current on long-lived connections.
+// The size of the pooled buffer is passed to buffers while it is in use,
+// and its negation once it isn't.
+func copyStream(dst, src net.Conn, count, buffers func(n int64), allowSplice bool, clocks ...*activityClock) (int64, error) {
+	// TCP to TCP: let the runtime use splice(2) where available
+	if _, ok := dst.(*net.TCPConn); ok && allowSplice {
+		if _, ok := src.(*net.TCPConn); ok {
+			n, err := io.Copy(dst, src)
+			count(n)
+			touchAll(clocks)
+			return n, err
+		}
+	}
+
+	bufp := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(bufp)
+	buf := *bufp
+
+	buffers(int64(len(buf)))
+	defer buffers(-int64(len(buf)))
+
+	var written int64
+	for {
+		nr, readErr := src.Read(buf)
+		if nr > 0 {
+			nw, writeErr := dst.Write(buf[:nr])
+			if nw > 0 {
+				written += int64(nw)
+				count(int64(nw))
+				touchAll(clocks)
+			}
+			if writeErr != nil {
+				return written, writeErr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+		if readErr != nil {
+			if readErr == io.EOF {
+				return written, nil
+			}
+			return written, readErr
+		}
+	}
+}
+
+// touchAll marks activity on every clock
+func touchAll(clocks []*activityClock) {
+	for _, clock := range clocks {
+		clock.Touch()
+	}
+}
@@ -0,0 +1,164 @@
+package tunnel
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProxyConnsCountsBytes(t *testing.T) {
+	clientConn, nearConn := net.Pipe()
+	farConn, serverConn := net.Pipe()
+
+	var stats forwarderCounters
+	var activity activityClock
+
+	done := make(chan struct{})
+	go func() {
+		proxyConns(nearConn, farConn, &stats, &activity, 0, nil)
+		close(done)
+	}()
+
+	// Echo server on the far side
+	go io.Copy(serverConn, serverConn)
+
+	payload := bytes.Repeat([]byte("x"), 100*1024)
+	go func() {
+		clientConn.Write(payload)
+	}()
+
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(clientConn, got); err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("Echoed payload does not match")
+	}
+
+	// Activity is recorded while the connection is still open
+	if activity.Time().IsZero() {
+		t.Error("Expected activity to be recorded")
+	}
+
+	serverConn.Close()
+	clientConn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("proxyConns did not return after both sides closed")
+	}
+
+	if n := stats.bytesSent.Load(); n != int64(len(payload)) {
+		t.Errorf("Expected BytesSent %d, got %d", len(payload), n)
+	}
+	if n := stats.bytesReceived.Load(); n != int64(len(payload)) {
+		t.Errorf("Expected BytesReceived %d, got %d", len(payload), n)
+	}
+}
+
+func TestProxyConnsIdleTimeout(t *testing.T) {
+	clientConn, nearConn := net.Pipe()
+	farConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	var stats forwarderCounters
+	var activity activityClock
+
+	done := make(chan struct{})
+	go func() {
+		proxyConns(nearConn, farConn, &stats, &activity, 100*time.Millisecond, nil)
+		close(done)
+	}()
+
+	// Neither side sends anything; the watchdog should close both
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("proxyConns did not close idle connection")
+	}
+
+	if n := stats.timeouts.Load(); n != 1 {
+		t.Errorf("Expected 1 timeout, got %d", n)
+	}
+}
+
+// legacyProxy is the previous per-forwarder proxy loop, kept for comparison
+func legacyProxy(near, far net.Conn, stats *ForwarderStats, mu *sync.Mutex) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		n, _ := io.Copy(far, near)
+		atomic.AddInt64(&stats.BytesSent, n)
+		mu.Lock()
+		stats.LastActivity = time.Now()
+		mu.Unlock()
+	}()
+
+	go func() {
+		defer wg.Done()
+		n, _ := io.Copy(near, far)
+		atomic.AddInt64(&stats.BytesReceived, n)
+		mu.Lock()
+		stats.LastActivity = time.Now()
+		mu.Unlock()
+	}()
+
+	wg.Wait()
+}
+
+// benchmarkProxy pushes payloadSize bytes through a fresh proxied connection
+// per iteration, across GOMAXPROCS concurrent connections
+func benchmarkProxy(b *testing.B, proxy func(near, far net.Conn)) {
+	const payloadSize = 1 << 20
+	payload := make([]byte, payloadSize)
+
+	b.SetBytes(payloadSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			clientConn, nearConn := net.Pipe()
+			farConn, serverConn := net.Pipe()
+
+			done := make(chan struct{})
+			go func() {
+				proxy(nearConn, farConn)
+				close(done)
+			}()
+
+			go func() {
+				clientConn.Write(payload)
+				clientConn.Close()
+			}()
+
+			io.CopyN(io.Discard, serverConn, payloadSize)
+			serverConn.Close()
+			<-done
+		}
+	})
+}
+
+func BenchmarkProxyConns(b *testing.B) {
+	var stats forwarderCounters
+	var activity activityClock
+	benchmarkProxy(b, func(near, far net.Conn) {
+		proxyConns(near, far, &stats, &activity, 0, nil)
+	})
+}
+
+func BenchmarkLegacyProxy(b *testing.B) {
+	var stats ForwarderStats
+	var mu sync.Mutex
+	benchmarkProxy(b, func(near, far net.Conn) {
+		legacyProxy(near, far, &stats, &mu)
+	})
+}
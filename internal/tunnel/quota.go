@@ -0,0 +1,232 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// quotaMeterInterval is how often tunnel bandwidth is measured for quotas
+const quotaMeterInterval = 5 * time.Second
+
+// Resources limited by quotas, as reported in QuotaError
+const (
+	QuotaTunnels   = "tunnels"
+	QuotaActive    = "active"
+	QuotaBandwidth = "bandwidth"
+)
+
+// ErrQuotaExceeded is returned, as a QuotaError, when creating or starting
+// a tunnel would exceed a quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaLimits caps the tunnels of one user or one project. Zero fields are
+// unlimited.
+type QuotaLimits struct {
+	MaxTunnels   int   `json:"maxTunnels"`   // tunnels, running or not; deleted ones don't count
+	MaxActive    int   `json:"maxActive"`    // tunnels running or connecting at once
+	MaxBandwidth int64 `json:"maxBandwidth"` // bytes/second, sent and received, across running tunnels
+}
+
+// Quotas are the limits applied to each user and to each project
+type Quotas struct {
+	User    QuotaLimits
+	Project QuotaLimits
+}
+
+// QuotaUsage is what the tunnels of a user or project currently use
+type QuotaUsage struct {
+	Tunnels   int   `json:"tunnels"`
+	Active    int   `json:"active"`
+	Bandwidth int64 `json:"bandwidth"` // bytes/second, as last measured
+}
+
+// QuotaError reports the quota a create or start would exceed
+type QuotaError struct {
+	Scope    string // "user" or "project"
+	Name     string // the user or project
+	Resource string // QuotaTunnels, QuotaActive or QuotaBandwidth
+	Limit    int64
+	Used     int64
+}
+
+func (e *QuotaError) Error() string {
+	unit := "tunnels"
+	switch e.Resource {
+	case QuotaActive:
+		unit = "active tunnels"
+	case QuotaBandwidth:
+		unit = "bytes/s of bandwidth"
+	}
+	return fmt.Sprintf("%v: %s %q uses %d of its %d %s", ErrQuotaExceeded, e.Scope, e.Name, e.Used, e.Limit, unit)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// SetQuotas sets the limits Create, Start and Restore enforce. Bandwidth
+// is measured every few seconds; while a user or project is over its
+// bandwidth quota none of its tunnels start, but running ones aren't
+// throttled.
+func (m *Manager) SetQuotas(quotas Quotas) {
+	m.quotaMu.Lock()
+	m.quotas = quotas
+	startMeter := !m.metering && (quotas.User.MaxBandwidth > 0 || quotas.Project.MaxBandwidth > 0)
+	if startMeter {
+		m.metering = true
+	}
+	m.quotaMu.Unlock()
+
+	if startMeter {
+		go m.meterBandwidth(quotaMeterInterval)
+	}
+}
+
+// Quotas returns the limits in force
+func (m *Manager) Quotas() Quotas {
+	m.quotaMu.RLock()
+	defer m.quotaMu.RUnlock()
+	return m.quotas
+}
+
+// UserUsage reports what a user's tunnels currently use
+func (m *Manager) UserUsage(owner string) QuotaUsage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.usageLocked(func(spec *types.TunnelSpec) bool { return spec.Owner == owner })
+}
+
+// ProjectUsage reports what a project's tunnels currently use
+func (m *Manager) ProjectUsage(project string) QuotaUsage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.usageLocked(func(spec *types.TunnelSpec) bool { return spec.ProjectName() == project })
+}
+
+// CheckStartQuota returns a QuotaError if starting a tunnel would exceed a
+// quota, for callers that start tunnels elsewhere, e.g. on remote agents
+func (m *Manager) CheckStartQuota(tunnelID string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tunnel, exists := m.tunnels[tunnelID]
+	if !exists {
+		return fmt.Errorf("tunnel %s not found", tunnelID)
+	}
+	return m.checkQuotaLocked(tunnel.Spec, false, true)
+}
+
+// usageLocked adds up the usage of the live tunnels matching a filter.
+// m.mu must be held.
+func (m *Manager) usageLocked(match func(*types.TunnelSpec) bool) QuotaUsage {
+	m.quotaMu.RLock()
+	bandwidth := m.bandwidth
+	m.quotaMu.RUnlock()
+
+	var usage QuotaUsage
+	for id, t := range m.tunnels {
+		if !match(t.Spec) {
+			continue
+		}
+		usage.Tunnels++
+		if status := t.GetStatus(); status != nil &&
+			(status.State == types.TunnelStatePending || status.State == types.TunnelStateActive) {
+			usage.Active++
+			usage.Bandwidth += bandwidth[id]
+		}
+	}
+	return usage
+}
+
+// checkQuotaLocked returns a QuotaError if adding a tunnel (creating) or
+// running it (starting) would exceed its owner's or project's quota. The
+// tunnel itself isn't counted, so it is never held against its own limit.
+// m.mu must be held.
+func (m *Manager) checkQuotaLocked(spec *types.TunnelSpec, creating, starting bool) error {
+	quotas := m.Quotas()
+	project := spec.ProjectName()
+
+	scopes := []struct {
+		scope, name string
+		limits      QuotaLimits
+		match       func(*types.TunnelSpec) bool
+	}{
+		{"user", spec.Owner, quotas.User, func(s *types.TunnelSpec) bool { return s.Owner == spec.Owner }},
+		{"project", project, quotas.Project, func(s *types.TunnelSpec) bool { return s.ProjectName() == project }},
+	}
+
+	for _, sc := range scopes {
+		if sc.limits == (QuotaLimits{}) {
+			continue
+		}
+		usage := m.usageLocked(func(s *types.TunnelSpec) bool { return s.ID != spec.ID && sc.match(s) })
+		exceeded := func(resource string, limit, used int64) error {
+			return &QuotaError{Scope: sc.scope, Name: sc.name, Resource: resource, Limit: limit, Used: used}
+		}
+
+		if creating && sc.limits.MaxTunnels > 0 && usage.Tunnels >= sc.limits.MaxTunnels {
+			return exceeded(QuotaTunnels, int64(sc.limits.MaxTunnels), int64(usage.Tunnels))
+		}
+		if !starting {
+			continue
+		}
+		if sc.limits.MaxActive > 0 && usage.Active >= sc.limits.MaxActive {
+			return exceeded(QuotaActive, int64(sc.limits.MaxActive), int64(usage.Active))
+		}
+		if sc.limits.MaxBandwidth > 0 && usage.Bandwidth >= sc.limits.MaxBandwidth {
+			return exceeded(QuotaBandwidth, sc.limits.MaxBandwidth, usage.Bandwidth)
+		}
+	}
+	return nil
+}
+
+// trafficSample is a tunnel's byte count at a point in time
+type trafficSample struct {
+	bytes int64
+	at    time.Time
+}
+
+// meterBandwidth measures the throughput of every tunnel each interval
+// until the manager's context is cancelled
+func (m *Manager) meterBandwidth(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := make(map[string]trafficSample)
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			last = m.measureBandwidth(last, now)
+		}
+	}
+}
+
+// measureBandwidth records each tunnel's throughput since the previous
+// samples and returns the new ones
+func (m *Manager) measureBandwidth(last map[string]trafficSample, now time.Time) map[string]trafficSample {
+	tunnels := m.List()
+	samples := make(map[string]trafficSample, len(tunnels))
+	rates := make(map[string]int64, len(tunnels))
+
+	for _, t := range tunnels {
+		stats := t.Stats()
+		sample := trafficSample{bytes: stats.BytesSent + stats.BytesReceived, at: now}
+		samples[t.Spec.ID] = sample
+
+		// Counters reset when a tunnel reconnects
+		if prev, ok := last[t.Spec.ID]; ok && sample.bytes >= prev.bytes && now.After(prev.at) {
+			rates[t.Spec.ID] = int64(float64(sample.bytes-prev.bytes) / now.Sub(prev.at).Seconds())
+		}
+	}
+
+	m.quotaMu.Lock()
+	m.bandwidth = rates
+	m.quotaMu.Unlock()
+
+	return samples
+}
@@ -0,0 +1,95 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestQuotas(t *testing.T) {
+	m := NewManager(context.Background())
+	m.SetQuotas(Quotas{
+		User:    QuotaLimits{MaxTunnels: 2},
+		Project: QuotaLimits{MaxActive: 1},
+	})
+
+	// Delegated to an agent, so starting only marks them pending
+	create := func(id, owner string) error {
+		return m.Create(context.Background(), &types.TunnelSpec{ID: id, Name: id, Owner: owner, Type: types.TunnelTypeLocal, AgentID: testAgentID})
+	}
+	for _, id := range []string{"a1", "a2"} {
+		if err := create(id, "alice"); err != nil {
+			t.Fatalf("Create %s failed: %v", id, err)
+		}
+	}
+
+	var qerr *QuotaError
+	err := create("a3", "alice")
+	if !errors.As(err, &qerr) || !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected a quota error creating a third tunnel, got %v", err)
+	}
+	if qerr.Scope != "user" || qerr.Resource != QuotaTunnels || qerr.Limit != 2 || qerr.Used != 2 {
+		t.Errorf("Unexpected quota error %+v", qerr)
+	}
+	if err := create("b1", "bob"); err != nil {
+		t.Errorf("Expected other users to be unaffected, got %v", err)
+	}
+
+	// Only one tunnel of the default project may run at a time
+	if err := m.Start(context.Background(), "a1"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	err = m.Start(context.Background(), "b1")
+	if !errors.As(err, &qerr) || qerr.Scope != "project" || qerr.Resource != QuotaActive {
+		t.Errorf("Expected the project's active quota to be exceeded, got %v", err)
+	}
+	if err := m.CheckStartQuota("a1"); err != nil {
+		t.Errorf("Expected a running tunnel not to count against itself, got %v", err)
+	}
+
+	usage := m.UserUsage("alice")
+	if usage != (QuotaUsage{Tunnels: 2, Active: 1}) {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+
+	// Deleting frees the slot; restoring takes it again
+	if err := m.Delete(context.Background(), "a2"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := create("a3", "alice"); err != nil {
+		t.Fatalf("Expected a free slot after deleting, got %v", err)
+	}
+	if _, err := m.Restore(context.Background(), "a2"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected restoring to exceed the quota, got %v", err)
+	}
+}
+
+func TestBandwidthQuota(t *testing.T) {
+	m := NewManager(context.Background())
+	m.SetQuotas(Quotas{User: QuotaLimits{MaxBandwidth: 1000}})
+
+	for _, id := range []string{"a1", "a2"} {
+		createTunnels(t, m, &types.TunnelSpec{ID: id, Name: id, Owner: "alice", Type: types.TunnelTypeLocal})
+	}
+	if err := m.Start(context.Background(), "a1"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	// A second ago a1's counters stood 2000 bytes lower
+	now := time.Now()
+	last := m.measureBandwidth(map[string]trafficSample{"a1": {bytes: -2000, at: now.Add(-time.Second)}}, now)
+	if _, ok := last["a2"]; !ok {
+		t.Error("Expected every tunnel to be sampled")
+	}
+	if usage := m.UserUsage("alice"); usage.Bandwidth != 2000 {
+		t.Fatalf("Expected 2000 bytes/s, got %+v", usage)
+	}
+
+	var qerr *QuotaError
+	if err := m.Start(context.Background(), "a2"); !errors.As(err, &qerr) || qerr.Resource != QuotaBandwidth {
+		t.Errorf("Expected the bandwidth quota to be exceeded, got %v", err)
+	}
+}
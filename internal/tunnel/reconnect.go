@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// ErrNotReconnectable is returned when reconnecting a tunnel that isn't
+// down, or that this instance doesn't run
+var ErrNotReconnectable = errors.New("tunnel can't be reconnected")
+
+// Reconnect makes the next attempt to connect a failed or reconnecting
+// tunnel right away, for when the cause has been fixed: it resets the
+// tunnel's circuit breaker, then cuts short the backoff of a reconnecting
+// session or a pending restart, or else rebuilds a failed tunnel from
+// scratch. It returns once the attempt is scheduled; the attempt itself
+// runs in the background, outliving the caller, and reports through the
+// tunnel's status.
+func (m *Manager) Reconnect(tunnelID string) error {
+	t, err := m.Get(tunnelID)
+	if err != nil {
+		return err
+	}
+	if !m.runOnThisNode(t.Spec.AgentID) {
+		return fmt.Errorf("%w: it runs on agent %s", ErrNotReconnectable, t.Spec.AgentID)
+	}
+	if m.standby.Load() {
+		return fmt.Errorf("%w: %s", ErrNotReconnectable, standbyMessage)
+	}
+
+	t.mu.Lock()
+	state := types.TunnelStateStopped
+	if t.Status != nil {
+		state = t.Status.State
+	}
+	switch state {
+	case types.TunnelStateActive:
+		t.mu.Unlock()
+		return fmt.Errorf("%w: it is active", ErrNotReconnectable)
+	case types.TunnelStateStopped:
+		t.mu.Unlock()
+		return fmt.Errorf("%w: it is stopped; start it instead", ErrNotReconnectable)
+	case types.TunnelStateMisconfigured:
+		t.mu.Unlock()
+		return fmt.Errorf("%w: it is misconfigured; fix it and start it", ErrNotReconnectable)
+	}
+
+	m.circuitBreaker.GetBreaker(tunnelID).Reset()
+
+	if t.restartPending && t.restartNow != nil {
+		select {
+		case t.restartNow <- struct{}{}:
+		default:
+		}
+		t.mu.Unlock()
+		return nil
+	}
+	if (t.session != nil && t.session.RetryNow()) || (t.multiSession != nil && t.multiSession.RetryNow()) {
+		t.mu.Unlock()
+		return nil
+	}
+	if state != types.TunnelStateFailed {
+		t.mu.Unlock()
+		return fmt.Errorf("%w: a connection attempt is in progress", ErrNotReconnectable)
+	}
+
+	// Nothing is scheduled: start over with a fresh session and forwarder
+	_, _ = t.teardown(false)
+	t.mu.Unlock()
+
+	t.updateStatus(types.TunnelStatePending, "Reconnecting")
+	go m.connectTunnel(t)
+	return nil
+}
@@ -0,0 +1,106 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestReconnectSkipsRestartBackoff(t *testing.T) {
+	manager := NewManager(context.Background())
+
+	spec := newFailingSpec("reconnect-restart", types.RestartPolicy{
+		Mode:       types.RestartOnFailure,
+		MaxPerHour: 3,
+		Backoff:    time.Minute,
+	})
+	if err := manager.Create(context.Background(), spec); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	tunnel, _ := manager.Get(spec.ID)
+	waitForStatus(t, tunnel, "restart to be scheduled", func(s *types.TunnelStatus) bool {
+		return s.NextRetryAt != nil
+	})
+
+	// The restart runs at once, fails again and schedules the next one
+	if err := manager.Reconnect(spec.ID); err != nil {
+		t.Fatalf("Reconnect failed: %v", err)
+	}
+	waitForStatus(t, tunnel, "the restart to run", func(s *types.TunnelStatus) bool {
+		return s.RestartCount == 1 && s.RetryAttempt == 2
+	})
+
+	if err := manager.Stop(context.Background(), spec.ID); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if err := manager.Reconnect(spec.ID); !errors.Is(err, ErrNotReconnectable) {
+		t.Errorf("Reconnect of a stopped tunnel: error = %v, want ErrNotReconnectable", err)
+	}
+	if err := manager.Reconnect("missing"); err == nil || errors.Is(err, ErrNotReconnectable) {
+		t.Errorf("Reconnect of a missing tunnel: error = %v, want not found", err)
+	}
+}
+
+func TestReconnectFailedTunnel(t *testing.T) {
+	manager := NewManager(context.Background())
+
+	spec := newFailingSpec("reconnect-failed", types.RestartPolicy{})
+	if err := manager.Create(context.Background(), spec); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	tunnel, _ := manager.Get(spec.ID)
+	waitForStatus(t, tunnel, "the tunnel to fail", func(s *types.TunnelStatus) bool {
+		return s.State == types.TunnelStateFailed
+	})
+
+	// Trip the breaker; reconnecting resets it rather than failing fast
+	breaker := manager.circuitBreaker.GetBreaker(spec.ID)
+	for i := 0; i < breaker.maxFailures; i++ {
+		breaker.RecordFailure()
+	}
+	if err := manager.Reconnect(spec.ID); err != nil {
+		t.Fatalf("Reconnect failed: %v", err)
+	}
+	if state := breaker.State(); state == StateOpen {
+		t.Error("Expected reconnecting to close the circuit breaker")
+	}
+	status := waitForStatus(t, tunnel, "the tunnel to fail again", func(s *types.TunnelStatus) bool {
+		return s.State == types.TunnelStateFailed
+	})
+	if status.FailureReason == types.FailureCircuitOpen {
+		t.Errorf("Expected a connection attempt, got failure reason %s", status.FailureReason)
+	}
+}
+
+func TestSessionRetryNow(t *testing.T) {
+	addr, _ := startKillableSSHServer(t)
+
+	var session *Session
+	session = newReconnectingSession(t, addr, SessionConfig{
+		MaxRetries: 1,
+		OnRetry: func(time.Time, int, int) {
+			if !session.RetryNow() {
+				t.Error("Expected RetryNow() to cut the backoff short")
+			}
+		},
+	})
+	session.backoffConfig = BackoffConfig{Initial: time.Minute, Max: time.Minute, Multiplier: 2}
+
+	if session.RetryNow() {
+		t.Error("Expected RetryNow() to report a session that isn't backing off")
+	}
+
+	session.config = &ssh.ClientConfig{User: "testuser", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	start := time.Now()
+	if err := session.ConnectWithRetry(); err == nil {
+		t.Fatal("Expected ConnectWithRetry() to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the retry to skip the backoff, took %s", elapsed)
+	}
+}
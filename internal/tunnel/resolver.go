@@ -0,0 +1,265 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// DefaultDNSTimeout is how long a lookup may take unless configured
+const DefaultDNSTimeout = 5 * time.Second
+
+// DNSConfig configures how the server resolves the hostnames of first hops,
+// the ones it connects to itself. Later hops and destinations are resolved
+// by the hop before them.
+type DNSConfig struct {
+	Servers     []string      // host:port of DNS servers to ask instead of the system's; port 53 if left out
+	Timeout     time.Duration // per lookup; 0 = DefaultDNSTimeout
+	CacheTTL    time.Duration // how long an answer is reused; 0 looks up every connection
+	NegativeTTL time.Duration // how long a failed lookup is reported again without asking; 0 = not at all
+	StaleTTL    time.Duration // how long past CacheTTL an answer is still used while lookups fail
+}
+
+// Resolver looks up hop hostnames, caching the answers so reconnects don't
+// wait on DNS each time, and riding out DNS outages on the last answer
+type Resolver struct {
+	config   DNSConfig
+	resolver *net.Resolver
+	lookup   func(ctx context.Context, host string) ([]string, error)
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]*dnsEntry
+
+	next atomic.Uint64 // server the next query goes to, round-robin
+}
+
+// dnsEntry is the last lookup of a hostname
+type dnsEntry struct {
+	addrs      []string // of the last successful lookup
+	resolvedAt time.Time
+	err        error // of the last lookup, if it failed
+	failedAt   time.Time
+}
+
+// DNSCacheEntry is a cached hostname lookup
+type DNSCacheEntry struct {
+	Host       string     `json:"host"`
+	Addrs      []string   `json:"addrs"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	Stale      bool       `json:"stale"`           // past the cache TTL, used while lookups fail
+	Error      string     `json:"error,omitempty"` // of the last lookup, if it failed
+	FailedAt   *time.Time `json:"failedAt,omitempty"`
+}
+
+// NewResolver returns a resolver asking config's servers, or the system's
+func NewResolver(config DNSConfig) *Resolver {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultDNSTimeout
+	}
+	r := &Resolver{config: config, now: time.Now, cache: make(map[string]*dnsEntry)}
+
+	r.resolver = net.DefaultResolver
+	if len(config.Servers) > 0 {
+		var dialer net.Dialer
+		r.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				server := config.Servers[r.next.Add(1)%uint64(len(config.Servers))]
+				if _, _, err := net.SplitHostPort(server); err != nil {
+					server = net.JoinHostPort(server, "53")
+				}
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	r.lookup = r.resolver.LookupHost
+	return r
+}
+
+// LookupHost returns the addresses of host, from the cache while the answer
+// is fresh. When a lookup fails, an answer up to StaleTTL past its expiry
+// is returned instead. A nil resolver asks the system every time.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return []string{host}, nil
+	}
+	if r == nil {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		return addrs, resolveError(host, err)
+	}
+
+	now := r.now()
+	r.mu.Lock()
+	entry := r.cache[host]
+	if entry != nil {
+		if entry.addrs != nil && now.Sub(entry.resolvedAt) < r.config.CacheTTL {
+			addrs := entry.addrs
+			r.mu.Unlock()
+			return addrs, nil
+		}
+		if entry.err != nil && now.Sub(entry.failedAt) < r.config.NegativeTTL {
+			err := entry.err
+			r.mu.Unlock()
+			return nil, err
+		}
+	}
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+	addrs, err := r.lookup(ctx, host)
+	err = resolveError(host, err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry = r.cache[host]
+	if entry == nil {
+		entry = &dnsEntry{}
+		if r.config.CacheTTL > 0 || r.config.NegativeTTL > 0 || r.config.StaleTTL > 0 {
+			r.cache[host] = entry
+		}
+	}
+	if err == nil {
+		entry.addrs, entry.resolvedAt, entry.err = addrs, r.now(), nil
+		return addrs, nil
+	}
+	entry.err, entry.failedAt = err, r.now()
+	if entry.addrs != nil && now.Sub(entry.resolvedAt) < r.config.CacheTTL+r.config.StaleTTL {
+		return entry.addrs, nil
+	}
+	return nil, err
+}
+
+// DialTimeout connects to address, trying each address its host resolves
+// to in turn. A nil resolver dials like net.DialTimeout.
+func (r *Resolver) DialTimeout(ctx context.Context, network, address string, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	if r == nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := r.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Entries returns the cached lookups, by hostname
+func (r *Resolver) Entries() []DNSCacheEntry {
+	if r == nil {
+		return []DNSCacheEntry{}
+	}
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make([]DNSCacheEntry, 0, len(r.cache))
+	for host, e := range r.cache {
+		entry := DNSCacheEntry{Host: host, Addrs: slices.Clone(e.addrs)}
+		if entry.Addrs == nil {
+			entry.Addrs = []string{}
+		}
+		if e.addrs != nil {
+			resolvedAt := e.resolvedAt
+			entry.ResolvedAt = &resolvedAt
+			entry.Stale = now.Sub(e.resolvedAt) >= r.config.CacheTTL
+		}
+		if e.err != nil {
+			failedAt := e.failedAt
+			entry.Error, entry.FailedAt = e.err.Error(), &failedAt
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Host < entries[j].Host })
+	return entries
+}
+
+// Flush forgets the cached lookups, returning how many there were
+func (r *Resolver) Flush() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.cache)
+	clear(r.cache)
+	return n
+}
+
+// ResolveError is a failed lookup of a hostname, explained in plain words
+// rather than by the resolver's internals
+type ResolveError struct {
+	Host string
+	Err  *net.DNSError
+}
+
+func (e *ResolveError) Error() string {
+	var what string
+	switch {
+	case e.Err.IsNotFound:
+		what = "no such host"
+	case e.Err.IsTimeout:
+		what = "the DNS server didn't answer in time"
+	default:
+		what = "the DNS server failed: " + e.Err.Err
+	}
+	if e.Err.Server != "" {
+		what += " (asked " + e.Err.Server + ")"
+	}
+	return fmt.Sprintf("failed to resolve %s: %s", e.Host, what)
+}
+
+func (e *ResolveError) Unwrap() error { return e.Err }
+
+// resolveError explains a failed lookup of host, returning other errors,
+// such as a cancelled context, as they are
+func resolveError(host string, err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return &ResolveError{Host: host, Err: dnsErr}
+	}
+	return err
+}
+
+// dnsFailureReason classifies a failed lookup: the name doesn't exist, or
+// DNS couldn't say
+func dnsFailureReason(err *net.DNSError) types.FailureReason {
+	if err.IsNotFound {
+		return types.FailureDNSNotFound
+	}
+	return types.FailureDNSUnavailable
+}
+
+// SetDNS makes the manager's tunnels resolve their first hop with config,
+// from their next connection on
+func (m *Manager) SetDNS(config DNSConfig) {
+	m.resolver.Store(NewResolver(config))
+}
+
+// DNSResolver returns the resolver set with SetDNS, or nil if none is
+func (m *Manager) DNSResolver() *Resolver {
+	return m.resolver.Load()
+}
@@ -0,0 +1,139 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// fakeLookups answers lookups from answers, failing with err instead while
+// it is set, and counts them
+type fakeLookups struct {
+	answers map[string][]string
+	err     error
+	count   int
+}
+
+func (f *fakeLookups) lookup(ctx context.Context, host string) ([]string, error) {
+	f.count++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if addrs, ok := f.answers[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func newFakeResolver(config DNSConfig, lookups *fakeLookups) (*Resolver, *time.Time) {
+	now := time.Now()
+	r := NewResolver(config)
+	r.lookup = lookups.lookup
+	r.now = func() time.Time { return now }
+	return r, &now
+}
+
+func TestResolverCaches(t *testing.T) {
+	lookups := &fakeLookups{answers: map[string][]string{"bastion": {"10.0.0.1"}}}
+	r, now := newFakeResolver(DNSConfig{CacheTTL: time.Minute, NegativeTTL: 5 * time.Second, StaleTTL: time.Hour}, lookups)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if addrs, err := r.LookupHost(ctx, "bastion"); err != nil || addrs[0] != "10.0.0.1" {
+			t.Fatalf("LookupHost() = %v, %v", addrs, err)
+		}
+	}
+	if lookups.count != 1 {
+		t.Errorf("Looked up %d times, want the answer cached", lookups.count)
+	}
+
+	// IP addresses aren't looked up
+	if addrs, err := r.LookupHost(ctx, "::1"); err != nil || addrs[0] != "::1" || lookups.count != 1 {
+		t.Errorf("LookupHost(::1) = %v, %v after %d lookups", addrs, err, lookups.count)
+	}
+
+	// Past the TTL, a failing lookup falls back on the last answer
+	*now = now.Add(2 * time.Minute)
+	lookups.err = &net.DNSError{Err: "server misbehaving", Name: "bastion", Server: "10.0.0.53:53", IsTemporary: true}
+	if addrs, err := r.LookupHost(ctx, "bastion"); err != nil || addrs[0] != "10.0.0.1" {
+		t.Errorf("LookupHost() = %v, %v, want the stale answer", addrs, err)
+	}
+	entries := r.Entries()
+	if len(entries) != 1 || !entries[0].Stale || !strings.Contains(entries[0].Error, "server misbehaving") {
+		t.Errorf("Entries() = %+v, want the stale answer and the failure", entries)
+	}
+
+	// Once stale for too long, the failure is returned, explained
+	*now = now.Add(2 * time.Hour)
+	_, err := r.LookupHost(ctx, "bastion")
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) || ClassifyFailure(err) != types.FailureDNSUnavailable {
+		t.Fatalf("LookupHost() error = %v, want a DNS failure", err)
+	}
+	if want := "failed to resolve bastion: the DNS server failed: server misbehaving (asked 10.0.0.53:53)"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err, want)
+	}
+
+	// and reported again without asking for NegativeTTL
+	count := lookups.count
+	lookups.err = nil
+	if _, err := r.LookupHost(ctx, "bastion"); err == nil || lookups.count != count {
+		t.Errorf("Expected the failure cached, got %v after %d lookups", err, lookups.count-count)
+	}
+	*now = now.Add(10 * time.Second)
+	if _, err := r.LookupHost(ctx, "bastion"); err != nil {
+		t.Errorf("LookupHost() error = %v after the failure expired", err)
+	}
+
+	if n := r.Flush(); n != 1 || len(r.Entries()) != 0 {
+		t.Errorf("Flush() = %d, leaving %v", n, r.Entries())
+	}
+}
+
+func TestResolverWithoutCache(t *testing.T) {
+	lookups := &fakeLookups{answers: map[string][]string{"bastion": {"10.0.0.1"}}}
+	r, _ := newFakeResolver(DNSConfig{}, lookups)
+
+	for i := 0; i < 2; i++ {
+		r.LookupHost(context.Background(), "bastion")
+	}
+	if lookups.count != 2 || len(r.Entries()) != 0 {
+		t.Errorf("Looked up %d times, cached %v; want every connection looked up", lookups.count, r.Entries())
+	}
+
+	_, err := r.LookupHost(context.Background(), "bastoin")
+	if ClassifyFailure(err) != types.FailureDNSNotFound || err.Error() != "failed to resolve bastoin: no such host" {
+		t.Errorf("LookupHost() error = %v (%s)", err, ClassifyFailure(err))
+	}
+}
+
+func TestResolverDialTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// Nothing listens on the first address; the next one is tried
+	lookups := &fakeLookups{answers: map[string][]string{"bastion": {"127.0.0.2", "127.0.0.1"}}}
+	r, _ := newFakeResolver(DNSConfig{CacheTTL: time.Minute}, lookups)
+
+	conn, err := r.DialTimeout(context.Background(), "tcp", net.JoinHostPort("bastion", port), time.Second)
+	if err != nil {
+		t.Fatalf("DialTimeout() error = %v", err)
+	}
+	conn.Close()
+
+	var none *Resolver
+	if conn, err := none.DialTimeout(context.Background(), "tcp", listener.Addr().String(), time.Second); err != nil {
+		t.Errorf("DialTimeout() on a nil resolver error = %v", err)
+	} else {
+		conn.Close()
+	}
+}
@@ -0,0 +1,146 @@
+package tunnel
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+const (
+	// DefaultMaxRestartsPerHour is the restart budget when a policy sets none
+	DefaultMaxRestartsPerHour = 10
+
+	// DefaultRestartBackoff is the delay before the first restart when a
+	// policy sets none
+	DefaultRestartBackoff = time.Second
+
+	// maxRestartBackoff caps the delay between restarts
+	maxRestartBackoff = 5 * time.Minute
+
+	// restartWindow is the period the restart budget applies to
+	restartWindow = time.Hour
+)
+
+// restartBackoff returns the delay before a restart, doubling base for each
+// restart already made within the window
+func restartBackoff(base time.Duration, recent int) time.Duration {
+	if base <= 0 {
+		base = DefaultRestartBackoff
+	}
+	delay := base
+	for i := 0; i < recent && delay < maxRestartBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRestartBackoff {
+		delay = maxRestartBackoff
+	}
+	return delay
+}
+
+// pruneRestarts drops restarts older than the window
+func pruneRestarts(restarts []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-restartWindow)
+	kept := restarts[:0]
+	for _, at := range restarts {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	return kept
+}
+
+// recentRestarts counts restarts within the window. Must be called with t.mu held.
+func (t *Tunnel) recentRestarts(now time.Time) int {
+	cutoff := now.Add(-restartWindow)
+	count := 0
+	for _, at := range t.restarts {
+		if at.After(cutoff) {
+			count++
+		}
+	}
+	return count
+}
+
+// scheduleRestart restarts a tunnel that went down in state from, if its
+// RestartPolicy allows it and the hourly budget isn't spent. The restart is
+// abandoned if the tunnel leaves that state (e.g. is stopped or started by a
+// user) or is deleted during the backoff.
+func (m *Manager) scheduleRestart(t *Tunnel, from types.TunnelState, cause error) {
+	policy := t.Spec.Restart
+	switch policy.Mode {
+	case types.RestartOnFailure:
+		if from != types.TunnelStateFailed {
+			return
+		}
+	case types.RestartAlways:
+	default:
+		return
+	}
+
+	maxPerHour := policy.MaxPerHour
+	if maxPerHour <= 0 {
+		maxPerHour = DefaultMaxRestartsPerHour
+	}
+
+	t.mu.Lock()
+	if t.restartPending {
+		t.mu.Unlock()
+		return
+	}
+	t.restarts = pruneRestarts(t.restarts, time.Now())
+	recent := len(t.restarts)
+	if recent >= maxPerHour {
+		t.mu.Unlock()
+		t.updateFailure(from, ClassifyFailure(cause), fmt.Sprintf("Restart budget exhausted (%d restarts in the last hour): %v", recent, cause))
+		return
+	}
+	t.restartPending = true
+	t.restartNow = make(chan struct{}, 1)
+	delay := restartBackoff(policy.Backoff, recent)
+	wake := t.restartNow
+	t.mu.Unlock()
+
+	t.setNextRetry(time.Now().Add(delay), recent+1, maxPerHour)
+
+	go m.restartAfter(t, from, delay, maxPerHour, cause, wake)
+}
+
+// restartAfter waits out the backoff, or until woken, then
+// reconnects the tunnel from scratch
+func (m *Manager) restartAfter(t *Tunnel, from types.TunnelState, delay time.Duration, maxPerHour int, cause error, wake <-chan struct{}) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-wake:
+	case <-m.ctx.Done():
+	case <-t.ctx.Done():
+	}
+	_ = m.waitRetry(t.ctx)
+
+	current, err := m.Get(t.Spec.ID)
+
+	t.mu.Lock()
+	t.restartPending = false
+	t.restartNow = nil
+	if err != nil || current != t || m.ctx.Err() != nil || t.ctx.Err() != nil ||
+		t.Status == nil || t.Status.State != from {
+		t.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	t.restarts = append(t.restarts, now)
+	t.Status.RestartCount++
+	t.Status.LastRestartAt = &now
+	attempt := len(t.restarts)
+
+	// Start over with a fresh session and forwarder
+	_, _ = t.teardown(false)
+	t.mu.Unlock()
+
+	t.updateStatus(types.TunnelStatePending, fmt.Sprintf("Restarting (%d/%d this hour) after: %v", attempt, maxPerHour, cause))
+	m.connectTunnel(t)
+}
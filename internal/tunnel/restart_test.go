@@ -0,0 +1,179 @@
+package tunnel
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// newFailingSpec returns a tunnel that fails to initialize immediately
+func newFailingSpec(id string, policy types.RestartPolicy) *types.TunnelSpec {
+	return &types.TunnelSpec{
+		ID:         id,
+		Name:       id,
+		Type:       types.TunnelTypeLocal,
+		RemoteHost: "example.com",
+		RemotePort: 80,
+		Restart:    policy,
+	}
+}
+
+// waitForStatus polls a tunnel's status until ok returns true
+func waitForStatus(t *testing.T, tunnel *Tunnel, desc string, ok func(*types.TunnelStatus) bool) *types.TunnelStatus {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		status := tunnel.GetStatus()
+		if status != nil && ok(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s, status: %+v", desc, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	tests := []struct {
+		base   time.Duration
+		recent int
+		want   time.Duration
+	}{
+		{0, 0, DefaultRestartBackoff},
+		{time.Second, 0, time.Second},
+		{time.Second, 3, 8 * time.Second},
+		{time.Minute, 10, maxRestartBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := restartBackoff(tt.base, tt.recent); got != tt.want {
+			t.Errorf("restartBackoff(%s, %d) = %s, want %s", tt.base, tt.recent, got, tt.want)
+		}
+	}
+}
+
+func TestRestartOnFailureRespectsBudget(t *testing.T) {
+	manager := NewManager(context.Background())
+
+	restarting := make(chan struct{}, 8)
+	sub := manager.Subscribe(func(_ string, status *types.TunnelStatus) {
+		if status.State == types.TunnelStatePending && strings.HasPrefix(status.LastError, "Restarting") {
+			restarting <- struct{}{}
+		}
+	}, 0)
+	defer sub.Unsubscribe()
+
+	spec := newFailingSpec("restart-budget", types.RestartPolicy{
+		Mode:       types.RestartOnFailure,
+		MaxPerHour: 2,
+		Backoff:    5 * time.Millisecond,
+	})
+	if err := manager.Create(context.Background(), spec); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	tunnel, _ := manager.Get(spec.ID)
+
+	status := waitForStatus(t, tunnel, "restart budget to run out", func(s *types.TunnelStatus) bool {
+		return strings.HasPrefix(s.LastError, "Restart budget exhausted")
+	})
+
+	if status.State != types.TunnelStateFailed {
+		t.Errorf("Expected tunnel to stay failed, got %s", status.State)
+	}
+	if status.RestartCount != 2 || status.RestartsLastHour != 2 {
+		t.Errorf("Expected 2 restarts, got %d (%d in the last hour)", status.RestartCount, status.RestartsLastHour)
+	}
+	if status.LastRestartAt == nil {
+		t.Error("Expected last restart time to be set")
+	}
+
+	// Each restart is announced
+	for i := 0; i < 2; i++ {
+		select {
+		case <-restarting:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected restart event %d", i+1)
+		}
+	}
+}
+
+func TestRestartNeverByDefault(t *testing.T) {
+	manager := NewManager(context.Background())
+
+	spec := newFailingSpec("restart-never", types.RestartPolicy{})
+	if err := manager.Create(context.Background(), spec); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	tunnel, _ := manager.Get(spec.ID)
+
+	waitForStatus(t, tunnel, "tunnel to fail", func(s *types.TunnelStatus) bool {
+		return s.State == types.TunnelStateFailed
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	if status := tunnel.GetStatus(); status.RestartCount != 0 || status.State != types.TunnelStateFailed {
+		t.Errorf("Expected no restarts, got %d (state %s)", status.RestartCount, status.State)
+	}
+}
+
+func TestRestartAbandonedWhenStopped(t *testing.T) {
+	manager := NewManager(context.Background())
+
+	spec := newFailingSpec("restart-stopped", types.RestartPolicy{
+		Mode:    types.RestartAlways,
+		Backoff: 100 * time.Millisecond,
+	})
+	if err := manager.Create(context.Background(), spec); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	tunnel, _ := manager.Get(spec.ID)
+
+	waitForStatus(t, tunnel, "tunnel to fail", func(s *types.TunnelStatus) bool {
+		return s.State == types.TunnelStateFailed
+	})
+
+	// A user stop during the backoff is final
+	if err := manager.Stop(context.Background(), spec.ID); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	time.Sleep(250 * time.Millisecond)
+
+	if status := tunnel.GetStatus(); status.RestartCount != 0 || status.State != types.TunnelStateStopped {
+		t.Errorf("Expected tunnel to stay stopped without restarts, got %d (state %s)", status.RestartCount, status.State)
+	}
+}
+
+func TestRestartReportsNextRetry(t *testing.T) {
+	manager := NewManager(context.Background())
+
+	spec := newFailingSpec("restart-next", types.RestartPolicy{
+		Mode:       types.RestartOnFailure,
+		MaxPerHour: 3,
+		Backoff:    time.Minute,
+	})
+	if err := manager.Create(context.Background(), spec); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	tunnel, _ := manager.Get(spec.ID)
+
+	status := waitForStatus(t, tunnel, "restart to be scheduled", func(s *types.TunnelStatus) bool {
+		return s.NextRetryAt != nil
+	})
+	if status.State != types.TunnelStateFailed || status.RetryAttempt != 1 || status.MaxRetryAttempts != 3 {
+		t.Errorf("Expected failed awaiting restart 1 of 3, got %s awaiting %d of %d", status.State, status.RetryAttempt, status.MaxRetryAttempts)
+	}
+	if wait := time.Until(*status.NextRetryAt); wait < 50*time.Second || wait > time.Minute {
+		t.Errorf("Expected the restart in about a minute, got %s", wait)
+	}
+
+	if err := manager.Stop(context.Background(), spec.ID); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if status := tunnel.GetStatus(); status.NextRetryAt != nil || status.RetryAttempt != 0 {
+		t.Errorf("Expected stopping to cancel the scheduled restart, got %v (attempt %d)", status.NextRetryAt, status.RetryAttempt)
+	}
+}
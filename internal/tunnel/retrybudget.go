@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RetryBudget caps how fast all of a manager's tunnels together retry
+// connecting, reconnects and restarts alike, so that hundreds of tunnels
+// through a bastion that goes down don't all hammer it when it comes back.
+// First connections aren't limited.
+type RetryBudget struct {
+	Rate  float64 // attempts per second; 0 = unlimited
+	Burst int     // attempts allowed at once before Rate applies; 0 = 1
+}
+
+// retryLimiter is a token bucket: attempts take a token, reserving one in
+// advance when there is none left, and tokens come back at the budget's rate
+type retryLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRetryLimiter(budget RetryBudget) *retryLimiter {
+	if budget.Rate <= 0 {
+		return nil
+	}
+	burst := float64(max(budget.Burst, 1))
+	return &retryLimiter{rate: budget.Rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait blocks until the budget allows another attempt, or ctx is done
+func (l *retryLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Hand the reserved token back to the attempts still waiting
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// SetRetryBudget limits the retries of all tunnels, from now on
+func (m *Manager) SetRetryBudget(budget RetryBudget) {
+	m.retryLimiter.Store(newRetryLimiter(budget))
+}
+
+// waitRetry blocks until the retry budget allows another attempt, or ctx
+// is done
+func (m *Manager) waitRetry(ctx context.Context) error {
+	return m.retryLimiter.Load().wait(ctx)
+}
@@ -0,0 +1,55 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetryLimiter(t *testing.T) {
+	limiter := newRetryLimiter(RetryBudget{Rate: 20, Burst: 3})
+
+	// The burst goes at once, then attempts are spaced out at the rate
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatalf("wait() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected 2 attempts over the burst to take about 100ms, took %s", elapsed)
+	}
+
+	// A waiter that gives up hands its reservation back
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("wait() error = %v, want DeadlineExceeded", err)
+	}
+	start = time.Now()
+	if err := limiter.wait(context.Background()); err != nil {
+		t.Fatalf("wait() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 80*time.Millisecond {
+		t.Errorf("Expected the next attempt within one interval, took %s", elapsed)
+	}
+}
+
+func TestRetryLimiterUnlimited(t *testing.T) {
+	m := NewManager(context.Background())
+	for i := 0; i < 100; i++ {
+		if err := m.waitRetry(context.Background()); err != nil {
+			t.Fatalf("waitRetry() error = %v", err)
+		}
+	}
+
+	m.SetRetryBudget(RetryBudget{Rate: 1})
+	if err := m.waitRetry(context.Background()); err != nil {
+		t.Fatalf("waitRetry() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.waitRetry(ctx); err == nil {
+		t.Error("Expected the second attempt to wait for the budget")
+	}
+}
@@ -0,0 +1,131 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// ErrMisconfigured is returned when starting a tunnel whose spec can't work
+// as stored, e.g. because its key file is gone
+var ErrMisconfigured = errors.New("tunnel is misconfigured")
+
+// CheckSpec returns what would make a stored tunnel fail to start no matter
+// how often it is retried: hops that can't be dialed or authenticated to as
+// specified, and key files this machine doesn't have. It is empty for a
+// spec that may work.
+func CheckSpec(spec *types.TunnelSpec) []string {
+	if len(spec.Hops) == 0 {
+		return []string{"no hops"}
+	}
+
+	var problems []string
+	for i, hop := range spec.Hops {
+		report := func(format string, args ...interface{}) {
+			problems = append(problems, fmt.Sprintf("hop %d: ", i+1)+fmt.Sprintf(format, args...))
+		}
+
+		// Address book entries are checked when they are saved, and fill
+		// in what the hop leaves unset when it connects
+		if _, ok := types.AddressRef(hop.Host); ok {
+			continue
+		}
+		if !validHost(hop.Host) {
+			report("invalid host %q", hop.Host)
+		}
+		if hop.Port < 1 || hop.Port > 65535 {
+			report("invalid port %d", hop.Port)
+		}
+		if hop.User == "" {
+			report("no user")
+		}
+
+		// An attached hop reuses another tunnel's authenticated connection
+		if hop.Attach != "" {
+			continue
+		}
+		switch hop.AuthMethod {
+		case types.AuthMethodKey:
+			if hop.KeyID == "" {
+				report("no key file for key authentication")
+				break
+			}
+			// Managed keys are kept by the server, not in files
+			if _, ok := types.ManagedKeyID(hop.KeyID); ok {
+				break
+			}
+			path, err := ExpandPath(hop.KeyID)
+			if err == nil {
+				_, err = os.Stat(path)
+			}
+			if err != nil {
+				report("key file %s: %v", hop.KeyID, unwrapPathError(err))
+			}
+		case types.AuthMethodAgent:
+		case types.AuthMethodPassword, types.AuthMethodCert:
+			report("%s authentication is not supported yet", hop.AuthMethod)
+		default:
+			report("unknown auth method %q", hop.AuthMethod)
+		}
+	}
+	return problems
+}
+
+// validHost reports whether host is an IP address or a well-formed DNS name
+func validHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// unwrapPathError drops the path an os error repeats
+func unwrapPathError(err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
+
+// markMisconfigured records a tunnel's problems in its status. Must be
+// called before the tunnel is shared, or with its status otherwise unused.
+func markMisconfigured(status *types.TunnelStatus, problems []string) {
+	status.State = types.TunnelStateMisconfigured
+	status.Problems = problems
+	status.LastError = "misconfigured: " + strings.Join(problems, "; ")
+}
+
+// recheckLocked checks a tunnel marked misconfigured again before starting
+// it, since e.g. its key file may have been restored. Must be called with
+// m.mu held.
+func (m *Manager) recheckLocked(tunnel *Tunnel) error {
+	status := tunnel.GetStatus()
+	if status == nil || status.State != types.TunnelStateMisconfigured {
+		return nil
+	}
+	if problems := CheckSpec(tunnel.Spec); len(problems) > 0 {
+		tunnel.mu.Lock()
+		tunnel.Status.Problems = problems
+		tunnel.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrMisconfigured, strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestCheckSpec(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missingKey := filepath.Join(t.TempDir(), "gone")
+
+	tests := []struct {
+		name string
+		hops []types.Hop
+		want []string
+	}{
+		{
+			name: "valid",
+			hops: []types.Hop{
+				{Host: "bastion.example.com", Port: 22, User: "ops", AuthMethod: types.AuthMethodKey, KeyID: keyPath},
+				{Host: "10.0.0.5", Port: 2222, User: "ops", AuthMethod: types.AuthMethodAgent},
+			},
+		},
+		{
+			name: "no hops",
+			want: []string{"no hops"},
+		},
+		{
+			name: "missing key file",
+			hops: []types.Hop{{Host: "bastion", Port: 22, User: "ops", AuthMethod: types.AuthMethodKey, KeyID: missingKey}},
+			want: []string{"hop 1: key file " + missingKey + ": no such file or directory"},
+		},
+		{
+			name: "invalid host and port",
+			hops: []types.Hop{
+				{Host: "bastion", Port: 22, User: "ops", AuthMethod: types.AuthMethodAgent},
+				{Host: "bad host!", Port: 0, User: "", AuthMethod: types.AuthMethodAgent},
+			},
+			want: []string{`hop 2: invalid host "bad host!"`, "hop 2: invalid port 0", "hop 2: no user"},
+		},
+		{
+			name: "unsupported auth methods",
+			hops: []types.Hop{
+				{Host: "a", Port: 22, User: "ops", AuthMethod: types.AuthMethodPassword},
+				{Host: "b", Port: 22, User: "ops", AuthMethod: "kerberos"},
+			},
+			want: []string{"hop 1: password authentication is not supported yet", `hop 2: unknown auth method "kerberos"`},
+		},
+		{
+			name: "managed key needs no key file",
+			hops: []types.Hop{{Host: "bastion", Port: 22, User: "ops", AuthMethod: types.AuthMethodKey, KeyID: "managed://deploy"}},
+		},
+		{
+			name: "attached hop needs no credentials",
+			hops: []types.Hop{{Host: "bastion", Port: 22, User: "ops", Attach: "other"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CheckSpec(&types.TunnelSpec{ID: "check", Hops: tt.hops})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CheckSpec() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFromStorageMarksMisconfigured(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStorage()
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+
+	spec := &types.TunnelSpec{
+		ID:         "misconfigured-1",
+		Type:       types.TunnelTypeLocal,
+		Hops:       []types.Hop{{Host: "bastion", Port: 22, User: "ops", AuthMethod: types.AuthMethodKey, KeyID: keyPath}},
+		RemoteHost: "db",
+		RemotePort: 5432,
+	}
+	_ = store.Save(ctx, spec)
+	store.statuses[spec.ID] = "stopped"
+
+	manager := NewManager(ctx)
+	manager.SetStorage(store)
+	if err := manager.LoadFromStorage(ctx); err != nil {
+		t.Fatalf("LoadFromStorage failed: %v", err)
+	}
+	tunnel, _ := manager.Get(spec.ID)
+	status := tunnel.GetStatus()
+	if status.State != types.TunnelStateMisconfigured || len(status.Problems) != 1 ||
+		!strings.Contains(status.LastError, "key file") {
+		t.Fatalf("Expected the tunnel to be misconfigured by its key file, got %s %q", status.State, status.Problems)
+	}
+	if got := store.status(spec.ID); got != string(types.TunnelStateMisconfigured) {
+		t.Errorf("Expected the misconfigured state to be stored, got %s", got)
+	}
+
+	if err := manager.Start(ctx, spec.ID); !errors.Is(err, ErrMisconfigured) {
+		t.Errorf("Start() error = %v, want ErrMisconfigured", err)
+	}
+
+	// Once the key file is back, the tunnel starts again
+	if err := os.WriteFile(keyPath, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Start(ctx, spec.ID); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	status = waitForStatus(t, tunnel, "the tunnel to try connecting", func(s *types.TunnelStatus) bool {
+		return s.State != types.TunnelStateMisconfigured
+	})
+	if len(status.Problems) != 0 {
+		t.Errorf("Expected the problems to be cleared, got %q", status.Problems)
+	}
+}
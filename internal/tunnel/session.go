@@ -2,11 +2,14 @@ package tunnel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,14 +21,16 @@ import (
 	"github.com/craigderington/lazytunnel/pkg/types"
 )
 
-// expandPath expands ~ to the user's home directory
-func expandPath(path string) (string, error) {
+// ExpandPath expands ~ to the user's home directory and, on Windows,
+// %VAR% environment references such as %USERPROFILE%
+func ExpandPath(path string) (string, error) {
 	if path == "" {
 		return path, nil
 	}
+	path = expandEnvPath(path)
 
-	// If path starts with ~/, expand to home directory
-	if strings.HasPrefix(path, "~/") {
+	// If path starts with ~/ (or ~\ on Windows), expand to home directory
+	if strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
 			return "", fmt.Errorf("failed to get home directory: %w", err)
@@ -53,35 +58,68 @@ type Session struct {
 	config *ssh.ClientConfig
 
 	// Connection state
-	connected   bool
+	state       SessionState
 	lastError   error
 	retryCount  int
+	nextRetryAt *time.Time    // when the next connection attempt is due, while backing off
+	retryNow    chan struct{} // cuts the backoff short
 	connectedAt *time.Time
+	attached    bool // client is borrowed from another session, so not ours to close
 	mu          sync.RWMutex
 
-	// Keep-alive
+	// Snapshot of the fields above plus server identification and latency.
+	// Guarded by its own lock so status reads don't wait on an in-progress
+	// connect; refreshed by publishStatus whenever the state changes.
+	info   types.HopStatus
+	infoMu sync.RWMutex
+
+	// Keep-alive, restarted with each connection
 	keepAlive     time.Duration
-	stopKeepAlive chan struct{}
+	keepAliveMax  int
+	stopKeepAlive chan struct{} // closed to stop the current connection's keep-alives
 
 	// Auto-reconnect
 	autoReconnect bool
 	maxRetries    int
 	backoffConfig BackoffConfig
+	retryWait     RetryWaitFunc
 
 	// Callbacks
 	onDisconnect DisconnectCallback
 	onReconnect  ReconnectCallback
+	onGiveUp     GiveUpCallback
+	onRetry      RetryCallback
+	prompt       PromptFunc
+	passphrase   PassphraseFunc
+	keys         KeySource
+	resolver     *Resolver
+	dial         DialFunc
+	attach       AttachFunc
+
+	socketBuffer int // SO_RCVBUF and SO_SNDBUF of the connection to the hop; 0 = OS default
+	faults       FaultSource
+	simulator    *Simulator
 
 	// Context for cancellation
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
+// DefaultKeepAliveMax is how many keep-alives in a row a server may leave
+// unanswered before its connection is considered lost, like OpenSSH's
+// ServerAliveCountMax
+const DefaultKeepAliveMax = 3
+
+// errKeepAliveMissed is returned for a keep-alive the server didn't answer in
+// time
+var errKeepAliveMissed = errors.New("keep-alive not answered")
+
 // BackoffConfig defines exponential backoff parameters
 type BackoffConfig struct {
 	Initial    time.Duration
 	Max        time.Duration
 	Multiplier float64
+	Jitter     float64 // fraction of each delay randomized either way, 0 to 1
 }
 
 // DefaultBackoffConfig returns default backoff configuration
@@ -90,25 +128,84 @@ func DefaultBackoffConfig() BackoffConfig {
 		Initial:    1 * time.Second,
 		Max:        60 * time.Second,
 		Multiplier: 2.0,
+		Jitter:     0.2,
 	}
 }
 
+// BackoffConfigFor returns the backoff of a tunnel's policy, with the
+// defaults for the values it leaves unset
+func BackoffConfigFor(policy types.BackoffPolicy) BackoffConfig {
+	config := DefaultBackoffConfig()
+	if policy.Initial > 0 {
+		config.Initial = policy.Initial
+	}
+	if policy.Max > 0 {
+		config.Max = policy.Max
+	}
+	if policy.Multiplier >= 1 {
+		config.Multiplier = policy.Multiplier
+	}
+	if policy.Jitter > 0 {
+		config.Jitter = min(policy.Jitter, 1)
+	} else if policy.Jitter < 0 {
+		config.Jitter = 0
+	}
+
+	// A default cap below a custom first delay would shorten it
+	config.Max = max(config.Max, config.Initial)
+	return config
+}
+
 // DisconnectCallback is called when a session disconnects
 type DisconnectCallback func(err error)
 
 // ReconnectCallback is called when a session successfully reconnects
 type ReconnectCallback func()
 
+// GiveUpCallback is called when a lost connection won't be re-established,
+// because auto-reconnect is off or every attempt failed
+type GiveUpCallback func(err error)
+
+// RetryCallback is called when a failed connection attempt will be followed
+// by another at the given time; attempt counts from 1, up to maxAttempts
+type RetryCallback func(at time.Time, attempt, maxAttempts int)
+
+// RetryWaitFunc blocks until a retry may be made, e.g. on a budget shared
+// by many sessions, or returns ctx's error once it is done
+type RetryWaitFunc func(ctx context.Context) error
+
+// PassphraseFunc returns the passphrase of an encrypted private key
+type PassphraseFunc func(keyPath string) ([]byte, error)
+
+// KeySource unlocks the managed keys hops reference with a key ID of
+// managed://<id>, rather than a private key file path
+type KeySource interface {
+	Signers(ctx context.Context, id string) ([]ssh.Signer, error)
+}
+
 // SessionConfig contains configuration for creating an SSH session
 type SessionConfig struct {
 	Hop           *types.Hop
 	KeepAlive     time.Duration
+	KeepAliveMax  int // unanswered keep-alives in a row before the connection is lost
 	AutoReconnect bool
 	MaxRetries    int
 	Timeout       time.Duration
 	BackoffConfig BackoffConfig
 	OnDisconnect  DisconnectCallback // Called when connection is lost
 	OnReconnect   ReconnectCallback  // Called when reconnection succeeds
+	OnGiveUp      GiveUpCallback     // Called when the session stops trying to reconnect
+	OnRetry       RetryCallback      // Called when the next connection attempt is scheduled
+	RetryWait     RetryWaitFunc      // Called after each backoff, before the attempt
+	Prompt        PromptFunc         // Answers keyboard-interactive challenges
+	Passphrase    PassphraseFunc     // Unlocks passphrase-protected keys
+	Keys          KeySource          // Resolves managed key IDs; nil leaves them unusable
+	Resolver      *Resolver          // Looks up the (first) hop's hostname; nil asks the system every time
+	Dial          DialFunc           // Opens the connection to the (first) hop; nil dials directly
+	Attach        AttachFunc         // Borrows an established connection to the hop instead of dialing one
+	SocketBuffer  int                // SO_RCVBUF and SO_SNDBUF bytes of the TCP connection to the hop; 0 keeps the OS default
+	Faults        FaultSource        // Delays connections through the (last) hop on purpose; nil injects none
+	Simulator     *Simulator         // Stands in for the hops and everything behind them; nil connects for real
 }
 
 // NewSession creates a new SSH session
@@ -121,6 +218,9 @@ func NewSession(ctx context.Context, config SessionConfig) (*Session, error) {
 	if config.KeepAlive == 0 {
 		config.KeepAlive = 30 * time.Second
 	}
+	if config.KeepAliveMax == 0 {
+		config.KeepAliveMax = DefaultKeepAliveMax
+	}
 	if config.MaxRetries == 0 {
 		config.MaxRetries = 3
 	}
@@ -136,12 +236,25 @@ func NewSession(ctx context.Context, config SessionConfig) (*Session, error) {
 	session := &Session{
 		hop:           config.Hop,
 		keepAlive:     config.KeepAlive,
+		keepAliveMax:  config.KeepAliveMax,
 		autoReconnect: config.AutoReconnect,
 		maxRetries:    config.MaxRetries,
 		backoffConfig: config.BackoffConfig,
 		onDisconnect:  config.OnDisconnect,
 		onReconnect:   config.OnReconnect,
-		stopKeepAlive: make(chan struct{}),
+		onGiveUp:      config.OnGiveUp,
+		onRetry:       config.OnRetry,
+		retryWait:     config.RetryWait,
+		prompt:        config.Prompt,
+		passphrase:    config.Passphrase,
+		keys:          config.Keys,
+		resolver:      config.Resolver,
+		dial:          config.Dial,
+		attach:        config.Attach,
+		socketBuffer:  config.SocketBuffer,
+		faults:        config.Faults,
+		simulator:     config.Simulator,
+		retryNow:      make(chan struct{}, 1),
 		ctx:           sessionCtx,
 		cancel:        cancel,
 	}
@@ -156,39 +269,99 @@ func NewSession(ctx context.Context, config SessionConfig) (*Session, error) {
 func (s *Session) Connect() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.publishStatus()
+
+	return s.connectLocked(s.dialClient)
+}
 
-	if s.connected {
+// connectLocked makes one connection attempt with establish, moving the
+// session through its states. Must be called with s.mu held.
+func (s *Session) connectLocked(establish func() (*ssh.Client, error)) error {
+	switch s.state {
+	case SessionClosed:
+		return ErrSessionClosed
+	case SessionConnected:
 		return nil
 	}
 
+	s.fire(eventDial)
+	client, err := establish()
+	if err != nil {
+		s.lastError = err
+		s.fire(eventFailed)
+		return err
+	}
+
+	s.client = client
+	s.attached = s.attach != nil
+	now := time.Now()
+	s.connectedAt = &now
+	s.retryCount = 0
+	s.lastError = nil
+	s.fire(eventConnected)
+	s.startKeepAlive(client)
+
+	return nil
+}
+
+// dialClient connects and authenticates to the hop, or borrows the
+// connection to it when the session is attached to another
+func (s *Session) dialClient() (*ssh.Client, error) {
+	if s.attach != nil {
+		return s.attachClient()
+	}
+
 	// Build SSH client config if not already built
 	if s.config == nil {
 		config, err := s.buildSSHConfig(10 * time.Second) // Default timeout
 		if err != nil {
-			s.lastError = fmt.Errorf("failed to build SSH config: %w", err)
-			return s.lastError
+			return nil, fmt.Errorf("failed to build SSH config: %w", err)
 		}
 		s.config = config
 	}
 
-	addr := fmt.Sprintf("%s:%d", s.hop.Host, s.hop.Port)
-	client, err := ssh.Dial("tcp", addr, s.config)
+	addr := net.JoinHostPort(s.hop.Host, strconv.Itoa(s.hop.Port))
+	config, banner := s.configWithBanner()
+	client, err := s.dialSSH(addr, config)
 	if err != nil {
-		s.lastError = fmt.Errorf("failed to connect to %s: %w", addr, err)
-		return s.lastError
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
 	}
 
-	s.client = client
-	s.connected = true
-	now := time.Now()
-	s.connectedAt = &now
-	s.retryCount = 0
-	s.lastError = nil
-
-	// Start keep-alive
-	go s.keepAliveLoop()
+	s.recordServerInfo(client, *banner)
+	if err := s.startAgentForwarding(client); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
 
-	return nil
+// dialSSH opens the SSH connection to the hop, through the session's
+// DialFunc if it has one
+func (s *Session) dialSSH(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var conn net.Conn
+	var err error
+	switch {
+	case s.simulator != nil:
+		conn, err = s.simulator.dialHop(s.ctx, addr)
+	case s.dial == nil:
+		conn, err = s.resolver.DialTimeout(s.ctx, "tcp", addr, config.Timeout)
+	default:
+		conn, err = s.dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.socketBuffer > 0 {
+		// Best effort: the kernel caps the sizes, and relayed connections
+		// aren't sockets
+		applyTCPOptions(conn, types.TCPOptions{ReadBuffer: s.socketBuffer, WriteBuffer: s.socketBuffer})
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
 }
 
 // connectOverConn establishes an SSH connection over an existing net.Conn
@@ -196,77 +369,191 @@ func (s *Session) Connect() error {
 func (s *Session) connectOverConn(conn net.Conn) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.publishStatus()
+
+	return s.connectLocked(func() (*ssh.Client, error) {
+		// Build SSH client config if not already built
+		if s.config == nil {
+			config, err := s.buildSSHConfig(10 * time.Second)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build SSH config: %w", err)
+			}
+			s.config = config
+		}
+
+		// Create SSH client connection over the existing conn
+		config, banner := s.configWithBanner()
+		sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.hop.Host, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to establish SSH over connection: %w", err)
+		}
+
+		s.recordServerInfo(sshConn, *banner)
+		client := ssh.NewClient(sshConn, chans, reqs)
+		if err := s.startAgentForwarding(client); err != nil {
+			client.Close()
+			return nil, err
+		}
+		return client, nil
+	})
+}
+
+// maxBannerLength caps how much of a server's auth banner is kept
+const maxBannerLength = 4096
 
-	if s.connected {
+// configWithBanner returns a copy of the client config that captures the
+// server's auth banner into the returned string during the handshake
+func (s *Session) configWithBanner() (*ssh.ClientConfig, *string) {
+	var banner string
+	config := *s.config
+	config.BannerCallback = func(message string) error {
+		banner += message
 		return nil
 	}
+	return &config, &banner
+}
 
-	// Build SSH client config if not already built
-	if s.config == nil {
-		config, err := s.buildSSHConfig(10 * time.Second)
-		if err != nil {
-			s.lastError = fmt.Errorf("failed to build SSH config: %w", err)
-			return s.lastError
-		}
-		s.config = config
+// recordServerInfo stores the identification of a freshly connected server
+func (s *Session) recordServerInfo(conn ssh.ConnMetadata, banner string) {
+	banner = strings.TrimSpace(banner)
+	if len(banner) > maxBannerLength {
+		banner = banner[:maxBannerLength]
 	}
 
-	// Create SSH client connection over the existing conn
-	sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.hop.Host, s.config)
+	s.infoMu.Lock()
+	defer s.infoMu.Unlock()
+	s.info.ServerVersion = string(conn.ServerVersion())
+	s.info.Banner = banner
+}
+
+// publishStatus copies the connection state into the status snapshot. Must
+// be called with s.mu held.
+func (s *Session) publishStatus() {
+	s.infoMu.Lock()
+	defer s.infoMu.Unlock()
+
+	s.info.Connected = s.state == SessionConnected
+	s.info.State = s.state.String()
+	s.info.ConnectedAt = s.connectedAt
+	s.info.RetryCount = s.retryCount
+	s.info.NextRetryAt = s.nextRetryAt
+	s.info.LastError = ""
+	if s.lastError != nil {
+		s.info.LastError = s.lastError.Error()
+	}
+	s.info.FailureReason = ClassifyFailure(s.lastError)
+	if s.state != SessionConnected {
+		s.info.Latency = 0
+		s.info.MissedKeepAlives = 0
+	}
+}
+
+// recordError marks the hop as failed for a reason found outside the
+// session, e.g. when the previous hop can't reach it
+func (s *Session) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err
+	s.publishStatus()
+}
+
+// HopStatus returns the hop's connection details without waiting on an
+// in-progress connect
+func (s *Session) HopStatus() types.HopStatus {
+	s.infoMu.RLock()
+	defer s.infoMu.RUnlock()
+
+	status := s.info
+	status.Host = s.hop.Host
+	status.Port = s.hop.Port
+	status.User = s.hop.User
+	status.ForwardAgent = s.hop.ForwardAgent
+	return status
+}
+
+// startAgentForwarding forwards the local SSH agent to the hop when it opted
+// in. The request is tied to an idle session that lives as long as the client.
+func (s *Session) startAgentForwarding(client *ssh.Client) error {
+	if !s.hop.ForwardAgent || s.simulator != nil {
+		return nil
+	}
+
+	conn, err := DialAgent()
 	if err != nil {
-		s.lastError = fmt.Errorf("failed to establish SSH over connection: %w", err)
-		return s.lastError
+		return fmt.Errorf("agent forwarding to %s: %w", s.hop.Host, err)
 	}
+	go func() {
+		client.Wait()
+		conn.Close()
+	}()
 
-	s.client = ssh.NewClient(sshConn, chans, reqs)
-	s.connected = true
-	now := time.Now()
-	s.connectedAt = &now
-	s.retryCount = 0
-	s.lastError = nil
+	if err := agent.ForwardToAgent(client, agent.NewClient(conn)); err != nil {
+		return fmt.Errorf("failed to forward SSH agent to %s: %w", s.hop.Host, err)
+	}
 
-	// Start keep-alive
-	go s.keepAliveLoop()
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open session for agent forwarding on %s: %w", s.hop.Host, err)
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		session.Close()
+		return fmt.Errorf("%s refused agent forwarding: %w", s.hop.Host, err)
+	}
 
 	return nil
 }
 
-// Disconnect closes the SSH connection
+// Disconnect closes the SSH connection, stopping any reconnect in progress.
+// Connect can connect the session again.
 func (s *Session) Disconnect() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if !s.connected {
+	if !s.fire(eventDisconnect) {
 		return nil
 	}
+	defer s.publishStatus()
+	return s.dropClientLocked()
+}
 
-	// Stop keep-alive
-	close(s.stopKeepAlive)
+// Close closes the session for good and cancels its context
+func (s *Session) Close() error {
+	s.cancel()
 
-	if s.client != nil {
-		if err := s.client.Close(); err != nil {
-			return fmt.Errorf("failed to close SSH client: %w", err)
-		}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.fire(eventClose) {
+		return nil
+	}
+	defer s.publishStatus()
+	return s.dropClientLocked()
+}
+
+// dropClientLocked stops the keep-alives and closes the connection, unless
+// it is borrowed. Must be called with s.mu held.
+func (s *Session) dropClientLocked() error {
+	if s.stopKeepAlive != nil {
+		close(s.stopKeepAlive)
+		s.stopKeepAlive = nil
 	}
 
-	s.connected = false
+	client := s.client
 	s.client = nil
 	s.connectedAt = nil
-
+	if client != nil && !s.attached {
+		if err := client.Close(); err != nil {
+			return fmt.Errorf("failed to close SSH client: %w", err)
+		}
+	}
 	return nil
 }
 
-// Close closes the session and cancels the context
-func (s *Session) Close() error {
-	s.cancel()
-	return s.Disconnect()
-}
-
 // IsConnected returns whether the session is currently connected
 func (s *Session) IsConnected() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.connected
+	return s.state == SessionConnected
 }
 
 // Client returns the underlying SSH client (thread-safe)
@@ -282,49 +569,131 @@ func (s *Session) Dial(network, address string) (net.Conn, error) {
 	if client == nil {
 		return nil, fmt.Errorf("session not connected")
 	}
+	if err := delayDial(context.Background(), s.faults); err != nil {
+		return nil, err
+	}
 
 	return client.Dial(network, address)
 }
 
+// DialContext creates a connection through this SSH session, giving up when
+// ctx is done. A connection completing after that is closed.
+func (s *Session) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client := s.Client()
+	if client == nil {
+		return nil, fmt.Errorf("session not connected")
+	}
+	if err := delayDial(ctx, s.faults); err != nil {
+		return nil, err
+	}
+
+	return client.DialContext(ctx, network, address)
+}
+
+// RetryNow makes the next connection attempt without waiting out the rest
+// of the backoff, reporting whether the session was backing off. It
+// doesn't wait on an in-progress connect.
+func (s *Session) RetryNow() bool {
+	s.infoMu.RLock()
+	defer s.infoMu.RUnlock()
+	if s.info.NextRetryAt == nil {
+		return false
+	}
+	select {
+	case s.retryNow <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// clearNextRetry forgets the scheduled attempt once it is due
+func (s *Session) clearNextRetry() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextRetryAt = nil
+	s.publishStatus()
+}
+
 // ConnectWithRetry connects with automatic retry logic
 func (s *Session) ConnectWithRetry() error {
+	return s.retry(s.Connect)
+}
+
+// retry makes connection attempts with exponential backoff until one
+// succeeds, the retries run out, or the session stops wanting to connect
+func (s *Session) retry(attempt func() error) error {
 	backoff := s.backoffConfig.Initial
 
-	for attempt := 0; attempt <= s.maxRetries; attempt++ {
+	for n := 0; n <= s.maxRetries; n++ {
 		select {
 		case <-s.ctx.Done():
 			return s.ctx.Err()
 		default:
 		}
 
-		err := s.Connect()
+		err := attempt()
 		if err == nil {
 			return nil
 		}
+		if errors.Is(err, ErrSessionClosed) || errors.Is(err, errNotReconnecting) {
+			return err
+		}
 
+		var next time.Time
+		var delay time.Duration
 		s.mu.Lock()
-		s.retryCount = attempt + 1
+		s.retryCount = n + 1
+		if n < s.maxRetries {
+			delay = spread(backoff, s.backoffConfig.Jitter)
+			next = time.Now().Add(delay)
+			s.nextRetryAt = &next
+			// Forget a RetryNow that came too late for the previous backoff
+			select {
+			case <-s.retryNow:
+			default:
+			}
+		}
+		s.publishStatus()
 		s.mu.Unlock()
 
-		if attempt < s.maxRetries {
+		if n < s.maxRetries {
+			if s.onRetry != nil {
+				s.onRetry(next, n+2, s.maxRetries+1)
+			}
 			select {
-			case <-time.After(backoff):
-				// Calculate next backoff
-				backoff = time.Duration(float64(backoff) * s.backoffConfig.Multiplier)
-				if backoff > s.backoffConfig.Max {
-					backoff = s.backoffConfig.Max
-				}
+			case <-time.After(delay):
+			case <-s.retryNow:
 			case <-s.ctx.Done():
+				s.clearNextRetry()
 				return s.ctx.Err()
 			}
+			s.clearNextRetry()
+			if s.retryWait != nil {
+				if err := s.retryWait(s.ctx); err != nil {
+					return err
+				}
+			}
+
+			// Calculate next backoff
+			backoff = time.Duration(float64(backoff) * s.backoffConfig.Multiplier)
+			if backoff > s.backoffConfig.Max {
+				backoff = s.backoffConfig.Max
+			}
 		}
 	}
 
-	return fmt.Errorf("failed to connect after %d attempts: %w", s.maxRetries+1, s.lastError)
+	s.mu.RLock()
+	lastErr := s.lastError
+	s.mu.RUnlock()
+	return fmt.Errorf("failed to connect after %d attempts: %w", s.maxRetries+1, lastErr)
 }
 
 // buildSSHConfig builds an ssh.ClientConfig based on the hop configuration
 func (s *Session) buildSSHConfig(timeout time.Duration) (*ssh.ClientConfig, error) {
+	if s.simulator != nil {
+		return s.simulator.clientConfig(s.hop.User, timeout), nil
+	}
+
 	hostKeyCallback, err := s.buildHostKeyCallback()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build host key callback: %w", err)
@@ -362,9 +731,35 @@ func (s *Session) buildSSHConfig(timeout time.Duration) (*ssh.ClientConfig, erro
 		return nil, fmt.Errorf("unsupported auth method: %s", s.hop.AuthMethod)
 	}
 
+	// Tried after the primary method, e.g. for an OTP after a key
+	if s.hop.KeyboardInteractive {
+		if s.prompt == nil {
+			return nil, fmt.Errorf("keyboard-interactive authentication needs a way to prompt the user")
+		}
+		config.Auth = append(config.Auth, ssh.KeyboardInteractive(s.challenge))
+	}
+
 	return config, nil
 }
 
+// challenge relays a keyboard-interactive challenge to the user
+func (s *Session) challenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	// Servers may send an empty round just to show the instruction
+	if len(questions) == 0 {
+		return []string{}, nil
+	}
+
+	prompts := make([]AuthPromptQuestion, len(questions))
+	for i, q := range questions {
+		prompts[i] = AuthPromptQuestion{Text: q, Echo: echos[i]}
+	}
+
+	if name != "" {
+		instruction = strings.TrimSpace(name + "\n" + instruction)
+	}
+	return s.prompt(s.ctx, s.hop, instruction, prompts)
+}
+
 // buildHostKeyCallback creates the appropriate host key verification callback
 func (s *Session) buildHostKeyCallback() (ssh.HostKeyCallback, error) {
 	// Default to strict verification if not specified
@@ -399,7 +794,7 @@ func (s *Session) buildStrictHostKeyCallback() (ssh.HostKeyCallback, error) {
 	}
 
 	// Expand ~ in path
-	expandedPath, err := expandPath(knownHostsPath)
+	expandedPath, err := ExpandPath(knownHostsPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to expand known_hosts path: %w", err)
 	}
@@ -431,9 +826,19 @@ func (s *Session) keyAuth() (ssh.AuthMethod, error) {
 	if s.hop.KeyID == "" {
 		return nil, fmt.Errorf("key_id is required for key authentication")
 	}
+	if id, ok := types.ManagedKeyID(s.hop.KeyID); ok {
+		if s.keys == nil {
+			return nil, fmt.Errorf("managed key %s: managed keys are not enabled", id)
+		}
+		signers, err := s.keys.Signers(s.ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.PublicKeys(signers...), nil
+	}
 
 	// Expand ~ to home directory
-	expandedPath, err := expandPath(s.hop.KeyID)
+	expandedPath, err := ExpandPath(s.hop.KeyID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to expand key path: %w", err)
 	}
@@ -446,6 +851,14 @@ func (s *Session) keyAuth() (ssh.AuthMethod, error) {
 	}
 
 	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) && s.passphrase != nil {
+		passphrase, perr := s.passphrase(expandedPath)
+		if perr != nil {
+			return nil, fmt.Errorf("failed to get passphrase for %s: %w", expandedPath, perr)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse private key: %w", err)
 	}
@@ -455,41 +868,53 @@ func (s *Session) keyAuth() (ssh.AuthMethod, error) {
 
 // agentAuth creates SSH agent authentication
 func (s *Session) agentAuth() (ssh.AuthMethod, error) {
-	socket := os.Getenv("SSH_AUTH_SOCK")
-	if socket == "" {
-		return nil, fmt.Errorf("SSH_AUTH_SOCK not set")
-	}
-
-	conn, err := net.Dial("unix", socket)
+	conn, err := DialAgent()
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+		return nil, err
 	}
 
 	agentClient := agent.NewClient(conn)
 	return ssh.PublicKeysCallback(agentClient.Signers), nil
 }
 
-// keepAliveLoop sends periodic keep-alive packets
-func (s *Session) keepAliveLoop() {
-	ticker := time.NewTicker(s.keepAlive)
-	defer ticker.Stop()
+// startKeepAlive starts the keep-alives of a new connection. Must be called
+// with s.mu held.
+func (s *Session) startKeepAlive(client *ssh.Client) {
+	stop := make(chan struct{})
+	s.stopKeepAlive = stop
+	go s.keepAliveLoop(client, stop)
+}
+
+// keepAliveLoop sends periodic keep-alive packets over client until stop is
+// closed. A keep-alive the server doesn't answer within the interval is a
+// miss; the connection is only considered lost after keepAliveMax misses in
+// a row, or as soon as it is closed.
+func (s *Session) keepAliveLoop(client *ssh.Client, stop <-chan struct{}) {
+	misses := 0
+	timer := time.NewTimer(jitter(s.keepAlive))
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			if err := s.sendKeepAlive(); err != nil {
-				// Notify listeners about disconnection
-				if s.onDisconnect != nil {
-					s.onDisconnect(err)
-				}
-
-				// Connection lost, attempt reconnect if enabled
-				if s.autoReconnect {
-					go s.reconnect()
+		case <-timer.C:
+			err := s.sendKeepAlive(client)
+			if errors.Is(err, errKeepAliveMissed) && misses+1 < s.keepAliveMax {
+				misses++
+				s.recordMisses(misses)
+				timer.Reset(jitter(s.keepAlive))
+				continue
+			}
+			if err == nil {
+				if misses > 0 {
+					misses = 0
+					s.recordMisses(0)
 				}
-				return
+				timer.Reset(jitter(s.keepAlive))
+				continue
 			}
-		case <-s.stopKeepAlive:
+			s.connectionLost(client, fmt.Errorf("keep-alive failed: %w", err))
+			return
+		case <-stop:
 			return
 		case <-s.ctx.Done():
 			return
@@ -497,64 +922,127 @@ func (s *Session) keepAliveLoop() {
 	}
 }
 
-// sendKeepAlive sends a keep-alive packet
-func (s *Session) sendKeepAlive() error {
-	client := s.Client()
-	if client == nil {
-		return fmt.Errorf("client not connected")
+// connectionLost tears down a connection whose keep-alives failed, or that
+// was dropped on purpose, then reconnects or gives up. A connection the
+// session already replaced or dropped is left alone.
+func (s *Session) connectionLost(client *ssh.Client, err error) {
+	s.mu.Lock()
+	if s.client != client || !s.fire(eventLost) {
+		s.mu.Unlock()
+		return
 	}
+	s.lastError = err
+	_ = s.dropClientLocked()
+	if !s.autoReconnect {
+		s.fire(eventGiveUp)
+	}
+	s.publishStatus()
+	s.mu.Unlock()
 
-	// Send a keep-alive request
-	_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
-	if err != nil {
-		s.mu.Lock()
-		s.connected = false
-		s.lastError = fmt.Errorf("keep-alive failed: %w", err)
-		s.mu.Unlock()
-		return err
+	// Notify listeners about disconnection
+	if s.onDisconnect != nil {
+		s.onDisconnect(err)
 	}
 
-	return nil
+	// Connection lost, attempt reconnect if enabled
+	if s.autoReconnect {
+		go s.reconnect()
+	} else if s.onGiveUp != nil {
+		s.onGiveUp(err)
+	}
 }
 
-// reconnect attempts to reconnect the session
-func (s *Session) reconnect() {
-	s.mu.Lock()
-	// If we're already connected, something else reconnected us
-	if s.connected {
-		s.mu.Unlock()
-		return
+// sendKeepAlive sends a keep-alive packet and waits up to the keep-alive
+// interval for the answer
+func (s *Session) sendKeepAlive(client *ssh.Client) error {
+	// Send a keep-alive request; its round trip doubles as the hop's latency.
+	// The request blocks until answered or the connection closes, which
+	// could take forever on a dead network.
+	start := time.Now()
+	answered := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		answered <- err
+	}()
+	timeout := time.NewTimer(s.keepAlive)
+	defer timeout.Stop()
+	select {
+	case err := <-answered:
+		if err != nil {
+			return err
+		}
+	case <-timeout.C:
+		return errKeepAliveMissed
 	}
 
-	// Check if we're already in a reconnection attempt (retryCount > 0 means we're retrying)
-	// This prevents multiple goroutines from attempting reconnection simultaneously
-	if s.retryCount > 0 {
-		s.mu.Unlock()
-		return
-	}
-	s.mu.Unlock()
+	s.infoMu.Lock()
+	s.info.Latency = time.Since(start)
+	s.infoMu.Unlock()
+
+	return nil
+}
+
+// recordMisses publishes the number of keep-alives missed in a row
+func (s *Session) recordMisses(misses int) {
+	s.infoMu.Lock()
+	defer s.infoMu.Unlock()
+	s.info.MissedKeepAlives = misses
+}
+
+// jitter spreads an interval by up to 10% either way, so sessions started
+// together don't send their keep-alives in lockstep
+func jitter(interval time.Duration) time.Duration {
+	return spread(interval, 0.1)
+}
 
-	// Close the old client
-	if s.client != nil {
-		s.client.Close()
-		s.client = nil
+// spread randomizes d by up to fraction of it either way
+func spread(d time.Duration, fraction float64) time.Duration {
+	width := int64(float64(d) * fraction * 2)
+	if width <= 0 {
+		return d
 	}
+	return d - time.Duration(width/2) + time.Duration(rand.Int63n(width))
+}
 
-	// Attempt reconnection
-	if err := s.ConnectWithRetry(); err != nil {
+// reconnect re-establishes a lost connection. Only connectionLost starts
+// it, once per lost connection, and it stops as soon as the session is
+// disconnected or closed.
+func (s *Session) reconnect() {
+	err := s.retry(func() error {
 		s.mu.Lock()
-		s.lastError = fmt.Errorf("reconnect failed: %w", err)
-		s.mu.Unlock()
+		defer s.mu.Unlock()
+		defer s.publishStatus()
 
-		// Notify about final reconnection failure
-		if s.onDisconnect != nil {
-			s.onDisconnect(s.lastError)
+		if s.state != SessionReconnecting {
+			return errNotReconnecting
 		}
-	} else {
+		return s.connectLocked(s.dialClient)
+	})
+	if err == nil {
 		// Reconnection succeeded!
 		if s.onReconnect != nil {
 			s.onReconnect()
 		}
+		return
+	}
+
+	lastErr := fmt.Errorf("reconnect failed: %w", err)
+	s.mu.Lock()
+	if !s.fire(eventGiveUp) {
+		// Disconnected or closed meanwhile
+		s.mu.Unlock()
+		return
+	}
+	s.lastError = lastErr
+	s.publishStatus()
+	s.mu.Unlock()
+
+	// Notify about final reconnection failure
+	if s.onDisconnect != nil {
+		s.onDisconnect(lastErr)
+	}
+	if s.onGiveUp != nil {
+		s.onGiveUp(lastErr)
 	}
 }
 
@@ -564,7 +1052,8 @@ func (s *Session) Status() SessionStatus {
 	defer s.mu.RUnlock()
 
 	return SessionStatus{
-		Connected:   s.connected,
+		State:       s.state,
+		Connected:   s.state == SessionConnected,
 		ConnectedAt: s.connectedAt,
 		LastError:   s.lastError,
 		RetryCount:  s.retryCount,
@@ -576,6 +1065,7 @@ func (s *Session) Status() SessionStatus {
 
 // SessionStatus represents the current status of an SSH session
 type SessionStatus struct {
+	State       SessionState
 	Connected   bool
 	ConnectedAt *time.Time
 	LastError   error
@@ -611,6 +1101,9 @@ func NewMultiHopSession(ctx context.Context, hops []types.Hop, config SessionCon
 	for i := range hops {
 		hopConfig := config
 		hopConfig.Hop = &hops[i]
+		if i < len(hops)-1 {
+			hopConfig.Faults = nil // only connections to the destination are delayed
+		}
 
 		session, err := NewSession(mhCtx, hopConfig)
 		if err != nil {
@@ -643,10 +1136,16 @@ func (mhs *MultiHopSession) Connect() error {
 		currentSession := mhs.hops[i]
 
 		// Dial through previous hop to current hop
-		addr := fmt.Sprintf("%s:%d", currentSession.hop.Host, currentSession.hop.Port)
-		conn, err := prevSession.Dial("tcp", addr)
+		addr := net.JoinHostPort(currentSession.hop.Host, strconv.Itoa(currentSession.hop.Port))
+		conn, err := dialContext(mhs.ctx, prevSession, "tcp", addr, DefaultDialTimeout)
 		if err != nil {
-			return fmt.Errorf("failed to dial hop %d through hop %d: %w", i, i-1, err)
+			reason := types.FailureHostUnreachable
+			if dnsReason := ClassifyFailure(err); dnsReason == types.FailureDNSNotFound || dnsReason == types.FailureDNSUnavailable {
+				reason = dnsReason
+			}
+			err = withReason(reason, fmt.Errorf("failed to dial hop %d through hop %d: %w", i, i-1, err))
+			currentSession.recordError(err)
+			return err
 		}
 
 		// Establish SSH connection over the tunneled connection
@@ -675,6 +1174,18 @@ func (mhs *MultiHopSession) Dial(network, address string) (net.Conn, error) {
 	return lastHop.Dial(network, address)
 }
 
+// DialContext creates a connection through the multi-hop chain to the final
+// destination, giving up when ctx is done
+func (mhs *MultiHopSession) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	mhs.mu.RLock()
+	defer mhs.mu.RUnlock()
+
+	if len(mhs.hops) == 0 {
+		return nil, fmt.Errorf("no hops configured")
+	}
+	return mhs.hops[len(mhs.hops)-1].DialContext(ctx, network, address)
+}
+
 // Close closes all hop sessions
 func (mhs *MultiHopSession) Close() error {
 	mhs.cancel()
@@ -728,6 +1239,26 @@ func (mhs *MultiHopSession) Status() []SessionStatus {
 	return statuses
 }
 
+// HopStatus returns per-hop connection details in chain order. The hop list
+// is fixed at construction, so this doesn't wait on an in-progress Connect.
+func (mhs *MultiHopSession) HopStatus() []types.HopStatus {
+	statuses := make([]types.HopStatus, len(mhs.hops))
+	for i, session := range mhs.hops {
+		statuses[i] = session.HopStatus()
+	}
+	return statuses
+}
+
+// RetryNow cuts short the backoff of each hop that is backing off,
+// reporting whether any was
+func (mhs *MultiHopSession) RetryNow() bool {
+	retried := false
+	for _, session := range mhs.hops {
+		retried = session.RetryNow() || retried
+	}
+	return retried
+}
+
 // getLastHopClient returns the SSH client of the last hop (for remote forwarding)
 func (mhs *MultiHopSession) getLastHopClient() interface{} {
 	mhs.mu.RLock()
@@ -1,11 +1,25 @@
 package tunnel
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/craigderington/lazytunnel/pkg/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 func TestNewSession(t *testing.T) {
@@ -287,3 +301,560 @@ func TestDefaultBackoffConfig(t *testing.T) {
 		t.Errorf("Multiplier (%v) less than 1, backoff won't increase", config.Multiplier)
 	}
 }
+
+// newTestServerConfig returns an SSH server config that accepts any password
+// and sends an auth banner
+func newTestServerConfig(t *testing.T) *ssh.ServerConfig {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		ServerVersion: "SSH-2.0-TestBastion_1.0",
+		BannerCallback: func(ssh.ConnMetadata) string {
+			return "Authorized use only\n"
+		},
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(signer)
+	return serverConfig
+}
+
+// startTestSSHServer runs an SSH server, using newTestServerConfig when
+// serverConfig is nil. Channels go to handle, or are rejected when it is nil.
+// It returns the listen address.
+func startTestSSHServer(t *testing.T, serverConfig *ssh.ServerConfig, handle func(*ssh.ServerConn, <-chan ssh.NewChannel)) string {
+	t.Helper()
+
+	if serverConfig == nil {
+		serverConfig = newTestServerConfig(t)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			serverConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer serverConn.Close()
+
+				conn, chans, reqs, err := ssh.NewServerConn(serverConn, serverConfig)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				go ssh.DiscardRequests(reqs)
+				if handle != nil {
+					handle(conn, chans)
+					return
+				}
+				for ch := range chans {
+					ch.Reject(ssh.Prohibited, "not supported")
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// testClientConfig authenticates against startTestSSHServer
+func testClientConfig(user string) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+}
+
+func TestSessionRecordsServerVersionAndBanner(t *testing.T) {
+	addr := startTestSSHServer(t, nil, nil)
+
+	hop := &types.Hop{Host: "bastion", Port: 22, User: "testuser", AuthMethod: types.AuthMethodPassword}
+	session, err := NewSession(context.Background(), SessionConfig{Hop: hop})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	session.config = testClientConfig(hop.User)
+
+	if status := session.HopStatus(); status.Connected || status.ServerVersion != "" || status.Banner != "" {
+		t.Errorf("HopStatus() before connect = %+v, want disconnected with no server info", status)
+	}
+
+	clientConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer clientConn.Close()
+
+	if err := session.connectOverConn(clientConn); err != nil {
+		t.Fatalf("connectOverConn() error = %v", err)
+	}
+
+	status := session.HopStatus()
+	if !status.Connected || status.ConnectedAt == nil {
+		t.Errorf("HopStatus() = %+v, want connected with connectedAt set", status)
+	}
+	if status.ServerVersion != "SSH-2.0-TestBastion_1.0" {
+		t.Errorf("ServerVersion = %q, want %q", status.ServerVersion, "SSH-2.0-TestBastion_1.0")
+	}
+	if status.Banner != "Authorized use only" {
+		t.Errorf("Banner = %q, want %q", status.Banner, "Authorized use only")
+	}
+	if status.Host != hop.Host || status.User != hop.User {
+		t.Errorf("HopStatus() = %+v, want host %s user %s", status, hop.Host, hop.User)
+	}
+
+	if err := session.Disconnect(); err != nil {
+		t.Fatalf("Disconnect() error = %v", err)
+	}
+	if status := session.HopStatus(); status.Connected || status.ConnectedAt != nil {
+		t.Errorf("HopStatus() after disconnect = %+v, want disconnected", status)
+	}
+}
+
+func TestMultiHopSessionHopStatusPinpointsFailedHop(t *testing.T) {
+	addr := startTestSSHServer(t, nil, nil)
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+
+	hops := []types.Hop{
+		{Host: host, Port: port, User: "jump", AuthMethod: types.AuthMethodPassword},
+		{Host: "10.0.0.2", Port: 22, User: "internal", AuthMethod: types.AuthMethodPassword},
+		{Host: "10.0.0.3", Port: 22, User: "target", AuthMethod: types.AuthMethodPassword},
+	}
+
+	mhs, err := NewMultiHopSession(context.Background(), hops, SessionConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create multi-hop session: %v", err)
+	}
+	defer mhs.Close()
+	mhs.hops[0].config = testClientConfig("jump")
+
+	// The test server refuses to forward, so the chain breaks at hop 1
+	if err := mhs.Connect(); err == nil {
+		t.Fatal("Connect() succeeded, want error from hop 1")
+	}
+
+	statuses := mhs.HopStatus()
+	if len(statuses) != 3 {
+		t.Fatalf("HopStatus() returned %d hops, want 3", len(statuses))
+	}
+	if !statuses[0].Connected || statuses[0].LastError != "" {
+		t.Errorf("hop 0 = %+v, want connected without error", statuses[0])
+	}
+	if statuses[1].Connected || statuses[1].LastError == "" {
+		t.Errorf("hop 1 = %+v, want disconnected with an error", statuses[1])
+	}
+	if statuses[2].Connected || statuses[2].LastError != "" {
+		t.Errorf("hop 2 = %+v, want untouched", statuses[2])
+	}
+}
+
+func TestSessionForwardsAgent(t *testing.T) {
+	// Serve a local agent holding one key, as SSH_AUTH_SOCK would
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate user key: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: userKey}); err != nil {
+		t.Fatalf("Failed to add key to agent: %v", err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	agentListener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on agent socket: %v", err)
+	}
+	defer agentListener.Close()
+	go func() {
+		for {
+			conn, err := agentListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	// The hop accepts the forwarding request, then lists keys through it
+	forwardedKeys := make(chan int, 1)
+	addr := startTestSSHServer(t, nil, func(conn *ssh.ServerConn, chans <-chan ssh.NewChannel) {
+		for newCh := range chans {
+			if newCh.ChannelType() != "session" {
+				newCh.Reject(ssh.Prohibited, "not supported")
+				continue
+			}
+			ch, reqs, err := newCh.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer ch.Close()
+				for req := range reqs {
+					req.Reply(req.Type == "auth-agent-req@openssh.com", nil)
+					if req.Type != "auth-agent-req@openssh.com" {
+						continue
+					}
+					agentCh, agentReqs, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+					if err != nil {
+						forwardedKeys <- -1
+						return
+					}
+					go ssh.DiscardRequests(agentReqs)
+					keys, err := agent.NewClient(agentCh).List()
+					agentCh.Close()
+					if err != nil {
+						forwardedKeys <- -1
+						return
+					}
+					forwardedKeys <- len(keys)
+				}
+			}()
+		}
+	})
+
+	hop := &types.Hop{Host: "bastion", Port: 22, User: "testuser", AuthMethod: types.AuthMethodPassword, ForwardAgent: true}
+	session, err := NewSession(context.Background(), SessionConfig{Hop: hop})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	session.config = testClientConfig(hop.User)
+
+	clientConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer clientConn.Close()
+
+	if err := session.connectOverConn(clientConn); err != nil {
+		t.Fatalf("connectOverConn() error = %v", err)
+	}
+
+	select {
+	case n := <-forwardedKeys:
+		if n != 1 {
+			t.Errorf("hop saw %d forwarded keys, want 1", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hop never used the forwarded agent")
+	}
+
+	if !session.HopStatus().ForwardAgent {
+		t.Error("HopStatus().ForwardAgent = false, want true")
+	}
+}
+
+func TestSessionForwardAgentRequiresSocket(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	addr := startTestSSHServer(t, nil, nil)
+
+	hop := &types.Hop{Host: "bastion", Port: 22, User: "testuser", AuthMethod: types.AuthMethodPassword, ForwardAgent: true}
+	session, err := NewSession(context.Background(), SessionConfig{Hop: hop})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	session.config = testClientConfig(hop.User)
+
+	clientConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer clientConn.Close()
+
+	if err := session.connectOverConn(clientConn); err == nil {
+		t.Fatal("connectOverConn() succeeded without SSH_AUTH_SOCK, want error")
+	}
+	if session.IsConnected() {
+		t.Error("session connected despite failed agent forwarding")
+	}
+}
+
+func TestSessionRelaysKeyboardInteractivePrompts(t *testing.T) {
+	serverConfig := newTestServerConfig(t)
+	serverConfig.PasswordCallback = nil
+	serverConfig.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		answers, err := client("Duo", "Enter your passcode", []string{"Passcode: "}, []bool{false})
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) != 1 || answers[0] != "123456" {
+			return nil, fmt.Errorf("wrong passcode")
+		}
+		return nil, nil
+	}
+	addr := startTestSSHServer(t, serverConfig, nil)
+
+	manager := NewManager(context.Background())
+	hop := &types.Hop{Host: "bastion", Port: 22, User: "testuser", AuthMethod: types.AuthMethodKey, KeyboardInteractive: true}
+	session, err := NewSession(context.Background(), SessionConfig{Hop: hop, Prompt: manager.promptFunc("tunnel-1")})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	session.config = &ssh.ClientConfig{
+		User:            hop.User,
+		Auth:            []ssh.AuthMethod{ssh.KeyboardInteractive(session.challenge)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+
+	// Play the user: wait for the prompt to show up, then answer it
+	answered := make(chan error, 1)
+	go func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			prompts := manager.PendingPrompts()
+			if len(prompts) == 0 {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+
+			p := prompts[0]
+			if p.TunnelID != "tunnel-1" || p.Host != "bastion" || len(p.Questions) != 1 || p.Questions[0].Echo {
+				answered <- fmt.Errorf("unexpected prompt %+v", p)
+				return
+			}
+			if !strings.Contains(p.Instruction, "Enter your passcode") {
+				answered <- fmt.Errorf("instruction = %q, want the server's instruction", p.Instruction)
+				return
+			}
+			answered <- manager.AnswerPrompt(p.ID, []string{"123456"})
+			return
+		}
+		answered <- fmt.Errorf("no prompt appeared")
+	}()
+
+	clientConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer clientConn.Close()
+
+	if err := session.connectOverConn(clientConn); err != nil {
+		t.Fatalf("connectOverConn() error = %v", err)
+	}
+	if err := <-answered; err != nil {
+		t.Fatal(err)
+	}
+	if len(manager.PendingPrompts()) != 0 {
+		t.Error("prompt still pending after being answered")
+	}
+}
+
+func TestSessionKeyAuthUsesPassphrase(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("hunter2"))
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	hop := &types.Hop{Host: "bastion", Port: 22, User: "deploy", AuthMethod: types.AuthMethodKey, KeyID: keyPath}
+
+	session, err := NewSession(context.Background(), SessionConfig{Hop: hop})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if _, err := session.keyAuth(); err == nil {
+		t.Error("keyAuth() succeeded on an encrypted key without a passphrase")
+	}
+
+	var asked string
+	session, err = NewSession(context.Background(), SessionConfig{
+		Hop: hop,
+		Passphrase: func(path string) ([]byte, error) {
+			asked = path
+			return []byte("hunter2"), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if _, err := session.keyAuth(); err != nil {
+		t.Errorf("keyAuth() error = %v", err)
+	}
+	if asked != keyPath {
+		t.Errorf("Passphrase asked for %q, want %q", asked, keyPath)
+	}
+}
+
+// staticKeys is a KeySource holding signers by managed key ID
+type staticKeys map[string][]ssh.Signer
+
+func (k staticKeys) Signers(ctx context.Context, id string) ([]ssh.Signer, error) {
+	signers, ok := k[id]
+	if !ok {
+		return nil, fmt.Errorf("managed key not found: %s", id)
+	}
+	return signers, nil
+}
+
+func TestSessionKeyAuthUsesManagedKeys(t *testing.T) {
+	_, old, _ := ed25519.GenerateKey(rand.Reader)
+	_, current, _ := ed25519.GenerateKey(rand.Reader)
+	oldSigner, _ := ssh.NewSignerFromKey(old)
+	currentSigner, _ := ssh.NewSignerFromKey(current)
+
+	// The server only trusts the key being rotated out, so authentication
+	// must fall back to it
+	serverConfig := newTestServerConfig(t)
+	serverConfig.PasswordCallback = nil
+	serverConfig.PublicKeyCallback = func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if bytes.Equal(key.Marshal(), oldSigner.PublicKey().Marshal()) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unknown key")
+	}
+	host, port, _ := net.SplitHostPort(startTestSSHServer(t, serverConfig, nil))
+	portNum, _ := strconv.Atoi(port)
+
+	hop := &types.Hop{Host: host, Port: portNum, User: "deploy", AuthMethod: types.AuthMethodKey,
+		KeyID: "managed://deploy", HostKeyVerification: types.HostKeyVerifyInsecure}
+
+	session, err := NewSession(context.Background(), SessionConfig{Hop: hop})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if _, err := session.keyAuth(); err == nil {
+		t.Error("keyAuth() succeeded on a managed key without a key source")
+	}
+
+	session, err = NewSession(context.Background(), SessionConfig{
+		Hop:  hop,
+		Keys: staticKeys{"deploy": {currentSigner, oldSigner}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	if err := session.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if got := jitter(time.Second); got < 900*time.Millisecond || got >= 1100*time.Millisecond {
+			t.Fatalf("jitter(1s) = %v, want within 10%%", got)
+		}
+	}
+}
+
+func TestSessionToleratesMissedKeepAlives(t *testing.T) {
+	// The server holds its keep-alive answers while quiet; answers must come
+	// in order, so they are delayed rather than dropped
+	var quiet atomic.Bool
+	serverConfig := newTestServerConfig(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+		if err != nil {
+			return
+		}
+		go func() {
+			for ch := range chans {
+				ch.Reject(ssh.Prohibited, "not supported")
+			}
+		}()
+		for req := range reqs {
+			for quiet.Load() {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+		}
+	}()
+
+	host, portStr, _ := net.SplitHostPort(listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	disconnected := make(chan error, 1)
+	session, err := NewSession(context.Background(), SessionConfig{
+		Hop:          &types.Hop{Host: host, Port: port, User: "testuser", AuthMethod: types.AuthMethodPassword},
+		KeepAlive:    50 * time.Millisecond,
+		KeepAliveMax: 4,
+		OnDisconnect: func(err error) { disconnected <- err },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	session.config = testClientConfig("testuser")
+	if err := session.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	waitFor := func(desc string, ok func(types.HopStatus) bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !ok(session.HopStatus()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s, status %+v", desc, session.HopStatus())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// A couple of misses are tolerated
+	quiet.Store(true)
+	waitFor("a missed keep-alive", func(s types.HopStatus) bool { return s.MissedKeepAlives > 0 })
+	quiet.Store(false)
+	waitFor("the keep-alives to be answered again", func(s types.HopStatus) bool { return s.MissedKeepAlives == 0 })
+	if !session.IsConnected() {
+		t.Fatal("Expected the session to stay connected through a few missed keep-alives")
+	}
+
+	// Too many in a row lose the connection
+	quiet.Store(true)
+	select {
+	case err := <-disconnected:
+		if !errors.Is(err, errKeepAliveMissed) {
+			t.Errorf("Expected the connection to be lost to missed keep-alives, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the connection to be declared lost")
+	}
+	if session.IsConnected() {
+		t.Error("Expected the session to be disconnected")
+	}
+	quiet.Store(false)
+}
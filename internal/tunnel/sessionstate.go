@@ -0,0 +1,113 @@
+package tunnel
+
+import "errors"
+
+// SessionState is where a Session is in its lifecycle
+type SessionState int
+
+const (
+	// SessionDisconnected is a session not connected yet, disconnected on
+	// purpose, or that gave up reconnecting. Connect starts it again.
+	SessionDisconnected SessionState = iota
+	// SessionConnecting is a session making its first connection
+	SessionConnecting
+	// SessionConnected is a session with a working connection
+	SessionConnected
+	// SessionReconnecting is a session re-establishing a lost connection
+	SessionReconnecting
+	// SessionClosed is a session closed for good
+	SessionClosed
+)
+
+func (st SessionState) String() string {
+	switch st {
+	case SessionDisconnected:
+		return "disconnected"
+	case SessionConnecting:
+		return "connecting"
+	case SessionConnected:
+		return "connected"
+	case SessionReconnecting:
+		return "reconnecting"
+	case SessionClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// sessionEvent is something that happens to a session and may move it to
+// another state
+type sessionEvent int
+
+const (
+	eventDial       sessionEvent = iota // a connection attempt starts
+	eventConnected                      // the attempt succeeded
+	eventFailed                         // the attempt failed
+	eventLost                           // the connection dropped
+	eventGiveUp                         // the lost connection won't be re-established
+	eventDisconnect                     // disconnected on purpose
+	eventClose                          // closed for good
+)
+
+// sessionTransitions is the session state machine: the state each event
+// moves a session to from each state. Events missing from a state don't
+// apply to it.
+var sessionTransitions = map[SessionState]map[sessionEvent]SessionState{
+	SessionDisconnected: {
+		eventDial:  SessionConnecting,
+		eventClose: SessionClosed,
+	},
+	SessionConnecting: {
+		eventConnected:  SessionConnected,
+		eventFailed:     SessionDisconnected,
+		eventDisconnect: SessionDisconnected,
+		eventClose:      SessionClosed,
+	},
+	SessionConnected: {
+		eventLost:       SessionReconnecting,
+		eventDisconnect: SessionDisconnected,
+		eventClose:      SessionClosed,
+	},
+	SessionReconnecting: {
+		// Each attempt is part of the reconnect; only the last failure
+		// gives up
+		eventDial:       SessionReconnecting,
+		eventConnected:  SessionConnected,
+		eventFailed:     SessionReconnecting,
+		eventGiveUp:     SessionDisconnected,
+		eventDisconnect: SessionDisconnected,
+		eventClose:      SessionClosed,
+	},
+	SessionClosed: {},
+}
+
+// ErrSessionClosed is returned when connecting a closed session
+var ErrSessionClosed = errors.New("session closed")
+
+// errNotReconnecting stops a reconnect when the session was disconnected or
+// closed meanwhile
+var errNotReconnecting = errors.New("session no longer reconnecting")
+
+// nextState returns the state an event moves a session to, and whether the
+// event applies to the state at all
+func nextState(from SessionState, event sessionEvent) (SessionState, bool) {
+	to, ok := sessionTransitions[from][event]
+	return to, ok
+}
+
+// fire moves the session to the event's next state, if the event applies.
+// Must be called with s.mu held.
+func (s *Session) fire(event sessionEvent) bool {
+	to, ok := nextState(s.state, event)
+	if ok {
+		s.state = to
+	}
+	return ok
+}
+
+// State returns the session's lifecycle state
+func (s *Session) State() SessionState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
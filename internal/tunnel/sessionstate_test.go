@@ -0,0 +1,251 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestSessionTransitions(t *testing.T) {
+	tests := []struct {
+		from    SessionState
+		event   sessionEvent
+		to      SessionState
+		applies bool
+	}{
+		{SessionDisconnected, eventDial, SessionConnecting, true},
+		{SessionConnecting, eventConnected, SessionConnected, true},
+		{SessionConnecting, eventFailed, SessionDisconnected, true},
+		{SessionConnected, eventLost, SessionReconnecting, true},
+		{SessionReconnecting, eventFailed, SessionReconnecting, true},
+		{SessionReconnecting, eventGiveUp, SessionDisconnected, true},
+		{SessionReconnecting, eventDisconnect, SessionDisconnected, true},
+		{SessionConnected, eventClose, SessionClosed, true},
+		{SessionDisconnected, eventDisconnect, SessionDisconnected, false},
+		{SessionConnected, eventGiveUp, SessionConnected, false},
+		{SessionDisconnected, eventLost, SessionDisconnected, false},
+		{SessionClosed, eventDial, SessionClosed, false},
+		{SessionClosed, eventClose, SessionClosed, false},
+	}
+	for _, tt := range tests {
+		s := &Session{state: tt.from}
+		if ok := s.fire(tt.event); ok != tt.applies || s.state != tt.to {
+			t.Errorf("%s + event %d = %s (%v), want %s (%v)", tt.from, tt.event, s.state, ok, tt.to, tt.applies)
+		}
+	}
+}
+
+// startKillableSSHServer runs a test SSH server and returns its address and
+// a function dropping every connection it has open
+func startKillableSSHServer(t *testing.T) (string, func()) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var conns []*ssh.ServerConn
+	addr := startTestSSHServer(t, nil, func(conn *ssh.ServerConn, chans <-chan ssh.NewChannel) {
+		mu.Lock()
+		conns = append(conns, conn)
+		mu.Unlock()
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "not supported")
+		}
+	})
+	kill := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		conns = nil
+	}
+	return addr, kill
+}
+
+// newReconnectingSession returns a session to addr that reconnects quickly
+func newReconnectingSession(t *testing.T, addr string, config SessionConfig) *Session {
+	t.Helper()
+
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+	config.Hop = &types.Hop{Host: host, Port: port, User: "testuser", AuthMethod: types.AuthMethodPassword}
+	config.KeepAlive = 20 * time.Millisecond
+	config.AutoReconnect = true
+	config.BackoffConfig = BackoffConfig{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2}
+	session, err := NewSession(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	t.Cleanup(func() { session.Close() })
+	session.config = testClientConfig("testuser")
+	return session
+}
+
+func TestSessionReconnectsAfterConnectionLoss(t *testing.T) {
+	addr, kill := startKillableSSHServer(t)
+	reconnected := make(chan struct{}, 1)
+	session := newReconnectingSession(t, addr, SessionConfig{
+		OnReconnect: func() { reconnected <- struct{}{} },
+	})
+
+	if err := session.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	first := session.Client()
+
+	kill()
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for the session to reconnect, state %s", session.State())
+	}
+	if state := session.State(); state != SessionConnected {
+		t.Errorf("Expected the session to be connected again, got %s", state)
+	}
+	if session.Client() == first {
+		t.Error("Expected a new connection")
+	}
+
+	// The new connection has keep-alives of its own
+	kill()
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for the second reconnect, state %s", session.State())
+	}
+}
+
+func TestSessionDisconnectStopsReconnect(t *testing.T) {
+	addr, kill := startKillableSSHServer(t)
+	gaveUp := make(chan error, 1)
+	lost := make(chan error, 1)
+	session := newReconnectingSession(t, addr, SessionConfig{
+		MaxRetries:   100,
+		OnDisconnect: func(err error) { lost <- err },
+		OnGiveUp:     func(err error) { gaveUp <- err },
+	})
+	if err := session.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	// Every reconnect attempt fails while the hop can't be authenticated to
+	session.mu.Lock()
+	session.config = &ssh.ClientConfig{User: "testuser", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	session.mu.Unlock()
+	kill()
+	select {
+	case <-lost:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the connection loss to be noticed")
+	}
+	if state := session.State(); state != SessionReconnecting {
+		t.Fatalf("Expected the session to be reconnecting, got %s", state)
+	}
+
+	if err := session.Disconnect(); err != nil {
+		t.Fatalf("Disconnect() error = %v", err)
+	}
+	if state := session.State(); state != SessionDisconnected {
+		t.Errorf("Expected the session to be disconnected, got %s", state)
+	}
+	select {
+	case err := <-gaveUp:
+		t.Errorf("Expected a disconnected session to stop reconnecting quietly, gave up with %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// It can be connected again, and closed for good
+	session.mu.Lock()
+	session.config = testClientConfig("testuser")
+	session.mu.Unlock()
+	if err := session.Connect(); err != nil {
+		t.Fatalf("Connect() after Disconnect() error = %v", err)
+	}
+	if err := session.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := session.Connect(); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Expected connecting a closed session to fail, got %v", err)
+	}
+}
+
+func TestSessionConcurrentDisconnectAndReconnect(t *testing.T) {
+	addr, kill := startKillableSSHServer(t)
+	session := newReconnectingSession(t, addr, SessionConfig{MaxRetries: 100})
+	if err := session.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				switch (i + j) % 3 {
+				case 0:
+					kill()
+				case 1:
+					_ = session.Disconnect()
+				case 2:
+					_ = session.Connect()
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Whatever happened, the session settles and can be used again
+	_ = session.Disconnect()
+	if err := session.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if state := session.State(); state != SessionConnected {
+		t.Errorf("Expected the session to be connected, got %s", state)
+	}
+}
+
+func TestSessionReportsNextRetry(t *testing.T) {
+	addr, _ := startKillableSSHServer(t)
+
+	type retry struct {
+		at                   time.Time
+		attempt, maxAttempts int
+	}
+	var retries []retry
+	var session *Session
+	session = newReconnectingSession(t, addr, SessionConfig{
+		MaxRetries: 2,
+		OnRetry: func(at time.Time, attempt, maxAttempts int) {
+			if next := session.HopStatus().NextRetryAt; next == nil || !next.Equal(at) {
+				t.Errorf("Expected the hop status to show the retry at %s, got %v", at, next)
+			}
+			retries = append(retries, retry{at, attempt, maxAttempts})
+		},
+	})
+
+	// Every attempt fails while the hop can't be authenticated to
+	session.config = &ssh.ClientConfig{User: "testuser", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	start := time.Now()
+	if err := session.ConnectWithRetry(); err == nil {
+		t.Fatal("Expected ConnectWithRetry() to fail")
+	}
+
+	if len(retries) != 2 || retries[0].attempt != 2 || retries[1].attempt != 3 || retries[1].maxAttempts != 3 {
+		t.Fatalf("Expected retries 2 and 3 of 3, got %+v", retries)
+	}
+	if retries[0].at.Before(start.Add(10*time.Millisecond)) || !retries[1].at.After(retries[0].at) {
+		t.Errorf("Expected retries after the backoff, got %+v", retries)
+	}
+	if next := session.HopStatus().NextRetryAt; next != nil {
+		t.Errorf("Expected no retry once they ran out, got %v", next)
+	}
+}
@@ -0,0 +1,464 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// DefaultSimulatorLatency is how long simulated SSH handshakes take
+	DefaultSimulatorLatency = 500 * time.Millisecond
+
+	// DefaultSimulatorInterval is how often simulated clients send a request
+	// through each running tunnel
+	DefaultSimulatorInterval = 2 * time.Second
+
+	// simulatorMaxResponse caps the size of simulated destinations' answers
+	simulatorMaxResponse = 256 << 10
+)
+
+// SimulatorConfig tunes a Simulator. Zero values use the defaults.
+type SimulatorConfig struct {
+	Latency  time.Duration // of each hop's handshake, give or take half
+	Interval time.Duration // between simulated requests through each tunnel, give or take half; negative sends none
+}
+
+// Simulator stands in, in memory, for the SSH servers and destinations
+// tunnels connect to, so the whole server can run without any SSH
+// infrastructure (lazytunnel-server --mock). Every hop accepts any user
+// after a short handshake, and hosts under .invalid don't exist, to try out
+// failures. Destinations answer HTTP requests and echo anything else, and
+// simulated clients keep requests flowing through running tunnels so their
+// traffic numbers move.
+type Simulator struct {
+	config  SimulatorConfig
+	server  *ssh.ServerConfig
+	hostKey ssh.PublicKey
+}
+
+// NewSimulator creates a simulator with a fresh host key
+func NewSimulator(config SimulatorConfig) (*Simulator, error) {
+	if config.Latency == 0 {
+		config.Latency = DefaultSimulatorLatency
+	}
+	if config.Interval == 0 {
+		config.Interval = DefaultSimulatorInterval
+	}
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate simulator host key: %w", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate simulator host key: %w", err)
+	}
+	server := &ssh.ServerConfig{
+		NoClientAuth:  true,
+		ServerVersion: "SSH-2.0-lazytunnel-simulator",
+		BannerCallback: func(conn ssh.ConnMetadata) string {
+			return "Simulated by lazytunnel: nothing here is real.\n"
+		},
+	}
+	server.AddHostKey(signer)
+
+	return &Simulator{config: config, server: server, hostKey: signer.PublicKey()}, nil
+}
+
+// SetSimulator makes the manager's tunnels connect to sim instead of real
+// SSH servers from their next connection on; nil connects for real again
+func (m *Manager) SetSimulator(sim *Simulator) {
+	m.simulator.Store(sim)
+}
+
+// Simulator returns the simulator set with SetSimulator, or nil if none is
+func (m *Manager) Simulator() *Simulator {
+	return m.simulator.Load()
+}
+
+// clientConfig is how sessions log in to the simulator: as user, without
+// credentials, trusting only the simulator's host key
+func (sim *Simulator) clientConfig(user string, timeout time.Duration) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            user,
+		Timeout:         timeout,
+		HostKeyCallback: ssh.FixedHostKey(sim.hostKey),
+	}
+}
+
+// dialHop opens a connection to the simulated SSH server at addr
+func (sim *Simulator) dialHop(ctx context.Context, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := sim.pause(ctx, sim.config.Latency); err != nil {
+		return nil, err
+	}
+	if unreachable(host) {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}}
+	}
+
+	client, server := bufferedPipe()
+	go sim.serveConn(server)
+	return client, nil
+}
+
+// DialContext opens a connection to a simulated local destination, for the
+// remote forwards the simulator delivers connections to
+func (sim *Simulator) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client, server := net.Pipe()
+	go sim.serveDestination(server)
+	return client, nil
+}
+
+// bufferedPipe is net.Pipe with a relay in the middle, so both ends can
+// write before reading, as SSH peers do when they exchange versions
+func bufferedPipe() (net.Conn, net.Conn) {
+	client, in := net.Pipe()
+	out, server := net.Pipe()
+	go func() {
+		io.Copy(out, in)
+		out.Close()
+	}()
+	go func() {
+		io.Copy(in, out)
+		in.Close()
+	}()
+	return client, server
+}
+
+// unreachable reports whether a simulated host doesn't exist
+func unreachable(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	return host == "invalid" || strings.HasSuffix(host, ".invalid")
+}
+
+// pause waits around d, giving up when ctx is done
+func (sim *Simulator) pause(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d/2 + time.Duration(rand.Int63n(int64(d))))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// simulatedForward is the payload of tcpip-forward and cancel-tcpip-forward
+// requests, and of the forwarded-tcpip channels opened for them (RFC 4254
+// section 7)
+type simulatedForward struct {
+	Addr string
+	Port uint32
+}
+
+// simulatedOrigin is the payload of direct-tcpip and forwarded-tcpip
+// channels (RFC 4254 section 7)
+type simulatedOrigin struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// serveConn serves one simulated SSH connection: port forwarding both ways,
+// and nothing else
+func (sim *Simulator) serveConn(nc net.Conn) {
+	conn, chans, reqs, err := ssh.NewServerConn(nc, sim.server)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	defer conn.Close()
+
+	go func() {
+		for ch := range chans {
+			if ch.ChannelType() != "direct-tcpip" {
+				ch.Reject(ssh.UnknownChannelType, "only port forwarding is simulated")
+				continue
+			}
+			go sim.serveDirect(ch)
+		}
+	}()
+
+	forwards := make(map[uint32]chan struct{}) // port -> stops its clients
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			var msg simulatedForward
+			if err := ssh.Unmarshal(req.Payload, &msg); err != nil || forwards[msg.Port] != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			var reply []byte
+			if msg.Port == 0 {
+				msg.Port = uint32(32768 + rand.Intn(28232))
+				reply = ssh.Marshal(struct{ Port uint32 }{msg.Port})
+			}
+			stop := make(chan struct{})
+			forwards[msg.Port] = stop
+			req.Reply(true, reply)
+			go sim.driveForward(conn, msg, stop)
+
+		case "cancel-tcpip-forward":
+			var msg simulatedForward
+			if err := ssh.Unmarshal(req.Payload, &msg); err != nil || forwards[msg.Port] == nil {
+				req.Reply(false, nil)
+				continue
+			}
+			close(forwards[msg.Port])
+			delete(forwards, msg.Port)
+			req.Reply(true, nil)
+
+		default:
+			// keepalive@openssh.com gets the same refusal OpenSSH sends
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+
+	for _, stop := range forwards {
+		close(stop)
+	}
+}
+
+// serveDirect connects a direct-tcpip channel to its simulated destination
+func (sim *Simulator) serveDirect(ch ssh.NewChannel) {
+	var msg simulatedOrigin
+	if err := ssh.Unmarshal(ch.ExtraData(), &msg); err != nil {
+		ch.Reject(ssh.ConnectionFailed, "invalid destination")
+		return
+	}
+	if unreachable(msg.Addr) {
+		ch.Reject(ssh.ConnectionFailed, fmt.Sprintf("%s: no such host", msg.Addr))
+		return
+	}
+
+	channel, reqs, err := ch.Accept()
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	sim.serveDestination(channel)
+}
+
+// serveDestination plays a destination on conn: another simulated SSH
+// server for the next hop, a web server for HTTP requests, or an echo
+// server for anything else
+func (sim *Simulator) serveDestination(conn io.ReadWriteCloser) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	head, err := r.Peek(4)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case string(head) == "SSH-":
+		// Chained hops handshake like the first
+		if err := sim.pause(context.Background(), sim.config.Latency); err != nil {
+			return
+		}
+		client, server := net.Pipe()
+		go sim.serveConn(server)
+		go func() {
+			io.Copy(client, r)
+			client.Close()
+		}()
+		io.Copy(conn, client)
+
+	case isHTTPRequest(head):
+		// Only the request line and headers are read: the answer ends the
+		// connection anyway
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "\r\n" || line == "\n" {
+				break
+			}
+		}
+		body := strings.Repeat("lazytunnel ", (512+rand.Intn(simulatorMaxResponse))/11)
+		fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+			len(body), body)
+
+	default:
+		io.Copy(conn, r)
+	}
+}
+
+// isHTTPRequest reports whether a connection starts like an HTTP request
+func isHTTPRequest(head []byte) bool {
+	switch string(head) {
+	case "GET ", "HEAD", "POST", "PUT ", "DELE", "PATC", "OPTI":
+		return true
+	}
+	return false
+}
+
+// driveForward has simulated clients connect to a remote forward, through
+// conn, until stop is closed or conn is
+func (sim *Simulator) driveForward(conn *ssh.ServerConn, forward simulatedForward, stop <-chan struct{}) {
+	closed := make(chan struct{})
+	go func() {
+		conn.Wait()
+		close(closed)
+	}()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-closed:
+			return
+		case <-time.After(sim.interval()):
+		}
+
+		payload := ssh.Marshal(simulatedOrigin{
+			Addr:       forward.Addr,
+			Port:       forward.Port,
+			OriginAddr: fmt.Sprintf("198.51.100.%d", 1+rand.Intn(254)),
+			OriginPort: uint32(32768 + rand.Intn(28232)),
+		})
+		channel, reqs, err := conn.OpenChannel("forwarded-tcpip", payload)
+		if err != nil {
+			continue
+		}
+		go ssh.DiscardRequests(reqs)
+		go simulateRequest(channel, net.JoinHostPort(forward.Addr, strconv.Itoa(int(forward.Port))))
+	}
+}
+
+// driveTraffic has simulated clients send requests through a tunnel's
+// local listener until forwarder is no longer the tunnel's or the manager
+// shuts down. Remote forwards are driven from the simulated server instead.
+func (sim *Simulator) driveTraffic(t *Tunnel, forwarder Forwarder) {
+	var connect func() (net.Conn, error)
+	switch f := forwarder.(type) {
+	case *LocalForwarder:
+		connect = func() (net.Conn, error) {
+			return net.DialTimeout("tcp", f.LocalAddr(), 5*time.Second)
+		}
+	case *DynamicForwarder:
+		connect = func() (net.Conn, error) {
+			return socksConnect(f.LocalAddr(), "app.simulated", 80)
+		}
+	default:
+		return
+	}
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-time.After(sim.interval()):
+		}
+
+		if t.currentForwarder() != forwarder {
+			return
+		}
+
+		conn, err := connect()
+		if err != nil {
+			continue
+		}
+		conn.SetDeadline(time.Now().Add(time.Minute))
+		go simulateRequest(conn, "app.simulated")
+	}
+}
+
+// interval returns how long to wait until the next simulated request
+func (sim *Simulator) interval() time.Duration {
+	d := sim.config.Interval
+	if d < 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+// simulateRequest sends one HTTP request over conn and reads the answer,
+// closing conn after it as a client would rather than waiting for the
+// destination to
+func simulateRequest(conn io.ReadWriteCloser, host string) {
+	defer conn.Close()
+	if _, err := fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", host); err != nil {
+		return
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// socksConnect opens a connection to host:port through the SOCKS5 proxy at
+// addr, as a simulated browser would
+func socksConnect(addr, host string, port int) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	// Greeting without auth, then CONNECT by name
+	reply := make([]byte, 4)
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, reply[:2]); err != nil || reply[1] != 0x00 {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS proxy refused the greeting")
+	}
+	request := append([]byte{0x05, 0x01, 0x00, 0x03, byte(len(host))}, host...)
+	request = append(request, byte(port>>8), byte(port))
+	if _, err := conn.Write(request); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0x00 {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS proxy refused the connection")
+	}
+
+	// Skip the bound address
+	var skip int
+	switch reply[3] {
+	case 0x01:
+		skip = net.IPv4len + 2
+	case 0x04:
+		skip = net.IPv6len + 2
+	case 0x03:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		skip = int(n[0]) + 2
+	}
+	if _, err := io.CopyN(io.Discard, conn, int64(skip)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
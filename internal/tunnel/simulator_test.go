@@ -0,0 +1,99 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func newSimulatedManager(t *testing.T) *Manager {
+	t.Helper()
+	sim, err := NewSimulator(SimulatorConfig{Latency: time.Millisecond, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewSimulator() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	manager := NewManager(ctx)
+	manager.SetSimulator(sim)
+	return manager
+}
+
+func TestSimulatorCarriesTraffic(t *testing.T) {
+	manager := newSimulatedManager(t)
+	hop := types.Hop{Host: "bastion.example.com", Port: 22, User: "ops", AuthMethod: types.AuthMethodAgent}
+	inner := types.Hop{Host: "inner.example.com", Port: 2222, User: "ops", AuthMethod: types.AuthMethodKey, KeyID: "/missing/id_ed25519"}
+	specs := []*types.TunnelSpec{
+		{ID: "local", Name: "local", Type: types.TunnelTypeLocal, LocalBindAddress: "127.0.0.1",
+			RemoteHost: "db.internal", RemotePort: 5432, Hops: []types.Hop{hop}},
+		{ID: "dynamic", Name: "dynamic", Type: types.TunnelTypeDynamic, LocalBindAddress: "127.0.0.1",
+			Hops: []types.Hop{hop, inner}},
+		{ID: "remote", Name: "remote", Type: types.TunnelTypeRemote, LocalPort: 3000, RemotePort: 8080,
+			Hops: []types.Hop{hop}},
+	}
+
+	for _, spec := range specs {
+		if err := manager.Create(context.Background(), spec); err != nil {
+			t.Fatalf("Create(%s) error = %v", spec.ID, err)
+		}
+		defer manager.Stop(context.Background(), spec.ID)
+
+		tun, err := manager.Get(spec.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitForStatus(t, tun, spec.ID+" to be active", func(s *types.TunnelStatus) bool {
+			return s.State == types.TunnelStateActive
+		})
+
+		deadline := time.Now().Add(3 * time.Second)
+		for tun.Stats().BytesReceived == 0 || tun.Stats().BytesSent == 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("No simulated traffic through %s: %+v", spec.ID, tun.Stats())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+func TestSimulatorUnreachableHost(t *testing.T) {
+	manager := newSimulatedManager(t)
+	spec := &types.TunnelSpec{ID: "gone", Name: "gone", Type: types.TunnelTypeLocal, LocalBindAddress: "127.0.0.1",
+		RemoteHost: "db.internal", RemotePort: 5432,
+		Hops: []types.Hop{{Host: "gone.invalid", Port: 22, User: "ops", AuthMethod: types.AuthMethodAgent}}}
+	if err := manager.Create(context.Background(), spec); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	tun, err := manager.Get(spec.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hop fails like a real one would, retrying while it does
+	waitForStatus(t, tun, "the hop to fail", func(s *types.TunnelStatus) bool {
+		return len(s.Hops) == 1 && s.Hops[0].FailureReason == types.FailureDNSNotFound
+	})
+}
+
+func TestSimulatorDestinations(t *testing.T) {
+	sim, err := NewSimulator(SimulatorConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := sim.DialContext(context.Background(), "tcp", "127.0.0.1:3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 5)
+	if _, err := conn.Read(reply); err != nil || string(reply) != "ping\n" {
+		t.Errorf("Expected the destination to echo, got %q, %v", reply, err)
+	}
+}
@@ -0,0 +1,110 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+)
+
+// ListenerFactory opens the local sockets forwarders accept traffic on.
+// *net.ListenConfig is one; others can listen with TLS, on unix sockets or
+// behind the PROXY protocol, or in memory for tests.
+type ListenerFactory interface {
+	Listen(ctx context.Context, network, address string) (net.Listener, error)
+	ListenPacket(ctx context.Context, network, address string) (net.PacketConn, error)
+}
+
+// Dialer opens the local connections remote forwards deliver traffic to.
+// *net.Dialer is one.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// ForwarderOption customizes a forwarder when it is created
+type ForwarderOption func(*socketFactories)
+
+// WithListenerFactory makes forwarders open their local listeners through
+// factory instead of the net package
+func WithListenerFactory(factory ListenerFactory) ForwarderOption {
+	return func(f *socketFactories) {
+		f.listener = factory
+	}
+}
+
+// WithDialer makes forwarders dial local connections through dialer instead
+// of the net package
+func WithDialer(dialer Dialer) ForwarderOption {
+	return func(f *socketFactories) {
+		f.dialer = dialer
+	}
+}
+
+// WithListenerFailure makes forwarders call failed once their listener is
+// broken for good and they stop accepting connections
+func WithListenerFailure(failed func(err error)) ForwarderOption {
+	return func(f *socketFactories) {
+		f.listenerFailed = failed
+	}
+}
+
+// WithResolver makes forwarders look up hop hostnames with resolver, as the
+// session did, rather than asking the system
+func WithResolver(resolver *Resolver) ForwarderOption {
+	return func(f *socketFactories) {
+		f.resolver = resolver
+	}
+}
+
+// socketFactories opens a forwarder's local sockets, and reports when its
+// listener breaks
+type socketFactories struct {
+	listener       ListenerFactory
+	dialer         Dialer
+	listenerFailed func(err error) // may be nil
+	fdGuard        *FDGuard        // may be nil
+	resolver       *Resolver       // may be nil
+	addresses      AddressBook     // may be nil
+	faults         FaultSource     // may be nil
+}
+
+// admit reports whether to serve a connection just accepted, closing it if
+// not: the process is running out of file descriptors
+func (f socketFactories) admit(conn net.Conn) bool {
+	if f.fdGuard.Admit() {
+		return true
+	}
+	conn.Close()
+	return false
+}
+
+// reportListenerFailure passes err to the WithListenerFailure callback, if any
+func (f socketFactories) reportListenerFailure(err error) {
+	if f.listenerFailed != nil {
+		f.listenerFailed(err)
+	}
+}
+
+// newSocketFactories applies opts over the net package defaults
+func newSocketFactories(opts []ForwarderOption) socketFactories {
+	f := socketFactories{
+		listener: &net.ListenConfig{},
+		dialer:   &net.Dialer{},
+	}
+	for _, opt := range opts {
+		opt(&f)
+	}
+	return f
+}
+
+// SetForwarderOptions sets the options every forwarder the manager creates
+// from now on is built with
+func (m *Manager) SetForwarderOptions(opts ...ForwarderOption) {
+	m.forwarderOptions.Store(&opts)
+}
+
+// forwarderOpts returns the options set with SetForwarderOptions
+func (m *Manager) forwarderOpts() []ForwarderOption {
+	if opts := m.forwarderOptions.Load(); opts != nil {
+		return *opts
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// memNetwork is an in-memory ListenerFactory and Dialer: dialing an address
+// connects to whatever listens on it over net.Pipe, so forwarders can be
+// tested without real ports
+type memNetwork struct {
+	mu        sync.Mutex
+	listeners map[string]*memListener
+}
+
+func newMemNetwork() *memNetwork {
+	return &memNetwork{listeners: make(map[string]*memListener)}
+}
+
+func (n *memNetwork) Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, taken := n.listeners[address]; taken {
+		return nil, fmt.Errorf("listen %s %s: address already in use", network, address)
+	}
+	l := &memListener{
+		network: n,
+		addr:    memAddr(address),
+		conns:   make(chan net.Conn),
+		closed:  make(chan struct{}),
+	}
+	n.listeners[address] = l
+	return l, nil
+}
+
+func (n *memNetwork) ListenPacket(ctx context.Context, network, address string) (net.PacketConn, error) {
+	return nil, fmt.Errorf("listen %s %s: not supported in memory", network, address)
+}
+
+func (n *memNetwork) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	n.mu.Lock()
+	l := n.listeners[address]
+	n.mu.Unlock()
+	refused := fmt.Errorf("dial %s %s: connection refused", network, address)
+	if l == nil {
+		return nil, refused
+	}
+
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+	case <-ctx.Done():
+		refused = ctx.Err()
+	}
+	client.Close()
+	server.Close()
+	return nil, refused
+}
+
+// memListener is a listener of a memNetwork
+type memListener struct {
+	network   *memNetwork
+	addr      memAddr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.network.mu.Lock()
+		delete(l.network.listeners, string(l.addr))
+		l.network.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr { return l.addr }
+
+// memAddr is the address of a memListener
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+// serveEcho echoes back every connection accepted on l until it is closed
+func serveEcho(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+	}
+}
+
+// roundTrip writes msg to conn and reads the echo back
+func roundTrip(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf) != msg {
+		t.Errorf("Echo = %q, want %q", buf, msg)
+	}
+}
+
+func TestLocalForwarderListensThroughFactory(t *testing.T) {
+	mem := newMemNetwork()
+	remote := newMemNetwork()
+	backend, _ := remote.Listen(context.Background(), "tcp", "db.internal:5432")
+	defer backend.Close()
+	go serveEcho(backend)
+
+	session := &MockSessionDialer{
+		connected: true,
+		dialFunc: func(network, address string) (net.Conn, error) {
+			return remote.DialContext(context.Background(), network, address)
+		},
+	}
+	spec := &types.TunnelSpec{
+		Type:             types.TunnelTypeLocal,
+		LocalBindAddress: "127.0.0.1",
+		LocalPort:        5432,
+		RemoteHost:       "db.internal",
+		RemotePort:       5432,
+	}
+	forwarder, err := NewLocalForwarder(context.Background(), spec, session, WithListenerFactory(mem))
+	if err != nil {
+		t.Fatalf("NewLocalForwarder() error = %v", err)
+	}
+	if err := forwarder.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer forwarder.ForceStop()
+	if addr := forwarder.LocalAddr(); addr != "127.0.0.1:5432" {
+		t.Errorf("LocalAddr() = %q, want the in-memory listener", addr)
+	}
+
+	client, err := mem.DialContext(context.Background(), "tcp", "127.0.0.1:5432")
+	if err != nil {
+		t.Fatalf("Failed to connect to the forwarder: %v", err)
+	}
+	defer client.Close()
+	roundTrip(t, client, "ping")
+
+	// The factory's errors surface from Start
+	second, _ := NewLocalForwarder(context.Background(), spec, session, WithListenerFactory(mem))
+	if err := second.Start(); err == nil {
+		second.Stop()
+		t.Error("Expected binding a taken address to fail")
+	}
+}
+
+func TestRemoteForwarderDialsThroughDialer(t *testing.T) {
+	mem := newMemNetwork()
+	app, _ := mem.Listen(context.Background(), "tcp", "127.0.0.1:3000")
+	defer app.Close()
+	go serveEcho(app)
+
+	spec := &types.TunnelSpec{Type: types.TunnelTypeRemote, RemotePort: 8080, LocalPort: 3000}
+	forwarder, err := NewRemoteForwarder(context.Background(), spec, &MockSessionDialer{connected: true}, WithDialer(mem))
+	if err != nil {
+		t.Fatalf("NewRemoteForwarder() error = %v", err)
+	}
+
+	// A connection arriving from the SSH server's listener
+	near, far := net.Pipe()
+	tracked := forwarder.activeConns.add(near)
+	go forwarder.handleConnection(near, tracked)
+	roundTrip(t, far, "pong")
+
+	if closed, err := forwarder.ForceStop(); err != nil || closed != 1 {
+		t.Errorf("ForceStop() = %d, %v, want the connection closed", closed, err)
+	}
+}
+
+func TestDialContextTimesOutThroughDialer(t *testing.T) {
+	mem := newMemNetwork()
+	// Listening without accepting leaves dials hanging
+	l, _ := mem.Listen(context.Background(), "tcp", "127.0.0.1:3000")
+	defer l.Close()
+
+	_, err := dialContext(context.Background(), mem, "tcp", "127.0.0.1:3000", 50*time.Millisecond)
+	if !errors.Is(err, ErrDialTimeout) {
+		t.Errorf("Expected ErrDialTimeout, got %v", err)
+	}
+}
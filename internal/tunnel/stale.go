@@ -0,0 +1,128 @@
+package tunnel
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// staleCheckInterval is how often tunnels are checked against their StalePolicy
+const staleCheckInterval = 5 * time.Second
+
+// fillActivity sets the traffic staleness fields of status from the
+// forwarder. Must be called with t.mu held.
+func (t *Tunnel) fillActivity(status *types.TunnelStatus, now time.Time) {
+	status.LastActivity = nil
+	status.StaleSeconds = 0
+
+	if t.forwarder == nil || status.State != types.TunnelStateActive {
+		return
+	}
+
+	last := t.forwarder.Stats().LastActivity
+	if last.IsZero() {
+		return
+	}
+	status.LastActivity = &last
+	if idle := now.Sub(last); idle > 0 {
+		status.StaleSeconds = idle.Seconds()
+	}
+}
+
+// markStale records whether the tunnel is stale, reporting the change to the
+// status callback. It returns false if the tunnel was already in that state.
+func (t *Tunnel) markStale(stale bool) bool {
+	t.mu.Lock()
+
+	if t.Status == nil || t.Status.Stale == stale {
+		t.mu.Unlock()
+		return false
+	}
+	t.Status.Stale = stale
+
+	snapshot := *t.Status
+	t.fillActivity(&snapshot, time.Now())
+	cb := t.statusCallback
+	t.mu.Unlock()
+
+	if cb != nil {
+		cb(t.Spec.ID, &snapshot)
+	}
+	return true
+}
+
+// monitorStaleness periodically applies each tunnel's StalePolicy until the
+// manager's context is cancelled
+func (m *Manager) monitorStaleness() {
+	ticker := time.NewTicker(staleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.checkStale(now)
+		}
+	}
+}
+
+// checkStale marks tunnels that have been idle past their StalePolicy as
+// stale, and no longer stale once traffic resumes. Restart and stop actions
+// run when a tunnel first becomes stale.
+func (m *Manager) checkStale(now time.Time) {
+	for _, t := range m.List() {
+		policy := t.Spec.Staleness
+		if policy.After <= 0 {
+			continue
+		}
+
+		status := t.statusAt(now)
+		if status == nil {
+			continue
+		}
+		stale := status.LastActivity != nil && status.StaleSeconds >= policy.After.Seconds()
+
+		if !t.markStale(stale) || !stale {
+			continue
+		}
+
+		switch policy.Action {
+		case types.StaleActionRestart:
+			go m.restartStale(t.Spec.ID)
+		case types.StaleActionStop:
+			go m.stopStale(t, policy.After)
+		}
+	}
+}
+
+// stopStale stops a tunnel that stopped carrying traffic. Its RestartPolicy
+// may bring it back, since the server rather than a user stopped it.
+func (m *Manager) stopStale(t *Tunnel, after time.Duration) {
+	if err := m.Stop(m.ctx, t.Spec.ID); err != nil {
+		return
+	}
+	m.scheduleRestart(t, types.TunnelStateStopped, fmt.Errorf("no traffic for %s", after))
+}
+
+// restartStale reconnects a tunnel that stopped carrying traffic
+func (m *Manager) restartStale(tunnelID string) {
+	if err := m.Stop(m.ctx, tunnelID); err != nil {
+		return
+	}
+	_ = m.Start(m.ctx, tunnelID)
+}
+
+// statusAt returns a copy of the tunnel status with staleness computed at now
+func (t *Tunnel) statusAt(now time.Time) *types.TunnelStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.Status == nil {
+		return nil
+	}
+	status := *t.Status
+	t.fillActivity(&status, now)
+	return &status
+}
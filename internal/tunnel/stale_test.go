@@ -0,0 +1,123 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// newStaleTunnel registers an active tunnel with an idle forwarder
+func newStaleTunnel(t *testing.T, m *Manager, id string, policy types.StalePolicy) *Tunnel {
+	t.Helper()
+
+	spec := &types.TunnelSpec{
+		ID:         id,
+		Type:       types.TunnelTypeLocal,
+		RemoteHost: "example.com",
+		RemotePort: 80,
+		Staleness:  policy,
+	}
+	forwarder, err := NewLocalForwarder(context.Background(), spec, &MockSessionDialer{connected: true})
+	if err != nil {
+		t.Fatalf("NewLocalForwarder failed: %v", err)
+	}
+
+	tunnel := &Tunnel{
+		Spec:           spec,
+		ctx:            context.Background(),
+		forwarder:      forwarder,
+		statusCallback: m.handleStatusChange,
+	}
+	tunnel.updateStatus(types.TunnelStateActive, "")
+
+	m.mu.Lock()
+	m.tunnels[id] = tunnel
+	m.mu.Unlock()
+	return tunnel
+}
+
+func TestGetStatusReportsLastActivity(t *testing.T) {
+	manager := NewManager(context.Background())
+	tunnel := newStaleTunnel(t, manager, "activity-1", types.StalePolicy{})
+
+	status := tunnel.statusAt(time.Now().Add(90 * time.Second))
+	if status.LastActivity == nil {
+		t.Fatal("Expected last activity to be reported")
+	}
+	if status.StaleSeconds < 90 {
+		t.Errorf("Expected at least 90 stale seconds, got %v", status.StaleSeconds)
+	}
+
+	// Stopped tunnels carry no traffic to measure
+	tunnel.updateStatus(types.TunnelStateStopped, "")
+	if status := tunnel.GetStatus(); status.LastActivity != nil || status.StaleSeconds != 0 {
+		t.Errorf("Expected no activity for a stopped tunnel, got %v / %v", status.LastActivity, status.StaleSeconds)
+	}
+}
+
+func TestCheckStaleNotifies(t *testing.T) {
+	manager := NewManager(context.Background())
+	tunnel := newStaleTunnel(t, manager, "stale-notify", types.StalePolicy{
+		After:  time.Minute,
+		Action: types.StaleActionNotify,
+	})
+
+	events := make(chan types.TunnelStatus, 8)
+	sub := manager.Subscribe(func(_ string, status *types.TunnelStatus) { events <- *status }, 0)
+	defer sub.Unsubscribe()
+
+	// Not idle long enough yet
+	manager.checkStale(time.Now())
+	if tunnel.GetStatus().Stale {
+		t.Fatal("Expected tunnel not to be stale yet")
+	}
+
+	manager.checkStale(time.Now().Add(2 * time.Minute))
+	if !tunnel.GetStatus().Stale {
+		t.Fatal("Expected tunnel to be stale")
+	}
+
+	select {
+	case status := <-events:
+		if !status.Stale || status.LastActivity == nil {
+			t.Errorf("Expected a stale event with last activity, got %+v", status)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a status event when the tunnel became stale")
+	}
+
+	// Notify leaves the tunnel running
+	if state := tunnel.GetStatus().State; state != types.TunnelStateActive {
+		t.Errorf("Expected tunnel to stay active, got %s", state)
+	}
+
+	// Traffic resuming clears the flag
+	tunnel.forwarder.(*LocalForwarder).activity.Touch()
+	manager.checkStale(time.Now())
+	if tunnel.GetStatus().Stale {
+		t.Error("Expected stale flag to clear once traffic resumes")
+	}
+}
+
+func TestCheckStaleStops(t *testing.T) {
+	manager := NewManager(context.Background())
+	tunnel := newStaleTunnel(t, manager, "stale-stop", types.StalePolicy{
+		After:  time.Minute,
+		Action: types.StaleActionStop,
+	})
+
+	manager.checkStale(time.Now().Add(2 * time.Minute))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for tunnel.GetStatus().State != types.TunnelStateStopped {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected stale tunnel to be stopped, got %s", tunnel.GetStatus().State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if tunnel.GetStatus().Stale {
+		t.Error("Expected stale flag to clear once stopped")
+	}
+}
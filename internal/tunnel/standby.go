@@ -0,0 +1,201 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// standbyMessage is the status detail of tunnels another instance runs
+const standbyMessage = "standby: running on the leader instance"
+
+// SetStandby switches the manager between leading and standing by in a
+// high-availability setup, where several servers share one storage and only
+// the leader runs tunnels. Once it has been called, create, start and stop
+// persist desired states, so whichever instance leads acts on them.
+// Standing by stops the tunnels this instance runs but leaves their desired
+// states alone; leading starts the tunnels whose desired state is active.
+func (m *Manager) SetStandby(standby bool) {
+	m.clustered.Store(true)
+	if m.standby.Swap(standby) == standby {
+		return
+	}
+
+	if standby {
+		for _, t := range m.List() {
+			if m.runOnThisNode(t.Spec.AgentID) {
+				_ = t.Stop()
+			}
+		}
+		return
+	}
+
+	// States taken over from the previous leader don't describe this
+	// instance, which runs nothing yet
+	for _, t := range m.List() {
+		if status := t.GetStatus(); m.runOnThisNode(t.Spec.AgentID) && status != nil && status.State != types.TunnelStateStopped {
+			t.updateStatus(types.TunnelStateStopped, "")
+		}
+	}
+	go m.RestoreDesired(m.ctx)
+}
+
+// Standby reports whether the manager stands by for another instance
+func (m *Manager) Standby() bool {
+	return m.standby.Load()
+}
+
+// SyncFromStorage catches up with changes other instances sharing the
+// storage made: tunnels they created, deleted, restored or purged, and
+// desired states they set, which the leader acts on by starting or stopping
+// tunnels. Standing by, tunnel states are taken from storage too, where
+// the leader records them.
+func (m *Manager) SyncFromStorage(ctx context.Context) error {
+	if m.storage == nil {
+		return fmt.Errorf("no storage configured")
+	}
+
+	specs, err := m.storage.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list tunnels from storage: %w", err)
+	}
+	var stored map[string]string
+	if lister, ok := m.storage.(StatusLister); ok {
+		if stored, err = lister.ListStatuses(ctx); err != nil {
+			return fmt.Errorf("failed to list tunnel statuses from storage: %w", err)
+		}
+	}
+
+	standby := m.standby.Load()
+	var start, stop []string // acted on once the lock is released
+	var removed []*Tunnel    // deleted or purged elsewhere, stopped once unlocked
+
+	m.mu.Lock()
+	changed := false
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		seen[spec.ID] = true
+		live, isLive := m.tunnels[spec.ID]
+		_, isDeleted := m.deleted[spec.ID]
+		local := RunOnThisNode(m.nodeAgentID, spec.AgentID)
+
+		switch {
+		case spec.DeletedAt != nil && isLive:
+			delete(m.tunnels, spec.ID)
+			m.deleted[spec.ID] = m.storedTunnel(spec)
+			m.notifyStatusWatch(spec.ID, m.version.Add(1), true)
+			removed = append(removed, live)
+			changed = true
+
+		case spec.DeletedAt != nil && !isDeleted:
+			m.deleted[spec.ID] = m.storedTunnel(spec)
+			changed = true
+
+		case spec.DeletedAt == nil && !isLive:
+			delete(m.deleted, spec.ID)
+			live = m.storedTunnel(spec)
+			m.tunnels[spec.ID] = live
+			if !standby && local && spec.DesiredStatus == types.DesiredStatusActive {
+				start = append(start, spec.ID)
+			}
+			changed = true
+
+		case isLive && live.Spec.DesiredStatus != spec.DesiredStatus:
+			live.Spec.DesiredStatus = spec.DesiredStatus
+			if !standby && local {
+				if spec.DesiredStatus == types.DesiredStatusActive {
+					if status := live.GetStatus(); status != nil && status.State == types.TunnelStateActive {
+						break
+					}
+					start = append(start, spec.ID)
+				} else {
+					stop = append(stop, spec.ID)
+				}
+			}
+		}
+
+		// The leader's view of the tunnels it runs
+		if standby && live != nil && spec.DeletedAt == nil {
+			if state, ok := stored[spec.ID]; ok {
+				if status := live.GetStatus(); status == nil || string(status.State) != state {
+					message := ""
+					if local {
+						message = standbyMessage
+					}
+					live.updateStatus(types.TunnelState(state), message)
+				}
+			}
+		}
+	}
+
+	for id, t := range m.tunnels {
+		if !seen[id] {
+			delete(m.tunnels, id)
+			m.notifyStatusWatch(id, m.version.Add(1), true)
+			removed = append(removed, t)
+		}
+	}
+	for id := range m.deleted {
+		if !seen[id] {
+			delete(m.deleted, id)
+			changed = true
+		}
+	}
+	if changed {
+		m.version.Add(1)
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, t := range removed {
+		if err := t.Stop(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop tunnel %s: %w", t.Spec.ID, err))
+		}
+	}
+	for _, id := range stop {
+		if err := m.Stop(ctx, id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, id := range start {
+		if err := m.Start(ctx, id); err != nil {
+			errs = append(errs, fmt.Errorf("failed to start tunnel %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// storedTunnel tracks a tunnel another instance added to the storage. It
+// starts out stopped; standing by, SyncFromStorage then takes its state
+// from storage.
+func (m *Manager) storedTunnel(spec *types.TunnelSpec) *Tunnel {
+	return &Tunnel{
+		Spec:           spec,
+		CreatedAt:      spec.CreatedAt,
+		ctx:            m.ctx,
+		statusCallback: m.handleStatusChange,
+		transitionHook: m.runHooks,
+		Status: &types.TunnelStatus{
+			TunnelID: spec.ID,
+			State:    types.TunnelStateStopped,
+		},
+	}
+}
+
+// persistDesired records a tunnel's desired state when instances share the
+// storage, for whichever of them leads
+func (m *Manager) persistDesired(ctx context.Context, tunnel *Tunnel, desired types.DesiredStatus) error {
+	if !m.clustered.Load() {
+		return nil
+	}
+	tunnel.Spec.DesiredStatus = desired
+	if m.storage == nil {
+		return nil
+	}
+	if err := m.storage.UpdateDesiredStatus(ctx, tunnel.Spec.ID, desired); err != nil {
+		return fmt.Errorf("failed to update desired status in storage: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// sharedStorage hands out copies of the stored specs, as a database shared
+// by several instances would
+type sharedStorage struct {
+	*memoryStorage
+}
+
+func (s sharedStorage) List(ctx context.Context) ([]*types.TunnelSpec, error) {
+	specs, _ := s.memoryStorage.List(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copies := make([]*types.TunnelSpec, len(specs))
+	for i, spec := range specs {
+		spec := *spec
+		copies[i] = &spec
+	}
+	return copies, nil
+}
+
+func TestStandbyFailover(t *testing.T) {
+	ctx := context.Background()
+	store := sharedStorage{newMemoryStorage()}
+
+	leader := NewManager(ctx)
+	leader.SetStorage(store)
+	leader.SetStandby(false)
+
+	standby := NewManager(ctx)
+	standby.SetStorage(store)
+	standby.SetStandby(true)
+
+	// Created through the standby instance, run by the leader
+	spec := newFailingSpec("ha-1", types.RestartPolicy{})
+	if err := standby.Create(ctx, spec); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if spec.DesiredStatus != types.DesiredStatusActive {
+		t.Errorf("Expected the desired state to be persisted as active, got %q", spec.DesiredStatus)
+	}
+	waitForStatus(t, mustGet(t, standby, "ha-1"), "standby", func(s *types.TunnelStatus) bool {
+		return s.State == types.TunnelStatePending && s.LastError == standbyMessage
+	})
+
+	if err := leader.SyncFromStorage(ctx); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	// The leader tries to connect, which fails without hops
+	waitForStatus(t, mustGet(t, leader, "ha-1"), "failure", func(s *types.TunnelStatus) bool {
+		return s.State == types.TunnelStateFailed
+	})
+
+	// The standby reports the state the leader recorded
+	if err := standby.SyncFromStorage(ctx); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if state := mustGet(t, standby, "ha-1").GetStatus().State; state != types.TunnelStateFailed {
+		t.Errorf("Expected the standby to report the leader's state, got %s", state)
+	}
+
+	// Stopping through the standby stops it on the leader
+	if err := standby.Stop(ctx, "ha-1"); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if err := leader.SyncFromStorage(ctx); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if state := mustGet(t, leader, "ha-1").GetStatus().State; state != types.TunnelStateStopped {
+		t.Errorf("Expected the leader to stop the tunnel, got %s", state)
+	}
+
+	// Deleting elsewhere moves it to the deleted tunnels
+	if err := standby.Delete(ctx, "ha-1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := leader.SyncFromStorage(ctx); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if _, err := leader.Get("ha-1"); err == nil {
+		t.Error("Expected the tunnel deleted elsewhere to be gone")
+	}
+	if len(leader.ListDeleted()) != 1 {
+		t.Error("Expected the tunnel deleted elsewhere to be restorable")
+	}
+
+	// Taking over: the former leader stands by, the standby leads
+	leader.SetStandby(true)
+	standby.SetStandby(false)
+	if !leader.Standby() || standby.Standby() {
+		t.Error("Expected the roles to be swapped")
+	}
+}
+
+func mustGet(t *testing.T, m *Manager, id string) *Tunnel {
+	t.Helper()
+	tunnel, err := m.Get(id)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	return tunnel
+}
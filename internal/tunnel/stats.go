@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// forwarderCounters are a forwarder's live statistics. Connections update
+// them concurrently with Stats reading them, so every update is one change
+// that a snapshot sees entirely or not at all: writing counts the updates in
+// progress and seq the finished ones, and a read that overlapped either is
+// retried. Updates are brief, so retries are rare and short; a snapshot that
+// keeps overlapping them under heavy traffic gives up after a few and reads
+// with updates paused instead.
+type forwarderCounters struct {
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+	connections   atomic.Int64
+	activeConns   atomic.Int64
+	errors        atomic.Int64
+	timeouts      atomic.Int64
+	dialRetries   atomic.Int64
+	goroutines    atomic.Int64
+	openSockets   atomic.Int64
+	bufferBytes   atomic.Int64
+
+	writing  atomic.Int64
+	seq      atomic.Uint64
+	updating sync.RWMutex // shared by updates, held alone by a paused read
+
+	// Set before the forwarder is shared and never changed
+	startedAt time.Time
+}
+
+// snapshotRetries is how many reads a snapshot tries before pausing updates
+const snapshotRetries = 8
+
+// add adds n to each of counters, as one update
+func (c *forwarderCounters) add(n int64, counters ...*atomic.Int64) {
+	c.updating.RLock()
+	c.writing.Add(1)
+	for _, counter := range counters {
+		counter.Add(n)
+	}
+	c.seq.Add(1)
+	c.writing.Add(-1)
+	c.updating.RUnlock()
+}
+
+// failed counts a failed connection, and a timeout if err is one
+func (c *forwarderCounters) failed(err error) {
+	if isTimeout(err) {
+		c.add(1, &c.errors, &c.timeouts)
+		return
+	}
+	c.add(1, &c.errors)
+}
+
+// snapshot returns the counters as of one moment between updates, with
+// lastActivity, which the forwarder's activity clock keeps
+func (c *forwarderCounters) snapshot(lastActivity time.Time) ForwarderStats {
+	for range snapshotRetries {
+		seq := c.seq.Load()
+		idle := c.writing.Load() == 0
+		stats := c.read(lastActivity, seq)
+		if idle && c.writing.Load() == 0 && c.seq.Load() == seq {
+			return stats
+		}
+		runtime.Gosched()
+	}
+
+	// Updates in progress finish and new ones wait, so nothing moves
+	c.updating.Lock()
+	defer c.updating.Unlock()
+	return c.read(lastActivity, c.seq.Load())
+}
+
+// read loads the counters, which are only consistent when no update
+// overlapped it
+func (c *forwarderCounters) read(lastActivity time.Time, seq uint64) ForwarderStats {
+	return ForwarderStats{
+		BytesSent:     c.bytesSent.Load(),
+		BytesReceived: c.bytesReceived.Load(),
+		Connections:   c.connections.Load(),
+		ActiveConns:   c.activeConns.Load(),
+		Errors:        c.errors.Load(),
+		Timeouts:      c.timeouts.Load(),
+		DialRetries:   c.dialRetries.Load(),
+		StartedAt:     c.startedAt,
+		LastActivity:  lastActivity,
+		Goroutines:    c.goroutines.Load(),
+		OpenSockets:   c.openSockets.Load(),
+		BufferBytes:   c.bufferBytes.Load(),
+		Seq:           seq,
+	}
+}
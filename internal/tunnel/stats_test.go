@@ -0,0 +1,134 @@
+package tunnel
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestForwarderCountersSnapshotIsConsistent(t *testing.T) {
+	var stats forwarderCounters
+	stats.startedAt = time.Now()
+	last := stats.snapshot(time.Time{})
+
+	const writers, updates = 4, 2000
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				stats.add(1, &stats.connections, &stats.activeConns)
+				stats.add(100, &stats.bytesSent)
+				stats.add(-1, &stats.activeConns)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		snap := stats.snapshot(time.Time{})
+		if snap.ActiveConns > snap.Connections || snap.ActiveConns < 0 {
+			t.Fatalf("Torn snapshot: %d active of %d connections", snap.ActiveConns, snap.Connections)
+		}
+		if snap.Seq < last.Seq || snap.Connections < last.Connections || snap.BytesSent < last.BytesSent {
+			t.Fatalf("Snapshot went back: %+v after %+v", snap, last)
+		}
+		if snap.Seq == last.Seq && snap != last {
+			t.Fatalf("Counters changed without the sequence: %+v after %+v", snap, last)
+		}
+		last = snap
+	}
+
+	final := stats.snapshot(time.Time{})
+	if final.Connections != writers*updates || final.ActiveConns != 0 || final.BytesSent != writers*updates*100 {
+		t.Errorf("Final stats = %+v", final)
+	}
+	if final.Seq != 3*writers*updates {
+		t.Errorf("Seq = %d, want one per update (%d)", final.Seq, 3*writers*updates)
+	}
+}
+
+func TestForwarderCountersSnapshotGivesUp(t *testing.T) {
+	var stats forwarderCounters
+	stats.add(5, &stats.connections)
+	// As if every read overlapped an update
+	stats.writing.Add(1)
+
+	got := make(chan ForwarderStats)
+	go func() { got <- stats.snapshot(time.Time{}) }()
+	select {
+	case snap := <-got:
+		if snap.Connections != 5 || snap.Seq != 1 {
+			t.Errorf("Snapshot = %+v, want 5 connections at seq 1", snap)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Snapshot kept retrying")
+	}
+}
+
+// countingForwarder forwards nothing but counts as if it did
+type countingForwarder struct {
+	stats    forwarderCounters
+	activity activityClock
+}
+
+func (f *countingForwarder) Start() error          { return nil }
+func (f *countingForwarder) Stop() error           { return nil }
+func (f *countingForwarder) Stats() ForwarderStats { return f.stats.snapshot(f.activity.Time()) }
+
+func TestTunnelStatusReadsConcurrently(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(ctx)
+	createTunnels(t, manager, &types.TunnelSpec{ID: "busy", Name: "busy", Type: types.TunnelTypeLocal})
+	tunnel, _ := manager.Get("busy")
+	forwarder := &countingForwarder{}
+	tunnel.mu.Lock()
+	tunnel.forwarder = forwarder
+	tunnel.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			forwarder.stats.add(10, &forwarder.stats.bytesSent)
+			forwarder.activity.Touch()
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				tunnel.GetStatus()
+				tunnel.Stats()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			tunnel.UpdateStatus(types.TunnelStateActive, "")
+		}
+	}()
+	wg.Wait()
+
+	if status := tunnel.GetStatus(); status.BytesSent != 10000 {
+		t.Errorf("BytesSent = %d, want 10000", status.BytesSent)
+	}
+}
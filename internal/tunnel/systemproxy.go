@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// ErrSystemProxyUnsupported is returned on platforms whose proxy settings
+// lazytunnel can't change
+var ErrSystemProxyUnsupported = errors.New("system proxy settings aren't supported on this platform")
+
+// SystemProxy changes the operating system's proxy settings, which browsers
+// and most other applications follow: networksetup on macOS, WinINET on
+// Windows and gsettings on GNOME
+type SystemProxy interface {
+	// Register makes host:port the system's SOCKS proxy
+	Register(host string, port int) error
+	// Deregister turns the system's SOCKS proxy off
+	Deregister() error
+}
+
+// NewSystemProxy returns the proxy settings of the platform the process
+// runs on
+func NewSystemProxy() SystemProxy {
+	return platformSystemProxy()
+}
+
+// SetSystemProxy makes dynamic tunnels asking for it register with proxy
+// instead of the platform's settings
+func (m *Manager) SetSystemProxy(proxy SystemProxy) {
+	m.proxyOwner.Lock()
+	defer m.proxyOwner.Unlock()
+	m.proxyOwner.proxy = proxy
+}
+
+// systemProxyOwner tracks which tunnel the system proxy points at: only
+// one can be at a time, and the last registered wins
+type systemProxyOwner struct {
+	sync.Mutex
+	proxy    SystemProxy // nil = the platform's
+	tunnelID string      // empty = none registered
+}
+
+// registerSystemProxy makes a dynamic tunnel's SOCKS listener the system
+// proxy, returning what turns it off again if the tunnel still owns it
+func (m *Manager) registerSystemProxy(spec *types.TunnelSpec, forwarder Forwarder) (func(), error) {
+	addr, port := boundEndpoint(forwarder)
+	if port == 0 {
+		return nil, fmt.Errorf("system proxy: SOCKS proxy isn't listening")
+	}
+	host := proxyHost(addr, spec.LocalFamily)
+	tunnelID := spec.ID
+
+	m.proxyOwner.Lock()
+	defer m.proxyOwner.Unlock()
+	if m.proxyOwner.proxy == nil {
+		m.proxyOwner.proxy = NewSystemProxy()
+	}
+	proxy := m.proxyOwner.proxy
+	if err := proxy.Register(host, port); err != nil {
+		return nil, fmt.Errorf("system proxy: %w", err)
+	}
+	m.proxyOwner.tunnelID = tunnelID
+
+	return func() {
+		m.proxyOwner.Lock()
+		defer m.proxyOwner.Unlock()
+		if m.proxyOwner.tunnelID != tunnelID {
+			return // another tunnel took over
+		}
+		m.proxyOwner.tunnelID = ""
+		// Best effort: the tunnel is going away either way
+		_ = proxy.Deregister()
+	}, nil
+}
+
+// SystemProxyTunnel returns the ID of the tunnel the system proxy points
+// at, or "" if none
+func (m *Manager) SystemProxyTunnel() string {
+	m.proxyOwner.Lock()
+	defer m.proxyOwner.Unlock()
+	return m.proxyOwner.tunnelID
+}
+
+// proxyHost returns the host applications should reach a listener on addr
+// at: loopback for a listener on every address, IPv4 unless the listener
+// only takes IPv6
+func proxyHost(addr string, family types.AddressFamily) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "127.0.0.1"
+	}
+	ip, err := netip.ParseAddr(host)
+	switch {
+	case err != nil:
+		return host // localhost
+	case ip.IsUnspecified() && family == types.AddressFamilyIPv6:
+		return "::1"
+	case ip.IsUnspecified():
+		return "127.0.0.1"
+	}
+	return ip.String()
+}
@@ -0,0 +1,70 @@
+//go:build darwin
+
+package tunnel
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// networkSetupProxy sets the SOCKS proxy of every enabled network service
+// with networksetup
+type networkSetupProxy struct{}
+
+func platformSystemProxy() SystemProxy {
+	return networkSetupProxy{}
+}
+
+func (networkSetupProxy) Register(host string, port int) error {
+	services, err := networkServices()
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		// Setting the proxy also turns it on
+		if err := networkSetup("-setsocksfirewallproxy", service, host, strconv.Itoa(port)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (networkSetupProxy) Deregister() error {
+	services, err := networkServices()
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		if err := networkSetup("-setsocksfirewallproxystate", service, "off"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// networkServices lists the enabled network services, e.g. "Wi-Fi"
+func networkServices() ([]string, error) {
+	out, err := exec.Command("networksetup", "-listallnetworkservices").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network services: %w", err)
+	}
+
+	var services []string
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	// The first line explains that an asterisk marks disabled services
+	for _, line := range lines[1:] {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "*") {
+			services = append(services, line)
+		}
+	}
+	return services, nil
+}
+
+func networkSetup(args ...string) error {
+	if out, err := exec.Command("networksetup", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("networksetup %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// gsettingsProxy sets GNOME's proxy settings, which GNOME applications,
+// Chrome and Firefox (by default) follow
+type gsettingsProxy struct{}
+
+func platformSystemProxy() SystemProxy {
+	return gsettingsProxy{}
+}
+
+func (gsettingsProxy) Register(host string, port int) error {
+	if err := gsettings("org.gnome.system.proxy.socks", "host", host); err != nil {
+		return err
+	}
+	if err := gsettings("org.gnome.system.proxy.socks", "port", strconv.Itoa(port)); err != nil {
+		return err
+	}
+	return gsettings("org.gnome.system.proxy", "mode", "manual")
+}
+
+func (gsettingsProxy) Deregister() error {
+	return gsettings("org.gnome.system.proxy", "mode", "none")
+}
+
+func gsettings(schema, key, value string) error {
+	if _, err := exec.LookPath("gsettings"); err != nil {
+		return fmt.Errorf("%w: needs gsettings (GNOME)", ErrSystemProxyUnsupported)
+	}
+	out, err := exec.Command("gsettings", "set", schema, key, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("gsettings set %s %s: %w: %s", schema, key, err, strings.TrimSpace(string(out)))
+	}
+	// gsettings reports some failures, e.g. without a D-Bus session, only
+	// as warnings
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return errors.New("gsettings: " + msg)
+	}
+	return nil
+}
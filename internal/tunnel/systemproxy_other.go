@@ -0,0 +1,18 @@
+//go:build !darwin && !linux && !windows
+
+package tunnel
+
+// unsupportedProxy fails, on platforms without known proxy settings
+type unsupportedProxy struct{}
+
+func platformSystemProxy() SystemProxy {
+	return unsupportedProxy{}
+}
+
+func (unsupportedProxy) Register(host string, port int) error {
+	return ErrSystemProxyUnsupported
+}
+
+func (unsupportedProxy) Deregister() error {
+	return ErrSystemProxyUnsupported
+}
@@ -0,0 +1,124 @@
+package tunnel
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// fakeSystemProxy records what tunnels register instead of touching the
+// machine's settings
+type fakeSystemProxy struct {
+	mu         sync.Mutex
+	registered string
+	port       int
+	enabled    bool
+}
+
+func (p *fakeSystemProxy) Register(host string, port int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.registered, p.port, p.enabled = host, port, true
+	return nil
+}
+
+func (p *fakeSystemProxy) Deregister() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.enabled = false
+	return nil
+}
+
+func (p *fakeSystemProxy) state() (string, int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.registered, p.port, p.enabled
+}
+
+func TestSystemProxyFollowsTunnel(t *testing.T) {
+	manager := newSimulatedManager(t)
+	proxy := &fakeSystemProxy{}
+	manager.SetSystemProxy(proxy)
+
+	hop := types.Hop{Host: "bastion.example.com", Port: 22, User: "ops", AuthMethod: types.AuthMethodAgent}
+	newSpec := func(id string) *types.TunnelSpec {
+		return &types.TunnelSpec{ID: id, Name: id, Type: types.TunnelTypeDynamic, LocalBindAddress: "0.0.0.0",
+			Hops: []types.Hop{hop}, SystemProxy: true}
+	}
+
+	first := newSpec("first")
+	if err := manager.Create(context.Background(), first); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	tun, err := manager.Get(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := waitForStatus(t, tun, "the tunnel to be active", func(s *types.TunnelStatus) bool {
+		return s.State == types.TunnelStateActive
+	})
+
+	host, port, enabled := proxy.state()
+	if !enabled || host != "127.0.0.1" || port != status.BoundPort {
+		t.Errorf("Registered %s:%d (enabled %v), want 127.0.0.1:%d", host, port, enabled, status.BoundPort)
+	}
+	if owner := manager.SystemProxyTunnel(); owner != first.ID {
+		t.Errorf("SystemProxyTunnel() = %q, want %q", owner, first.ID)
+	}
+
+	// A second tunnel takes over, and stopping the first leaves it alone
+	second := newSpec("second")
+	if err := manager.Create(context.Background(), second); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	defer manager.Stop(context.Background(), second.ID)
+	tun, err = manager.Get(second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, tun, "the second tunnel to be active", func(s *types.TunnelStatus) bool {
+		return s.State == types.TunnelStateActive
+	})
+	if owner := manager.SystemProxyTunnel(); owner != second.ID {
+		t.Errorf("SystemProxyTunnel() = %q, want %q", owner, second.ID)
+	}
+	if err := manager.Stop(context.Background(), first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, enabled := proxy.state(); !enabled {
+		t.Error("Stopping a tunnel that no longer owns the system proxy turned it off")
+	}
+
+	if err := manager.Stop(context.Background(), second.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, enabled := proxy.state(); enabled {
+		t.Error("System proxy still enabled after its tunnel stopped")
+	}
+	if owner := manager.SystemProxyTunnel(); owner != "" {
+		t.Errorf("SystemProxyTunnel() = %q after stopping, want none", owner)
+	}
+}
+
+func TestProxyHost(t *testing.T) {
+	tests := []struct {
+		addr   string
+		family types.AddressFamily
+		want   string
+	}{
+		{"127.0.0.1:1080", "", "127.0.0.1"},
+		{"0.0.0.0:1080", "", "127.0.0.1"},
+		{"[::]:1080", "", "127.0.0.1"},
+		{"[::]:1080", types.AddressFamilyIPv6, "::1"},
+		{"[::1]:1080", types.AddressFamilyIPv6, "::1"},
+		{"192.168.1.10:1080", "", "192.168.1.10"},
+		{"localhost:1080", "", "localhost"},
+	}
+	for _, tt := range tests {
+		if got := proxyHost(tt.addr, tt.family); got != tt.want {
+			t.Errorf("proxyHost(%q, %q) = %q, want %q", tt.addr, tt.family, got, tt.want)
+		}
+	}
+}
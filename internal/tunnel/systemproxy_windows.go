@@ -0,0 +1,73 @@
+//go:build windows
+
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// internetSettingsKey holds the current user's WinINET proxy settings,
+// which Edge, Chrome and most other applications follow
+const internetSettingsKey = `Software\Microsoft\Windows\CurrentVersion\Internet Settings`
+
+// WinINET options telling running applications to reload the settings
+const (
+	internetOptionRefresh         = 37
+	internetOptionSettingsChanged = 39
+)
+
+var (
+	wininet                = windows.NewLazySystemDLL("wininet.dll")
+	procInternetSetOptionW = wininet.NewProc("InternetSetOptionW")
+)
+
+// winINetProxy sets the current user's WinINET proxy
+type winINetProxy struct{}
+
+func platformSystemProxy() SystemProxy {
+	return winINetProxy{}
+}
+
+func (winINetProxy) Register(host string, port int) error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to open internet settings: %w", err)
+	}
+	defer key.Close()
+
+	if err := key.SetStringValue("ProxyServer", "socks="+net.JoinHostPort(host, strconv.Itoa(port))); err != nil {
+		return fmt.Errorf("failed to set proxy server: %w", err)
+	}
+	if err := key.SetDWordValue("ProxyEnable", 1); err != nil {
+		return fmt.Errorf("failed to enable proxy: %w", err)
+	}
+	return refreshInternetSettings()
+}
+
+func (winINetProxy) Deregister() error {
+	key, err := registry.OpenKey(registry.CURRENT_USER, internetSettingsKey, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to open internet settings: %w", err)
+	}
+	defer key.Close()
+
+	if err := key.SetDWordValue("ProxyEnable", 0); err != nil {
+		return fmt.Errorf("failed to disable proxy: %w", err)
+	}
+	return refreshInternetSettings()
+}
+
+// refreshInternetSettings makes running applications pick up the changes
+func refreshInternetSettings() error {
+	for _, option := range []uintptr{internetOptionSettingsChanged, internetOptionRefresh} {
+		if ok, _, err := procInternetSetOptionW.Call(0, option, 0, 0); ok == 0 {
+			return fmt.Errorf("failed to refresh internet settings: %w", err)
+		}
+	}
+	return nil
+}
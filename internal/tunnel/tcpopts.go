@@ -0,0 +1,161 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// DefaultDialTimeout bounds dials through the tunnel when the spec doesn't
+// set a connect timeout
+const DefaultDialTimeout = 30 * time.Second
+
+// DefaultDialRetryBackoff is the delay before the first dial retry when the
+// spec doesn't set one
+const DefaultDialRetryBackoff = 200 * time.Millisecond
+
+// maxDialRetryBackoff caps the doubling delay between dial retries
+const maxDialRetryBackoff = 5 * time.Second
+
+// ErrDialTimeout is returned when a dial through the tunnel exceeds the
+// tunnel's connect timeout
+var ErrDialTimeout = errors.New("dial timed out")
+
+// applyTCPOptions applies per-tunnel socket options to a forwarded connection.
+// Connections that are not TCP sockets (e.g. SSH channels) are left untouched.
+func applyTCPOptions(conn net.Conn, opts types.TCPOptions) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	var errs []error
+
+	if opts.NoDelay != nil {
+		if err := tcpConn.SetNoDelay(*opts.NoDelay); err != nil {
+			errs = append(errs, fmt.Errorf("set nodelay: %w", err))
+		}
+	}
+
+	switch {
+	case opts.KeepAlive > 0:
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			errs = append(errs, fmt.Errorf("enable keepalive: %w", err))
+		} else if err := tcpConn.SetKeepAlivePeriod(opts.KeepAlive); err != nil {
+			errs = append(errs, fmt.Errorf("set keepalive period: %w", err))
+		}
+	case opts.KeepAlive < 0:
+		if err := tcpConn.SetKeepAlive(false); err != nil {
+			errs = append(errs, fmt.Errorf("disable keepalive: %w", err))
+		}
+	}
+
+	if opts.ReadBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(opts.ReadBuffer); err != nil {
+			errs = append(errs, fmt.Errorf("set read buffer: %w", err))
+		}
+	}
+
+	if opts.WriteBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(opts.WriteBuffer); err != nil {
+			errs = append(errs, fmt.Errorf("set write buffer: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// dialTimeout returns the effective connect timeout for a tunnel
+func dialTimeout(opts types.TCPOptions) time.Duration {
+	if opts.ConnectTimeout > 0 {
+		return opts.ConnectTimeout
+	}
+	return DefaultDialTimeout
+}
+
+// dialWithRetry calls dial until it succeeds or the tunnel's retry policy
+// gives up, holding the client connection open in the meantime. Without a
+// hold timeout it makes at most DialRetries extra attempts; with one it keeps
+// retrying until the hold window has passed. Retries stop early when stop
+// is closed. The last dial error is returned on failure.
+func dialWithRetry(opts types.TCPOptions, stop <-chan struct{}, stats *forwarderCounters, dial func() (net.Conn, error)) (net.Conn, error) {
+	conn, err := dial()
+	if err == nil || (opts.DialRetries <= 0 && opts.HoldTimeout <= 0) {
+		return conn, err
+	}
+
+	backoff := opts.DialRetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultDialRetryBackoff
+	}
+
+	var holdUntil time.Time
+	if opts.HoldTimeout > 0 {
+		holdUntil = time.Now().Add(opts.HoldTimeout)
+	}
+
+	for attempt := 1; ; attempt++ {
+		wait := backoff
+		if holdUntil.IsZero() {
+			if attempt > opts.DialRetries {
+				return nil, err
+			}
+		} else {
+			remaining := time.Until(holdUntil)
+			if remaining <= 0 {
+				return nil, err
+			}
+			if wait > remaining {
+				wait = remaining
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+
+		stats.add(1, &stats.dialRetries)
+		if conn, err = dial(); err == nil {
+			return conn, nil
+		}
+
+		backoff *= 2
+		if backoff > maxDialRetryBackoff {
+			backoff = maxDialRetryBackoff
+		}
+	}
+}
+
+// isTimeout reports whether err is a dial or I/O timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, ErrDialTimeout) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// dialContext dials address through dialer, an SSH session or the local
+// network, giving up after timeout or as soon as ctx is done, e.g. when the
+// tunnel stops. A zero timeout waits for the dial as long as ctx allows.
+func dialContext(ctx context.Context, dialer Dialer, network, address string, timeout time.Duration) (net.Conn, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrDialTimeout)
+		defer cancel()
+	}
+
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil && errors.Is(context.Cause(ctx), ErrDialTimeout) {
+		return nil, fmt.Errorf("dial %s after %s: %w", address, timeout, ErrDialTimeout)
+	}
+	return conn, err
+}
@@ -0,0 +1,247 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestApplyTCPOptions(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			defer conn.Close()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	noDelay := false
+	opts := types.TCPOptions{
+		NoDelay:     &noDelay,
+		KeepAlive:   10 * time.Second,
+		ReadBuffer:  256 * 1024,
+		WriteBuffer: 256 * 1024,
+	}
+	if err := applyTCPOptions(conn, opts); err != nil {
+		t.Errorf("applyTCPOptions() error = %v", err)
+	}
+
+	// Non-TCP connections are ignored
+	pipeA, pipeB := net.Pipe()
+	defer pipeA.Close()
+	defer pipeB.Close()
+	if err := applyTCPOptions(pipeA, opts); err != nil {
+		t.Errorf("applyTCPOptions() on pipe error = %v", err)
+	}
+}
+
+func TestDialSessionTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	slowSession := &MockSessionDialer{
+		connected: true,
+		dialFunc: func(network, address string) (net.Conn, error) {
+			<-release
+			conn, _ := net.Pipe()
+			return conn, nil
+		},
+	}
+
+	start := time.Now()
+	_, err := dialContext(context.Background(), slowSession, "tcp", "db.internal:5432", 50*time.Millisecond)
+	if !errors.Is(err, ErrDialTimeout) {
+		t.Fatalf("dialContext() error = %v, want ErrDialTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("dialContext() took %v, expected to give up after timeout", elapsed)
+	}
+
+	fastSession := &MockSessionDialer{
+		connected: true,
+		dialFunc: func(network, address string) (net.Conn, error) {
+			conn, _ := net.Pipe()
+			return conn, nil
+		},
+	}
+
+	conn, err := dialContext(context.Background(), fastSession, "tcp", "db.internal:5432", time.Second)
+	if err != nil {
+		t.Fatalf("dialContext() error = %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialSessionCanceled(t *testing.T) {
+	release := make(chan struct{})
+	lateConn, remote := net.Pipe()
+	defer remote.Close()
+	hungSession := &MockSessionDialer{
+		connected: true,
+		dialFunc: func(network, address string) (net.Conn, error) {
+			<-release
+			return lateConn, nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err := dialContext(ctx, hungSession, "tcp", "db.internal:5432", 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("dialContext() error = %v, want context.Canceled", err)
+	}
+	if errors.Is(err, ErrDialTimeout) {
+		t.Error("Expected a canceled dial not to count as a timeout")
+	}
+
+	// The connection completing after the dial gave up is closed
+	close(release)
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := remote.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected the late connection to be closed, got %v", err)
+	}
+}
+
+func TestLocalForwarderStopCancelsDials(t *testing.T) {
+	dialing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	hungSession := &MockSessionDialer{
+		connected: true,
+		dialFunc: func(network, address string) (net.Conn, error) {
+			dialing <- struct{}{}
+			<-release
+			return nil, errors.New("connection refused")
+		},
+	}
+	spec := &types.TunnelSpec{
+		Type:       types.TunnelTypeLocal,
+		LocalPort:  0,
+		RemoteHost: "db.internal",
+		RemotePort: 5432,
+		TCP:        types.TCPOptions{ConnectTimeout: time.Hour},
+	}
+	forwarder, err := NewLocalForwarder(context.Background(), spec, hungSession)
+	if err != nil {
+		t.Fatalf("NewLocalForwarder() error = %v", err)
+	}
+	if err := forwarder.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	client, err := net.Dial("tcp", forwarder.LocalAddr())
+	if err != nil {
+		t.Fatalf("Failed to connect to the forwarder: %v", err)
+	}
+	defer client.Close()
+	<-dialing
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- forwarder.Stop() }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Stop() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Stop not to wait for a hung dial")
+	}
+}
+
+// flakyDial returns a dial func that fails the given number of times, then succeeds
+func flakyDial(failures int) (func() (net.Conn, error), *int) {
+	calls := 0
+	return func() (net.Conn, error) {
+		calls++
+		if calls <= failures {
+			return nil, errors.New("connection refused")
+		}
+		conn, _ := net.Pipe()
+		return conn, nil
+	}, &calls
+}
+
+func TestDialWithRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      types.TCPOptions
+		failures  int
+		wantErr   bool
+		wantCalls int
+	}{
+		{"no retries", types.TCPOptions{}, 1, true, 1},
+		{"recovers within retries", types.TCPOptions{DialRetries: 3, DialRetryBackoff: time.Millisecond}, 2, false, 3},
+		{"retries exhausted", types.TCPOptions{DialRetries: 2, DialRetryBackoff: time.Millisecond}, 5, true, 3},
+		{"hold keeps retrying", types.TCPOptions{HoldTimeout: time.Second, DialRetryBackoff: time.Millisecond}, 6, false, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats forwarderCounters
+			dial, calls := flakyDial(tt.failures)
+
+			conn, err := dialWithRetry(tt.opts, make(chan struct{}), &stats, dial)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dialWithRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if conn != nil {
+				conn.Close()
+			}
+			if *calls != tt.wantCalls {
+				t.Errorf("dial called %d times, want %d", *calls, tt.wantCalls)
+			}
+			if stats.dialRetries.Load() != int64(tt.wantCalls-1) {
+				t.Errorf("DialRetries = %d, want %d", stats.dialRetries.Load(), tt.wantCalls-1)
+			}
+		})
+	}
+}
+
+func TestDialWithRetryHoldExpires(t *testing.T) {
+	var stats forwarderCounters
+	dial, _ := flakyDial(1 << 30)
+
+	start := time.Now()
+	_, err := dialWithRetry(types.TCPOptions{HoldTimeout: 100 * time.Millisecond, DialRetryBackoff: 10 * time.Millisecond}, make(chan struct{}), &stats, dial)
+	if err == nil {
+		t.Fatal("dialWithRetry() succeeded, want error after hold timeout")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("dialWithRetry() gave up after %v, want about the hold timeout", elapsed)
+	}
+}
+
+func TestDialWithRetryStops(t *testing.T) {
+	var stats forwarderCounters
+	dial, calls := flakyDial(1 << 30)
+
+	stop := make(chan struct{})
+	close(stop)
+
+	_, err := dialWithRetry(types.TCPOptions{DialRetries: 5, DialRetryBackoff: time.Second}, stop, &stats, dial)
+	if err == nil {
+		t.Fatal("dialWithRetry() succeeded, want error")
+	}
+	if *calls != 1 {
+		t.Errorf("dial called %d times after stop, want 1", *calls)
+	}
+}
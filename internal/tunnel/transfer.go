@@ -0,0 +1,282 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrNotConnected is returned for operations that need a connected SSH session
+var ErrNotConnected = errors.New("tunnel is not connected")
+
+// RemoteFile is a file being downloaded from a hop. Read returns exactly
+// Size bytes; Close must be called to end the transfer.
+type RemoteFile struct {
+	Name string
+	Size int64
+	Mode os.FileMode
+
+	reader  io.Reader
+	ack     *bufio.Reader
+	stdin   io.WriteCloser
+	session *ssh.Session
+	read    int64
+}
+
+// Read reads the file contents
+func (f *RemoteFile) Read(p []byte) (int, error) {
+	n, err := f.reader.Read(p)
+	f.read += int64(n)
+	return n, err
+}
+
+// Close confirms a completely read file to the remote scp and ends the session
+func (f *RemoteFile) Close() error {
+	var err error
+	if f.read == f.Size {
+		if err = scpReadAck(f.ack); err == nil {
+			_, err = f.stdin.Write([]byte{0})
+		}
+	}
+	f.stdin.Close()
+	f.session.Close()
+	return err
+}
+
+// hopClient returns the SSH client of a hop, counting from 0; a negative
+// index selects the last hop
+func (t *Tunnel) hopClient(hop int) (*ssh.Client, error) {
+	session, err := t.hopSession(hop)
+	if err != nil {
+		return nil, err
+	}
+	client := session.Client()
+	if client == nil {
+		return nil, ErrNotConnected
+	}
+	return client, nil
+}
+
+// hopSession returns the session of a hop like hopClient, connected or not
+func (t *Tunnel) hopSession(hop int) (*Session, error) {
+	t.mu.RLock()
+	var sessions []*Session
+	switch {
+	case t.multiSession != nil:
+		sessions = t.multiSession.hops
+	case t.session != nil:
+		sessions = []*Session{t.session}
+	}
+	t.mu.RUnlock()
+
+	if len(sessions) == 0 {
+		return nil, ErrNotConnected
+	}
+	if hop < 0 {
+		hop = len(sessions) - 1
+	}
+	if hop >= len(sessions) {
+		return nil, fmt.Errorf("tunnel has %d hops, no hop %d", len(sessions), hop)
+	}
+	return sessions[hop], nil
+}
+
+// Upload writes size bytes from r to remotePath on a hop over the tunnel's
+// existing SSH connection, using the remote scp. A negative hop selects the
+// last hop.
+func (t *Tunnel) Upload(hop int, remotePath string, mode os.FileMode, size int64, r io.Reader) error {
+	client, err := t.hopClient(hop)
+	if err != nil {
+		return err
+	}
+	return scpUpload(client, remotePath, mode, size, r)
+}
+
+// Download opens remotePath on a hop over the tunnel's existing SSH
+// connection, using the remote scp. A negative hop selects the last hop.
+func (t *Tunnel) Download(hop int, remotePath string) (*RemoteFile, error) {
+	client, err := t.hopClient(hop)
+	if err != nil {
+		return nil, err
+	}
+	return scpDownload(client, remotePath)
+}
+
+// scpUpload sends one file with the scp sink protocol ("scp -t")
+func scpUpload(client *ssh.Client, remotePath string, mode os.FileMode, size int64, r io.Reader) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open session: %w", err)
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open stdin: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open stdout: %w", err)
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+
+	if err := session.Start("scp -t " + shellQuote(remotePath)); err != nil {
+		return fmt.Errorf("failed to start scp: %w", err)
+	}
+	ack := bufio.NewReader(stdout)
+
+	fail := func(err error) error {
+		stdin.Close()
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w (%s)", err, msg)
+		}
+		return err
+	}
+
+	if err := scpReadAck(ack); err != nil {
+		return fail(err)
+	}
+	if _, err := fmt.Fprintf(stdin, "C%04o %d %s\n", mode.Perm(), size, path.Base(remotePath)); err != nil {
+		return fail(fmt.Errorf("failed to send file header: %w", err))
+	}
+	if err := scpReadAck(ack); err != nil {
+		return fail(err)
+	}
+	if _, err := io.CopyN(stdin, r, size); err != nil {
+		return fail(fmt.Errorf("failed to send file: %w", err))
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return fail(fmt.Errorf("failed to finish file: %w", err))
+	}
+	if err := scpReadAck(ack); err != nil {
+		return fail(err)
+	}
+
+	stdin.Close()
+	if err := session.Wait(); err != nil {
+		return fail(fmt.Errorf("scp failed: %w", err))
+	}
+	return nil
+}
+
+// scpDownload requests one file with the scp source protocol ("scp -f")
+func scpDownload(client *ssh.Client, remotePath string) (*RemoteFile, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session: %w", err)
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to open stdin: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to open stdout: %w", err)
+	}
+
+	if err := session.Start("scp -f " + shellQuote(remotePath)); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start scp: %w", err)
+	}
+	ack := bufio.NewReader(stdout)
+
+	fail := func(err error) (*RemoteFile, error) {
+		stdin.Close()
+		session.Close()
+		return nil, err
+	}
+
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return fail(fmt.Errorf("failed to start transfer: %w", err))
+	}
+
+	header, err := scpReadRecord(ack)
+	if err != nil {
+		return fail(err)
+	}
+	file, err := parseSCPFileHeader(header)
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return fail(fmt.Errorf("failed to accept file: %w", err))
+	}
+
+	file.reader = io.LimitReader(ack, file.Size)
+	file.ack = ack
+	file.stdin = stdin
+	file.session = session
+	return file, nil
+}
+
+// parseSCPFileHeader parses a "C<mode> <size> <name>" record
+func parseSCPFileHeader(header string) (*RemoteFile, error) {
+	if !strings.HasPrefix(header, "C") {
+		return nil, fmt.Errorf("unexpected scp record %q (is the path a directory?)", header)
+	}
+
+	parts := strings.SplitN(header[1:], " ", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed scp file header %q", header)
+	}
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("malformed scp file mode %q", parts[0])
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("malformed scp file size %q", parts[1])
+	}
+
+	return &RemoteFile{Name: parts[2], Size: size, Mode: os.FileMode(mode).Perm()}, nil
+}
+
+// scpReadAck reads the status byte scp sends after each step
+func scpReadAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("scp closed the connection: %w", err)
+	}
+	if b == 0 {
+		return nil
+	}
+
+	// 1 is a warning and 2 a fatal error, both followed by a message
+	msg, _ := r.ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+}
+
+// scpReadRecord reads a control record, turning a warning or error into an error
+func scpReadRecord(r *bufio.Reader) (string, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return "", fmt.Errorf("scp closed the connection: %w", err)
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("scp closed the connection: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+
+	if b == 1 || b == 2 {
+		return "", fmt.Errorf("scp: %s", line)
+	}
+	return string(b) + line, nil
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
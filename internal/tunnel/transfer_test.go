@@ -0,0 +1,177 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// fakeSCP emulates the remote end of "scp -t" and "scp -f" over an
+// in-memory file system
+type fakeSCP struct {
+	mu    sync.Mutex
+	files map[string]string
+	modes map[string]os.FileMode
+}
+
+func (f *fakeSCP) handle(_ *ssh.ServerConn, chans <-chan ssh.NewChannel) {
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "sessions only")
+			continue
+		}
+		ch, reqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range reqs {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				ssh.Unmarshal(req.Payload, &payload)
+				req.Reply(true, nil)
+				go f.run(ch, payload.Command)
+			}
+		}()
+	}
+}
+
+func (f *fakeSCP) run(ch ssh.Channel, command string) {
+	defer ch.Close()
+	status := uint32(0)
+	defer func() {
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+	}()
+
+	fields := strings.SplitN(command, " ", 3)
+	if len(fields) != 3 || fields[0] != "scp" {
+		status = 127
+		return
+	}
+	remotePath := strings.Trim(fields[2], "'")
+	r := bufio.NewReader(ch)
+
+	switch fields[1] {
+	case "-t":
+		ch.Write([]byte{0})
+		header, _ := r.ReadString('\n')
+		var mode os.FileMode
+		var size int64
+		var name string
+		fmt.Sscanf(header, "C%o %d %s", &mode, &size, &name)
+		ch.Write([]byte{0})
+		data := make([]byte, size)
+		io.ReadFull(r, data)
+		r.ReadByte()
+
+		f.mu.Lock()
+		f.files[remotePath] = string(data)
+		f.modes[remotePath] = mode
+		f.mu.Unlock()
+		ch.Write([]byte{0})
+
+	case "-f":
+		r.ReadByte()
+		f.mu.Lock()
+		data, ok := f.files[remotePath]
+		mode := f.modes[remotePath]
+		f.mu.Unlock()
+		if !ok {
+			fmt.Fprintf(ch, "\x01scp: %s: No such file or directory\n", remotePath)
+			status = 1
+			return
+		}
+		fmt.Fprintf(ch, "C%04o %d %s\n", mode, len(data), remotePath[strings.LastIndex(remotePath, "/")+1:])
+		r.ReadByte()
+		io.WriteString(ch, data)
+		ch.Write([]byte{0})
+		r.ReadByte()
+	}
+}
+
+// newTransferTunnel returns a tunnel whose session is connected to a fake scp server
+func newTransferTunnel(t *testing.T, fs *fakeSCP) *Tunnel {
+	t.Helper()
+
+	addr := startTestSSHServer(t, nil, fs.handle)
+
+	hop := &types.Hop{Host: "bastion", Port: 22, User: "deploy", AuthMethod: types.AuthMethodPassword}
+	session, err := NewSession(context.Background(), SessionConfig{Hop: hop})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	t.Cleanup(func() { session.Close() })
+	session.config = testClientConfig(hop.User)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	if err := session.connectOverConn(conn); err != nil {
+		t.Fatalf("connectOverConn() error = %v", err)
+	}
+
+	return &Tunnel{Spec: &types.TunnelSpec{ID: "transfer"}, session: session}
+}
+
+func TestTunnelUploadDownload(t *testing.T) {
+	fs := &fakeSCP{files: map[string]string{}, modes: map[string]os.FileMode{}}
+	tunnel := newTransferTunnel(t, fs)
+
+	content := "listen: 0.0.0.0:8080\n"
+	if err := tunnel.Upload(-1, "/etc/app/app.yaml", 0600, int64(len(content)), strings.NewReader(content)); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if fs.files["/etc/app/app.yaml"] != content || fs.modes["/etc/app/app.yaml"] != 0600 {
+		t.Fatalf("Unexpected remote file %q (mode %o)", fs.files["/etc/app/app.yaml"], fs.modes["/etc/app/app.yaml"])
+	}
+
+	file, err := tunnel.Download(-1, "/etc/app/app.yaml")
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if string(data) != content || file.Name != "app.yaml" || file.Size != int64(len(content)) || file.Mode != 0600 {
+		t.Errorf("Unexpected download %q (%s, %d bytes, mode %o)", data, file.Name, file.Size, file.Mode)
+	}
+}
+
+func TestTunnelDownloadMissingFile(t *testing.T) {
+	fs := &fakeSCP{files: map[string]string{}, modes: map[string]os.FileMode{}}
+	tunnel := newTransferTunnel(t, fs)
+
+	_, err := tunnel.Download(-1, "/nope")
+	if err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Errorf("Expected scp's error to be reported, got %v", err)
+	}
+
+	if _, err := tunnel.Download(3, "/nope"); err == nil {
+		t.Error("Expected an error for a hop the tunnel doesn't have")
+	}
+}
+
+func TestTransferRequiresConnection(t *testing.T) {
+	tunnel := &Tunnel{Spec: &types.TunnelSpec{ID: "idle"}}
+	if _, err := tunnel.Download(-1, "/etc/hosts"); err != ErrNotConnected {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+}
@@ -0,0 +1,302 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// redirector installs and removes the NAT rules that send traffic for the
+// tunnel's routes to the local transparent listener
+type redirector interface {
+	Install(routes []string, excludes []string, port int) error
+	Remove() error
+}
+
+// TransparentForwarder implements sshuttle-style layer-3 forwarding.
+// Outbound TCP connections to the configured CIDRs are redirected by the
+// kernel to a local listener; the original destination is recovered from the
+// socket and dialed through the SSH session.
+type TransparentForwarder struct {
+	spec       *types.TunnelSpec
+	session    SessionDialer
+	sockets    socketFactories
+	listener   net.Listener
+	redirector redirector
+
+	// Stats
+	stats    forwarderCounters
+	activity activityClock
+
+	// Connection tracking
+	activeConns connTracker
+	mu          sync.RWMutex
+
+	// Lifecycle
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTransparentForwarder creates a new transparent (layer-3) forwarder
+func NewTransparentForwarder(ctx context.Context, spec *types.TunnelSpec, session SessionDialer, opts ...ForwarderOption) (*TransparentForwarder, error) {
+	if spec.Type != types.TunnelTypeTransparent {
+		return nil, fmt.Errorf("invalid tunnel type: expected transparent, got %s", spec.Type)
+	}
+
+	if len(spec.Routes) == 0 {
+		return nil, fmt.Errorf("at least one route is required for transparent forwarding")
+	}
+
+	for _, route := range spec.Routes {
+		ip, _, err := net.ParseCIDR(route)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %q: %w", route, err)
+		}
+		if ip.To4() == nil {
+			return nil, fmt.Errorf("invalid route %q: only IPv4 routes are supported", route)
+		}
+	}
+
+	if spec.LocalPort < 0 {
+		return nil, fmt.Errorf("invalid local port: %d", spec.LocalPort)
+	}
+
+	redir, err := newRedirector(spec.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	fwdCtx, cancel := context.WithCancel(ctx)
+
+	tf := &TransparentForwarder{
+		spec:       spec,
+		session:    session,
+		sockets:    newSocketFactories(opts),
+		redirector: redir,
+		ctx:        fwdCtx,
+		cancel:     cancel,
+		stopCh:     make(chan struct{}),
+	}
+
+	tf.stats.startedAt = time.Now()
+	tf.activity.Touch()
+
+	return tf, nil
+}
+
+// Start binds the local redirect listener and installs the NAT rules
+func (tf *TransparentForwarder) Start() error {
+	tf.mu.Lock()
+	if tf.listener != nil {
+		tf.mu.Unlock()
+		return fmt.Errorf("forwarder already started")
+	}
+
+	// Redirected traffic arrives on loopback; never expose this listener
+	listener, addr, err := tf.sockets.listen(tf.ctx, tf.spec, true)
+	if err != nil {
+		tf.mu.Unlock()
+		return fmt.Errorf("failed to bind to %s: %w", addr, err)
+	}
+
+	// The NAT rules redirect to a TCP port
+	tcpAddr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		listener.Close()
+		tf.mu.Unlock()
+		return fmt.Errorf("transparent forwarding needs a TCP listener, got %s", listener.Addr().Network())
+	}
+	port := tcpAddr.Port
+	if tf.spec.LocalPort == 0 {
+		tf.spec.LocalPort = port
+	}
+
+	// Exclude the first hop so the SSH connection itself is not redirected
+	var excludes []string
+	if hops, err := ResolveHops(tf.ctx, tf.sockets.addresses, tf.spec.Hops); err == nil && len(hops) > 0 {
+		if addrs, err := tf.sockets.resolver.LookupHost(tf.ctx, hops[0].Host); err == nil {
+			excludes = addrs
+		}
+	}
+
+	if err := tf.redirector.Install(tf.spec.Routes, excludes, port); err != nil {
+		listener.Close()
+		tf.mu.Unlock()
+		return fmt.Errorf("failed to install redirect rules: %w", err)
+	}
+
+	tf.listener = listener
+	tf.stats.add(1, &tf.stats.openSockets)
+	tf.mu.Unlock()
+
+	go tf.acceptLoop()
+
+	return nil
+}
+
+// acceptLoop accepts redirected connections
+func (tf *TransparentForwarder) acceptLoop() {
+	tf.stats.add(1, &tf.stats.goroutines)
+	defer tf.stats.add(-1, &tf.stats.goroutines)
+
+	var backoff acceptBackoff
+	for {
+		select {
+		case <-tf.stopCh:
+			return
+		case <-tf.ctx.Done():
+			return
+		default:
+		}
+
+		tf.mu.RLock()
+		listener := tf.listener
+		tf.mu.RUnlock()
+
+		if listener == nil {
+			return
+		}
+
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-tf.stopCh:
+				return
+			case <-tf.ctx.Done():
+				return
+			default:
+			}
+
+			tf.stats.add(1, &tf.stats.errors)
+			if backoff.failed(err) {
+				tf.sockets.reportListenerFailure(err)
+				return
+			}
+			if !backoff.pause(tf.stopCh, tf.ctx.Done()) {
+				return
+			}
+			continue
+		}
+		backoff.reset()
+		if !tf.sockets.admit(conn) {
+			tf.stats.add(1, &tf.stats.errors)
+			continue
+		}
+
+		tracked := tf.activeConns.add(conn)
+		go tf.handleConnection(conn, tracked)
+	}
+}
+
+// handleConnection forwards a redirected connection to its original destination
+func (tf *TransparentForwarder) handleConnection(clientConn net.Conn, tracked *trackedConn) {
+	defer tf.activeConns.done(tracked)
+	defer clientConn.Close()
+
+	tf.stats.add(1, &tf.stats.connections, &tf.stats.activeConns)
+	defer tf.stats.add(-1, &tf.stats.activeConns)
+	tf.stats.add(1, &tf.stats.goroutines)
+	defer tf.stats.add(-1, &tf.stats.goroutines)
+	tf.stats.add(1, &tf.stats.openSockets)
+	defer tf.stats.add(-1, &tf.stats.openSockets)
+
+	if !tf.session.IsConnected() {
+		tf.stats.add(1, &tf.stats.errors)
+		return
+	}
+
+	// Recover where the client was actually trying to connect
+	destAddr, err := originalDestination(clientConn)
+	if err != nil {
+		tf.stats.add(1, &tf.stats.errors)
+		return
+	}
+
+	applyTCPOptions(clientConn, tf.spec.TCP)
+
+	remoteConn, err := dialWithRetry(tf.spec.TCP, tf.stopCh, &tf.stats, func() (net.Conn, error) {
+		return dialContext(tf.ctx, tf.session, "tcp", destAddr, dialTimeout(tf.spec.TCP))
+	})
+	if err != nil {
+		tf.stats.failed(err)
+		return
+	}
+	defer remoteConn.Close()
+	tracked.hold(remoteConn)
+	tf.stats.add(1, &tf.stats.openSockets)
+	defer tf.stats.add(-1, &tf.stats.openSockets)
+
+	tracked.setTarget(destAddr)
+	proxyConns(clientConn, remoteConn, &tf.stats, &tf.activity, tf.spec.TCP.IdleTimeout, tracked)
+}
+
+// Stop removes the NAT rules, stops the listener and waits for connections to close
+func (tf *TransparentForwarder) Stop() error {
+	_, err := tf.stop(false)
+	return err
+}
+
+// ForceStop removes the NAT rules and stops the listener, closing active
+// connections at once
+func (tf *TransparentForwarder) ForceStop() (int, error) {
+	return tf.stop(true)
+}
+
+// stop tears the forwarder down, returning how many connections it closed
+func (tf *TransparentForwarder) stop(force bool) (int, error) {
+	var closed int
+	var err error
+	tf.stopOnce.Do(func() {
+		close(tf.stopCh)
+		tf.cancel()
+
+		tf.mu.Lock()
+		if tf.listener != nil {
+			// Remove rules first so new connections aren't redirected to a closed port
+			if removeErr := tf.redirector.Remove(); removeErr != nil {
+				err = fmt.Errorf("failed to remove redirect rules: %w", removeErr)
+			}
+			if closeErr := tf.listener.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+			tf.listener = nil
+			tf.stats.add(-1, &tf.stats.openSockets)
+		}
+		tf.mu.Unlock()
+
+		var drainErr error
+		closed, drainErr = tf.activeConns.drain(drainTimeout(tf.spec), force)
+		if drainErr != nil {
+			err = drainErr
+		}
+	})
+
+	return closed, err
+}
+
+// Stats returns the current forwarder statistics
+func (tf *TransparentForwarder) Stats() ForwarderStats {
+	return tf.stats.snapshot(tf.activity.Time())
+}
+
+// Connections lists the active connections
+func (tf *TransparentForwarder) Connections() []ConnectionInfo {
+	return tf.activeConns.connections()
+}
+
+// LocalAddr returns the local redirect listener address
+func (tf *TransparentForwarder) LocalAddr() string {
+	tf.mu.RLock()
+	defer tf.mu.RUnlock()
+
+	if tf.listener != nil {
+		return tf.listener.Addr().String()
+	}
+	return ""
+}
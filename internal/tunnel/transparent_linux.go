@@ -0,0 +1,113 @@
+//go:build linux
+
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// soOriginalDst is the netfilter socket option holding the pre-NAT destination
+const soOriginalDst = 80
+
+// iptablesRedirector manages a per-tunnel nat chain using iptables
+type iptablesRedirector struct {
+	chain string
+}
+
+// newRedirector creates the platform redirector for a tunnel
+func newRedirector(tunnelID string) (redirector, error) {
+	if _, err := exec.LookPath("iptables"); err != nil {
+		return nil, fmt.Errorf("transparent tunnels require iptables: %w", err)
+	}
+
+	// iptables chain names are limited to 28 characters
+	id := strings.ReplaceAll(tunnelID, "-", "")
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return &iptablesRedirector{chain: "LAZYTUNNEL-" + strings.ToUpper(id)}, nil
+}
+
+// Install creates the chain, adds a REDIRECT rule per route and hooks it into OUTPUT
+func (r *iptablesRedirector) Install(routes []string, excludes []string, port int) error {
+	if err := r.iptables("-N", r.chain); err != nil {
+		return err
+	}
+
+	for _, addr := range excludes {
+		if ip := net.ParseIP(addr); ip == nil || ip.To4() == nil {
+			continue
+		}
+		if err := r.iptables("-A", r.chain, "-d", addr, "-j", "RETURN"); err != nil {
+			r.Remove()
+			return err
+		}
+	}
+
+	for _, route := range routes {
+		if err := r.iptables("-A", r.chain, "-p", "tcp", "-d", route,
+			"-j", "REDIRECT", "--to-ports", strconv.Itoa(port)); err != nil {
+			r.Remove()
+			return err
+		}
+	}
+
+	if err := r.iptables("-I", "OUTPUT", "1", "-p", "tcp", "-j", r.chain); err != nil {
+		r.Remove()
+		return err
+	}
+
+	return nil
+}
+
+// Remove unhooks, flushes and deletes the chain
+func (r *iptablesRedirector) Remove() error {
+	// Best effort: each step may fail if Install was partial
+	r.iptables("-D", "OUTPUT", "-p", "tcp", "-j", r.chain)
+	r.iptables("-F", r.chain)
+	return r.iptables("-X", r.chain)
+}
+
+// iptables runs a command against the nat table
+func (r *iptablesRedirector) iptables(args ...string) error {
+	cmd := exec.Command("iptables", append([]string{"-t", "nat"}, args...)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("iptables %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// originalDestination returns the pre-NAT destination of a redirected connection
+func originalDestination(conn net.Conn) (string, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return "", fmt.Errorf("not a TCP connection")
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return "", fmt.Errorf("failed to access socket: %w", err)
+	}
+
+	var addr *syscall.IPv6Mreq
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		// The sockaddr_in is returned in an IPv6Mreq-sized buffer
+		addr, sockErr = syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst)
+	}); err != nil {
+		return "", fmt.Errorf("failed to access socket: %w", err)
+	}
+	if sockErr != nil {
+		return "", fmt.Errorf("failed to read original destination: %w", sockErr)
+	}
+
+	raw := addr.Multiaddr
+	ip := net.IPv4(raw[4], raw[5], raw[6], raw[7])
+	port := int(raw[2])<<8 | int(raw[3])
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// newRedirector creates the platform redirector for a tunnel
+func newRedirector(tunnelID string) (redirector, error) {
+	return nil, fmt.Errorf("transparent tunnels are not supported on %s", runtime.GOOS)
+}
+
+// originalDestination returns the pre-NAT destination of a redirected connection
+func originalDestination(conn net.Conn) (string, error) {
+	return "", fmt.Errorf("transparent tunnels are not supported on %s", runtime.GOOS)
+}
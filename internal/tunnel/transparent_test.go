@@ -0,0 +1,57 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestNewTransparentForwarderValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		spec *types.TunnelSpec
+	}{
+		{
+			name: "invalid tunnel type",
+			spec: &types.TunnelSpec{
+				ID:     "test-1",
+				Type:   types.TunnelTypeLocal,
+				Routes: []string{"10.0.0.0/8"},
+			},
+		},
+		{
+			name: "no routes",
+			spec: &types.TunnelSpec{
+				ID:   "test-2",
+				Type: types.TunnelTypeTransparent,
+			},
+		},
+		{
+			name: "malformed route",
+			spec: &types.TunnelSpec{
+				ID:     "test-3",
+				Type:   types.TunnelTypeTransparent,
+				Routes: []string{"10.0.0.0"},
+			},
+		},
+		{
+			name: "IPv6 route",
+			spec: &types.TunnelSpec{
+				ID:     "test-4",
+				Type:   types.TunnelTypeTransparent,
+				Routes: []string{"fd00::/8"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSession := &MockSessionDialer{connected: true}
+
+			if _, err := NewTransparentForwarder(context.Background(), tt.spec, mockSession); err == nil {
+				t.Error("NewTransparentForwarder() expected error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,488 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// UDPRelayCommand is executed on the last hop to relay framed datagrams to
+// the target. The target host:port is appended as the final argument.
+var UDPRelayCommand = "tunnelctl udp-relay"
+
+const (
+	// maxDatagramSize is the largest UDP payload that can be framed
+	maxDatagramSize = 65535
+
+	// defaultUDPFlowIdleTimeout closes per-client relay channels after
+	// inactivity when the spec sets no idle timeout
+	defaultUDPFlowIdleTimeout = 60 * time.Second
+)
+
+// WriteDatagram writes a single length-prefixed datagram frame.
+// Frames are a 2-byte big-endian payload length followed by the payload.
+func WriteDatagram(w io.Writer, payload []byte) error {
+	if len(payload) > maxDatagramSize {
+		return fmt.Errorf("datagram too large: %d bytes", len(payload))
+	}
+
+	frame := make([]byte, 2+len(payload))
+	binary.BigEndian.PutUint16(frame, uint16(len(payload)))
+	copy(frame[2:], payload)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadDatagram reads a single length-prefixed datagram frame into buf
+// and returns the payload length. buf must hold maxDatagramSize bytes.
+func ReadDatagram(r io.Reader, buf []byte) (int, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+
+	n := int(binary.BigEndian.Uint16(header[:]))
+	if n > len(buf) {
+		return 0, fmt.Errorf("datagram too large for buffer: %d bytes", n)
+	}
+
+	if _, err := io.ReadFull(r, buf[:n]); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ServeUDPRelay is the remote end of a UDP tunnel: it reads framed datagrams
+// from r, sends them to target and frames every reply back onto w.
+// It returns when r is closed.
+func ServeUDPRelay(r io.Reader, w io.Writer, target string) error {
+	conn, err := net.Dial("udp", target)
+	if err != nil {
+		return fmt.Errorf("failed to dial %s: %w", target, err)
+	}
+	defer conn.Close()
+
+	// Replies: target -> framed stream
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if err := WriteDatagram(w, buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Requests: framed stream -> target
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := ReadDatagram(r, buf)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if _, err := conn.Write(buf[:n]); err != nil {
+			return fmt.Errorf("failed to send datagram: %w", err)
+		}
+	}
+}
+
+// udpFlow is the relay channel serving a single local client address
+type udpFlow struct {
+	session  *ssh.Session
+	stdin    io.WriteCloser
+	lastSeen atomic.Int64 // unix nanoseconds
+}
+
+// UDPForwarder implements local UDP forwarding.
+// Each local client address gets its own SSH exec channel running
+// UDPRelayCommand on the last hop; datagrams are framed over that channel.
+type UDPForwarder struct {
+	spec    *types.TunnelSpec
+	session SessionDialer
+	sockets socketFactories
+	conn    net.PacketConn
+
+	flows   map[string]*udpFlow
+	flowsMu sync.Mutex
+
+	// Stats
+	stats    forwarderCounters
+	activity activityClock
+
+	// Connection tracking
+	activeConns sync.WaitGroup
+	mu          sync.RWMutex
+
+	// Lifecycle
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewUDPForwarder creates a new local UDP forwarder
+func NewUDPForwarder(ctx context.Context, spec *types.TunnelSpec, session SessionDialer, opts ...ForwarderOption) (*UDPForwarder, error) {
+	if spec.Type != types.TunnelTypeLocal {
+		return nil, fmt.Errorf("invalid tunnel type: UDP forwarding requires local, got %s", spec.Type)
+	}
+
+	if spec.RemoteHost == "" || spec.RemotePort == 0 {
+		return nil, fmt.Errorf("remote host and port are required for UDP forwarding")
+	}
+
+	if spec.LocalPort < 0 {
+		return nil, fmt.Errorf("invalid local port: %d", spec.LocalPort)
+	}
+
+	fwdCtx, cancel := context.WithCancel(ctx)
+
+	uf := &UDPForwarder{
+		spec:    spec,
+		session: session,
+		sockets: newSocketFactories(opts),
+		flows:   make(map[string]*udpFlow),
+		ctx:     fwdCtx,
+		cancel:  cancel,
+		stopCh:  make(chan struct{}),
+	}
+
+	uf.stats.startedAt = time.Now()
+	uf.activity.Touch()
+
+	return uf, nil
+}
+
+// Start binds the local UDP port and begins relaying datagrams
+func (uf *UDPForwarder) Start() error {
+	uf.mu.Lock()
+	if uf.conn != nil {
+		uf.mu.Unlock()
+		return fmt.Errorf("forwarder already started")
+	}
+
+	conn, addr, err := uf.sockets.listenPacket(uf.ctx, uf.spec)
+	if err != nil {
+		uf.mu.Unlock()
+		return fmt.Errorf("failed to bind to %s: %w", addr, err)
+	}
+
+	uf.conn = conn
+	uf.stats.add(1, &uf.stats.openSockets)
+
+	// Update spec with actual bound port if ephemeral was used
+	if uf.spec.LocalPort == 0 {
+		if udpAddr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			uf.spec.LocalPort = udpAddr.Port
+		}
+	}
+
+	uf.mu.Unlock()
+
+	go uf.readLoop()
+	go uf.reapLoop()
+
+	return nil
+}
+
+// readLoop reads datagrams from local clients and sends them to their flow
+func (uf *UDPForwarder) readLoop() {
+	uf.stats.add(1, &uf.stats.goroutines)
+	defer uf.stats.add(-1, &uf.stats.goroutines)
+
+	buf := make([]byte, maxDatagramSize)
+	uf.stats.add(maxDatagramSize, &uf.stats.bufferBytes)
+	defer uf.stats.add(-maxDatagramSize, &uf.stats.bufferBytes)
+
+	for {
+		uf.mu.RLock()
+		conn := uf.conn
+		uf.mu.RUnlock()
+
+		if conn == nil {
+			return
+		}
+
+		n, clientAddr, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-uf.stopCh:
+				return
+			case <-uf.ctx.Done():
+				return
+			default:
+				uf.stats.add(1, &uf.stats.errors)
+				continue
+			}
+		}
+
+		flow, err := uf.getFlow(clientAddr)
+		if err != nil {
+			uf.stats.add(1, &uf.stats.errors)
+			continue
+		}
+
+		if err := WriteDatagram(flow.stdin, buf[:n]); err != nil {
+			uf.stats.add(1, &uf.stats.errors)
+			uf.closeFlow(clientAddr.String())
+			continue
+		}
+
+		flow.lastSeen.Store(time.Now().UnixNano())
+		uf.stats.add(int64(n), &uf.stats.bytesSent)
+		uf.activity.Touch()
+	}
+}
+
+// getFlow returns the relay flow for a client, opening one if needed
+func (uf *UDPForwarder) getFlow(clientAddr net.Addr) (*udpFlow, error) {
+	key := clientAddr.String()
+
+	uf.flowsMu.Lock()
+	defer uf.flowsMu.Unlock()
+
+	if flow, ok := uf.flows[key]; ok {
+		return flow, nil
+	}
+
+	if !uf.session.IsConnected() {
+		return nil, fmt.Errorf("session not connected")
+	}
+
+	client := uf.getSSHClient()
+	if client == nil {
+		return nil, fmt.Errorf("SSH client not available for UDP relay")
+	}
+
+	sshSession, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open relay channel: %w", err)
+	}
+
+	stdin, err := sshSession.StdinPipe()
+	if err != nil {
+		sshSession.Close()
+		return nil, fmt.Errorf("failed to open relay stdin: %w", err)
+	}
+
+	stdout, err := sshSession.StdoutPipe()
+	if err != nil {
+		sshSession.Close()
+		return nil, fmt.Errorf("failed to open relay stdout: %w", err)
+	}
+
+	target, err := uf.sockets.target(uf.ctx, net.JoinHostPort(uf.spec.RemoteHost, fmt.Sprintf("%d", uf.spec.RemotePort)))
+	if err != nil {
+		sshSession.Close()
+		return nil, err
+	}
+	if err := sshSession.Start(udpRelayCommand(target)); err != nil {
+		sshSession.Close()
+		return nil, fmt.Errorf("failed to start relay: %w", err)
+	}
+
+	flow := &udpFlow{session: sshSession, stdin: stdin}
+	flow.lastSeen.Store(time.Now().UnixNano())
+	uf.flows[key] = flow
+
+	uf.stats.add(1, &uf.stats.connections, &uf.stats.activeConns)
+	uf.stats.add(1, &uf.stats.openSockets)
+
+	uf.activeConns.Add(1)
+	go uf.replyLoop(key, clientAddr, flow, stdout)
+
+	return flow, nil
+}
+
+// udpRelayCommand is the command that relays a flow's datagrams to target
+func udpRelayCommand(target string) string {
+	return UDPRelayCommand + " " + shellQuote(target)
+}
+
+// replyLoop forwards framed replies from the relay back to the local client
+func (uf *UDPForwarder) replyLoop(key string, clientAddr net.Addr, flow *udpFlow, stdout io.Reader) {
+	defer uf.activeConns.Done()
+	defer uf.closeFlow(key)
+
+	uf.stats.add(1, &uf.stats.goroutines)
+	defer uf.stats.add(-1, &uf.stats.goroutines)
+
+	buf := make([]byte, maxDatagramSize)
+	uf.stats.add(maxDatagramSize, &uf.stats.bufferBytes)
+	defer uf.stats.add(-maxDatagramSize, &uf.stats.bufferBytes)
+
+	for {
+		n, err := ReadDatagram(stdout, buf)
+		if err != nil {
+			return
+		}
+
+		uf.mu.RLock()
+		conn := uf.conn
+		uf.mu.RUnlock()
+
+		if conn == nil {
+			return
+		}
+
+		if _, err := conn.WriteTo(buf[:n], clientAddr); err != nil {
+			uf.stats.add(1, &uf.stats.errors)
+			return
+		}
+
+		flow.lastSeen.Store(time.Now().UnixNano())
+		uf.stats.add(int64(n), &uf.stats.bytesReceived)
+		uf.activity.Touch()
+	}
+}
+
+// reapLoop closes flows that have been idle longer than the idle timeout
+func (uf *UDPForwarder) reapLoop() {
+	// UDP has no connection teardown, so flows always expire
+	idleTimeout := uf.spec.TCP.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultUDPFlowIdleTimeout
+	}
+
+	uf.stats.add(1, &uf.stats.goroutines)
+	defer uf.stats.add(-1, &uf.stats.goroutines)
+
+	ticker := time.NewTicker(idleCheckInterval(idleTimeout))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-uf.stopCh:
+			return
+		case <-uf.ctx.Done():
+			return
+		case <-ticker.C:
+			cutoff := time.Now().Add(-idleTimeout).UnixNano()
+
+			uf.flowsMu.Lock()
+			var idle []string
+			for key, flow := range uf.flows {
+				if flow.lastSeen.Load() < cutoff {
+					idle = append(idle, key)
+				}
+			}
+			uf.flowsMu.Unlock()
+
+			for _, key := range idle {
+				uf.closeFlow(key)
+				uf.stats.add(1, &uf.stats.timeouts)
+			}
+		}
+	}
+}
+
+// closeFlow tears down a client's relay channel
+func (uf *UDPForwarder) closeFlow(key string) {
+	uf.flowsMu.Lock()
+	flow, ok := uf.flows[key]
+	if ok {
+		delete(uf.flows, key)
+	}
+	uf.flowsMu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	flow.stdin.Close()
+	flow.session.Close()
+	uf.stats.add(-1, &uf.stats.activeConns)
+	uf.stats.add(-1, &uf.stats.openSockets)
+}
+
+// getSSHClient extracts the SSH client of the last hop from the session
+func (uf *UDPForwarder) getSSHClient() *ssh.Client {
+	if s, ok := uf.session.(*Session); ok {
+		return s.Client()
+	}
+	if mhs, ok := uf.session.(*MultiHopSession); ok {
+		if client, ok := mhs.getLastHopClient().(*ssh.Client); ok {
+			return client
+		}
+	}
+	return nil
+}
+
+// Stop closes the local socket and all relay channels
+func (uf *UDPForwarder) Stop() error {
+	_, err := uf.stop()
+	return err
+}
+
+// ForceStop stops the forwarder like Stop, which never waits for flows to
+// go quiet, and returns how many relay channels it closed
+func (uf *UDPForwarder) ForceStop() (int, error) {
+	return uf.stop()
+}
+
+// stop closes the socket and every flow's relay channel
+func (uf *UDPForwarder) stop() (int, error) {
+	var closed int
+	var err error
+	uf.stopOnce.Do(func() {
+		close(uf.stopCh)
+		uf.cancel()
+
+		uf.mu.Lock()
+		if uf.conn != nil {
+			err = uf.conn.Close()
+			uf.conn = nil
+			uf.stats.add(-1, &uf.stats.openSockets)
+		}
+		uf.mu.Unlock()
+
+		uf.flowsMu.Lock()
+		keys := make([]string, 0, len(uf.flows))
+		for key := range uf.flows {
+			keys = append(keys, key)
+		}
+		uf.flowsMu.Unlock()
+
+		for _, key := range keys {
+			uf.closeFlow(key)
+		}
+		closed = len(keys)
+
+		if timeout := drainTimeout(uf.spec); !waitTimeout(&uf.activeConns, timeout) {
+			err = fmt.Errorf("timeout waiting for relay channels to close after %s", timeout)
+		}
+	})
+
+	return closed, err
+}
+
+// Stats returns the current forwarder statistics
+func (uf *UDPForwarder) Stats() ForwarderStats {
+	return uf.stats.snapshot(uf.activity.Time())
+}
+
+// LocalAddr returns the local UDP address
+func (uf *UDPForwarder) LocalAddr() string {
+	uf.mu.RLock()
+	defer uf.mu.RUnlock()
+
+	if uf.conn != nil {
+		return uf.conn.LocalAddr().String()
+	}
+	return ""
+}
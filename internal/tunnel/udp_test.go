@@ -0,0 +1,166 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestDatagramFraming(t *testing.T) {
+	var buf bytes.Buffer
+	payloads := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte{0xAB}, 1500)}
+
+	for _, p := range payloads {
+		if err := WriteDatagram(&buf, p); err != nil {
+			t.Fatalf("WriteDatagram() error = %v", err)
+		}
+	}
+
+	out := make([]byte, maxDatagramSize)
+	for _, want := range payloads {
+		n, err := ReadDatagram(&buf, out)
+		if err != nil {
+			t.Fatalf("ReadDatagram() error = %v", err)
+		}
+		if !bytes.Equal(out[:n], want) {
+			t.Errorf("ReadDatagram() = %d bytes, want %d", n, len(want))
+		}
+	}
+
+	if _, err := ReadDatagram(&buf, out); err != io.EOF {
+		t.Errorf("ReadDatagram() on empty stream error = %v, want EOF", err)
+	}
+
+	if err := WriteDatagram(&buf, make([]byte, maxDatagramSize+1)); err == nil {
+		t.Error("WriteDatagram() expected error for oversized datagram")
+	}
+}
+
+func TestServeUDPRelay(t *testing.T) {
+	// UDP echo server standing in for the remote target
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start echo server: %v", err)
+	}
+	defer echo.Close()
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeUDPRelay(reqR, respW, echo.LocalAddr().String())
+	}()
+
+	if err := WriteDatagram(reqW, []byte("ping")); err != nil {
+		t.Fatalf("WriteDatagram() error = %v", err)
+	}
+
+	buf := make([]byte, maxDatagramSize)
+	n, err := ReadDatagram(respR, buf)
+	if err != nil {
+		t.Fatalf("ReadDatagram() error = %v", err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Errorf("Expected echoed 'ping', got %q", buf[:n])
+	}
+
+	reqW.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ServeUDPRelay() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("ServeUDPRelay() did not return after input closed")
+	}
+}
+
+func TestUDPRelayCommandQuotesTarget(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to run the command")
+	}
+
+	// The shell must hand the relay the target as one argument, verbatim
+	for _, target := range []string{"10.0.0.2:53", "it's:53", "$(touch pwned):53", "a' 'b:53"} {
+		cmd := strings.Replace(udpRelayCommand(target), UDPRelayCommand, `printf "%s|"`, 1)
+		out, err := exec.Command("sh", "-c", cmd).Output()
+		if err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		if string(out) != target+"|" {
+			t.Errorf("%q reached the relay as %q", target, out)
+		}
+	}
+}
+
+func TestNewUDPForwarder(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *types.TunnelSpec
+		wantErr bool
+	}{
+		{
+			name: "valid local UDP spec",
+			spec: &types.TunnelSpec{
+				ID:         "test-1",
+				Type:       types.TunnelTypeLocal,
+				Protocol:   types.ProtocolUDP,
+				LocalPort:  5353,
+				RemoteHost: "10.0.0.2",
+				RemotePort: 53,
+			},
+			wantErr: false,
+		},
+		{
+			name: "remote tunnel type",
+			spec: &types.TunnelSpec{
+				ID:         "test-2",
+				Type:       types.TunnelTypeRemote,
+				Protocol:   types.ProtocolUDP,
+				RemoteHost: "10.0.0.2",
+				RemotePort: 53,
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing remote port",
+			spec: &types.TunnelSpec{
+				ID:         "test-3",
+				Type:       types.TunnelTypeLocal,
+				Protocol:   types.ProtocolUDP,
+				RemoteHost: "10.0.0.2",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSession := &MockSessionDialer{connected: true}
+
+			_, err := NewUDPForwarder(context.Background(), tt.spec, mockSession)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewUDPForwarder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"context"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// Usage sums up what a tunnel did over a period, as far as its sampled
+// counters tell: traffic and connections between the first and last sample
+// in the period, and how long it was up
+type Usage struct {
+	// Sessions counts the times the tunnel was up: when the period starts
+	// with it active, each time it becomes active, and each restart of its
+	// forwarder seen as its counters going back to zero
+	Sessions      int64
+	Connections   int64
+	BytesSent     int64
+	BytesReceived int64
+	Errors        int64
+	// Active is how long the tunnel was sampled as active. Gaps between
+	// samples longer than the recorder's, e.g. while the server was down,
+	// don't count.
+	Active time.Duration
+}
+
+// Add adds another period's or tunnel's usage
+func (u *Usage) Add(other Usage) {
+	u.Sessions += other.Sessions
+	u.Connections += other.Connections
+	u.BytesSent += other.BytesSent
+	u.BytesReceived += other.BytesReceived
+	u.Errors += other.Errors
+	u.Active += other.Active
+}
+
+// Usage sums up a tunnel's samples taken in [since, until)
+func (h *HistoryRecorder) Usage(ctx context.Context, tunnelID string, since, until time.Time) (Usage, error) {
+	samples, err := h.Range(ctx, tunnelID, since, until)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	// Samples come from memory every interval and from storage every
+	// persistence interval; anything sparser is a gap in recording
+	maxGap := 2 * h.interval
+	if h.persist.Store != nil && 2*h.persist.Interval > maxGap {
+		maxGap = 2 * h.persist.Interval
+	}
+	return summarizeUsage(samples, maxGap), nil
+}
+
+// summarizeUsage sums up samples, oldest first, not counting active time
+// across gaps longer than maxGap
+func summarizeUsage(samples []MetricsSample, maxGap time.Duration) Usage {
+	var usage Usage
+	for i, cur := range samples {
+		if i == 0 {
+			if cur.State == types.TunnelStateActive {
+				usage.Sessions++
+			}
+			continue
+		}
+		prev := samples[i-1]
+
+		// Counters are cumulative since the forwarder started, so going
+		// down means it restarted and counted the new value from zero
+		restarted := cur.Connections < prev.Connections || cur.BytesSent < prev.BytesSent ||
+			cur.BytesReceived < prev.BytesReceived
+		usage.Connections += counterDelta(prev.Connections, cur.Connections, restarted)
+		usage.BytesSent += counterDelta(prev.BytesSent, cur.BytesSent, restarted)
+		usage.BytesReceived += counterDelta(prev.BytesReceived, cur.BytesReceived, restarted)
+		usage.Errors += counterDelta(prev.Errors, cur.Errors, restarted)
+
+		if cur.State == types.TunnelStateActive && (prev.State != types.TunnelStateActive || restarted) {
+			usage.Sessions++
+		}
+		if elapsed := cur.Timestamp.Sub(prev.Timestamp); prev.State == types.TunnelStateActive && elapsed <= maxGap {
+			usage.Active += elapsed
+		}
+	}
+	return usage
+}
+
+// counterDelta returns how much a cumulative counter grew between two
+// samples
+func counterDelta(prev, cur int64, restarted bool) int64 {
+	if restarted {
+		return cur
+	}
+	return cur - prev
+}
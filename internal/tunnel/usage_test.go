@@ -0,0 +1,58 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestHistoryRecorderUsage(t *testing.T) {
+	h := NewHistoryRecorder(NewManager(context.Background()), time.Second, time.Hour)
+	active, failed := types.TunnelStateActive, types.TunnelStateFailed
+
+	base := time.Now().Add(-30 * time.Minute).Truncate(time.Second)
+	for i, sample := range []MetricsSample{
+		{State: active, Connections: 5, BytesSent: 500, BytesReceived: 50},    // before the period
+		{State: active, Connections: 10, BytesSent: 1000, BytesReceived: 100}, // the period starts up
+		{State: active, Connections: 12, BytesSent: 1500, BytesReceived: 150},
+		{State: failed, Connections: 12, BytesSent: 1500, BytesReceived: 150, Errors: 1},
+		{State: active, Connections: 2, BytesSent: 300, BytesReceived: 30}, // restarted
+		{State: active, Connections: 3, BytesSent: 400, BytesReceived: 40},
+	} {
+		sample.Timestamp = base.Add(time.Duration(i) * time.Second)
+		h.record("t1", sample)
+	}
+	// A gap in recording, e.g. the server was down, adds traffic but no time
+	h.record("t1", MetricsSample{Timestamp: base.Add(time.Minute), State: active, Connections: 4, BytesSent: 450, BytesReceived: 45})
+
+	usage, err := h.Usage(context.Background(), "t1", base.Add(time.Second), base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Usage failed: %v", err)
+	}
+	want := Usage{
+		Sessions:      2,
+		Connections:   2 + 2 + 1 + 1,
+		BytesSent:     500 + 300 + 100 + 50,
+		BytesReceived: 50 + 30 + 10 + 5,
+		Errors:        1,
+		Active:        3 * time.Second,
+	}
+	if usage != want {
+		t.Errorf("Usage = %+v, want %+v", usage, want)
+	}
+
+	// The period's end is exclusive
+	usage, err = h.Usage(context.Background(), "t1", base, base.Add(2*time.Second))
+	if err != nil {
+		t.Fatalf("Usage failed: %v", err)
+	}
+	if usage.Sessions != 1 || usage.Connections != 5 || usage.Active != time.Second {
+		t.Errorf("Usage of the first two samples = %+v", usage)
+	}
+
+	if usage, _ := h.Usage(context.Background(), "unknown", base, base.Add(time.Hour)); usage != (Usage{}) {
+		t.Errorf("Usage of an unknown tunnel = %+v, want none", usage)
+	}
+}
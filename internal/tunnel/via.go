@@ -0,0 +1,109 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// DialFunc opens a connection, like net.Dial
+type DialFunc func(network, address string) (net.Conn, error)
+
+// viaDialer returns a DialFunc that reaches addresses through the listener
+// of another tunnel. The tunnel is looked up on each dial, so reconnects
+// follow it if it restarts on another port.
+func (m *Manager) viaDialer(tunnelID string, timeout time.Duration) DialFunc {
+	return func(network, address string) (net.Conn, error) {
+		via, err := m.Get(tunnelID)
+		if err != nil {
+			return nil, fmt.Errorf("via tunnel: %w", err)
+		}
+		return via.dialThrough(m.AddressBook(), network, address, timeout)
+	}
+}
+
+// CanCarry reports whether connections to address can go through the
+// tunnel: a dynamic tunnel reaches any address, a local tunnel only the one
+// it forwards to
+func CanCarry(spec *types.TunnelSpec, address string) error {
+	switch spec.Type {
+	case types.TunnelTypeDynamic:
+		return nil
+	case types.TunnelTypeLocal:
+		if spec.Protocol == types.ProtocolUDP {
+			return fmt.Errorf("UDP tunnel %s can't carry TCP connections", spec.Name)
+		}
+		for _, mapping := range spec.Ports {
+			if net.JoinHostPort(mapping.RemoteHost, strconv.Itoa(mapping.RemotePort)) == address {
+				return nil
+			}
+		}
+		if len(spec.Ports) == 0 && len(spec.Targets) == 0 &&
+			net.JoinHostPort(spec.RemoteHost, strconv.Itoa(spec.RemotePort)) == address {
+			return nil
+		}
+		return fmt.Errorf("tunnel %s doesn't forward to %s", spec.Name, address)
+	default:
+		return fmt.Errorf("tunnel %s is a %s tunnel; only local and dynamic tunnels can carry connections", spec.Name, spec.Type)
+	}
+}
+
+// dialThrough connects to address through the tunnel's listener. The
+// tunnel's destinations may reference entries of book.
+func (t *Tunnel) dialThrough(book AddressBook, network, address string, timeout time.Duration) (net.Conn, error) {
+	spec, err := ResolveSpec(context.Background(), book, t.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("via tunnel %s: %w", t.Spec.Name, err)
+	}
+	if err := CanCarry(spec, address); err != nil {
+		return nil, err
+	}
+	status := t.GetStatus()
+	if status == nil || status.State != types.TunnelStateActive {
+		return nil, fmt.Errorf("via tunnel %s is not active", t.Spec.Name)
+	}
+
+	listener := status.BoundAddress
+	for _, port := range status.Ports {
+		if target, _ := ResolveTarget(context.Background(), book, net.JoinHostPort(port.RemoteHost, strconv.Itoa(port.RemotePort))); target == address {
+			listener = port.BoundAddress
+		}
+	}
+	if listener == "" {
+		return nil, fmt.Errorf("via tunnel %s isn't listening", t.Spec.Name)
+	}
+	listener = dialableAddr(listener)
+
+	dialer := &net.Dialer{Timeout: timeout}
+	if t.Spec.Type != types.TunnelTypeDynamic {
+		return dialer.Dial(network, listener)
+	}
+	socks, err := proxy.SOCKS5("tcp", listener, nil, dialer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use via tunnel %s: %w", t.Spec.Name, err)
+	}
+	return socks.Dial(network, address)
+}
+
+// dialableAddr turns the address of a listener bound to all interfaces into
+// one to connect to
+func dialableAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		if ip.To4() != nil {
+			host = "127.0.0.1"
+		} else {
+			host = "::1"
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
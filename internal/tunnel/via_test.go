@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+func TestCanCarry(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    types.TunnelSpec
+		address string
+		wantErr bool
+	}{
+		{"dynamic", types.TunnelSpec{Type: types.TunnelTypeDynamic}, "bastion.internal:22", false},
+		{"local target", types.TunnelSpec{Type: types.TunnelTypeLocal, RemoteHost: "bastion.internal", RemotePort: 22}, "bastion.internal:22", false},
+		{"local other target", types.TunnelSpec{Type: types.TunnelTypeLocal, RemoteHost: "db.internal", RemotePort: 5432}, "bastion.internal:22", true},
+		{"port mapping", types.TunnelSpec{Type: types.TunnelTypeLocal, Ports: []types.PortMapping{
+			{LocalPort: 5432, RemoteHost: "db.internal", RemotePort: 5432},
+			{LocalPort: 2222, RemoteHost: "bastion.internal", RemotePort: 22},
+		}}, "bastion.internal:22", false},
+		{"balanced", types.TunnelSpec{Type: types.TunnelTypeLocal, RemoteHost: "bastion.internal", RemotePort: 22,
+			Targets: []string{"bastion.internal:22"}}, "bastion.internal:22", true},
+		{"udp", types.TunnelSpec{Type: types.TunnelTypeLocal, Protocol: types.ProtocolUDP, RemoteHost: "bastion.internal", RemotePort: 22}, "bastion.internal:22", true},
+		{"remote", types.TunnelSpec{Type: types.TunnelTypeRemote, RemoteHost: "bastion.internal", RemotePort: 22}, "bastion.internal:22", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CanCarry(&tt.spec, tt.address); (err != nil) != tt.wantErr {
+				t.Errorf("CanCarry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDialableAddr(t *testing.T) {
+	tests := map[string]string{
+		"0.0.0.0:2222":   "127.0.0.1:2222",
+		"[::]:2222":      "[::1]:2222",
+		"127.0.0.1:2222": "127.0.0.1:2222",
+		"10.0.0.5:2222":  "10.0.0.5:2222",
+	}
+	for addr, want := range tests {
+		if got := dialableAddr(addr); got != want {
+			t.Errorf("dialableAddr(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestSessionDialsHopThroughLocalTunnel(t *testing.T) {
+	// The via tunnel's listener leads straight to the bastion, whose name
+	// only resolves on the far side of the tunnel
+	addr := startTestSSHServer(t, nil, nil)
+
+	m := NewManager(context.Background())
+	via := addStoredTunnels(m, &types.TunnelSpec{
+		ID:         "via-1",
+		Name:       "to-bastion",
+		Type:       types.TunnelTypeLocal,
+		RemoteHost: "bastion.internal",
+		RemotePort: 22,
+	})["to-bastion"]
+
+	hop := &types.Hop{Host: "bastion.internal", Port: 22, User: "testuser", AuthMethod: types.AuthMethodPassword, Via: "via-1"}
+	session, err := NewSession(context.Background(), SessionConfig{Hop: hop, Dial: m.viaDialer("via-1", time.Second)})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	session.config = testClientConfig(hop.User)
+
+	if err := session.Connect(); err == nil {
+		t.Fatal("Expected connecting through a stopped tunnel to fail")
+	}
+
+	via.mu.Lock()
+	via.Status.State = types.TunnelStateActive
+	via.forwarder = listeningForwarder(addr)
+	via.mu.Unlock()
+
+	if err := session.Connect(); err != nil {
+		t.Fatalf("Connect() through the via tunnel failed: %v", err)
+	}
+	if status := session.HopStatus(); !status.Connected || status.ServerVersion != "SSH-2.0-TestBastion_1.0" {
+		t.Errorf("HopStatus() = %+v, want connected to the test bastion", status)
+	}
+}
+
+// listeningForwarder reports a listener address without forwarding anything
+type listeningForwarder string
+
+func (f listeningForwarder) Start() error          { return nil }
+func (f listeningForwarder) Stop() error           { return nil }
+func (f listeningForwarder) Stats() ForwarderStats { return ForwarderStats{} }
+func (f listeningForwarder) LocalAddr() string     { return string(f) }
@@ -0,0 +1,174 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// WebSocketConn carries a byte stream over a WebSocket, one binary message
+// per write, so it can stand in for a TCP connection
+type WebSocketConn struct {
+	ws *websocket.Conn
+
+	readMu sync.Mutex
+	reader io.Reader // current message
+
+	writeMu sync.Mutex
+}
+
+// NewWebSocketConn wraps ws as a net.Conn
+func NewWebSocketConn(ws *websocket.Conn) *WebSocketConn {
+	return &WebSocketConn{ws: ws}
+}
+
+// Read reads from the stream, across message boundaries
+func (c *WebSocketConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for {
+		if c.reader == nil {
+			msgType, reader, err := c.ws.NextReader()
+			if err != nil {
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseNormalClosure {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			if msgType != websocket.BinaryMessage {
+				continue
+			}
+			c.reader = reader
+		}
+
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Write sends p as one binary message
+func (c *WebSocketConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close tells the other side the stream ended and closes the connection
+func (c *WebSocketConn) Close() error {
+	c.writeMu.Lock()
+	c.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	c.writeMu.Unlock()
+	return c.ws.Close()
+}
+
+func (c *WebSocketConn) LocalAddr() net.Addr  { return c.ws.LocalAddr() }
+func (c *WebSocketConn) RemoteAddr() net.Addr { return c.ws.RemoteAddr() }
+
+func (c *WebSocketConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *WebSocketConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *WebSocketConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
+
+// relayDialer returns a DialFunc reaching addresses through the WebSocket
+// relay of a lazytunnel server, authenticating with token if there is one.
+// HTTPS_PROXY and friends are honored, as corporate networks often require.
+func relayDialer(relayURL, token string, timeout time.Duration) DialFunc {
+	return func(network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		u, err := url.Parse(relayURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relay URL: %w", err)
+		}
+		q := u.Query()
+		q.Set("host", host)
+		q.Set("port", port)
+		u.RawQuery = q.Encode()
+
+		header := http.Header{}
+		if token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		}
+		dialer := websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: timeout,
+		}
+		ws, resp, err := dialer.Dial(u.String(), header)
+		if err != nil {
+			if resp != nil {
+				return nil, fmt.Errorf("relay %s refused %s: %s", u.Host, address, resp.Status)
+			}
+			return nil, fmt.Errorf("relay %s: %w", u.Host, err)
+		}
+		return NewWebSocketConn(ws), nil
+	}
+}
+
+// transportDialer returns the DialFunc reaching the hop over its transport,
+// or nil to dial it directly
+func transportDialer(hop *types.Hop, token string, resolver *Resolver, timeout time.Duration) DialFunc {
+	switch hop.Transport {
+	case types.HopTransportWSS:
+		return relayDialer(hop.RelayURL, token, timeout)
+	case types.HopTransportAuto:
+		relay := relayDialer(hop.RelayURL, token, timeout)
+		return func(network, address string) (net.Conn, error) {
+			conn, err := resolver.DialTimeout(context.Background(), network, address, timeout)
+			if err == nil {
+				return conn, nil
+			}
+			conn, relayErr := relay(network, address)
+			if relayErr != nil {
+				return nil, fmt.Errorf("direct: %w; %w", err, relayErr)
+			}
+			return conn, nil
+		}
+	default:
+		return nil
+	}
+}
+
+// SetRelayToken sets the API token hops using the wss or auto transport
+// present to the relay
+func (m *Manager) SetRelayToken(token string) {
+	m.relayToken.Store(&token)
+}
+
+// relayTokenValue returns the token set with SetRelayToken
+func (m *Manager) relayTokenValue() string {
+	if token := m.relayToken.Load(); token != nil {
+		return *token
+	}
+	return ""
+}
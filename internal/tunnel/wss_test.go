@@ -0,0 +1,137 @@
+package tunnel
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// startRelay starts a relay accepting token and connecting to whatever the
+// client asks for, with hosts named in aliases resolved only by the relay
+func startRelay(t *testing.T, token string, aliases map[string]string) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		address := net.JoinHostPort(r.URL.Query().Get("host"), r.URL.Query().Get("port"))
+		if alias, ok := aliases[r.URL.Query().Get("host")]; ok {
+			address = alias
+		}
+		target, err := net.Dial("tcp", address)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := NewWebSocketConn(ws)
+		defer conn.Close()
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	}))
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/v1/relay"
+}
+
+// startEcho starts a TCP server echoing back what it receives
+func startEcho(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// assertEcho writes through conn and expects the same bytes back
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	// Larger than one read, so reads span messages
+	want := strings.Repeat("lazytunnel", 10000)
+	go conn.Write([]byte(want))
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != want {
+		t.Fatalf("Echo through the relay failed: %v", err)
+	}
+}
+
+func TestRelayDialer(t *testing.T) {
+	relay := startRelay(t, "secret", nil)
+	echo := startEcho(t)
+
+	conn, err := relayDialer(relay, "secret", time.Second)("tcp", echo)
+	if err != nil {
+		t.Fatalf("Dialing through the relay failed: %v", err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+
+	// The relay's refusal is reported
+	_, err = relayDialer(relay, "wrong", time.Second)("tcp", echo)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected the relay's 401 to be reported, got %v", err)
+	}
+}
+
+func TestTransportDialer(t *testing.T) {
+	echo := startEcho(t)
+	relay := startRelay(t, "secret", map[string]string{"bastion.invalid": echo})
+
+	if dial := transportDialer(&types.Hop{Transport: types.HopTransportTCP}, "", nil, time.Second); dial != nil {
+		t.Error("Expected tcp hops to be dialed directly")
+	}
+
+	// auto connects directly when it can...
+	auto := transportDialer(&types.Hop{Transport: types.HopTransportAuto, RelayURL: "ws://127.0.0.1:1/relay"}, "secret", nil, time.Second)
+	conn, err := auto("tcp", echo)
+	if err != nil {
+		t.Fatalf("Direct connection failed: %v", err)
+	}
+	if _, ok := conn.(*WebSocketConn); ok {
+		t.Error("Expected a direct connection when the hop is reachable")
+	}
+	conn.Close()
+
+	// ...and through the relay when it can't
+	auto = transportDialer(&types.Hop{Transport: types.HopTransportAuto, RelayURL: relay}, "secret", nil, time.Second)
+	conn, err = auto("tcp", "bastion.invalid:22")
+	if err != nil {
+		t.Fatalf("Connection through the relay failed: %v", err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+
+	// Both failures are reported when neither works
+	auto = transportDialer(&types.Hop{Transport: types.HopTransportAuto, RelayURL: relay}, "wrong", nil, time.Second)
+	if _, err := auto("tcp", "bastion.invalid:22"); err == nil || !strings.Contains(err.Error(), "direct") || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected both the direct and relay errors, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package agentclient
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -16,6 +17,28 @@ type Client struct {
 	BaseURL    string
 	HTTPClient *http.Client
 	Token      string
+
+	// Credentials of the last Login, used to log in again when the token
+	// expires
+	username, password string
+}
+
+// StatusError is returned when the API responds with an error status
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("api %s %s: %s", e.Method, e.Path, e.Body)
+}
+
+// IsStatus reports whether err is an API response with the given status
+func IsStatus(err error, code int) bool {
+	var serr *StatusError
+	return errors.As(err, &serr) && serr.StatusCode == code
 }
 
 func New(baseURL, token string) *Client {
@@ -52,9 +75,9 @@ func (c *Client) Report(agentID string, reports []types.AgentStatusReport) error
 	return c.post("/agents/"+agentID+"/report", reports, nil)
 }
 
+// Login obtains a token, which is renewed with the same credentials
+// whenever the API rejects it
 func (c *Client) Login(username, password string) (string, error) {
-	body := types.AgentRegisterRequest{}
-	_ = body
 	var resp struct {
 		Token string `json:"token"`
 	}
@@ -63,6 +86,7 @@ func (c *Client) Login(username, password string) (string, error) {
 		return "", err
 	}
 	c.Token = resp.Token
+	c.username, c.password = username, password
 	return resp.Token, nil
 }
 
@@ -79,13 +103,29 @@ func (c *Client) get(path string, out interface{}) error {
 }
 
 func (c *Client) do(method, path string, body, out interface{}, auth bool) error {
-	var reader io.Reader
+	var payload []byte
 	if body != nil {
 		b, err := json.Marshal(body)
 		if err != nil {
 			return err
 		}
-		reader = bytes.NewReader(b)
+		payload = b
+	}
+
+	err := c.send(method, path, payload, out, auth)
+	if auth && c.username != "" && IsStatus(err, http.StatusUnauthorized) {
+		if _, err := c.Login(c.username, c.password); err != nil {
+			return fmt.Errorf("token rejected and login failed: %w", err)
+		}
+		err = c.send(method, path, payload, out, auth)
+	}
+	return err
+}
+
+func (c *Client) send(method, path string, payload []byte, out interface{}, auth bool) error {
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
 	}
 
 	req, err := http.NewRequest(method, c.BaseURL+path, reader)
@@ -105,11 +145,11 @@ func (c *Client) do(method, path string, body, out interface{}, auth bool) error
 
 	if res.StatusCode >= 400 {
 		msg, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("api %s %s: %s", method, path, string(msg))
+		return &StatusError{Method: method, Path: path, StatusCode: res.StatusCode, Body: string(msg)}
 	}
 
 	if out != nil && res.StatusCode != http.StatusNoContent {
 		return json.NewDecoder(res.Body).Decode(out)
 	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,193 @@
+// Package tunnelkit is the supported API for embedding lazytunnel's SSH
+// tunnels in other Go programs.
+//
+// A Manager runs tunnels described by a TunnelSpec, connecting their SSH
+// sessions, reconnecting them and reporting their status, the same way the
+// lazytunnel server does:
+//
+//	manager := tunnelkit.NewManager(ctx)
+//	defer manager.Shutdown()
+//
+//	err := manager.Create(ctx, &tunnelkit.TunnelSpec{
+//		ID:         "db",
+//		Name:       "db",
+//		Type:       tunnelkit.TunnelTypeLocal,
+//		LocalPort:  5432,
+//		RemoteHost: "db.internal",
+//		RemotePort: 5432,
+//		Hops: []tunnelkit.Hop{{
+//			Host:       "bastion.example.com",
+//			Port:       22,
+//			User:       "deploy",
+//			AuthMethod: tunnelkit.AuthMethodAgent,
+//		}},
+//	})
+//
+// Programs wanting more control can open a Session or MultiHopSession
+// themselves and run forwarders over it with NewLocalForwarder,
+// NewRemoteForwarder, NewDynamicForwarder and friends. The types here are
+// aliases of lazytunnel's own, so values move freely between them.
+package tunnelkit
+
+import (
+	"context"
+
+	"github.com/craigderington/lazytunnel/internal/tunnel"
+	"github.com/craigderington/lazytunnel/pkg/types"
+)
+
+// Tunnel specs and status
+type (
+	TunnelSpec   = types.TunnelSpec
+	TunnelStatus = types.TunnelStatus
+	TunnelType   = types.TunnelType
+	TunnelState  = types.TunnelState
+	Hop          = types.Hop
+	HopTransport = types.HopTransport
+	AuthMethod   = types.AuthMethod
+	Protocol     = types.Protocol
+
+	HostKeyVerification = types.HostKeyVerification
+)
+
+const (
+	TunnelTypeLocal       = types.TunnelTypeLocal
+	TunnelTypeRemote      = types.TunnelTypeRemote
+	TunnelTypeDynamic     = types.TunnelTypeDynamic
+	TunnelTypeTransparent = types.TunnelTypeTransparent
+
+	TunnelStatePending = types.TunnelStatePending
+	TunnelStateActive  = types.TunnelStateActive
+	TunnelStateFailed  = types.TunnelStateFailed
+	TunnelStateStopped = types.TunnelStateStopped
+
+	AuthMethodKey      = types.AuthMethodKey
+	AuthMethodPassword = types.AuthMethodPassword
+	AuthMethodAgent    = types.AuthMethodAgent
+	AuthMethodCert     = types.AuthMethodCert
+
+	HostKeyVerifyStrict   = types.HostKeyVerifyStrict
+	HostKeyVerifyPrompt   = types.HostKeyVerifyPrompt
+	HostKeyVerifyInsecure = types.HostKeyVerifyInsecure
+
+	ProtocolTCP = types.ProtocolTCP
+	ProtocolUDP = types.ProtocolUDP
+
+	HopTransportTCP  = types.HopTransportTCP
+	HopTransportWSS  = types.HopTransportWSS
+	HopTransportAuto = types.HopTransportAuto
+)
+
+// Managing tunnels
+type (
+	Manager              = tunnel.Manager
+	Tunnel               = tunnel.Tunnel
+	Storage              = tunnel.Storage
+	StopOptions          = tunnel.StopOptions
+	StatusCallback       = tunnel.StatusCallback
+	StatusEvent          = tunnel.StatusEvent
+	Subscription         = tunnel.Subscription
+	CircuitBreakerConfig = tunnel.CircuitBreakerConfig
+)
+
+// DefaultDrainTimeout is how long stopping a tunnel waits for its active
+// connections to finish, unless the spec sets its own
+const DefaultDrainTimeout = tunnel.DefaultDrainTimeout
+
+// NewManager creates a tunnel manager. Its tunnels live until ctx is done or
+// Shutdown is called.
+func NewManager(ctx context.Context, cbConfig ...CircuitBreakerConfig) *Manager {
+	return tunnel.NewManager(ctx, cbConfig...)
+}
+
+// DefaultCircuitBreakerConfig returns the circuit breaker settings managers
+// use unless given their own
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return tunnel.DefaultCircuitBreakerConfig()
+}
+
+// SSH sessions
+type (
+	Session         = tunnel.Session
+	SessionConfig   = tunnel.SessionConfig
+	SessionState    = tunnel.SessionState
+	SessionStatus   = tunnel.SessionStatus
+	MultiHopSession = tunnel.MultiHopSession
+	BackoffConfig   = tunnel.BackoffConfig
+)
+
+// NewSession creates an SSH session to config.Hop. It connects on Connect or
+// ConnectWithRetry.
+func NewSession(ctx context.Context, config SessionConfig) (*Session, error) {
+	return tunnel.NewSession(ctx, config)
+}
+
+// NewMultiHopSession creates an SSH session reaching the last of hops through
+// the ones before it
+func NewMultiHopSession(ctx context.Context, hops []Hop, config SessionConfig) (*MultiHopSession, error) {
+	return tunnel.NewMultiHopSession(ctx, hops, config)
+}
+
+// DefaultBackoffConfig returns the reconnect backoff sessions use unless
+// configured otherwise
+func DefaultBackoffConfig() BackoffConfig {
+	return tunnel.DefaultBackoffConfig()
+}
+
+// Forwarders
+type (
+	Forwarder          = tunnel.Forwarder
+	ForwarderStats     = tunnel.ForwarderStats
+	ForceStopper       = tunnel.ForceStopper
+	SessionDialer      = tunnel.SessionDialer
+	LocalForwarder     = tunnel.LocalForwarder
+	RemoteForwarder    = tunnel.RemoteForwarder
+	DynamicForwarder   = tunnel.DynamicForwarder
+	MultiPortForwarder = tunnel.MultiPortForwarder
+	UDPForwarder       = tunnel.UDPForwarder
+	ForwarderOption    = tunnel.ForwarderOption
+	ListenerFactory    = tunnel.ListenerFactory
+	Dialer             = tunnel.Dialer
+)
+
+// NewLocalForwarder creates a forwarder listening locally and forwarding
+// each connection to spec's remote host over session
+func NewLocalForwarder(ctx context.Context, spec *TunnelSpec, session SessionDialer, opts ...ForwarderOption) (*LocalForwarder, error) {
+	return tunnel.NewLocalForwarder(ctx, spec, session, opts...)
+}
+
+// NewRemoteForwarder creates a forwarder listening on the SSH server and
+// forwarding each connection back to spec's local port
+func NewRemoteForwarder(ctx context.Context, spec *TunnelSpec, session SessionDialer, opts ...ForwarderOption) (*RemoteForwarder, error) {
+	return tunnel.NewRemoteForwarder(ctx, spec, session, opts...)
+}
+
+// NewDynamicForwarder creates a local SOCKS5 proxy dialing its destinations
+// over session
+func NewDynamicForwarder(ctx context.Context, spec *TunnelSpec, session SessionDialer, opts ...ForwarderOption) (*DynamicForwarder, error) {
+	return tunnel.NewDynamicForwarder(ctx, spec, session, opts...)
+}
+
+// NewMultiPortForwarder creates a forwarder for every port mapping of spec,
+// sharing session
+func NewMultiPortForwarder(ctx context.Context, spec *TunnelSpec, session SessionDialer, opts ...ForwarderOption) (*MultiPortForwarder, error) {
+	return tunnel.NewMultiPortForwarder(ctx, spec, session, opts...)
+}
+
+// NewUDPForwarder creates a local forwarder carrying UDP datagrams over
+// session
+func NewUDPForwarder(ctx context.Context, spec *TunnelSpec, session SessionDialer, opts ...ForwarderOption) (*UDPForwarder, error) {
+	return tunnel.NewUDPForwarder(ctx, spec, session, opts...)
+}
+
+// WithListenerFactory makes forwarders open their local listeners through
+// factory instead of the net package
+func WithListenerFactory(factory ListenerFactory) ForwarderOption {
+	return tunnel.WithListenerFactory(factory)
+}
+
+// WithDialer makes forwarders dial local connections through dialer instead
+// of the net package
+func WithDialer(dialer Dialer) ForwarderOption {
+	return tunnel.WithDialer(dialer)
+}
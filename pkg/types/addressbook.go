@@ -0,0 +1,58 @@
+package types
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// AddressRefPrefix marks a hop host or destination as the name of an
+// address book entry rather than a hostname, e.g. @prod-bastion
+const AddressRefPrefix = "@"
+
+// addressNamePattern is what address book entry names may look like
+var addressNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,62}$`)
+
+// ValidAddressName reports whether name can name an address book entry
+func ValidAddressName(name string) bool {
+	return addressNamePattern.MatchString(name)
+}
+
+// AddressRef returns the address book entry a host references, if it does
+func AddressRef(host string) (string, bool) {
+	if !strings.HasPrefix(host, AddressRefPrefix) {
+		return "", false
+	}
+	name := strings.TrimPrefix(host, AddressRefPrefix)
+	return name, ValidAddressName(name)
+}
+
+// AddressRefPort returns the address book entry and port of a destination
+// written as @name:port, if it is one
+func AddressRefPort(target string) (string, string, bool) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", "", false
+	}
+	name, ok := AddressRef(host)
+	return name, port, ok
+}
+
+// AddressBookEntry names a host, and how to log in to it, so tunnel specs
+// can reference it as @name: hops take the entry's host, and the port,
+// user and credentials they leave unset, when they connect; destinations
+// take its host. Changing the entry changes every tunnel using it from its
+// next connection on.
+type AddressBookEntry struct {
+	Name        string     `json:"name"`
+	Host        string     `json:"host"`
+	Port        int        `json:"port,omitempty"` // SSH port of hops using the entry
+	User        string     `json:"user,omitempty"`
+	AuthMethod  AuthMethod `json:"auth_method,omitempty"`
+	KeyID       string     `json:"key_id,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Owner       string     `json:"owner"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
@@ -31,4 +31,11 @@ type AgentStatusReport struct {
 	TunnelID  string `json:"tunnel_id"`
 	Status    string `json:"status"`
 	LastError string `json:"last_error,omitempty"`
+
+	FailureReason FailureReason `json:"failure_reason,omitempty"`
+
+	// The next attempt to connect the tunnel, while one is scheduled
+	NextRetryAt      *time.Time `json:"next_retry_at,omitempty"`
+	RetryAttempt     int        `json:"retry_attempt,omitempty"`
+	MaxRetryAttempts int        `json:"max_retry_attempts,omitempty"`
 }
\ No newline at end of file
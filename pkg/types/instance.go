@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+// Instance is a server sharing its storage with others for high
+// availability. Instances announce themselves with heartbeats.
+type Instance struct {
+	ID          string    `json:"id"`
+	Address     string    `json:"address,omitempty"` // where its API can be reached, if configured
+	StartedAt   time.Time `json:"started_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+}
+
+// Alive reports whether the instance has sent a heartbeat within ttl of now
+func (i *Instance) Alive(now time.Time, ttl time.Duration) bool {
+	return now.Sub(i.HeartbeatAt) < ttl
+}
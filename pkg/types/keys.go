@@ -0,0 +1,46 @@
+package types
+
+import (
+	"strings"
+	"time"
+)
+
+// ManagedKeyPrefix marks a hop's key ID as a managed key's ID rather than a
+// private key file path, e.g. managed://<id>
+const ManagedKeyPrefix = "managed://"
+
+// ManagedKeyID returns the managed key a hop key ID references, if it does
+func ManagedKeyID(keyID string) (string, bool) {
+	if !strings.HasPrefix(keyID, ManagedKeyPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(keyID, ManagedKeyPrefix), true
+}
+
+// ManagedKey is an SSH private key kept by the server, encrypted at rest,
+// that hops reference by ID instead of by a path on the server's filesystem
+type ManagedKey struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Owner       string     `json:"owner"`
+	Type        string     `json:"type"`        // e.g. ssh-ed25519
+	Fingerprint string     `json:"fingerprint"` // SHA256, as ssh-keygen -l shows it
+	PublicKey   string     `json:"public_key"`  // in authorized_keys format
+	CreatedAt   time.Time  `json:"created_at"`
+	RotatedAt   *time.Time `json:"rotated_at,omitempty"`
+
+	// Previous is the key a rotation replaced. Hops keep offering it after
+	// the new one until the rotation is finished, so tunnels stay up while
+	// the new public key is rolled out.
+	Previous *ManagedKeyVersion `json:"previous,omitempty"`
+
+	PrivateKey []byte `json:"-"` // encrypted OpenSSH private key
+}
+
+// ManagedKeyVersion is a managed key's key pair
+type ManagedKeyVersion struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"public_key"`
+	PrivateKey  []byte `json:"-"` // encrypted OpenSSH private key
+}
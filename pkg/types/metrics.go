@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+// MetricsSnapshot is a tunnel's state and traffic counters at a point in
+// time, persisted so that traffic history survives restarts
+type MetricsSnapshot struct {
+	TunnelID      string        `json:"tunnel_id"`
+	Timestamp     time.Time     `json:"timestamp"`
+	State         TunnelState   `json:"state"`
+	BytesSent     int64         `json:"bytes_sent"`
+	BytesReceived int64         `json:"bytes_received"`
+	Connections   int64         `json:"connections"`
+	ActiveConns   int64         `json:"active_conns"`
+	Errors        int64         `json:"errors"`
+	Latency       time.Duration `json:"latency"`
+}
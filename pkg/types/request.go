@@ -0,0 +1,135 @@
+package types
+
+// The request types below are the API's create request, shared so clients
+// build exactly what the server validates. The validate tags are checked by
+// the API server, which also registers the custom ones (tunneltype,
+// authmethod, subdomain, bindaddr, addressref, addressref_port) and the
+// checks across fields. Hosts may be @name references to address book
+// entries.
+
+// CreateTunnelRequest represents the validated request for creating a tunnel
+type CreateTunnelRequest struct {
+	Name             string           `json:"name" validate:"required,min=1,max=100"`
+	Type             string           `json:"type" validate:"required,tunneltype"`
+	Protocol         string           `json:"protocol" validate:"omitempty,oneof=tcp udp"`
+	Hops             []HopReq         `json:"hops" validate:"required,min=1,dive"`
+	LocalPort        int              `json:"localPort" validate:"min=0,max=65535"`
+	LocalBindAddress string           `json:"localBindAddress" validate:"omitempty,bindaddr"`   // IP address, [IPv6] or localhost
+	LocalFamily      string           `json:"localFamily" validate:"omitempty,oneof=ipv4 ipv6"` // empty = any
+	RemoteHost       string           `json:"remoteHost" validate:"omitempty,hostname|ip_addr|addressref"`
+	RemotePort       int              `json:"remotePort" validate:"omitempty,min=1,max=65535"`
+	Targets          []string         `json:"targets" validate:"omitempty,max=32,dive,hostname_port|addressref_port"`
+	Balance          *BalanceReq      `json:"balance"`
+	Ports            []PortMappingReq `json:"ports" validate:"omitempty,max=64,dive"`
+	Routes           []string         `json:"routes" validate:"omitempty,dive,cidrv4"`
+	SystemProxy      bool             `json:"systemProxy"` // dynamic tunnels: register as the OS's SOCKS proxy while running
+	PAC              *PACReq          `json:"pac"`         // dynamic tunnels: what their proxy.pac sends through the proxy
+	TCP              *TCPOptionsReq   `json:"tcp"`
+	AutoReconnect    *bool            `json:"autoReconnect"` // nil uses the server's default
+	KeepAlive        int              `json:"keepAlive" validate:"min=0,max=300"`
+	KeepAliveMax     int              `json:"keepAliveMax" validate:"min=0,max=100"`   // unanswered keep-alives in a row before reconnecting; 0 = 3
+	DrainTimeout     int              `json:"drainTimeout" validate:"min=0,max=86400"` // seconds stopping waits for active connections; 0 = 10
+	MaxRetries       int              `json:"maxRetries" validate:"min=0,max=100"`
+	Backoff          *BackoffReq      `json:"backoff"`
+	AgentID          string           `json:"agentId" validate:"omitempty,max=100"`
+	Expose           bool             `json:"expose"`
+	Subdomain        string           `json:"subdomain" validate:"omitempty,subdomain"`
+	Staleness        *StalenessReq    `json:"staleness"`
+	Restart          *RestartReq      `json:"restart"`
+	Hooks            *HooksReq        `json:"hooks"`
+	DependsOn        []string         `json:"dependsOn" validate:"omitempty,max=16,dive,min=1,max=100"` // names of tunnels to wait for
+	// Interpolated marks fields the client resolved from ${VAR} references:
+	// field path to template, kept with the tunnel
+	Interpolated map[string]string `json:"interpolated" validate:"omitempty,max=64,dive,keys,max=128,endkeys,max=1024"`
+}
+
+// StalenessReq configures stale tunnel detection in a validated tunnel request
+type StalenessReq struct {
+	After  int    `json:"after" validate:"min=0,max=604800"` // seconds without traffic; 0 = never stale
+	Action string `json:"action" validate:"omitempty,oneof=notify restart stop"`
+}
+
+// PACReq picks what a dynamic tunnel's proxy auto-config file proxies in a
+// validated tunnel request
+type PACReq struct {
+	Domains []string `json:"domains" validate:"omitempty,max=64,dive,hostname_rfc1123"` // also matches subdomains
+	CIDRs   []string `json:"cidrs" validate:"omitempty,max=64,dive,cidrv4"`
+}
+
+// PortMappingReq represents one port of a multi-port local tunnel in a validated request
+type PortMappingReq struct {
+	Name       string `json:"name" validate:"omitempty,max=64"`
+	LocalPort  int    `json:"localPort" validate:"min=0,max=65535"` // 0 = OS-assigned
+	RemoteHost string `json:"remoteHost" validate:"required,hostname|ip_addr|addressref"`
+	RemotePort int    `json:"remotePort" validate:"required,min=1,max=65535"`
+}
+
+// BalanceReq configures load balancing over Targets in a validated tunnel request
+type BalanceReq struct {
+	Strategy            string `json:"strategy" validate:"omitempty,oneof=round-robin least-connections"`
+	HealthCheckInterval int    `json:"healthCheckInterval" validate:"min=0,max=3600"` // seconds; 0 = default
+}
+
+// TCPOptionsReq represents socket tuning in a validated tunnel request
+type TCPOptionsReq struct {
+	NoDelay        *bool `json:"noDelay"`
+	KeepAlive      int   `json:"keepAlive" validate:"min=-1,max=7200"` // seconds; -1 disables
+	ReadBuffer     int   `json:"readBuffer" validate:"min=0,max=16777216"`
+	WriteBuffer    int   `json:"writeBuffer" validate:"min=0,max=16777216"`
+	SSHBuffer      int   `json:"sshBuffer" validate:"min=0,max=67108864"` // SSH connection to the first hop
+	ConnectTimeout int   `json:"connectTimeout" validate:"min=0,max=300"` // seconds
+	IdleTimeout    int   `json:"idleTimeout" validate:"min=0,max=86400"`  // seconds; 0 = never
+
+	DialRetries      int `json:"dialRetries" validate:"min=0,max=10"`
+	DialRetryBackoff int `json:"dialRetryBackoff" validate:"min=0,max=10000"` // milliseconds; 0 = default
+	HoldTimeout      int `json:"holdTimeout" validate:"min=0,max=300"`        // seconds
+}
+
+// RestartReq configures the restart policy in a validated tunnel request
+type RestartReq struct {
+	Mode       string `json:"mode" validate:"omitempty,oneof=always on-failure never"`
+	MaxPerHour int    `json:"maxPerHour" validate:"min=0,max=1000"` // 0 = default
+	Backoff    int    `json:"backoff" validate:"min=0,max=3600"`    // seconds; 0 = default
+}
+
+// BackoffReq configures the reconnect backoff in a validated tunnel request
+type BackoffReq struct {
+	Initial    int     `json:"initial" validate:"min=0,max=300000"`                         // milliseconds; 0 = default
+	Max        int     `json:"max" validate:"omitempty,min=0,max=3600000,gtefield=Initial"` // milliseconds; 0 = default
+	Multiplier float64 `json:"multiplier" validate:"omitempty,min=1,max=10"`                // 0 = default
+	Jitter     float64 `json:"jitter" validate:"min=-1,max=1"`                              // fraction of each delay; 0 = default, -1 disables
+}
+
+// HooksReq configures lifecycle hooks in a validated tunnel request
+type HooksReq struct {
+	OnConnect    []HookReq `json:"onConnect" validate:"omitempty,max=8,dive"`
+	OnDisconnect []HookReq `json:"onDisconnect" validate:"omitempty,max=8,dive"`
+	OnFailure    []HookReq `json:"onFailure" validate:"omitempty,max=8,dive"`
+}
+
+// HookReq is one hook in a validated tunnel request: a command or a webhook
+type HookReq struct {
+	Command string `json:"command,omitempty" validate:"required_without=URL,excluded_with=URL,max=4096"`
+	URL     string `json:"url,omitempty" validate:"omitempty,http_url,max=2048"`
+	Timeout int    `json:"timeout,omitempty" validate:"min=0,max=3600"` // seconds; 0 = default
+}
+
+// HopReq represents a single hop in a validated tunnel request. A hop whose
+// host is an @name address book reference takes the port, user and
+// credentials it leaves unset from the entry; other hops need them all.
+type HopReq struct {
+	Host       string `json:"host" validate:"required,hostname|ip_addr|addressref"`
+	Port       int    `json:"port" validate:"omitempty,min=1,max=65535"`
+	User       string `json:"user" validate:"omitempty,max=100"`
+	AuthMethod string `json:"auth_method" validate:"omitempty,authmethod"`
+	KeyID      string `json:"key_id,omitempty"`
+
+	ForwardAgent        bool `json:"forward_agent,omitempty"`
+	KeyboardInteractive bool `json:"keyboard_interactive,omitempty"`
+
+	Via    string `json:"via,omitempty" validate:"omitempty,max=100"`                      // ID or name of a local or dynamic tunnel to reach the hop through
+	Attach string `json:"attach,omitempty" validate:"omitempty,max=100,excluded_with=Via"` // ID or name of a tunnel whose connection to the hop is reused
+
+	Transport string `json:"transport,omitempty" validate:"omitempty,oneof=tcp wss auto"` // how the hop is reached: tcp (default), wss through RelayURL, or auto
+	RelayURL  string `json:"relay_url,omitempty" validate:"omitempty,max=2048,ws_url"`    // lazytunnel server relay endpoint, wss://host/api/v1/relay
+}
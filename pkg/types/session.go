@@ -0,0 +1,21 @@
+package types
+
+import "time"
+
+// Session is an issued API token, tracked by its JWT ID so it can be
+// listed and revoked before it expires.
+type Session struct {
+	ID        string     `json:"id"` // the token's jti claim
+	UserID    string     `json:"user_id"`
+	Username  string     `json:"username"`
+	Device    string     `json:"device"` // User-Agent of the login request
+	IP        string     `json:"ip"`
+	IssuedAt  time.Time  `json:"issued_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the session's token is still usable at now
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
@@ -1,6 +1,11 @@
 package types
 
-import "time"
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
 
 // TunnelType represents the type of SSH tunnel
 type TunnelType string
@@ -9,6 +14,29 @@ const (
 	TunnelTypeLocal   TunnelType = "local"
 	TunnelTypeRemote  TunnelType = "remote"
 	TunnelTypeDynamic TunnelType = "dynamic"
+	// TunnelTypeTransparent redirects traffic for selected CIDRs through the
+	// SSH connection (sshuttle-style TCP NAT, Linux only)
+	TunnelTypeTransparent TunnelType = "transparent"
+)
+
+// Protocol represents the transport protocol carried by a tunnel
+type Protocol string
+
+const (
+	ProtocolTCP Protocol = "tcp"
+	// ProtocolUDP frames datagrams over an SSH channel (local tunnels only)
+	ProtocolUDP Protocol = "udp"
+)
+
+// AddressFamily restricts the IP version of a tunnel's local socket
+type AddressFamily string
+
+const (
+	// AddressFamilyAny binds whatever the bind address is: both loopback
+	// addresses for localhost, and both versions for ::
+	AddressFamilyAny  AddressFamily = ""
+	AddressFamilyIPv4 AddressFamily = "ipv4"
+	AddressFamilyIPv6 AddressFamily = "ipv6"
 )
 
 // TunnelState represents the current state of a tunnel
@@ -19,6 +47,26 @@ const (
 	TunnelStateActive  TunnelState = "active"
 	TunnelStateFailed  TunnelState = "failed"
 	TunnelStateStopped TunnelState = "stopped"
+	// TunnelStateMisconfigured marks a stored tunnel whose spec can't work
+	// as is, found when the server loads it; TunnelStatus.Problems says why
+	TunnelStateMisconfigured TunnelState = "misconfigured"
+)
+
+// FailureReason categorizes why a tunnel or hop failed, so clients can
+// suggest a remedy instead of showing the raw error
+type FailureReason string
+
+const (
+	FailureAuthFailed        FailureReason = "auth_failed"        // the hop rejected the credentials
+	FailureHostUnreachable   FailureReason = "host_unreachable"   // a hop couldn't be reached
+	FailureDNSNotFound       FailureReason = "dns_not_found"      // a hop or destination hostname doesn't exist
+	FailureDNSUnavailable    FailureReason = "dns_unavailable"    // DNS didn't answer for a hop or destination, or failed
+	FailureHostKeyMismatch   FailureReason = "host_key_mismatch"  // the hop's key isn't the known one
+	FailurePortInUse         FailureReason = "port_in_use"        // the listening port is taken, locally or on the hop
+	FailureTargetUnreachable FailureReason = "target_unreachable" // the hop couldn't reach the destination
+	FailureCircuitOpen       FailureReason = "circuit_open"       // connects are paused after repeated failures
+	FailureListenerBroken    FailureReason = "listener_broken"    // the listening socket stopped accepting connections
+	FailureUnknown           FailureReason = "unknown"
 )
 
 // AuthMethod represents SSH authentication methods
@@ -39,26 +87,261 @@ const (
 	DesiredStatusActive  DesiredStatus = "active"
 )
 
+// DefaultProject is the project of tunnels and users that don't name one
+const DefaultProject = "default"
+
 // TunnelSpec defines a tunnel configuration
 type TunnelSpec struct {
 	ID               string        `json:"id"`
 	Name             string        `json:"name"`
 	Owner            string        `json:"owner"`
+	Project          string        `json:"project,omitempty"`  // empty = DefaultProject
 	AgentID          string        `json:"agent_id,omitempty"` // empty = run on API server (embedded)
 	DesiredStatus    DesiredStatus `json:"desired_status,omitempty"`
 	Type             TunnelType    `json:"type"`
+	Protocol         Protocol      `json:"protocol,omitempty"` // empty = tcp
 	Hops             []Hop         `json:"hops"`
 	LocalPort        int           `json:"local_port,omitempty"`
 	LocalBindAddress string        `json:"local_bind_address,omitempty"`
+	LocalFamily      AddressFamily `json:"local_family,omitempty"` // IP version of the local socket; empty = any
 	RemoteHost       string        `json:"remote_host,omitempty"`
 	RemotePort       int           `json:"remote_port,omitempty"`
+	Targets          []string      `json:"targets,omitempty"` // host:port pool for local tunnels, instead of remote_host/remote_port
+	Balance          BalancePolicy `json:"balance,omitempty"`
+	Ports            []PortMapping `json:"ports,omitempty"`            // local tunnels: several ports over one SSH session, instead of local_port/remote_host/remote_port
+	PublicSubdomain  string        `json:"public_subdomain,omitempty"` // remote tunnels exposed via the server's public router
+	Routes           []string      `json:"routes,omitempty"`           // CIDRs routed through transparent tunnels
+	SystemProxy      bool          `json:"system_proxy,omitempty"`     // dynamic tunnels: registered as the OS's SOCKS proxy while running
+	PAC              PACPolicy     `json:"pac,omitempty"`              // dynamic tunnels: what their proxy auto-config file proxies
+	TCP              TCPOptions    `json:"tcp,omitempty"`
 	Auth             AuthConfig    `json:"auth"`
 	AutoReconnect    bool          `json:"auto_reconnect"`
 	KeepAlive        time.Duration `json:"keep_alive"`
+	KeepAliveMax     int           `json:"keep_alive_max,omitempty"` // unanswered keep-alives in a row before the connection is lost; 0 = default
+	DrainTimeout     time.Duration `json:"drain_timeout,omitempty"`  // how long stopping waits for active connections to finish; 0 = default
 	MaxRetries       int           `json:"max_retries"`
+	Backoff          BackoffPolicy `json:"backoff,omitempty"`
 	Policy           PolicySpec    `json:"policy,omitempty"`
+	Staleness        StalePolicy   `json:"staleness,omitempty"`
+	Restart          RestartPolicy `json:"restart,omitempty"`
+	Hooks            Hooks         `json:"hooks,omitempty"`
+	DependsOn        []string      `json:"depends_on,omitempty"` // names of tunnels that must be active before this one connects
 	CreatedAt        time.Time     `json:"created_at"`
 	UpdatedAt        time.Time     `json:"updated_at"`
+	DeletedAt        *time.Time    `json:"deleted_at,omitempty"` // set while soft-deleted
+
+	// Interpolated marks the fields resolved from ${VAR} references when the
+	// tunnel was created, mapping each field's path (e.g. "hops[0].host") to
+	// its template
+	Interpolated map[string]string `json:"interpolated,omitempty"`
+}
+
+// ProjectName returns the project the tunnel belongs to
+func (s *TunnelSpec) ProjectName() string {
+	if s.Project == "" {
+		return DefaultProject
+	}
+	return s.Project
+}
+
+// ParseBindAddress checks a local bind address and returns it without
+// brackets: an IP address, an IPv6 one in brackets ([::1]) or with a zone
+// (fe80::1%eth0), or localhost
+func ParseBindAddress(addr string) (string, error) {
+	host := addr
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+		if ip, err := netip.ParseAddr(host); err != nil || !ip.Is6() {
+			return "", fmt.Errorf("bind address %q: only IPv6 addresses go in brackets", addr)
+		}
+	}
+	if strings.EqualFold(host, "localhost") {
+		return "localhost", nil
+	}
+	if _, err := netip.ParseAddr(host); err != nil {
+		return "", fmt.Errorf("bind address %q: must be an IP address or localhost", addr)
+	}
+	return host, nil
+}
+
+// Allows reports whether a bind address ParseBindAddress accepted can be
+// bound in family f. localhost can be bound in either.
+func (f AddressFamily) Allows(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	switch {
+	case err != nil, f == AddressFamilyAny:
+		return true
+	case f == AddressFamilyIPv4:
+		return ip.Unmap().Is4()
+	}
+	return ip.Is6() && !ip.Is4In6()
+}
+
+// TCPOptions tunes the sockets of forwarded connections. Zero values keep
+// the Go/OS defaults.
+type TCPOptions struct {
+	NoDelay        *bool         `json:"no_delay,omitempty"`        // nil = enabled (Go default)
+	KeepAlive      time.Duration `json:"keep_alive,omitempty"`      // SO_KEEPALIVE interval; negative disables
+	ReadBuffer     int           `json:"read_buffer,omitempty"`     // SO_RCVBUF bytes
+	WriteBuffer    int           `json:"write_buffer,omitempty"`    // SO_SNDBUF bytes
+	SSHBuffer      int           `json:"ssh_buffer,omitempty"`      // SO_RCVBUF and SO_SNDBUF bytes of the SSH connection to the first hop
+	ConnectTimeout time.Duration `json:"connect_timeout,omitempty"` // remote dial timeout; 0 = default
+	IdleTimeout    time.Duration `json:"idle_timeout,omitempty"`    // close connections idle this long; 0 = never
+
+	// Retrying failed dials to the destination (e.g. while it restarts)
+	DialRetries      int           `json:"dial_retries,omitempty"`       // extra attempts after a failed dial
+	DialRetryBackoff time.Duration `json:"dial_retry_backoff,omitempty"` // delay before the first retry, doubled per attempt; 0 = default
+	HoldTimeout      time.Duration `json:"hold_timeout,omitempty"`       // keep the client connected and retry for up to this long
+}
+
+// StaleAction is what happens to a connected tunnel that stops carrying traffic
+type StaleAction string
+
+const (
+	// StaleActionNotify only reports the tunnel as stale
+	StaleActionNotify StaleAction = "notify"
+	// StaleActionRestart reconnects the tunnel
+	StaleActionRestart StaleAction = "restart"
+	// StaleActionStop stops the tunnel
+	StaleActionStop StaleAction = "stop"
+)
+
+// PACPolicy picks the destinations a dynamic tunnel's proxy auto-config
+// (PAC) file sends through its SOCKS proxy; browsers reach everything else
+// directly
+type PACPolicy struct {
+	Domains []string `json:"domains,omitempty"` // each also matches its subdomains
+	CIDRs   []string `json:"cidrs,omitempty"`   // IPv4 networks, matched against addresses as typed, not resolved
+}
+
+// Empty reports whether the policy proxies nothing
+func (p PACPolicy) Empty() bool {
+	return len(p.Domains) == 0 && len(p.CIDRs) == 0
+}
+
+// StalePolicy marks a tunnel stale once it has been active without any
+// traffic for After, then applies Action (default notify)
+type StalePolicy struct {
+	After  time.Duration `json:"after,omitempty"` // 0 = never stale
+	Action StaleAction   `json:"action,omitempty"`
+}
+
+// BackoffPolicy spaces out a session's reconnect attempts: each delay is
+// the previous one times Multiplier, up to Max. Zero values keep the
+// defaults.
+type BackoffPolicy struct {
+	Initial    time.Duration `json:"initial,omitempty"`    // delay before the first retry; 0 = 1s
+	Max        time.Duration `json:"max,omitempty"`        // longest delay; 0 = 60s
+	Multiplier float64       `json:"multiplier,omitempty"` // 0 = 2
+	Jitter     float64       `json:"jitter,omitempty"`     // fraction of each delay randomized either way, up to 1; 0 = 0.2, negative = none
+}
+
+// RestartMode selects when the manager restarts a tunnel that went down
+type RestartMode string
+
+const (
+	// RestartNever leaves failed tunnels failed (the default)
+	RestartNever RestartMode = "never"
+	// RestartOnFailure restarts tunnels that fail to connect or lose their
+	// connection for good
+	RestartOnFailure RestartMode = "on-failure"
+	// RestartAlways also restarts tunnels the server stopped by itself, such
+	// as the stop action of a StalePolicy. User-requested stops are final.
+	RestartAlways RestartMode = "always"
+)
+
+// RestartPolicy restarts a tunnel from scratch (new SSH session and
+// forwarder) after it goes down, beyond the session-level AutoReconnect
+type RestartPolicy struct {
+	Mode       RestartMode   `json:"mode,omitempty"`         // empty = never
+	MaxPerHour int           `json:"max_per_hour,omitempty"` // restart budget; 0 = default
+	Backoff    time.Duration `json:"backoff,omitempty"`      // delay before the first restart, doubled per restart in the last hour; 0 = default
+}
+
+// HookEvent is a change in a tunnel's lifecycle that runs its hooks
+type HookEvent string
+
+const (
+	// HookConnect fires when the tunnel becomes active
+	HookConnect HookEvent = "connect"
+	// HookDisconnect fires when an active tunnel stops being active, e.g.
+	// when it is stopped or starts reconnecting
+	HookDisconnect HookEvent = "disconnect"
+	// HookFailure fires when the tunnel fails
+	HookFailure HookEvent = "failure"
+)
+
+// Hook runs a local command or calls a webhook; exactly one of Command and
+// URL is set
+type Hook struct {
+	Command string        `json:"command,omitempty"` // run by the shell, with the tunnel's context in LAZYTUNNEL_* variables
+	URL     string        `json:"url,omitempty"`     // POSTed the event as JSON
+	Timeout time.Duration `json:"timeout,omitempty"` // 0 = default
+}
+
+// Hooks are run, in order, on a tunnel's lifecycle events by the machine
+// running the tunnel
+type Hooks struct {
+	OnConnect    []Hook `json:"on_connect,omitempty"`
+	OnDisconnect []Hook `json:"on_disconnect,omitempty"`
+	OnFailure    []Hook `json:"on_failure,omitempty"`
+}
+
+// For returns the hooks of an event
+func (h Hooks) For(event HookEvent) []Hook {
+	switch event {
+	case HookConnect:
+		return h.OnConnect
+	case HookDisconnect:
+		return h.OnDisconnect
+	case HookFailure:
+		return h.OnFailure
+	}
+	return nil
+}
+
+// Empty reports whether there are no hooks
+func (h Hooks) Empty() bool {
+	return len(h.OnConnect) == 0 && len(h.OnDisconnect) == 0 && len(h.OnFailure) == 0
+}
+
+// HasCommands reports whether any hook runs a command
+func (h Hooks) HasCommands() bool {
+	for _, hooks := range [][]Hook{h.OnConnect, h.OnDisconnect, h.OnFailure} {
+		for _, hook := range hooks {
+			if hook.Command != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PortMapping is one port forwarded by a multi-port local tunnel
+type PortMapping struct {
+	Name       string `json:"name,omitempty"` // e.g. "db"
+	LocalPort  int    `json:"local_port"`     // 0 = OS-assigned
+	RemoteHost string `json:"remote_host"`
+	RemotePort int    `json:"remote_port"`
+}
+
+// BalanceStrategy selects which pooled target serves a new connection
+type BalanceStrategy string
+
+const (
+	// BalanceRoundRobin cycles through the healthy targets (the default)
+	BalanceRoundRobin BalanceStrategy = "round-robin"
+	// BalanceLeastConnections picks the healthy target with the fewest open
+	// connections
+	BalanceLeastConnections BalanceStrategy = "least-connections"
+)
+
+// BalancePolicy controls how a local tunnel spreads connections over its
+// Targets. Targets that fail a dial or health check are ejected until a
+// later health check succeeds.
+type BalancePolicy struct {
+	Strategy            BalanceStrategy `json:"strategy,omitempty"`              // empty = round-robin
+	HealthCheckInterval time.Duration   `json:"health_check_interval,omitempty"` // 0 = default
 }
 
 // HostKeyVerification represents host key verification strategies
@@ -82,8 +365,49 @@ type Hop struct {
 	KeyID               string              `json:"key_id,omitempty"`
 	HostKeyVerification HostKeyVerification `json:"host_key_verification,omitempty"`
 	KnownHostsPath      string              `json:"known_hosts_path,omitempty"`
+
+	// ForwardAgent exposes the local SSH agent to this hop (like ssh -A) so it
+	// can authenticate onward with the user's keys. Anyone with root on the
+	// hop can use the agent while the tunnel is connected.
+	ForwardAgent bool `json:"forward_agent,omitempty"`
+
+	// KeyboardInteractive also tries keyboard-interactive auth after the
+	// primary method (e.g. Duo or OTP), relaying the server's questions to
+	// the user through the API
+	KeyboardInteractive bool `json:"keyboard_interactive,omitempty"`
+
+	// Via is the ID of a local or dynamic tunnel to reach this hop through,
+	// instead of dialing it directly. Only the first hop of a tunnel has one.
+	Via string `json:"via,omitempty"`
+
+	// Attach is the ID of a tunnel connected to the same hop whose SSH
+	// connection is reused, instead of dialing and authenticating again.
+	// Only the first hop of a tunnel has one.
+	Attach string `json:"attach,omitempty"`
+
+	// Transport is how the connection to this hop is carried. Only the
+	// first hop of a tunnel has one.
+	Transport HopTransport `json:"transport,omitempty"` // empty = tcp
+	// RelayURL is the lazytunnel server's relay endpoint
+	// (wss://host/api/v1/relay) used by the wss and auto transports
+	RelayURL string `json:"relay_url,omitempty"`
 }
 
+// HopTransport is how the connection to a hop is carried
+type HopTransport string
+
+const (
+	// HopTransportTCP dials the hop directly
+	HopTransportTCP HopTransport = "tcp"
+	// HopTransportWSS wraps the SSH connection in a WebSocket to a lazytunnel
+	// server, which unwraps it and dials the hop, for networks that only
+	// let HTTPS out
+	HopTransportWSS HopTransport = "wss"
+	// HopTransportAuto dials the hop directly and falls back to wss when
+	// that fails
+	HopTransportAuto HopTransport = "auto"
+)
+
 // AuthConfig contains authentication configuration
 type AuthConfig struct {
 	Method   AuthMethod `json:"method"`
@@ -101,12 +425,82 @@ type PolicySpec struct {
 
 // TunnelStatus represents the current status of a tunnel
 type TunnelStatus struct {
-	TunnelID      string        `json:"tunnel_id"`
-	State         TunnelState   `json:"state"`
-	ConnectedAt   *time.Time    `json:"connected_at,omitempty"`
-	LastError     string        `json:"last_error,omitempty"`
-	BytesSent     int64         `json:"bytes_sent"`
-	BytesReceived int64         `json:"bytes_received"`
-	Latency       time.Duration `json:"latency"`
-	RetryCount    int           `json:"retry_count"`
+	TunnelID      string         `json:"tunnel_id"`
+	State         TunnelState    `json:"state"`
+	ConnectedAt   *time.Time     `json:"connected_at,omitempty"`
+	LastError     string         `json:"last_error,omitempty"`
+	FailureReason FailureReason  `json:"failure_reason,omitempty"` // category of LastError, set with it
+	Problems      []string       `json:"problems,omitempty"`       // what makes a misconfigured tunnel's spec unusable
+	BytesSent     int64          `json:"bytes_sent"`
+	BytesReceived int64          `json:"bytes_received"`
+	Latency       time.Duration  `json:"latency"`
+	RetryCount    int            `json:"retry_count"`
+	BoundAddress  string         `json:"bound_address,omitempty"` // where the tunnel accepts connections once listening
+	BoundPort     int            `json:"bound_port,omitempty"`
+	Hops          []HopStatus    `json:"hops,omitempty"`
+	Targets       []TargetStatus `json:"targets,omitempty"` // load-balanced targets, in configured order
+	Ports         []PortStatus   `json:"ports,omitempty"`   // multi-port mappings, in configured order
+
+	// Traffic staleness, computed while the tunnel is forwarding
+	LastActivity *time.Time `json:"last_activity,omitempty"`
+	StaleSeconds float64    `json:"stale_seconds"`   // seconds since LastActivity
+	Stale        bool       `json:"stale,omitempty"` // idle past the tunnel's StalePolicy
+
+	// Restarts performed by the tunnel's RestartPolicy
+	RestartCount     int        `json:"restart_count"`
+	RestartsLastHour int        `json:"restarts_last_hour"`
+	LastRestartAt    *time.Time `json:"last_restart_at,omitempty"`
+
+	// Connections closed without draining when the tunnel was last stopped
+	ForceClosed int `json:"force_closed,omitempty"`
+
+	// The next reconnect or restart attempt, while one is scheduled
+	NextRetryAt      *time.Time `json:"next_retry_at,omitempty"`
+	RetryAttempt     int        `json:"retry_attempt,omitempty"`      // counting from 1
+	MaxRetryAttempts int        `json:"max_retry_attempts,omitempty"` // attempts before giving up; for restarts, per hour
+}
+
+// TargetStatus describes one target of a load-balanced local tunnel
+type TargetStatus struct {
+	Address     string `json:"address"`
+	Healthy     bool   `json:"healthy"`
+	ActiveConns int64  `json:"active_conns"`
+	Connections int64  `json:"connections"` // successful dials
+	Failures    int64  `json:"failures"`    // failed dials
+	LastError   string `json:"last_error,omitempty"`
+
+	FailureReason FailureReason `json:"failure_reason,omitempty"`
+}
+
+// PortStatus reports one mapping of a multi-port local tunnel
+type PortStatus struct {
+	Name          string `json:"name,omitempty"`
+	LocalPort     int    `json:"local_port"` // the bound port once listening
+	RemoteHost    string `json:"remote_host"`
+	RemotePort    int    `json:"remote_port"`
+	BoundAddress  string `json:"bound_address,omitempty"`
+	BytesSent     int64  `json:"bytes_sent"`
+	BytesReceived int64  `json:"bytes_received"`
+	Connections   int64  `json:"connections"`
+	ActiveConns   int64  `json:"active_conns"`
+	Errors        int64  `json:"errors"`
+}
+
+// HopStatus describes the SSH connection to one hop, in chain order
+type HopStatus struct {
+	Host             string        `json:"host"`
+	Port             int           `json:"port"`
+	User             string        `json:"user"`
+	State            string        `json:"state,omitempty"` // disconnected, connecting, connected, reconnecting or closed
+	Connected        bool          `json:"connected"`
+	ConnectedAt      *time.Time    `json:"connected_at,omitempty"`
+	LastError        string        `json:"last_error,omitempty"`
+	FailureReason    FailureReason `json:"failure_reason,omitempty"`
+	Latency          time.Duration `json:"latency"`                      // last keep-alive round trip
+	MissedKeepAlives int           `json:"missed_keep_alives,omitempty"` // unanswered keep-alives in a row
+	RetryCount       int           `json:"retry_count"`
+	NextRetryAt      *time.Time    `json:"next_retry_at,omitempty"` // while backing off between attempts
+	ForwardAgent     bool          `json:"forward_agent,omitempty"`
+	ServerVersion    string        `json:"server_version,omitempty"` // e.g. SSH-2.0-OpenSSH_9.6
+	Banner           string        `json:"banner,omitempty"`         // pre-auth banner sent by the server
 }
@@ -0,0 +1,32 @@
+//go:build !noui
+
+// Package web holds the built frontend so the server binary can serve it
+// without web/dist being deployed next to it. Run "npm run build" here
+// before "go build" to include it; build with -tags noui to leave it out.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:dist
+var dist embed.FS
+
+// Dist returns the embedded frontend, or nil when the binary was built
+// without one
+func Dist() fs.FS {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		return nil
+	}
+	if _, err := fs.Stat(sub, "index.html"); err != nil {
+		return nil
+	}
+	return sub
+}
+
+// Enabled reports whether the server should serve the web UI at all
+func Enabled() bool {
+	return true
+}
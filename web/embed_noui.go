@@ -0,0 +1,15 @@
+//go:build noui
+
+package web
+
+import "io/fs"
+
+// Dist returns nil; headless builds carry no frontend
+func Dist() fs.FS {
+	return nil
+}
+
+// Enabled reports false; headless builds serve only the API
+func Enabled() bool {
+	return false
+}
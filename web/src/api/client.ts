@@ -2,24 +2,36 @@ import { apiUrl } from '@/lib/config'
 import { clearStoredToken, getAuthToken } from '@/lib/auth'
 import type {
   APIError,
+  APIErrorDetail,
   AgentInfo,
+  AuthPrompt,
+  CreateKeyRequest,
   CreateTunnelRequest,
   HealthResponse,
+  IdentitiesResponse,
+  InstallKeyRequest,
+  InstallKeyResponse,
   LoginRequest,
   LoginResponse,
   LogsResponse,
+  ManagedKey,
+  PortCheckResponse,
+  Session,
   Tunnel,
   TunnelMetrics,
+  TunnelStatusDetail,
 } from '@/api/types'
 
 export class APIClientError extends Error {
   status: number
   code?: string
+  details: APIErrorDetail[]
 
-  constructor(status: number, message: string, code?: string) {
+  constructor(status: number, message: string, code?: string, details: APIErrorDetail[] = []) {
     super(message)
     this.status = status
     this.code = code
+    this.details = details
   }
 }
 
@@ -28,7 +40,8 @@ async function parseError(response: Response): Promise<APIClientError> {
   return new APIClientError(
     response.status,
     body.message || response.statusText,
-    body.code
+    body.code,
+    body.details
   )
 }
 
@@ -53,8 +66,15 @@ export class LazytunnelClient {
     let response = await fetch(apiUrl(path), { ...options, headers })
 
     if (response.status === 401 && auth) {
-      clearStoredToken()
-      const token = await getAuthToken()
+      const { code } = (await response
+        .clone()
+        .json()
+        .catch(() => ({}))) as APIError
+      if (code === 'TOKEN_EXPIRED' || code === 'TOKEN_INVALID') {
+        clearStoredToken()
+      }
+      // Only an expired token is worth retrying with a fresh one
+      const token = code === 'TOKEN_EXPIRED' ? await getAuthToken() : null
       if (token) {
         headers.Authorization = `Bearer ${token}`
         response = await fetch(apiUrl(path), { ...options, headers })
@@ -84,6 +104,48 @@ export class LazytunnelClient {
     )
   }
 
+  listSessions(): Promise<Session[]> {
+    return this.request<Session[]>('/auth/sessions')
+  }
+
+  revokeSession(id: string): Promise<void> {
+    return this.request<void>(`/auth/sessions/${id}`, { method: 'DELETE' })
+  }
+
+  listIdentities(): Promise<IdentitiesResponse> {
+    return this.request<IdentitiesResponse>('/identities')
+  }
+
+  listKeys(): Promise<ManagedKey[]> {
+    return this.request<ManagedKey[]>('/keys')
+  }
+
+  createKey(body: CreateKeyRequest): Promise<ManagedKey> {
+    return this.request<ManagedKey>('/keys', { method: 'POST', body: JSON.stringify(body) })
+  }
+
+  rotateKey(id: string, body: Omit<CreateKeyRequest, 'name'> = {}): Promise<ManagedKey> {
+    return this.request<ManagedKey>(`/keys/${id}/rotate`, {
+      method: 'POST',
+      body: JSON.stringify(body),
+    })
+  }
+
+  finishKeyRotation(id: string): Promise<ManagedKey> {
+    return this.request<ManagedKey>(`/keys/${id}/rotate/finish`, { method: 'POST' })
+  }
+
+  deleteKey(id: string): Promise<void> {
+    return this.request<void>(`/keys/${id}`, { method: 'DELETE' })
+  }
+
+  installKey(id: string, body: InstallKeyRequest): Promise<InstallKeyResponse> {
+    return this.request<InstallKeyResponse>(`/keys/${id}/install`, {
+      method: 'POST',
+      body: JSON.stringify(body),
+    })
+  }
+
   listTunnels(): Promise<Tunnel[]> {
     return this.request<Tunnel[]>('/tunnels')
   }
@@ -111,10 +173,37 @@ export class LazytunnelClient {
     return this.request<Tunnel>(`/tunnels/${id}/stop`, { method: 'POST' })
   }
 
+  reconnectTunnel(id: string): Promise<Tunnel> {
+    return this.request<Tunnel>(`/tunnels/${id}/reconnect`, { method: 'POST' })
+  }
+
+  getTunnelStatus(id: string): Promise<TunnelStatusDetail> {
+    return this.request<TunnelStatusDetail>(`/tunnels/${id}/status`)
+  }
+
   getTunnelMetrics(id: string): Promise<TunnelMetrics> {
     return this.request<TunnelMetrics>(`/tunnels/${id}/metrics`)
   }
 
+  listPrompts(): Promise<AuthPrompt[]> {
+    return this.request<AuthPrompt[]>('/prompts')
+  }
+
+  answerPrompt(id: string, answers: string[]): Promise<void> {
+    return this.request<void>(`/prompts/${id}`, {
+      method: 'POST',
+      body: JSON.stringify({ answers }),
+    })
+  }
+
+  checkPort(port: number, bind?: string, suggest = true): Promise<PortCheckResponse> {
+    const params = new URLSearchParams({ port: String(port), suggest: String(suggest) })
+    if (bind) {
+      params.set('bind', bind)
+    }
+    return this.request<PortCheckResponse>(`/ports/check?${params}`)
+  }
+
   getLogs(lines = 200): Promise<LogsResponse> {
     return this.request<LogsResponse>(`/logs?lines=${lines}`)
   }
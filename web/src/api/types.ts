@@ -1,35 +1,169 @@
 /** API types aligned with api/openapi.yaml */
 
 export type TunnelType = 'local' | 'remote' | 'dynamic'
-export type TunnelStatus = 'active' | 'connecting' | 'disconnected' | 'failed' | 'stopped'
+export type TunnelStatus = 'active' | 'connecting' | 'disconnected' | 'failed' | 'stopped' | 'misconfigured'
 
 export interface Hop {
+  /** Hostname or IP, or @name for an address book entry */
   host: string
   port: number
   user: string
   auth_method: 'key' | 'password' | 'agent' | 'cert'
   key_id?: string
+  forward_agent?: boolean
+  keyboard_interactive?: boolean
+  via?: string // first hop only: tunnel to reach the hop through
+  attach?: string // first hop only: tunnel whose connection to the hop is reused
+  transport?: 'tcp' | 'wss' | 'auto' // first hop only: how the hop is reached
+  relay_url?: string // WebSocket relay for the wss and auto transports
+}
+
+// What a dynamic tunnel's proxy.pac sends through its SOCKS proxy
+export interface PACPolicy {
+  domains?: string[] // each also matches its subdomains
+  cidrs?: string[] // IPv4 networks, matched against hosts given as addresses
+}
+
+export interface StalePolicy {
+  after: number // seconds without traffic; 0 disables
+  action?: 'notify' | 'restart' | 'stop'
+}
+
+export interface RestartPolicy {
+  mode: 'always' | 'on-failure' | 'never'
+  maxPerHour?: number // 0 = default (10)
+  backoff?: number // seconds; 0 = default (1)
+}
+
+export interface BackoffPolicy {
+  initial?: number // milliseconds before the first retry; 0 = default (1000)
+  max?: number // longest delay in milliseconds; 0 = default (60000)
+  multiplier?: number // 0 = default (2)
+  jitter?: number // fraction of each delay randomized either way, up to 1; 0 = default (0.2), -1 = none
+}
+
+export interface Hook {
+  command?: string // requires the admin role
+  url?: string
+  timeout?: number // seconds; 0 = default (30)
+}
+
+export interface Hooks {
+  onConnect?: Hook[]
+  onDisconnect?: Hook[]
+  onFailure?: Hook[]
+}
+
+// A cached lookup of a hop hostname (GET /system/dns)
+export interface DNSCacheEntry {
+  host: string
+  addrs: string[]
+  resolvedAt?: string
+  stale: boolean // past the cache TTL, used while lookups fail
+  error?: string
+  failedAt?: string
+}
+
+// Restricts a tunnel's local socket to one IP version; unset = any
+export type AddressFamily = 'ipv4' | 'ipv6'
+
+export interface BalancePolicy {
+  strategy?: 'round-robin' | 'least-connections'
+  healthCheckInterval?: number // seconds; 0 = default (10)
+}
+
+export interface PortMapping {
+  name?: string
+  localPort: number // 0 = OS-assigned
+  remoteHost: string
+  remotePort: number
+}
+
+export interface PortStatus extends PortMapping {
+  boundAddress?: string
+  bytesSent: number
+  bytesReceived: number
+  connections: number
+  activeConns: number
+  errors: number
+}
+
+// Category of a tunnel's, hop's or target's last error
+export type FailureReason =
+  | 'auth_failed'
+  | 'host_unreachable'
+  | 'dns_not_found'
+  | 'dns_unavailable'
+  | 'host_key_mismatch'
+  | 'port_in_use'
+  | 'target_unreachable'
+  | 'circuit_open'
+  | 'listener_broken'
+  | 'unknown'
+
+export interface TargetStatus {
+  address: string
+  healthy: boolean
+  activeConns: number
+  connections: number
+  failures: number
+  lastError?: string
+  failureReason?: FailureReason
 }
 
 export interface Tunnel {
   id: string
   name: string
   owner: string
+  project: string
   agentId?: string
   desiredStatus?: string
   type: TunnelType
   hops: Hop[]
   localPort: number
+  localBindAddress?: string
+  localFamily?: AddressFamily
   remoteHost: string
   remotePort: number
+  targets?: string[] | null
+  balance?: BalancePolicy
+  ports?: PortMapping[] | null
+  systemProxy?: boolean
+  pac?: PACPolicy
   autoReconnect: boolean
   keepAlive: number
+  keepAliveMax?: number
+  drainTimeout?: number
   maxRetries: number
+  backoff?: BackoffPolicy
   status: TunnelStatus
   createdAt: string
   updatedAt: string
+  deletedAt?: string | null
   lastConnected?: string
   errorMessage?: string
+  failureReason?: FailureReason
+  problems?: string[] // why a misconfigured tunnel can't start
+  boundAddress?: string
+  boundPort?: number
+  staleness?: StalePolicy
+  lastActivity?: string | null
+  staleSeconds?: number
+  stale?: boolean
+  restart?: RestartPolicy
+  hooks?: Hooks
+  dependsOn?: string[]
+  dependencies?: { name: string; id: string; status: TunnelStatus | 'missing' }[]
+  dependenciesReady?: boolean
+  restarts?: { count: number; lastHour: number; lastRestartAt: string | null } | null
+  nextRetryAt?: string | null // when a scheduled reconnect or restart is due
+  retryAttempt?: number
+  maxRetryAttempts?: number
+  targetStatus?: TargetStatus[] | null
+  portStatus?: PortStatus[] | null
+  publicUrl?: string
+  forceClosed?: number // connections closed without draining when last force-stopped
+  interpolated?: Record<string, string>
 }
 
 export interface CreateTunnelRequest {
@@ -37,11 +171,101 @@ export interface CreateTunnelRequest {
   type: TunnelType
   hops: Hop[]
   localPort: number
+  localBindAddress?: string // IP address, [IPv6 address] or localhost
+  localFamily?: AddressFamily
   remoteHost: string
   remotePort: number
+  targets?: string[]
+  balance?: BalancePolicy
+  ports?: PortMapping[]
+  systemProxy?: boolean // dynamic tunnels only: become the OS SOCKS proxy while running
+  pac?: PACPolicy // dynamic tunnels only: served as /tunnels/{id}/proxy.pac
   autoReconnect?: boolean
   keepAlive?: number
+  keepAliveMax?: number // unanswered keep-alives in a row before reconnecting; default 3
+  drainTimeout?: number // seconds stopping waits for active connections; default 10
   maxRetries?: number
+  backoff?: BackoffPolicy
+  staleness?: StalePolicy
+  restart?: RestartPolicy
+  hooks?: Hooks
+  dependsOn?: string[] // names of tunnels to wait for
+  interpolated?: Record<string, string>
+}
+
+// Raw status from GET /tunnels/{id}/status (snake_case, unlike Tunnel)
+export interface TunnelStatusDetail {
+  tunnel_id: string
+  state: 'pending' | 'active' | 'failed' | 'stopped' | 'misconfigured'
+  connected_at?: string
+  last_error?: string
+  failure_reason?: FailureReason
+  bytes_sent: number
+  bytes_received: number
+  latency: number
+  retry_count: number
+  bound_address?: string
+  bound_port?: number
+  last_activity?: string
+  stale_seconds: number
+  stale?: boolean
+  restart_count: number
+  restarts_last_hour: number
+  last_restart_at?: string
+  next_retry_at?: string
+  retry_attempt?: number
+  max_retry_attempts?: number
+  targets?: {
+    address: string
+    healthy: boolean
+    active_conns: number
+    connections: number
+    failures: number
+    last_error?: string
+    failure_reason?: FailureReason
+  }[]
+  ports?: {
+    name?: string
+    local_port: number
+    remote_host: string
+    remote_port: number
+    bound_address?: string
+    bytes_sent: number
+    bytes_received: number
+    connections: number
+    active_conns: number
+    errors: number
+  }[]
+  hops?: HopStatus[]
+}
+
+export interface HopStatus {
+  host: string
+  port: number
+  user: string
+  state?: 'disconnected' | 'connecting' | 'connected' | 'reconnecting' | 'closed'
+  connected: boolean
+  connected_at?: string
+  last_error?: string
+  failure_reason?: FailureReason
+  latency: number
+  missed_keep_alives?: number
+  retry_count: number
+  next_retry_at?: string
+  forward_agent?: boolean
+  server_version?: string
+  banner?: string
+}
+
+export interface AuthPrompt {
+  id: string
+  tunnelId: string
+  host: string
+  user: string
+  instruction: string
+  questions: { text: string; echo: boolean }[]
+  createdAt: string
+  expiresAt: string
 }
 
 export interface TunnelMetrics {
@@ -51,12 +275,30 @@ export interface TunnelMetrics {
   connectionsActive: number
   uptime: number
   lastHeartbeat: string
+  goroutines: number
+  openSockets: number
+  bufferBytes: number
+  sshConnections: number
+  lastActivity: string | null
+  staleSeconds: number
+  stale: boolean
+  statsSeq: number
+}
+
+export interface PortCheckResponse {
+  port: number
+  bind: string
+  protocol: 'tcp' | 'udp'
+  available: boolean
+  usedBy: { id: string; name: string }[]
+  suggestedPort?: number
 }
 
 export interface HealthResponse {
   status: string
   time: string
   version?: string
+  role?: 'standalone' | 'leader' | 'standby'
   tunnels?: {
     total: number
     active: number
@@ -75,9 +317,129 @@ export interface LoginResponse {
   expiresIn: number
 }
 
+export interface Session {
+  id: string
+  device: string
+  ip: string
+  issuedAt: string
+  expiresAt: string
+  current: boolean
+}
+
+/** A key hops can authenticate with; authMethod and keyId select it. */
+export interface Identity {
+  source: 'agent' | 'file' | 'managed'
+  authMethod: 'agent' | 'key'
+  keyId?: string
+  type?: string
+  fingerprint?: string
+  comment?: string
+  encrypted?: boolean
+  error?: string
+}
+
+export interface IdentitiesResponse {
+  identities: Identity[]
+  agentSocket?: string
+  agentError?: string
+}
+
+/** An SSH key kept encrypted by the server; hops use it as keyId. */
+export interface ManagedKey {
+  id: string
+  keyId: string
+  name: string
+  owner: string
+  type: string
+  fingerprint: string
+  publicKey: string
+  createdAt: string
+  rotatedAt?: string
+  /** The key pair a rotation in progress replaced */
+  previous?: {
+    type: string
+    fingerprint: string
+    publicKey: string
+  }
+  usedBy: string[]
+}
+
+/** A named host that hops and destinations reference as @name */
+export interface AddressBookEntry {
+  name: string
+  /** What tunnel specs write to use it, @name */
+  ref: string
+  host: string
+  port?: number
+  user?: string
+  auth_method?: 'key' | 'password' | 'agent' | 'cert'
+  key_id?: string
+  description?: string
+  owner: string
+  createdAt: string
+  updatedAt: string
+  usedBy: string[]
+}
+
+/** Uploads privateKey, or generates an ed25519 key without one */
+export interface CreateKeyRequest {
+  name: string
+  privateKey?: string
+  passphrase?: string
+}
+
+/** Installs a managed key on a tunnel's hop, or on host */
+export interface InstallKeyRequest {
+  tunnelId?: string
+  hop?: number
+  host?: string
+  port?: number
+  user?: string
+  hostKeyVerification?: 'strict' | 'prompt' | 'insecure'
+  password?: string
+  permitOpen?: string[]
+  permitListen?: string[]
+  from?: string[]
+}
+
+export interface InstallKeyResponse {
+  host: string
+  user: string
+  line: string
+  /** False if the key was already authorized */
+  added: boolean
+}
+
+export type ShareAccess = 'read' | 'control'
+
+export interface CreateShareRequest {
+  access?: ShareAccess
+  expiresIn?: string
+}
+
+export interface Share {
+  id: string
+  token: string
+  url: string
+  tunnelId: string
+  access: ShareAccess
+  expiresAt: string
+}
+
+export interface APIErrorDetail {
+  field?: string
+  value?: unknown
+  issue?: string
+  /** Stable code of a validation failure, e.g. VAL_PORT_RANGE */
+  code?: string
+  params?: Record<string, string>
+}
+
 export interface APIError {
   code?: string
   message?: string
+  details?: APIErrorDetail[]
+  request_id?: string
   timestamp?: string
 }
 
@@ -92,4 +454,45 @@ export interface AgentInfo {
   status: string
   last_seen: string
   tunnel_count?: number
-}
\ No newline at end of file
+}
+// Command protocol over /ws: commands mirror the REST endpoints and are
+// answered with an ack carrying the same id
+export const WS_PROTOCOL_VERSION = 1
+
+export type WebSocketCommandType =
+  | 'heartbeat'
+  | 'list'
+  | 'create'
+  | 'get'
+  | 'status'
+  | 'start'
+  | 'stop'
+  | 'delete'
+
+export interface WebSocketCommand {
+  id: string
+  type: WebSocketCommandType
+  version: number
+  // create: the CreateTunnelRequest; tunnel commands: { tunnelId }
+  payload?: unknown
+}
+
+export interface WebSocketHello {
+  protocolVersion: number
+  commands: WebSocketCommandType[]
+  updates: 'full' | 'batched'
+  // Reconnect with ?resume=clientId&lastSeq= to get the events missed meanwhile
+  clientId: string
+  resumed: boolean
+  resumeWindow: number
+  pingInterval: number
+}
+
+export interface WebSocketAck {
+  id: string
+  type: string
+  ok: boolean
+  status: number
+  result?: unknown
+  error?: APIError
+}
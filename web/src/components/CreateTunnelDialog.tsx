@@ -13,7 +13,9 @@ import {
 import { Button } from './ui/button'
 import { Input } from './ui/input'
 import { Label } from './ui/label'
-import { useCreateTunnel } from '@/lib/queries'
+import { IdentitySelect } from './IdentitySelect'
+import { useCreateTunnel, useIdentities } from '@/lib/queries'
+import { describeError } from '@/lib/validationMessages'
 import { Plus, Loader2 } from 'lucide-react'
 import type { TunnelType } from '@/types/tunnel'
 
@@ -48,6 +50,7 @@ export function CreateTunnelDialog() {
   const [tunnelType, setTunnelType] = useState<TunnelType>('local')
   const [useBastionHost, setUseBastionHost] = useState(false)
   const createTunnel = useCreateTunnel()
+  const { data: identities } = useIdentities(open)
 
   const handleOpenChange = (newOpen: boolean) => {
     console.log('🚇 Create Tunnel Dialog:', newOpen ? 'OPENING' : 'CLOSING')
@@ -59,6 +62,8 @@ export function CreateTunnelDialog() {
     handleSubmit,
     formState: { errors },
     reset,
+    setValue,
+    watch,
   } = useForm<TunnelFormData>({
     resolver: zodResolver(tunnelSchema),
     defaultValues: {
@@ -86,8 +91,9 @@ export function CreateTunnelDialog() {
           host: data.bastionHost,
           port: data.bastionPort || 22,
           user: data.bastionUser || data.sshUser,
-          auth_method: 'key' as const,  // Note: snake_case for backend
-          key_id: data.bastionIdentityFile,  // Note: snake_case for backend
+          // An empty key file authenticates with the SSH agent
+          auth_method: data.bastionIdentityFile ? ('key' as const) : ('agent' as const),  // Note: snake_case for backend
+          key_id: data.bastionIdentityFile || undefined,  // Note: snake_case for backend
         })
       }
 
@@ -97,16 +103,17 @@ export function CreateTunnelDialog() {
         host: data.sshHost,
         port: data.sshPort,
         user: data.sshUser,
-        auth_method: 'key' as const,  // Note: snake_case for backend
-        key_id: data.identityFile,  // Note: snake_case for backend
+        auth_method: data.identityFile ? ('key' as const) : ('agent' as const),  // Note: snake_case for backend
+        key_id: data.identityFile || undefined,  // Note: snake_case for backend
       })
 
       const payload = {
         name: data.name,
         type: data.type,
         localPort: data.localPort,
-        remoteHost: data.remoteHost || '',
-        remotePort: data.remotePort || 0,
+        // Dynamic tunnels have no fixed destination; the server rejects one
+        remoteHost: data.type === 'dynamic' ? '' : data.remoteHost || '',
+        remotePort: data.type === 'dynamic' ? 0 : data.remotePort || 0,
         hops,
         autoReconnect: data.autoReconnect,
         keepAlive: 30,
@@ -123,7 +130,7 @@ export function CreateTunnelDialog() {
       reset()
     } catch (error) {
       console.error('❌ Failed to create tunnel:', error)
-      alert(`Failed to create tunnel: ${error instanceof Error ? error.message : 'Unknown error'}`)
+      alert(`Failed to create tunnel: ${describeError(error)}`)
     }
   }
 
@@ -297,14 +304,16 @@ export function CreateTunnelDialog() {
             </div>
 
             <div className="space-y-2">
-              <Label htmlFor="identityFile">Identity File (Optional)</Label>
-              <Input
+              <Label htmlFor="identityFile">Identity</Label>
+              <IdentitySelect
                 id="identityFile"
-                placeholder="~/.ssh/id_rsa or leave empty for SSH agent"
-                {...register('identityFile')}
+                identities={identities?.identities ?? []}
+                value={watch('identityFile') ?? ''}
+                onChange={(keyId) => setValue('identityFile', keyId)}
               />
               <p className="text-xs text-muted-foreground">
-                Path to SSH private key. Leave empty to use SSH agent.
+                The server's SSH agent, or one of its private key files.
+                {identities?.agentError && ` SSH agent unavailable: ${identities.agentError}`}
               </p>
             </div>
           </div>
@@ -361,11 +370,12 @@ export function CreateTunnelDialog() {
                 </div>
 
                 <div className="space-y-2">
-                  <Label htmlFor="bastionIdentityFile">Identity File (Optional)</Label>
-                  <Input
+                  <Label htmlFor="bastionIdentityFile">Identity</Label>
+                  <IdentitySelect
                     id="bastionIdentityFile"
-                    placeholder="~/.ssh/bastion_key"
-                    {...register('bastionIdentityFile')}
+                    identities={identities?.identities ?? []}
+                    value={watch('bastionIdentityFile') ?? ''}
+                    onChange={(keyId) => setValue('bastionIdentityFile', keyId)}
                   />
                 </div>
               </div>
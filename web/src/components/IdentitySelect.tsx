@@ -0,0 +1,76 @@
+import { useState } from 'react'
+import { Input } from './ui/input'
+import type { Identity } from '@/api/types'
+
+const OTHER = '__other__'
+
+interface IdentitySelectProps {
+  id: string
+  identities: Identity[]
+  /** Key file path; empty authenticates with the SSH agent */
+  value: string
+  onChange: (keyId: string) => void
+}
+
+function describe(identity: Identity): string {
+  const name =
+    identity.source === 'file' ? identity.keyId : identity.comment || `${identity.source} key`
+  const details = [identity.type, identity.fingerprint].filter(Boolean).join(' ')
+  const notes = [
+    identity.source === 'file' && identity.comment,
+    identity.source === 'managed' && 'managed',
+    identity.encrypted && 'passphrase',
+    identity.error,
+  ].filter(Boolean)
+  return [name, details && `— ${details}`, notes.length > 0 && `(${notes.join(', ')})`]
+    .filter(Boolean)
+    .join(' ')
+}
+
+/**
+ * Picks the SSH agent, one of the server's key files or one of its managed
+ * keys, with a free-text path for keys the server doesn't list.
+ */
+export function IdentitySelect({ id, identities, value, onChange }: IdentitySelectProps) {
+  const agentKeys = identities.filter((identity) => identity.source === 'agent')
+  const keyFiles = identities.filter((identity) => identity.source !== 'agent')
+  const listed = value === '' || keyFiles.some((identity) => identity.keyId === value)
+  const [other, setOther] = useState(!listed)
+
+  return (
+    <div className="space-y-2">
+      <select
+        id={id}
+        className="flex h-10 w-full rounded-md border border-input bg-background px-3 py-2 text-sm ring-offset-background focus-visible:outline-none focus-visible:ring-2 focus-visible:ring-ring"
+        value={other ? OTHER : value}
+        onChange={(e) => {
+          const selected = e.target.value
+          setOther(selected === OTHER)
+          onChange(selected === OTHER ? '' : selected)
+        }}
+      >
+        <option value="">
+          SSH agent{agentKeys.length > 0 ? ` (${agentKeys.length} key${agentKeys.length === 1 ? '' : 's'})` : ''}
+        </option>
+        {agentKeys.map((identity) => (
+          <option key={identity.fingerprint} value="" disabled>
+            · {describe(identity)}
+          </option>
+        ))}
+        {keyFiles.map((identity) => (
+          <option key={identity.keyId} value={identity.keyId} disabled={Boolean(identity.error)}>
+            {describe(identity)}
+          </option>
+        ))}
+        <option value={OTHER}>Other key file…</option>
+      </select>
+      {other && (
+        <Input
+          placeholder="~/.ssh/id_ed25519"
+          value={value}
+          onChange={(e) => onChange(e.target.value)}
+        />
+      )}
+    </div>
+  )
+}
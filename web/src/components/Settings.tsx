@@ -1,6 +1,8 @@
 import { useState } from 'react'
 import { useQuery } from '@tanstack/react-query'
 import { api } from '@/api/client'
+import { useRevokeSession, useSessions } from '@/lib/queries'
+import { useAuthStore } from '@/store/authStore'
 import { useSettingsStore } from '@/store/settingsStore'
 import { useThemeStore } from '@/store/themeStore'
 import { useTunnelStore } from '@/store/tunnelStore'
@@ -23,6 +25,11 @@ export function Settings() {
     refetchInterval: 10000,
   })
 
+  const isAuthenticated = useAuthStore((s) => s.isAuthenticated)
+  const showSessions = isAuthenticated && !isDemoMode
+  const { data: sessions = [] } = useSessions(showSessions)
+  const revokeSession = useRevokeSession()
+
   const set = <K extends keyof typeof settings>(key: K, value: (typeof settings)[K]) => {
     setLocal((p) => ({ ...p, [key]: value }))
     setDirty(true)
@@ -72,6 +79,37 @@ export function Settings() {
         )}
       </section>
 
+      {showSessions && (
+        <section className="mb-10 space-y-3">
+          <p className="text-xs uppercase tracking-wider text-muted-foreground">Sessions</p>
+          <ul className="divide-y divide-border border-t border-border text-sm">
+            {sessions.map((session) => (
+              <li key={session.id} className="flex items-center justify-between gap-4 py-2">
+                <div className="min-w-0">
+                  <p className="truncate">{session.device || 'Unknown device'}</p>
+                  <p className="font-mono text-xs text-muted-foreground">
+                    {session.ip} · signed in {new Date(session.issuedAt).toLocaleString()} ·
+                    expires {new Date(session.expiresAt).toLocaleString()}
+                  </p>
+                </div>
+                {session.current ? (
+                  <span className="text-xs text-muted-foreground">This session</span>
+                ) : (
+                  <Button
+                    variant="outline"
+                    size="sm"
+                    disabled={revokeSession.isPending}
+                    onClick={() => revokeSession.mutate(session.id)}
+                  >
+                    Revoke
+                  </Button>
+                )}
+              </li>
+            ))}
+          </ul>
+        </section>
+      )}
+
       <section className="space-y-6 border-t border-border pt-8">
         <div className="space-y-1.5">
           <Label className="text-xs text-muted-foreground">API base URL</Label>
@@ -1,5 +1,5 @@
-import { useState } from 'react'
-import { useTunnels, useStartTunnel, useStopTunnel, useDeleteTunnel } from '@/lib/queries'
+import { useEffect, useState } from 'react'
+import { useTunnels, useStartTunnel, useStopTunnel, useReconnectTunnel, useDeleteTunnel } from '@/lib/queries'
 import { useTunnelStore } from '@/store/tunnelStore'
 import { PageHeader } from './PageHeader'
 import { Badge } from './ui/badge'
@@ -8,6 +8,7 @@ import type { Tunnel, TunnelStatus } from '@/api/types'
 import { Play, Square, Trash2, Loader2, ArrowRight } from 'lucide-react'
 import { cn } from '@/lib/utils'
 import { getTunnelBrowseUrl } from '@/lib/tunnelUrl'
+import { getFailureHint } from '@/lib/failureHints'
 
 export function TunnelList() {
   const { isLoading, error } = useTunnels()
@@ -15,6 +16,7 @@ export function TunnelList() {
   const isDemoMode = useTunnelStore((s) => s.isDemoMode)
   const startTunnel = useStartTunnel()
   const stopTunnel = useStopTunnel()
+  const reconnectTunnel = useReconnectTunnel()
   const deleteTunnel = useDeleteTunnel()
   const [busy, setBusy] = useState<string | null>(null)
 
@@ -70,6 +72,10 @@ export function TunnelList() {
                 setBusy(tunnel.id)
                 stopTunnel.mutate(tunnel.id, { onSettled: () => setBusy(null) })
               }}
+              onReconnect={() => {
+                setBusy(tunnel.id)
+                reconnectTunnel.mutate(tunnel.id, { onSettled: () => setBusy(null) })
+              }}
               onDelete={() => {
                 setBusy(tunnel.id)
                 deleteTunnel.mutate(tunnel.id, { onSettled: () => setBusy(null) })
@@ -81,17 +87,44 @@ export function TunnelList() {
   )
 }
 
+/** Seconds until a scheduled reconnect or restart, ticking every second. */
+function RetryCountdown({
+  at,
+  attempt,
+  maxAttempts,
+}: {
+  at: string
+  attempt?: number
+  maxAttempts?: number
+}) {
+  const [now, setNow] = useState(() => Date.now())
+  useEffect(() => {
+    const timer = setInterval(() => setNow(Date.now()), 1000)
+    return () => clearInterval(timer)
+  }, [])
+
+  const seconds = Math.ceil((new Date(at).getTime() - now) / 1000)
+  const of = attempt && maxAttempts ? ` ${attempt}/${maxAttempts}` : ''
+  return (
+    <span className="font-mono text-xs text-muted-foreground">
+      {seconds > 0 ? `retry${of} in ${seconds}s` : `retrying${of}…`}
+    </span>
+  )
+}
+
 function TunnelRow({
   tunnel,
   busy,
   onStart,
   onStop,
+  onReconnect,
   onDelete,
 }: {
   tunnel: Tunnel
   busy: boolean
   onStart: () => void
   onStop: () => void
+  onReconnect: () => void
   onDelete: () => void
 }) {
   const status = statusLabel(tunnel.status)
@@ -114,6 +147,23 @@ function TunnelRow({
           >
             {status.label}
           </Badge>
+          {tunnel.nextRetryAt && (
+            <RetryCountdown
+              at={tunnel.nextRetryAt}
+              attempt={tunnel.retryAttempt}
+              maxAttempts={tunnel.maxRetryAttempts}
+            />
+          )}
+          {tunnel.nextRetryAt && (
+            <button
+              type="button"
+              onClick={onReconnect}
+              disabled={busy}
+              className="text-xs text-muted-foreground underline-offset-2 hover:text-foreground hover:underline disabled:opacity-50"
+            >
+              retry now
+            </button>
+          )}
         </div>
         <p className="mt-2 flex flex-wrap items-center gap-2 font-mono text-xs text-muted-foreground">
           <span>:{tunnel.localPort}</span>
@@ -143,6 +193,9 @@ function TunnelRow({
         {tunnel.errorMessage && (
           <p className="mt-2 text-xs text-destructive">{tunnel.errorMessage}</p>
         )}
+        {tunnel.errorMessage && getFailureHint(tunnel.failureReason) && (
+          <p className="mt-1 text-xs text-muted-foreground">{getFailureHint(tunnel.failureReason)}</p>
+        )}
       </div>
 
       <div className="flex shrink-0 gap-2">
@@ -177,6 +230,8 @@ function statusLabel(status: TunnelStatus) {
       return { label: 'connecting', variant: 'warning' as const }
     case 'failed':
       return { label: 'failed', variant: 'destructive' as const }
+    case 'misconfigured':
+      return { label: 'misconfigured', variant: 'destructive' as const }
     default:
       return { label: status, variant: 'secondary' as const }
   }
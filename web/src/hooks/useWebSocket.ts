@@ -1,26 +1,51 @@
 import { useEffect, useRef, useCallback } from 'react'
+import { useQueryClient } from '@tanstack/react-query'
 import { useTunnelStore } from '@/store/tunnelStore'
 import { useConnectionStore } from '@/store/connectionStore'
 import { useAuthStore } from '@/store/authStore'
 import { getAuthToken } from '@/lib/auth'
 import { wsUrl } from '@/lib/config'
-import type { TunnelStatus } from '@/api/types'
+import { tunnelKeys } from '@/lib/queries'
+import type { FailureReason, Tunnel, TunnelStatus, WebSocketHello } from '@/api/types'
 
 interface WebSocketMessage {
   type: string
+  seq?: number
   payload: {
     tunnelId: string
     status: {
       state: string
-      lastError?: string
+      last_error?: string
+      failure_reason?: FailureReason
+      problems?: string[]
+      next_retry_at?: string
+      retry_attempt?: number
+      max_retry_attempts?: number
     }
+    // The tunnel as the REST API returns it; absent once deleted
+    tunnel?: Tunnel
   }
 }
 
+// One tunnel's entry in a tunnel_updates message: the top-level fields that
+// changed since the last one, null for those that are gone
+interface TunnelDiff {
+  tunnelId: string
+  full?: boolean
+  deleted?: boolean
+  status?: Record<string, unknown>
+  tunnel?: Record<string, unknown>
+}
+
 export function useWebSocket() {
   const wsRef = useRef<WebSocket | null>(null)
   const reconnectRef = useRef<ReturnType<typeof setTimeout> | null>(null)
+  // What to resume with after reconnecting
+  const clientIdRef = useRef<string | null>(null)
+  const lastSeqRef = useRef(0)
+  const queryClient = useQueryClient()
   const updateTunnel = useTunnelStore((s) => s.updateTunnel)
+  const removeTunnel = useTunnelStore((s) => s.removeTunnel)
   const setWsConnected = useConnectionStore((s) => s.setWsConnected)
   const isAuthenticated = useAuthStore((s) => s.isAuthenticated)
   const isDemoMode = useTunnelStore((s) => s.isDemoMode)
@@ -52,6 +77,11 @@ export function useWebSocket() {
 
     let url = wsUrl('/ws')
     url += `?token=${encodeURIComponent(token)}`
+    // Coalesced diffs rather than a full message per change
+    url += '&updates=batched'
+    if (clientIdRef.current) {
+      url += `&resume=${encodeURIComponent(clientIdRef.current)}&lastSeq=${lastSeqRef.current}`
+    }
 
     const socket = new WebSocket(url)
     wsRef.current = socket
@@ -61,12 +91,42 @@ export function useWebSocket() {
     socket.onmessage = (event) => {
       try {
         const message: WebSocketMessage = JSON.parse(event.data)
-        if (message.type === 'tunnel_update') {
-          const { tunnelId, status } = message.payload
-          updateTunnel(tunnelId, {
-            status: mapTunnelState(status.state),
-            errorMessage: status.lastError || undefined,
-          })
+        if (message.seq) {
+          lastSeqRef.current = message.seq
+        }
+        if (message.type === 'hello') {
+          const hello = message.payload as unknown as WebSocketHello
+          // Events were missed and can't be replayed: start over from REST
+          if (clientIdRef.current && !hello.resumed) {
+            void queryClient.invalidateQueries({ queryKey: tunnelKeys.lists() })
+          }
+          if (!hello.resumed) {
+            lastSeqRef.current = 0
+          }
+          clientIdRef.current = hello.clientId
+        } else if (message.type === 'tunnel_updates') {
+          const { updates } = message.payload as unknown as { updates: TunnelDiff[] }
+          for (const diff of updates) {
+            if (diff.deleted) {
+              removeTunnel(diff.tunnelId)
+            } else if (diff.tunnel) {
+              updateTunnel(diff.tunnelId, withoutNulls(diff.tunnel))
+            }
+          }
+        } else if (message.type === 'tunnel_update') {
+          const { tunnelId, status, tunnel } = message.payload
+          updateTunnel(
+            tunnelId,
+            tunnel ?? {
+              status: mapTunnelState(status.state),
+              errorMessage: status.last_error || undefined,
+              failureReason: status.failure_reason,
+              problems: status.problems,
+              nextRetryAt: status.next_retry_at ?? null,
+              retryAttempt: status.retry_attempt,
+              maxRetryAttempts: status.max_retry_attempts,
+            },
+          )
         }
       } catch {
         /* ignore malformed frames */
@@ -84,7 +144,7 @@ export function useWebSocket() {
     }
 
     socket.onerror = () => setWsConnected(false)
-  }, [disconnect, isAuthenticated, isDemoMode, setWsConnected, updateTunnel])
+  }, [disconnect, isAuthenticated, isDemoMode, queryClient, removeTunnel, setWsConnected, updateTunnel])
 
   useEffect(() => {
     void connect()
@@ -102,9 +162,19 @@ function mapTunnelState(state: string): TunnelStatus {
       return 'connecting'
     case 'failed':
       return 'failed'
+    case 'misconfigured':
+      return 'misconfigured'
     case 'stopped':
       return 'disconnected'
     default:
       return 'disconnected'
   }
-}
\ No newline at end of file
+}
+// withoutNulls turns the fields a diff drops into undefined ones
+function withoutNulls(fields: Record<string, unknown>): Partial<Tunnel> {
+  const result: Record<string, unknown> = {}
+  for (const [key, value] of Object.entries(fields)) {
+    result[key] = value === null ? undefined : value
+  }
+  return result as Partial<Tunnel>
+}
@@ -125,6 +125,7 @@ export const DEMO_TUNNELS: TunnelSpec[] = [
     createdAt: new Date(Date.now() - 1000 * 60 * 60 * 48).toISOString(), // 2 days ago
     updatedAt: new Date(Date.now() - 1000 * 60 * 60 * 24).toISOString(),
     errorMessage: 'Connection timeout after 30s',
+    failureReason: 'host_unreachable',
   },
   {
     id: 'demo-6',
@@ -173,6 +174,7 @@ export const DEMO_TUNNELS: TunnelSpec[] = [
     createdAt: new Date(Date.now() - 1000 * 60 * 20).toISOString(),
     updatedAt: new Date(Date.now() - 1000 * 60 * 5).toISOString(),
     errorMessage: 'Authentication failed: invalid SSH key',
+    failureReason: 'auth_failed',
   },
   {
     id: 'demo-8',
@@ -0,0 +1,19 @@
+import type { FailureReason } from '@/api/types'
+
+const hints: Record<FailureReason, string> = {
+  auth_failed: 'Check the hop user and its key, password or agent.',
+  host_unreachable: 'Check the hop address and any firewall in between.',
+  dns_not_found: 'The hostname does not exist; check it for typos, or the DNS servers asked.',
+  dns_unavailable: 'DNS did not answer; the tunnel retries, using the last known address if there is one.',
+  host_key_mismatch: "The hop's host key is unknown or changed: verify it, then update known_hosts.",
+  port_in_use: 'Another process holds the port; pick another one or stop that process.',
+  target_unreachable: 'The hop cannot reach the destination; check its host and port from the hop.',
+  circuit_open: 'Connects are paused after repeated failures and resume shortly.',
+  listener_broken: 'The listening socket stopped accepting connections; restart the tunnel.',
+  unknown: '',
+}
+
+/** What to try for a tunnel or hop that failed for reason, if anything. */
+export function getFailureHint(reason?: FailureReason): string | null {
+  return (reason && hints[reason]) || null
+}
@@ -173,3 +173,65 @@ export function useStopTunnel() {
     },
   })
 }
+
+export function useReconnectTunnel() {
+  const queryClient = useQueryClient()
+  const updateTunnel = useTunnelStore((state) => state.updateTunnel)
+  const isDemoMode = useTunnelStore((state) => state.isDemoMode)
+  const tunnels = useTunnelStore((state) => state.tunnels)
+
+  return useMutation({
+    mutationFn: async (id: string) => {
+      if (isDemoMode) {
+        const tunnel = tunnels.find(t => t.id === id)
+        if (!tunnel) throw new Error('Tunnel not found')
+        return {
+          ...tunnel,
+          status: 'connecting' as const,
+          nextRetryAt: null,
+          retryAttempt: undefined,
+          maxRetryAttempts: undefined,
+          updatedAt: new Date().toISOString(),
+        }
+      }
+      return api.reconnectTunnel(id)
+    },
+    onSuccess: (data) => {
+      if (!isDemoMode) {
+        queryClient.invalidateQueries({ queryKey: tunnelKeys.detail(data.id) })
+      }
+      updateTunnel(data.id, data)
+    },
+  })
+}
+
+export function useIdentities(enabled: boolean) {
+  return useQuery({
+    queryKey: ['identities'],
+    queryFn: () => api.listIdentities(),
+    enabled,
+  })
+}
+
+export const sessionKeys = {
+  all: ['sessions'] as const,
+}
+
+export function useSessions(enabled: boolean) {
+  return useQuery({
+    queryKey: sessionKeys.all,
+    queryFn: () => api.listSessions(),
+    enabled,
+  })
+}
+
+export function useRevokeSession() {
+  const queryClient = useQueryClient()
+
+  return useMutation({
+    mutationFn: (id: string) => api.revokeSession(id),
+    onSuccess: () => {
+      queryClient.invalidateQueries({ queryKey: sessionKeys.all })
+    },
+  })
+}
@@ -0,0 +1,66 @@
+import { APIClientError } from '@/api/client'
+import type { APIErrorDetail } from '@/api/types'
+
+// Messages of validation failures by the stable code the server sends with
+// each one. {field}, {param} and {value} are replaced with the failure's
+// field and params.
+export type ValidationMessages = Record<string, string>
+
+const english: ValidationMessages = {
+  VAL_REQUIRED: '{field} is required',
+  VAL_REQUIRED_FOR_TYPE: '{field} is required for {param} tunnels',
+  VAL_UNSUPPORTED_FOR_TYPE: '{field} is not supported for {param} tunnels',
+  VAL_REQUIRED_FOR_TRANSPORT: '{field} is required for the {param} transport',
+  VAL_REQUIRED_WITHOUT: '{field} or {param} is required',
+  VAL_EXCLUDED_WITH: '{field} and {param} cannot both be set',
+  VAL_EMPTY: '{field} cannot be empty',
+  VAL_MIN: '{field} must be at least {param}',
+  VAL_MAX: '{field} must be at most {param}',
+  VAL_MIN_FIELD: '{field} must be at least {param}',
+  VAL_PORT_RANGE: '{field} must be a port number between 1 and 65535',
+  VAL_DUPLICATE_PORT: '{field} maps local port {param} more than once',
+  VAL_HOSTNAME: '{field} must be a valid hostname or IP address',
+  VAL_IP: '{field} must be a valid IP address',
+  VAL_ADDRESS_NAME: '{field} must start with a letter or digit and hold only letters, digits, dots, underscores and hyphens',
+  VAL_HOST_PORT: '{field} must be a host:port pair',
+  VAL_CIDR: '{field} must be a valid IPv4 CIDR (e.g. 10.0.0.0/8)',
+  VAL_WS_URL: '{field} must be a ws or wss URL',
+  VAL_HTTP_URL: '{field} must be an http or https URL',
+  VAL_SUBDOMAIN: '{field} must be a lowercase DNS label (letters, digits, hyphens)',
+  VAL_TUNNEL_TYPE: '{field} must be one of: {param}',
+  VAL_AUTH_METHOD: '{field} must be one of: {param}',
+  VAL_ONE_OF: '{field} must be one of: {param}',
+  VAL_FIRST_HOP_ONLY: '{field} is only allowed on the first hop',
+  VAL_DURATION: '{field} must be a positive duration of at most {param}',
+  VAL_PROJECT_NAME: 'Use lowercase letters, digits and dashes',
+}
+
+const catalogs: Record<string, ValidationMessages> = { en: english }
+
+/** Adds or replaces the messages of a language, e.g. 'de' or 'pt-BR'. */
+export function registerValidationMessages(locale: string, messages: ValidationMessages) {
+  catalogs[locale.toLowerCase()] = { ...catalogs[locale.toLowerCase()], ...messages }
+}
+
+/**
+ * The message of a validation failure in locale, falling back to its
+ * language, then English, then the server's own message.
+ */
+export function localizeValidationError(detail: APIErrorDetail, locale = navigator.language): string {
+  const tag = locale.toLowerCase()
+  const code = detail.code ?? ''
+  const template = catalogs[tag]?.[code] ?? catalogs[tag.split('-')[0]]?.[code] ?? english[code]
+  if (!template) {
+    return detail.issue || detail.field || 'Invalid value'
+  }
+  const values: Record<string, string> = { field: detail.field ?? '', ...detail.params }
+  return template.replace(/\{(\w+)\}/g, (match, name: string) => values[name] ?? match)
+}
+
+/** A request error for display, listing its validation failures if any. */
+export function describeError(error: unknown, locale?: string): string {
+  if (error instanceof APIClientError && error.details.length > 0) {
+    return error.details.map((detail) => localizeValidationError(detail, locale)).join('; ')
+  }
+  return error instanceof Error ? error.message : 'Unknown error'
+}